@@ -0,0 +1,187 @@
+// Package audit records the actions affecting a running cloudflared in a tamper-evident log.
+//
+// The log is a file of JSON lines, one Entry per action. Each entry holds the hash of the previous one and its own
+// hash, so that editing, removing or reordering entries breaks the chain, which Verify detects.
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// ActorLocal is the actor of actions taken from the host cloudflared runs on, e.g. with a signal or through
+	// the metrics server, or by cloudflared itself.
+	ActorLocal = "local"
+	// ActorRemote is the actor of configurations pushed from the Cloudflare dashboard or API.
+	ActorRemote = "remote"
+)
+
+const (
+	ActionConfigApply         = "config_apply"
+	ActionDrain               = "drain"
+	ActionReconnect           = "reconnect"
+	ActionPrepareReconnect    = "prepare_reconnect"
+	ActionMaintenanceOverride = "maintenance_override"
+	ActionLogStream           = "log_stream"
+	ActionAutoupdate          = "autoupdate"
+)
+
+const (
+	OutcomeSuccess = "success"
+	OutcomeFailure = "failure"
+)
+
+// Entry is a line of the audit log.
+type Entry struct {
+	Time    time.Time         `json:"time"`
+	Action  string            `json:"action"`
+	Actor   string            `json:"actor"`
+	Outcome string            `json:"outcome"`
+	Error   string            `json:"error,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+	// PrevHash is the Hash of the previous entry, empty for the first one
+	PrevHash string `json:"prevHash"`
+	// Hash is the hex encoded SHA-256 of the entry encoded without it
+	Hash string `json:"hash"`
+}
+
+func (e Entry) hash() (string, error) {
+	e.Hash = ""
+	encoded, err := json.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(encoded)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Log appends entries to an audit log file. A nil Log records nothing, so that callers don't need to check whether
+// auditing is enabled.
+type Log struct {
+	lock     sync.Mutex
+	file     *os.File
+	lastHash string
+	log      *zerolog.Logger
+}
+
+// Open opens the audit log at path to append entries to it, creating it if needed. The entries already in the file
+// are verified first, so that a tampered log isn't extended.
+func Open(path string, log *zerolog.Logger) (*Log, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open the audit log")
+	}
+	lastHash, _, err := verify(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, errors.Wrapf(err, "audit log %s failed verification, move it aside to start a new one", path)
+	}
+	return &Log{
+		file:     file,
+		lastHash: lastHash,
+		log:      log,
+	}, nil
+}
+
+// Record appends an entry for action, taken by actor, to the log. The outcome is a failure if err isn't nil.
+// Failing to write the entry is logged rather than returned, as it must not prevent the action.
+func (l *Log) Record(action, actor string, err error, details map[string]string) {
+	if l == nil {
+		return
+	}
+	entry := Entry{
+		Time:    time.Now().UTC(),
+		Action:  action,
+		Actor:   actor,
+		Outcome: OutcomeSuccess,
+		Details: details,
+	}
+	if err != nil {
+		entry.Outcome = OutcomeFailure
+		entry.Error = err.Error()
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if writeErr := l.append(entry); writeErr != nil {
+		l.log.Err(writeErr).Str("action", action).Msg("Failed to write to the audit log")
+	}
+}
+
+func (l *Log) append(entry Entry) error {
+	entry.PrevHash = l.lastHash
+	hash, err := entry.hash()
+	if err != nil {
+		return err
+	}
+	entry.Hash = hash
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	// Entries must survive a crash right after the action
+	if err := l.file.Sync(); err != nil {
+		return err
+	}
+	l.lastHash = hash
+	return nil
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.file.Close()
+}
+
+// Verify checks the hash chain of the audit log read from r, returning how many entries it holds. The error tells
+// the first line that doesn't match the chain.
+func Verify(r io.Reader) (int, error) {
+	_, entries, err := verify(r)
+	return entries, err
+}
+
+func verify(r io.Reader) (lastHash string, entries int, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return "", entries, fmt.Errorf("line %d isn't an audit log entry: %w", line, err)
+		}
+		if entry.PrevHash != lastHash {
+			return "", entries, fmt.Errorf("line %d doesn't follow the previous entry, entries were removed or reordered", line)
+		}
+		hash, err := entry.hash()
+		if err != nil {
+			return "", entries, err
+		}
+		if hash != entry.Hash {
+			return "", entries, fmt.Errorf("line %d doesn't match its hash, it was modified", line)
+		}
+		lastHash = entry.Hash
+		entries++
+	}
+	return lastHash, entries, scanner.Err()
+}
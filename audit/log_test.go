@@ -0,0 +1,106 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+var testLogger = zerolog.Nop()
+
+func TestRecordAndVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := Open(path, &testLogger)
+	require.NoError(t, err)
+	auditLog.Record(ActionConfigApply, ActorRemote, nil, map[string]string{"version": "3"})
+	auditLog.Record(ActionReconnect, ActorLocal, errors.New("invalid delay"), nil)
+	require.NoError(t, auditLog.Close())
+
+	// Reopening continues the chain
+	auditLog, err = Open(path, &testLogger)
+	require.NoError(t, err)
+	auditLog.Record(ActionDrain, ActorLocal, nil, map[string]string{"signal": "terminated"})
+	require.NoError(t, auditLog.Close())
+
+	lines := readLines(t, path)
+	require.Len(t, lines, 3)
+	entries, err := Verify(strings.NewReader(strings.Join(lines, "\n")))
+	require.NoError(t, err)
+	require.Equal(t, 3, entries)
+
+	require.Contains(t, lines[0], `"action":"config_apply","actor":"remote","outcome":"success"`)
+	require.Contains(t, lines[1], `"outcome":"failure","error":"invalid delay"`)
+}
+
+func TestVerifyDetectsTampering(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := Open(path, &testLogger)
+	require.NoError(t, err)
+	for _, action := range []string{ActionConfigApply, ActionReconnect, ActionAutoupdate} {
+		auditLog.Record(action, ActorLocal, nil, nil)
+	}
+	require.NoError(t, auditLog.Close())
+	lines := readLines(t, path)
+
+	tests := []struct {
+		name  string
+		lines []string
+	}{
+		{
+			name:  "modified",
+			lines: []string{lines[0], strings.Replace(lines[1], `"actor":"local"`, `"actor":"remote"`, 1), lines[2]},
+		},
+		{
+			name:  "removed",
+			lines: []string{lines[0], lines[2]},
+		},
+		{
+			name:  "reordered",
+			lines: []string{lines[0], lines[2], lines[1]},
+		},
+		{
+			name:  "not an entry",
+			lines: []string{lines[0], "garbage", lines[1]},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			entries, err := Verify(strings.NewReader(strings.Join(test.lines, "\n")))
+			require.ErrorContains(t, err, "line 2")
+			require.Equal(t, 1, entries)
+		})
+	}
+}
+
+func TestOpenTamperedLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := Open(path, &testLogger)
+	require.NoError(t, err)
+	auditLog.Record(ActionConfigApply, ActorRemote, nil, nil)
+	require.NoError(t, auditLog.Close())
+
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, bytes.Replace(content, []byte("remote"), []byte("local"), 1), 0o600))
+
+	_, err = Open(path, &testLogger)
+	require.Error(t, err)
+}
+
+func TestNilLog(t *testing.T) {
+	var auditLog *Log
+	auditLog.Record(ActionDrain, ActorLocal, nil, nil)
+	require.NoError(t, auditLog.Close())
+}
+
+func readLines(t *testing.T, path string) []string {
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+}
@@ -3,6 +3,8 @@ package client
 import (
 	"fmt"
 	"net"
+	"slices"
+	"strings"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
@@ -14,8 +16,11 @@ import (
 // Config captures the local client runtime configuration.
 type Config struct {
 	ConnectorID uuid.UUID
-	Version     string
-	Arch        string
+	// StableConnectorID identifies the connector across restarts, see LoadStableConnectorID. It is uuid.Nil if the
+	// connector doesn't keep one.
+	StableConnectorID uuid.UUID
+	Version           string
+	Arch              string
 
 	featureSelector features.FeatureSelector
 }
@@ -39,8 +44,10 @@ func NewConfig(version string, arch string, featureSelector features.FeatureSele
 // change, but they will not change within the scope of this struct.
 type ConnectionOptionsSnapshot struct {
 	client              pogs.ClientInfo
+	stableConnectorID   uuid.UUID
 	originLocalIP       net.IP
 	numPreviousAttempts uint8
+	compressionQuality  uint8
 	FeatureSnapshot     features.FeatureSnapshot
 }
 
@@ -53,22 +60,109 @@ func (c *Config) ConnectionOptionsSnapshot(originIP net.IP, previousAttempts uin
 			Arch:     c.Arch,
 			Features: snapshot.FeaturesList,
 		},
+		stableConnectorID:   c.StableConnectorID,
 		originLocalIP:       originIP,
 		numPreviousAttempts: previousAttempts,
 		FeatureSnapshot:     snapshot,
 	}
 }
 
+// WithCompression returns a copy of the snapshot that negotiates compressing the connection streams with the
+// given quality, advertising the compression algorithm as a client feature.
+func (c *ConnectionOptionsSnapshot) WithCompression(quality uint8, algorithmFeature string) *ConnectionOptionsSnapshot {
+	snapshot := *c
+	snapshot.compressionQuality = quality
+	snapshot.client.Features = append(slices.Clone(c.client.Features), algorithmFeature)
+	return &snapshot
+}
+
 func (c ConnectionOptionsSnapshot) ConnectionOptions() *pogs.ConnectionOptions {
 	return &pogs.ConnectionOptions{
 		Client:              c.client,
 		OriginLocalIP:       c.originLocalIP,
 		ReplaceExisting:     false,
-		CompressionQuality:  0,
+		CompressionQuality:  c.compressionQuality,
 		NumPreviousAttempts: c.numPreviousAttempts,
 	}
 }
 
+// ConnectionOptionsInfo is the content of a ConnectionOptionsSnapshot as sent to the edge when registering a
+// connection, exported to diagnose a feature negotiation that doesn't match the edge.
+type ConnectionOptionsInfo struct {
+	Features            []string                 `json:"features"`
+	DatagramVersion     features.DatagramVersion `json:"datagramVersion"`
+	PostQuantum         string                   `json:"postQuantum"`
+	NumPreviousAttempts uint8                    `json:"numPreviousAttempts"`
+	CompressionQuality  uint8                    `json:"compressionQuality,omitempty"`
+	OriginLocalIP       net.IP                   `json:"originLocalIP,omitempty"`
+	StableConnectorID   string                   `json:"stableConnectorID,omitempty"`
+}
+
+// Info returns the options of the snapshot in their exported form.
+func (c ConnectionOptionsSnapshot) Info() ConnectionOptionsInfo {
+	postQuantum := "prefer"
+	switch c.FeatureSnapshot.PostQuantum {
+	case features.PostQuantumStrict:
+		postQuantum = "strict"
+	case features.PostQuantumDisabled:
+		postQuantum = "disabled"
+	}
+	info := ConnectionOptionsInfo{
+		Features:            slices.Clone(c.client.Features),
+		DatagramVersion:     c.FeatureSnapshot.DatagramVersion,
+		PostQuantum:         postQuantum,
+		NumPreviousAttempts: c.numPreviousAttempts,
+		CompressionQuality:  c.compressionQuality,
+		OriginLocalIP:       c.originLocalIP,
+	}
+	if c.stableConnectorID != uuid.Nil {
+		info.StableConnectorID = c.stableConnectorID.String()
+	}
+	return info
+}
+
+// FeatureChange is a feature negotiated differently by two registrations of a connection.
+type FeatureChange struct {
+	Feature  string `json:"feature"`
+	Previous string `json:"previous"`
+	Current  string `json:"current"`
+}
+
+// Feature states of the client features in a FeatureChange
+const (
+	featureOn  = "on"
+	featureOff = "off"
+)
+
+// FeatureChanges returns the features negotiated differently than in the previous options, sorted by feature: the
+// datagram version, the post-quantum mode and each client feature that was added or removed.
+func (c ConnectionOptionsInfo) FeatureChanges(previous ConnectionOptionsInfo) []FeatureChange {
+	var changes []FeatureChange
+	if previous.DatagramVersion != c.DatagramVersion {
+		changes = append(changes, FeatureChange{"datagramVersion", string(previous.DatagramVersion), string(c.DatagramVersion)})
+	}
+	if previous.PostQuantum != c.PostQuantum {
+		changes = append(changes, FeatureChange{"postQuantum", previous.PostQuantum, c.PostQuantum})
+	}
+	for _, feature := range c.Features {
+		if !slices.Contains(previous.Features, feature) {
+			changes = append(changes, FeatureChange{feature, featureOff, featureOn})
+		}
+	}
+	for _, feature := range previous.Features {
+		if !slices.Contains(c.Features, feature) {
+			changes = append(changes, FeatureChange{feature, featureOn, featureOff})
+		}
+	}
+	slices.SortFunc(changes, func(a, b FeatureChange) int {
+		return strings.Compare(a.Feature, b.Feature)
+	})
+	return changes
+}
+
 func (c ConnectionOptionsSnapshot) LogFields(event *zerolog.Event) *zerolog.Event {
+	if c.stableConnectorID != uuid.Nil {
+		event = event.Str("stableConnectorID", c.stableConnectorID.String())
+	}
 	return event.Strs("features", c.client.Features)
 }
@@ -4,6 +4,7 @@ import (
 	"net"
 	"testing"
 
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 
 	"github.com/cloudflare/cloudflared/features"
@@ -48,3 +49,64 @@ func (m *mockFeatureSelector) Snapshot() features.FeatureSnapshot {
 		FeaturesList:    []string{features.FeaturePostQuantum, features.FeatureDatagramV3_2},
 	}
 }
+
+func TestConnectionOptionsWithCompression(t *testing.T) {
+	config, err := NewConfig("1234", "linux_amd64", &mockFeatureSelector{})
+	require.NoError(t, err)
+
+	connOptions := config.ConnectionOptionsSnapshot(nil, 0)
+	compressed := connOptions.WithCompression(2, "http2_compression_zstd")
+
+	require.Equal(t, uint8(2), compressed.ConnectionOptions().CompressionQuality)
+	require.Equal(t, []string{features.FeaturePostQuantum, features.FeatureDatagramV3_2, "http2_compression_zstd"}, compressed.ConnectionOptions().Client.Features)
+	// The original snapshot is left as is
+	require.Equal(t, uint8(0), connOptions.ConnectionOptions().CompressionQuality)
+	require.Equal(t, []string{features.FeaturePostQuantum, features.FeatureDatagramV3_2}, connOptions.ConnectionOptions().Client.Features)
+}
+
+func TestConnectionOptionsInfo(t *testing.T) {
+	config, err := NewConfig("1234", "linux_amd64", &mockFeatureSelector{})
+	require.NoError(t, err)
+
+	originIP := net.ParseIP("192.168.1.1")
+	connOptions := config.ConnectionOptionsSnapshot(originIP, 3).WithCompression(2, "http2_compression_zstd")
+	require.Equal(t, ConnectionOptionsInfo{
+		Features:            []string{features.FeaturePostQuantum, features.FeatureDatagramV3_2, "http2_compression_zstd"},
+		DatagramVersion:     features.DatagramV3,
+		PostQuantum:         "prefer",
+		NumPreviousAttempts: 3,
+		CompressionQuality:  2,
+		OriginLocalIP:       originIP,
+	}, connOptions.Info())
+}
+
+func TestConnectionOptionsStableConnectorID(t *testing.T) {
+	config, err := NewConfig("1234", "linux_amd64", &mockFeatureSelector{})
+	require.NoError(t, err)
+	require.Empty(t, config.ConnectionOptionsSnapshot(nil, 0).Info().StableConnectorID)
+
+	config.StableConnectorID = uuid.New()
+	info := config.ConnectionOptionsSnapshot(nil, 0).Info()
+	require.Equal(t, config.StableConnectorID.String(), info.StableConnectorID)
+	require.NotEqual(t, config.ConnectorID.String(), info.StableConnectorID)
+}
+
+func TestFeatureChanges(t *testing.T) {
+	previous := ConnectionOptionsInfo{
+		Features:        []string{features.FeaturePostQuantum, features.FeatureDatagramV3_2},
+		DatagramVersion: features.DatagramV3,
+		PostQuantum:     "prefer",
+	}
+	require.Empty(t, previous.FeatureChanges(previous))
+
+	current := ConnectionOptionsInfo{
+		Features:        []string{features.FeaturePostQuantum, features.FeatureDatagramV2},
+		DatagramVersion: features.DatagramV2,
+		PostQuantum:     "prefer",
+	}
+	require.Equal(t, []FeatureChange{
+		{Feature: "datagramVersion", Previous: string(features.DatagramV3), Current: string(features.DatagramV2)},
+		{Feature: features.FeatureDatagramV2, Previous: "off", Current: "on"},
+		{Feature: features.FeatureDatagramV3_2, Previous: "on", Current: "off"},
+	}, current.FeatureChanges(previous))
+}
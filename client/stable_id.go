@@ -0,0 +1,57 @@
+package client
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// LoadStableConnectorID returns the connector ID saved in the file at path, generating and saving one the first time.
+// Unlike ConnectorID, which is generated on every run, it stays the same across restarts so that the same connector
+// reconnecting can be recognized in logs and metrics.
+func LoadStableConnectorID(path string) (uuid.UUID, error) {
+	content, err := os.ReadFile(path)
+	if err == nil {
+		id, err := uuid.Parse(strings.TrimSpace(string(content)))
+		if err != nil {
+			return uuid.Nil, fmt.Errorf("invalid connector ID in %s: %w", path, err)
+		}
+		return id, nil
+	}
+	if !os.IsNotExist(err) {
+		return uuid.Nil, fmt.Errorf("unable to read the connector ID from %s: %w", path, err)
+	}
+
+	id, err := uuid.NewRandom()
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("unable to generate a connector UUID: %w", err)
+	}
+	if err := writeStableConnectorID(path, id); err != nil {
+		return uuid.Nil, fmt.Errorf("unable to save the connector ID to %s: %w", path, err)
+	}
+	return id, nil
+}
+
+// writeStableConnectorID writes id to a temporary file renamed over path, so that a crash never leaves a truncated
+// file behind.
+func writeStableConnectorID(path string, id uuid.UUID) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(id.String() + "\n"); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,30 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadStableConnectorID(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "state", "connector-id")
+
+	// The ID is generated the first time, then the same across restarts
+	id, err := LoadStableConnectorID(path)
+	require.NoError(t, err)
+	require.NotEqual(t, uuid.Nil, id)
+	reloaded, err := LoadStableConnectorID(path)
+	require.NoError(t, err)
+	require.Equal(t, id, reloaded)
+
+	// A file that doesn't hold an ID isn't overwritten
+	require.NoError(t, os.WriteFile(path, []byte("not an id"), 0600))
+	_, err = LoadStableConnectorID(path)
+	require.Error(t, err)
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, "not an id", string(content))
+}
@@ -58,7 +58,12 @@ func (s *ResolverService) Shutdown() {
 func (s *ResolverService) Run() error {
 	// create a listener
 	l, err := tunneldns.CreateListener(s.resolver.AddressOrDefault(), s.resolver.PortOrDefault(),
-		s.resolver.UpstreamsOrDefault(), s.resolver.BootstrapsOrDefault(), s.resolver.MaxUpstreamConnectionsOrDefault(), s.log)
+		s.resolver.UpstreamsOrDefault(), s.resolver.BootstrapsOrDefault(), s.resolver.MaxUpstreamConnectionsOrDefault(),
+		tunneldns.ResolverOptions{
+			UpstreamPolicy: s.resolver.UpstreamPolicy,
+			ECS:            s.resolver.ECS,
+			BlocklistFile:  s.resolver.Blocklist,
+		}, s.log)
 	if err != nil {
 		return err
 	}
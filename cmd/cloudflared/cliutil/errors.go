@@ -1,6 +1,7 @@
 package cliutil
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/urfave/cli/v2"
@@ -30,7 +31,13 @@ func WithErrorHandler(actionFunc cli.ActionFunc) cli.ActionFunc {
 				msg := fmt.Sprintf("%s\nSee 'cloudflared %s --help'.", err.Error(), ctx.Command.FullName())
 				err = cli.Exit(msg, -1)
 			} else if _, ok := err.(cli.ExitCoder); !ok {
-				err = cli.Exit(err.Error(), 1)
+				// Keep the exit code of an ExitCoder wrapped along the way
+				exitCode := ExitCodeError
+				var exitCoder cli.ExitCoder
+				if errors.As(err, &exitCoder) {
+					exitCode = exitCoder.ExitCode()
+				}
+				err = cli.Exit(err.Error(), exitCode)
 			}
 		}
 		return err
@@ -0,0 +1,130 @@
+package cliutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/cloudflare/cloudflared/redact"
+)
+
+// Exit codes of a cloudflared process running a tunnel. Orchestrators and scripts rely on them to tell why
+// cloudflared exited, so existing values must never change.
+const (
+	ExitCodeDrained         = 0
+	ExitCodeError           = 1
+	ExitCodeConfigInvalid   = 3
+	ExitCodeAuthFailure     = 4
+	ExitCodeNoEdgeReachable = 5
+	ExitCodeFatalPanic      = 6
+	ExitCodeReadyTimeout    = 7
+	ExitCodeUpdateFailed    = 10
+	ExitCodeUpdated         = 11
+	ExitCodeConfigChanged   = 12
+)
+
+// ShutdownReason is the machine-readable reason cloudflared stopped, as written in the shutdown report.
+type ShutdownReason string
+
+const (
+	ShutdownReasonDrained         ShutdownReason = "drained"
+	ShutdownReasonError           ShutdownReason = "error"
+	ShutdownReasonConfigInvalid   ShutdownReason = "config_invalid"
+	ShutdownReasonAuthFailure     ShutdownReason = "auth_failure"
+	ShutdownReasonNoEdgeReachable ShutdownReason = "no_edge_reachable"
+	ShutdownReasonFatalPanic      ShutdownReason = "fatal_panic"
+	ShutdownReasonReadyTimeout    ShutdownReason = "ready_timeout"
+	ShutdownReasonUpdateFailed    ShutdownReason = "update_failed"
+	ShutdownReasonUpdated         ShutdownReason = "updated"
+	ShutdownReasonConfigChanged   ShutdownReason = "config_changed"
+)
+
+// ExitCode returns the process exit code of the reason.
+func (r ShutdownReason) ExitCode() int {
+	switch r {
+	case ShutdownReasonDrained:
+		return ExitCodeDrained
+	case ShutdownReasonConfigInvalid:
+		return ExitCodeConfigInvalid
+	case ShutdownReasonAuthFailure:
+		return ExitCodeAuthFailure
+	case ShutdownReasonNoEdgeReachable:
+		return ExitCodeNoEdgeReachable
+	case ShutdownReasonFatalPanic:
+		return ExitCodeFatalPanic
+	case ShutdownReasonReadyTimeout:
+		return ExitCodeReadyTimeout
+	case ShutdownReasonUpdateFailed:
+		return ExitCodeUpdateFailed
+	case ShutdownReasonUpdated:
+		return ExitCodeUpdated
+	case ShutdownReasonConfigChanged:
+		return ExitCodeConfigChanged
+	default:
+		return ExitCodeError
+	}
+}
+
+// ShutdownError implements ExitCoder interface, the app will exit with the status code of its reason.
+// nolint: errname
+type ShutdownError struct {
+	Reason ShutdownReason
+	Err    error
+}
+
+func NewShutdownError(reason ShutdownReason, err error) *ShutdownError {
+	return &ShutdownError{
+		Reason: reason,
+		Err:    err,
+	}
+}
+
+func (e *ShutdownError) Error() string {
+	if e.Err == nil {
+		return string(e.Reason)
+	}
+	return e.Err.Error()
+}
+
+func (e *ShutdownError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ShutdownError) ExitCode() int {
+	return e.Reason.ExitCode()
+}
+
+// ShutdownReport is written as the last line of output when cloudflared stops running a tunnel.
+type ShutdownReport struct {
+	Reason        ShutdownReason `json:"reason"`
+	ExitCode      int            `json:"exit_code"`
+	Error         string         `json:"error,omitempty"`
+	UptimeSeconds float64        `json:"uptime_seconds"`
+	Version       string         `json:"version"`
+	// Attempts lists the failed connection attempts when no connection registered within the ready timeout
+	Attempts any `json:"attempts,omitempty"`
+}
+
+func NewShutdownReport(reason ShutdownReason, err error, uptime time.Duration, version string) ShutdownReport {
+	report := ShutdownReport{
+		Reason:        reason,
+		ExitCode:      reason.ExitCode(),
+		UptimeSeconds: uptime.Seconds(),
+		Version:       version,
+	}
+	if err != nil {
+		report.Error = redact.Error(err)
+	}
+	return report
+}
+
+// Write writes the report as a single JSON line.
+func (r ShutdownReport) Write(w io.Writer) error {
+	content, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", content)
+	return err
+}
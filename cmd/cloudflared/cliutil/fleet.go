@@ -0,0 +1,61 @@
+package cliutil
+
+import (
+	"os"
+	"runtime"
+	"strings"
+
+	homedir "github.com/mitchellh/go-homedir"
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+
+	"github.com/cloudflare/cloudflared/client"
+	"github.com/cloudflare/cloudflared/cmd/cloudflared/flags"
+	"github.com/cloudflare/cloudflared/fleet"
+)
+
+// FleetFetcher returns the fleet.Fetcher of --fleet-config-url, or nil if it isn't set. The fleet flags can't be set
+// in the configuration file, since they tell where it comes from.
+func FleetFetcher(c *cli.Context, log *zerolog.Logger) (*fleet.Fetcher, error) {
+	endpoint := c.String(flags.FleetConfigURL)
+	if endpoint == "" {
+		return nil, nil
+	}
+	cachePath, err := homedir.Expand(c.String(flags.FleetConfigCache))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to expand the fleet configuration cache path %s", c.String(flags.FleetConfigCache))
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get the hostname to identify the connector to the fleet")
+	}
+	identity := fleet.Identity{
+		Hostname: hostname,
+		OS:       runtime.GOOS,
+		Arch:     runtime.GOARCH,
+		Version:  strings.Split(c.App.Version, " ")[0],
+	}
+	if path := c.String(flags.ConnectorIDFile); path != "" {
+		connectorID, err := client.LoadStableConnectorID(path)
+		if err != nil {
+			return nil, err
+		}
+		identity.ConnectorID = connectorID.String()
+	}
+	return fleet.NewFetcher(endpoint, c.String(flags.FleetConfigToken), cachePath, identity, log)
+}
+
+// bootstrapFleetConfig fetches the configuration of --fleet-config-url, and uses the file it's cached in as the
+// configuration file.
+func bootstrapFleetConfig(c *cli.Context, log *zerolog.Logger) error {
+	fetcher, err := FleetFetcher(c, log)
+	if err != nil || fetcher == nil {
+		return err
+	}
+	if err := fetcher.Bootstrap(c.Context); err != nil {
+		return err
+	}
+	log.Info().Msgf("Using the fleet configuration cached in %s", fetcher.CachePath())
+	return c.Set("config", fetcher.CachePath())
+}
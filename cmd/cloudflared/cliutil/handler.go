@@ -33,18 +33,20 @@ func ConfiguredActionWithWarnings(actionFunc func(*cli.Context, string) error) c
 }
 
 func setFlagsFromConfigFile(c *cli.Context) (configWarnings string, err error) {
-	const errorExitCode = 1
 	log := logger.CreateLoggerFromContext(c, logger.EnableTerminalLog)
+	if err := bootstrapFleetConfig(c, log); err != nil {
+		return "", cli.Exit(err, ExitCodeConfigInvalid)
+	}
 	inputSource, warnings, err := config.ReadConfigFile(c, log)
 	if err != nil {
 		if err == config.ErrNoConfigFile {
 			return "", nil
 		}
-		return "", cli.Exit(err, errorExitCode)
+		return "", cli.Exit(err, ExitCodeConfigInvalid)
 	}
 
 	if err := altsrc.ApplyInputSource(c, inputSource); err != nil {
-		return "", cli.Exit(err, errorExitCode)
+		return "", cli.Exit(err, ExitCodeConfigInvalid)
 	}
 	return warnings, nil
 }
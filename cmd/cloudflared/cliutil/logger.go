@@ -36,6 +36,38 @@ func ConfigureLoggingFlags(shouldHide bool) []cli.Flag {
 			EnvVars: []string{"TUNNEL_PROTO_LOGLEVEL", "TUNNEL_TRANSPORT_LOGLEVEL"},
 			Hidden:  shouldHide,
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    flags.TransportLogFile,
+			Usage:   "Save transport logs to this file instead of along with the application log, so that verbose transport logging doesn't drown it.",
+			EnvVars: []string{"TUNNEL_TRANSPORT_LOGFILE"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    flags.TransportLogDirectory,
+			Usage:   "Save transport logs to rotated files in this directory instead of along with the application log, so that verbose transport logging doesn't drown it.",
+			EnvVars: []string{"TUNNEL_TRANSPORT_LOGDIRECTORY"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    flags.TransportLogMaxSize,
+			Value:   10,
+			Usage:   "Size in megabytes at which transport logs saved to --transport-log-directory are rotated.",
+			EnvVars: []string{"TUNNEL_TRANSPORT_LOG_MAX_SIZE"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    flags.TransportLogMaxBackups,
+			Value:   5,
+			Usage:   "Number of rotated transport log files kept in --transport-log-directory, 0 keeps all of them.",
+			EnvVars: []string{"TUNNEL_TRANSPORT_LOG_MAX_BACKUPS"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    flags.TransportLogMaxAge,
+			Usage:   "Number of days rotated transport log files are kept in --transport-log-directory, 0 keeps them regardless of age.",
+			EnvVars: []string{"TUNNEL_TRANSPORT_LOG_MAX_AGE"},
+			Hidden:  shouldHide,
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:    flags.LogFile,
 			Usage:   "Save application log to this file for reporting issues.",
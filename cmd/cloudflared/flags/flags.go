@@ -4,6 +4,12 @@ const (
 	// HaConnections specifies how many connections to make to the edge
 	HaConnections = "ha-connections"
 
+	// HaRamp specifies how the connections after the first one are started: fixed, exponential or sequential
+	HaRamp = "ha-ramp"
+
+	// HaRampInterval specifies the delay between starting connections, or the longest wait for the previous one with the sequential ramp
+	HaRampInterval = "ha-ramp-interval"
+
 	// SshPort is the port on localhost the cloudflared ssh server will run on
 	SshPort = "local-ssh-port"
 
@@ -37,14 +43,135 @@ const (
 	// RpcTimeout is how long to wait for a Capnp RPC request to the edge
 	RpcTimeout = "rpc-timeout"
 
+	// RpcRegisterTimeout is how long to wait for the edge to register a connection. Defaults to RpcTimeout.
+	RpcRegisterTimeout = "rpc-register-timeout"
+
+	// RpcUnregisterTimeout is how long to wait for the edge to unregister a connection. Defaults to 5s, never longer than the grace period.
+	RpcUnregisterTimeout = "rpc-unregister-timeout"
+
+	// RpcLocalConfigTimeout is how long to wait for the edge to accept the local configuration. Defaults to RpcTimeout.
+	RpcLocalConfigTimeout = "rpc-local-config-timeout"
+
+	// RpcIdempotentRetries is how many times idempotent RPCs to the edge are retried after timing out
+	RpcIdempotentRetries = "rpc-idempotent-retries"
+
+	// ControlStreamFallbackThreshold is the number of consecutive registration timeouts on the QUIC control stream
+	// after which registration is retried over an HTTP/2 control connection. 0 disables the fallback.
+	ControlStreamFallbackThreshold = "control-stream-fallback-threshold"
+
+	// ControlStreamHeartbeatInterval is how often a heartbeat is sent over the registered control stream. 0 disables heartbeats.
+	ControlStreamHeartbeatInterval = "control-stream-heartbeat-interval"
+
+	// ControlStreamHeartbeatMaxMisses is the number of consecutive failed heartbeats after which the connection is considered dead
+	ControlStreamHeartbeatMaxMisses = "control-stream-heartbeat-max-misses"
+
+	// FaultInjection is a key=value fault injected to exercise retries and fallbacks, only meant for tests and staging
+	FaultInjection = "fault-injection"
+
+	// ConnectionLeakCheck is how long a closed connection has to release its goroutines, streams and sessions before a leak is logged
+	ConnectionLeakCheck = "connection-leak-check"
+
+	// UDPSessionResumeGrace is how long a datagram v3 UDP flow outlives its closed connection to be resumed on another one
+	UDPSessionResumeGrace = "udp-session-resume-grace"
+
+	// ConnectorIDFile is the file where the connector ID that stays the same across restarts is saved
+	ConnectorIDFile = "connector-id-file"
+
+	// EdgeAddrStateFile is the file where the edge IP each connection registered on is remembered across restarts
+	EdgeAddrStateFile = "edge-addr-state-file"
+
+	// EdgeAddrStateTTL is how long a remembered edge IP is preferred after the connection registered on it
+	EdgeAddrStateTTL = "edge-addr-state-ttl"
+
+	// EdgeScorecardFile is the file where the per edge IP scores used to prefer reliable edge IPs are kept across restarts
+	EdgeScorecardFile = "edge-scorecard-file"
+
+	// EdgeRegistrationLockDir is the directory of the lock files sibling processes share to avoid registering on the same edge IP
+	EdgeRegistrationLockDir = "edge-registration-lock-dir"
+
+	// FirstConnectionRace is the number of edge IPs the first connection dials in parallel when the tunnel starts, keeping the first to connect
+	FirstConnectionRace = "first-connection-race"
+
+	// ReadyTimeout is how long to wait for a connection to register before exiting with a summary of the failed connection attempts
+	ReadyTimeout = "ready-timeout"
+
+	// FleetConfigURL is the HTTPS endpoint cloudflared fetches its configuration from instead of reading a local configuration file
+	FleetConfigURL = "fleet-config-url"
+
+	// FleetConfigToken is the bearer token cloudflared authenticates to the fleet configuration endpoint with
+	FleetConfigToken = "fleet-config-token"
+
+	// FleetConfigCache is the file the configuration fetched from the fleet configuration endpoint is cached in
+	FleetConfigCache = "fleet-config-cache"
+
+	// FleetConfigRefresh is how often the configuration is fetched again from the fleet configuration endpoint
+	FleetConfigRefresh = "fleet-config-refresh"
+
+	// ConnectionAuditFile is the JSON lines file where every attempt to connect to the edge is recorded, whatever the log level
+	ConnectionAuditFile = "connection-audit-file"
+
+	// ConnectionAuditMaxSize is the size in megabytes of the connection audit file before it is rotated
+	ConnectionAuditMaxSize = "connection-audit-max-size"
+
+	// ConnectionAuditMaxBackups is how many rotated connection audit files are kept
+	ConnectionAuditMaxBackups = "connection-audit-max-backups"
+
 	// WriteStreamTimeout sets if we should have a timeout when writing data to a stream towards the destination (edge/origin).
 	WriteStreamTimeout = "write-stream-timeout"
 
+	// OriginDialerPlugin is the local gRPC target of a plugin consulted to dial the origins
+	OriginDialerPlugin = "origin-dialer-plugin"
+
 	// QuicDisablePathMTUDiscovery sets if QUIC should not perform PTMU discovery and use a smaller (safe) packet size.
 	// Packets will then be at most 1252 (IPv4) / 1232 (IPv6) bytes in size.
 	// Note that this may result in packet drops for UDP proxying, since we expect being able to send at least 1280 bytes of inner packets.
 	QuicDisablePathMTUDiscovery = "quic-disable-pmtu-discovery"
 
+	// QuicMTUProbe is the command line flag to probe the MTU to the edge before connecting with QUIC, to size QUIC packets to it
+	// or fall back to HTTP2 if it is too small for QUIC
+	QuicMTUProbe = "quic-mtu-probe"
+
+	// QuicAssessmentInterval is the command line flag to measure in the background whether QUIC would work while the connections use
+	// HTTP2, and recommend whether to switch to it
+	QuicAssessmentInterval = "quic-assessment-interval"
+
+	// QuicDSCP is the command line flag to mark the UDP packets of QUIC connections to the edge with a DSCP value, so that QoS
+	// policies can prioritize or deprioritize tunnel traffic
+	QuicDSCP = "quic-dscp"
+
+	// HTTP2DSCP is the command line flag to mark the TCP packets of HTTP2 connections to the edge with a DSCP value
+	HTTP2DSCP = "http2-dscp"
+
+	// EdgeTCPFastOpen is the command line flag to open HTTP2 connections to the edge with TCP Fast Open
+	EdgeTCPFastOpen = "edge-tcp-fast-open"
+
+	// EdgeTCPNoDelay is the command line flag to disable Nagle's algorithm on HTTP2 connections to the edge
+	EdgeTCPNoDelay = "edge-tcp-nodelay"
+
+	// EdgeTCPKeepAliveInterval is the command line flag to set the idle time before and between TCP keepalive probes of HTTP2
+	// connections to the edge
+	EdgeTCPKeepAliveInterval = "edge-tcp-keepalive-interval"
+
+	// EdgeTCPKeepAliveCount is the command line flag to set how many unanswered TCP keepalive probes close an HTTP2 connection
+	// to the edge
+	EdgeTCPKeepAliveCount = "edge-tcp-keepalive-count"
+
+	// EdgeTCPUserTimeout is the command line flag to set how long data sent on an HTTP2 connection to the edge may stay
+	// unacknowledged before the connection is closed
+	EdgeTCPUserTimeout = "edge-tcp-user-timeout"
+
+	// EgressShaping is the command line flag to limit the rate at which a traffic class is written to the edge, so that
+	// bulk transfers can't starve the control streams on thin uplinks
+	EgressShaping = "egress-shaping"
+
+	// CompressionQuality is the command line flag to set how hard streams of HTTP2 connections to the edge are
+	// compressed, 0 disables compression
+	CompressionQuality = "compression-quality"
+
+	// CompressionAlgorithm is the command line flag to choose between gzip and zstd to compress streams of HTTP2
+	// connections to the edge
+	CompressionAlgorithm = "compression-algorithm"
+
 	// QuicConnLevelFlowControlLimit controls the max flow control limit allocated for a QUIC connection. This controls how much data is the
 	// receiver willing to buffer. Once the limit is reached, the sender will send a DATA_BLOCKED frame to indicate it has more data to write,
 	// but it's blocked by flow control
@@ -66,6 +193,9 @@ const (
 	// Tag is the command line flag to set custom tags used to identify this tunnel via added HTTP request headers to the origin
 	Tag = "tag"
 
+	// TagMetadata is the command line flag to set the cloud provider whose instance metadata tags can be templated from
+	TagMetadata = "tag-metadata"
+
 	// Protocol is the command line flag to set the protocol to use to connect to the Cloudflare Edge
 	Protocol = "protocol"
 
@@ -81,20 +211,59 @@ const (
 	// EdgeIpVersion is the command line flag to set the Cloudflare Edge IP address version to connect with
 	EdgeIpVersion = "edge-ip-version"
 
+	// EdgeAddrRotation is the command line flag to set which region a connection's new edge IP address comes from when it is rotated after a failure
+	EdgeAddrRotation = "edge-addr-rotation"
+
 	// EdgeBindAddress is the command line flag to bind to IP address for outgoing connections to Cloudflare Edge
 	EdgeBindAddress = "edge-bind-address"
 
 	// EdgeProxyURL 是命令行标志，用于设置连接到 Cloudflare Edge 时使用的 SOCKS5 代理
 	// 格式: socks5://[user:pass@]host:port
-	// 如果代理连接失败，会自动降级到直连方式
+	// 如果代理连接失败，会自动降级到直连方式，除非关闭 EdgeProxyDirectFallback
 	EdgeProxyURL = "edge-proxy-url"
 
+	// EdgeProxyUsername 是命令行标志，用于设置 SOCKS5 代理的用户名，优先于代理 URL 中的用户信息
+	EdgeProxyUsername = "edge-proxy-username"
+
+	// EdgeProxyPassword 是命令行标志，用于设置 SOCKS5 代理的密码
+	EdgeProxyPassword = "edge-proxy-password"
+
+	// EdgeProxyCredentialsFile 是命令行标志，用于从文件读取 SOCKS5 代理的凭据
+	// 文件内容格式: user:pass
+	EdgeProxyCredentialsFile = "edge-proxy-credentials-file"
+
+	// EdgeProxyTLS 是命令行标志，用于设置 https 代理的 TLS 配置，例如出示给企业 TLS 检查网关的客户端证书
+	// 格式: PROXY;cert=PATH;key=PATH;ca=PATH;server-name=NAME，PROXY 为代理的 host:port 或 * (所有代理)
+	EdgeProxyTLS = "edge-proxy-tls"
+
+	// EdgeProxySSHKey 是命令行标志，用于设置 ssh:// 代理（SSH 跳板机）认证用的私钥文件
+	EdgeProxySSHKey = "edge-proxy-ssh-key"
+
+	// EdgeProxySSHKnownHosts 是命令行标志，用于设置校验 SSH 跳板机主机密钥的 known_hosts 文件，默认为 ~/.ssh/known_hosts
+	EdgeProxySSHKnownHosts = "edge-proxy-ssh-known-hosts"
+
+	// EdgeProxySSHHostKey 是命令行标志，用于固定 SSH 跳板机主机密钥的 SHA256 指纹，可指定多个
+	EdgeProxySSHHostKey = "edge-proxy-ssh-host-key"
+
+	// EdgeProxyPAC 是命令行标志，用于设置 PAC 文件的 URL 或路径，按 PAC 文件为每个边缘地址选择代理或直连
+	// 与 EdgeProxyURL 互斥
+	EdgeProxyPAC = "edge-proxy-pac"
+
+	// EdgeProxyDirectFallback 是命令行标志，用于设置 SOCKS5 代理连接失败时是否降级到直连
+	EdgeProxyDirectFallback = "edge-proxy-direct-fallback"
+
+	// StrictEgress 是命令行标志，开启后除非到 Cloudflare Edge 的所有出站流量都经过 SOCKS5 代理，否则拒绝启动
+	StrictEgress = "strict-egress"
+
 	// Force is the command line flag to specify if you wish to force an action
 	Force = "force"
 
 	// Edge is the command line flag to set the address of the Cloudflare tunnel server. Only works in Cloudflare's internal testing environment
 	Edge = "edge"
 
+	// EdgeHostsFile is the YAML file answering the edge SRV and hostname lookups instead of DNS, for test labs without public DNS
+	EdgeHostsFile = "edge-hosts-file"
+
 	// Region is the command line flag to set the Cloudflare Edge region to connect to
 	Region = "region"
 
@@ -113,12 +282,18 @@ const (
 	// GracePeriod is the command line flag to set the maximum amount of time that cloudflared waits to shut down if it is still serving requests
 	GracePeriod = "grace-period"
 
+	// ShutdownTimeout is how long cloudflared waits for its servers to stop once the grace period is over, before exiting anyway
+	ShutdownTimeout = "shutdown-timeout"
+
 	// ICMPV4Src is the command line flag to set the source address and the interface name to send/receive ICMPv4 messages
 	ICMPV4Src = "icmpv4-src"
 
 	// ICMPV6Src is the command line flag to set the source address and the interface name to send/receive ICMPv6 messages
 	ICMPV6Src = "icmpv6-src"
 
+	// ICMPSourcePortRange is the command line flag to restrict the source ports of the ICMP sockets opened towards origins
+	ICMPSourcePortRange = "icmp-source-port-range"
+
 	// ProxyDns is the command line flag to run DNS server over HTTPS
 	ProxyDns = "proxy-dns"
 
@@ -131,6 +306,10 @@ const (
 	// NoAutoUpdate is the command line flag to disable cloudflared from checking for updates
 	NoAutoUpdate = "no-autoupdate"
 
+	// Lockdown is the command line flag to reject every attempt to change the configuration or the binary of a running
+	// cloudflared
+	Lockdown = "lockdown"
+
 	// LogLevel is the command line flag for the cloudflared logging level
 	LogLevel = "loglevel"
 
@@ -140,6 +319,21 @@ const (
 	// TransportLogLevel is the command line flag for the transport logging level
 	TransportLogLevel = "transport-loglevel"
 
+	// TransportLogFile is the command line flag to define the file where transport logs will be stored apart from application logs
+	TransportLogFile = "transport-logfile"
+
+	// TransportLogDirectory is the command line flag to define the directory where transport logs will be stored apart from application logs.
+	TransportLogDirectory = "transport-log-directory"
+
+	// TransportLogMaxSize is the command line flag for the size in megabytes at which transport logs are rotated
+	TransportLogMaxSize = "transport-log-max-size"
+
+	// TransportLogMaxBackups is the command line flag for how many rotated transport log files are kept
+	TransportLogMaxBackups = "transport-log-max-backups"
+
+	// TransportLogMaxAge is the command line flag for how many days rotated transport log files are kept
+	TransportLogMaxAge = "transport-log-max-age"
+
 	// LogFile is the command line flag to define the file where application logs will be stored
 	LogFile = "logfile"
 
@@ -160,6 +354,30 @@ const (
 	// Metrics is the command line flag to define the address of the metrics server
 	Metrics = "metrics"
 
+	// MetricsListener is the command line flag to add a listener of the metrics server, with its own access controls
+	MetricsListener = "metrics-listener"
+
+	// MetricsAuthToken is the command line flag to require a bearer token on requests to the metrics server
+	MetricsAuthToken = "metrics-auth-token"
+
+	// MetricsAllowedCIDR is the command line flag to restrict the client addresses allowed to reach the metrics server
+	MetricsAllowedCIDR = "metrics-allowed-cidr"
+
+	// MetricsTLSCert is the command line flag to define the certificate used to serve the metrics server over TLS
+	MetricsTLSCert = "metrics-tls-cert"
+
+	// MetricsTLSKey is the command line flag to define the private key used to serve the metrics server over TLS
+	MetricsTLSKey = "metrics-tls-key"
+
+	// MetricsClientCA is the command line flag to define the CA used to verify client certificates (mTLS) on the metrics server
+	MetricsClientCA = "metrics-client-ca"
+
+	// MetricsTunnelLabels is the command line flag to add the tunnel and connector labels to every metric served
+	MetricsTunnelLabels = "metrics-tunnel-labels"
+
+	// MetricsTunnelName is the command line flag to define the tunnel_name label of the metrics
+	MetricsTunnelName = "metrics-tunnel-name"
+
 	// MetricsUpdateFreq is the command line flag to define how frequently tunnel metrics are updated
 	MetricsUpdateFreq = "metrics-update-freq"
 
@@ -174,4 +392,62 @@ const (
 
 	// Automatically close the login interstitial browser window after the user makes a decision.
 	AutoCloseInterstitial = "auto-close"
+
+	// AuditLog is the command line flag to define the file actions affecting the running tunnel are audited to
+	AuditLog = "audit-log"
+
+	// HibernateAfter is the command line flag to define how long the tunnel has no requests before hibernating
+	HibernateAfter = "hibernate-after"
+
+	// HibernateKeepAlive is the command line flag to define the keepalive period of the connection left while hibernating
+	HibernateKeepAlive = "hibernate-keepalive"
+
+	// ProtocolFallbackSuccessRate is the command line flag to define the success rate of the connections registering with
+	// a protocol under which they fall back to another one
+	ProtocolFallbackSuccessRate = "protocol-fallback-success-rate"
+
+	// ProtocolFallbackWindow is the command line flag to define the sliding window of the protocol-fallback-success-rate
+	ProtocolFallbackWindow = "protocol-fallback-window"
+
+	// OverloadCPU is the command line flag to define the fraction of the CPU above which cloudflared sheds new streams
+	OverloadCPU = "overload-cpu"
+
+	// OverloadMemory is the command line flag to define the megabytes of memory above which cloudflared sheds new streams
+	OverloadMemory = "overload-memory"
+
+	// OverloadStreamRate is the command line flag to define the new streams and UDP flows admitted per second while overloaded
+	OverloadStreamRate = "overload-stream-rate"
+
+	// WatchdogTimeout is the command line flag to define how long a connection may go without an answered heartbeat before the watchdog reports it
+	WatchdogTimeout = "watchdog-timeout"
+
+	// WatchdogRestart is the command line flag to let the watchdog reconnect the connections it finds stalled
+	WatchdogRestart = "watchdog-restart"
+
+	// ClockSkewCheckInterval is the command line flag to define how often the local clock is compared with the Cloudflare edge
+	ClockSkewCheckInterval = "clock-skew-check-interval"
+
+	// ClockSkewThreshold is the command line flag to define the clock skew beyond which an error is logged
+	ClockSkewThreshold = "clock-skew-threshold"
+
+	// ClockSkewWait is the command line flag to define how long the registration waits at startup for a skewed clock to be synced
+	ClockSkewWait = "clock-skew-wait"
+
+	// UsageFile is the command line flag to define the file the bytes proxied per hostname and private network are saved to
+	UsageFile = "usage-file"
+
+	// UsageNetworks is the command line flag to define the private networks TCP traffic is attributed to
+	UsageNetworks = "usage-network"
+
+	// UsageSaveInterval is the command line flag to define how often the bytes proxied are saved to the usage file
+	UsageSaveInterval = "usage-save-interval"
+
+	// RandomSeed is the command line flag to seed the choice of edge IPs and the jitter of reconnect backoffs
+	RandomSeed = "random-seed"
+
+	// ManagementFailedRequests is the command line flag to define how many of the last failed requests management keeps
+	ManagementFailedRequests = "management-failed-requests"
+
+	// ErrorReportInterval is the command line flag to define how often summaries of recurring errors are reported
+	ErrorReportInterval = "error-report-interval"
 )
@@ -8,6 +8,7 @@ import (
 
 	"github.com/getsentry/sentry-go"
 	"github.com/urfave/cli/v2"
+	"github.com/urfave/cli/v2/altsrc"
 	"go.uber.org/automaxprocs/maxprocs"
 
 	"github.com/cloudflare/cloudflared/cmd/cloudflared/access"
@@ -120,6 +121,12 @@ func commands(version func(c *cli.Context)) []*cli.Command {
 					Usage:  "specify a version you wish to upgrade or downgrade to",
 					Hidden: false,
 				},
+				altsrc.NewBoolFlag(&cli.BoolFlag{
+					Name:    cfdflags.Lockdown,
+					Usage:   "refuse to update, set in the configuration file of cloudflared in read-only lockdown mode",
+					EnvVars: []string{"TUNNEL_LOCKDOWN"},
+					Hidden:  true,
+				}),
 			},
 			Description: `Looks for a new version on the official download server.
 If a new version exists, updates the agent binary and quits.
@@ -59,6 +59,23 @@ func Command(hidden bool) *cli.Command {
 				Value:   tunneldns.MaxUpstreamConnsDefault,
 				EnvVars: []string{"TUNNEL_DNS_MAX_UPSTREAM_CONNS"},
 			},
+			&cli.StringFlag{
+				Name:    "upstream-policy",
+				Usage:   "Order the upstreams are tried in: fallback (as configured), random, or fastest (lowest recent latency first). Upstreams failing repeatedly are tried last.",
+				Value:   string(tunneldns.PolicyFallback),
+				EnvVars: []string{"TUNNEL_DNS_UPSTREAM_POLICY"},
+			},
+			&cli.StringFlag{
+				Name:    "ecs",
+				Usage:   "EDNS client subnet of the queries forwarded upstream: passthrough, strip, or a subnet (e.g. 192.0.2.0/24) sent instead of the client one.",
+				Value:   tunneldns.ECSPassthrough,
+				EnvVars: []string{"TUNNEL_DNS_ECS"},
+			},
+			&cli.StringFlag{
+				Name:    "blocklist",
+				Usage:   "File of domains answered with NXDOMAIN along with their subdomains, one per line or in the hosts file format.",
+				EnvVars: []string{"TUNNEL_DNS_BLOCKLIST"},
+			},
 		},
 		ArgsUsage: " ", // can't be the empty string or we get the default output
 		Hidden:    hidden,
@@ -83,6 +100,11 @@ func Run(c *cli.Context) error {
 		c.StringSlice("upstream"),
 		c.StringSlice("bootstrap"),
 		c.Int("max-upstream-conns"),
+		tunneldns.ResolverOptions{
+			UpstreamPolicy: c.String("upstream-policy"),
+			ECS:            c.String("ecs"),
+			BlocklistFile:  c.String("blocklist"),
+		},
 		log,
 	)
 
@@ -78,7 +78,7 @@ func buildTailCommand(subcommands []*cli.Command) *cli.Command {
 			},
 			&cli.StringSliceFlag{
 				Name:    "event",
-				Usage:   "Filter by specific Events (cloudflared, http, tcp, udp) otherwise, defaults to send all events",
+				Usage:   "Filter by specific Events (cloudflared, http, tcp, udp, access) otherwise, defaults to send all events but access. Access events summarize every HTTP request: method, host, path, status, duration and bytes",
 				EnvVars: []string{"TUNNEL_MANAGEMENT_FILTER_EVENTS"},
 			},
 			&cli.StringFlag{
@@ -87,6 +87,11 @@ func buildTailCommand(subcommands []*cli.Command) *cli.Command {
 				EnvVars: []string{"TUNNEL_MANAGEMENT_FILTER_LEVEL"},
 				Value:   "debug",
 			},
+			&cli.StringSliceFlag{
+				Name:    "field",
+				Usage:   "Only stream these fields of the events, e.g. status and duration, otherwise, defaults to stream all fields",
+				EnvVars: []string{"TUNNEL_MANAGEMENT_FILTER_FIELDS"},
+			},
 			&cli.Float64Flag{
 				Name:    "sample",
 				Usage:   "Sample log events by percentage (0.0 .. 1.0). No sampling by default.",
@@ -196,6 +201,7 @@ func parseFilters(c *cli.Context) (*management.StreamingFilters, error) {
 	argLevel := c.String("level")
 	argEvents := c.StringSlice("event")
 	argSample := c.Float64("sample")
+	argFields := c.StringSlice("field")
 
 	if argLevel != "" {
 		l, ok := management.ParseLogLevel(argLevel)
@@ -208,7 +214,7 @@ func parseFilters(c *cli.Context) (*management.StreamingFilters, error) {
 	for _, v := range argEvents {
 		t, ok := management.ParseLogEventType(v)
 		if !ok {
-			return nil, fmt.Errorf("invalid --event filter provided, please use one of the following EventTypes: cloudflared, http, tcp, udp")
+			return nil, fmt.Errorf("invalid --event filter provided, please use one of the following EventTypes: cloudflared, http, tcp, udp, access")
 		}
 		events = append(events, t)
 	}
@@ -218,7 +224,7 @@ func parseFilters(c *cli.Context) (*management.StreamingFilters, error) {
 	}
 	sample = argSample
 
-	if level == nil && len(events) == 0 && argSample != 1.0 {
+	if level == nil && len(events) == 0 && len(argFields) == 0 && argSample != 1.0 {
 		// When no filters are provided, do not return a StreamingFilters struct
 		return nil, nil
 	}
@@ -227,6 +233,7 @@ func parseFilters(c *cli.Context) (*management.StreamingFilters, error) {
 		Level:    level,
 		Events:   events,
 		Sampling: sample,
+		Fields:   argFields,
 	}, nil
 }
 
@@ -15,12 +15,14 @@ import (
 	"github.com/coreos/go-systemd/v22/daemon"
 	"github.com/facebookgo/grace/gracenet"
 	"github.com/getsentry/sentry-go"
+	"github.com/google/uuid"
 	"github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"github.com/urfave/cli/v2"
 	"github.com/urfave/cli/v2/altsrc"
 
+	"github.com/cloudflare/cloudflared/audit"
 	"github.com/cloudflare/cloudflared/cfapi"
 	"github.com/cloudflare/cloudflared/cmd/cloudflared/cliutil"
 	cfdflags "github.com/cloudflare/cloudflared/cmd/cloudflared/flags"
@@ -31,16 +33,22 @@ import (
 	"github.com/cloudflare/cloudflared/credentials"
 	"github.com/cloudflare/cloudflared/diagnostic"
 	"github.com/cloudflare/cloudflared/edgediscovery"
+	"github.com/cloudflare/cloudflared/errorreport"
+	"github.com/cloudflare/cloudflared/fleet"
+	"github.com/cloudflare/cloudflared/har"
 	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/lockdown"
 	"github.com/cloudflare/cloudflared/logger"
 	"github.com/cloudflare/cloudflared/management"
 	"github.com/cloudflare/cloudflared/metrics"
 	"github.com/cloudflare/cloudflared/orchestration"
+	"github.com/cloudflare/cloudflared/redact"
 	"github.com/cloudflare/cloudflared/signal"
 	"github.com/cloudflare/cloudflared/supervisor"
 	"github.com/cloudflare/cloudflared/tlsconfig"
 	"github.com/cloudflare/cloudflared/tunneldns"
 	"github.com/cloudflare/cloudflared/tunnelstate"
+	"github.com/cloudflare/cloudflared/usage"
 	"github.com/cloudflare/cloudflared/validation"
 )
 
@@ -75,9 +83,19 @@ var (
 	// however this approach is not maintainble in the long-term.
 	nonSecretFlagsList = []string{
 		"config",
+		cfdflags.FleetConfigCache,
+		cfdflags.FleetConfigRefresh,
 		cfdflags.AutoUpdateFreq,
 		cfdflags.NoAutoUpdate,
+		cfdflags.Lockdown,
 		cfdflags.Metrics,
+		cfdflags.MetricsListener,
+		cfdflags.MetricsAllowedCIDR,
+		cfdflags.MetricsTLSCert,
+		cfdflags.MetricsTLSKey,
+		cfdflags.MetricsClientCA,
+		cfdflags.MetricsTunnelLabels,
+		cfdflags.MetricsTunnelName,
 		"pidfile",
 		"url",
 		"hello-world",
@@ -97,6 +115,9 @@ var (
 		"no-tls-verify",
 		"no-chunked-encoding",
 		"http2-origin",
+		"http3-origin",
+		"request-body-buffer-dir",
+		"request-body-buffer-max-size",
 		cfdflags.ManagementHostname,
 		"service-op-ip",
 		"local-ssh-port",
@@ -112,6 +133,11 @@ var (
 		"proxy-port",
 		cfdflags.LogLevel,
 		cfdflags.TransportLogLevel,
+		cfdflags.TransportLogFile,
+		cfdflags.TransportLogDirectory,
+		cfdflags.TransportLogMaxSize,
+		cfdflags.TransportLogMaxBackups,
+		cfdflags.TransportLogMaxAge,
 		cfdflags.LogFile,
 		cfdflags.LogDirectory,
 		cfdflags.TraceOutput,
@@ -121,11 +147,26 @@ var (
 		"proxy-dns-upstream",
 		"proxy-dns-max-upstream-conns",
 		"proxy-dns-bootstrap",
+		"proxy-dns-upstream-policy",
+		"proxy-dns-ecs",
+		"proxy-dns-blocklist",
 		cfdflags.IsAutoUpdated,
 		cfdflags.Edge,
+		cfdflags.EdgeHostsFile,
 		cfdflags.Region,
 		cfdflags.EdgeIpVersion,
+		cfdflags.EdgeAddrRotation,
 		cfdflags.EdgeBindAddress,
+		cfdflags.EdgeProxyURL,
+		cfdflags.EdgeProxyUsername,
+		cfdflags.EdgeProxyCredentialsFile,
+		cfdflags.EdgeProxyTLS,
+		cfdflags.EdgeProxySSHKey,
+		cfdflags.EdgeProxySSHKnownHosts,
+		cfdflags.EdgeProxySSHHostKey,
+		cfdflags.EdgeProxyPAC,
+		cfdflags.EdgeProxyDirectFallback,
+		cfdflags.StrictEgress,
 		"cacert",
 		"hostname",
 		"id",
@@ -133,33 +174,97 @@ var (
 		cfdflags.ApiURL,
 		cfdflags.MetricsUpdateFreq,
 		cfdflags.Tag,
+		cfdflags.TagMetadata,
 		"heartbeat-interval",
 		"heartbeat-count",
 		cfdflags.MaxEdgeAddrRetries,
 		cfdflags.Retries,
 		"ha-connections",
+		cfdflags.HaRamp,
+		cfdflags.HaRampInterval,
 		"rpc-timeout",
+		cfdflags.RpcRegisterTimeout,
+		cfdflags.RpcUnregisterTimeout,
+		cfdflags.RpcLocalConfigTimeout,
+		cfdflags.RpcIdempotentRetries,
+		cfdflags.ControlStreamFallbackThreshold,
+		cfdflags.ControlStreamHeartbeatInterval,
+		cfdflags.ControlStreamHeartbeatMaxMisses,
+		cfdflags.FaultInjection,
+		cfdflags.OriginDialerPlugin,
+		cfdflags.ConnectionLeakCheck,
+		cfdflags.UDPSessionResumeGrace,
+		cfdflags.ConnectorIDFile,
+		cfdflags.EdgeAddrStateFile,
+		cfdflags.EdgeAddrStateTTL,
+		cfdflags.EdgeScorecardFile,
+		cfdflags.EdgeRegistrationLockDir,
+		cfdflags.FirstConnectionRace,
+		cfdflags.ProtocolFallbackSuccessRate,
+		cfdflags.ProtocolFallbackWindow,
+		cfdflags.RandomSeed,
+		cfdflags.HibernateAfter,
+		cfdflags.HibernateKeepAlive,
+		cfdflags.OverloadCPU,
+		cfdflags.OverloadMemory,
+		cfdflags.OverloadStreamRate,
+		cfdflags.WatchdogTimeout,
+		cfdflags.WatchdogRestart,
+		cfdflags.ClockSkewCheckInterval,
+		cfdflags.ClockSkewThreshold,
+		cfdflags.ClockSkewWait,
+		cfdflags.UsageFile,
+		cfdflags.UsageNetworks,
+		cfdflags.UsageSaveInterval,
+		cfdflags.ReadyTimeout,
+		cfdflags.ConnectionAuditFile,
+		cfdflags.ConnectionAuditMaxSize,
+		cfdflags.ConnectionAuditMaxBackups,
 		"write-stream-timeout",
 		"quic-disable-pmtu-discovery",
+		cfdflags.QuicMTUProbe,
+		cfdflags.QuicAssessmentInterval,
+		cfdflags.QuicDSCP,
+		cfdflags.HTTP2DSCP,
+		cfdflags.EdgeTCPFastOpen,
+		cfdflags.EdgeTCPNoDelay,
+		cfdflags.EdgeTCPKeepAliveInterval,
+		cfdflags.EdgeTCPKeepAliveCount,
+		cfdflags.EdgeTCPUserTimeout,
+		cfdflags.EgressShaping,
 		"quic-connection-level-flow-control-limit",
 		"quic-stream-level-flow-control-limit",
 		cfdflags.ConnectorLabel,
 		cfdflags.GracePeriod,
-		"compression-quality",
+		cfdflags.ShutdownTimeout,
+		cfdflags.CompressionQuality,
+		cfdflags.CompressionAlgorithm,
 		"use-reconnect-token",
 		"dial-edge-timeout",
 		"stdin-control",
+		cfdflags.AuditLog,
 		cfdflags.Name,
 		cfdflags.Ui,
 		"quick-service",
 		"max-fetch-size",
 		cfdflags.PostQuantum,
 		"management-diagnostics",
+		cfdflags.ManagementFailedRequests,
 		cfdflags.Protocol,
 		"overwrite-dns",
 		"help",
 		cfdflags.MaxActiveFlows,
 	}
+	metricsAuthTokenFlag = altsrc.NewStringFlag(&cli.StringFlag{
+		Name:    cfdflags.MetricsAuthToken,
+		Usage:   "Require clients of the metrics server to send this token as `TOKEN` in an `Authorization: Bearer` header.",
+		EnvVars: []string{"TUNNEL_METRICS_AUTH_TOKEN"},
+	})
+	edgeProxyPasswordFlag = altsrc.NewStringFlag(&cli.StringFlag{
+		Name:    cfdflags.EdgeProxyPassword,
+		Usage:   "Password to authenticate with the proxy set by --edge-proxy-url, set it with the environment variable to keep it out of process lists.",
+		EnvVars: []string{"TUNNEL_EDGE_PROXY_PASSWORD"},
+	})
 )
 
 func Flags() []cli.Flag {
@@ -175,6 +280,8 @@ func Commands() []*cli.Command {
 		buildRunCommand(),
 		buildListCommand(),
 		buildReadyCommand(),
+		buildPrepareReconnectCommand(),
+		buildMaintenanceOverrideCommand(),
 		buildInfoCommand(),
 		buildIngressSubcommand(),
 		buildDeleteCommand(),
@@ -329,11 +436,33 @@ func routeFromFlag(c *cli.Context) (route cfapi.HostnameRoute, ok bool) {
 	return nil, false
 }
 
+// StartServer runs the tunnel server until it is shut down. The returned error carries the exit code of the
+// shutdown reason, which is also written as a final JSON shutdown report line to stderr. Panics of the main
+// goroutine and of the supervisor, metrics server, updater and DNS proxy goroutines are reported as fatal_panic;
+// panics of the goroutines these start, e.g. per connection or stream, still crash the process unreported.
 func StartServer(
 	c *cli.Context,
 	info *cliutil.BuildInfo,
 	namedTunnel *connection.TunnelProperties,
 	log *zerolog.Logger,
+) (err error) {
+	startTime := time.Now()
+	redactSecrets(c, namedTunnel)
+	defer func() {
+		if r := recover(); r != nil {
+			log.Error().Msgf("Tunnel server panicked: %v", r)
+			err = recoverFatalPanic(r)
+		}
+		err = reportShutdown(os.Stderr, err, startTime, info.CloudflaredVersion)
+	}()
+	return startServer(c, info, namedTunnel, log)
+}
+
+func startServer(
+	c *cli.Context,
+	info *cliutil.BuildInfo,
+	namedTunnel *connection.TunnelProperties,
+	log *zerolog.Logger,
 ) error {
 	err := sentry.Init(sentry.ClientOptions{
 		Dsn:     sentryDSN,
@@ -391,13 +520,37 @@ func StartServer(
 	ctx, cancel := context.WithCancel(c.Context)
 	defer cancel()
 
-	go waitForSignal(graceShutdownC, log)
+	var auditLog *audit.Log
+	if path := c.String(cfdflags.AuditLog); path != "" {
+		if auditLog, err = audit.Open(path, log); err != nil {
+			log.Err(err).Msg("Couldn't open the audit log")
+			return cliutil.NewShutdownError(cliutil.ShutdownReasonConfigInvalid, err)
+		}
+		defer auditLog.Close()
+	}
+
+	maintenanceWindows, err := maintenanceWindows(config.GetConfiguration().MaintenanceWindows)
+	if err != nil {
+		log.Err(err).Msg("Invalid maintenanceWindows")
+		return cliutil.NewShutdownError(cliutil.ShutdownReasonConfigInvalid, errors.Wrap(err, "invalid maintenanceWindows"))
+	}
+
+	go waitForSignal(graceShutdownC, auditLog, log)
+
+	lock := lockdown.New(c.Bool(cfdflags.Lockdown), auditLog, log)
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		errorreport.Run(ctx, c.Duration(cfdflags.ErrorReportInterval), log)
+	}()
 
 	if c.IsSet(cfdflags.ProxyDns) {
 		dnsReadySignal := make(chan struct{})
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
+			defer recoverServerPanic(errC, log)
 			errC <- runDNSProxyServer(c, dnsReadySignal, ctx.Done(), log)
 		}()
 		// Wait for proxy-dns to come up (if used)
@@ -414,17 +567,39 @@ func StartServer(
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		defer recoverServerPanic(errC, log)
 		autoupdater := updater.NewAutoUpdater(
-			c.Bool(cfdflags.NoAutoUpdate), c.Duration(cfdflags.AutoUpdateFreq), &listeners, log,
+			c.Bool(cfdflags.NoAutoUpdate) || lock.Enabled(), c.Duration(cfdflags.AutoUpdateFreq), &listeners, auditLog, maintenanceWindows, log,
 		)
 		errC <- autoupdater.Run(ctx)
 	}()
 
+	if interval := c.Duration(cfdflags.FleetConfigRefresh); interval > 0 {
+		fetcher, err := cliutil.FleetFetcher(c, log)
+		if err != nil {
+			return cliutil.NewShutdownError(cliutil.ShutdownReasonConfigInvalid, err)
+		}
+		if fetcher != nil && interval < fleet.MinRefreshInterval {
+			err := fmt.Errorf("--%s must be 0 or at least %s", cfdflags.FleetConfigRefresh, fleet.MinRefreshInterval)
+			return cliutil.NewShutdownError(cliutil.ShutdownReasonConfigInvalid, err)
+		}
+		if fetcher != nil && lock.Enabled() {
+			log.Info().Msg("The fleet configuration isn't refreshed in lockdown mode")
+		} else if fetcher != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer recoverServerPanic(errC, log)
+				errC <- fetcher.Run(ctx, interval)
+			}()
+		}
+	}
+
 	// Serve DNS proxy stand-alone if no tunnel type (quick, adhoc, named) is going to run
 	if dnsProxyStandAlone(c, namedTunnel) {
 		connectedSignal.Notify()
 		// no grace period, handle SIGINT/SIGTERM immediately
-		return waitToShutdown(&wg, cancel, errC, graceShutdownC, 0, log)
+		return waitToShutdown(&wg, cancel, errC, graceShutdownC, 0, c.Duration(cfdflags.ShutdownTimeout), log)
 	}
 
 	logTransport := logger.CreateTransportLoggerFromContext(c, logger.EnableTerminalLog)
@@ -443,19 +618,50 @@ func StartServer(
 	tunnelConfig, orchestratorConfig, err := prepareTunnelConfig(ctx, c, info, log, logTransport, observer, namedTunnel)
 	if err != nil {
 		log.Err(err).Msg("Couldn't start tunnel")
-		return err
+		return cliutil.NewShutdownError(cliutil.ShutdownReasonConfigInvalid, err)
 	}
 	connectorID := tunnelConfig.ClientConfig.ConnectorID
+	orchestratorConfig.AuditLog = auditLog
+	orchestratorConfig.Lockdown = lock
+
+	var usageAccounting *usage.Accounting
+	if path := c.String(cfdflags.UsageFile); path != "" {
+		networks, err := parseUsageNetworks(c.StringSlice(cfdflags.UsageNetworks))
+		if err != nil {
+			log.Err(err).Msg("Couldn't start tunnel")
+			return cliutil.NewShutdownError(cliutil.ShutdownReasonConfigInvalid, err)
+		}
+		if usageAccounting, err = usage.New(path, networks); err != nil {
+			log.Warn().Err(err).Msg("Ignoring previously saved bandwidth usage")
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			usageAccounting.Run(ctx, c.Duration(cfdflags.UsageSaveInterval), log)
+		}()
+	}
+	orchestratorConfig.Usage = usageAccounting
+	var harRecorder *har.Recorder
+	if c.Bool("management-diagnostics") {
+		harRecorder = har.NewRecorder(buildInfo.CloudflaredVersion, c.Int(cfdflags.ManagementFailedRequests))
+	}
+	orchestratorConfig.HAR = harRecorder
+	tunnelConfig.Usage = usageAccounting
+	tunnelConfig.Maintenance = maintenanceWindows
 
 	// Disable ICMP packet routing for quick tunnels
 	if quickTunnelURL != "" {
 		tunnelConfig.ICMPRouterServer = nil
+		ingress.DisableICMPProxy("ICMP is not proxied for quick tunnels")
 	}
 
 	serviceIP := c.String("service-op-ip")
-	if edgeAddrs, err := edgediscovery.ResolveEdge(log, tunnelConfig.Region, tunnelConfig.EdgeIPVersion); err == nil {
-		if serviceAddr, err := edgeAddrs.GetAddrForRPC(); err == nil {
-			serviceIP = serviceAddr.TCP.String()
+	// With --strict-egress, the edge is only looked up in the edge hosts file
+	if !c.Bool(cfdflags.StrictEgress) || tunnelConfig.EdgeHosts != nil {
+		if edgeAddrs, err := edgediscovery.ResolveEdge(log, tunnelConfig.Region, tunnelConfig.EdgeIPVersion, tunnelConfig.EdgeHosts); err == nil {
+			if serviceAddr, err := edgeAddrs.GetAddrForRPC(); err == nil {
+				serviceIP = serviceAddr.TCP.String()
+			}
 		}
 	}
 
@@ -474,6 +680,9 @@ func StartServer(
 		managementHostname = c.String(cfdflags.ManagementHostname)
 	}
 
+	tracker := tunnelstate.NewConnTracker(log)
+	observer.RegisterSink(tracker)
+
 	mgmt := management.New(
 		managementHostname,
 		c.Bool("management-diagnostics"),
@@ -482,6 +691,9 @@ func StartServer(
 		c.String(cfdflags.ConnectorLabel),
 		logger.ManagementLogger.Log,
 		logger.ManagementLogger,
+		auditLog,
+		tracker,
+		harRecorder,
 	)
 	internalRules := []ingress.Rule{ingress.NewManagementRule(mgmt)}
 	orchestrator, err := orchestration.NewOrchestrator(ctx, orchestratorConfig, tunnelConfig.Tags, internalRules, tunnelConfig.Log)
@@ -489,6 +701,22 @@ func StartServer(
 		return err
 	}
 
+	tunnelLabels := metrics.TunnelLabels{
+		TunnelID:    tunnelConfig.NamedTunnel.Credentials.TunnelID.String(),
+		TunnelName:  metricsTunnelName(c),
+		ConnectorID: connectorID.String(),
+	}
+	if stableConnectorID := tunnelConfig.ClientConfig.StableConnectorID; stableConnectorID != uuid.Nil {
+		tunnelLabels.StableConnectorID = stableConnectorID.String()
+	}
+	metrics.RegisterTunnelInfo(tunnelLabels)
+
+	metricsAuth, err := metricsAuthConfig(c)
+	if err != nil {
+		log.Err(err).Msg("Error configuring metrics server access control")
+		return cliutil.NewShutdownError(cliutil.ShutdownReasonConfigInvalid, errors.Wrap(err, "Error configuring metrics server access control"))
+	}
+
 	metricsListener, err := metrics.CreateMetricsListener(&listeners, c.String("metrics"))
 	if err != nil {
 		log.Err(err).Msg("Error opening metrics server listener")
@@ -496,13 +724,19 @@ func StartServer(
 	}
 
 	defer metricsListener.Close()
+	extraMetricsListeners, err := openMetricsListeners(c, &listeners, metricsAuth)
+	for _, extra := range extraMetricsListeners {
+		defer extra.listener.Close()
+	}
+	if err != nil {
+		log.Err(err).Msg("Error opening metrics server listener")
+		return cliutil.NewShutdownError(cliutil.ShutdownReasonConfigInvalid, errors.Wrap(err, "Error opening metrics server listener"))
+	}
 	wg.Add(1)
 
 	go func() {
 		defer wg.Done()
-		tracker := tunnelstate.NewConnTracker(log)
-		observer.RegisterSink(tracker)
-
+		defer recoverServerPanic(errC, log)
 		ipv4, ipv6, err := determineICMPSources(c, log)
 		sources := make([]string, 0)
 		if err == nil {
@@ -510,6 +744,8 @@ func StartServer(
 			sources = append(sources, ipv6.String())
 		}
 
+		icmpProxyStatus := ingress.GetICMPProxyStatus()
+
 		readinessServer := metrics.NewReadyServer(connectorID, tracker)
 		cliFlags := nonSecretCliFlags(log, c, nonSecretFlagsList)
 		diagnosticHandler := diagnostic.NewDiagnosticHandler(
@@ -521,12 +757,32 @@ func StartServer(
 			tracker,
 			cliFlags,
 			sources,
+			&diagnostic.ICMPProxyStatus{State: string(icmpProxyStatus.State), Reason: icmpProxyStatus.Reason},
+			usageAccounting,
 		)
 		metricsConfig := metrics.Config{
 			ReadyServer:         readinessServer,
 			DiagnosticHandler:   diagnosticHandler,
 			QuickTunnelHostname: quickTunnelURL,
 			Orchestrator:        orchestrator,
+			ReconnectPreparer:   tunnelConfig.ReconnectPreparer,
+			Maintenance:         maintenanceWindows,
+			Auth:                metricsAuth,
+			AuditLog:            auditLog,
+			Lockdown:            lock,
+		}
+		if c.Bool(cfdflags.MetricsTunnelLabels) {
+			metricsConfig.TunnelLabels = &tunnelLabels
+		}
+		for _, extra := range extraMetricsListeners {
+			extraConfig := metricsConfig
+			extraConfig.Auth = extra.auth
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				defer recoverServerPanic(errC, log)
+				errC <- metrics.ServeMetrics(extra.listener, ctx, extraConfig, log)
+			}()
 		}
 		errC <- metrics.ServeMetrics(metricsListener, ctx, metricsConfig, log)
 	}()
@@ -534,7 +790,7 @@ func StartServer(
 	reconnectCh := make(chan supervisor.ReconnectSignal, c.Int(cfdflags.HaConnections))
 	if c.IsSet("stdin-control") {
 		log.Info().Msg("Enabling control through stdin")
-		go stdinControl(reconnectCh, log)
+		go stdinControl(reconnectCh, auditLog, lock, log)
 	}
 
 	wg.Add(1)
@@ -543,14 +799,15 @@ func StartServer(
 			wg.Done()
 			log.Info().Msg("Tunnel server stopped")
 		}()
+		defer recoverServerPanic(errC, log)
 		errC <- supervisor.StartTunnelDaemon(ctx, tunnelConfig, orchestrator, connectedSignal, reconnectCh, graceShutdownC)
 	}()
 
 	gracePeriod, err := gracePeriod(c)
 	if err != nil {
-		return err
+		return cliutil.NewShutdownError(cliutil.ShutdownReasonConfigInvalid, err)
 	}
-	return waitToShutdown(&wg, cancel, errC, graceShutdownC, gracePeriod, log)
+	return waitToShutdown(&wg, cancel, errC, graceShutdownC, gracePeriod, c.Duration(cfdflags.ShutdownTimeout), log)
 }
 
 func waitToShutdown(wg *sync.WaitGroup,
@@ -558,6 +815,7 @@ func waitToShutdown(wg *sync.WaitGroup,
 	errC <-chan error,
 	graceShutdownC <-chan struct{},
 	gracePeriod time.Duration,
+	shutdownTimeout time.Duration,
 	log *zerolog.Logger,
 ) error {
 	var err error
@@ -591,8 +849,23 @@ func waitToShutdown(wg *sync.WaitGroup,
 			}
 		}
 	}()
-	wg.Wait()
-	close(stopDiscarding)
+	defer close(stopDiscarding)
+	stoppedC := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(stoppedC)
+	}()
+	var timeoutC <-chan time.Time
+	if shutdownTimeout > 0 {
+		timer := time.NewTimer(shutdownTimeout)
+		defer timer.Stop()
+		timeoutC = timer.C
+	}
+	select {
+	case <-stoppedC:
+	case <-timeoutC:
+		log.Warn().Msgf("Servers did not stop within %s of the shutdown, exiting anyway", shutdownTimeout)
+	}
 
 	return err
 }
@@ -630,6 +903,8 @@ func hostnameFromURI(uri string) string {
 		return addPortIfMissing(u, 3389)
 	case "smb":
 		return addPortIfMissing(u, 445)
+	case "vnc":
+		return addPortIfMissing(u, 5900)
 	case "tcp":
 		return addPortIfMissing(u, 7864) // just a random port since there isn't a default in this case
 	}
@@ -662,6 +937,12 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 			EnvVars: []string{"TUNNEL_EDGE"},
 			Hidden:  true,
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.EdgeHostsFile,
+			Usage:   "Answer the edge SRV and hostname lookups of edge discovery from this YAML file instead of DNS, to test discovery and failover against mock edges without public DNS.",
+			EnvVars: []string{"TUNNEL_EDGE_HOSTS_FILE"},
+			Hidden:  true,
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:    cfdflags.Region,
 			Usage:   "Cloudflare Edge region to connect to. Omit or set to empty to connect to the global region.",
@@ -669,11 +950,17 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:    cfdflags.EdgeIpVersion,
-			Usage:   "Cloudflare Edge IP address version to connect with. {4, 6, auto}",
+			Usage:   "Cloudflare Edge IP address version to connect with. {4, 6, auto}. Unless it is set, IPv6 is used on hosts without an IPv4 route to the edge.",
 			EnvVars: []string{"TUNNEL_EDGE_IP_VERSION"},
 			Value:   "4",
 			Hidden:  false,
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.EdgeAddrRotation,
+			Usage:   "Which region a connection's new Cloudflare Edge IP address comes from when it is rotated after a failure. {balanced, same-region, other-region}. same-region preserves latency, other-region maximizes availability.",
+			EnvVars: []string{"TUNNEL_EDGE_ADDR_ROTATION"},
+			Value:   "balanced",
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:    cfdflags.EdgeBindAddress,
 			Usage:   "Bind to IP address for outgoing connections to Cloudflare Edge.",
@@ -682,10 +969,60 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:    cfdflags.EdgeProxyURL,
-			Usage:   "SOCKS5 proxy URL for connections to Cloudflare Edge. Format: socks5://[user:pass@]host:port. Falls back to direct connection if proxy fails.",
+			Usage:   "SOCKS5 or HTTP CONNECT proxy URL, or SSH jump host, for connections to Cloudflare Edge. Format: socks5://host:port, http://host:port, https://host:port or ssh://user@host:port (see --edge-proxy-ssh-key). Falls back to direct connection if proxy fails, unless --edge-proxy-direct-fallback=false. Prefer --edge-proxy-username and --edge-proxy-password or --edge-proxy-credentials-file to credentials in the URL, which end up in process lists.",
 			EnvVars: []string{"TUNNEL_EDGE_PROXY_URL"},
 			Hidden:  false,
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.EdgeProxyUsername,
+			Usage:   "Username to authenticate with the proxy set by --edge-proxy-url.",
+			EnvVars: []string{"TUNNEL_EDGE_PROXY_USERNAME"},
+			Hidden:  false,
+		}),
+		edgeProxyPasswordFlag,
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.EdgeProxyCredentialsFile,
+			Usage:   "File with the `user:pass` credentials to authenticate with the proxy set by --edge-proxy-url.",
+			EnvVars: []string{"TUNNEL_EDGE_PROXY_CREDENTIALS_FILE"},
+			Hidden:  false,
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name: cfdflags.EdgeProxyTLS,
+			Usage: "TLS settings of the connections to an https:// edge proxy, e.g. the client certificate an enterprise TLS inspecting gateway requires, distinct from the edge TLS credentials. " +
+				"Format: `PROXY;cert=PATH;key=PATH;ca=PATH;server-name=NAME` where PROXY is the host:port of the proxy or * for any proxy, and every setting is optional. Multiple proxies may be specified.",
+			EnvVars: []string{"TUNNEL_EDGE_PROXY_TLS"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.EdgeProxySSHKey,
+			Usage:   "Private key authenticating with the SSH jump host set by --edge-proxy-url ssh://user@bastion:port, for networks where the only allowed egress is SSH to a bastion. The key must not be encrypted.",
+			EnvVars: []string{"TUNNEL_EDGE_PROXY_SSH_KEY"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.EdgeProxySSHKnownHosts,
+			Usage:   "known_hosts file verifying the host key of the SSH jump host. Defaults to ~/.ssh/known_hosts.",
+			EnvVars: []string{"TUNNEL_EDGE_PROXY_SSH_KNOWN_HOSTS"},
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:    cfdflags.EdgeProxySSHHostKey,
+			Usage:   "SHA256 fingerprint of the host key of the SSH jump host, as printed by ssh-keygen -lf, accepted in addition to the known hosts. Multiple fingerprints may be specified.",
+			EnvVars: []string{"TUNNEL_EDGE_PROXY_SSH_HOST_KEY"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.EdgeProxyPAC,
+			Usage:   "URL (http://, https:// or file://) or path of a PAC file selecting the proxies, or a direct connection, for each Cloudflare Edge address, instead of --edge-proxy-url. The proxies are tried in the order the PAC file returns them. The PAC file is fetched again every hour.",
+			EnvVars: []string{"TUNNEL_EDGE_PROXY_PAC"},
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:    cfdflags.EdgeProxyDirectFallback,
+			Usage:   "Connect to Cloudflare Edge directly when the proxy set by --edge-proxy-url, or all the proxies selected by --edge-proxy-pac, fail.",
+			EnvVars: []string{"TUNNEL_EDGE_PROXY_DIRECT_FALLBACK"},
+			Value:   true,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:    cfdflags.StrictEgress,
+			Usage:   "Refuse to start unless all the traffic to Cloudflare Edge goes through the proxy set by --edge-proxy-url: it requires --protocol http2, --edge-proxy-direct-fallback=false, and --edge-hosts-file or --edge with IP addresses so that the edge isn't looked up in DNS. The DNS lookups of the protocol and features to use are skipped.",
+			EnvVars: []string{"TUNNEL_STRICT_EGRESS"},
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:    tlsconfig.CaCertFlag,
 			Usage:   "Certificate Authority authenticating connections with Cloudflare's edge network.",
@@ -744,10 +1081,16 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 		}),
 		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
 			Name:    cfdflags.Tag,
-			Usage:   "Custom tags used to identify this tunnel via added HTTP request headers to the origin, in format `KEY=VALUE`. Multiple tags may be specified.",
+			Usage:   "Custom tags used to identify this tunnel via added HTTP request headers to the origin, in format `KEY=VALUE`. Multiple tags may be specified. Values are Go templates of the host, e.g. `{{.Hostname}}`, `{{.OS}}`, `{{.Arch}}`, `{{env \"REGION\"}}` or `{{metadata \"instance-id\"}}`.",
 			EnvVars: []string{"TUNNEL_TAG"},
 			Hidden:  true,
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.TagMetadata,
+			Usage:   "Cloud provider whose instance metadata service tags can read with `{{metadata \"KEY\"}}`: aws, gcp or azure. The metadata service isn't queried unless set.",
+			EnvVars: []string{"TUNNEL_TAG_METADATA"},
+			Hidden:  true,
+		}),
 		altsrc.NewDurationFlag(&cli.DurationFlag{
 			Name:   "heartbeat-interval",
 			Usage:  "Minimum idle time before sending a heartbeat.",
@@ -780,11 +1123,227 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 			Value:  4,
 			Hidden: true,
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:   cfdflags.HaRamp,
+			Usage:  "How the connections after the first one are started once it registered: fixed starts them one at a time ha-ramp-interval apart, exponential in batches doubling in size ha-ramp-interval apart, sequential each once the previous one registered, waiting at most ha-ramp-interval for it.",
+			Value:  string(supervisor.RampFixed),
+			Hidden: true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   cfdflags.HaRampInterval,
+			Usage:  "Delay of the ha-ramp between connections, or batches of connections.",
+			Value:  time.Second,
+			Hidden: true,
+		}),
 		altsrc.NewDurationFlag(&cli.DurationFlag{
 			Name:   cfdflags.RpcTimeout,
 			Value:  5 * time.Second,
 			Hidden: true,
 		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   cfdflags.RpcRegisterTimeout,
+			Usage:  "Timeout of the RPC registering a connection with the edge. Defaults to --rpc-timeout.",
+			Hidden: true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   cfdflags.RpcUnregisterTimeout,
+			Usage:  "Timeout of the RPC unregistering a connection from the edge. Defaults to 5s, and never exceeds --grace-period.",
+			Hidden: true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   cfdflags.RpcLocalConfigTimeout,
+			Usage:  "Timeout of the RPC sending the local configuration to the edge. Defaults to --rpc-timeout.",
+			Hidden: true,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:   cfdflags.RpcIdempotentRetries,
+			Usage:  "Number of times idempotent RPCs to the edge, such as sending the local configuration, are retried after timing out.",
+			Value:  2,
+			Hidden: true,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.ControlStreamFallbackThreshold,
+			Usage:   "Number of consecutive registration timeouts on the QUIC control stream after which cloudflared registers the connection over an HTTP/2 control connection instead. 0 disables this behavior.",
+			EnvVars: []string{"TUNNEL_CONTROL_STREAM_FALLBACK_THRESHOLD"},
+			Value:   0,
+			Hidden:  true,
+		}),
+		altsrc.NewFloat64Flag(&cli.Float64Flag{
+			Name:    cfdflags.ProtocolFallbackSuccessRate,
+			Usage:   "Fall back to another protocol only once fewer than this fraction of the attempts of all the connections to register with the current protocol succeeded over protocol-fallback-window, rather than as soon as one connection runs out of retries. 0 falls back after the retries.",
+			EnvVars: []string{"TUNNEL_PROTOCOL_FALLBACK_SUCCESS_RATE"},
+			Value:   0.5,
+			Hidden:  true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.ProtocolFallbackWindow,
+			Usage:   "Sliding window over which the protocol-fallback-success-rate is measured.",
+			EnvVars: []string{"TUNNEL_PROTOCOL_FALLBACK_WINDOW"},
+			Value:   5 * time.Minute,
+			Hidden:  true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.ControlStreamHeartbeatInterval,
+			Usage:   "Interval between heartbeats sent over the control stream to detect an unresponsive edge. 0 disables heartbeats.",
+			EnvVars: []string{"TUNNEL_CONTROL_STREAM_HEARTBEAT_INTERVAL"},
+			Value:   0,
+			Hidden:  true,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.ControlStreamHeartbeatMaxMisses,
+			Usage:   "Number of consecutive failed control stream heartbeats after which the connection is considered dead and reconnected.",
+			EnvVars: []string{"TUNNEL_CONTROL_STREAM_HEARTBEAT_MAX_MISSES"},
+			Value:   3,
+			Hidden:  true,
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:    cfdflags.FaultInjection,
+			Usage:   "Inject a fault to exercise retries and fallbacks, only meant for tests and staging. Accepts quic-packet-drop=<rate>, origin-dial-delay=<duration>, dup-conn=<conn index>[:<count>], proxy-dial-fail=<rate> and seed=<int>.",
+			EnvVars: []string{"TUNNEL_FAULT_INJECTION"},
+			Hidden:  true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.ConnectionLeakCheck,
+			Usage:   "Warn when a closed tunnel connection still holds goroutines, streams or UDP sessions after this long. 0 disables the check.",
+			EnvVars: []string{"TUNNEL_CONNECTION_LEAK_CHECK"},
+			Value:   0,
+			Hidden:  true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.UDPSessionResumeGrace,
+			Usage:   "Keep private network UDP flows open for this long after their tunnel connection drops, so that they resume with the same origin socket once registered again on a new connection. Only flows of the QUIC datagram v3 protocol resume, v2 flows always close with their connection. 0 closes the flows with their connection.",
+			EnvVars: []string{"TUNNEL_UDP_SESSION_RESUME_GRACE"},
+			Value:   0,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.ConnectorIDFile,
+			Usage:   "Save a connector ID that stays the same across restarts in this file, generated the first time. It is added to the connection options and as stable_connector_id to the cloudflared_tunnel_info metric, and to every metric with --metrics-tunnel-labels, so that the same connector reconnecting can be recognized. Disabled if empty.",
+			EnvVars: []string{"TUNNEL_CONNECTOR_ID_FILE"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.EdgeAddrStateFile,
+			Usage:   "Remember the edge IP each connection registered on in this file, and prefer the same IPs when cloudflared restarts. This reduces duplicate connection registrations after quick restarts. Disabled if empty.",
+			EnvVars: []string{"TUNNEL_EDGE_ADDR_STATE_FILE"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.EdgeAddrStateTTL,
+			Usage:   "How long after registering on an edge IP it is preferred on restart.",
+			EnvVars: []string{"TUNNEL_EDGE_ADDR_STATE_TTL"},
+			Value:   time.Hour,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.EdgeScorecardFile,
+			Usage:   "Keep the scores of edge IPs in this file across restarts. Edge IPs are scored by handshake success rate, registration time and how long connections last, and the best scoring ones are preferred. If empty, scores are only kept in memory.",
+			EnvVars: []string{"TUNNEL_EDGE_SCORECARD_FILE"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.EdgeRegistrationLockDir,
+			Usage:   "Share lock files in this directory with the other cloudflared processes of the host, so that their connections don't register on the same edge IP, which the edge refuses as duplicate connections. Connections of a single process never share an edge IP. Only supported on Unix. Disabled if empty.",
+			EnvVars: []string{"TUNNEL_EDGE_REGISTRATION_LOCK_DIR"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.HibernateAfter,
+			Usage:   "Hibernate the tunnel after this long without requests: all connections but one are closed, and the one left pings the edge less often, which saves battery and data on rarely used tunnels. All connections are restored as soon as a request arrives. 0 disables hibernation.",
+			EnvVars: []string{"TUNNEL_HIBERNATE_AFTER"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.HibernateKeepAlive,
+			Usage:   "Keepalive period of the QUIC connection left while the tunnel hibernates.",
+			EnvVars: []string{"TUNNEL_HIBERNATE_KEEPALIVE"},
+			Value:   15 * time.Second,
+		}),
+		altsrc.NewFloat64Flag(&cli.Float64Flag{
+			Name:    cfdflags.OverloadCPU,
+			Usage:   "Consider cloudflared overloaded while it uses more than this fraction of the CPU it can use (GOMAXPROCS), e.g. 0.9. While overloaded, new streams and UDP flows are admitted at overload-stream-rate and the others refused at once, HTTP requests with a 503, so that the sessions in flight stay healthy. 0 disables the limit.",
+			EnvVars: []string{"TUNNEL_OVERLOAD_CPU"},
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.OverloadMemory,
+			Usage:   "Consider cloudflared overloaded while it holds more than this many megabytes of memory, see overload-cpu. 0 disables the limit.",
+			EnvVars: []string{"TUNNEL_OVERLOAD_MEMORY"},
+		}),
+		altsrc.NewFloat64Flag(&cli.Float64Flag{
+			Name:    cfdflags.OverloadStreamRate,
+			Usage:   "Number of new streams and UDP flows admitted per second while cloudflared is overloaded.",
+			EnvVars: []string{"TUNNEL_OVERLOAD_STREAM_RATE"},
+			Value:   10,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.WatchdogTimeout,
+			Usage:   "Watch that the connections keep answering control stream heartbeats, and report those that answered none for this long. Requires control-stream-heartbeat-interval. 0 disables the watchdog.",
+			EnvVars: []string{"TUNNEL_WATCHDOG_TIMEOUT"},
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:    cfdflags.WatchdogRestart,
+			Usage:   "Reconnect the connections the watchdog finds stalled instead of only reporting them.",
+			EnvVars: []string{"TUNNEL_WATCHDOG_RESTART"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.ClockSkewCheckInterval,
+			Usage:   "Compare the local clock with the Date headers of the Cloudflare edge at startup and this often, logging an error and reporting the clock_skew_seconds metric when it drifts, as TLS and token validation then fail. 0 disables the check.",
+			EnvVars: []string{"TUNNEL_CLOCK_SKEW_CHECK_INTERVAL"},
+			Value:   time.Hour,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.ClockSkewThreshold,
+			Usage:   "Clock skew beyond which an error is logged. At least 2s.",
+			EnvVars: []string{"TUNNEL_CLOCK_SKEW_THRESHOLD"},
+			Value:   30 * time.Second,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.ClockSkewWait,
+			Usage:   "When the clock is skewed at startup, delay the registration up to this long for it to be synced, e.g. by NTP on a device that just booted. 0 registers right away.",
+			EnvVars: []string{"TUNNEL_CLOCK_SKEW_WAIT"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.UsageFile,
+			Usage:   "Account the bytes proxied per ingress hostname and per private network, and save the totals to this file so that they add up across restarts. The totals are served by the metrics server. Disabled if empty.",
+			EnvVars: []string{"TUNNEL_USAGE_FILE"},
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:    cfdflags.UsageNetworks,
+			Usage:   "Private network CIDR, e.g. 10.0.0.0/16, that TCP, UDP and ICMP traffic to private IPs is attributed to in the usage file. Traffic is attributed to the smallest network containing its destination. Can be repeated.",
+			EnvVars: []string{"TUNNEL_USAGE_NETWORKS"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.UsageSaveInterval,
+			Usage:   "How often the bytes proxied are saved to the usage file.",
+			EnvVars: []string{"TUNNEL_USAGE_SAVE_INTERVAL"},
+			Value:   usage.DefaultSaveInterval,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.RandomSeed,
+			Usage:   "Seed the choice of edge IPs and the jitter of reconnect backoffs, so that the reconnect behavior of a run can be reproduced when debugging. 0 uses a random seed.",
+			EnvVars: []string{"TUNNEL_RANDOM_SEED"},
+			Hidden:  true,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.FirstConnectionRace,
+			Usage:   "When the tunnel starts, dial this many edge IPs in parallel for the first connection and keep the first to connect, so that blocked paths to the edge don't delay the tunnel becoming ready. Useful for short-lived tunnels, e.g. in CI. 2 or 3 is usually enough, less than 2 disables racing.",
+			EnvVars: []string{"TUNNEL_FIRST_CONNECTION_RACE"},
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.ReadyTimeout,
+			Usage:   "Exit if no connection registers within this long after starting, instead of retrying forever. The shutdown report lists the failed connection attempts with their edge IP, protocol, proxy usage and error. Useful for CI pipelines and provisioning scripts. 0 retries forever.",
+			EnvVars: []string{"TUNNEL_READY_TIMEOUT"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.ConnectionAuditFile,
+			Usage:   "Record every attempt to connect to the edge in this JSON lines file, with its time, edge IP, protocol, proxy usage, duration, result and error class, whatever the log level. Disabled if empty.",
+			EnvVars: []string{"TUNNEL_CONNECTION_AUDIT_FILE"},
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.ConnectionAuditMaxSize,
+			Usage:   "Rotate the file set by --connection-audit-file once it reaches this many megabytes.",
+			EnvVars: []string{"TUNNEL_CONNECTION_AUDIT_MAX_SIZE"},
+			Value:   10,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.ConnectionAuditMaxBackups,
+			Usage:   "Keep this many rotated files of --connection-audit-file.",
+			EnvVars: []string{"TUNNEL_CONNECTION_AUDIT_MAX_BACKUPS"},
+			Value:   3,
+		}),
 		altsrc.NewDurationFlag(&cli.DurationFlag{
 			Name:    cfdflags.WriteStreamTimeout,
 			EnvVars: []string{"TUNNEL_STREAM_WRITE_TIMEOUT"},
@@ -792,6 +1351,11 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 			Value:   0 * time.Second,
 			Hidden:  true,
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.OriginDialerPlugin,
+			Usage:   "Dial the origins through the plugin serving the origin dialer gRPC protocol at this unix socket (unix:///path/to/socket) or loopback address (127.0.0.1:port). Origins the plugin can't be reached for or declines are dialed directly.",
+			EnvVars: []string{"TUNNEL_ORIGIN_DIALER_PLUGIN"},
+		}),
 		altsrc.NewBoolFlag(&cli.BoolFlag{
 			Name:    cfdflags.QuicDisablePathMTUDiscovery,
 			EnvVars: []string{"TUNNEL_DISABLE_QUIC_PMTU"},
@@ -799,6 +1363,67 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 			Value:   false,
 			Hidden:  true,
 		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:    cfdflags.QuicMTUProbe,
+			EnvVars: []string{"TUNNEL_QUIC_MTU_PROBE"},
+			Usage:   "Probe the MTU to Cloudflare Edge before connecting with QUIC. Smaller QUIC packets are sent if it is below the default, and the fallback protocol is used if it is too small for QUIC. Linux only.",
+			Value:   false,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.QuicAssessmentInterval,
+			EnvVars: []string{"TUNNEL_QUIC_ASSESSMENT_INTERVAL"},
+			Usage:   "While the connections use HTTP2, measure this often whether QUIC would work: the QUIC handshake, packet loss and MTU to an edge IP, without registering a connection. Whether switching to QUIC is recommended is logged and reported in the quic_assessment metrics. At least 1m, 0 disables it.",
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.QuicDSCP,
+			EnvVars: []string{"TUNNEL_QUIC_DSCP"},
+			Usage:   "DSCP value (0-63) to mark the UDP packets of QUIC connections to Cloudflare Edge with, for QoS policies to prioritize or deprioritize tunnel traffic. 0 leaves them unmarked. Marking disables ECN for QUIC.",
+			Value:   0,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.HTTP2DSCP,
+			EnvVars: []string{"TUNNEL_HTTP2_DSCP"},
+			Usage:   "DSCP value (0-63) to mark the TCP packets of HTTP2 connections to Cloudflare Edge with, for QoS policies to prioritize or deprioritize tunnel traffic. 0 leaves them unmarked. Connections through --edge-proxy-url are not marked.",
+			Value:   0,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:    cfdflags.EdgeTCPFastOpen,
+			EnvVars: []string{"TUNNEL_EDGE_TCP_FAST_OPEN"},
+			Usage:   "Open HTTP2 connections to Cloudflare Edge with TCP Fast Open, which saves a round trip when reconnecting. Linux only.",
+			Value:   false,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:    cfdflags.EdgeTCPNoDelay,
+			EnvVars: []string{"TUNNEL_EDGE_TCP_NODELAY"},
+			Usage:   "Disable Nagle's algorithm on HTTP2 connections to Cloudflare Edge.",
+			Value:   true,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.EdgeTCPKeepAliveInterval,
+			EnvVars: []string{"TUNNEL_EDGE_TCP_KEEPALIVE_INTERVAL"},
+			Usage:   "How long an HTTP2 connection to Cloudflare Edge is idle before the first TCP keepalive probe, and between probes. 0 uses the default of 15s.",
+			Value:   0,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.EdgeTCPKeepAliveCount,
+			EnvVars: []string{"TUNNEL_EDGE_TCP_KEEPALIVE_COUNT"},
+			Usage:   "How many unanswered TCP keepalive probes close an HTTP2 connection to Cloudflare Edge. 0 uses the OS default.",
+			Value:   0,
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.EdgeTCPUserTimeout,
+			EnvVars: []string{"TUNNEL_EDGE_TCP_USER_TIMEOUT"},
+			Usage:   "How long data sent on an HTTP2 connection to Cloudflare Edge may stay unacknowledged before the connection is closed, to detect hung connections quickly. 0 uses the OS default. Linux only.",
+			Value:   0,
+		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:    cfdflags.EgressShaping,
+			EnvVars: []string{"TUNNEL_EGRESS_SHAPING"},
+			Usage: "Limit the rate at which a traffic class is written to Cloudflare Edge: `CLASS=RATE[;burst=SIZE]` where CLASS is control, http, tcp, udp or icmp, " +
+				"RATE is in bits per second and SIZE in bytes, with an optional k, M or G suffix, e.g. http=20M. " +
+				"Shaping bulk traffic below the uplink keeps room for the control streams, whose missed heartbeats disconnect the tunnel. " +
+				"Datagrams over the rate are dropped. The rates are shared by all the connections. Multiple classes may be specified.",
+		}),
 		altsrc.NewIntFlag(&cli.IntFlag{
 			Name:    cfdflags.QuicConnLevelFlowControlLimit,
 			EnvVars: []string{"TUNNEL_QUIC_CONN_LEVEL_FLOW_CONTROL_LIMIT"},
@@ -825,14 +1450,28 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 			EnvVars: []string{"TUNNEL_GRACE_PERIOD"},
 			Hidden:  shouldHide,
 		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.ShutdownTimeout,
+			Usage:   "Once the grace period is over, how long cloudflared waits for its servers to stop before exiting anyway, so that shutting down takes at most the grace period plus this timeout. 0 waits until they stop.",
+			Value:   time.Second * 10,
+			EnvVars: []string{"TUNNEL_SHUTDOWN_TIMEOUT"},
+			Hidden:  shouldHide,
+		}),
 		// Note TUN-3758 , we use Int because UInt is not supported with altsrc
 		altsrc.NewIntFlag(&cli.IntFlag{
-			Name:    "compression-quality",
+			Name:    cfdflags.CompressionQuality,
 			Value:   0,
-			Usage:   "(beta) Use cross-stream compression instead HTTP compression. 0-off, 1-low, 2-medium, >=3-high.",
+			Usage:   "(beta) Compress the streams of HTTP2 connections to Cloudflare Edge, to save bandwidth at the cost of CPU. 0-off, 1-low, 2-medium, >=3-high. Has no effect with QUIC.",
 			EnvVars: []string{"TUNNEL_COMPRESSION_LEVEL"},
 			Hidden:  shouldHide,
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.CompressionAlgorithm,
+			Value:   string(connection.CompressionZstd),
+			Usage:   "(beta) Algorithm to compress the streams of HTTP2 connections to Cloudflare Edge with when compression-quality is set. One of gzip or zstd.",
+			EnvVars: []string{"TUNNEL_COMPRESSION_ALGORITHM"},
+			Hidden:  shouldHide,
+		}),
 		altsrc.NewBoolFlag(&cli.BoolFlag{
 			Name:    "use-reconnect-token",
 			Usage:   "Test reestablishing connections with the new 'reconnect token' flow.",
@@ -854,6 +1493,19 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 			Hidden:  true,
 			Value:   false,
 		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:    cfdflags.ErrorReportInterval,
+			Usage:   "How often summaries of recurring errors are reported, rather than each occurrence.",
+			Value:   errorreport.DefaultInterval,
+			EnvVars: []string{"TUNNEL_ERROR_REPORT_INTERVAL"},
+			Hidden:  true,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.AuditLog,
+			Usage:   "Appends the actions affecting the running tunnel, such as configuration updates, reconnects, draining and autoupdates, to this hash-chained audit log file.",
+			EnvVars: []string{"TUNNEL_AUDIT_LOG"},
+			Hidden:  shouldHide,
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:    cfdflags.Name,
 			Aliases: []string{"n"},
@@ -887,10 +1539,16 @@ func tunnelFlags(shouldHide bool) []cli.Flag {
 		}),
 		altsrc.NewBoolFlag(&cli.BoolFlag{
 			Name:    "management-diagnostics",
-			Usage:   "Enables the in-depth diagnostic routes to be made available over the management service (/debug/pprof, /metrics, etc.)",
+			Usage:   "Enables the in-depth diagnostic routes to be made available over the management service (/debug/pprof, /metrics, /capture, /failed_requests, etc.)",
 			EnvVars: []string{"TUNNEL_MANAGEMENT_DIAGNOSTICS"},
 			Value:   true,
 		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    cfdflags.ManagementFailedRequests,
+			Usage:   "Number of the last failed requests, answered with a 5xx status, kept in memory for the /failed_requests diagnostic route of the management service. 0 keeps none.",
+			EnvVars: []string{"TUNNEL_MANAGEMENT_FAILED_REQUESTS"},
+			Value:   har.DefaultMaxFailures,
+		}),
 		selectProtocolFlag,
 		overwriteDNSFlag,
 	}...)
@@ -907,6 +1565,32 @@ func configureCloudflaredFlags(shouldHide bool) []cli.Flag {
 			Value:  config.FindDefaultConfigPath(),
 			Hidden: shouldHide,
 		},
+		&cli.StringFlag{
+			Name:    cfdflags.FleetConfigURL,
+			Usage:   "Fetch the configuration from this HTTPS endpoint instead of reading --config, identifying the connector with its connector ID (see --connector-id-file), hostname and OS. The configuration is a YAML template rendered with .ConnectorID, .Hostname, .OS, .Arch and .Version, and is cached in --fleet-config-cache to start while the endpoint is unreachable.",
+			EnvVars: []string{"TUNNEL_FLEET_CONFIG_URL"},
+			Hidden:  shouldHide,
+		},
+		&cli.StringFlag{
+			Name:    cfdflags.FleetConfigToken,
+			Usage:   "Authenticate to --fleet-config-url with this bearer token.",
+			EnvVars: []string{"TUNNEL_FLEET_CONFIG_TOKEN"},
+			Hidden:  shouldHide,
+		},
+		&cli.StringFlag{
+			Name:    cfdflags.FleetConfigCache,
+			Usage:   "Cache the configuration fetched from --fleet-config-url in this file.",
+			EnvVars: []string{"TUNNEL_FLEET_CONFIG_CACHE"},
+			Value:   filepath.Join(config.DefaultConfigSearchDirectories()[0], fleet.DefaultCacheFile),
+			Hidden:  shouldHide,
+		},
+		&cli.DurationFlag{
+			Name:    cfdflags.FleetConfigRefresh,
+			Usage:   "Fetch the configuration from --fleet-config-url again this often, and restart with exit code 12 when it changed so that the service manager runs cloudflared with it. 0 only fetches it on start.",
+			EnvVars: []string{"TUNNEL_FLEET_CONFIG_REFRESH"},
+			Value:   fleet.DefaultRefreshInterval,
+			Hidden:  shouldHide,
+		},
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:    cfdflags.OriginCert,
 			Usage:   "Path to the certificate generated for your origin when you run cloudflared login.",
@@ -927,11 +1611,17 @@ func configureCloudflaredFlags(shouldHide bool) []cli.Flag {
 			Value:   false,
 			Hidden:  shouldHide,
 		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:    cfdflags.Lockdown,
+			Usage:   "Read-only lockdown mode for appliances shipping cloudflared in a verified configuration: reject remote configurations, fleet configuration refreshes, updates, stdin control commands and the admin endpoints of the metrics server. Attempts are logged and recorded in the audit log. Remotely managed tunnels have no ingress rules in this mode.",
+			EnvVars: []string{"TUNNEL_LOCKDOWN"},
+			Hidden:  shouldHide,
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:  cfdflags.Metrics,
 			Value: metrics.GetMetricsDefaultAddress(metrics.Runtime),
 			Usage: fmt.Sprintf(
-				`Listen address for metrics reporting. If no address is passed cloudflared will try to bind to %v.
+				`Listen address for metrics reporting, unix:PATH listening on a unix socket. If no address is passed cloudflared will try to bind to %v.
 If all are unavailable, a random port will be used. Note that when running cloudflared from an virtual
 environment the default address binds to all interfaces, hence, it is important to isolate the host
 and virtualized host network stacks from each other`,
@@ -940,6 +1630,51 @@ and virtualized host network stacks from each other`,
 			EnvVars: []string{"TUNNEL_METRICS"},
 			Hidden:  shouldHide,
 		}),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name: cfdflags.MetricsListener,
+			Usage: "Also serve the metrics server on `LISTENER`: `ADDRESS[;OPTION=VALUE...]` where ADDRESS is host:port, [ipv6]:port or unix:path. " +
+				"The options family=ipv4|ipv6, auth=none, token-file, allowed-cidr (repeatable), tls-cert, tls-key and client-ca give the listener its own address family and access controls, " +
+				"otherwise it has the access controls of --metrics. Multiple listeners may be specified.",
+			EnvVars: []string{"TUNNEL_METRICS_LISTENER"},
+			Hidden:  shouldHide,
+		}),
+		hiddenStringFlag(metricsAuthTokenFlag, shouldHide),
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
+			Name:    cfdflags.MetricsAllowedCIDR,
+			Usage:   "Only allow clients from this `CIDR` to reach the metrics server. Multiple CIDRs may be specified.",
+			EnvVars: []string{"TUNNEL_METRICS_ALLOWED_CIDR"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.MetricsTLSCert,
+			Usage:   "Serve the metrics server over TLS using the certificate at `PATH`. Requires --metrics-tls-key.",
+			EnvVars: []string{"TUNNEL_METRICS_TLS_CERT"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.MetricsTLSKey,
+			Usage:   "Private key at `PATH` matching --metrics-tls-cert.",
+			EnvVars: []string{"TUNNEL_METRICS_TLS_KEY"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.MetricsClientCA,
+			Usage:   "Require clients of the metrics server to present a certificate signed by the CA at `PATH` (mTLS). Requires --metrics-tls-cert.",
+			EnvVars: []string{"TUNNEL_METRICS_CLIENT_CA"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:    cfdflags.MetricsTunnelLabels,
+			Usage:   "Add the tunnel_id, tunnel_name and connector_id labels to every metric served, so that metrics of several tunnels can be aggregated without relabeling them when scraping.",
+			EnvVars: []string{"TUNNEL_METRICS_TUNNEL_LABELS"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    cfdflags.MetricsTunnelName,
+			Usage:   "Use `NAME` as the tunnel_name label of the metrics. Defaults to the name of the tunnel run, when it's run by name.",
+			EnvVars: []string{"TUNNEL_METRICS_TUNNEL_NAME"},
+			Hidden:  shouldHide,
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:    "pidfile",
 			Usage:   "Write the application's PID to this file after first successful connection.",
@@ -949,6 +1684,13 @@ and virtualized host network stacks from each other`,
 	}
 }
 
+// hiddenStringFlag returns a copy of flag hidden when shouldHide is set, for flags shared with secretFlags.
+func hiddenStringFlag(flag *altsrc.StringFlag, shouldHide bool) *altsrc.StringFlag {
+	hidden := *flag.StringFlag
+	hidden.Hidden = shouldHide
+	return altsrc.NewStringFlag(&hidden)
+}
+
 func configureProxyFlags(shouldHide bool) []cli.Flag {
 	flags := []cli.Flag{
 		altsrc.NewStringFlag(&cli.StringFlag{
@@ -984,6 +1726,11 @@ func configureProxyFlags(shouldHide bool) []cli.Flag {
 			Value:  time.Second * 10,
 			Hidden: shouldHide,
 		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:   ingress.ProxyResponseHeaderTimeoutFlag,
+			Usage:  legacyTunnelFlag("HTTP proxy timeout for receiving the response headers once the request is sent, 0 waits indefinitely"),
+			Hidden: shouldHide,
+		}),
 		altsrc.NewDurationFlag(&cli.DurationFlag{
 			Name:   ingress.ProxyTCPKeepAliveFlag,
 			Usage:  legacyTunnelFlag("HTTP proxy TCP keepalive duration"),
@@ -1062,6 +1809,26 @@ func configureProxyFlags(shouldHide bool) []cli.Flag {
 			Hidden:  shouldHide,
 			Value:   false,
 		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:    ingress.Http3OriginFlag,
+			Usage:   "Enables HTTP/3 to https origin servers, falling back to HTTP/2 or HTTP/1.1 when they can't be reached over QUIC.",
+			EnvVars: []string{"TUNNEL_ORIGIN_ENABLE_HTTP3"},
+			Hidden:  shouldHide,
+			Value:   false,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    ingress.RequestBodyBufferDirFlag,
+			Usage:   "Buffers request bodies to a temporary file in this directory, so that origins reading them slowly don't stall the edge.",
+			EnvVars: []string{"TUNNEL_REQUEST_BODY_BUFFER_DIR"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:    ingress.RequestBodyBufferMaxSizeFlag,
+			Usage:   "Maximum bytes of a request body buffered to disk when request-body-buffer-dir is set.",
+			EnvVars: []string{"TUNNEL_REQUEST_BODY_BUFFER_MAX_SIZE"},
+			Hidden:  shouldHide,
+			Value:   64 * (1 << 20), // 64 MB
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:    cfdflags.ManagementHostname,
 			Usage:   "Management hostname to signify incoming management requests",
@@ -1232,10 +1999,30 @@ func configureProxyDNSFlags(shouldHide bool) []cli.Flag {
 			EnvVars: []string{"TUNNEL_DNS_BOOTSTRAP"},
 			Hidden:  shouldHide,
 		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "proxy-dns-upstream-policy",
+			Usage:   "Order the upstreams are tried in: fallback (as configured), random, or fastest (lowest recent latency first). Upstreams failing repeatedly are tried last.",
+			Value:   string(tunneldns.PolicyFallback),
+			EnvVars: []string{"TUNNEL_DNS_UPSTREAM_POLICY"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "proxy-dns-ecs",
+			Usage:   "EDNS client subnet of the queries forwarded upstream: passthrough, strip, or a subnet (e.g. 192.0.2.0/24) sent instead of the client one.",
+			Value:   tunneldns.ECSPassthrough,
+			EnvVars: []string{"TUNNEL_DNS_ECS"},
+			Hidden:  shouldHide,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "proxy-dns-blocklist",
+			Usage:   "File of domains answered with NXDOMAIN along with their subdomains, one per line or in the hosts file format.",
+			EnvVars: []string{"TUNNEL_DNS_BLOCKLIST"},
+			Hidden:  shouldHide,
+		}),
 	}
 }
 
-func stdinControl(reconnectCh chan supervisor.ReconnectSignal, log *zerolog.Logger) {
+func stdinControl(reconnectCh chan supervisor.ReconnectSignal, auditLog *audit.Log, lock *lockdown.Lockdown, log *zerolog.Logger) {
 	for {
 		scanner := bufio.NewScanner(os.Stdin)
 		for scanner.Scan() {
@@ -1246,16 +2033,22 @@ func stdinControl(reconnectCh chan supervisor.ReconnectSignal, log *zerolog.Logg
 			case "":
 				break
 			case "reconnect":
+				if err := lock.Check(audit.ActionReconnect, audit.ActorLocal, map[string]string{"command": command}); err != nil {
+					log.Error().Msg(err.Error())
+					continue
+				}
 				var reconnect supervisor.ReconnectSignal
 				if len(parts) > 1 {
 					var err error
 					if reconnect.Delay, err = time.ParseDuration(parts[1]); err != nil {
 						log.Error().Msg(err.Error())
+						auditLog.Record(audit.ActionReconnect, audit.ActorLocal, err, map[string]string{"command": command})
 						continue
 					}
 				}
 				log.Info().Msgf("Sending %+v", reconnect)
 				reconnectCh <- reconnect
+				auditLog.Record(audit.ActionReconnect, audit.ActorLocal, nil, map[string]string{"command": command})
 			default:
 				log.Info().Str(LogFieldCommand, command).Msg("Unknown command")
 				fallthrough
@@ -1285,7 +2078,7 @@ func nonSecretCliFlags(log *zerolog.Logger, cli *cli.Context, flagInclusionList
 		}
 
 		switch flag {
-		case cfdflags.LogDirectory, cfdflags.LogFile:
+		case cfdflags.LogDirectory, cfdflags.LogFile, cfdflags.TransportLogDirectory, cfdflags.TransportLogFile:
 			{
 				absolute, err := filepath.Abs(value)
 				if err != nil {
@@ -1294,6 +2087,9 @@ func nonSecretCliFlags(log *zerolog.Logger, cli *cli.Context, flagInclusionList
 					flags[flag] = absolute
 				}
 			}
+		case cfdflags.EdgeProxyURL, cfdflags.EdgeProxyPAC:
+			// The proxy URL may still carry the proxy credentials as userinfo
+			flags[flag] = redact.String(value)
 		default:
 			flags[flag] = value
 		}
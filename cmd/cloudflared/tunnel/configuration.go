@@ -3,18 +3,26 @@ package tunnel
 import (
 	"context"
 	"crypto/tls"
+	"encoding/base64"
 	"fmt"
+	"math"
 	"net"
 	"net/netip"
+	"net/url"
 	"os"
 	"strings"
 	"time"
 
+	"github.com/facebookgo/grace/gracenet"
+	"github.com/google/uuid"
+	homedir "github.com/mitchellh/go-homedir"
 	"github.com/pkg/errors"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
 	"github.com/urfave/cli/v2"
 	"github.com/urfave/cli/v2/altsrc"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/proxy"
 	"golang.org/x/term"
 
 	"github.com/cloudflare/cloudflared/client"
@@ -24,22 +32,30 @@ import (
 	"github.com/cloudflare/cloudflared/connection"
 	"github.com/cloudflare/cloudflared/edgediscovery"
 	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+	"github.com/cloudflare/cloudflared/edgediscovery/pac"
+	"github.com/cloudflare/cloudflared/faultinject"
 	"github.com/cloudflare/cloudflared/features"
 	"github.com/cloudflare/cloudflared/ingress"
 	"github.com/cloudflare/cloudflared/ingress/origins"
+	"github.com/cloudflare/cloudflared/maintenance"
+	"github.com/cloudflare/cloudflared/metrics"
 	"github.com/cloudflare/cloudflared/orchestration"
+	"github.com/cloudflare/cloudflared/redact"
 	"github.com/cloudflare/cloudflared/supervisor"
 	"github.com/cloudflare/cloudflared/tlsconfig"
+	"github.com/cloudflare/cloudflared/tunnelrpc"
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
 )
 
 const (
 	secretValue       = "*****"
 	icmpFunnelTimeout = time.Second * 10
+	// maxHibernateKeepAlive bounds the keepalive period of the connection left while the tunnel hibernates
+	maxHibernateKeepAlive = time.Minute
 )
 
 var (
-	secretFlags = [2]*altsrc.StringFlag{credentialsContentsFlag, tunnelTokenFlag}
+	secretFlags = [4]*altsrc.StringFlag{credentialsContentsFlag, tunnelTokenFlag, metricsAuthTokenFlag, edgeProxyPasswordFlag}
 
 	configFlags = []string{
 		flags.AutoUpdateFreq,
@@ -100,6 +116,16 @@ func isSecretFlag(key string) bool {
 	return false
 }
 
+// redactSecrets keeps the values of secret flags and the tunnel secret out of logs, errors and diagnostics.
+func redactSecrets(c *cli.Context, namedTunnel *connection.TunnelProperties) {
+	for _, flag := range secretFlags {
+		redact.AddSecret(c.String(flag.Name))
+	}
+	if namedTunnel != nil && len(namedTunnel.Credentials.TunnelSecret) > 0 {
+		redact.AddSecret(base64.StdEncoding.EncodeToString(namedTunnel.Credentials.TunnelSecret))
+	}
+}
+
 func isSecretEnvVar(key string) bool {
 	for _, flag := range secretFlags {
 		for _, secretEnvVar := range flag.EnvVars {
@@ -111,6 +137,35 @@ func isSecretEnvVar(key string) bool {
 	return false
 }
 
+// protocolScheduler wraps selector to force the protocols of the protocolSchedule rules of the configuration file.
+func protocolScheduler(selector connection.ProtocolSelector, schedule []config.ProtocolScheduleRule, pqMode features.PostQuantumMode) (connection.ProtocolSelector, error) {
+	rules := make([]connection.ProtocolScheduleRule, 0, len(schedule))
+	for i, r := range schedule {
+		rule, err := connection.NewProtocolScheduleRule(r.Schedule, r.Duration.Duration, r.Protocol)
+		if err != nil {
+			return nil, fmt.Errorf("rule #%d: %w", i+1, err)
+		}
+		if pqMode == features.PostQuantumStrict && r.Protocol != connection.QUIC.String() {
+			return nil, fmt.Errorf("rule #%d: post-quantum is only supported with the quic transport", i+1)
+		}
+		rules = append(rules, rule)
+	}
+	return connection.NewScheduledProtocolSelector(selector, rules)
+}
+
+// maintenanceWindows parses the maintenanceWindows of the configuration file, returns nil if there are none.
+func maintenanceWindows(windows []config.MaintenanceWindow) (*maintenance.Windows, error) {
+	parsed := make([]connection.ScheduleWindow, 0, len(windows))
+	for i, w := range windows {
+		window, err := connection.NewScheduleWindow(w.Schedule, w.Duration.Duration)
+		if err != nil {
+			return nil, fmt.Errorf("window #%d: %w", i+1, err)
+		}
+		parsed = append(parsed, window)
+	}
+	return maintenance.New(parsed), nil
+}
+
 func dnsProxyStandAlone(c *cli.Context, namedTunnel *connection.TunnelProperties) bool {
 	return c.IsSet(flags.ProxyDns) &&
 		!(c.IsSet(flags.Name) || // adhoc-named tunnel
@@ -128,7 +183,21 @@ func prepareTunnelConfig(
 ) (*supervisor.TunnelConfig, *orchestration.Config, error) {
 	transportProtocol := c.String(flags.Protocol)
 	isPostQuantumEnforced := c.Bool(flags.PostQuantum)
-	featureSelector, err := features.NewFeatureSelector(ctx, namedTunnel.Credentials.AccountTag, c.StringSlice(flags.Features), isPostQuantumEnforced, log)
+	cliFeatures := c.StringSlice(flags.Features)
+	if transportProtocol == connection.HybridFlag {
+		cliFeatures = append(cliFeatures, features.FeatureHybridProtocols)
+	}
+	featureOverrides := features.Overrides{
+		Enable:  config.GetConfiguration().FeatureOverrides.Enable,
+		Disable: config.GetConfiguration().FeatureOverrides.Disable,
+	}
+	strictEgress := c.Bool(flags.StrictEgress)
+	newFeatureSelector := features.NewFeatureSelector
+	if strictEgress {
+		// The features aren't looked up in DNS, which wouldn't go through the edge proxy
+		newFeatureSelector = features.NewLocalFeatureSelector
+	}
+	featureSelector, err := newFeatureSelector(ctx, namedTunnel.Credentials.AccountTag, cliFeatures, isPostQuantumEnforced, featureOverrides, log)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "Failed to create feature selector")
 	}
@@ -139,12 +208,26 @@ func prepareTunnelConfig(
 	}
 
 	log.Info().Msgf("Generated Connector ID: %s", clientConfig.ConnectorID)
+	if path := c.String(flags.ConnectorIDFile); path != "" {
+		if clientConfig.StableConnectorID, err = client.LoadStableConnectorID(path); err != nil {
+			return nil, nil, err
+		}
+		log.Info().Msgf("Stable Connector ID: %s", clientConfig.StableConnectorID)
+	}
 
 	tags, err := NewTagSliceFromCLI(c.StringSlice(flags.Tag))
 	if err != nil {
 		log.Err(err).Msg("Tag parse failure")
 		return nil, nil, errors.Wrap(err, "Tag parse failure")
 	}
+	metadata, err := newInstanceMetadata(c.String(flags.TagMetadata))
+	if err != nil {
+		return nil, nil, err
+	}
+	if tags, err = expandTagTemplates(tags, metadata); err != nil {
+		log.Err(err).Msg("Tag template failure")
+		return nil, nil, errors.Wrap(err, "Tag template failure")
+	}
 	tags = append(tags, pogs.Tag{Name: "ID", Value: clientConfig.ConnectorID.String()})
 
 	clientFeatures := featureSelector.Snapshot()
@@ -163,10 +246,23 @@ func prepareTunnelConfig(
 		return nil, nil, err
 	}
 
-	protocolSelector, err := connection.NewProtocolSelector(transportProtocol, namedTunnel.Credentials.AccountTag, c.IsSet(TunnelTokenFlag), isPostQuantumEnforced, edgediscovery.ProtocolPercentage, connection.ResolveTTL, log)
+	protocolFetcher := edgediscovery.ProtocolPercentage
+	if strictEgress {
+		if err := validateStrictEgress(c, transportProtocol, cfg.ProtocolSchedule); err != nil {
+			return nil, nil, err
+		}
+		// The protocol percentages aren't looked up in DNS either, the protocol is always HTTP/2
+		protocolFetcher = func() (edgediscovery.ProtocolPercents, error) { return nil, nil }
+	}
+	protocolSelector, err := connection.NewProtocolSelector(transportProtocol, namedTunnel.Credentials.AccountTag, c.IsSet(TunnelTokenFlag), isPostQuantumEnforced, protocolFetcher, connection.ResolveTTL, log)
 	if err != nil {
 		return nil, nil, err
 	}
+	if schedule := config.GetConfiguration().ProtocolSchedule; len(schedule) > 0 {
+		if protocolSelector, err = protocolScheduler(protocolSelector, schedule, pqMode); err != nil {
+			return nil, nil, errors.Wrap(err, "invalid protocolSchedule")
+		}
+	}
 	log.Info().Msgf("Initial protocol %s", protocolSelector.Current())
 
 	edgeTLSConfigs := make(map[connection.Protocol]*tls.Config, len(connection.ProtocolList))
@@ -193,6 +289,17 @@ func prepareTunnelConfig(
 	if err != nil {
 		return nil, nil, err
 	}
+	edgeRotation, err := parseEdgeAddrRotation(c.String(flags.EdgeAddrRotation))
+	if err != nil {
+		return nil, nil, err
+	}
+	rampStrategy, err := supervisor.ParseRampStrategy(c.String(flags.HaRamp))
+	if err != nil {
+		return nil, nil, err
+	}
+	if c.Duration(flags.HaRampInterval) <= 0 {
+		return nil, nil, fmt.Errorf("%s must be positive", flags.HaRampInterval)
+	}
 	edgeBindAddr, err := parseConfigBindAddress(c.String(flags.EdgeBindAddress))
 	if err != nil {
 		return nil, nil, err
@@ -200,11 +307,58 @@ func prepareTunnelConfig(
 	if err := testIPBindable(edgeBindAddr); err != nil {
 		return nil, nil, fmt.Errorf("invalid edge-bind-address %s: %v", edgeBindAddr, err)
 	}
+	edgeProxyURL, edgeProxyAuth, err := edgeProxy(c, log)
+	if err != nil {
+		return nil, nil, err
+	}
+	edgeProxySSH, err := edgeProxyJumpHost(c, edgeProxyURL)
+	if err != nil {
+		return nil, nil, err
+	}
+	edgeProxyTLS, err := parseEdgeProxyTLS(c.StringSlice(flags.EdgeProxyTLS))
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid %s: %w", flags.EdgeProxyTLS, err)
+	}
+	var edgeProxyPAC *pac.Selector
+	if location := c.String(flags.EdgeProxyPAC); location != "" {
+		if edgeProxyPAC, err = pac.NewSelector(ctx, location, log); err != nil {
+			return nil, nil, fmt.Errorf("invalid %s: %s", flags.EdgeProxyPAC, redact.Error(err))
+		}
+	}
+	quicDSCP, err := parseDSCP(c, flags.QuicDSCP)
+	if err != nil {
+		return nil, nil, err
+	}
+	http2Compression, err := parseHTTP2Compression(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	edgeTCPOptions, err := parseEdgeTCPOptions(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	egressShaper, err := parseEgressShaping(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	observer.SetEgressShaper(egressShaper)
 	edgeIPVersion, err = adjustIPVersionByBindAddress(edgeIPVersion, edgeBindAddr)
 	if err != nil {
 		// This is not a fatal error, we just overrode edgeIPVersion
 		log.Warn().Str("edgeIPVersion", edgeIPVersion.String()).Err(err).Msg("Overriding edge-ip-version")
 	}
+	// An explicit edge-ip-version or bind address overrides the detection
+	if edgeBindAddr == nil && (!c.IsSet(flags.EdgeIpVersion) || edgeIPVersion == allregions.Auto) {
+		edgeIPVersion = detectEdgeIPVersion(edgeIPVersion, edgediscovery.DetectIPRoutes(), log)
+	}
+
+	var edgeHosts *allregions.EdgeHosts
+	if path := c.String(flags.EdgeHostsFile); path != "" {
+		if edgeHosts, err = allregions.LoadEdgeHosts(path); err != nil {
+			return nil, nil, err
+		}
+		log.Warn().Str("path", path).Msg("Resolving the edge from the edge hosts file instead of DNS")
+	}
 
 	region := c.String(flags.Region)
 	endpoint := namedTunnel.Credentials.Endpoint
@@ -222,11 +376,27 @@ func prepareTunnelConfig(
 
 	warpRoutingConfig := ingress.NewWarpRoutingConfig(&cfg.WarpRouting)
 
-	// Setup origin dialer service and virtual services
-	originDialerService := ingress.NewOriginDialer(ingress.OriginConfig{
+	faults, err := faultInjector(c, log)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	originConfig := ingress.OriginConfig{
 		DefaultDialer:   ingress.NewDialer(warpRoutingConfig),
 		TCPWriteTimeout: c.Duration(flags.WriteStreamTimeout),
-	}, log)
+		Faults:          faults,
+	}
+	if target := c.String(flags.OriginDialerPlugin); target != "" {
+		plugin, err := origins.NewPluginDialer(target)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s provided: %w", flags.OriginDialerPlugin, err)
+		}
+		log.Info().Msgf("Dialing the origins through the origin dialer plugin %s", target)
+		originConfig.Plugin = plugin
+	}
+
+	// Setup origin dialer service and virtual services
+	originDialerService := ingress.NewOriginDialer(originConfig, log)
 
 	// Setup DNS Resolver Service
 	originMetrics := origins.NewMetrics(prometheus.DefaultRegisterer)
@@ -241,14 +411,59 @@ func prepareTunnelConfig(
 	}
 	originDialerService.AddReservedService(dnsService, []netip.AddrPort{origins.VirtualDNSServiceAddr})
 
+	// The edge closes connections that don't ping it for too long
+	if c.Duration(flags.HibernateAfter) > 0 && (c.Duration(flags.HibernateKeepAlive) < time.Second || c.Duration(flags.HibernateKeepAlive) > maxHibernateKeepAlive) {
+		return nil, nil, fmt.Errorf("%s must be between 1s and %s", flags.HibernateKeepAlive, maxHibernateKeepAlive)
+	}
+	if overloadCPU := c.Float64(flags.OverloadCPU); overloadCPU < 0 || overloadCPU > 1 {
+		return nil, nil, fmt.Errorf("%s must be a fraction between 0 and 1", flags.OverloadCPU)
+	}
+	if c.Int(flags.OverloadMemory) < 0 {
+		return nil, nil, fmt.Errorf("%s can't be negative", flags.OverloadMemory)
+	}
+	if c.Float64(flags.OverloadStreamRate) < 0 {
+		return nil, nil, fmt.Errorf("%s can't be negative", flags.OverloadStreamRate)
+	}
+	if successRate := c.Float64(flags.ProtocolFallbackSuccessRate); successRate < 0 || successRate > 1 {
+		return nil, nil, fmt.Errorf("%s must be a fraction between 0 and 1", flags.ProtocolFallbackSuccessRate)
+	}
+	if interval := c.Duration(flags.QuicAssessmentInterval); interval != 0 && interval < supervisor.MinQUICAssessmentInterval {
+		return nil, nil, fmt.Errorf("%s must be at least %s", flags.QuicAssessmentInterval, supervisor.MinQUICAssessmentInterval)
+	}
+	if threshold := c.Duration(flags.ClockSkewThreshold); threshold < supervisor.MinClockSkewThreshold {
+		return nil, nil, fmt.Errorf("%s must be at least %s", flags.ClockSkewThreshold, supervisor.MinClockSkewThreshold)
+	}
+	if c.Int(flags.ConnectionAuditMaxSize) <= 0 {
+		return nil, nil, fmt.Errorf("%s must be positive", flags.ConnectionAuditMaxSize)
+	}
+	if c.Int(flags.ConnectionAuditMaxBackups) < 0 {
+		return nil, nil, fmt.Errorf("%s can't be negative", flags.ConnectionAuditMaxBackups)
+	}
+
+	// A connection would be reported stalled between two heartbeats
+	if watchdogTimeout := c.Duration(flags.WatchdogTimeout); watchdogTimeout > 0 && watchdogTimeout <= c.Duration(flags.ControlStreamHeartbeatInterval) {
+		return nil, nil, fmt.Errorf("%s must be longer than %s", flags.WatchdogTimeout, flags.ControlStreamHeartbeatInterval)
+	}
+
+	controlStreamHeartbeat := connection.ControlStreamHeartbeat{
+		Interval:  c.Duration(flags.ControlStreamHeartbeatInterval),
+		MaxMisses: uint(c.Int(flags.ControlStreamHeartbeatMaxMisses)), // nolint: gosec
+	}
+
 	tunnelConfig := &supervisor.TunnelConfig{
 		ClientConfig:    clientConfig,
 		GracePeriod:     gracePeriod,
 		EdgeAddrs:       c.StringSlice(flags.Edge),
 		Region:          resolvedRegion,
 		EdgeIPVersion:   edgeIPVersion,
+		EdgeRotation:    edgeRotation,
+		EdgeHosts:       edgeHosts,
 		EdgeBindAddr:    edgeBindAddr,
-		EdgeProxyURL:    c.String(flags.EdgeProxyURL),
+		EdgeProxyURL:    edgeProxyURL,
+		EdgeProxyAuth:   edgeProxyAuth,
+		EdgeProxyTLS:    edgeProxyTLS,
+		EdgeProxySSH:    edgeProxySSH,
+		EdgeProxyPAC:    edgeProxyPAC,
 		HAConnections:   c.Int(flags.HaConnections),
 		IsAutoupdated:   c.Bool(flags.IsAutoUpdated),
 		LBPool:          c.String(flags.LBPool),
@@ -265,16 +480,54 @@ func prepareTunnelConfig(
 		EdgeTLSConfigs:                      edgeTLSConfigs,
 		MaxEdgeAddrRetries:                  uint8(c.Int(flags.MaxEdgeAddrRetries)), // nolint: gosec
 		RPCTimeout:                          c.Duration(flags.RpcTimeout),
+		RegistrationTimeouts:                registrationTimeouts(c),
+		ControlStreamFallbackThreshold:      uint(c.Int(flags.ControlStreamFallbackThreshold)), // nolint: gosec
+		ControlStreamHeartbeat:              controlStreamHeartbeat,
+		FaultInjector:                       faults,
+		ConnectionLeakCheck:                 c.Duration(flags.ConnectionLeakCheck),
+		UDPSessionResumeGrace:               c.Duration(flags.UDPSessionResumeGrace),
+		EdgeAddrStateFile:                   c.String(flags.EdgeAddrStateFile),
+		EdgeAddrStateTTL:                    c.Duration(flags.EdgeAddrStateTTL),
+		EdgeScorecardFile:                   c.String(flags.EdgeScorecardFile),
+		EdgeRegistrationLockDir:             c.String(flags.EdgeRegistrationLockDir),
+		FirstConnectionRace:                 c.Int(flags.FirstConnectionRace),
+		RandomSeed:                          int64(c.Int(flags.RandomSeed)),
+		HibernateAfter:                      c.Duration(flags.HibernateAfter),
+		HibernateKeepAlive:                  c.Duration(flags.HibernateKeepAlive),
+		OverloadCPU:                         c.Float64(flags.OverloadCPU),
+		OverloadMemory:                      uint64(c.Int(flags.OverloadMemory)) << 20,
+		OverloadStreamRate:                  c.Float64(flags.OverloadStreamRate),
+		ProtocolFallbackSuccessRate:         c.Float64(flags.ProtocolFallbackSuccessRate),
+		ProtocolFallbackWindow:              c.Duration(flags.ProtocolFallbackWindow),
+		RegistrationRamp:                    supervisor.RegistrationRamp{Strategy: rampStrategy, Interval: c.Duration(flags.HaRampInterval)},
+		WatchdogTimeout:                     c.Duration(flags.WatchdogTimeout),
+		WatchdogRestart:                     c.Bool(flags.WatchdogRestart),
+		ReadyTimeout:                        c.Duration(flags.ReadyTimeout),
+		ConnectionAuditFile:                 c.String(flags.ConnectionAuditFile),
+		ConnectionAuditMaxSize:              c.Int(flags.ConnectionAuditMaxSize),
+		ConnectionAuditMaxBackups:           c.Int(flags.ConnectionAuditMaxBackups),
+		ReconnectPreparer:                   supervisor.NewReconnectPreparer(),
+		ClockSkewCheckURL:                   clockSkewCheckURL(c),
+		ClockSkewCheckInterval:              c.Duration(flags.ClockSkewCheckInterval),
+		ClockSkewThreshold:                  c.Duration(flags.ClockSkewThreshold),
+		ClockSkewWait:                       c.Duration(flags.ClockSkewWait),
 		WriteStreamTimeout:                  c.Duration(flags.WriteStreamTimeout),
 		DisableQUICPathMTUDiscovery:         c.Bool(flags.QuicDisablePathMTUDiscovery),
 		QUICConnectionLevelFlowControlLimit: c.Uint64(flags.QuicConnLevelFlowControlLimit),
 		QUICStreamLevelFlowControlLimit:     c.Uint64(flags.QuicStreamLevelFlowControlLimit),
+		QUICDSCP:                            quicDSCP,
+		QUICMTUProbe:                        c.Bool(flags.QuicMTUProbe),
+		QUICAssessmentInterval:              c.Duration(flags.QuicAssessmentInterval),
+		EdgeTCPOptions:                      edgeTCPOptions,
+		EdgeProxyDirectFallback:             c.Bool(flags.EdgeProxyDirectFallback),
+		HTTP2Compression:                    http2Compression,
 		OriginDNSService:                    dnsService,
 		OriginDialerService:                 originDialerService,
 	}
 	icmpRouter, err := newICMPRouter(c, log)
 	if err != nil {
 		log.Warn().Err(err).Msg("ICMP proxy feature is disabled")
+		ingress.DisableICMPProxy(err.Error())
 	} else {
 		tunnelConfig.ICMPRouterServer = icmpRouter
 	}
@@ -299,6 +552,350 @@ func parseConfigFlags(c *cli.Context) map[string]string {
 	return result
 }
 
+func metricsAuthConfig(c *cli.Context) (metrics.AuthConfig, error) {
+	allowedCIDRs, err := metrics.ParseAllowedCIDRs(c.StringSlice(flags.MetricsAllowedCIDR))
+	if err != nil {
+		return metrics.AuthConfig{}, err
+	}
+	authConfig := metrics.AuthConfig{
+		BearerToken:  c.String(flags.MetricsAuthToken),
+		AllowedCIDRs: allowedCIDRs,
+	}
+
+	authConfig.TLSConfig, err = metricsTLSConfig(
+		c.String(flags.MetricsTLSCert), c.String(flags.MetricsTLSKey), c.String(flags.MetricsClientCA),
+		flags.MetricsTLSCert, flags.MetricsTLSKey, flags.MetricsClientCA,
+	)
+	if err != nil {
+		return metrics.AuthConfig{}, err
+	}
+	return authConfig, nil
+}
+
+// metricsTLSConfig loads the TLS configuration of a metrics listener, nil without a certificate. The names of the
+// settings are used in the errors.
+func metricsTLSConfig(certPath, keyPath, clientCA, certName, keyName, clientCAName string) (*tls.Config, error) {
+	if (certPath == "") != (keyPath == "") {
+		return nil, fmt.Errorf("%s and %s must be provided together", certName, keyName)
+	}
+	if certPath == "" {
+		if clientCA != "" {
+			return nil, fmt.Errorf("%s requires %s and %s", clientCAName, certName, keyName)
+		}
+		return nil, nil
+	}
+
+	params := &tlsconfig.TLSParameters{
+		Cert:       certPath,
+		Key:        keyPath,
+		MinVersion: tls.VersionTLS12,
+	}
+	if clientCA != "" {
+		params.ClientCAs = []string{clientCA}
+	}
+	tlsConfig, err := tlsconfig.GetConfig(params)
+	if err != nil {
+		return nil, errors.Wrap(err, "error loading metrics server TLS configuration")
+	}
+	return tlsConfig, nil
+}
+
+// metricsListener is an additional listener of the metrics server.
+type metricsListener struct {
+	listener net.Listener
+	auth     metrics.AuthConfig
+}
+
+// openMetricsListeners opens the listeners of --metrics-listener. The listeners opened before an error are returned
+// along with it, for the caller to close them.
+func openMetricsListeners(c *cli.Context, listeners *gracenet.Net, mainAuth metrics.AuthConfig) ([]metricsListener, error) {
+	var opened []metricsListener
+	for _, value := range c.StringSlice(flags.MetricsListener) {
+		spec, err := metrics.ParseListenerSpec(value)
+		if err != nil {
+			return opened, err
+		}
+		auth, err := metricsListenerAuth(spec, mainAuth)
+		if err != nil {
+			return opened, err
+		}
+		listener, err := spec.Listen(listeners)
+		if err != nil {
+			return opened, err
+		}
+		opened = append(opened, metricsListener{listener: listener, auth: auth})
+	}
+	return opened, nil
+}
+
+// metricsListenerAuth returns the access controls of an additional listener of the metrics server.
+func metricsListenerAuth(spec metrics.ListenerSpec, mainAuth metrics.AuthConfig) (metrics.AuthConfig, error) {
+	if spec.Inherit {
+		return spec.InheritedAuth(mainAuth), nil
+	}
+	allowedCIDRs, err := metrics.ParseAllowedCIDRs(spec.AllowedCIDRs)
+	if err != nil {
+		return metrics.AuthConfig{}, err
+	}
+	auth := metrics.AuthConfig{AllowedCIDRs: allowedCIDRs}
+	if spec.TokenFile != "" {
+		token, err := os.ReadFile(spec.TokenFile)
+		if err != nil {
+			return metrics.AuthConfig{}, errors.Wrap(err, "error reading the token of the metrics listener")
+		}
+		if auth.BearerToken = strings.TrimSpace(string(token)); auth.BearerToken == "" {
+			return metrics.AuthConfig{}, fmt.Errorf("the token file %s of the metrics listener is empty", spec.TokenFile)
+		}
+	}
+	auth.TLSConfig, err = metricsTLSConfig(spec.TLSCert, spec.TLSKey, spec.ClientCA, "tls-cert", "tls-key", "client-ca")
+	if err != nil {
+		return metrics.AuthConfig{}, err
+	}
+	return auth, nil
+}
+
+// metricsTunnelName returns the tunnel_name label of the metrics: --metrics-tunnel-name, or else the name of the
+// tunnel when it's run by name. Tunnels run by ID or with a token don't know their name.
+func metricsTunnelName(c *cli.Context) string {
+	if name := c.String(flags.MetricsTunnelName); name != "" {
+		return name
+	}
+	if name := c.String(flags.Name); name != "" {
+		return name
+	}
+	tunnelRef := c.Args().First()
+	if tunnelRef == "" {
+		tunnelRef = config.GetConfiguration().TunnelID
+	}
+	if _, err := uuid.Parse(tunnelRef); err == nil {
+		return ""
+	}
+	return tunnelRef
+}
+
+// edgeProxy returns the SOCKS5 or HTTP CONNECT proxy to connect to the edge through, if any, and the credentials to
+// authenticate with it or with the proxies selected by --edge-proxy-pac. The credentials come from
+// --edge-proxy-username and --edge-proxy-password, or else from --edge-proxy-credentials-file, or else from the
+// userinfo of the proxy URL, which is removed from the returned URL so that it doesn't end up in logs.
+func edgeProxy(c *cli.Context, log *zerolog.Logger) (string, *proxy.Auth, error) {
+	proxyURL := c.String(flags.EdgeProxyURL)
+	if proxyURL != "" && c.String(flags.EdgeProxyPAC) != "" {
+		return "", nil, fmt.Errorf("%s and %s can't be used together", flags.EdgeProxyURL, flags.EdgeProxyPAC)
+	}
+	if proxyURL == "" && c.String(flags.EdgeProxyPAC) == "" {
+		return "", nil, nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid %s: %s", flags.EdgeProxyURL, redact.Error(err))
+	}
+
+	var auth *proxy.Auth
+	if username := c.String(flags.EdgeProxyUsername); username != "" {
+		auth = &proxy.Auth{User: username, Password: c.String(flags.EdgeProxyPassword)}
+	} else if path := c.String(flags.EdgeProxyCredentialsFile); path != "" {
+		if auth, err = readEdgeProxyCredentials(path); err != nil {
+			return "", nil, err
+		}
+	} else if u.User != nil {
+		auth = &proxy.Auth{User: u.User.Username()}
+		var hasPassword bool
+		if auth.Password, hasPassword = u.User.Password(); hasPassword {
+			log.Warn().Msgf("Credentials in %s end up in process lists and configuration files, use %s and %s or %s instead",
+				flags.EdgeProxyURL, flags.EdgeProxyUsername, flags.EdgeProxyPassword, flags.EdgeProxyCredentialsFile)
+		}
+	}
+	if auth != nil {
+		redact.AddSecret(auth.Password)
+	}
+	if proxyURL == "" {
+		return "", auth, nil
+	}
+	u.User = nil
+	return u.String(), auth, nil
+}
+
+// validateStrictEgress returns why the traffic to the edge wouldn't all go through the edge proxy with
+// --strict-egress: the edge proxy only proxies TCP, so QUIC goes around it, as do the connections falling back to
+// direct ones when the proxy fails and the DNS lookups of edge discovery.
+func validateStrictEgress(c *cli.Context, transportProtocol string, schedule []config.ProtocolScheduleRule) error {
+	if c.String(flags.EdgeProxyURL) == "" {
+		return fmt.Errorf("%s requires %s", flags.StrictEgress, flags.EdgeProxyURL)
+	}
+	if transportProtocol != connection.HTTP2.String() {
+		return fmt.Errorf("%s requires --%s %s, the edge proxy doesn't proxy the UDP of QUIC", flags.StrictEgress, flags.Protocol, connection.HTTP2)
+	}
+	for _, rule := range schedule {
+		if rule.Protocol != connection.HTTP2.String() {
+			return fmt.Errorf("%s doesn't allow protocolSchedule rules switching to %s, the edge proxy only proxies %s", flags.StrictEgress, rule.Protocol, connection.HTTP2)
+		}
+	}
+	if c.Bool(flags.EdgeProxyDirectFallback) {
+		return fmt.Errorf("%s requires %s=false, connections would otherwise go around the edge proxy when it fails", flags.StrictEgress, flags.EdgeProxyDirectFallback)
+	}
+	if edgeAddrs := c.StringSlice(flags.Edge); len(edgeAddrs) > 0 {
+		for _, addr := range edgeAddrs {
+			host, _, err := net.SplitHostPort(addr)
+			if err != nil || net.ParseIP(host) == nil {
+				return fmt.Errorf("%s requires the %s addresses to be IP addresses, %s would be looked up in DNS", flags.StrictEgress, flags.Edge, addr)
+			}
+		}
+	} else if c.String(flags.EdgeHostsFile) == "" {
+		return fmt.Errorf("%s requires %s or %s with IP addresses, the edge would otherwise be looked up in DNS", flags.StrictEgress, flags.EdgeHostsFile, flags.Edge)
+	}
+	return nil
+}
+
+// parseEdgeProxyTLS parses the TLS settings of the https edge proxies, formatted as
+// PROXY;cert=PATH;key=PATH;ca=PATH;server-name=NAME where PROXY is the host:port of the proxy or * for any proxy.
+func parseEdgeProxyTLS(specs []string) (edgediscovery.ProxyTLSConfigs, error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	configs := make(edgediscovery.ProxyTLSConfigs, len(specs))
+	for _, spec := range specs {
+		fields := strings.Split(spec, ";")
+		proxyAddr := strings.TrimSpace(fields[0])
+		if proxyAddr != edgediscovery.AnyProxy {
+			if _, _, err := net.SplitHostPort(proxyAddr); err != nil {
+				return nil, fmt.Errorf("the proxy of %q must be host:port or %s", spec, edgediscovery.AnyProxy)
+			}
+		}
+		if _, ok := configs[proxyAddr]; ok {
+			return nil, fmt.Errorf("the proxy %s has several TLS settings", proxyAddr)
+		}
+
+		var certPath, keyPath string
+		tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+		for _, option := range fields[1:] {
+			key, value, ok := strings.Cut(strings.TrimSpace(option), "=")
+			if !ok || value == "" {
+				return nil, fmt.Errorf("invalid setting %q of %q, expected SETTING=VALUE", option, spec)
+			}
+			switch key {
+			case "cert":
+				certPath = value
+			case "key":
+				keyPath = value
+			case "ca":
+				rootCAs, err := tlsconfig.LoadCert([]string{value})
+				if err != nil {
+					return nil, err
+				}
+				tlsConfig.RootCAs = rootCAs
+			case "server-name":
+				tlsConfig.ServerName = value
+			default:
+				return nil, fmt.Errorf("unknown setting %q of %q", key, spec)
+			}
+		}
+		if (certPath == "") != (keyPath == "") {
+			return nil, fmt.Errorf("the cert and key of %q must be provided together", spec)
+		}
+		if certPath != "" {
+			cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+			if err != nil {
+				return nil, errors.Wrapf(err, "error loading the client certificate of the proxy %s", proxyAddr)
+			}
+			tlsConfig.Certificates = []tls.Certificate{cert}
+		}
+		configs[proxyAddr] = tlsConfig
+	}
+	return configs, nil
+}
+
+// edgeProxyJumpHost returns the SSH jump host of an ssh:// edge proxy, authenticating with the key of
+// --edge-proxy-ssh-key and verifying the host key with the known hosts of --edge-proxy-ssh-known-hosts, or
+// ~/.ssh/known_hosts if it exists, and the fingerprints of --edge-proxy-ssh-host-key.
+func edgeProxyJumpHost(c *cli.Context, proxyURL string) (*edgediscovery.SSHJumpHost, error) {
+	if !strings.HasPrefix(proxyURL, "ssh://") {
+		return nil, nil
+	}
+	keyPath := c.String(flags.EdgeProxySSHKey)
+	if keyPath == "" {
+		return nil, fmt.Errorf("an ssh:// %s requires %s", flags.EdgeProxyURL, flags.EdgeProxySSHKey)
+	}
+	keyPath, err := homedir.Expand(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	pemBytes, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't read the SSH key from %s", keyPath)
+	}
+	signer, err := ssh.ParsePrivateKey(pemBytes)
+	if err != nil {
+		var passphraseErr *ssh.PassphraseMissingError
+		if errors.As(err, &passphraseErr) {
+			return nil, fmt.Errorf("the SSH key %s is encrypted, %s requires an unencrypted key", keyPath, flags.EdgeProxySSHKey)
+		}
+		return nil, errors.Wrapf(err, "invalid SSH key %s", keyPath)
+	}
+
+	knownHosts := c.String(flags.EdgeProxySSHKnownHosts)
+	if knownHosts == "" {
+		// The default known hosts are only used if they exist
+		if knownHosts, err = homedir.Expand("~/.ssh/known_hosts"); err != nil {
+			return nil, err
+		}
+		if _, err := os.Stat(knownHosts); err != nil {
+			knownHosts = ""
+		}
+	} else if knownHosts, err = homedir.Expand(knownHosts); err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := edgediscovery.SSHHostKeyCallback(knownHosts, c.StringSlice(flags.EdgeProxySSHHostKey))
+	if err != nil {
+		return nil, fmt.Errorf("can't verify the SSH jump host of %s: %w, set %s or %s", flags.EdgeProxyURL, err, flags.EdgeProxySSHKnownHosts, flags.EdgeProxySSHHostKey)
+	}
+	return edgediscovery.NewSSHJumpHost(signer, hostKeyCallback), nil
+}
+
+// readEdgeProxyCredentials reads the user:pass credentials of the edge proxy from path.
+func readEdgeProxyCredentials(path string) (*proxy.Auth, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "couldn't read edge proxy credentials from %s", path)
+	}
+	username, password, ok := strings.Cut(strings.TrimSpace(string(content)), ":")
+	if !ok || username == "" {
+		return nil, fmt.Errorf("edge proxy credentials in %s must be formatted as user:pass", path)
+	}
+	return &proxy.Auth{User: username, Password: password}, nil
+}
+
+// faultInjector returns the faults configured to be injected, or nil if there are none.
+func faultInjector(c *cli.Context, log *zerolog.Logger) (*faultinject.Injector, error) {
+	specs := c.StringSlice(flags.FaultInjection)
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	cfg, err := faultinject.ParseConfig(specs)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s provided: %w", flags.FaultInjection, err)
+	}
+	injector := faultinject.New(cfg)
+	log.Warn().Msgf("Fault injection is enabled: %s", injector)
+	return injector, nil
+}
+
+// registrationTimeouts returns the timeouts of each control stream RPC, falling back to rpc-timeout for
+// operations without a specific timeout.
+func registrationTimeouts(c *cli.Context) tunnelrpc.RegistrationTimeouts {
+	timeouts := tunnelrpc.NewRegistrationTimeouts(c.Duration(flags.RpcTimeout))
+	if timeout := c.Duration(flags.RpcRegisterTimeout); timeout > 0 {
+		timeouts.RegisterConnection = timeout
+	}
+	if timeout := c.Duration(flags.RpcLocalConfigTimeout); timeout > 0 {
+		timeouts.UpdateLocalConfiguration = timeout
+	}
+	timeouts.UnregisterConnection = c.Duration(flags.RpcUnregisterTimeout)
+	if retries := c.Int(flags.RpcIdempotentRetries); retries > 0 {
+		timeouts.IdempotentRetries = uint(retries)
+	}
+	return timeouts
+}
+
 func gracePeriod(c *cli.Context) (time.Duration, error) {
 	period := c.Duration(flags.GracePeriod)
 	if period > connection.MaxGracePeriod {
@@ -326,6 +923,97 @@ func parseConfigIPVersion(version string) (v allregions.ConfigIPVersion, err err
 	return
 }
 
+// parseEdgeAddrRotation returns the edge address rotation policy from possible expected values from config
+func parseEdgeAddrRotation(policy string) (allregions.RotationPolicy, error) {
+	switch policy {
+	case "", "balanced":
+		return allregions.RotateBalanced, nil
+	case "same-region":
+		return allregions.RotateSameRegion, nil
+	case "other-region":
+		return allregions.RotateOtherRegion, nil
+	default:
+		return allregions.RotateBalanced, fmt.Errorf("invalid value for edge-addr-rotation: %s", policy)
+	}
+}
+
+// parseDSCP returns the DSCP value of flag, which must be between 0 and edgediscovery.MaxDSCP.
+func parseDSCP(c *cli.Context, flag string) (uint8, error) {
+	dscp := c.Int(flag)
+	if dscp < 0 || dscp > edgediscovery.MaxDSCP {
+		return 0, fmt.Errorf("invalid value for %s: %d, must be between 0 and %d", flag, dscp, edgediscovery.MaxDSCP)
+	}
+	return uint8(dscp), nil
+}
+
+// parseEdgeTCPOptions returns the socket options of HTTP2 connections to the edge.
+func parseEdgeTCPOptions(c *cli.Context) (edgediscovery.TCPOptions, error) {
+	dscp, err := parseDSCP(c, flags.HTTP2DSCP)
+	if err != nil {
+		return edgediscovery.TCPOptions{}, err
+	}
+	options := edgediscovery.TCPOptions{
+		DSCP:              dscp,
+		FastOpen:          c.Bool(flags.EdgeTCPFastOpen),
+		Nagle:             !c.Bool(flags.EdgeTCPNoDelay),
+		KeepAliveInterval: c.Duration(flags.EdgeTCPKeepAliveInterval),
+		KeepAliveCount:    c.Int(flags.EdgeTCPKeepAliveCount),
+		UserTimeout:       c.Duration(flags.EdgeTCPUserTimeout),
+	}
+	if options.KeepAliveInterval < 0 {
+		return edgediscovery.TCPOptions{}, fmt.Errorf("invalid value for %s: %s", flags.EdgeTCPKeepAliveInterval, options.KeepAliveInterval)
+	}
+	if options.KeepAliveCount < 0 {
+		return edgediscovery.TCPOptions{}, fmt.Errorf("invalid value for %s: %d", flags.EdgeTCPKeepAliveCount, options.KeepAliveCount)
+	}
+	if options.UserTimeout < 0 {
+		return edgediscovery.TCPOptions{}, fmt.Errorf("invalid value for %s: %s", flags.EdgeTCPUserTimeout, options.UserTimeout)
+	}
+	return options, nil
+}
+
+// clockSkewCheckURL returns the URL of the Cloudflare API host, whose Date header the local clock is compared with.
+func clockSkewCheckURL(c *cli.Context) string {
+	apiURL, err := url.Parse(c.String(flags.ApiURL))
+	if err != nil || apiURL.Host == "" {
+		return ""
+	}
+	return (&url.URL{Scheme: apiURL.Scheme, Host: apiURL.Host, Path: "/"}).String()
+}
+
+// parseEgressShaping returns the shaper of what the tunnel connections write to the edge, nil if no class is
+// shaped.
+func parseEgressShaping(c *cli.Context) (*connection.EgressShaper, error) {
+	rates := map[connection.TrafficClass]connection.ShaperRate{}
+	for _, spec := range c.StringSlice(flags.EgressShaping) {
+		class, rate, err := connection.ParseShaperRate(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %w", flags.EgressShaping, err)
+		}
+		if _, ok := rates[class]; ok {
+			return nil, fmt.Errorf("invalid value for %s: %s is shaped more than once", flags.EgressShaping, class)
+		}
+		rates[class] = rate
+	}
+	return connection.NewEgressShaper(rates), nil
+}
+
+// parseHTTP2Compression returns how the streams of HTTP2 connections to the edge are compressed.
+func parseHTTP2Compression(c *cli.Context) (connection.HTTP2Compression, error) {
+	quality := c.Int(flags.CompressionQuality)
+	if quality <= 0 {
+		return connection.HTTP2Compression{}, nil
+	}
+	algorithm, err := connection.ParseCompressionAlgorithm(c.String(flags.CompressionAlgorithm))
+	if err != nil {
+		return connection.HTTP2Compression{}, fmt.Errorf("invalid value for %s: %w", flags.CompressionAlgorithm, err)
+	}
+	return connection.HTTP2Compression{
+		Algorithm: algorithm,
+		Quality:   uint8(min(quality, math.MaxUint8)),
+	}, nil
+}
+
 func parseConfigBindAddress(ipstr string) (net.IP, error) {
 	// Unspecified - it's fine
 	if ipstr == "" {
@@ -371,13 +1059,42 @@ func adjustIPVersionByBindAddress(ipVersion allregions.ConfigIPVersion, ip net.I
 	}
 }
 
+// detectEdgeIPVersion adjusts ipVersion to the IP versions the host has routes for, so that e.g. an IPv6-only host
+// doesn't use up its retries dialing IPv4 edge addresses.
+func detectEdgeIPVersion(ipVersion allregions.ConfigIPVersion, routes edgediscovery.IPRoutes, log *zerolog.Logger) allregions.ConfigIPVersion {
+	detected := ipVersion
+	switch {
+	case routes.V4 && routes.V6:
+	case routes.V6:
+		detected = allregions.IPv6Only
+	case routes.V4:
+		if ipVersion == allregions.Auto {
+			detected = allregions.IPv4Only
+		}
+	default:
+		log.Warn().Str("edgeIPVersion", ipVersion.String()).Msg("Found no IPv4 or IPv6 route to the Cloudflare edge")
+		return ipVersion
+	}
+	log.Info().
+		Bool("ipv4Route", routes.V4).
+		Bool("ipv6Route", routes.V6).
+		Str("edgeIPVersion", detected.String()).
+		Msg("Detected the IP versions the Cloudflare edge can be reached with")
+	return detected
+}
+
 func newICMPRouter(c *cli.Context, logger *zerolog.Logger) (ingress.ICMPRouterServer, error) {
 	ipv4Src, ipv6Src, err := determineICMPSources(c, logger)
 	if err != nil {
 		return nil, err
 	}
 
-	icmpRouter, err := ingress.NewICMPRouter(ipv4Src, ipv6Src, logger, icmpFunnelTimeout)
+	sourcePorts, err := ingress.ParsePortRange(c.String(flags.ICMPSourcePortRange))
+	if err != nil {
+		return nil, err
+	}
+
+	icmpRouter, err := ingress.NewICMPRouter(ipv4Src, ipv6Src, sourcePorts, logger, icmpFunnelTimeout)
 	if err != nil {
 		return nil, err
 	}
@@ -535,3 +1252,15 @@ func parseResolverAddrPorts(input []string) ([]netip.AddrPort, error) {
 	}
 	return addrs, nil
 }
+
+func parseUsageNetworks(input []string) ([]netip.Prefix, error) {
+	networks := make([]netip.Prefix, 0, len(input))
+	for _, val := range input {
+		network, err := netip.ParsePrefix(val)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s %q: %w", flags.UsageNetworks, val, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"os"
 
 	"github.com/cloudflare/cloudflared/cmd/cloudflared/cliutil"
 	"github.com/cloudflare/cloudflared/config"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
 )
 
 const ingressDataJSONFlagName = "json"
@@ -45,10 +47,11 @@ func buildIngressSubcommand() *cli.Command {
 
 		To ensure cloudflared can route all incoming requests, the last rule must be a catch-all
 		rule that matches all traffic. You can validate these rules with the 'ingress validate'
-		command, and test which rule matches a particular URL with 'ingress rule <URL>'.
+		command, test which rule matches a particular URL with 'ingress rule <URL>', and check
+		a list of sample requests against their expected rules with 'ingress test <FILE>'.
 
 		Multiple-origin routing is incompatible with the --url flag.`,
-		Subcommands: []*cli.Command{buildValidateIngressCommand(), buildTestURLCommand()},
+		Subcommands: []*cli.Command{buildValidateIngressCommand(), buildTestURLCommand(), buildTestSuiteCommand()},
 	}
 }
 
@@ -78,6 +81,31 @@ func buildTestURLCommand() *cli.Command {
 	}
 }
 
+func buildTestSuiteCommand() *cli.Command {
+	return &cli.Command{
+		Name:      "test",
+		Action:    cliutil.ConfiguredAction(testSuiteCommand),
+		Usage:     "Check that sample requests match the expected ingress rules",
+		UsageText: "cloudflared tunnel [--config FILEPATH] ingress test FILE",
+		ArgsUsage: "FILE",
+		Description: `Matches the sample requests of a YAML file against the ingress rules, without reaching any
+		origin, and fails unless each of them matches the expected rule. The rules are numbered as
+		by 'ingress rule', for example:
+
+		- name: API
+		  host: api.example.com
+		  path: /v1/users
+		  expect:
+		    rule: 0
+		- host: unknown.example.com
+		  expect:
+		    service: http_status:404
+
+		Checking the suite in CI validates configuration changes before they are deployed.`,
+		Flags: []cli.Flag{ingressDataJSON},
+	}
+}
+
 // validateIngressCommand check the syntax of the ingress rules in the cloudflared config file
 func validateIngressCommand(c *cli.Context, warnings string) error {
 	conf, err := getConfiguration(c)
@@ -143,3 +171,78 @@ func testURLCommand(c *cli.Context) error {
 	fmt.Println(ing.Rules[i].MultiLineString())
 	return nil
 }
+
+// ingressTestCase is a sample request of an ingress test suite, with the rule it must match.
+type ingressTestCase struct {
+	Name   string `yaml:"name"`
+	Host   string `yaml:"host"`
+	Path   string `yaml:"path"`
+	Expect struct {
+		// Index of the rule, as printed by 'ingress rule'
+		Rule *int `yaml:"rule"`
+		// Service of the rule, e.g. http://localhost:8000
+		Service string `yaml:"service"`
+	} `yaml:"expect"`
+}
+
+func (tc ingressTestCase) String() string {
+	if tc.Name != "" {
+		return tc.Name
+	}
+	return tc.Host + tc.Path
+}
+
+// testSuiteCommand checks the sample requests of a test suite against the ingress rules.
+func testSuiteCommand(c *cli.Context) error {
+	suitePath := c.Args().First()
+	if suitePath == "" {
+		return errors.New("cloudflared tunnel ingress test expects a single argument, the test suite file")
+	}
+	suite, err := os.ReadFile(suitePath)
+	if err != nil {
+		return err
+	}
+	var testCases []ingressTestCase
+	if err := yaml.Unmarshal(suite, &testCases); err != nil {
+		return errors.Wrapf(err, "%s isn't a valid test suite", suitePath)
+	}
+
+	conf, err := getConfiguration(c)
+	if err != nil {
+		return err
+	}
+	ing, err := ingress.ParseIngress(conf)
+	if err != nil {
+		return errors.Wrap(err, "Validation failed")
+	}
+
+	failures := runIngressTests(ing, testCases)
+	for _, failure := range failures {
+		fmt.Println("FAIL", failure)
+	}
+	fmt.Printf("%d passed, %d failed\n", len(testCases)-len(failures), len(failures))
+	if len(failures) > 0 {
+		return fmt.Errorf("%d of %d ingress tests failed", len(failures), len(testCases))
+	}
+	return nil
+}
+
+// runIngressTests returns the failures of the test cases matched against ing.
+func runIngressTests(ing ingress.Ingress, testCases []ingressTestCase) []string {
+	var failures []string
+	for _, tc := range testCases {
+		if tc.Expect.Rule == nil && tc.Expect.Service == "" {
+			failures = append(failures, fmt.Sprintf("%s: expects neither a rule nor a service", tc))
+			continue
+		}
+		rule, i := ing.FindMatchingRule(tc.Host, tc.Path)
+		if tc.Expect.Rule != nil && *tc.Expect.Rule != i {
+			failures = append(failures, fmt.Sprintf("%s: matched rule #%d, expected rule #%d", tc, i, *tc.Expect.Rule))
+			continue
+		}
+		if service := rule.Service.String(); tc.Expect.Service != "" && tc.Expect.Service != service {
+			failures = append(failures, fmt.Sprintf("%s: matched rule #%d to %s, expected %s", tc, i, service, tc.Expect.Service))
+		}
+	}
+	return failures
+}
@@ -0,0 +1,61 @@
+package tunnel
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+func TestRunIngressTests(t *testing.T) {
+	var conf config.Configuration
+	require.NoError(t, yaml.Unmarshal([]byte(`
+ingress:
+ - hostname: api.example.com
+   path: /v1/.*
+   service: http://localhost:8000
+ - hostname: "*.example.com"
+   service: http://localhost:8080
+ - service: http_status:404
+`), &conf))
+	ing, err := ingress.ParseIngress(&conf)
+	require.NoError(t, err)
+
+	var testCases []ingressTestCase
+	require.NoError(t, yaml.Unmarshal([]byte(`
+- name: API
+  host: api.example.com
+  path: /v1/users
+  expect:
+    rule: 0
+- host: api.example.com
+  path: /v2/users
+  expect:
+    service: http://localhost:8080
+- host: example.org
+  expect:
+    rule: 2
+    service: http_status:404
+`), &testCases))
+	require.Empty(t, runIngressTests(ing, testCases))
+
+	require.NoError(t, yaml.Unmarshal([]byte(`
+- name: wrong rule
+  host: api.example.com
+  expect:
+    rule: 0
+- host: www.example.com
+  expect:
+    service: http://localhost:8000
+- name: no expectation
+  host: www.example.com
+`), &testCases))
+	require.Equal(t, []string{
+		"wrong rule: matched rule #1, expected rule #0",
+		"www.example.com: matched rule #1 to http://localhost:8080, expected http://localhost:8000",
+		"no expectation: expects neither a rule nor a service",
+	}, runIngressTests(ing, testCases))
+}
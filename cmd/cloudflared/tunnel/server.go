@@ -19,10 +19,14 @@ func runDNSProxyServer(c *cli.Context, dnsReadySignal chan struct{}, shutdownC <
 	if maxUpstreamConnections < 0 {
 		return fmt.Errorf("'%s' must be 0 or higher", "proxy-dns-max-upstream-conns")
 	}
-	listener, err := tunneldns.CreateListener(c.String("proxy-dns-address"), uint16(port), c.StringSlice("proxy-dns-upstream"), c.StringSlice("proxy-dns-bootstrap"), maxUpstreamConnections, log)
+	options := tunneldns.ResolverOptions{
+		UpstreamPolicy: c.String("proxy-dns-upstream-policy"),
+		ECS:            c.String("proxy-dns-ecs"),
+		BlocklistFile:  c.String("proxy-dns-blocklist"),
+	}
+	listener, err := tunneldns.CreateListener(c.String("proxy-dns-address"), uint16(port), c.StringSlice("proxy-dns-upstream"), c.StringSlice("proxy-dns-bootstrap"), maxUpstreamConnections, options, log)
 	if err != nil {
 		close(dnsReadySignal)
-		listener.Stop()
 		return errors.Wrap(err, "Cannot create the DNS over HTTPS proxy server")
 	}
 
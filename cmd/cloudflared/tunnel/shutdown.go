@@ -0,0 +1,107 @@
+package tunnel
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/rs/zerolog"
+	"github.com/urfave/cli/v2"
+
+	"github.com/cloudflare/cloudflared/cmd/cloudflared/cliutil"
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/edgediscovery"
+	"github.com/cloudflare/cloudflared/fleet"
+	"github.com/cloudflare/cloudflared/supervisor"
+)
+
+// shutdownReason classifies the error that stopped the tunnel server. A nil error means the server was drained
+// after a graceful shutdown was signalled.
+func shutdownReason(err error) cliutil.ShutdownReason {
+	if err == nil {
+		return cliutil.ShutdownReasonDrained
+	}
+
+	var shutdownErr *cliutil.ShutdownError
+	if errors.As(err, &shutdownErr) {
+		return shutdownErr.Reason
+	}
+	// The updater stops the server with an error carrying its own exit code
+	var exitCoder cli.ExitCoder
+	if errors.As(err, &exitCoder) {
+		switch exitCoder.ExitCode() {
+		case cliutil.ExitCodeUpdated:
+			return cliutil.ShutdownReasonUpdated
+		case cliutil.ExitCodeUpdateFailed:
+			return cliutil.ShutdownReasonUpdateFailed
+		}
+	}
+
+	var (
+		readyTimeoutErr *supervisor.ReadyTimeoutError
+		registerErr     connection.ServerRegisterTunnelError
+		connectivityErr *supervisor.ConnectivityError
+		noAddressesErr  edgediscovery.ErrNoAddressesLeft
+		dialErr         edgediscovery.DialError
+		quicDialErr     *connection.EdgeQuicDialError
+		fleetChangedErr *fleet.ChangedError
+	)
+	switch {
+	case errors.As(err, &fleetChangedErr):
+		return cliutil.ShutdownReasonConfigChanged
+	case errors.As(err, &readyTimeoutErr):
+		return cliutil.ShutdownReasonReadyTimeout
+	case errors.As(err, &registerErr) && strings.Contains(registerErr.Error(), "Unauthorized"):
+		return cliutil.ShutdownReasonAuthFailure
+	case errors.As(err, &connectivityErr),
+		errors.As(err, &noAddressesErr),
+		errors.As(err, &dialErr),
+		errors.As(err, &quicDialErr):
+		return cliutil.ShutdownReasonNoEdgeReachable
+	default:
+		return cliutil.ShutdownReasonError
+	}
+}
+
+// reportShutdown writes the shutdown report of the tunnel server that stopped with err, and returns err annotated
+// with the exit code of its reason. Errors that already carry an exit code, such as the updater's, keep it.
+func reportShutdown(w io.Writer, err error, startTime time.Time, version string) error {
+	reason := shutdownReason(err)
+	report := cliutil.NewShutdownReport(reason, err, time.Since(startTime), version)
+	// urfave/cli exits with the code of the returned error itself, not of the errors it wraps
+	exitCoder, hasExitCode := err.(cli.ExitCoder)
+	if hasExitCode {
+		report.ExitCode = exitCoder.ExitCode()
+	}
+	var readyTimeoutErr *supervisor.ReadyTimeoutError
+	if errors.As(err, &readyTimeoutErr) {
+		report.Attempts = readyTimeoutErr.Attempts
+	}
+	_ = report.Write(w)
+	if err == nil {
+		return nil
+	}
+	if hasExitCode {
+		return err
+	}
+	return cliutil.NewShutdownError(reason, err)
+}
+
+// recoverFatalPanic turns a panic of the tunnel server into a fatal panic error, after reporting it to Sentry.
+func recoverFatalPanic(recovered interface{}) error {
+	sentry.CurrentHub().Recover(recovered)
+	sentry.Flush(2 * time.Second)
+	return cliutil.NewShutdownError(cliutil.ShutdownReasonFatalPanic, fmt.Errorf("panic: %v", recovered))
+}
+
+// recoverServerPanic sends a panic of a goroutine of the tunnel server to errC as a fatal panic error, so that the
+// server shuts down and reports it like a panic of the main goroutine. It must be deferred by the goroutine itself.
+func recoverServerPanic(errC chan<- error, log *zerolog.Logger) {
+	if r := recover(); r != nil {
+		log.Error().Msgf("Tunnel server panicked: %v", r)
+		errC <- recoverFatalPanic(r)
+	}
+}
@@ -0,0 +1,209 @@
+package tunnel
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/urfave/cli/v2"
+
+	"github.com/cloudflare/cloudflared/cmd/cloudflared/cliutil"
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/edgediscovery"
+	"github.com/cloudflare/cloudflared/fleet"
+	"github.com/cloudflare/cloudflared/supervisor"
+)
+
+func TestShutdownReason(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected cliutil.ShutdownReason
+	}{
+		{
+			name:     "graceful shutdown",
+			expected: cliutil.ShutdownReasonDrained,
+		},
+		{
+			name:     "unauthorized registration",
+			err:      connection.ServerRegisterTunnelError{Cause: errors.New("Unauthorized: Failed to get tunnel"), Permanent: true},
+			expected: cliutil.ShutdownReasonAuthFailure,
+		},
+		{
+			name:     "other registration error",
+			err:      connection.ServerRegisterTunnelError{Cause: errors.New("internal error"), Permanent: true},
+			expected: cliutil.ShutdownReasonError,
+		},
+		{
+			name:     "max edge retries reached",
+			err:      supervisor.NewConnectivityError(true),
+			expected: cliutil.ShutdownReasonNoEdgeReachable,
+		},
+		{
+			name:     "no edge addresses left",
+			err:      fmt.Errorf("initial tunnel connection failed: %w", edgediscovery.ErrNoAddressesLeft{}),
+			expected: cliutil.ShutdownReasonNoEdgeReachable,
+		},
+		{
+			name:     "quic dial error",
+			err:      &connection.EdgeQuicDialError{Cause: errors.New("timeout")},
+			expected: cliutil.ShutdownReasonNoEdgeReachable,
+		},
+		{
+			name:     "no connection registered in time",
+			err:      &supervisor.ReadyTimeoutError{Timeout: time.Minute},
+			expected: cliutil.ShutdownReasonReadyTimeout,
+		},
+		{
+			name:     "already classified",
+			err:      cliutil.NewShutdownError(cliutil.ShutdownReasonConfigInvalid, errors.New("bad ingress")),
+			expected: cliutil.ShutdownReasonConfigInvalid,
+		},
+		{
+			name:     "updated",
+			err:      cli.Exit("cloudflared has been updated to version 2025.2.0", cliutil.ExitCodeUpdated),
+			expected: cliutil.ShutdownReasonUpdated,
+		},
+		{
+			name:     "update failed",
+			err:      fmt.Errorf("autoupdater: %w", cli.Exit("failed to update cloudflared", cliutil.ExitCodeUpdateFailed)),
+			expected: cliutil.ShutdownReasonUpdateFailed,
+		},
+		{
+			name:     "fleet configuration changed",
+			err:      &fleet.ChangedError{CachePath: "fleet-config.yml"},
+			expected: cliutil.ShutdownReasonConfigChanged,
+		},
+		{
+			name:     "unknown error",
+			err:      errors.New("metrics server failed"),
+			expected: cliutil.ShutdownReasonError,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, shutdownReason(test.err))
+		})
+	}
+}
+
+func TestReportShutdown(t *testing.T) {
+	var buf bytes.Buffer
+	startTime := time.Now().Add(-time.Minute)
+	err := reportShutdown(&buf, supervisor.NewConnectivityError(true), startTime, "2025.1.0")
+
+	var exitCoder cli.ExitCoder
+	require.ErrorAs(t, err, &exitCoder)
+	assert.Equal(t, cliutil.ExitCodeNoEdgeReachable, exitCoder.ExitCode())
+
+	var report cliutil.ShutdownReport
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &report))
+	assert.Equal(t, cliutil.ShutdownReasonNoEdgeReachable, report.Reason)
+	assert.Equal(t, cliutil.ExitCodeNoEdgeReachable, report.ExitCode)
+	assert.NotEmpty(t, report.Error)
+	assert.GreaterOrEqual(t, report.UptimeSeconds, time.Minute.Seconds())
+	assert.Equal(t, "2025.1.0", report.Version)
+
+	buf.Reset()
+	require.NoError(t, reportShutdown(&buf, nil, time.Now(), "2025.1.0"))
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &report))
+	assert.Equal(t, cliutil.ShutdownReasonDrained, report.Reason)
+	assert.Equal(t, cliutil.ExitCodeDrained, report.ExitCode)
+}
+
+func TestReportShutdownReadyTimeout(t *testing.T) {
+	var buf bytes.Buffer
+	readyTimeoutErr := &supervisor.ReadyTimeoutError{
+		Timeout: 30 * time.Second,
+		Attempts: []supervisor.ConnectionAttempt{
+			{EdgeIP: "198.41.200.1", Protocol: "quic", Error: "timeout: no recent network activity"},
+			{EdgeIP: "198.41.200.2", Protocol: "http2", Proxy: true, Error: "connection refused"},
+		},
+	}
+	err := reportShutdown(&buf, readyTimeoutErr, time.Now(), "2025.1.0")
+
+	var exitCoder cli.ExitCoder
+	require.ErrorAs(t, err, &exitCoder)
+	assert.Equal(t, cliutil.ExitCodeReadyTimeout, exitCoder.ExitCode())
+
+	var report struct {
+		Reason   cliutil.ShutdownReason         `json:"reason"`
+		Error    string                         `json:"error"`
+		Attempts []supervisor.ConnectionAttempt `json:"attempts"`
+	}
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &report))
+	assert.Equal(t, cliutil.ShutdownReasonReadyTimeout, report.Reason)
+	assert.Contains(t, report.Error, "connection refused")
+	assert.Equal(t, readyTimeoutErr.Attempts, report.Attempts)
+}
+
+func TestReportShutdownUpdater(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		reason   cliutil.ShutdownReason
+		exitCode int
+	}{
+		{
+			name:     "updated",
+			err:      cli.Exit("cloudflared has been updated to version 2025.2.0", cliutil.ExitCodeUpdated),
+			reason:   cliutil.ShutdownReasonUpdated,
+			exitCode: cliutil.ExitCodeUpdated,
+		},
+		{
+			name:     "update failed",
+			err:      cli.Exit("failed to update cloudflared", cliutil.ExitCodeUpdateFailed),
+			reason:   cliutil.ShutdownReasonUpdateFailed,
+			exitCode: cliutil.ExitCodeUpdateFailed,
+		},
+		{
+			name:     "wrapped update",
+			err:      fmt.Errorf("autoupdater: %w", cli.Exit("cloudflared has been updated", cliutil.ExitCodeUpdated)),
+			reason:   cliutil.ShutdownReasonUpdated,
+			exitCode: cliutil.ExitCodeUpdated,
+		},
+		{
+			name:     "other exit code",
+			err:      cli.Exit("stopped", 2),
+			reason:   cliutil.ShutdownReasonError,
+			exitCode: 2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			err := reportShutdown(&buf, test.err, time.Now(), "2025.1.0")
+
+			// urfave/cli only exits with the code of the returned error itself
+			exitCoder, ok := err.(cli.ExitCoder)
+			require.True(t, ok)
+			assert.Equal(t, test.exitCode, exitCoder.ExitCode())
+
+			var report cliutil.ShutdownReport
+			require.NoError(t, json.Unmarshal(buf.Bytes(), &report))
+			assert.Equal(t, test.reason, report.Reason)
+			assert.Equal(t, test.exitCode, report.ExitCode)
+		})
+	}
+}
+
+func TestRecoverServerPanic(t *testing.T) {
+	errC := make(chan error, 1)
+	log := zerolog.Nop()
+	go func() {
+		defer recoverServerPanic(errC, &log)
+		panic("metrics server")
+	}()
+
+	err := <-errC
+	var shutdownErr *cliutil.ShutdownError
+	require.ErrorAs(t, err, &shutdownErr)
+	assert.Equal(t, cliutil.ShutdownReasonFatalPanic, shutdownErr.Reason)
+	assert.Contains(t, err.Error(), "metrics server")
+}
@@ -6,10 +6,12 @@ import (
 	"syscall"
 
 	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/audit"
 )
 
 // waitForSignal closes graceShutdownC to indicate that we should start graceful shutdown sequence
-func waitForSignal(graceShutdownC chan struct{}, logger *zerolog.Logger) {
+func waitForSignal(graceShutdownC chan struct{}, auditLog *audit.Log, logger *zerolog.Logger) {
 	signals := make(chan os.Signal, 10)
 	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
 	defer signal.Stop(signals)
@@ -17,6 +19,7 @@ func waitForSignal(graceShutdownC chan struct{}, logger *zerolog.Logger) {
 	select {
 	case s := <-signals:
 		logger.Info().Msgf("Initiating graceful shutdown due to signal %s ...", s)
+		auditLog.Record(audit.ActionDrain, audit.ActorLocal, nil, map[string]string{"signal": s.String()})
 		close(graceShutdownC)
 	case <-graceShutdownC:
 	}
@@ -52,7 +52,7 @@ func TestSignalShutdown(t *testing.T) {
 			}
 		})
 
-		waitForSignal(graceShutdownC, &log)
+		waitForSignal(graceShutdownC, nil, &log)
 		assert.True(t, channelClosed(graceShutdownC))
 	}
 }
@@ -76,7 +76,7 @@ func TestWaitForShutdown(t *testing.T) {
 	go func() {
 		errC <- serverErr
 	}()
-	err := waitToShutdown(&wg, cancel, errC, graceShutdownC, gracePeriod, &log)
+	err := waitToShutdown(&wg, cancel, errC, graceShutdownC, gracePeriod, 0, &log)
 	assert.Equal(t, serverErr, err)
 	assert.True(t, contextCancelled)
 	assert.False(t, channelClosed(graceShutdownC))
@@ -90,7 +90,7 @@ func TestWaitForShutdown(t *testing.T) {
 		time.Sleep(tick)
 		errC <- serverErr
 	}()
-	err = waitToShutdown(&wg, cancel, errC, graceShutdownC, gracePeriod, &log)
+	err = waitToShutdown(&wg, cancel, errC, graceShutdownC, gracePeriod, 0, &log)
 	assert.Nil(t, err)
 	assert.True(t, contextCancelled)
 	assert.True(t, time.Now().Sub(startTime) < time.Second) // check that wait ended early
@@ -98,8 +98,25 @@ func TestWaitForShutdown(t *testing.T) {
 	// with graceShutdownC closed stop right away without grace period
 	contextCancelled = false
 	startTime = time.Now()
-	err = waitToShutdown(&wg, cancel, errC, graceShutdownC, 0, &log)
+	err = waitToShutdown(&wg, cancel, errC, graceShutdownC, 0, 0, &log)
 	assert.Nil(t, err)
 	assert.True(t, contextCancelled)
 	assert.True(t, time.Now().Sub(startTime) < time.Second) // check that wait ended early
 }
+
+func TestWaitForShutdownTimeout(t *testing.T) {
+	log := zerolog.Nop()
+	errC := make(chan error)
+	graceShutdownC := make(chan struct{})
+	close(graceShutdownC)
+
+	// A server that doesn't stop doesn't hold the shutdown past its timeout
+	var wg sync.WaitGroup
+	wg.Add(1)
+	defer wg.Done()
+	startTime := time.Now()
+	err := waitToShutdown(&wg, func() {}, errC, graceShutdownC, 0, tick, &log)
+	assert.Nil(t, err)
+	assert.True(t, time.Since(startTime) >= tick)
+	assert.True(t, time.Since(startTime) < time.Second)
+}
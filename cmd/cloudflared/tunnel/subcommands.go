@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -32,6 +33,7 @@ import (
 	"github.com/cloudflare/cloudflared/diagnostic"
 	"github.com/cloudflare/cloudflared/fips"
 	"github.com/cloudflare/cloudflared/metrics"
+	"github.com/cloudflare/cloudflared/tlsconfig"
 )
 
 const (
@@ -50,6 +52,11 @@ const (
 	noDiagNetworkFlagName   = "no-diag-network"
 	diagContainerIDFlagName = "diag-container-id"
 	diagPodFlagName         = "diag-pod-id"
+	prepareWindowFlagName   = "window"
+	overrideDurationFlag    = "duration"
+	metricsCAFlagName       = "metrics-ca"
+	metricsClientCertName   = "metrics-client-cert"
+	metricsClientKeyName    = "metrics-client-key"
 
 	LogFieldTunnelID = "tunnelID"
 )
@@ -196,6 +203,34 @@ var (
 		Usage:   "Source address and the interface name to send/receive ICMPv6 messages. If not provided cloudflared will dial a local address to determine the source IP or fallback to ::.",
 		EnvVars: []string{"TUNNEL_ICMPV6_SRC"},
 	}
+	icmpSourcePortRangeFlag = &cli.StringFlag{
+		Name:    flags.ICMPSourcePortRange,
+		Usage:   "Range of source ports, in the <min>-<max> format, of the ICMP sockets cloudflared opens towards origins. The port is also the echo ID of the proxied requests. Only supported on Linux, by default any port is used.",
+		EnvVars: []string{"TUNNEL_ICMP_SOURCE_PORT_RANGE"},
+	}
+	prepareReconnectWindowFlag = &cli.DurationFlag{
+		Name:  prepareWindowFlagName,
+		Usage: "How long the tunnel stays prepared to reconnect quickly, it should cover the maintenance window. Defaults to 10m.",
+	}
+	maintenanceOverrideDurationFlag = &cli.DurationFlag{
+		Name:  overrideDurationFlag,
+		Usage: "How long disruptive actions are allowed outside of the maintenance windows. Defaults to the override duration of the running tunnel.",
+	}
+	metricsCAFlag = &cli.StringFlag{
+		Name:    metricsCAFlagName,
+		Usage:   "CA certificate used to verify the metrics server. Setting it, or a client certificate, makes the request over HTTPS.",
+		EnvVars: []string{"TUNNEL_METRICS_CA"},
+	}
+	metricsClientCertFlag = &cli.StringFlag{
+		Name:    metricsClientCertName,
+		Usage:   "Client certificate presented to the metrics server when it requires client certificates (mTLS).",
+		EnvVars: []string{"TUNNEL_METRICS_CLIENT_CERT"},
+	}
+	metricsClientKeyFlag = &cli.StringFlag{
+		Name:    metricsClientKeyName,
+		Usage:   "Private key of the client certificate presented to the metrics server.",
+		EnvVars: []string{"TUNNEL_METRICS_CLIENT_KEY"},
+	}
 	metricsFlag = &cli.StringFlag{
 		Name:  flags.Metrics,
 		Usage: "The metrics server address i.e.: 127.0.0.1:12345. If your instance is running in a Docker/Kubernetes environment you need to setup port forwarding for your application.",
@@ -243,7 +278,7 @@ var (
 	}
 	dnsResolverAddrsFlag = &cli.StringSliceFlag{
 		Name:    flags.VirtualDNSServiceResolverAddresses,
-		Usage:   "Overrides the dynamic DNS resolver resolution to use these address:port's instead.",
+		Usage:   "Overrides the dynamic DNS resolver resolution to use these address:port's instead. When multiple are provided, they are health checked and unanswered UDP queries fail over to another one.",
 		EnvVars: []string{"TUNNEL_DNS_RESOLVER_ADDRS"},
 	}
 )
@@ -503,6 +538,132 @@ func readyCommand(c *cli.Context) error {
 	return nil
 }
 
+func buildPrepareReconnectCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "prepare-reconnect",
+		Action:      cliutil.ConfiguredAction(prepareReconnectCommand),
+		Usage:       "Prepare a running tunnel to reconnect quickly after planned maintenance",
+		UsageText:   "cloudflared tunnel [tunnel command options] prepare-reconnect [subcommand options]",
+		Description: "cloudflared tunnel prepare-reconnect calls the /prepare-reconnect endpoint of a running tunnel before a planned origin host reboot or network maintenance. The tunnel resolves fresh edge addresses, checks that they are reachable, and until the window ends retries dropped connections every second, so that it reconnects within seconds once the maintenance is over. The endpoint is only served when the metrics server authenticates clients, pass --metrics-auth-token to the tunnel command, and --metrics-ca or a client certificate when the metrics server uses TLS.",
+		Flags: []cli.Flag{
+			prepareReconnectWindowFlag,
+			metricsCAFlag,
+			metricsClientCertFlag,
+			metricsClientKeyFlag,
+		},
+		CustomHelpTemplate: commandHelpTemplate(),
+	}
+}
+
+func prepareReconnectCommand(c *cli.Context) error {
+	query := url.Values{}
+	if c.IsSet(prepareWindowFlagName) {
+		query.Set("window", c.Duration(prepareWindowFlagName).String())
+	}
+	body, err := metricsRequest(c, http.MethodPost, "/prepare-reconnect", query)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(os.Stdout, string(body))
+	return err
+}
+
+func buildMaintenanceOverrideCommand() *cli.Command {
+	return &cli.Command{
+		Name:        "maintenance-override",
+		Action:      cliutil.ConfiguredAction(maintenanceOverrideCommand),
+		Usage:       "Allow disruptive actions of a running tunnel outside of its maintenance windows in an emergency",
+		UsageText:   "cloudflared tunnel [tunnel command options] maintenance-override [subcommand options]",
+		Description: "cloudflared tunnel maintenance-override calls the /maintenance-override endpoint of a running tunnel, so that actions deferred to the maintenance windows, e.g. reconnecting with a scheduled protocol, happen right away and until the override expires. The endpoint is only served when the metrics server authenticates clients, pass --metrics-auth-token to the tunnel command, and --metrics-ca or a client certificate when the metrics server uses TLS.",
+		Flags: []cli.Flag{
+			maintenanceOverrideDurationFlag,
+			metricsCAFlag,
+			metricsClientCertFlag,
+			metricsClientKeyFlag,
+		},
+		CustomHelpTemplate: commandHelpTemplate(),
+	}
+}
+
+func maintenanceOverrideCommand(c *cli.Context) error {
+	query := url.Values{}
+	if c.IsSet(overrideDurationFlag) {
+		query.Set("duration", c.Duration(overrideDurationFlag).String())
+	}
+	body, err := metricsRequest(c, http.MethodPost, "/maintenance-override", query)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(os.Stdout, string(body))
+	return err
+}
+
+// metricsRequest sends a request to an endpoint of the metrics server of a running tunnel and returns the body
+// of the response. It presents the token of --metrics-auth-token, and talks HTTPS when the server CA or a client
+// certificate is given, so that it reaches endpoints that require authentication.
+func metricsRequest(c *cli.Context, method, path string, query url.Values) ([]byte, error) {
+	metricsOpts := c.String(flags.Metrics)
+	if !c.IsSet(flags.Metrics) {
+		return nil, errors.New("--metrics has to be provided")
+	}
+
+	client, scheme, err := metricsClient(c)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := fmt.Sprintf("%s://%s%s", scheme, metricsOpts, path)
+	requestURL := endpoint
+	if len(query) > 0 {
+		requestURL += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token := c.String(flags.MetricsAuthToken); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("%s endpoint returned status code %d\n%s", endpoint, res.StatusCode, body)
+	}
+	return body, nil
+}
+
+// metricsClient returns the client and the scheme used to reach the metrics server, matching the TLS
+// settings of the server described by --metrics-tls-cert and --metrics-client-ca.
+func metricsClient(c *cli.Context) (*http.Client, string, error) {
+	caPath, certPath, keyPath := c.String(metricsCAFlagName), c.String(metricsClientCertName), c.String(metricsClientKeyName)
+	if (certPath == "") != (keyPath == "") {
+		return nil, "", fmt.Errorf("%s and %s must be provided together", metricsClientCertName, metricsClientKeyName)
+	}
+	if caPath == "" && certPath == "" {
+		return http.DefaultClient, "http", nil
+	}
+	params := &tlsconfig.TLSParameters{
+		Cert: certPath,
+		Key:  keyPath,
+	}
+	if caPath != "" {
+		params.RootCAs = []string{caPath}
+	}
+	tlsConfig, err := tlsconfig.GetConfig(params)
+	if err != nil {
+		return nil, "", err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{Transport: transport}, "https", nil
+}
+
 func buildInfoCommand() *cli.Command {
 	return &cli.Command{
 		Name:        "info",
@@ -722,6 +883,7 @@ func buildRunCommand() *cli.Command {
 		tunnelTokenFileFlag,
 		icmpv4SrcFlag,
 		icmpv6SrcFlag,
+		icmpSourcePortRangeFlag,
 		maxActiveFlowsFlag,
 		dnsResolverAddrsFlag,
 	}
@@ -777,7 +939,7 @@ func runCommand(c *cli.Context) error {
 		if token, err := ParseToken(tokenStr); err == nil {
 			return sc.runWithCredentials(token.Credentials())
 		}
-		return cliutil.UsageError("Provided Tunnel token is not valid.")
+		return cliutil.NewShutdownError(cliutil.ShutdownReasonAuthFailure, errors.New("Provided Tunnel token is not valid."))
 	} else {
 		tunnelRef := c.Args().First()
 		if tunnelRef == "" {
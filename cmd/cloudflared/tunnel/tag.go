@@ -2,8 +2,18 @@ package tunnel
 
 import (
 	"fmt"
+	"io"
+	"net/http"
+	"os"
 	"regexp"
+	"runtime"
+	"strings"
+	"text/template"
+	"time"
 
+	"github.com/pkg/errors"
+
+	"github.com/cloudflare/cloudflared/cmd/cloudflared/flags"
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
 )
 
@@ -30,3 +40,131 @@ func NewTagSliceFromCLI(tags []string) ([]pogs.Tag, error) {
 	}
 	return tagSlice, nil
 }
+
+// tagFacts are the facts of the host tag templates can refer to, e.g. {{.Hostname}}.
+type tagFacts struct {
+	Hostname string
+	OS       string
+	Arch     string
+}
+
+// expandTagTemplates executes the values of tags as Go templates, so that a fleet of connectors sharing a
+// configuration registers with the tags of each host. Besides the facts of the host, templates can read environment
+// variables with env, and the instance metadata of the cloud provider with metadata when it is set.
+func expandTagTemplates(tags []pogs.Tag, metadata *instanceMetadata) ([]pogs.Tag, error) {
+	// The hostname is left empty when the OS doesn't give it
+	hostname, _ := os.Hostname()
+	facts := tagFacts{Hostname: hostname, OS: runtime.GOOS, Arch: runtime.GOARCH}
+	funcs := template.FuncMap{
+		"env": os.Getenv,
+		"metadata": func(key string) (string, error) {
+			if metadata == nil {
+				return "", fmt.Errorf("set --%s to read the instance metadata %s", flags.TagMetadata, key)
+			}
+			return metadata.get(key)
+		},
+	}
+	expanded := make([]pogs.Tag, 0, len(tags))
+	for _, tag := range tags {
+		if !strings.Contains(tag.Value, "{{") {
+			expanded = append(expanded, tag)
+			continue
+		}
+		tmpl, err := template.New(tag.Name).Option("missingkey=error").Funcs(funcs).Parse(tag.Value)
+		if err != nil {
+			return nil, err
+		}
+		var value strings.Builder
+		if err := tmpl.Execute(&value, facts); err != nil {
+			return nil, err
+		}
+		// The value must still be valid in an HTTP header
+		expandedTag, ok := NewTagFromCLI(tag.Name + "=" + strings.TrimSpace(value.String()))
+		if !ok {
+			return nil, fmt.Errorf("tag %s expands to the invalid value %q", tag.Name, value.String())
+		}
+		expanded = append(expanded, expandedTag)
+	}
+	return expanded, nil
+}
+
+const instanceMetadataTimeout = 2 * time.Second
+
+// instanceMetadata reads the instance metadata service of a cloud provider, caching the values it read.
+type instanceMetadata struct {
+	provider string
+	// baseURL is the address of the metadata service, e.g. http://169.254.169.254
+	baseURL string
+	client  *http.Client
+	values  map[string]string
+}
+
+// newInstanceMetadata returns the instance metadata of provider, or nil when provider is empty.
+func newInstanceMetadata(provider string) (*instanceMetadata, error) {
+	m := &instanceMetadata{
+		provider: provider,
+		client:   &http.Client{Timeout: instanceMetadataTimeout},
+		values:   make(map[string]string),
+	}
+	switch provider {
+	case "":
+		return nil, nil
+	case "aws", "azure":
+		m.baseURL = "http://169.254.169.254"
+	case "gcp":
+		m.baseURL = "http://metadata.google.internal"
+	default:
+		return nil, fmt.Errorf("unknown --%s %q, expected aws, gcp or azure", flags.TagMetadata, provider)
+	}
+	return m, nil
+}
+
+// get returns the metadata at key, a path relative to the instance metadata of the provider, e.g. instance-id on
+// AWS, zone on GCP or compute/location on Azure.
+func (m *instanceMetadata) get(key string) (string, error) {
+	if value, ok := m.values[key]; ok {
+		return value, nil
+	}
+	var value string
+	var err error
+	switch m.provider {
+	case "aws":
+		// IMDSv2 requires a session token
+		var token string
+		token, err = m.fetch(http.MethodPut, m.baseURL+"/latest/api/token", "X-Aws-Ec2-Metadata-Token-Ttl-Seconds", "60")
+		if err == nil {
+			value, err = m.fetch(http.MethodGet, m.baseURL+"/latest/meta-data/"+key, "X-Aws-Ec2-Metadata-Token", token)
+		}
+	case "gcp":
+		value, err = m.fetch(http.MethodGet, m.baseURL+"/computeMetadata/v1/instance/"+key, "Metadata-Flavor", "Google")
+	case "azure":
+		value, err = m.fetch(http.MethodGet, m.baseURL+"/metadata/instance/"+key+"?api-version=2021-02-01&format=text", "Metadata", "true")
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read the %s instance metadata %s", m.provider, key)
+	}
+	m.values[key] = value
+	return value, nil
+}
+
+// fetch requests url from the metadata service with the header the provider requires, and returns the body.
+func (m *instanceMetadata) fetch(method, url, headerName, headerValue string) (string, error) {
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set(headerName, headerValue)
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata service responded with %s", resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
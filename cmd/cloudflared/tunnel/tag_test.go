@@ -1,11 +1,16 @@
 package tunnel
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
 	"testing"
 
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSingleTag(t *testing.T) {
@@ -44,3 +49,71 @@ func TestTagSlice(t *testing.T) {
 	tagSlice, err = NewTagSliceFromCLI([]string{"a=b", "=", "e=f"})
 	assert.Error(t, err)
 }
+
+func TestExpandTagTemplates(t *testing.T) {
+	t.Setenv("TEST_TAG_REGION", "eu-west-1")
+	hostname, err := os.Hostname()
+	require.NoError(t, err)
+
+	tags, err := expandTagTemplates([]pogs.Tag{
+		{Name: "static", Value: "value"},
+		{Name: "host", Value: "{{.Hostname}}"},
+		{Name: "platform", Value: "{{.OS}}/{{.Arch}}"},
+		{Name: "region", Value: `{{env "TEST_TAG_REGION"}}`},
+	}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []pogs.Tag{
+		{Name: "static", Value: "value"},
+		{Name: "host", Value: hostname},
+		{Name: "platform", Value: runtime.GOOS + "/" + runtime.GOARCH},
+		{Name: "region", Value: "eu-west-1"},
+	}, tags)
+
+	for name, value := range map[string]string{
+		"invalid template":        "{{.Hostname",
+		"unknown fact":            "{{.Zone}}",
+		"empty value":             `{{env "TEST_TAG_UNSET"}}`,
+		"metadata without source": `{{metadata "instance-id"}}`,
+	} {
+		_, err := expandTagTemplates([]pogs.Tag{{Name: "tag", Value: value}}, nil)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestExpandTagTemplatesMetadata(t *testing.T) {
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch {
+		case r.Method == http.MethodPut && r.URL.Path == "/latest/api/token":
+			require.Equal(t, "60", r.Header.Get("X-Aws-Ec2-Metadata-Token-Ttl-Seconds"))
+			_, _ = w.Write([]byte("imds-token"))
+		case r.URL.Path == "/latest/meta-data/placement/availability-zone" && r.Header.Get("X-Aws-Ec2-Metadata-Token") == "imds-token":
+			_, _ = w.Write([]byte("eu-west-1a\n"))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	metadata, err := newInstanceMetadata("aws")
+	require.NoError(t, err)
+	metadata.baseURL = server.URL
+	tags, err := expandTagTemplates([]pogs.Tag{
+		{Name: "zone", Value: `{{metadata "placement/availability-zone"}}`},
+		{Name: "location", Value: `aws-{{metadata "placement/availability-zone"}}`},
+	}, metadata)
+	require.NoError(t, err)
+	assert.Equal(t, []pogs.Tag{{Name: "zone", Value: "eu-west-1a"}, {Name: "location", Value: "aws-eu-west-1a"}}, tags)
+	// Values are read once
+	assert.Equal(t, 2, requests)
+
+	_, err = expandTagTemplates([]pogs.Tag{{Name: "id", Value: `{{metadata "instance-id"}}`}}, metadata)
+	assert.Error(t, err)
+
+	metadata, err = newInstanceMetadata("")
+	require.NoError(t, err)
+	assert.Nil(t, metadata)
+	_, err = newInstanceMetadata("oracle")
+	assert.Error(t, err)
+}
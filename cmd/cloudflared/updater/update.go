@@ -14,10 +14,13 @@ import (
 	"github.com/urfave/cli/v2"
 	"golang.org/x/term"
 
+	"github.com/cloudflare/cloudflared/audit"
 	"github.com/cloudflare/cloudflared/cmd/cloudflared/cliutil"
 	cfdflags "github.com/cloudflare/cloudflared/cmd/cloudflared/flags"
 	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/lockdown"
 	"github.com/cloudflare/cloudflared/logger"
+	"github.com/cloudflare/cloudflared/maintenance"
 )
 
 const (
@@ -49,7 +52,7 @@ func (u *statusSuccess) Error() string {
 }
 
 func (u *statusSuccess) ExitCode() int {
-	return 11
+	return cliutil.ExitCodeUpdated
 }
 
 // statusError implements ExitCoder interface, the app will exit with status code 10
@@ -62,7 +65,7 @@ func (e *statusError) Error() string {
 }
 
 func (e *statusError) ExitCode() int {
-	return 10
+	return cliutil.ExitCodeUpdateFailed
 }
 
 type updateOptions struct {
@@ -136,6 +139,12 @@ func applyUpdate(options updateOptions, update CheckResult) UpdateOutcome {
 func Update(c *cli.Context) error {
 	log := logger.CreateLoggerFromContext(c, logger.EnableTerminalLog)
 
+	// The configuration file of an appliance in lockdown mode also locks the binary
+	if c.Bool(cfdflags.Lockdown) {
+		log.Error().Msg("cloudflared can't be updated in lockdown mode")
+		return &statusError{lockdown.ErrLockdown}
+	}
+
 	if wasInstalledFromPackageManager() {
 		packageManagerName := "a package manager"
 		if BuiltForPackageManager != "" {
@@ -202,7 +211,10 @@ func loggedUpdate(log *zerolog.Logger, options updateOptions) UpdateOutcome {
 type AutoUpdater struct {
 	configurable *configurable
 	listeners    *gracenet.Net
-	log          *zerolog.Logger
+	auditLog     *audit.Log
+	// maintenance restricts the updates, and the restarts that follow, to its windows
+	maintenance *maintenance.Windows
+	log         *zerolog.Logger
 }
 
 // AutoUpdaterConfigurable is the attributes of AutoUpdater that can be reconfigured during runtime
@@ -211,10 +223,12 @@ type configurable struct {
 	freq    time.Duration
 }
 
-func NewAutoUpdater(updateDisabled bool, freq time.Duration, listeners *gracenet.Net, log *zerolog.Logger) *AutoUpdater {
+func NewAutoUpdater(updateDisabled bool, freq time.Duration, listeners *gracenet.Net, auditLog *audit.Log, maintenanceWindows *maintenance.Windows, log *zerolog.Logger) *AutoUpdater {
 	return &AutoUpdater{
 		configurable: createUpdateConfig(updateDisabled, freq, log),
 		listeners:    listeners,
+		auditLog:     auditLog,
+		maintenance:  maintenanceWindows,
 		log:          log,
 	}
 }
@@ -237,6 +251,7 @@ func createUpdateConfig(updateDisabled bool, freq time.Duration, log *zerolog.Lo
 // Run will perodically check for cloudflared updates, download them, and then restart the current cloudflared process
 // to use the new version. It delays the first update check by the configured frequency as to not attempt a
 // download immediately and restart after starting (in the case that there is an upgrade available).
+// With maintenance windows, a check that is due outside of them waits for the next window.
 func (a *AutoUpdater) Run(ctx context.Context) error {
 	ticker := time.NewTicker(a.configurable.freq)
 	for {
@@ -245,7 +260,19 @@ func (a *AutoUpdater) Run(ctx context.Context) error {
 			return ctx.Err()
 		case <-ticker.C:
 		}
+		if a.configurable.enabled && !a.maintenance.Open() {
+			a.log.Info().Msg("Waiting for a maintenance window to check for updates")
+			if err := a.maintenance.Wait(ctx); err != nil {
+				return err
+			}
+		}
 		updateOutcome := loggedUpdate(a.log, updateOptions{updateDisabled: !a.configurable.enabled})
+		if updateOutcome.Updated || updateOutcome.Error != nil {
+			a.auditLog.Record(audit.ActionAutoupdate, audit.ActorLocal, updateOutcome.Error, map[string]string{
+				"fromVersion": buildInfo.CloudflaredVersion,
+				"toVersion":   updateOutcome.Version,
+			})
+		}
 		if updateOutcome.Updated {
 			buildInfo.CloudflaredVersion = updateOutcome.Version
 			if IsSysV() {
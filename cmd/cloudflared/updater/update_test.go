@@ -20,7 +20,7 @@ func init() {
 func TestDisabledAutoUpdater(t *testing.T) {
 	listeners := &gracenet.Net{}
 	log := zerolog.Nop()
-	autoupdater := NewAutoUpdater(false, 0, listeners, &log)
+	autoupdater := NewAutoUpdater(false, 0, listeners, nil, nil, &log)
 	ctx, cancel := context.WithCancel(context.Background())
 	errC := make(chan error)
 	go func() {
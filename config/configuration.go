@@ -17,6 +17,7 @@ import (
 	"github.com/urfave/cli/v2"
 	yaml "gopkg.in/yaml.v3"
 
+	"github.com/cloudflare/cloudflared/redact"
 	"github.com/cloudflare/cloudflared/validation"
 )
 
@@ -193,6 +194,8 @@ type OriginRequestConfig struct {
 	ConnectTimeout *CustomDuration `yaml:"connectTimeout" json:"connectTimeout,omitempty"`
 	// HTTP proxy timeout for completing a TLS handshake
 	TLSTimeout *CustomDuration `yaml:"tlsTimeout" json:"tlsTimeout,omitempty"`
+	// HTTP proxy timeout for receiving the response headers once the request is sent, 0 waits indefinitely
+	ResponseHeaderTimeout *CustomDuration `yaml:"responseHeaderTimeout" json:"responseHeaderTimeout,omitempty"`
 	// HTTP proxy TCP keepalive duration
 	TCPKeepAlive *CustomDuration `yaml:"tcpKeepAlive" json:"tcpKeepAlive,omitempty"`
 	// HTTP proxy should disable "happy eyeballs" for IPv4/v6 fallback
@@ -209,6 +212,8 @@ type OriginRequestConfig struct {
 	MatchSNIToHost *bool `yaml:"matchSNItoHost" json:"matchSNItoHost,omitempty"`
 	// Path to the CA for the certificate of your origin.
 	// This option should be used only if your certificate is not signed by Cloudflare.
+	// If the path is a directory, every certificate in it is trusted and the directory is re-scanned
+	// periodically so that rotated CAs are picked up without a restart.
 	CAPool *string `yaml:"caPool" json:"caPool,omitempty"`
 	// Disables TLS verification of the certificate presented by your origin.
 	// Will allow any certificate from the origin to be accepted.
@@ -229,8 +234,188 @@ type OriginRequestConfig struct {
 	IPRules []IngressIPRule `yaml:"ipRules" json:"ipRules,omitempty"`
 	// Attempt to connect to origin with HTTP/2
 	Http2Origin *bool `yaml:"http2Origin" json:"http2Origin,omitempty"`
+	// Attempt to connect to https origins with HTTP/3, falling back to HTTP/2 or HTTP/1.1 if they can't be reached
+	// over QUIC
+	Http3Origin *bool `yaml:"http3Origin" json:"http3Origin,omitempty"`
+	// Directory request bodies are buffered to when the origin reads them slower than the edge delivers them.
+	// Buffering is disabled when unset.
+	RequestBodyBufferDir *string `yaml:"requestBodyBufferDir" json:"requestBodyBufferDir,omitempty"`
+	// Maximum bytes of a request body buffered to disk, 64 MB by default
+	RequestBodyBufferMaxSize *int `yaml:"requestBodyBufferMaxSize" json:"requestBodyBufferMaxSize,omitempty"`
 	// Access holds all access related configs
 	Access *AccessConfig `yaml:"access" json:"access,omitempty"`
+	// Headers added to requests to the origin, mapping each header name to the registration tag whose value it is
+	// set to. E.g. `X-Connector-Site: site` tells the origin which connector served the request.
+	TagHeaders map[string]string `yaml:"tagHeaders" json:"tagHeaders,omitempty"`
+	// Header the ID of each request is sent to the origin in, e.g. X-Request-ID. The ID is the request's cf-ray, or a
+	// generated one when it has none, and is logged with the request.
+	RequestIDHeader *string `yaml:"requestIDHeader" json:"requestIDHeader,omitempty"`
+	// Minimum TLS version to reach https origins with: 1.0, 1.1, 1.2 or 1.3. Defaults to 1.2
+	TLSMinVersion *string `yaml:"tlsMinVersion" json:"tlsMinVersion,omitempty"`
+	// Maximum TLS version to reach https origins with: 1.0, 1.1, 1.2 or 1.3. Defaults to 1.3
+	TLSMaxVersion *string `yaml:"tlsMaxVersion" json:"tlsMaxVersion,omitempty"`
+	// TLS 1.0-1.2 cipher suites allowed with https origins, by their IANA name, e.g.
+	// TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256. TLS 1.3 cipher suites aren't configurable, so setting them caps the
+	// maximum TLS version at 1.2 unless tlsMaxVersion is set.
+	TLSCipherSuites []string `yaml:"tlsCipherSuites" json:"tlsCipherSuites,omitempty"`
+	// Hex encoded SHA-256 fingerprint of the certificate the origin must present. The certificate is trusted if it
+	// matches, whoever issued it, which is safer than disabling verification with noTLSVerify. Several fingerprints
+	// separated by commas can be pinned while the origin rotates its certificate.
+	OriginCertFingerprint *string `yaml:"originCertFingerprint" json:"originCertFingerprint,omitempty"`
+	// Socks restricts who can use a socks-proxy service and how much
+	Socks *SocksConfig `yaml:"socks" json:"socks,omitempty"`
+	// SessionRecording records the sessions of bastion and TCP services, e.g. SSH, for compliance
+	SessionRecording *SessionRecordingConfig `yaml:"sessionRecording" json:"sessionRecording,omitempty"`
+	// Optimizes TCP services for interactive protocols, e.g. RDP and VNC: data is forwarded in small writes which
+	// take priority over bulk transfers, such as downloads, through the tunnel
+	LatencyMode *bool `yaml:"latencyMode" json:"latencyMode,omitempty"`
+	// Sends a PROXY protocol v2 header with the IP of the client to TCP services, e.g. for HAProxy or PostgreSQL
+	// to see who is connecting
+	ProxyProtocol *bool `yaml:"proxyProtocol" json:"proxyProtocol,omitempty"`
+	// How long a session of a udp:// service is kept without datagrams in either direction. Defaults to 60s
+	UDPIdleTimeout *CustomDuration `yaml:"udpIdleTimeout" json:"udpIdleTimeout,omitempty"`
+	// CORS answers preflight requests and adds CORS headers to responses, without involving the origin
+	CORS *CORSConfig `yaml:"cors" json:"cors,omitempty"`
+	// ExtAuthz asks an external HTTP service to authorize every request before it is proxied
+	ExtAuthz *ExtAuthzConfig `yaml:"extAuthz" json:"extAuthz,omitempty"`
+	// DNS resolves the hostname of the origin differently than the host of cloudflared, e.g. with resolvers that
+	// know internal names
+	DNS *OriginDNSConfig `yaml:"dns" json:"dns,omitempty"`
+	// Warmup keeps connections to HTTP origins open while idle, so requests don't wait for them to be established
+	Warmup *OriginWarmupConfig `yaml:"warmup" json:"warmup,omitempty"`
+	// Transform runs a script over the requests to HTTP origins and their responses, e.g. to mint auth headers or
+	// rewrite URLs. Experimental
+	Transform *TransformConfig `yaml:"transform" json:"transform,omitempty"`
+	// Order requests go through the middleware of the rule, e.g. [extAuthz, access]. Set at the top level, it orders
+	// the middleware of every rule. Defaults to access, then extAuthz
+	Middleware []string `yaml:"middleware" json:"middleware,omitempty"`
+}
+
+type TransformConfig struct {
+	// Path of the Lua 5.1 script, WASM modules aren't supported. The script can define on_request(req) and
+	// on_response(resp), which change the tables they are given. It runs sandboxed, without the io, os and package
+	// libraries
+	Lua string `yaml:"lua" json:"lua"`
+	// Maximum bytes of the request and response bodies given to the script, larger bodies are streamed without it.
+	// Bodies are left out when 0, the default
+	MaxBodySize int64 `yaml:"maxBodySize" json:"maxBodySize,omitempty"`
+	// How long each call of the script may run before the request fails. Defaults to 100ms
+	Timeout *CustomDuration `yaml:"timeout" json:"timeout,omitempty"`
+}
+
+type OriginWarmupConfig struct {
+	// Number of connections kept open to the origin
+	Connections int `yaml:"connections" json:"connections"`
+	// How often the connections are checked with a HEAD request, which also keeps them from idling out. Defaults to
+	// 30s, and should be shorter than keepAliveTimeout
+	PingInterval *CustomDuration `yaml:"pingInterval" json:"pingInterval,omitempty"`
+	// Path of the HEAD requests. Defaults to /
+	PingPath string `yaml:"pingPath" json:"pingPath,omitempty"`
+}
+
+type OriginDNSConfig struct {
+	// Addresses of hostnames, which are then not resolved, e.g. `db.internal: [10.0.0.5]`
+	Hosts map[string][]string `yaml:"hosts" json:"hosts,omitempty"`
+	// DNS servers resolving origin hostnames instead of those of the host, e.g. 10.0.0.53 or 10.0.0.53:5353. They are
+	// tried in order and their answers are cached for their TTL
+	Resolvers []string `yaml:"resolvers" json:"resolvers,omitempty"`
+	// Address family dialed first when a hostname has both: ipv4 or ipv6. Defaults to the order of the answers
+	IPPreference string `yaml:"ipPreference" json:"ipPreference,omitempty"`
+}
+
+type ExtAuthzConfig struct {
+	// URL of the authorization service. It gets a GET request with the headers of the request to authorize, plus
+	// X-Forwarded-Method, X-Forwarded-Host and X-Forwarded-Uri, and allows the request by answering with a 2xx status
+	URL string `yaml:"url" json:"url"`
+	// How long to wait for the authorization service. Defaults to 5s
+	Timeout *CustomDuration `yaml:"timeout" json:"timeout,omitempty"`
+	// Whether requests are allowed or denied when the authorization service can't be reached: allow or deny.
+	// Defaults to deny
+	FailurePolicy string `yaml:"failurePolicy" json:"failurePolicy,omitempty"`
+}
+
+type CORSConfig struct {
+	// Origins allowed to make cross-origin requests, e.g. https://app.example.com, https://*.example.com or * for
+	// any origin
+	AllowedOrigins []string `yaml:"allowedOrigins" json:"allowedOrigins,omitempty"`
+	// Methods allowed in cross-origin requests. Defaults to GET, HEAD and POST
+	AllowedMethods []string `yaml:"allowedMethods" json:"allowedMethods,omitempty"`
+	// Request headers allowed in cross-origin requests, or * for any header
+	AllowedHeaders []string `yaml:"allowedHeaders" json:"allowedHeaders,omitempty"`
+	// Response headers browsers expose to the scripts of the allowed origins
+	ExposedHeaders []string `yaml:"exposedHeaders" json:"exposedHeaders,omitempty"`
+	// Whether cross-origin requests can include credentials, e.g. cookies
+	AllowCredentials bool `yaml:"allowCredentials" json:"allowCredentials,omitempty"`
+	// How long browsers can cache the answers to preflight requests
+	MaxAge *CustomDuration `yaml:"maxAge" json:"maxAge,omitempty"`
+}
+
+type SocksConfig struct {
+	// Users that authenticate with a username and password. Clients can't connect anonymously once any is set.
+	Users []SocksUser `yaml:"users" json:"users,omitempty"`
+	// CIDRs the clients must connect from, per their Cf-Connecting-IP. Any client is allowed when empty.
+	ClientCIDRs []string `yaml:"clientCIDRs" json:"clientCIDRs,omitempty"`
+	// Maximum concurrent connections through the proxy, unlimited when 0
+	MaxConnections int `yaml:"maxConnections" json:"maxConnections,omitempty"`
+	// Maximum concurrent connections of each user, unlimited when 0
+	MaxConnectionsPerUser int `yaml:"maxConnectionsPerUser" json:"maxConnectionsPerUser,omitempty"`
+	// Maximum bytes per second of each connection in each direction, unlimited when 0
+	BandwidthLimit int64 `yaml:"bandwidthLimit" json:"bandwidthLimit,omitempty"`
+}
+
+type SocksUser struct {
+	Username string `yaml:"username" json:"username"`
+	Password string `yaml:"password" json:"password"`
+	// Destinations the user can connect to, on top of the ipRules of the service. The user can reach every
+	// destination the ipRules allow when empty.
+	IPRules []IngressIPRule `yaml:"ipRules" json:"ipRules,omitempty"`
+}
+
+// MarshalJSON hides the password, e.g. from the configuration served by the metrics server.
+func (u SocksUser) MarshalJSON() ([]byte, error) {
+	type socksUser SocksUser
+	u.Password = redact.Placeholder
+	return json.Marshal(socksUser(u))
+}
+
+type SessionRecordingConfig struct {
+	// Directory recordings are written to, and kept in unless they are uploaded to S3
+	Dir string `yaml:"dir" json:"dir"`
+	// Format of the recordings: raw, the bytes in each direction, or asciicast for plaintext terminal protocols.
+	// Recordings of SSH sessions are ciphertext only: cloudflared forwards the encrypted SSH stream and never holds
+	// its keys, so the commands and output of the session can't be read or replayed from the recording.
+	Format string `yaml:"format" json:"format,omitempty"`
+	// Maximum bytes recorded per session, unlimited when 0
+	MaxSize int64 `yaml:"maxSize" json:"maxSize,omitempty"`
+	// Maximum duration recorded per session, unlimited when 0
+	MaxDuration CustomDuration `yaml:"maxDuration" json:"maxDuration,omitempty"`
+	// S3-compatible bucket recordings are uploaded to once complete
+	S3 *SessionRecordingS3Config `yaml:"s3" json:"s3,omitempty"`
+}
+
+type SessionRecordingS3Config struct {
+	Endpoint string `yaml:"endpoint" json:"endpoint"`
+	Region   string `yaml:"region" json:"region,omitempty"`
+	Bucket   string `yaml:"bucket" json:"bucket"`
+	Prefix   string `yaml:"prefix" json:"prefix,omitempty"`
+	// Credentials default to the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN environment variables
+	AccessKeyID     string `yaml:"accessKeyID" json:"accessKeyID,omitempty"`
+	SecretAccessKey string `yaml:"secretAccessKey" json:"secretAccessKey,omitempty"`
+	// Session token of temporary credentials
+	SessionToken string `yaml:"sessionToken" json:"sessionToken,omitempty"`
+}
+
+// MarshalJSON hides the secret access key and session token, e.g. from the configuration served by the metrics
+// server.
+func (c SessionRecordingS3Config) MarshalJSON() ([]byte, error) {
+	type s3Config SessionRecordingS3Config
+	if c.SecretAccessKey != "" {
+		c.SecretAccessKey = redact.Placeholder
+	}
+	if c.SessionToken != "" {
+		c.SessionToken = redact.Placeholder
+	}
+	return json.Marshal(s3Config(c))
 }
 
 type AccessConfig struct {
@@ -257,13 +442,47 @@ type Configuration struct {
 	Ingress       []UnvalidatedIngressRule
 	WarpRouting   WarpRoutingConfig   `yaml:"warp-routing"`
 	OriginRequest OriginRequestConfig `yaml:"originRequest"`
-	sourceFile    string
+	// FeatureOverrides force features sent to the edge on or off, to work around a regression of a feature
+	FeatureOverrides FeatureOverridesConfig `yaml:"featureOverrides"`
+	// ProtocolSchedule forces a protocol to the edge at scheduled times, e.g. http2 during a known UDP outage
+	ProtocolSchedule []ProtocolScheduleRule `yaml:"protocolSchedule"`
+	// MaintenanceWindows restrict autoupdates and scheduled reconnections to known times
+	MaintenanceWindows []MaintenanceWindow `yaml:"maintenanceWindows"`
+	sourceFile         string
+}
+
+type ProtocolScheduleRule struct {
+	// Cron expression (minute hour day-of-month month day-of-week, in local time) of the times the window opens
+	Schedule string `yaml:"schedule"`
+	// How long the window stays open
+	Duration CustomDuration `yaml:"duration"`
+	// Protocol forced while the window is open: quic or http2
+	Protocol string `yaml:"protocol"`
+}
+
+type MaintenanceWindow struct {
+	// Cron expression (minute hour day-of-month month day-of-week, in local time) of the times the window opens
+	Schedule string `yaml:"schedule"`
+	// How long the window stays open
+	Duration CustomDuration `yaml:"duration"`
+}
+
+type FeatureOverridesConfig struct {
+	Enable  []string `yaml:"enable"`
+	Disable []string `yaml:"disable"`
 }
 
 type WarpRoutingConfig struct {
 	ConnectTimeout *CustomDuration `yaml:"connectTimeout" json:"connectTimeout,omitempty"`
 	MaxActiveFlows *uint64         `yaml:"maxActiveFlows" json:"maxActiveFlows,omitempty"`
 	TCPKeepAlive   *CustomDuration `yaml:"tcpKeepAlive" json:"tcpKeepAlive,omitempty"`
+	// Clamps the MSS of TCP connections to private network origins, e.g. 1220 for a path with an MTU of 1280.
+	// Only supported on Linux and macOS.
+	TCPMaxSegmentSize *int `yaml:"tcpMaxSegmentSize" json:"tcpMaxSegmentSize,omitempty"`
+	// Socket receive and send buffer sizes in bytes of TCP connections to private network origins, which bound
+	// the window of each flow.
+	TCPReadBufferSize  *int `yaml:"tcpReadBufferSize" json:"tcpReadBufferSize,omitempty"`
+	TCPWriteBufferSize *int `yaml:"tcpWriteBufferSize" json:"tcpWriteBufferSize,omitempty"`
 }
 
 type configFileSettings struct {
@@ -51,6 +51,10 @@ warp-routing:
   enabled: true
   connectTimeout: 2s
   tcpKeepAlive: 10s
+protocolSchedule:
+ - schedule: "0 1 * * *"
+   duration: 4h
+   protocol: http2
 
 retries: 5
 grace-period: 30s
@@ -71,6 +75,11 @@ counters:
 	assert.Equal(t, firstIngress, config.Ingress[0])
 	assert.Equal(t, secondIngress, config.Ingress[1])
 	assert.Equal(t, warpRouting, config.WarpRouting)
+	assert.Equal(t, []ProtocolScheduleRule{{
+		Schedule: "0 1 * * *",
+		Duration: CustomDuration{Duration: 4 * time.Hour},
+		Protocol: "http2",
+	}}, config.ProtocolSchedule)
 	privateV4 := "10.0.0.0/8"
 	privateV6 := "fc00::/7"
 	ipRules := []IngressIPRule{
@@ -144,7 +153,13 @@ var rawJsonConfig = []byte(`
 			"allow": true
 		}
 	],
-	"http2Origin": true
+	"http2Origin": true,
+	"tagHeaders": {
+		"X-Connector-Site": "site"
+	},
+	"tlsMinVersion": "1.2",
+	"tlsCipherSuites": ["TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"],
+	"middleware": ["extAuthz", "access"]
 }
 `)
 
@@ -192,6 +207,10 @@ func assertConfig(
 	assert.Equal(t, uint(9000), *config.ProxyPort)
 	assert.Equal(t, "socks", *config.ProxyType)
 	assert.Equal(t, true, *config.Http2Origin)
+	assert.Equal(t, map[string]string{"X-Connector-Site": "site"}, config.TagHeaders)
+	assert.Equal(t, "1.2", *config.TLSMinVersion)
+	assert.Equal(t, []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"}, config.TLSCipherSuites)
+	assert.Equal(t, []string{"extAuthz", "access"}, config.Middleware)
 
 	privateV4 := "10.0.0.0/8"
 	privateV6 := "fc00::/7"
@@ -217,3 +236,23 @@ func assertConfig(
 
 	require.Equal(t, config2, config)
 }
+
+func TestSocksUserMarshalJSONHidesPassword(t *testing.T) {
+	var user SocksUser
+	require.NoError(t, json.Unmarshal([]byte(`{"username": "alice", "password": "alice-password"}`), &user))
+	assert.Equal(t, "alice-password", user.Password)
+
+	content, err := json.Marshal(user)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "alice-password")
+	assert.Contains(t, string(content), `"username":"alice"`)
+}
+
+func TestSessionRecordingS3MarshalJSONHidesSecret(t *testing.T) {
+	s3 := SessionRecordingS3Config{Endpoint: "https://s3.example.com", Bucket: "recordings", AccessKeyID: "id", SecretAccessKey: "s3-secret", SessionToken: "s3-token"}
+	content, err := json.Marshal(s3)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "s3-secret")
+	assert.NotContains(t, string(content), "s3-token")
+	assert.Contains(t, string(content), `"accessKeyID":"id"`)
+}
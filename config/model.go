@@ -34,6 +34,9 @@ type DNSResolver struct {
 	Upstreams              []string `json:"upstreams,omitempty"`
 	Bootstraps             []string `json:"bootstraps,omitempty"`
 	MaxUpstreamConnections int      `json:"max_upstream_connections,omitempty"`
+	UpstreamPolicy         string   `json:"upstream_policy,omitempty"`
+	ECS                    string   `json:"ecs,omitempty"`
+	Blocklist              string   `json:"blocklist,omitempty"`
 }
 
 // Root is the base options to configure the service
@@ -65,6 +68,9 @@ func (r *DNSResolver) Hash() string {
 	_, _ = io.WriteString(h, fmt.Sprintf("%d", r.Port))
 	_, _ = io.WriteString(h, fmt.Sprintf("%d", r.MaxUpstreamConnections))
 	_, _ = io.WriteString(h, fmt.Sprintf("%v", r.Enabled))
+	_, _ = io.WriteString(h, r.UpstreamPolicy)
+	_, _ = io.WriteString(h, r.ECS)
+	_, _ = io.WriteString(h, r.Blocklist)
 	return fmt.Sprintf("%x", h.Sum(nil))
 }
 
@@ -130,6 +130,20 @@ func (t Type) shouldFlush() bool {
 	}
 }
 
+// trafficClass returns the TrafficClass of the streams of this kind of connection, false if they aren't counted.
+func (t Type) trafficClass() (TrafficClass, bool) {
+	switch t {
+	case TypeWebsocket, TypeHTTP:
+		return TrafficHTTP, true
+	case TypeTCP:
+		return TrafficTCP, true
+	case TypeControlStream:
+		return TrafficControl, true
+	default:
+		return "", false
+	}
+}
+
 func (t Type) String() string {
 	switch t {
 	case TypeWebsocket:
@@ -91,6 +91,12 @@ func (moc *mockOriginProxy) ProxyHTTP(
 		originRespEndpoint(w, http.StatusOK, []byte(http.StatusText(http.StatusOK)))
 	case "/large_file":
 		originRespEndpoint(w, http.StatusOK, testLargeResp)
+	case "/echo":
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return err
+		}
+		originRespEndpoint(w, http.StatusOK, body)
 	case "/400":
 		originRespEndpoint(w, http.StatusBadRequest, []byte(http.StatusText(http.StatusBadRequest)))
 	case "/500":
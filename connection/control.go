@@ -4,17 +4,22 @@ import (
 	"context"
 	"io"
 	"net"
+	"strconv"
 	"time"
 
 	"github.com/pkg/errors"
 
+	"github.com/cloudflare/cloudflared/client"
+	"github.com/cloudflare/cloudflared/edgediscovery"
 	"github.com/cloudflare/cloudflared/management"
 	"github.com/cloudflare/cloudflared/tunnelrpc"
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
 )
 
+var errControlStreamHeartbeat = errors.New("edge stopped responding to control stream heartbeats")
+
 // registerClient derives a named tunnel rpc client that can then be used to register and unregister connections.
-type registerClientFunc func(context.Context, io.ReadWriteCloser, time.Duration) tunnelrpc.RegistrationClient
+type registerClientFunc func(context.Context, io.ReadWriteCloser, tunnelrpc.RegistrationTimeouts) tunnelrpc.RegistrationClient
 
 type controlStream struct {
 	observer *Observer
@@ -26,19 +31,40 @@ type controlStream struct {
 	protocol         Protocol
 
 	registerClientFunc registerClientFunc
-	registerTimeout    time.Duration
+	registerTimeouts   tunnelrpc.RegistrationTimeouts
 
 	gracefulShutdownC <-chan struct{}
 	gracePeriod       time.Duration
 	stoppedGracefully bool
+	unregisteredC     chan struct{}
+
+	secondaryControlPlane ControlPlane
+	controlPlaneHealth    *ControlPlaneHealth
+	// registeredOverSecondary is true if the connection was registered over the secondary control plane
+	registeredOverSecondary bool
+
+	heartbeat ControlStreamHeartbeat
+}
+
+// ControlStreamHeartbeat configures the heartbeats sent over a registered control stream to detect an edge that
+// stopped responding before the transport notices it.
+type ControlStreamHeartbeat struct {
+	// Interval between heartbeats. 0 disables heartbeats.
+	Interval time.Duration
+	// MaxMisses is the number of consecutive failed heartbeats after which the connection is declared dead.
+	MaxMisses uint
+	// OnHeartbeat, if set, is called with the index of the connection after each answered heartbeat.
+	OnHeartbeat func(connIndex uint8)
 }
 
 // ControlStreamHandler registers connections with origintunneld and initiates graceful shutdown.
 type ControlStreamHandler interface {
 	// ServeControlStream handles the control plane of the transport in the current goroutine calling this
-	ServeControlStream(ctx context.Context, rw io.ReadWriteCloser, connOptions *pogs.ConnectionOptions, tunnelConfigGetter TunnelConfigJSONGetter) error
+	ServeControlStream(ctx context.Context, rw io.ReadWriteCloser, connOptions *client.ConnectionOptionsSnapshot, tunnelConfigGetter TunnelConfigJSONGetter) error
 	// IsStopped tells whether the method above has finished
 	IsStopped() bool
+	// Unregistered is closed once the connection was unregistered from the edge
+	Unregistered() <-chan struct{}
 }
 
 type TunnelConfigJSONGetter interface {
@@ -53,10 +79,13 @@ func NewControlStream(
 	connIndex uint8,
 	edgeAddress net.IP,
 	registerClientFunc registerClientFunc,
-	registerTimeout time.Duration,
+	registerTimeouts tunnelrpc.RegistrationTimeouts,
 	gracefulShutdownC <-chan struct{},
 	gracePeriod time.Duration,
 	protocol Protocol,
+	secondaryControlPlane ControlPlane,
+	controlPlaneHealth *ControlPlaneHealth,
+	heartbeat ControlStreamHeartbeat,
 ) ControlStreamHandler {
 	if registerClientFunc == nil {
 		registerClientFunc = tunnelrpc.NewRegistrationClient
@@ -66,43 +95,44 @@ func NewControlStream(
 		connectedFuse:      connectedFuse,
 		tunnelProperties:   tunnelProperties,
 		registerClientFunc: registerClientFunc,
-		registerTimeout:    registerTimeout,
+		registerTimeouts:   registerTimeouts,
 		connIndex:          connIndex,
 		edgeAddress:        edgeAddress,
 		gracefulShutdownC:  gracefulShutdownC,
 		gracePeriod:        gracePeriod,
+		unregisteredC:      make(chan struct{}),
 		protocol:           protocol,
+
+		secondaryControlPlane: secondaryControlPlane,
+		controlPlaneHealth:    controlPlaneHealth,
+
+		heartbeat: heartbeat,
 	}
 }
 
 func (c *controlStream) ServeControlStream(
 	ctx context.Context,
 	rw io.ReadWriteCloser,
-	connOptions *pogs.ConnectionOptions,
+	connOptions *client.ConnectionOptionsSnapshot,
 	tunnelConfigGetter TunnelConfigJSONGetter,
 ) error {
-	registrationClient := c.registerClientFunc(ctx, rw, c.registerTimeout)
-	c.observer.logConnecting(c.connIndex, c.edgeAddress, c.protocol)
-	registrationDetails, err := registrationClient.RegisterConnection(
-		ctx,
-		c.tunnelProperties.Credentials.Auth(),
-		c.tunnelProperties.Credentials.TunnelID,
-		connOptions,
-		c.connIndex,
-		c.edgeAddress)
+	registrationClient, registrationDetails, err := c.register(ctx, rw, connOptions.ConnectionOptions())
 	if err != nil {
-		defer registrationClient.Close()
 		if err.Error() == DuplicateConnectionError {
 			c.observer.metrics.regFail.WithLabelValues("dup_edge_conn", "registerConnection").Inc()
 			return errDuplicationConnection
 		}
 		c.observer.metrics.regFail.WithLabelValues("server_error", "registerConnection").Inc()
-		return serverRegistrationErrorFromRPC(err)
+		regErr := serverRegistrationErrorFromRPC(err)
+		if regErr.RateLimited {
+			c.observer.metrics.regRateLimited.Inc()
+		}
+		return regErr
 	}
 	c.observer.metrics.regSuccess.WithLabelValues("registerConnection").Inc()
 
 	c.observer.logConnected(registrationDetails.UUID, c.connIndex, registrationDetails.Location, c.edgeAddress, c.protocol)
-	c.observer.sendConnectedEvent(c.connIndex, c.protocol, registrationDetails.Location, c.edgeAddress)
+	c.observer.sendConnectedEvent(registrationDetails.UUID, c.connIndex, c.protocol, registrationDetails.Location, c.edgeAddress, connOptions)
 	c.connectedFuse.Connected()
 
 	// if conn index is 0 and tunnel is not remotely managed, then send local ingress rules configuration
@@ -121,9 +151,89 @@ func (c *controlStream) ServeControlStream(
 	return c.waitForUnregister(ctx, registrationClient)
 }
 
+// register registers the connection over the primary control stream rw. If the primary control stream is
+// degraded, or times out often enough to be considered degraded, the registration is retried over the
+// secondary control plane. On failure the returned client is already closed.
+func (c *controlStream) register(
+	ctx context.Context,
+	rw io.ReadWriteCloser,
+	connOptions *pogs.ConnectionOptions,
+) (tunnelrpc.RegistrationClient, *pogs.ConnectionDetails, error) {
+	useSecondary := c.secondaryControlPlane != nil && c.controlPlaneHealth.Enabled()
+	if !useSecondary || !c.controlPlaneHealth.IsDegraded(c.connIndex) {
+		registrationClient, registrationDetails, err := c.registerOver(ctx, rw, c.protocol, connOptions)
+		if err == nil {
+			c.controlPlaneHealth.recordSuccess(c.connIndex)
+			return registrationClient, registrationDetails, nil
+		}
+		if !isRPCTimeout(ctx, err) {
+			return nil, nil, err
+		}
+		c.controlPlaneHealth.recordTimeout(c.connIndex)
+		if !useSecondary || !c.controlPlaneHealth.IsDegraded(c.connIndex) {
+			return nil, nil, err
+		}
+	}
+
+	c.observer.log.Warn().
+		Uint8(LogFieldConnIndex, c.connIndex).
+		IPAddr(LogFieldIPAddress, c.edgeAddress).
+		Msgf("Control stream over %s is degraded, registering over %s instead", c.protocol, c.secondaryControlPlane.Protocol())
+	stream, err := c.secondaryControlPlane.OpenControlStream(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.observer.metrics.secondaryControlPlaneRegistrations.Inc()
+	c.registeredOverSecondary = true
+	return c.registerOver(ctx, stream, c.secondaryControlPlane.Protocol(), connOptions)
+}
+
+func (c *controlStream) registerOver(
+	ctx context.Context,
+	rw io.ReadWriteCloser,
+	protocol Protocol,
+	connOptions *pogs.ConnectionOptions,
+) (tunnelrpc.RegistrationClient, *pogs.ConnectionDetails, error) {
+	registrationClient := c.registerClientFunc(ctx, rw, c.registerTimeouts)
+	c.observer.logConnecting(c.connIndex, c.edgeAddress, protocol)
+	c.observer.sendConnectingEvent(c.connIndex, protocol, c.edgeAddress)
+	registerStart := time.Now()
+	registrationDetails, err := registrationClient.RegisterConnection(
+		ctx,
+		c.tunnelProperties.Credentials.Auth(),
+		c.tunnelProperties.Credentials.TunnelID,
+		connOptions,
+		c.connIndex,
+		c.edgeAddress)
+	edgediscovery.ObserveConnectPhase(edgediscovery.ConnectPhaseRegister, protocol.String(), registerStart, err)
+	if err != nil {
+		registrationClient.Close()
+		return nil, nil, err
+	}
+	return registrationClient, registrationDetails, nil
+}
+
+// isRPCTimeout returns true if the RPC failed because its own deadline expired rather than because the
+// connection is shutting down.
+func isRPCTimeout(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && errors.Is(err, context.DeadlineExceeded)
+}
+
 func (c *controlStream) waitForUnregister(ctx context.Context, registrationClient tunnelrpc.RegistrationClient) error {
 	// wait for connection termination or start of graceful shutdown
 	defer registrationClient.Close()
+
+	heartbeatCtx, cancelHeartbeat := context.WithCancel(ctx)
+	defer cancelHeartbeat()
+	heartbeatErrC := make(chan error, 1)
+	if c.heartbeat.Interval > 0 {
+		go func() {
+			if err := c.runHeartbeat(heartbeatCtx, registrationClient); err != nil {
+				heartbeatErrC <- err
+			}
+		}()
+	}
+
 	var shutdownError error
 	select {
 	case <-ctx.Done():
@@ -131,10 +241,26 @@ func (c *controlStream) waitForUnregister(ctx context.Context, registrationClien
 		break
 	case <-c.gracefulShutdownC:
 		c.stoppedGracefully = true
+	case err := <-heartbeatErrC:
+		// The edge is not responding, so there is no point in trying to unregister.
+		return err
 	}
+	cancelHeartbeat()
 
 	c.observer.sendUnregisteringEvent(c.connIndex)
-	err := registrationClient.GracefulShutdown(ctx, c.gracePeriod)
+	// The retry over the secondary control plane shares the grace period with the first attempt, so that
+	// unregistering is over within the grace period whichever way it goes
+	unregisterCtx := ctx
+	if c.gracePeriod > 0 {
+		var cancel context.CancelFunc
+		unregisterCtx, cancel = context.WithTimeout(ctx, c.gracePeriod)
+		defer cancel()
+	}
+	err := registrationClient.GracefulShutdown(unregisterCtx, c.gracePeriod)
+	if isRPCTimeout(unregisterCtx, err) && c.secondaryControlPlane != nil && c.controlPlaneHealth.Enabled() && !c.registeredOverSecondary {
+		err = c.unregisterOverSecondary(unregisterCtx)
+	}
+	close(c.unregisteredC)
 	if err != nil {
 		return errors.Wrap(err, "Error shutting down control stream")
 	}
@@ -146,6 +272,70 @@ func (c *controlStream) waitForUnregister(ctx context.Context, registrationClien
 	return shutdownError
 }
 
+// unregisterOverSecondary retries the unregistration over the secondary control plane after it timed out over the
+// primary control stream.
+func (c *controlStream) unregisterOverSecondary(ctx context.Context) error {
+	c.controlPlaneHealth.recordTimeout(c.connIndex)
+	c.observer.log.Warn().
+		Uint8(LogFieldConnIndex, c.connIndex).
+		IPAddr(LogFieldIPAddress, c.edgeAddress).
+		Msgf("Unregistration over %s timed out, retrying over %s", c.protocol, c.secondaryControlPlane.Protocol())
+	stream, err := c.secondaryControlPlane.OpenControlStream(ctx)
+	if err != nil {
+		return err
+	}
+	c.observer.metrics.secondaryControlPlaneUnregistrations.Inc()
+	registrationClient := c.registerClientFunc(ctx, stream, c.registerTimeouts)
+	defer registrationClient.Close()
+	return registrationClient.GracefulShutdown(ctx, c.gracePeriod)
+}
+
+// runHeartbeat sends heartbeats until ctx is done. It returns errControlStreamHeartbeat once MaxMisses
+// consecutive heartbeats failed.
+func (c *controlStream) runHeartbeat(ctx context.Context, registrationClient tunnelrpc.RegistrationClient) error {
+	maxMisses := c.heartbeat.MaxMisses
+	if maxMisses == 0 {
+		maxMisses = 1
+	}
+	ticker := time.NewTicker(c.heartbeat.Interval)
+	defer ticker.Stop()
+
+	var misses uint
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		rtt, err := registrationClient.Heartbeat(ctx)
+		if err == nil {
+			misses = 0
+			c.observer.metrics.heartbeatRTT.WithLabelValues(strconv.Itoa(int(c.connIndex))).Set(rtt.Seconds())
+			if c.heartbeat.OnHeartbeat != nil {
+				c.heartbeat.OnHeartbeat(c.connIndex)
+			}
+			continue
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		misses++
+		c.observer.metrics.heartbeatMisses.Inc()
+		c.observer.log.Warn().Err(err).
+			Uint8(LogFieldConnIndex, c.connIndex).
+			IPAddr(LogFieldIPAddress, c.edgeAddress).
+			Msgf("Control stream heartbeat failed (%d/%d)", misses, maxMisses)
+		if misses >= maxMisses {
+			return errControlStreamHeartbeat
+		}
+	}
+}
+
 func (c *controlStream) IsStopped() bool {
 	return c.stoppedGracefully
 }
+
+func (c *controlStream) Unregistered() <-chan struct{} {
+	return c.unregisteredC
+}
@@ -0,0 +1,204 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/rs/zerolog"
+	"golang.org/x/net/http2"
+)
+
+var errControlPlaneClosed = errors.New("secondary control plane closed before a control stream was opened")
+
+// ControlPlane opens the stream used to carry registration and unregistration RPCs for a connection.
+// It abstracts the transport so that the RPCs can be carried over a different protocol than the one serving
+// the data plane.
+type ControlPlane interface {
+	// Protocol is the transport used to carry the control stream.
+	Protocol() Protocol
+	// OpenControlStream blocks until a control stream is available or ctx is done.
+	OpenControlStream(ctx context.Context) (io.ReadWriteCloser, error)
+}
+
+// ControlPlaneHealth counts consecutive registration timeouts on the primary control stream of every
+// connection index. Once the count reaches the threshold, the control stream for that index is considered
+// degraded and registration is attempted over the secondary control plane. It is shared between reconnects.
+type ControlPlaneHealth struct {
+	mu        sync.Mutex
+	timeouts  map[uint8]uint
+	threshold uint
+}
+
+// NewControlPlaneHealth returns a ControlPlaneHealth. A threshold of 0 disables the secondary control plane.
+func NewControlPlaneHealth(threshold uint) *ControlPlaneHealth {
+	return &ControlPlaneHealth{
+		timeouts:  make(map[uint8]uint),
+		threshold: threshold,
+	}
+}
+
+// Enabled returns true if the secondary control plane may be used.
+func (h *ControlPlaneHealth) Enabled() bool {
+	return h != nil && h.threshold > 0
+}
+
+// IsDegraded returns true if the primary control stream for connIndex timed out too many times in a row.
+func (h *ControlPlaneHealth) IsDegraded(connIndex uint8) bool {
+	if !h.Enabled() {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.timeouts[connIndex] >= h.threshold
+}
+
+func (h *ControlPlaneHealth) recordTimeout(connIndex uint8) {
+	if !h.Enabled() {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.timeouts[connIndex]++
+}
+
+func (h *ControlPlaneHealth) recordSuccess(connIndex uint8) {
+	if !h.Enabled() {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.timeouts, connIndex)
+}
+
+// http2ControlPlane dials a dedicated HTTP/2 connection to the edge and waits for the edge to open a
+// control stream on it, the same way it does for connections using the HTTP/2 protocol.
+type http2ControlPlane struct {
+	dial      func(ctx context.Context) (net.Conn, error)
+	dataPlane http.Handler
+	log       *zerolog.Logger
+}
+
+// NewHTTP2ControlPlane returns a ControlPlane carried over a new HTTP/2 connection created by dial.
+// Registering over that connection makes the edge route traffic to it, so every request other than the control
+// stream is served by dataPlane.
+func NewHTTP2ControlPlane(dial func(ctx context.Context) (net.Conn, error), dataPlane http.Handler, log *zerolog.Logger) ControlPlane {
+	return &http2ControlPlane{
+		dial:      dial,
+		dataPlane: dataPlane,
+		log:       log,
+	}
+}
+
+func (p *http2ControlPlane) Protocol() Protocol {
+	return HTTP2
+}
+
+func (p *http2ControlPlane) OpenControlStream(ctx context.Context) (io.ReadWriteCloser, error) {
+	conn, err := p.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to dial secondary control plane: %w", err)
+	}
+
+	serveCtx, cancel := context.WithCancel(ctx)
+	handler := &http2ControlPlaneHandler{
+		streamC:   make(chan *http2ControlStream, 1),
+		dataPlane: p.dataPlane,
+		log:       p.log,
+	}
+	server := &http2.Server{
+		MaxConcurrentStreams: MaxConcurrentStreams,
+	}
+	serveDone := make(chan struct{})
+	go func() {
+		defer close(serveDone)
+		server.ServeConn(conn, &http2.ServeConnOpts{
+			Context: serveCtx,
+			Handler: handler,
+		})
+	}()
+	go func() {
+		<-serveCtx.Done()
+		_ = conn.Close()
+	}()
+
+	select {
+	case stream := <-handler.streamC:
+		stream.closeConn = cancel
+		return stream, nil
+	case <-serveDone:
+		cancel()
+		return nil, errControlPlaneClosed
+	case <-ctx.Done():
+		cancel()
+		return nil, ctx.Err()
+	}
+}
+
+// http2ControlPlaneHandler serves the control stream, and any other request with the data plane. Without a data
+// plane, other requests are rejected.
+type http2ControlPlaneHandler struct {
+	streamC   chan *http2ControlStream
+	once      sync.Once
+	dataPlane http.Handler
+	log       *zerolog.Logger
+}
+
+func (h *http2ControlPlaneHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	connType := determineHTTP2Type(r)
+	if connType != TypeControlStream && h.dataPlane != nil {
+		h.dataPlane.ServeHTTP(w, r)
+		return
+	}
+	if connType != TypeControlStream {
+		h.log.Debug().Msgf("Secondary control plane rejected request of type %s", connType)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	respWriter, err := NewHTTP2RespWriter(r, w, connType, h.log)
+	if err != nil {
+		h.log.Err(err).Msg("Secondary control plane failed to serve the control stream")
+		return
+	}
+	stream := &http2ControlStream{
+		ReadWriteCloser: respWriter,
+		done:            make(chan struct{}),
+	}
+	accepted := false
+	h.once.Do(func() {
+		h.streamC <- stream
+		accepted = true
+	})
+	if !accepted {
+		w.WriteHeader(http.StatusConflict)
+		return
+	}
+	// The control stream lives as long as this handler does not return.
+	select {
+	case <-stream.done:
+	case <-r.Context().Done():
+	}
+}
+
+// http2ControlStream is the control stream of a secondary control plane. Closing it also closes the
+// underlying HTTP/2 connection.
+type http2ControlStream struct {
+	io.ReadWriteCloser
+	done      chan struct{}
+	closeOnce sync.Once
+	closeConn context.CancelFunc
+}
+
+func (s *http2ControlStream) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		if s.closeConn != nil {
+			s.closeConn()
+		}
+	})
+	return nil
+}
@@ -0,0 +1,137 @@
+package connection
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestControlPlaneHealth(t *testing.T) {
+	disabled := NewControlPlaneHealth(0)
+	disabled.recordTimeout(0)
+	assert.False(t, disabled.Enabled())
+	assert.False(t, disabled.IsDegraded(0))
+
+	var nilHealth *ControlPlaneHealth
+	assert.False(t, nilHealth.Enabled())
+	assert.False(t, nilHealth.IsDegraded(0))
+
+	health := NewControlPlaneHealth(2)
+	health.recordTimeout(0)
+	assert.False(t, health.IsDegraded(0))
+	health.recordTimeout(0)
+	assert.True(t, health.IsDegraded(0))
+	assert.False(t, health.IsDegraded(1))
+	health.recordSuccess(0)
+	assert.False(t, health.IsDegraded(0))
+}
+
+func TestHTTP2ControlPlaneOpenControlStream(t *testing.T) {
+	edgeConn, cfdConn := net.Pipe()
+	log := zerolog.Nop()
+	controlPlane := NewHTTP2ControlPlane(func(ctx context.Context) (net.Conn, error) {
+		return cfdConn, nil
+	}, nil, &log)
+	assert.Equal(t, HTTP2, controlPlane.Protocol())
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	type openResult struct {
+		stream io.ReadWriteCloser
+		err    error
+	}
+	openC := make(chan openResult, 1)
+	go func() {
+		stream, err := controlPlane.OpenControlStream(ctx)
+		openC <- openResult{stream, err}
+	}()
+
+	edgeHTTP2Conn, err := testTransport.NewClientConn(edgeConn)
+	require.NoError(t, err)
+
+	// Any request other than the control stream is rejected
+	go func() {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost:8080/", nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		resp, err := edgeHTTP2Conn.RoundTrip(req)
+		if assert.NoError(t, err) {
+			defer resp.Body.Close()
+			assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		}
+	}()
+
+	reqBodyReader, reqBodyWriter := io.Pipe()
+	respC := make(chan *http.Response, 1)
+	go func() {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost:8080/", reqBodyReader)
+		if !assert.NoError(t, err) {
+			return
+		}
+		req.Header.Set(InternalUpgradeHeader, ControlStreamUpgrade)
+		// nolint: bodyclose
+		resp, err := edgeHTTP2Conn.RoundTrip(req)
+		if assert.NoError(t, err) {
+			respC <- resp
+		}
+	}()
+
+	result := <-openC
+	require.NoError(t, result.err)
+	stream := result.stream
+
+	go func() {
+		_, _ = reqBodyWriter.Write([]byte("register"))
+	}()
+	buf := make([]byte, len("register"))
+	_, err = io.ReadFull(stream, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "register", string(buf))
+
+	_, err = stream.Write([]byte("registered"))
+	require.NoError(t, err)
+	resp := <-respC
+	defer resp.Body.Close()
+	buf = make([]byte, len("registered"))
+	_, err = io.ReadFull(resp.Body, buf)
+	require.NoError(t, err)
+	assert.Equal(t, "registered", string(buf))
+
+	require.NoError(t, stream.Close())
+}
+
+func TestHTTP2ControlPlaneServesDataPlane(t *testing.T) {
+	edgeConn, cfdConn := net.Pipe()
+	log := zerolog.Nop()
+	dataPlane := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	})
+	controlPlane := NewHTTP2ControlPlane(func(ctx context.Context) (net.Conn, error) {
+		return cfdConn, nil
+	}, dataPlane, &log)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	go func() {
+		_, _ = controlPlane.OpenControlStream(ctx)
+	}()
+
+	edgeHTTP2Conn, err := testTransport.NewClientConn(edgeConn)
+	require.NoError(t, err)
+
+	// Once registered over the secondary control plane, the edge routes traffic to it
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost:8080/", nil)
+	require.NoError(t, err)
+	resp, err := edgeHTTP2Conn.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusTeapot, resp.StatusCode)
+}
@@ -1,6 +1,10 @@
 package connection
 
 import (
+	"regexp"
+	"strings"
+	"time"
+
 	tunnelpogs "github.com/cloudflare/cloudflared/tunnelrpc/pogs"
 )
 
@@ -33,6 +37,10 @@ func (e *EdgeQuicDialError) Unwrap() error {
 type ServerRegisterTunnelError struct {
 	Cause     error
 	Permanent bool
+	// RateLimited is true if the edge refused the registration because of rate limiting or too many connections
+	RateLimited bool
+	// RetryAfter is how long the edge asked to wait before registering again, 0 if it didn't say
+	RetryAfter time.Duration
 }
 
 func (e ServerRegisterTunnelError) Error() string {
@@ -40,16 +48,53 @@ func (e ServerRegisterTunnelError) Error() string {
 }
 
 func serverRegistrationErrorFromRPC(err error) ServerRegisterTunnelError {
+	regErr := ServerRegisterTunnelError{
+		Cause:     err,
+		Permanent: true,
+	}
 	if retryable, ok := err.(*tunnelpogs.RetryableError); ok {
-		return ServerRegisterTunnelError{
-			Cause:     retryable.Unwrap(),
-			Permanent: false,
+		regErr.Cause = retryable.Unwrap()
+		regErr.Permanent = false
+		regErr.RetryAfter = retryable.Delay
+	}
+	if isRateLimited(regErr.Cause) {
+		// Rate limiting is transient, even if the edge didn't say the registration can be retried
+		regErr.RateLimited = true
+		regErr.Permanent = false
+		if regErr.RetryAfter == 0 {
+			regErr.RetryAfter = retryAfterHint(regErr.Cause.Error())
 		}
 	}
-	return ServerRegisterTunnelError{
-		Cause:     err,
-		Permanent: true,
+	return regErr
+}
+
+var retryAfterPattern = regexp.MustCompile(`(?i)retry[-_ ]?after\D{0,3}(\d+)\s*(ms|s|m|h)?\b`)
+
+// isRateLimited returns true if the registration error says the edge is rate limiting registrations.
+func isRateLimited(cause error) bool {
+	msg := strings.ToLower(cause.Error())
+	return strings.Contains(msg, "rate limit") ||
+		strings.Contains(msg, "ratelimit") ||
+		strings.Contains(msg, "rate-limit") ||
+		strings.Contains(msg, "too many")
+}
+
+// retryAfterHint parses a hint like "retry after 30s" or "Retry-After: 30" from the registration error message.
+// A number without a unit is in seconds, like the HTTP Retry-After header. Returns 0 if there is no hint.
+func retryAfterHint(msg string) time.Duration {
+	match := retryAfterPattern.FindStringSubmatch(msg)
+	if match == nil {
+		return 0
+	}
+	unit := match[2]
+	if unit == "" {
+		unit = "s"
+	}
+	d, err := time.ParseDuration(match[1] + unit)
+	if err != nil {
+		return 0
 	}
+	return d
 }
 
 type ControlStreamError struct{}
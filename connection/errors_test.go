@@ -0,0 +1,85 @@
+package connection
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	tunnelpogs "github.com/cloudflare/cloudflared/tunnelrpc/pogs"
+)
+
+func TestServerRegistrationErrorFromRPC(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected ServerRegisterTunnelError
+	}{
+		{
+			name:     "permanent",
+			err:      errors.New("Unauthorized: Failed to get tunnel"),
+			expected: ServerRegisterTunnelError{Cause: errors.New("Unauthorized: Failed to get tunnel"), Permanent: true},
+		},
+		{
+			name:     "retryable",
+			err:      tunnelpogs.RetryErrorAfter(errors.New("internal error"), 5*time.Second),
+			expected: ServerRegisterTunnelError{Cause: errors.New("internal error"), RetryAfter: 5 * time.Second},
+		},
+		{
+			name: "rate limited with delay",
+			err:  tunnelpogs.RetryErrorAfter(errors.New("rate limited, retry after 30s"), 10*time.Second),
+			expected: ServerRegisterTunnelError{
+				Cause:       errors.New("rate limited, retry after 30s"),
+				RateLimited: true,
+				RetryAfter:  10 * time.Second,
+			},
+		},
+		{
+			name: "rate limited with hint",
+			err:  tunnelpogs.RetryErrorAfter(errors.New("rate limited, retry after 30s"), 0),
+			expected: ServerRegisterTunnelError{
+				Cause:       errors.New("rate limited, retry after 30s"),
+				RateLimited: true,
+				RetryAfter:  30 * time.Second,
+			},
+		},
+		{
+			name: "too many connections",
+			err:  errors.New("too many connections for this tunnel; Retry-After: 120"),
+			expected: ServerRegisterTunnelError{
+				Cause:       errors.New("too many connections for this tunnel; Retry-After: 120"),
+				RateLimited: true,
+				RetryAfter:  2 * time.Minute,
+			},
+		},
+		{
+			name: "rate limited without hint",
+			err:  errors.New("registration rate limit exceeded"),
+			expected: ServerRegisterTunnelError{
+				Cause:       errors.New("registration rate limit exceeded"),
+				RateLimited: true,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, serverRegistrationErrorFromRPC(test.err))
+		})
+	}
+}
+
+func TestRetryAfterHint(t *testing.T) {
+	tests := map[string]time.Duration{
+		"retry after 30s":                 30 * time.Second,
+		"Retry-After: 15":                 15 * time.Second,
+		"please retry_after=500ms":        500 * time.Millisecond,
+		"retry after 2m":                  2 * time.Minute,
+		"retry after 45 seconds":          45 * time.Second,
+		"too many connections":            0,
+		"retry later, after 30s of quiet": 0,
+	}
+	for msg, expected := range tests {
+		assert.Equal(t, expected, retryAfterHint(msg), msg)
+	}
+}
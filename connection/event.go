@@ -1,6 +1,10 @@
 package connection
 
-import "net"
+import (
+	"net"
+
+	"github.com/cloudflare/cloudflared/client"
+)
 
 // Event is something that happened to a connection, e.g. disconnection or registration.
 type Event struct {
@@ -10,6 +14,8 @@ type Event struct {
 	Protocol    Protocol
 	URL         string
 	EdgeAddress net.IP
+	// ConnectionOptions are the options the connection registered with, set on Connected
+	ConnectionOptions *client.ConnectionOptionsSnapshot
 }
 
 // Status is the status of a connection.
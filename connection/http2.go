@@ -40,8 +40,11 @@ type HTTP2Connection struct {
 	server       *http2.Server
 	orchestrator Orchestrator
 	connOptions  *client.ConnectionOptionsSnapshot
+	compression  HTTP2Compression
 	observer     *Observer
 	connIndex    uint8
+	traffic      *ConnTraffic
+	resources    *ConnResources
 
 	log                  *zerolog.Logger
 	activeRequestsWG     sync.WaitGroup
@@ -55,9 +58,11 @@ func NewHTTP2Connection(
 	conn net.Conn,
 	orchestrator Orchestrator,
 	connOptions *client.ConnectionOptionsSnapshot,
+	compression HTTP2Compression,
 	observer *Observer,
 	connIndex uint8,
 	controlStreamHandler ControlStreamHandler,
+	resources *ConnResources,
 	log *zerolog.Logger,
 ) *HTTP2Connection {
 	return &HTTP2Connection{
@@ -67,19 +72,41 @@ func NewHTTP2Connection(
 		},
 		orchestrator:         orchestrator,
 		connOptions:          connOptions,
+		compression:          compression,
 		observer:             observer,
 		connIndex:            connIndex,
+		traffic:              observer.ConnTraffic(connIndex),
+		resources:            resources,
 		controlStreamHandler: controlStreamHandler,
 		log:                  log,
 	}
 }
 
+// NewHTTP2DataPlane returns a handler for the requests the edge proxies over an HTTP/2 connection that doesn't
+// carry the control stream, e.g. the secondary control plane of a QUIC connection.
+func NewHTTP2DataPlane(
+	orchestrator Orchestrator,
+	observer *Observer,
+	connIndex uint8,
+	resources *ConnResources,
+	log *zerolog.Logger,
+) http.Handler {
+	return &HTTP2Connection{
+		orchestrator: orchestrator,
+		observer:     observer,
+		connIndex:    connIndex,
+		traffic:      observer.ConnTraffic(connIndex),
+		resources:    resources,
+		log:          log,
+	}
+}
+
 // Serve serves an HTTP2 server that the edge can talk to.
 func (c *HTTP2Connection) Serve(ctx context.Context) error {
-	go func() {
+	c.resources.Go(func() {
 		<-ctx.Done()
 		c.close()
-	}()
+	})
 	c.server.ServeConn(c.conn, &http2.ServeConnOpts{
 		Context: ctx,
 		Handler: c,
@@ -99,6 +126,7 @@ func (c *HTTP2Connection) Serve(ctx context.Context) error {
 func (c *HTTP2Connection) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	c.activeRequestsWG.Add(1)
 	defer c.activeRequestsWG.Done()
+	defer c.resources.StreamStarted()()
 
 	connType := determineHTTP2Type(r)
 	handleMissingRequestParts(connType, r)
@@ -108,6 +136,21 @@ func (c *HTTP2Connection) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		c.observer.log.Error().Msg(err.Error())
 		return
 	}
+	if c.compression.Enabled() && r.Header.Get(HTTP2CompressionHeader) == string(c.compression.Algorithm) {
+		r.Header.Del(HTTP2CompressionHeader)
+		r.Body = newDecompressedBody(r.Body, c.compression.Algorithm)
+		respWriter.r = r.Body
+		respWriter.compression = c.compression
+		defer respWriter.closeCompressor()
+	}
+	if class, ok := connType.trafficClass(); ok {
+		c.traffic.streamStarted(class)
+		r.Body = c.traffic.body(class, r.Body)
+		respWriter.r = r.Body
+		respWriter.traffic = c.traffic
+		respWriter.trafficClass = class
+		respWriter.trafficCtx = r.Context()
+	}
 
 	originProxy, err := c.orchestrator.GetOriginProxy()
 	if err != nil {
@@ -118,10 +161,14 @@ func (c *HTTP2Connection) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	var requestErr error
 	switch connType {
 	case TypeControlStream:
-		requestErr = c.controlStreamHandler.ServeControlStream(r.Context(), respWriter, c.connOptions.ConnectionOptions(), c.orchestrator)
+		requestErr = c.controlStreamHandler.ServeControlStream(r.Context(), respWriter, c.connOptions, c.orchestrator)
 		if requestErr != nil {
 			c.controlStreamErr = requestErr
 		}
+		if errors.Is(requestErr, errControlStreamHeartbeat) {
+			// The edge stopped responding, tear down the connection instead of waiting for the transport to notice.
+			_ = c.conn.Close()
+		}
 
 	case TypeConfiguration:
 		requestErr = c.handleConfigurationUpdate(respWriter, r)
@@ -201,6 +248,17 @@ type http2RespWriter struct {
 	hijackedMutex sync.Mutex
 	hijackedv     bool
 	log           *zerolog.Logger
+
+	// compression is set when the edge asked for the stream to be compressed, the compressor is then created with
+	// the response headers.
+	compression HTTP2Compression
+	compressor  *compressedWriter
+
+	// traffic counts and shapes the bytes written to the edge as trafficClass until trafficCtx is done, nil if
+	// they aren't counted
+	traffic      *ConnTraffic
+	trafficClass TrafficClass
+	trafficCtx   context.Context
 }
 
 func NewHTTP2RespWriter(r *http.Request, w http.ResponseWriter, connType Type, log *zerolog.Logger) (*http2RespWriter, error) {
@@ -246,9 +304,10 @@ func (rp *http2RespWriter) WriteRespHeaders(status int, header http.Header) erro
 		// lowercase headers for simplicity check
 		h2name := strings.ToLower(name)
 
-		if h2name == "content-length" {
+		if h2name == "content-length" && !rp.compression.Enabled() {
 			// This header has meaning in HTTP/2 and will be used by the edge,
 			// so it should be sent *also* as an HTTP/2 response header.
+			// It doesn't hold for a compressed body.
 			dest[name] = values
 		}
 
@@ -269,6 +328,14 @@ func (rp *http2RespWriter) WriteRespHeaders(status int, header http.Header) erro
 	dest.Set(CanonicalResponseUserHeaders, SerializeHeaders(userHeaders))
 
 	rp.setResponseMetaHeader(responseMetaHeaderOrigin)
+	if rp.compression.Enabled() {
+		compressor, err := newCompressedWriter(rp.w, rp.compression)
+		if err != nil {
+			return err
+		}
+		rp.compressor = compressor
+		dest.Set(HTTP2CompressionHeader, string(rp.compression.Algorithm))
+	}
 	// HTTP2 removes support for 101 Switching Protocols https://tools.ietf.org/html/rfc7540#section-8.1.1
 	if status == http.StatusSwitchingProtocols {
 		status = http.StatusOK
@@ -290,6 +357,7 @@ func (rp *http2RespWriter) Header() http.Header {
 }
 
 func (rp *http2RespWriter) Flush() {
+	rp.flushCompressor()
 	rp.flusher.Flush()
 }
 
@@ -313,7 +381,7 @@ func (rp *http2RespWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
 	}
 	// Make sure to flush anything left in the buffer before hijacking
 	if rp.shouldFlush {
-		rp.flusher.Flush()
+		rp.Flush()
 	}
 	rp.hijackedMutex.Lock()
 	defer rp.hijackedMutex.Unlock()
@@ -363,13 +431,45 @@ func (rp *http2RespWriter) Write(p []byte) (n int, err error) {
 			rp.log.Debug().Msgf("Recover from http2 response writer panic, error %s", debug.Stack())
 		}
 	}()
-	n, err = rp.w.Write(p)
+	if err := rp.traffic.shape(rp.trafficCtx, rp.trafficClass, len(p)); err != nil {
+		return 0, err
+	}
+	if rp.compressor != nil {
+		n, err = rp.compressor.Write(p)
+	} else {
+		n, err = rp.w.Write(p)
+	}
+	rp.traffic.addBytes(rp.trafficClass, trafficEgress, n)
 	if err == nil && rp.shouldFlush {
-		rp.flusher.Flush()
+		rp.Flush()
 	}
 	return n, err
 }
 
+func (rp *http2RespWriter) flushCompressor() {
+	if rp.compressor == nil {
+		return
+	}
+	if err := rp.compressor.Flush(); err != nil {
+		rp.log.Debug().Err(err).Msg("Failed to flush compressed response")
+	}
+}
+
+// closeCompressor writes out the end of the compressed response, it must be called before the handler returns.
+func (rp *http2RespWriter) closeCompressor() {
+	defer func() {
+		if r := recover(); r != nil {
+			rp.log.Debug().Msgf("Recover from http2 response writer panic, error %s", debug.Stack())
+		}
+	}()
+	if rp.compressor == nil {
+		return
+	}
+	if err := rp.compressor.Close(); err != nil {
+		rp.log.Debug().Err(err).Msg("Failed to close compressed response")
+	}
+}
+
 func (rp *http2RespWriter) Close() error {
 	return nil
 }
@@ -0,0 +1,285 @@
+package connection
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// HTTP2CompressionHeader is set by the edge on streams whose frames are compressed, with the algorithm as value.
+// cloudflared only honours it for the algorithm it advertised in the connection options, and then decompresses
+// the request body and compresses everything it writes back on the stream.
+const HTTP2CompressionHeader = "Cf-Cloudflared-Compression"
+
+// CompressionAlgorithm is the algorithm streams of an HTTP2 connection are compressed with.
+type CompressionAlgorithm string
+
+const (
+	CompressionGzip CompressionAlgorithm = "gzip"
+	CompressionZstd CompressionAlgorithm = "zstd"
+)
+
+// ParseCompressionAlgorithm returns the CompressionAlgorithm named s.
+func ParseCompressionAlgorithm(s string) (CompressionAlgorithm, error) {
+	switch algorithm := CompressionAlgorithm(s); algorithm {
+	case CompressionGzip, CompressionZstd:
+		return algorithm, nil
+	default:
+		return "", fmt.Errorf("unknown compression algorithm %q, expected %s or %s", s, CompressionGzip, CompressionZstd)
+	}
+}
+
+// Feature is the client feature the algorithm is advertised to the edge with.
+func (a CompressionAlgorithm) Feature() string {
+	return "http2_compression_" + string(a)
+}
+
+// HTTP2Compression configures the compression of HTTP2 connection streams. The Quality follows the
+// compression-quality flag: 0 is off, 1 low, 2 medium and 3 or above high. The zero value disables compression.
+type HTTP2Compression struct {
+	Algorithm CompressionAlgorithm
+	Quality   uint8
+}
+
+func (c HTTP2Compression) Enabled() bool {
+	return c.Quality > 0 && c.Algorithm != ""
+}
+
+var (
+	compressionUncompressedBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: TunnelSubsystem,
+			Name:      "http2_compression_uncompressed_bytes",
+			Help:      "Bytes of HTTP2 stream data before compression, or after decompression",
+		},
+		[]string{"algorithm", "direction"},
+	)
+	compressionCompressedBytes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: TunnelSubsystem,
+			Name:      "http2_compression_compressed_bytes",
+			Help:      "Bytes of HTTP2 stream data sent or received compressed",
+		},
+		[]string{"algorithm", "direction"},
+	)
+	compressionSeconds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: TunnelSubsystem,
+			Name:      "http2_compression_seconds",
+			Help:      "Time spent compressing and decompressing HTTP2 stream data",
+		},
+		[]string{"algorithm", "direction"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(compressionUncompressedBytes, compressionCompressedBytes, compressionSeconds)
+}
+
+const (
+	compressionDirectionEgress  = "egress"
+	compressionDirectionIngress = "ingress"
+)
+
+// compressionWriter is implemented by both gzip.Writer and zstd.Encoder.
+type compressionWriter interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressedWriter compresses what is written to it into w. Flush pushes what has been written so far to w, so that
+// streaming responses aren't held back by the compressor.
+type compressedWriter struct {
+	algorithm  CompressionAlgorithm
+	compressor compressionWriter
+	counter    *countingWriter
+}
+
+func newCompressedWriter(w io.Writer, compression HTTP2Compression) (*compressedWriter, error) {
+	counter := &countingWriter{w: w}
+	var (
+		compressor compressionWriter
+		err        error
+	)
+	switch compression.Algorithm {
+	case CompressionGzip:
+		compressor, err = gzip.NewWriterLevel(counter, gzipLevel(compression.Quality))
+	case CompressionZstd:
+		compressor, err = zstd.NewWriter(counter, zstd.WithEncoderLevel(zstdLevel(compression.Quality)), zstd.WithEncoderConcurrency(1))
+	default:
+		err = fmt.Errorf("unknown compression algorithm %q", compression.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &compressedWriter{
+		algorithm:  compression.Algorithm,
+		compressor: compressor,
+		counter:    counter,
+	}, nil
+}
+
+func (c *compressedWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := c.compressor.Write(p)
+	c.observe(start, n)
+	return n, err
+}
+
+func (c *compressedWriter) Flush() error {
+	start := time.Now()
+	err := c.compressor.Flush()
+	c.observe(start, 0)
+	return err
+}
+
+func (c *compressedWriter) Close() error {
+	start := time.Now()
+	err := c.compressor.Close()
+	c.observe(start, 0)
+	return err
+}
+
+// observe records the time spent since start and the bytes written in and out of the compressor. The time includes
+// writing compressed data to the underlying writer, which is small next to the compression itself.
+func (c *compressedWriter) observe(start time.Time, uncompressed int) {
+	algorithm := string(c.algorithm)
+	compressionSeconds.WithLabelValues(algorithm, compressionDirectionEgress).Add(time.Since(start).Seconds())
+	compressionUncompressedBytes.WithLabelValues(algorithm, compressionDirectionEgress).Add(float64(uncompressed))
+	compressionCompressedBytes.WithLabelValues(algorithm, compressionDirectionEgress).Add(float64(c.counter.take()))
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += n
+	return n, err
+}
+
+func (c *countingWriter) take() int {
+	n := c.n
+	c.n = 0
+	return n
+}
+
+// decompressedBody decompresses a request body. The body is only read from once the first Read is called, so that
+// a stream the edge hasn't written to yet doesn't block on the compression header.
+type decompressedBody struct {
+	algorithm    CompressionAlgorithm
+	body         io.ReadCloser
+	compressed   *countingReader
+	decompressor io.Reader
+	closer       func()
+	err          error
+}
+
+func newDecompressedBody(body io.ReadCloser, algorithm CompressionAlgorithm) *decompressedBody {
+	return &decompressedBody{
+		algorithm:  algorithm,
+		body:       body,
+		compressed: &countingReader{r: body},
+	}
+}
+
+func (d *decompressedBody) Read(p []byte) (int, error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	start := time.Now()
+	if d.decompressor == nil {
+		if err := d.init(); err != nil {
+			d.err = err
+			return 0, err
+		}
+	}
+	n, err := d.decompressor.Read(p)
+	algorithm := string(d.algorithm)
+	compressionSeconds.WithLabelValues(algorithm, compressionDirectionIngress).Add(time.Since(start).Seconds())
+	compressionUncompressedBytes.WithLabelValues(algorithm, compressionDirectionIngress).Add(float64(n))
+	compressionCompressedBytes.WithLabelValues(algorithm, compressionDirectionIngress).Add(float64(d.compressed.take()))
+	if err != nil {
+		d.err = err
+		if d.closer != nil {
+			d.closer()
+		}
+	}
+	return n, err
+}
+
+func (d *decompressedBody) init() error {
+	switch d.algorithm {
+	case CompressionGzip:
+		gzipReader, err := gzip.NewReader(d.compressed)
+		if err != nil {
+			return err
+		}
+		d.decompressor = gzipReader
+		d.closer = func() { _ = gzipReader.Close() }
+	case CompressionZstd:
+		zstdReader, err := zstd.NewReader(d.compressed, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return err
+		}
+		d.decompressor = zstdReader
+		d.closer = zstdReader.Close
+	default:
+		return fmt.Errorf("unknown compression algorithm %q", d.algorithm)
+	}
+	return nil
+}
+
+// Close closes the request body. The decompressor is released once reading the body ends, as Close may be called
+// while another goroutine reads.
+func (d *decompressedBody) Close() error {
+	return d.body.Close()
+}
+
+type countingReader struct {
+	r io.Reader
+	n int
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += n
+	return n, err
+}
+
+func (c *countingReader) take() int {
+	n := c.n
+	c.n = 0
+	return n
+}
+
+func gzipLevel(quality uint8) int {
+	switch quality {
+	case 1:
+		return gzip.BestSpeed
+	case 2:
+		return gzip.DefaultCompression
+	default:
+		return gzip.BestCompression
+	}
+}
+
+func zstdLevel(quality uint8) zstd.EncoderLevel {
+	switch quality {
+	case 1:
+		return zstd.SpeedFastest
+	case 2:
+		return zstd.SpeedDefault
+	default:
+		return zstd.SpeedBetterCompression
+	}
+}
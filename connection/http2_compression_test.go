@@ -0,0 +1,82 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCompressionAlgorithm(t *testing.T) {
+	algorithm, err := ParseCompressionAlgorithm("gzip")
+	require.NoError(t, err)
+	require.Equal(t, CompressionGzip, algorithm)
+
+	algorithm, err = ParseCompressionAlgorithm("zstd")
+	require.NoError(t, err)
+	require.Equal(t, CompressionZstd, algorithm)
+
+	_, err = ParseCompressionAlgorithm("brotli")
+	require.Error(t, err)
+}
+
+func TestServeHTTPCompressed(t *testing.T) {
+	for _, algorithm := range []CompressionAlgorithm{CompressionGzip, CompressionZstd} {
+		t.Run(string(algorithm), func(t *testing.T) {
+			compression := HTTP2Compression{Algorithm: algorithm, Quality: 2}
+			http2Conn, edgeConn := newTestHTTP2Connection()
+			http2Conn.compression = compression
+
+			ctx, cancel := context.WithCancel(t.Context())
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_ = http2Conn.Serve(ctx)
+			}()
+
+			edgeHTTP2Conn, err := testTransport.NewClientConn(edgeConn)
+			require.NoError(t, err)
+
+			// A stream the edge asks to compress has both its request and response bodies compressed
+			var reqBody bytes.Buffer
+			compressor, err := newCompressedWriter(&reqBody, compression)
+			require.NoError(t, err)
+			_, err = compressor.Write(testLargeResp)
+			require.NoError(t, err)
+			require.NoError(t, compressor.Close())
+			require.Less(t, reqBody.Len(), len(testLargeResp))
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://localhost:8080/echo", &reqBody)
+			require.NoError(t, err)
+			req.Header.Set(HTTP2CompressionHeader, string(algorithm))
+			resp, err := edgeHTTP2Conn.RoundTrip(req)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Equal(t, string(algorithm), resp.Header.Get(HTTP2CompressionHeader))
+			respBody, err := io.ReadAll(newDecompressedBody(resp.Body, algorithm))
+			require.NoError(t, err)
+			require.Equal(t, testLargeResp, respBody)
+			_ = resp.Body.Close()
+
+			// Other streams are left as they are
+			req, err = http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost:8080/large_file", nil)
+			require.NoError(t, err)
+			resp, err = edgeHTTP2Conn.RoundTrip(req)
+			require.NoError(t, err)
+			require.Equal(t, http.StatusOK, resp.StatusCode)
+			require.Empty(t, resp.Header.Get(HTTP2CompressionHeader))
+			respBody, err = io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.Equal(t, testLargeResp, respBody)
+			_ = resp.Body.Close()
+
+			cancel()
+			wg.Wait()
+		})
+	}
+}
@@ -41,19 +41,24 @@ func newTestHTTP2Connection() (*HTTP2Connection, net.Conn) {
 		connIndex,
 		nil,
 		nil,
-		1*time.Second,
+		tunnelrpc.NewRegistrationTimeouts(1*time.Second),
 		nil,
 		1*time.Second,
 		HTTP2,
+		nil,
+		nil,
+		ControlStreamHeartbeat{},
 	)
 	return NewHTTP2Connection(
 		cfdConn,
 		// OriginProxy is set in testConfigManager
 		testOrchestrator,
 		&client.ConnectionOptionsSnapshot{},
+		HTTP2Compression{},
 		obs,
 		connIndex,
 		controlStream,
+		NewConnResources(connIndex),
 		&log,
 	), edgeConn
 }
@@ -162,10 +167,12 @@ func TestServeHTTP(t *testing.T) {
 	}
 	cancel()
 	wg.Wait()
+	assertConnResourcesReleased(t, http2Conn.resources)
 }
 
 type mockNamedTunnelRPCClient struct {
 	shouldFail   error
+	heartbeatErr error
 	registered   chan struct{}
 	unregistered chan struct{}
 }
@@ -198,17 +205,23 @@ func (mc mockNamedTunnelRPCClient) GracefulShutdown(ctx context.Context, gracePe
 	return nil
 }
 
+func (mc mockNamedTunnelRPCClient) Heartbeat(ctx context.Context) (time.Duration, error) {
+	return time.Millisecond, mc.heartbeatErr
+}
+
 func (mockNamedTunnelRPCClient) Close() {}
 
 type mockRPCClientFactory struct {
 	shouldFail   error
+	heartbeatErr error
 	registered   chan struct{}
 	unregistered chan struct{}
 }
 
-func (mf *mockRPCClientFactory) newMockRPCClient(context.Context, io.ReadWriteCloser, time.Duration) tunnelrpc.RegistrationClient {
+func (mf *mockRPCClientFactory) newMockRPCClient(context.Context, io.ReadWriteCloser, tunnelrpc.RegistrationTimeouts) tunnelrpc.RegistrationClient {
 	return &mockNamedTunnelRPCClient{
 		shouldFail:   mf.shouldFail,
+		heartbeatErr: mf.heartbeatErr,
 		registered:   mf.registered,
 		unregistered: mf.unregistered,
 	}
@@ -371,10 +384,13 @@ func TestServeControlStream(t *testing.T) {
 		1,
 		nil,
 		rpcClientFactory.newMockRPCClient,
-		1*time.Second,
+		tunnelrpc.NewRegistrationTimeouts(1*time.Second),
 		nil,
 		1*time.Second,
 		HTTP2,
+		nil,
+		nil,
+		ControlStreamHeartbeat{},
 	)
 	http2Conn.controlStreamHandler = controlStream
 
@@ -408,6 +424,69 @@ func TestServeControlStream(t *testing.T) {
 	wg.Wait()
 }
 
+func TestControlStreamHeartbeatDeadPeer(t *testing.T) {
+	http2Conn, edgeConn := newTestHTTP2Connection()
+
+	rpcClientFactory := mockRPCClientFactory{
+		heartbeatErr: context.DeadlineExceeded,
+		registered:   make(chan struct{}),
+		unregistered: make(chan struct{}),
+	}
+
+	obs := NewObserver(&log, &log)
+	controlStream := NewControlStream(
+		obs,
+		mockConnectedFuse{},
+		&TunnelProperties{},
+		1,
+		nil,
+		rpcClientFactory.newMockRPCClient,
+		tunnelrpc.NewRegistrationTimeouts(1*time.Second),
+		nil,
+		1*time.Second,
+		HTTP2,
+		nil,
+		nil,
+		ControlStreamHeartbeat{
+			Interval:  10 * time.Millisecond,
+			MaxMisses: 2,
+		},
+	)
+	http2Conn.controlStreamHandler = controlStream
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	serveErrC := make(chan error, 1)
+	go func() {
+		serveErrC <- http2Conn.Serve(ctx)
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost:8080/", nil)
+	require.NoError(t, err)
+	req.Header.Set(InternalUpgradeHeader, ControlStreamUpgrade)
+
+	edgeHTTP2Conn, err := testTransport.NewClientConn(edgeConn)
+	require.NoError(t, err)
+	go func() {
+		// nolint: bodyclose
+		_, _ = edgeHTTP2Conn.RoundTrip(req)
+	}()
+
+	<-rpcClientFactory.registered
+	select {
+	case err := <-serveErrC:
+		require.ErrorIs(t, err, errControlStreamHeartbeat)
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was not closed after missing heartbeats")
+	}
+	// The edge is unresponsive, so no unregistration is attempted
+	select {
+	case <-rpcClientFactory.unregistered:
+		t.Fatal("unexpected unregistration")
+	default:
+	}
+}
+
 func TestFailRegistration(t *testing.T) {
 	http2Conn, edgeConn := newTestHTTP2Connection()
 
@@ -425,10 +504,13 @@ func TestFailRegistration(t *testing.T) {
 		http2Conn.connIndex,
 		nil,
 		rpcClientFactory.newMockRPCClient,
-		1*time.Second,
+		tunnelrpc.NewRegistrationTimeouts(1*time.Second),
 		nil,
 		1*time.Second,
 		HTTP2,
+		nil,
+		nil,
+		ControlStreamHeartbeat{},
 	)
 	http2Conn.controlStreamHandler = controlStream
 
@@ -475,10 +557,13 @@ func TestGracefulShutdownHTTP2(t *testing.T) {
 		http2Conn.connIndex,
 		nil,
 		rpcClientFactory.newMockRPCClient,
-		1*time.Second,
+		tunnelrpc.NewRegistrationTimeouts(1*time.Second),
 		shutdownC,
 		1*time.Second,
 		HTTP2,
+		nil,
+		nil,
+		ControlStreamHeartbeat{},
 	)
 
 	http2Conn.controlStreamHandler = controlStream
@@ -525,6 +610,7 @@ func TestGracefulShutdownHTTP2(t *testing.T) {
 
 	cancel()
 	wg.Wait()
+	assertConnResourcesReleased(t, http2Conn.resources)
 
 	events.assertSawEvent(t, Event{
 		Index:     http2Conn.connIndex,
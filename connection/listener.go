@@ -0,0 +1,42 @@
+package connection
+
+import (
+	"net"
+
+	"github.com/google/uuid"
+)
+
+// Listener is notified about the state of the tunnel, so that embedders and extensions can export it without
+// depending on the metrics code. Listeners are registered with Observer.RegisterListener, and are called one
+// at a time from a single goroutine, in the order things happened. Methods must not block. Embed NopListener
+// to only implement some of them.
+type Listener interface {
+	// OnConnected is called when a connection to the edge is established, before it registers the tunnel.
+	OnConnected(connIndex uint8, protocol Protocol, edgeAddress net.IP)
+	// OnRegistered is called when a connection registered the tunnel with the edge.
+	OnRegistered(conn RegisteredConnection)
+	// OnDisconnected is called when a connection to the edge is closed.
+	OnDisconnected(connIndex uint8)
+	// OnConfigApplied is called when a new remote configuration version is applied.
+	OnConfigApplied(version int32)
+	// OnProtocolChange is called when a connection changes the protocol it connects to the edge with.
+	OnProtocolChange(connIndex uint8, from, to Protocol)
+}
+
+// RegisteredConnection describes a connection that registered the tunnel with the edge.
+type RegisteredConnection struct {
+	Index        uint8
+	ConnectionID uuid.UUID
+	Location     string
+	Protocol     Protocol
+	EdgeAddress  net.IP
+}
+
+// NopListener implements Listener by ignoring everything.
+type NopListener struct{}
+
+func (NopListener) OnConnected(uint8, Protocol, net.IP)        {}
+func (NopListener) OnRegistered(RegisteredConnection)          {}
+func (NopListener) OnDisconnected(uint8)                       {}
+func (NopListener) OnConfigApplied(int32)                      {}
+func (NopListener) OnProtocolChange(uint8, Protocol, Protocol) {}
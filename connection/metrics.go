@@ -24,10 +24,24 @@ type tunnelMetrics struct {
 	locationLock sync.Mutex
 	// oldServerLocations stores the last server the tunnel was connected to
 	oldServerLocations map[string]string
+	// connectedLocations stores the location of the connections currently registered
+	connectedLocations  map[string]string
+	locationConnections *prometheus.GaugeVec
 
-	regSuccess *prometheus.CounterVec
-	regFail    *prometheus.CounterVec
-	rpcFail    *prometheus.CounterVec
+	regSuccess     *prometheus.CounterVec
+	regFail        *prometheus.CounterVec
+	regRateLimited prometheus.Counter
+	rpcFail        *prometheus.CounterVec
+
+	secondaryControlPlaneRegistrations   prometheus.Counter
+	secondaryControlPlaneUnregistrations prometheus.Counter
+
+	heartbeatRTT    *prometheus.GaugeVec
+	heartbeatMisses prometheus.Counter
+
+	trafficBytes     *prometheus.CounterVec
+	trafficStreams   *prometheus.CounterVec
+	trafficDatagrams *prometheus.CounterVec
 
 	tunnelsHA           tunnelsForHA
 	userHostnamesCounts *prometheus.CounterVec
@@ -90,6 +104,17 @@ func initTunnelMetrics() *tunnelMetrics {
 	)
 	prometheus.MustRegister(serverLocations)
 
+	locationConnections := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: TunnelSubsystem,
+			Name:      "location_connections",
+			Help:      "Number of connections currently registered with each Cloudflare data center",
+		},
+		[]string{"edge_location"},
+	)
+	prometheus.MustRegister(locationConnections)
+
 	rpcFail := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: MetricsNamespace,
@@ -112,6 +137,16 @@ func initTunnelMetrics() *tunnelMetrics {
 	)
 	prometheus.MustRegister(registerFail)
 
+	registerRateLimited := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: TunnelSubsystem,
+			Name:      "tunnel_register_rate_limited",
+			Help:      "Count of tunnel registrations the edge refused because of rate limiting or too many connections",
+		},
+	)
+	prometheus.MustRegister(registerRateLimited)
+
 	userHostnamesCounts := prometheus.NewCounterVec(
 		prometheus.CounterOpts{
 			Namespace: MetricsNamespace,
@@ -134,21 +169,112 @@ func initTunnelMetrics() *tunnelMetrics {
 	)
 	prometheus.MustRegister(registerSuccess)
 
+	secondaryControlPlaneRegistrations := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: TunnelSubsystem,
+			Name:      "secondary_control_plane_registrations",
+			Help:      "Count of registrations attempted over the secondary control plane because the primary control stream was degraded",
+		},
+	)
+	prometheus.MustRegister(secondaryControlPlaneRegistrations)
+
+	secondaryControlPlaneUnregistrations := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: TunnelSubsystem,
+			Name:      "secondary_control_plane_unregistrations",
+			Help:      "Count of unregistrations retried over the secondary control plane because the primary control stream timed out",
+		},
+	)
+	prometheus.MustRegister(secondaryControlPlaneUnregistrations)
+
+	heartbeatRTT := prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: TunnelSubsystem,
+			Name:      "control_stream_heartbeat_rtt_seconds",
+			Help:      "Round trip time of the last successful control stream heartbeat of each connection",
+		},
+		[]string{"conn_index"},
+	)
+	prometheus.MustRegister(heartbeatRTT)
+
+	heartbeatMisses := prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: TunnelSubsystem,
+			Name:      "control_stream_heartbeat_misses",
+			Help:      "Count of control stream heartbeats that failed or timed out",
+		},
+	)
+	prometheus.MustRegister(heartbeatMisses)
+
+	trafficBytes := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: TunnelSubsystem,
+			Name:      "traffic_bytes_total",
+			Help:      "Payload bytes proxied by each connection, by traffic class (control, http, tcp, udp, icmp) and direction (ingress from the edge, egress to the edge)",
+		},
+		[]string{"conn_index", "class", "direction"},
+	)
+	prometheus.MustRegister(trafficBytes)
+
+	trafficStreams := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: TunnelSubsystem,
+			Name:      "traffic_streams_total",
+			Help:      "Streams proxied by each connection, by traffic class (control, http, tcp)",
+		},
+		[]string{"conn_index", "class"},
+	)
+	prometheus.MustRegister(trafficStreams)
+
+	trafficDatagrams := prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: TunnelSubsystem,
+			Name:      "traffic_datagrams_total",
+			Help:      "Datagrams proxied by each connection, by traffic class (udp, icmp) and direction (ingress from the edge, egress to the edge)",
+		},
+		[]string{"conn_index", "class", "direction"},
+	)
+	prometheus.MustRegister(trafficDatagrams)
+
 	return &tunnelMetrics{
 		serverLocations:     serverLocations,
 		oldServerLocations:  make(map[string]string),
+		connectedLocations:  make(map[string]string),
+		locationConnections: locationConnections,
 		tunnelsHA:           newTunnelsForHA(),
 		regSuccess:          registerSuccess,
 		regFail:             registerFail,
+		regRateLimited:      registerRateLimited,
 		rpcFail:             rpcFail,
 		userHostnamesCounts: userHostnamesCounts,
 		localConfigMetrics:  newLocalConfigMetrics(),
+
+		secondaryControlPlaneRegistrations:   secondaryControlPlaneRegistrations,
+		secondaryControlPlaneUnregistrations: secondaryControlPlaneUnregistrations,
+		heartbeatRTT:                         heartbeatRTT,
+		heartbeatMisses:                      heartbeatMisses,
+		trafficBytes:                         trafficBytes,
+		trafficStreams:                       trafficStreams,
+		trafficDatagrams:                     trafficDatagrams,
 	}
 }
 
 func (t *tunnelMetrics) registerServerLocation(connectionID, loc string) {
 	t.locationLock.Lock()
 	defer t.locationLock.Unlock()
+	if connectedLoc, ok := t.connectedLocations[connectionID]; ok {
+		t.locationConnections.WithLabelValues(connectedLoc).Dec()
+	}
+	t.locationConnections.WithLabelValues(loc).Inc()
+	t.connectedLocations[connectionID] = loc
+
 	if oldLoc, ok := t.oldServerLocations[connectionID]; ok && oldLoc == loc {
 		return
 	} else if ok {
@@ -158,6 +284,16 @@ func (t *tunnelMetrics) registerServerLocation(connectionID, loc string) {
 	t.oldServerLocations[connectionID] = loc
 }
 
+// unregisterServerLocation stops counting a connection that disconnected in the connections of its location.
+func (t *tunnelMetrics) unregisterServerLocation(connectionID string) {
+	t.locationLock.Lock()
+	defer t.locationLock.Unlock()
+	if loc, ok := t.connectedLocations[connectionID]; ok {
+		t.locationConnections.WithLabelValues(loc).Dec()
+		delete(t.connectedLocations, connectionID)
+	}
+}
+
 var tunnelMetricsInternal struct {
 	sync.Once
 	metrics *tunnelMetrics
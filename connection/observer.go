@@ -3,10 +3,13 @@ package connection
 import (
 	"net"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 
+	"github.com/cloudflare/cloudflared/client"
 	"github.com/cloudflare/cloudflared/management"
 )
 
@@ -24,6 +27,12 @@ type Observer struct {
 	metrics         *tunnelMetrics
 	tunnelEventChan chan Event
 	addSinkChan     chan EventSink
+	// listeners are only notified once at least one is registered, so that the notifications cost nothing otherwise
+	hasListeners       atomic.Bool
+	listenersLock      sync.RWMutex
+	listeners          []Listener
+	listenerNotifyChan chan func(Listener)
+	egressShaper       *EgressShaper
 }
 
 type EventSink interface {
@@ -37,6 +46,8 @@ func NewObserver(log, logTransport *zerolog.Logger) *Observer {
 		metrics:         newTunnelMetrics(),
 		tunnelEventChan: make(chan Event, observerChannelBufferSize),
 		addSinkChan:     make(chan EventSink, observerChannelBufferSize),
+
+		listenerNotifyChan: make(chan func(Listener), observerChannelBufferSize),
 	}
 	go o.dispatchEvents()
 	return o
@@ -46,6 +57,14 @@ func (o *Observer) RegisterSink(sink EventSink) {
 	o.addSinkChan <- sink
 }
 
+// RegisterListener registers a Listener to be notified about the state of the tunnel from now on.
+func (o *Observer) RegisterListener(listener Listener) {
+	o.listenersLock.Lock()
+	defer o.listenersLock.Unlock()
+	o.listeners = append(o.listeners, listener)
+	o.hasListeners.Store(true)
+}
+
 func (o *Observer) logConnecting(connIndex uint8, address net.IP, protocol Protocol) {
 	o.log.Debug().
 		Int(management.EventTypeKey, int(management.Cloudflared)).
@@ -71,8 +90,37 @@ func (o *Observer) sendRegisteringEvent(connIndex uint8) {
 	o.sendEvent(Event{Index: connIndex, EventType: RegisteringTunnel})
 }
 
-func (o *Observer) sendConnectedEvent(connIndex uint8, protocol Protocol, location string, edgeAddress net.IP) {
-	o.sendEvent(Event{Index: connIndex, EventType: Connected, Protocol: protocol, Location: location, EdgeAddress: edgeAddress})
+func (o *Observer) sendConnectingEvent(connIndex uint8, protocol Protocol, edgeAddress net.IP) {
+	o.notifyListeners(func(l Listener) {
+		l.OnConnected(connIndex, protocol, edgeAddress)
+	})
+}
+
+func (o *Observer) sendConnectedEvent(
+	connectionID uuid.UUID,
+	connIndex uint8,
+	protocol Protocol,
+	location string,
+	edgeAddress net.IP,
+	connOptions *client.ConnectionOptionsSnapshot,
+) {
+	o.sendEvent(Event{
+		Index:             connIndex,
+		EventType:         Connected,
+		Protocol:          protocol,
+		Location:          location,
+		EdgeAddress:       edgeAddress,
+		ConnectionOptions: connOptions,
+	})
+	o.notifyListeners(func(l Listener) {
+		l.OnRegistered(RegisteredConnection{
+			Index:        connIndex,
+			ConnectionID: connectionID,
+			Location:     location,
+			Protocol:     protocol,
+			EdgeAddress:  edgeAddress,
+		})
+	})
 }
 
 func (o *Observer) SendURL(url string) {
@@ -95,7 +143,25 @@ func (o *Observer) sendUnregisteringEvent(connIndex uint8) {
 }
 
 func (o *Observer) SendDisconnect(connIndex uint8) {
+	o.metrics.unregisterServerLocation(uint8ToString(connIndex))
 	o.sendEvent(Event{Index: connIndex, EventType: Disconnected})
+	o.notifyListeners(func(l Listener) {
+		l.OnDisconnected(connIndex)
+	})
+}
+
+// SendConfigApplied notifies listeners that a new remote configuration version was applied.
+func (o *Observer) SendConfigApplied(version int32) {
+	o.notifyListeners(func(l Listener) {
+		l.OnConfigApplied(version)
+	})
+}
+
+// SendProtocolChange notifies listeners that a connection changed the protocol it connects with.
+func (o *Observer) SendProtocolChange(connIndex uint8, from, to Protocol) {
+	o.notifyListeners(func(l Listener) {
+		l.OnProtocolChange(connIndex, from, to)
+	})
 }
 
 func (o *Observer) sendEvent(e Event) {
@@ -107,6 +173,18 @@ func (o *Observer) sendEvent(e Event) {
 	}
 }
 
+func (o *Observer) notifyListeners(notify func(Listener)) {
+	if !o.hasListeners.Load() {
+		return
+	}
+	select {
+	case o.listenerNotifyChan <- notify:
+		break
+	default:
+		o.log.Warn().Msg("observer listener channel buffer is full")
+	}
+}
+
 func (o *Observer) dispatchEvents() {
 	var sinks []EventSink
 	for {
@@ -117,6 +195,13 @@ func (o *Observer) dispatchEvents() {
 			for _, sink := range sinks {
 				sink.OnTunnelEvent(evt)
 			}
+		case notify := <-o.listenerNotifyChan:
+			o.listenersLock.RLock()
+			listeners := o.listeners
+			o.listenersLock.RUnlock()
+			for _, listener := range listeners {
+				notify(listener)
+			}
 		}
 	}
 }
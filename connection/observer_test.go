@@ -1,14 +1,19 @@
 package connection
 
 import (
+	"fmt"
+	"net"
 	"strconv"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/prometheus/client_golang/prometheus"
 	dto "github.com/prometheus/client_model/go"
 	"github.com/stretchr/testify/assert"
+
+	"github.com/cloudflare/cloudflared/client"
 )
 
 func TestSendUrl(t *testing.T) {
@@ -62,6 +67,29 @@ func TestRegisterServerLocation(t *testing.T) {
 
 }
 
+func TestLocationConnections(t *testing.T) {
+	m := newTunnelMetrics()
+	getGaugeValue := func(loc string) float64 {
+		var metric dto.Metric
+		assert.NoError(t, m.locationConnections.WithLabelValues(loc).Write(&metric))
+		return metric.Gauge.GetValue()
+	}
+
+	m.registerServerLocation("40", "SIN")
+	m.registerServerLocation("41", "SIN")
+	assert.Equal(t, 2.0, getGaugeValue("SIN"))
+
+	// A connection registering again is only counted in its new location
+	m.registerServerLocation("41", "NRT")
+	assert.Equal(t, 1.0, getGaugeValue("SIN"))
+	assert.Equal(t, 1.0, getGaugeValue("NRT"))
+
+	m.unregisterServerLocation("40")
+	m.unregisterServerLocation("40")
+	assert.Equal(t, 0.0, getGaugeValue("SIN"))
+	assert.Equal(t, 1.0, getGaugeValue("NRT"))
+}
+
 func TestObserverEventsDontBlock(t *testing.T) {
 	observer := NewObserver(&log, &log)
 	var mu sync.Mutex
@@ -86,6 +114,64 @@ func TestObserverEventsDontBlock(t *testing.T) {
 	}
 }
 
+type listenerCollector struct {
+	NopListener
+	calls chan string
+}
+
+func (l *listenerCollector) OnConnected(connIndex uint8, protocol Protocol, edgeAddress net.IP) {
+	l.calls <- fmt.Sprintf("connected %d %s %s", connIndex, protocol, edgeAddress)
+}
+
+func (l *listenerCollector) OnRegistered(conn RegisteredConnection) {
+	l.calls <- fmt.Sprintf("registered %d %s %s", conn.Index, conn.ConnectionID, conn.Location)
+}
+
+func (l *listenerCollector) OnDisconnected(connIndex uint8) {
+	l.calls <- fmt.Sprintf("disconnected %d", connIndex)
+}
+
+func (l *listenerCollector) OnConfigApplied(version int32) {
+	l.calls <- fmt.Sprintf("config %d", version)
+}
+
+func (l *listenerCollector) OnProtocolChange(connIndex uint8, from, to Protocol) {
+	l.calls <- fmt.Sprintf("protocol %d %s %s", connIndex, from, to)
+}
+
+func TestObserverListeners(t *testing.T) {
+	observer := NewObserver(&log, &log)
+	// Nothing is queued before a listener is registered
+	observer.SendDisconnect(3)
+
+	listener := &listenerCollector{calls: make(chan string, 10)}
+	observer.RegisterListener(listener)
+
+	connID := uuid.New()
+	observer.sendConnectingEvent(1, QUIC, net.ParseIP("198.41.200.1"))
+	observer.sendConnectedEvent(connID, 1, QUIC, "LHR", net.ParseIP("198.41.200.1"), &client.ConnectionOptionsSnapshot{})
+	observer.SendConfigApplied(5)
+	observer.SendProtocolChange(2, QUIC, HTTP2)
+	observer.SendDisconnect(1)
+
+	expected := []string{
+		"connected 1 quic 198.41.200.1",
+		"registered 1 " + connID.String() + " LHR",
+		"config 5",
+		"protocol 2 quic http2",
+		"disconnected 1",
+	}
+	for _, call := range expected {
+		select {
+		case got := <-listener.calls:
+			assert.Equal(t, call, got)
+		case <-time.After(time.Second):
+			t.Fatalf("listener was not called with %q", call)
+		}
+	}
+	assert.Empty(t, listener.calls)
+}
+
 type eventCollectorSink struct {
 	observedEvents []Event
 	mu             sync.Mutex
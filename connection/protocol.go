@@ -12,7 +12,7 @@ import (
 )
 
 const (
-	AvailableProtocolFlagMessage = "Available protocols: 'auto' - automatically chooses the best protocol over time (the default; and also the recommended one); 'quic' - based on QUIC, relying on UDP egress to Cloudflare edge; 'http2' - using Go's HTTP2 library, relying on TCP egress to Cloudflare edge"
+	AvailableProtocolFlagMessage = "Available protocols: 'auto' - automatically chooses the best protocol over time (the default; and also the recommended one); 'quic' - based on QUIC, relying on UDP egress to Cloudflare edge; 'http2' - using Go's HTTP2 library, relying on TCP egress to Cloudflare edge; 'hybrid' - serves both quic and http2 at once, each on part of the HA connections, so that one degraded protocol path leaves the other serving"
 	// edgeH2muxTLSServerName is the server name to establish h2mux connection with edge (unused, but kept for legacy reference).
 	_ = "cftunnel.com"
 	// edgeH2TLSServerName is the server name to establish http2 connection with edge
@@ -20,6 +20,7 @@ const (
 	// edgeQUICServerName is the server name to establish quic connection with edge.
 	edgeQUICServerName = "quic.cftunnel.com"
 	AutoSelectFlag     = "auto"
+	HybridFlag         = "hybrid"
 	// SRV and TXT record resolution TTL
 	ResolveTTL = time.Hour
 )
@@ -86,6 +87,68 @@ type ProtocolSelector interface {
 	Fallback() (Protocol, bool)
 }
 
+// ProtocolHinter is a ProtocolSelector learning from the connections which protocol actually works, so that the
+// others converge on it instead of each finding it out on its own.
+type ProtocolHinter interface {
+	ProtocolSelector
+	// Hint makes Current return protocol, which a connection just registered with, for ttl. Fallback is unchanged,
+	// so that connections can still fall back from the selected protocol.
+	Hint(protocol Protocol, ttl time.Duration)
+	// Hinted returns the protocol of the last hint, or false once it expired.
+	Hinted() (Protocol, bool)
+}
+
+// protocolHint is the protocol a connection last registered with, until it expires.
+type protocolHint struct {
+	mu       sync.Mutex
+	protocol Protocol
+	expiry   time.Time
+}
+
+func (h *protocolHint) Hint(protocol Protocol, ttl time.Duration) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.protocol = protocol
+	h.expiry = time.Now().Add(ttl)
+}
+
+func (h *protocolHint) Hinted() (Protocol, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if !time.Now().Before(h.expiry) {
+		return 0, false
+	}
+	return h.protocol, true
+}
+
+// HybridProtocolSelector serves all the protocols of ProtocolList in parallel, each on its share of the HA
+// connections, rather than falling back from one to the other for all of them.
+type HybridProtocolSelector interface {
+	ProtocolSelector
+	// ForConnection returns the selector of the protocol connection connIndex serves.
+	ForConnection(connIndex uint8) ProtocolSelector
+}
+
+// hybridProtocolSelector alternates the protocols of ProtocolList between the connections. A QUIC connection may still
+// fall back to HTTP2 when QUIC is broken for it, while HTTP2 connections have nowhere to fall back to.
+type hybridProtocolSelector struct{}
+
+func (s *hybridProtocolSelector) Current() Protocol {
+	return ProtocolList[0]
+}
+
+func (s *hybridProtocolSelector) Fallback() (Protocol, bool) {
+	return ProtocolList[0].fallback()
+}
+
+func (s *hybridProtocolSelector) ForConnection(connIndex uint8) ProtocolSelector {
+	protocol := ProtocolList[int(connIndex)%len(ProtocolList)]
+	if _, hasFallback := protocol.fallback(); hasFallback {
+		return newDefaultProtocolSelector(protocol)
+	}
+	return &staticProtocolSelector{current: protocol}
+}
+
 // staticProtocolSelector will not provide a different protocol for Fallback
 type staticProtocolSelector struct {
 	current Protocol
@@ -101,6 +164,7 @@ func (s *staticProtocolSelector) Fallback() (Protocol, bool) {
 
 // remoteProtocolSelector will fetch a list of remote protocols to provide for edge discovery
 type remoteProtocolSelector struct {
+	protocolHint
 	lock sync.RWMutex
 
 	current Protocol
@@ -135,6 +199,9 @@ func newRemoteProtocolSelector(
 }
 
 func (s *remoteProtocolSelector) Current() Protocol {
+	if protocol, ok := s.Hinted(); ok {
+		return protocol
+	}
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	if time.Now().Before(s.refreshAfter) {
@@ -176,6 +243,7 @@ func getProtocol(protocolPool []Protocol, fetchFunc edgediscovery.PercentageFetc
 
 // defaultProtocolSelector will allow for a protocol to have a fallback
 type defaultProtocolSelector struct {
+	protocolHint
 	lock    sync.RWMutex
 	current Protocol
 }
@@ -189,6 +257,9 @@ func newDefaultProtocolSelector(
 }
 
 func (s *defaultProtocolSelector) Current() Protocol {
+	if protocol, ok := s.Hinted(); ok {
+		return protocol
+	}
 	s.lock.Lock()
 	defer s.lock.Unlock()
 	return s.current
@@ -236,6 +307,8 @@ func NewProtocolSelector(
 		return &staticProtocolSelector{current: QUIC}, nil
 	case HTTP2.String():
 		return &staticProtocolSelector{current: HTTP2}, nil
+	case HybridFlag:
+		return &hybridProtocolSelector{}, nil
 	case AutoSelectFlag:
 		// When a --token is provided, we want to start with QUIC but have fallback to HTTP2
 		if tunnelTokenProvided {
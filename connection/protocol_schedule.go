@@ -0,0 +1,219 @@
+package connection
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxScheduleWindow bounds how long a schedule window stays open after each matching time
+const maxScheduleWindow = 7 * 24 * time.Hour
+
+// ScheduleWindow opens at every minute matching a cron expression and stays open for a duration.
+type ScheduleWindow struct {
+	schedule *cronSchedule
+	duration time.Duration
+}
+
+// NewScheduleWindow parses a window from a cron expression with the 5 standard fields (minute, hour, day of month,
+// month and day of week, in local time) and how long the window stays open.
+func NewScheduleWindow(schedule string, duration time.Duration) (ScheduleWindow, error) {
+	cron, err := parseCronSchedule(schedule)
+	if err != nil {
+		return ScheduleWindow{}, err
+	}
+	if duration < time.Minute || duration > maxScheduleWindow {
+		return ScheduleWindow{}, fmt.Errorf("schedule duration must be between 1m and %s, got %s", maxScheduleWindow, duration)
+	}
+	return ScheduleWindow{schedule: cron, duration: duration}, nil
+}
+
+// Active tells whether the window is open at t, i.e. a minute matching the schedule started less than the duration
+// of the window before t.
+func (w ScheduleWindow) Active(t time.Time) bool {
+	t = t.Local()
+	for m := t.Truncate(time.Minute); t.Sub(m) < w.duration; m = m.Add(-time.Minute) {
+		if w.schedule.matches(m) {
+			return true
+		}
+	}
+	return false
+}
+
+// ProtocolScheduleRule forces a protocol while its window is open.
+type ProtocolScheduleRule struct {
+	window   ScheduleWindow
+	protocol Protocol
+}
+
+// NewProtocolScheduleRule parses a rule from the cron expression and duration of its window, see NewScheduleWindow,
+// and the protocol to force.
+func NewProtocolScheduleRule(schedule string, duration time.Duration, protocol string) (ProtocolScheduleRule, error) {
+	window, err := NewScheduleWindow(schedule, duration)
+	if err != nil {
+		return ProtocolScheduleRule{}, err
+	}
+	var p Protocol
+	switch protocol {
+	case QUIC.String():
+		p = QUIC
+	case HTTP2.String():
+		p = HTTP2
+	default:
+		return ProtocolScheduleRule{}, fmt.Errorf("protocol schedule can only force %s or %s, got %q", QUIC, HTTP2, protocol)
+	}
+	return ProtocolScheduleRule{window: window, protocol: p}, nil
+}
+
+// ProtocolScheduler is a ProtocolSelector forcing protocols at scheduled times. The protocol of the connections
+// is expected to be refreshed with Current once their window opens or closes.
+type ProtocolScheduler interface {
+	ProtocolSelector
+	// Scheduled returns the protocol forced by the schedule at this time, if any.
+	Scheduled() (Protocol, bool)
+}
+
+// scheduledProtocolSelector forces the protocol of the first rule whose window is open, and defers to the wrapped
+// selector otherwise. A forced protocol has no fallback.
+type scheduledProtocolSelector struct {
+	ProtocolSelector
+	rules []ProtocolScheduleRule
+	now   func() time.Time
+}
+
+// NewScheduledProtocolSelector wraps selector to force the protocols of rules while their window is open.
+func NewScheduledProtocolSelector(selector ProtocolSelector, rules []ProtocolScheduleRule) (ProtocolScheduler, error) {
+	if _, ok := selector.(HybridProtocolSelector); ok {
+		return nil, fmt.Errorf("a protocol schedule can't be used with the %s protocol", HybridFlag)
+	}
+	return &scheduledProtocolSelector{
+		ProtocolSelector: selector,
+		rules:            rules,
+		now:              time.Now,
+	}, nil
+}
+
+func (s *scheduledProtocolSelector) Scheduled() (Protocol, bool) {
+	now := s.now()
+	for _, rule := range s.rules {
+		if rule.window.Active(now) {
+			return rule.protocol, true
+		}
+	}
+	return 0, false
+}
+
+func (s *scheduledProtocolSelector) Current() Protocol {
+	if protocol, ok := s.Scheduled(); ok {
+		return protocol
+	}
+	return s.ProtocolSelector.Current()
+}
+
+func (s *scheduledProtocolSelector) Fallback() (Protocol, bool) {
+	if protocol, ok := s.Scheduled(); ok {
+		return protocol, false
+	}
+	return s.ProtocolSelector.Fallback()
+}
+
+// Hint passes the hint on to the wrapped selector, if it takes hints.
+func (s *scheduledProtocolSelector) Hint(protocol Protocol, ttl time.Duration) {
+	if hinter, ok := s.ProtocolSelector.(ProtocolHinter); ok {
+		hinter.Hint(protocol, ttl)
+	}
+}
+
+func (s *scheduledProtocolSelector) Hinted() (Protocol, bool) {
+	if hinter, ok := s.ProtocolSelector.(ProtocolHinter); ok {
+		return hinter.Hinted()
+	}
+	return 0, false
+}
+
+// cronSchedule is the set of minutes, hours, days of month, months and days of week matched by a cron expression
+type cronSchedule struct {
+	minutes, hours, daysOfMonth, months, daysOfWeek []bool
+	// Following cron, when both days are restricted a time matches if either of them does
+	anyDayOfMonth, anyDayOfWeek bool
+}
+
+func parseCronSchedule(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected 5 fields (minute hour day-of-month month day-of-week)", expr)
+	}
+	var schedule cronSchedule
+	var err error
+	if schedule.minutes, err = parseCronField(fields[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: minute: %w", expr, err)
+	}
+	if schedule.hours, err = parseCronField(fields[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: hour: %w", expr, err)
+	}
+	if schedule.daysOfMonth, err = parseCronField(fields[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: day of month: %w", expr, err)
+	}
+	if schedule.months, err = parseCronField(fields[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: month: %w", expr, err)
+	}
+	// Both 0 and 7 are Sunday
+	if schedule.daysOfWeek, err = parseCronField(fields[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("invalid schedule %q: day of week: %w", expr, err)
+	}
+	schedule.daysOfWeek[0] = schedule.daysOfWeek[0] || schedule.daysOfWeek[7]
+	schedule.anyDayOfMonth = fields[2] == "*"
+	schedule.anyDayOfWeek = fields[4] == "*"
+	return &schedule, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges (a-b) and steps (*/n or a-b/n) between min and max.
+func parseCronField(field string, min, max int) ([]bool, error) {
+	matches := make([]bool, max+1)
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepPart); err != nil || step < 1 {
+				return nil, fmt.Errorf("invalid step %q", stepPart)
+			}
+		}
+		start, end := min, max
+		if rangePart != "*" {
+			startPart, endPart, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if start, err = strconv.Atoi(startPart); err != nil {
+				return nil, fmt.Errorf("invalid value %q", startPart)
+			}
+			end = start
+			if isRange {
+				if end, err = strconv.Atoi(endPart); err != nil {
+					return nil, fmt.Errorf("invalid value %q", endPart)
+				}
+			} else if hasStep {
+				end = max
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("%q is out of the range %d-%d", part, min, max)
+		}
+		for i := start; i <= end; i += step {
+			matches[i] = true
+		}
+	}
+	return matches, nil
+}
+
+func (c *cronSchedule) matches(t time.Time) bool {
+	if !c.minutes[t.Minute()] || !c.hours[t.Hour()] || !c.months[t.Month()] {
+		return false
+	}
+	dayOfMonth := c.daysOfMonth[t.Day()]
+	dayOfWeek := c.daysOfWeek[t.Weekday()]
+	if c.anyDayOfMonth || c.anyDayOfWeek {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
@@ -0,0 +1,111 @@
+package connection
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCronSchedule(t *testing.T) {
+	tests := []struct {
+		expr    string
+		times   map[string]bool
+		wantErr bool
+	}{
+		{
+			expr: "30 1 * * *",
+			times: map[string]bool{
+				"2024-03-04T01:30:00": true,
+				"2024-03-04T01:31:00": false,
+				"2024-03-04T02:30:00": false,
+			},
+		},
+		{
+			expr: "*/15 22-23,0 * * 1-5",
+			times: map[string]bool{
+				"2024-03-04T22:45:00": true,  // Monday
+				"2024-03-05T00:15:00": true,  // Tuesday
+				"2024-03-05T00:20:00": false, // not on the step
+				"2024-03-09T22:00:00": false, // Saturday
+			},
+		},
+		{
+			// Either restricted day matches
+			expr: "0 0 1 * 7",
+			times: map[string]bool{
+				"2024-03-01T00:00:00": true, // 1st, a Friday
+				"2024-03-03T00:00:00": true, // Sunday
+				"2024-03-04T00:00:00": false,
+			},
+		},
+		{expr: "* * * *", wantErr: true},
+		{expr: "60 * * * *", wantErr: true},
+		{expr: "* 5-2 * * *", wantErr: true},
+		{expr: "*/0 * * * *", wantErr: true},
+		{expr: "* * * jan *", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.expr, func(t *testing.T) {
+			schedule, err := parseCronSchedule(test.expr)
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			for value, expected := range test.times {
+				tm, err := time.ParseInLocation("2006-01-02T15:04:05", value, time.Local)
+				require.NoError(t, err)
+				assert.Equal(t, expected, schedule.matches(tm), value)
+			}
+		})
+	}
+}
+
+func TestNewProtocolScheduleRule(t *testing.T) {
+	_, err := NewProtocolScheduleRule("0 1 * * *", 4*time.Hour, "http2")
+	require.NoError(t, err)
+	_, err = NewProtocolScheduleRule("0 1 * * *", 4*time.Hour, "h2mux")
+	require.Error(t, err)
+	_, err = NewProtocolScheduleRule("0 1 * * *", time.Second, "http2")
+	require.Error(t, err)
+	_, err = NewProtocolScheduleRule("0 1 * *", time.Hour, "http2")
+	require.Error(t, err)
+}
+
+func TestScheduledProtocolSelector(t *testing.T) {
+	// HTTP2 from 01:00 to 05:00 every night, QUIC with a fallback otherwise
+	rule, err := NewProtocolScheduleRule("0 1 * * *", 4*time.Hour, "http2")
+	require.NoError(t, err)
+	selector, err := NewScheduledProtocolSelector(newDefaultProtocolSelector(QUIC), []ProtocolScheduleRule{rule})
+	require.NoError(t, err)
+	scheduled := selector.(*scheduledProtocolSelector)
+
+	tests := []struct {
+		time        string
+		expected    Protocol
+		hasFallback bool
+	}{
+		{time: "2024-03-04T00:59:00", expected: QUIC, hasFallback: true},
+		{time: "2024-03-04T01:00:00", expected: HTTP2},
+		{time: "2024-03-04T04:59:59", expected: HTTP2},
+		{time: "2024-03-04T05:00:00", expected: QUIC, hasFallback: true},
+	}
+	for _, test := range tests {
+		now, err := time.ParseInLocation("2006-01-02T15:04:05", test.time, time.Local)
+		require.NoError(t, err)
+		scheduled.now = func() time.Time { return now }
+
+		assert.Equal(t, test.expected, selector.Current(), test.time)
+		_, hasFallback := selector.Fallback()
+		assert.Equal(t, test.hasFallback, hasFallback, test.time)
+		_, isScheduled := selector.Scheduled()
+		assert.Equal(t, !test.hasFallback, isScheduled, test.time)
+	}
+}
+
+func TestScheduledProtocolSelectorRejectsHybrid(t *testing.T) {
+	_, err := NewScheduledProtocolSelector(&hybridProtocolSelector{}, nil)
+	require.Error(t, err)
+}
@@ -3,6 +3,7 @@ package connection
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
@@ -67,6 +68,19 @@ func TestNewProtocolSelector(t *testing.T) {
 			hasFallback:      true,
 			expectedFallback: HTTP2,
 		},
+		{
+			name:             "named tunnel with hybrid",
+			protocol:         HybridFlag,
+			expectedProtocol: QUIC,
+			hasFallback:      true,
+			expectedFallback: HTTP2,
+		},
+		{
+			name:             "named tunnel (post quantum) w/hybrid",
+			protocol:         HybridFlag,
+			needPQ:           true,
+			expectedProtocol: QUIC,
+		},
 		{
 			name:             "named tunnel (post quantum)",
 			protocol:         AutoSelectFlag,
@@ -171,3 +185,62 @@ func TestAutoProtocolSelectorNoRefreshWithToken(t *testing.T) {
 	fetcher.protocolPercents = edgediscovery.ProtocolPercents{edgediscovery.ProtocolPercent{Protocol: "http2", Percentage: 100}}
 	assert.Equal(t, QUIC, selector.Current())
 }
+
+func TestProtocolSelectorHint(t *testing.T) {
+	fetcher := dynamicMockFetcher{
+		protocolPercents: edgediscovery.ProtocolPercents{edgediscovery.ProtocolPercent{Protocol: "quic", Percentage: 100}},
+	}
+	remote, err := NewProtocolSelector(AutoSelectFlag, testAccountTag, false, false, fetcher.fetch(), time.Hour, &log)
+	assert.NoError(t, err)
+	withToken, err := NewProtocolSelector(AutoSelectFlag, testAccountTag, true, false, fetcher.fetch(), time.Hour, &log)
+	assert.NoError(t, err)
+	scheduled, err := NewScheduledProtocolSelector(remote, nil)
+	assert.NoError(t, err)
+
+	for _, selector := range []ProtocolSelector{remote, withToken, scheduled} {
+		hinter, ok := selector.(ProtocolHinter)
+		assert.True(t, ok)
+		_, hinted := hinter.Hinted()
+		assert.False(t, hinted)
+
+		hinter.Hint(HTTP2, time.Hour)
+		protocol, hinted := hinter.Hinted()
+		assert.True(t, hinted)
+		assert.Equal(t, HTTP2, protocol)
+		assert.Equal(t, HTTP2, selector.Current())
+		// Connections can still fall back from the selected protocol
+		fallback, hasFallback := selector.Fallback()
+		assert.True(t, hasFallback)
+		assert.Equal(t, HTTP2, fallback)
+
+		hinter.Hint(HTTP2, 0)
+		_, hinted = hinter.Hinted()
+		assert.False(t, hinted)
+		assert.Equal(t, QUIC, selector.Current())
+	}
+
+	// A protocol the user picked takes no hint
+	static, err := NewProtocolSelector(QUIC.String(), testAccountTag, false, false, fetcher.fetch(), time.Hour, &log)
+	assert.NoError(t, err)
+	_, ok := static.(ProtocolHinter)
+	assert.False(t, ok)
+}
+
+func TestHybridProtocolSelectorForConnection(t *testing.T) {
+	selector, err := NewProtocolSelector(HybridFlag, testAccountTag, false, false, mockFetcher(true), testNoTTL, &log)
+	assert.NoError(t, err)
+	hybrid, ok := selector.(HybridProtocolSelector)
+	assert.True(t, ok)
+
+	for connIndex, expected := range []Protocol{QUIC, HTTP2, QUIC, HTTP2} {
+		connSelector := hybrid.ForConnection(uint8(connIndex))
+		assert.Equal(t, expected, connSelector.Current())
+		fallback, hasFallback := connSelector.Fallback()
+		if expected == QUIC {
+			assert.True(t, hasFallback)
+			assert.Equal(t, HTTP2, fallback)
+		} else {
+			assert.False(t, hasFallback)
+		}
+	}
+}
@@ -8,9 +8,14 @@ import (
 	"net/netip"
 	"runtime"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/quic-go/quic-go"
 	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/edgediscovery"
+	"github.com/cloudflare/cloudflared/faultinject"
 )
 
 var (
@@ -25,14 +30,31 @@ func DialQuic(
 	edgeAddr netip.AddrPort,
 	localAddr net.IP,
 	connIndex uint8,
+	dscp uint8,
+	faults *faultinject.Injector,
 	logger *zerolog.Logger,
 ) (quic.Connection, error) {
+	dialStart := time.Now()
 	udpConn, err := createUDPConnForConnIndex(connIndex, localAddr, edgeAddr, logger)
 	if err != nil {
+		edgediscovery.ObserveConnectPhase(edgediscovery.ConnectPhaseDial, QUIC.String(), dialStart, err)
 		return nil, err
 	}
 
-	conn, err := quic.Dial(ctx, udpConn, net.UDPAddrFromAddrPort(edgeAddr), tlsConfig, quicConfig)
+	var packetConn net.PacketConn = udpConn
+	if dscp != 0 {
+		if err := edgediscovery.SetDSCP(udpConn, net.IP(edgeAddr.Addr().Unmap().AsSlice()), dscp); err != nil {
+			edgediscovery.ObserveConnectPhase(edgediscovery.ConnectPhaseDial, QUIC.String(), dialStart, err)
+			udpConn.Close()
+			return nil, &EdgeQuicDialError{Cause: err}
+		}
+		packetConn = newDSCPPacketConn(udpConn)
+	}
+	edgediscovery.ObserveConnectPhase(edgediscovery.ConnectPhaseDial, QUIC.String(), dialStart, nil)
+
+	handshakeStart := time.Now()
+	conn, err := quic.Dial(ctx, faults.WrapPacketConn(packetConn), net.UDPAddrFromAddrPort(edgeAddr), tlsConfig, quicConfig)
+	edgediscovery.ObserveConnectPhase(edgediscovery.ConnectPhaseHandshake, QUIC.String(), handshakeStart, err)
 	if err != nil {
 		// close the udp server socket in case of error connecting to the edge
 		udpConn.Close()
@@ -88,6 +110,33 @@ func createUDPConnForConnIndex(connIndex uint8, localIP net.IP, edgeIP netip.Add
 	return udpConn, err
 }
 
+// dscpPacketConn keeps quic-go from sending packets with ancillary data, which sets the whole TOS byte to the ECN
+// bits of each packet and so would clear the DSCP marking of the socket. It disables ECN and GSO but not
+// setting the DF bit and the socket buffer sizes.
+type dscpPacketConn struct {
+	net.PacketConn
+	udpConn *net.UDPConn
+}
+
+func newDSCPPacketConn(udpConn *net.UDPConn) *dscpPacketConn {
+	return &dscpPacketConn{
+		PacketConn: udpConn,
+		udpConn:    udpConn,
+	}
+}
+
+func (c *dscpPacketConn) SyscallConn() (syscall.RawConn, error) {
+	return c.udpConn.SyscallConn()
+}
+
+func (c *dscpPacketConn) SetReadBuffer(bytes int) error {
+	return c.udpConn.SetReadBuffer(bytes)
+}
+
+func (c *dscpPacketConn) SetWriteBuffer(bytes int) error {
+	return c.udpConn.SetWriteBuffer(bytes)
+}
+
 type wrapCloseableConnQuicConnection struct {
 	quic.Connection
 	udpConn *net.UDPConn
@@ -46,6 +46,8 @@ type quicConnection struct {
 	controlStreamHandler ControlStreamHandler
 	connOptions          *client.ConnectionOptionsSnapshot
 	connIndex            uint8
+	traffic              *ConnTraffic
+	resources            *ConnResources
 
 	rpcTimeout         time.Duration
 	streamWriteTimeout time.Duration
@@ -64,6 +66,8 @@ func NewTunnelConnection(
 	rpcTimeout time.Duration,
 	streamWriteTimeout time.Duration,
 	gracePeriod time.Duration,
+	traffic *ConnTraffic,
+	resources *ConnResources,
 	logger *zerolog.Logger,
 ) TunnelConnection {
 	return &quicConnection{
@@ -74,6 +78,8 @@ func NewTunnelConnection(
 		controlStreamHandler: controlStreamHandler,
 		connOptions:          connOptions,
 		connIndex:            connIndex,
+		traffic:              traffic,
+		resources:            resources,
 		rpcTimeout:           rpcTimeout,
 		streamWriteTimeout:   streamWriteTimeout,
 		gracePeriod:          gracePeriod,
@@ -142,7 +148,7 @@ func (q *quicConnection) Serve(ctx context.Context) error {
 
 // serveControlStream will serve the RPC; blocking until the control plane is done.
 func (q *quicConnection) serveControlStream(ctx context.Context, controlStream quic.Stream) error {
-	return q.controlStreamHandler.ServeControlStream(ctx, controlStream, q.connOptions.ConnectionOptions(), q.orchestrator)
+	return q.controlStreamHandler.ServeControlStream(ctx, q.traffic.stream(ctx, TrafficControl, controlStream), q.connOptions, q.orchestrator)
 }
 
 // Close the connection with no errors specified.
@@ -160,11 +166,14 @@ func (q *quicConnection) acceptStream(ctx context.Context) error {
 			}
 			return fmt.Errorf("failed to accept QUIC stream: %w", err)
 		}
-		go q.runStream(quicStream)
+		q.resources.Go(func() {
+			q.runStream(quicStream)
+		})
 	}
 }
 
 func (q *quicConnection) runStream(quicStream quic.Stream) {
+	defer q.resources.StreamStarted()()
 	ctx := quicStream.Context()
 	stream := cfdquic.NewSafeStreamCloser(quicStream, q.streamWriteTimeout, q.logger)
 	defer stream.Close()
@@ -223,6 +232,7 @@ func (q *quicConnection) dispatchRequest(ctx context.Context, stream *rpcquic.Re
 
 	switch request.Type {
 	case pogs.ConnectionTypeHTTP, pogs.ConnectionTypeWebsocket:
+		stream = &rpcquic.RequestServerStream{ReadWriteCloser: q.traffic.stream(ctx, TrafficHTTP, stream.ReadWriteCloser)}
 		tracedReq, err := buildHTTPRequest(ctx, request, stream, q.connIndex, q.logger)
 		if err != nil {
 			return err, false
@@ -231,6 +241,7 @@ func (q *quicConnection) dispatchRequest(ctx context.Context, stream *rpcquic.Re
 		return originProxy.ProxyHTTP(&w, tracedReq, request.Type == pogs.ConnectionTypeWebsocket), w.connectResponseSent
 
 	case pogs.ConnectionTypeTCP:
+		stream = &rpcquic.RequestServerStream{ReadWriteCloser: q.traffic.stream(ctx, TrafficTCP, stream.ReadWriteCloser)}
 		rwa := &streamReadWriteAcker{RequestServerStream: stream}
 		metadata := request.MetadataMap()
 		return originProxy.ProxyTCP(ctx, rwa, &TCPRequest{
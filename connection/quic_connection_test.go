@@ -27,6 +27,7 @@ import (
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"golang.org/x/net/ipv4"
 	"golang.org/x/net/nettest"
 
 	"github.com/cloudflare/cloudflared/client"
@@ -34,6 +35,7 @@ import (
 	cfdflow "github.com/cloudflare/cloudflared/flow"
 
 	"github.com/cloudflare/cloudflared/datagramsession"
+	"github.com/cloudflare/cloudflared/edgediscovery"
 	"github.com/cloudflare/cloudflared/ingress"
 	"github.com/cloudflare/cloudflared/packet"
 	cfdquic "github.com/cloudflare/cloudflared/quic"
@@ -183,6 +185,7 @@ func TestQUICServer(t *testing.T) {
 			<-serverDone
 			cancel()
 			<-connDone
+			assertConnResourcesReleased(t, tunnelConn.(*quicConnection).resources)
 		})
 	}
 }
@@ -191,7 +194,7 @@ type fakeControlStream struct {
 	ControlStreamHandler
 }
 
-func (fakeControlStream) ServeControlStream(ctx context.Context, rw io.ReadWriteCloser, connOptions *pogs.ConnectionOptions, tunnelConfigGetter TunnelConfigJSONGetter) error {
+func (fakeControlStream) ServeControlStream(ctx context.Context, rw io.ReadWriteCloser, connOptions *client.ConnectionOptionsSnapshot, tunnelConfigGetter TunnelConfigJSONGetter) error {
 	<-ctx.Done()
 	return nil
 }
@@ -553,6 +556,7 @@ func TestServeUDPSession(t *testing.T) {
 	serveSession(ctx, datagramConn, edgeQUICSession, closedByTimeout, datagramsession.SessionIdleErr(time.Millisecond*50).Error(), t)
 	serveSession(ctx, datagramConn, edgeQUICSession, closedByRemote, "eyeball closed connection", t)
 	cancel()
+	assertConnResourcesReleased(t, datagramConn.resources)
 }
 
 func TestNopCloserReadWriterCloseBeforeEOF(t *testing.T) {
@@ -605,6 +609,26 @@ func TestCreateUDPConnReuseSourcePort(t *testing.T) {
 	}
 }
 
+func TestDSCPPacketConn(t *testing.T) {
+	edgeAddr := netip.MustParseAddrPort("127.0.0.1:7844")
+	log := zerolog.Nop()
+	udpConn, err := createUDPConnForConnIndex(0, nil, edgeAddr, &log)
+	require.NoError(t, err)
+	defer udpConn.Close()
+
+	require.NoError(t, edgediscovery.SetDSCP(udpConn, net.IP(edgeAddr.Addr().AsSlice()), 46))
+	tos, err := ipv4.NewConn(udpConn).TOS()
+	require.NoError(t, err)
+	assert.Equal(t, 46<<2, tos)
+
+	// quic-go must not be able to overwrite the TOS byte of each packet with ancillary data
+	var packetConn net.PacketConn = newDSCPPacketConn(udpConn)
+	_, ok := packetConn.(quic.OOBCapablePacketConn)
+	assert.False(t, ok)
+	_, ok = packetConn.(interface{ SetReadBuffer(int) error })
+	assert.True(t, ok)
+}
+
 // TestTCPProxy_FlowRateLimited tests if the pogs.ConnectResponse returns the expected error and metadata, when a
 // new flow is rate limited.
 func TestTCPProxy_FlowRateLimited(t *testing.T) {
@@ -814,6 +838,8 @@ func testTunnelConnection(t *testing.T, serverAddr netip.AddrPort, index uint8)
 		serverAddr,
 		nil, // connect on a random port
 		index,
+		0, // leave packets unmarked
+		nil,
 		&log,
 	)
 	require.NoError(t, err)
@@ -834,9 +860,11 @@ func testTunnelConnection(t *testing.T, serverAddr netip.AddrPort, index uint8)
 		TCPWriteTimeout: 1 * time.Second,
 	}, &log)
 
+	resources := NewConnResources(index)
 	datagramConn := &datagramV2Connection{
 		conn,
 		index,
+		resources,
 		sessionManager,
 		cfdflow.NewLimiter(0),
 		datagramMuxer,
@@ -858,6 +886,8 @@ func testTunnelConnection(t *testing.T, serverAddr netip.AddrPort, index uint8)
 		15*time.Second,
 		0*time.Second,
 		0*time.Second,
+		NewObserver(&log, &log).ConnTraffic(index),
+		resources,
 		&log,
 	)
 	return tunnelConn, datagramConn
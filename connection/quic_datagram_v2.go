@@ -49,6 +49,8 @@ type DatagramSessionHandler interface {
 type datagramV2Connection struct {
 	conn  quic.Connection
 	index uint8
+	// resources accounts for the sessions served on this connection
+	resources *ConnResources
 
 	// sessionManager tracks active sessions. It receives datagrams from quic connection via datagramMuxer
 	sessionManager datagramsession.Manager
@@ -76,8 +78,11 @@ func NewDatagramV2Connection(ctx context.Context,
 	rpcTimeout time.Duration,
 	streamWriteTimeout time.Duration,
 	flowLimiter cfdflow.Limiter,
+	traffic *ConnTraffic,
+	resources *ConnResources,
 	logger *zerolog.Logger,
 ) DatagramSessionHandler {
+	conn = traffic.datagrams(conn, classifyDatagramV2)
 	sessionDemuxChan := make(chan *packet.Session, demuxChanCapacity)
 	datagramMuxer := cfdquic.NewDatagramMuxerV2(conn, logger, sessionDemuxChan)
 	sessionManager := datagramsession.NewManager(logger, datagramMuxer.SendToSession, sessionDemuxChan)
@@ -86,6 +91,7 @@ func NewDatagramV2Connection(ctx context.Context,
 	return &datagramV2Connection{
 		conn:               conn,
 		index:              index,
+		resources:          resources,
 		sessionManager:     sessionManager,
 		flowLimiter:        flowLimiter,
 		datagramMuxer:      datagramMuxer,
@@ -173,10 +179,12 @@ func (q *datagramV2Connection) RegisterUdpSession(ctx context.Context, sessionID
 		return nil, err
 	}
 
-	go func() {
+	sessionDone := q.resources.SessionStarted()
+	q.resources.Go(func() {
+		defer sessionDone()
 		defer q.flowLimiter.Release() // we do the release here, instead of inside the `serveUDPSession` just to keep all acquire/release calls in the same method.
 		q.serveUDPSession(session, closeAfterIdleHint)
-	}()
+	})
 
 	log.Debug().
 		Str(datagramsession.LogFieldSessionID, datagramsession.FormatSessionID(sessionID)).
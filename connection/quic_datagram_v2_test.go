@@ -89,6 +89,8 @@ func TestRateLimitOnNewDatagramV2UDPSession(t *testing.T) {
 		0*time.Second,
 		0*time.Second,
 		flowLimiterMock,
+		nil,
+		nil,
 		&log,
 	)
 
@@ -3,6 +3,7 @@ package connection
 import (
 	"context"
 	"net"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
@@ -36,13 +37,23 @@ func NewDatagramV3Connection(ctx context.Context,
 	icmpRouter ingress.ICMPRouter,
 	index uint8,
 	metrics cfdquic.Metrics,
+	traffic *ConnTraffic,
+	resources *ConnResources,
 	logger *zerolog.Logger,
 ) DatagramSessionHandler {
+	conn = traffic.datagrams(conn, classifyDatagramV3)
 	log := logger.
 		With().
 		Int(management.EventTypeKey, int(management.UDP)).
 		Uint8(LogFieldConnIndex, index).
 		Logger()
+	if resources != nil {
+		sessionManager = &accountedSessionManager{
+			SessionManager: sessionManager,
+			resources:      resources,
+			sessionsDone:   make(map[cfdquic.RequestID]func()),
+		}
+	}
 	datagramMuxer := cfdquic.NewDatagramConn(conn, sessionManager, icmpRouter, index, metrics, &log)
 
 	return &datagramV3Connection{
@@ -67,3 +78,37 @@ func (d *datagramV3Connection) UnregisterUdpSession(ctx context.Context, session
 	d.metrics.UnsupportedRemoteCommand(d.index, "unregister_udp_session")
 	return ErrUnsupportedRPCUDPUnregistration
 }
+
+// accountedSessionManager accounts the sessions registered on a connection to its resources, until they are
+// unregistered. The session manager itself is shared by every connection.
+type accountedSessionManager struct {
+	cfdquic.SessionManager
+	resources *ConnResources
+
+	mu           sync.Mutex
+	sessionsDone map[cfdquic.RequestID]func()
+}
+
+func (m *accountedSessionManager) RegisterSession(request *cfdquic.UDPSessionRegistrationDatagram, conn cfdquic.DatagramConn) (cfdquic.Session, error) {
+	session, err := m.SessionManager.RegisterSession(request, conn)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessionsDone[request.RequestID]; !ok {
+		m.sessionsDone[request.RequestID] = m.resources.SessionStarted()
+	}
+	return session, nil
+}
+
+func (m *accountedSessionManager) UnregisterSession(requestID cfdquic.RequestID) {
+	m.SessionManager.UnregisterSession(requestID)
+	m.mu.Lock()
+	done, ok := m.sessionsDone[requestID]
+	delete(m.sessionsDone, requestID)
+	m.mu.Unlock()
+	if ok {
+		done()
+	}
+}
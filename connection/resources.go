@@ -0,0 +1,128 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+const resourcesPollInterval = 10 * time.Millisecond
+
+// ConnResources accounts for the goroutines, streams and UDP sessions owned by a single tunnel connection, so that
+// anything still alive after the connection closed can be reported as a leak. A nil *ConnResources does no
+// accounting.
+type ConnResources struct {
+	connIndex  uint8
+	goroutines atomic.Int64
+	streams    atomic.Int64
+	sessions   atomic.Int64
+}
+
+func NewConnResources(connIndex uint8) *ConnResources {
+	return &ConnResources{connIndex: connIndex}
+}
+
+// Go runs f in a goroutine accounted to the connection.
+func (r *ConnResources) Go(f func()) {
+	if r == nil {
+		go f()
+		return
+	}
+	r.goroutines.Add(1)
+	go func() {
+		defer r.goroutines.Add(-1)
+		f()
+	}()
+}
+
+// StreamStarted accounts for a stream until the returned function is called.
+func (r *ConnResources) StreamStarted() (done func()) {
+	return r.acquire(func(r *ConnResources) *atomic.Int64 { return &r.streams })
+}
+
+// SessionStarted accounts for a UDP session until the returned function is called.
+func (r *ConnResources) SessionStarted() (done func()) {
+	return r.acquire(func(r *ConnResources) *atomic.Int64 { return &r.sessions })
+}
+
+func (r *ConnResources) acquire(counter func(*ConnResources) *atomic.Int64) func() {
+	if r == nil {
+		return func() {}
+	}
+	c := counter(r)
+	c.Add(1)
+	var released atomic.Bool
+	return func() {
+		if released.CompareAndSwap(false, true) {
+			c.Add(-1)
+		}
+	}
+}
+
+// Residue returns what the connection currently holds.
+func (r *ConnResources) Residue() ConnResidue {
+	if r == nil {
+		return ConnResidue{}
+	}
+	return ConnResidue{
+		ConnIndex:  r.connIndex,
+		Goroutines: r.goroutines.Load(),
+		Streams:    r.streams.Load(),
+		Sessions:   r.sessions.Load(),
+	}
+}
+
+// WaitForRelease waits until the connection holds nothing or ctx is done, and returns what it still holds.
+// Goroutines of a closed connection take a moment to observe the closure, so the residue is only a leak once
+// they had time to return.
+func (r *ConnResources) WaitForRelease(ctx context.Context) ConnResidue {
+	ticker := time.NewTicker(resourcesPollInterval)
+	defer ticker.Stop()
+	for {
+		residue := r.Residue()
+		if residue.IsZero() {
+			return residue
+		}
+		select {
+		case <-ctx.Done():
+			return residue
+		case <-ticker.C:
+		}
+	}
+}
+
+// WaitForStreams waits until the connection has no stream in flight or ctx is done, and returns whether the streams
+// finished. Without accounting, it waits for ctx.
+func (r *ConnResources) WaitForStreams(ctx context.Context) bool {
+	if r == nil {
+		<-ctx.Done()
+		return false
+	}
+	ticker := time.NewTicker(resourcesPollInterval)
+	defer ticker.Stop()
+	for r.streams.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-ticker.C:
+		}
+	}
+	return true
+}
+
+// ConnResidue is a snapshot of the resources held by a connection.
+type ConnResidue struct {
+	ConnIndex  uint8
+	Goroutines int64
+	Streams    int64
+	Sessions   int64
+}
+
+func (r ConnResidue) IsZero() bool {
+	return r.Goroutines == 0 && r.Streams == 0 && r.Sessions == 0
+}
+
+func (r ConnResidue) String() string {
+	return fmt.Sprintf("connection %d holds %d goroutines, %d streams and %d sessions", r.ConnIndex, r.Goroutines, r.Streams, r.Sessions)
+}
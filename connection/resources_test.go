@@ -0,0 +1,113 @@
+package connection
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfdquic "github.com/cloudflare/cloudflared/quic/v3"
+)
+
+// assertConnResourcesReleased asserts a closed connection releases every goroutine, stream and session it owned.
+func assertConnResourcesReleased(t *testing.T, resources *ConnResources) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+	defer cancel()
+	residue := resources.WaitForRelease(ctx)
+	assert.True(t, residue.IsZero(), residue.String())
+}
+
+func TestConnResources(t *testing.T) {
+	resources := NewConnResources(3)
+	assert.True(t, resources.Residue().IsZero())
+
+	streamDone := resources.StreamStarted()
+	sessionDone := resources.SessionStarted()
+	releaseC := make(chan struct{})
+	resources.Go(func() {
+		<-releaseC
+	})
+	assert.Equal(t, ConnResidue{ConnIndex: 3, Goroutines: 1, Streams: 1, Sessions: 1}, resources.Residue())
+
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	residue := resources.WaitForRelease(ctx)
+	assert.False(t, residue.IsZero())
+	assert.Equal(t, "connection 3 holds 1 goroutines, 1 streams and 1 sessions", residue.String())
+
+	streamDone()
+	// Releasing twice must not be counted twice.
+	streamDone()
+	sessionDone()
+	close(releaseC)
+	assertConnResourcesReleased(t, resources)
+}
+
+func TestNilConnResources(t *testing.T) {
+	var resources *ConnResources
+	ran := make(chan struct{})
+	resources.Go(func() {
+		close(ran)
+	})
+	<-ran
+	resources.StreamStarted()()
+	resources.SessionStarted()()
+	require.True(t, resources.Residue().IsZero())
+	require.True(t, resources.WaitForRelease(t.Context()).IsZero())
+}
+
+func TestConnResourcesWaitForStreams(t *testing.T) {
+	resources := NewConnResources(0)
+	assert.True(t, resources.WaitForStreams(t.Context()))
+
+	streamDone := resources.StreamStarted()
+	ctx, cancel := context.WithTimeout(t.Context(), 50*time.Millisecond)
+	defer cancel()
+	assert.False(t, resources.WaitForStreams(ctx))
+
+	time.AfterFunc(20*time.Millisecond, streamDone)
+	assert.True(t, resources.WaitForStreams(t.Context()))
+
+	var nilResources *ConnResources
+	assert.False(t, nilResources.WaitForStreams(ctx))
+}
+
+type stubV3SessionManager struct {
+	cfdquic.SessionManager
+	unregistered []cfdquic.RequestID
+}
+
+func (m *stubV3SessionManager) RegisterSession(*cfdquic.UDPSessionRegistrationDatagram, cfdquic.DatagramConn) (cfdquic.Session, error) {
+	return nil, nil
+}
+
+func (m *stubV3SessionManager) UnregisterSession(requestID cfdquic.RequestID) {
+	m.unregistered = append(m.unregistered, requestID)
+}
+
+func TestConnResourcesV3Sessions(t *testing.T) {
+	resources := NewConnResources(1)
+	inner := &stubV3SessionManager{}
+	manager := &accountedSessionManager{
+		SessionManager: inner,
+		resources:      resources,
+		sessionsDone:   make(map[cfdquic.RequestID]func()),
+	}
+	requestID, err := cfdquic.RequestIDFromSlice(make([]byte, 16))
+	require.NoError(t, err)
+	request := &cfdquic.UDPSessionRegistrationDatagram{RequestID: requestID}
+
+	_, err = manager.RegisterSession(request, nil)
+	require.NoError(t, err)
+	// A registration that is retried is still a single session
+	_, err = manager.RegisterSession(request, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), resources.Residue().Sessions)
+
+	manager.UnregisterSession(requestID)
+	assert.Equal(t, []cfdquic.RequestID{requestID}, inner.unregistered)
+	assertConnResourcesReleased(t, resources)
+}
@@ -0,0 +1,217 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// defaultShaperBurst is how much of its rate a class can burst when the burst isn't configured
+	defaultShaperBurst = 100 * time.Millisecond
+	// minShaperBurst lets a class with a low rate still write a stream frame at once
+	minShaperBurst = 16 * 1024
+	// maxDatagramDelay is the most a datagram waits for its class, datagrams are dropped rather than queued as the
+	// UDP and ICMP traffic they carry is better served by loss than by latency
+	maxDatagramDelay = 50 * time.Millisecond
+)
+
+// ShaperRate is the rate at which a TrafficClass can write to the edge, in bytes per second, and the most bytes it
+// can write at once.
+type ShaperRate struct {
+	BytesPerSecond float64
+	Burst          float64
+}
+
+// ParseShaperRate parses the rate of a class as CLASS=RATE[;burst=SIZE], where RATE is in bits per second and SIZE
+// in bytes, both with an optional k, M or G suffix, e.g. http=20M;burst=256k.
+func ParseShaperRate(spec string) (TrafficClass, ShaperRate, error) {
+	classRate, options, _ := strings.Cut(spec, ";")
+	name, rateSpec, ok := strings.Cut(classRate, "=")
+	if !ok {
+		return "", ShaperRate{}, fmt.Errorf("invalid egress shaping %q, expected CLASS=RATE[;burst=SIZE]", spec)
+	}
+	class := TrafficClass(strings.ToLower(strings.TrimSpace(name)))
+	switch class {
+	case TrafficControl, TrafficHTTP, TrafficTCP, TrafficUDP, TrafficICMP:
+	default:
+		return "", ShaperRate{}, fmt.Errorf("unknown traffic class %q, expected %s, %s, %s, %s or %s", name,
+			TrafficControl, TrafficHTTP, TrafficTCP, TrafficUDP, TrafficICMP)
+	}
+	bits, err := parseSIValue(rateSpec)
+	if err != nil || bits <= 0 {
+		return "", ShaperRate{}, fmt.Errorf("invalid rate %q of %s, expected bits per second such as 20M", rateSpec, class)
+	}
+	rate := ShaperRate{BytesPerSecond: bits / 8}
+	rate.Burst = max(rate.BytesPerSecond*defaultShaperBurst.Seconds(), minShaperBurst)
+	if options != "" {
+		burstSpec, ok := strings.CutPrefix(strings.TrimSpace(options), "burst=")
+		if !ok {
+			return "", ShaperRate{}, fmt.Errorf("invalid option %q of %s, expected burst=SIZE", options, class)
+		}
+		if rate.Burst, err = parseSIValue(burstSpec); err != nil || rate.Burst < 1 {
+			return "", ShaperRate{}, fmt.Errorf("invalid burst %q of %s, expected bytes such as 256k", burstSpec, class)
+		}
+	}
+	return class, rate, nil
+}
+
+// parseSIValue parses a number with an optional k, M or G suffix.
+func parseSIValue(s string) (float64, error) {
+	s = strings.TrimSpace(s)
+	multiplier := 1.0
+	if s != "" {
+		switch s[len(s)-1] {
+		case 'k', 'K':
+			multiplier = 1e3
+		case 'm', 'M':
+			multiplier = 1e6
+		case 'g', 'G':
+			multiplier = 1e9
+		}
+		if multiplier != 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	value, err := strconv.ParseFloat(s, 64)
+	return value * multiplier, err
+}
+
+// EgressShaper paces what the tunnel connections write to the edge with a token bucket per TrafficClass, so that
+// bulk transfers through the tunnel can't saturate a thin uplink and starve the control streams, whose missed
+// heartbeats would then tear down the connections. The buckets are shared by all the connections, which share the
+// uplink. Stream writes wait for their class to have the tokens, datagrams wait at most maxDatagramDelay and are
+// dropped otherwise. Classes without a rate, and everything when the EgressShaper is nil, aren't shaped.
+type EgressShaper struct {
+	buckets map[TrafficClass]*tokenBucket
+}
+
+// NewEgressShaper returns an EgressShaper shaping each class of rates.
+func NewEgressShaper(rates map[TrafficClass]ShaperRate) *EgressShaper {
+	if len(rates) == 0 {
+		return nil
+	}
+	s := &EgressShaper{buckets: make(map[TrafficClass]*tokenBucket, len(rates))}
+	for class, rate := range rates {
+		s.buckets[class] = newTokenBucket(rate, time.Now)
+	}
+	return s
+}
+
+// wait blocks until class can write n bytes, or ctx is done.
+func (s *EgressShaper) wait(ctx context.Context, class TrafficClass, n int) error {
+	bucket := s.bucket(class)
+	if bucket == nil || n <= 0 {
+		return nil
+	}
+	delay, _ := bucket.take(n, 0)
+	if delay <= 0 {
+		return nil
+	}
+	shaperWaitSeconds.WithLabelValues(string(class)).Add(delay.Seconds())
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// admitDatagram waits until class can send a datagram of n bytes, returning false if that would take longer than
+// maxDatagramDelay and the datagram should be dropped.
+func (s *EgressShaper) admitDatagram(class TrafficClass, n int) bool {
+	bucket := s.bucket(class)
+	if bucket == nil {
+		return true
+	}
+	delay, ok := bucket.take(n, maxDatagramDelay)
+	if !ok {
+		shaperDroppedDatagrams.WithLabelValues(string(class)).Inc()
+		return false
+	}
+	if delay > 0 {
+		shaperWaitSeconds.WithLabelValues(string(class)).Add(delay.Seconds())
+		time.Sleep(delay)
+	}
+	return true
+}
+
+func (s *EgressShaper) bucket(class TrafficClass) *tokenBucket {
+	if s == nil {
+		return nil
+	}
+	return s.buckets[class]
+}
+
+type tokenBucket struct {
+	rate  float64
+	burst float64
+	now   func() time.Time
+
+	lock     sync.Mutex
+	tokens   float64
+	refilled time.Time
+}
+
+func newTokenBucket(rate ShaperRate, now func() time.Time) *tokenBucket {
+	return &tokenBucket{
+		rate:     rate.BytesPerSecond,
+		burst:    rate.Burst,
+		now:      now,
+		tokens:   rate.Burst,
+		refilled: now(),
+	}
+}
+
+// take takes n tokens, returning how long to wait until they are available. The bucket goes into debt so that
+// writes larger than the burst still go through at the rate, and concurrent writers wait in turn. When maxDelay is
+// positive nothing is taken if the wait would be longer, and take returns false.
+func (b *tokenBucket) take(n int, maxDelay time.Duration) (time.Duration, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := b.now()
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.refilled).Seconds()*b.rate)
+	b.refilled = now
+
+	var delay time.Duration
+	if missing := float64(n) - b.tokens; missing > 0 {
+		delay = time.Duration(missing / b.rate * float64(time.Second))
+	}
+	if maxDelay > 0 && delay > maxDelay {
+		return 0, false
+	}
+	b.tokens -= float64(n)
+	return delay, true
+}
+
+var (
+	shaperWaitSeconds = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: TunnelSubsystem,
+			Name:      "egress_shaper_wait_seconds_total",
+			Help:      "Time writes to the edge waited for the egress shaper, by traffic class",
+		},
+		[]string{"class"},
+	)
+	shaperDroppedDatagrams = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: MetricsNamespace,
+			Subsystem: TunnelSubsystem,
+			Name:      "egress_shaper_dropped_datagrams_total",
+			Help:      "Datagrams to the edge dropped by the egress shaper, by traffic class",
+		},
+		[]string{"class"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(shaperWaitSeconds, shaperDroppedDatagrams)
+}
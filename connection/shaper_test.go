@@ -0,0 +1,115 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfdquic "github.com/cloudflare/cloudflared/quic"
+)
+
+func TestParseShaperRate(t *testing.T) {
+	tests := []struct {
+		spec     string
+		class    TrafficClass
+		expected ShaperRate
+		wantErr  bool
+	}{
+		{spec: "http=20M", class: TrafficHTTP, expected: ShaperRate{BytesPerSecond: 2.5e6, Burst: 2.5e5}},
+		{spec: "UDP=1.6k", class: TrafficUDP, expected: ShaperRate{BytesPerSecond: 200, Burst: minShaperBurst}},
+		{spec: "control=8M;burst=1M", class: TrafficControl, expected: ShaperRate{BytesPerSecond: 1e6, Burst: 1e6}},
+		{spec: "http", wantErr: true},
+		{spec: "ftp=1M", wantErr: true},
+		{spec: "tcp=0", wantErr: true},
+		{spec: "tcp=fast", wantErr: true},
+		{spec: "tcp=1M;size=1k", wantErr: true},
+		{spec: "tcp=1M;burst=0", wantErr: true},
+	}
+	for _, test := range tests {
+		class, rate, err := ParseShaperRate(test.spec)
+		if test.wantErr {
+			assert.Error(t, err, test.spec)
+			continue
+		}
+		require.NoError(t, err, test.spec)
+		assert.Equal(t, test.class, class, test.spec)
+		assert.Equal(t, test.expected, rate, test.spec)
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	bucket := newTokenBucket(ShaperRate{BytesPerSecond: 1000, Burst: 500}, func() time.Time { return now })
+
+	// The burst is available at once, then writes wait for the rate, going into debt
+	delay, ok := bucket.take(500, 0)
+	assert.True(t, ok)
+	assert.Zero(t, delay)
+	delay, _ = bucket.take(1000, 0)
+	assert.Equal(t, time.Second, delay)
+	delay, _ = bucket.take(100, 0)
+	assert.Equal(t, 1100*time.Millisecond, delay)
+
+	// Datagrams aren't admitted when they would wait too long, and take nothing
+	_, ok = bucket.take(10, maxDatagramDelay)
+	assert.False(t, ok)
+	now = now.Add(1100 * time.Millisecond)
+	delay, ok = bucket.take(10, maxDatagramDelay)
+	assert.True(t, ok)
+	assert.Equal(t, 10*time.Millisecond, delay)
+
+	// Refills stop at the burst
+	now = now.Add(time.Hour)
+	delay, _ = bucket.take(600, 0)
+	assert.Equal(t, 100*time.Millisecond, delay)
+}
+
+func TestEgressShaperShapesStreams(t *testing.T) {
+	log := zerolog.Nop()
+	observer := NewObserver(&log, &log)
+	observer.SetEgressShaper(NewEgressShaper(map[TrafficClass]ShaperRate{
+		TrafficTCP: {BytesPerSecond: 1000, Burst: 100},
+	}))
+	traffic := observer.ConnTraffic(202)
+
+	// Control streams aren't shaped
+	var written bytes.Buffer
+	control := traffic.stream(context.Background(), TrafficControl, nopReadWriteCloser{Writer: &written})
+	start := time.Now()
+	_, err := control.Write(make([]byte, 1000))
+	require.NoError(t, err)
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+
+	tcp := traffic.stream(context.Background(), TrafficTCP, nopReadWriteCloser{Writer: &written})
+	_, err = tcp.Write(make([]byte, 150))
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), 40*time.Millisecond)
+
+	// A write waiting for the shaper gives up with its stream
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = traffic.stream(ctx, TrafficTCP, nopReadWriteCloser{Writer: &written}).Write(make([]byte, 1000))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1150, written.Len())
+}
+
+func TestEgressShaperDropsDatagrams(t *testing.T) {
+	log := zerolog.Nop()
+	observer := NewObserver(&log, &log)
+	observer.SetEgressShaper(NewEgressShaper(map[TrafficClass]ShaperRate{
+		TrafficUDP: {BytesPerSecond: 100, Burst: 10},
+	}))
+	traffic := observer.ConnTraffic(203)
+	conn := traffic.datagrams(&datagramOnlyConn{}, classifyDatagramV2)
+
+	udp := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, byte(cfdquic.DatagramTypeUDP)}
+	require.NoError(t, conn.SendDatagram(udp))
+	require.NoError(t, conn.SendDatagram(udp))
+	assert.Equal(t, 1.0, trafficValue(t, traffic.metrics.trafficDatagrams, "203", "udp", trafficEgress))
+	assert.Equal(t, 1.0, trafficValue(t, shaperDroppedDatagrams, "udp"))
+}
@@ -0,0 +1,212 @@
+package connection
+
+import (
+	"context"
+	"io"
+
+	"github.com/quic-go/quic-go"
+
+	cfdquic "github.com/cloudflare/cloudflared/quic"
+	v3 "github.com/cloudflare/cloudflared/quic/v3"
+)
+
+// TrafficClass is the kind of traffic a tunnel connection carries.
+type TrafficClass string
+
+const (
+	// TrafficControl is the control streams the connections are registered and kept alive with
+	TrafficControl TrafficClass = "control"
+	// TrafficHTTP is the HTTP and websocket requests proxied over streams
+	TrafficHTTP TrafficClass = "http"
+	// TrafficTCP is the TCP flows of private networks proxied over streams
+	TrafficTCP TrafficClass = "tcp"
+	// TrafficUDP is the UDP sessions of private networks proxied over datagrams
+	TrafficUDP TrafficClass = "udp"
+	// TrafficICMP is the ICMP packets of private networks proxied over datagrams
+	TrafficICMP TrafficClass = "icmp"
+
+	// trafficIngress is the traffic from the edge to the origins, trafficEgress the traffic back to the edge
+	trafficIngress = "ingress"
+	trafficEgress  = "egress"
+)
+
+// ConnTraffic counts the bytes, streams and datagrams a tunnel connection carries by TrafficClass, to see what uses
+// the tunnel in deployments mixing public hostnames and private networks. Bytes are the payloads proxied, without
+// the framing of the protocol. What the connection writes to the edge goes through the EgressShaper of the
+// Observer. A nil ConnTraffic counts and shapes nothing.
+type ConnTraffic struct {
+	metrics   *tunnelMetrics
+	connIndex string
+	shaper    *EgressShaper
+}
+
+// ConnTraffic returns the ConnTraffic of the connection connIndex.
+func (o *Observer) ConnTraffic(connIndex uint8) *ConnTraffic {
+	if o == nil {
+		return nil
+	}
+	return &ConnTraffic{
+		metrics:   o.metrics,
+		connIndex: uint8ToString(connIndex),
+		shaper:    o.egressShaper,
+	}
+}
+
+// SetEgressShaper shapes what the connections write to the edge with shaper, it applies to the ConnTraffic
+// created afterwards.
+func (o *Observer) SetEgressShaper(shaper *EgressShaper) {
+	o.egressShaper = shaper
+}
+
+// shape waits until the EgressShaper lets class write n bytes to the edge.
+func (t *ConnTraffic) shape(ctx context.Context, class TrafficClass, n int) error {
+	if t == nil {
+		return nil
+	}
+	return t.shaper.wait(ctx, class, n)
+}
+
+func (t *ConnTraffic) streamStarted(class TrafficClass) {
+	if t == nil {
+		return
+	}
+	t.metrics.trafficStreams.WithLabelValues(t.connIndex, string(class)).Inc()
+}
+
+func (t *ConnTraffic) addBytes(class TrafficClass, direction string, n int) {
+	if t == nil || n <= 0 {
+		return
+	}
+	t.metrics.trafficBytes.WithLabelValues(t.connIndex, string(class), direction).Add(float64(n))
+}
+
+func (t *ConnTraffic) addDatagram(class TrafficClass, direction string, n int) {
+	if t == nil {
+		return
+	}
+	t.metrics.trafficDatagrams.WithLabelValues(t.connIndex, string(class), direction).Inc()
+	t.addBytes(class, direction, n)
+}
+
+// stream counts a stream of class and the bytes read from and written to it, and shapes its writes until ctx is
+// done.
+func (t *ConnTraffic) stream(ctx context.Context, class TrafficClass, rwc io.ReadWriteCloser) io.ReadWriteCloser {
+	if t == nil {
+		return rwc
+	}
+	t.streamStarted(class)
+	return &trafficStream{ReadWriteCloser: rwc, ctx: ctx, traffic: t, class: class}
+}
+
+// body counts the bytes read from the body of an HTTP/2 request of class.
+func (t *ConnTraffic) body(class TrafficClass, body io.ReadCloser) io.ReadCloser {
+	if t == nil || body == nil {
+		return body
+	}
+	return &trafficBody{ReadCloser: body, traffic: t, class: class}
+}
+
+// datagrams counts the datagrams sent and received on conn, classified by classify, and shapes the datagrams sent.
+func (t *ConnTraffic) datagrams(conn quic.Connection, classify func(datagram []byte) (TrafficClass, bool)) quic.Connection {
+	if t == nil {
+		return conn
+	}
+	return &trafficDatagramConn{Connection: conn, traffic: t, classify: classify}
+}
+
+type trafficStream struct {
+	io.ReadWriteCloser
+	ctx     context.Context
+	traffic *ConnTraffic
+	class   TrafficClass
+}
+
+func (s *trafficStream) Read(p []byte) (int, error) {
+	n, err := s.ReadWriteCloser.Read(p)
+	s.traffic.addBytes(s.class, trafficIngress, n)
+	return n, err
+}
+
+func (s *trafficStream) Write(p []byte) (int, error) {
+	if err := s.traffic.shape(s.ctx, s.class, len(p)); err != nil {
+		return 0, err
+	}
+	n, err := s.ReadWriteCloser.Write(p)
+	s.traffic.addBytes(s.class, trafficEgress, n)
+	return n, err
+}
+
+type trafficBody struct {
+	io.ReadCloser
+	traffic *ConnTraffic
+	class   TrafficClass
+}
+
+func (b *trafficBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.traffic.addBytes(b.class, trafficIngress, n)
+	return n, err
+}
+
+type trafficDatagramConn struct {
+	quic.Connection
+	traffic  *ConnTraffic
+	classify func(datagram []byte) (TrafficClass, bool)
+}
+
+func (c *trafficDatagramConn) SendDatagram(payload []byte) error {
+	class, ok := c.classify(payload)
+	// Dropped datagrams are lost as if on the way to the edge
+	if ok && !c.traffic.shaper.admitDatagram(class, len(payload)) {
+		return nil
+	}
+	if err := c.Connection.SendDatagram(payload); err != nil {
+		return err
+	}
+	if ok {
+		c.traffic.addDatagram(class, trafficEgress, len(payload))
+	}
+	return nil
+}
+
+func (c *trafficDatagramConn) ReceiveDatagram(ctx context.Context) ([]byte, error) {
+	payload, err := c.Connection.ReceiveDatagram(ctx)
+	if err == nil {
+		if class, ok := c.classify(payload); ok {
+			c.traffic.addDatagram(class, trafficIngress, len(payload))
+		}
+	}
+	return payload, err
+}
+
+// classifyDatagramV2 returns the class of a datagram v2 from its type suffix. IP packets are only ICMP.
+func classifyDatagramV2(datagram []byte) (TrafficClass, bool) {
+	if len(datagram) == 0 {
+		return "", false
+	}
+	switch cfdquic.DatagramV2Type(datagram[len(datagram)-1]) {
+	case cfdquic.DatagramTypeUDP:
+		return TrafficUDP, true
+	case cfdquic.DatagramTypeIP, cfdquic.DatagramTypeIPWithTrace:
+		return TrafficICMP, true
+	default:
+		return "", false
+	}
+}
+
+// classifyDatagramV3 returns the class of a datagram v3 from its type prefix, the session registrations aren't
+// counted.
+func classifyDatagramV3(datagram []byte) (TrafficClass, bool) {
+	datagramType, err := v3.ParseDatagramType(datagram)
+	if err != nil {
+		return "", false
+	}
+	switch datagramType {
+	case v3.UDPSessionPayloadType:
+		return TrafficUDP, true
+	case v3.ICMPType:
+		return TrafficICMP, true
+	default:
+		return "", false
+	}
+}
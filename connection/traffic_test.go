@@ -0,0 +1,112 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/quic-go/quic-go"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	cfdquic "github.com/cloudflare/cloudflared/quic"
+	v3 "github.com/cloudflare/cloudflared/quic/v3"
+)
+
+func trafficValue(t *testing.T, vec *prometheus.CounterVec, labels ...string) float64 {
+	t.Helper()
+	var m dto.Metric
+	require.NoError(t, vec.WithLabelValues(labels...).Write(&m))
+	return m.GetCounter().GetValue()
+}
+
+type nopReadWriteCloser struct {
+	io.Reader
+	io.Writer
+}
+
+func (nopReadWriteCloser) Close() error { return nil }
+
+func TestConnTrafficStream(t *testing.T) {
+	log := zerolog.Nop()
+	traffic := NewObserver(&log, &log).ConnTraffic(200)
+
+	var written bytes.Buffer
+	stream := traffic.stream(context.Background(), TrafficTCP, nopReadWriteCloser{Reader: bytes.NewBufferString("request"), Writer: &written})
+	_, err := io.ReadAll(stream)
+	require.NoError(t, err)
+	_, err = stream.Write([]byte("response body"))
+	require.NoError(t, err)
+
+	assert.Equal(t, 1.0, trafficValue(t, traffic.metrics.trafficStreams, "200", "tcp"))
+	assert.Equal(t, 7.0, trafficValue(t, traffic.metrics.trafficBytes, "200", "tcp", trafficIngress))
+	assert.Equal(t, 13.0, trafficValue(t, traffic.metrics.trafficBytes, "200", "tcp", trafficEgress))
+
+	body := traffic.body(TrafficHTTP, io.NopCloser(bytes.NewBufferString("body")))
+	_, err = io.ReadAll(body)
+	require.NoError(t, err)
+	assert.Equal(t, 4.0, trafficValue(t, traffic.metrics.trafficBytes, "200", "http", trafficIngress))
+}
+
+func TestConnTrafficNil(t *testing.T) {
+	var observer *Observer
+	traffic := observer.ConnTraffic(0)
+	assert.Nil(t, traffic)
+	rwc := nopReadWriteCloser{}
+	assert.Equal(t, rwc, traffic.stream(context.Background(), TrafficHTTP, rwc))
+	traffic.addBytes(TrafficHTTP, trafficEgress, 10)
+}
+
+type datagramOnlyConn struct {
+	quic.Connection
+	received [][]byte
+}
+
+func (c *datagramOnlyConn) SendDatagram([]byte) error {
+	return nil
+}
+
+func (c *datagramOnlyConn) ReceiveDatagram(context.Context) ([]byte, error) {
+	datagram := c.received[0]
+	c.received = c.received[1:]
+	return datagram, nil
+}
+
+func TestConnTrafficDatagrams(t *testing.T) {
+	log := zerolog.Nop()
+	traffic := NewObserver(&log, &log).ConnTraffic(201)
+
+	udp := []byte{1, 2, 3, byte(cfdquic.DatagramTypeUDP)}
+	icmp := []byte{1, 2, byte(cfdquic.DatagramTypeIP)}
+	span := []byte{1, byte(cfdquic.DatagramTypeTracingSpan)}
+	conn := traffic.datagrams(&datagramOnlyConn{received: [][]byte{udp, icmp, span}}, classifyDatagramV2)
+	for i := 0; i < 3; i++ {
+		_, err := conn.ReceiveDatagram(context.Background())
+		require.NoError(t, err)
+	}
+	require.NoError(t, conn.SendDatagram(udp))
+
+	assert.Equal(t, 1.0, trafficValue(t, traffic.metrics.trafficDatagrams, "201", "udp", trafficIngress))
+	assert.Equal(t, 1.0, trafficValue(t, traffic.metrics.trafficDatagrams, "201", "udp", trafficEgress))
+	assert.Equal(t, 1.0, trafficValue(t, traffic.metrics.trafficDatagrams, "201", "icmp", trafficIngress))
+	assert.Equal(t, 4.0, trafficValue(t, traffic.metrics.trafficBytes, "201", "udp", trafficIngress))
+	assert.Equal(t, 3.0, trafficValue(t, traffic.metrics.trafficBytes, "201", "icmp", trafficIngress))
+}
+
+func TestClassifyDatagramV3(t *testing.T) {
+	for datagram, expected := range map[byte]TrafficClass{
+		byte(v3.UDPSessionPayloadType):      TrafficUDP,
+		byte(v3.ICMPType):                   TrafficICMP,
+		byte(v3.UDPSessionRegistrationType): "",
+	} {
+		class, ok := classifyDatagramV3([]byte{datagram, 0})
+		assert.Equal(t, expected, class)
+		assert.Equal(t, expected != "", ok)
+	}
+	_, ok := classifyDatagramV3(nil)
+	assert.False(t, ok)
+}
@@ -14,6 +14,8 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/redact"
 )
 
 // CreateDiagnosticZipFile create a zip file with the contents from the all
@@ -65,7 +67,13 @@ func CreateDiagnosticZipFile(base string, paths []string) (zipFileName string, e
 			return "", fmt.Errorf("error creating archive writer from %s: %w", file, err)
 		}
 
-		if _, err = io.Copy(writer, handle); err != nil {
+		// Profiles are binary, everything else is text that may have secrets, e.g. in logs
+		if filepath.Ext(file) == ".pprof" {
+			_, err = io.Copy(writer, handle)
+		} else {
+			_, err = redact.Copy(writer, handle)
+		}
+		if err != nil {
 			return "", fmt.Errorf("error copying file %s: %w", file, err)
 		}
 	}
@@ -11,7 +11,11 @@ import (
 	"github.com/google/uuid"
 	"github.com/rs/zerolog"
 
+	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/supervisor"
+	"github.com/cloudflare/cloudflared/tlsconfig"
 	"github.com/cloudflare/cloudflared/tunnelstate"
+	"github.com/cloudflare/cloudflared/usage"
 )
 
 type Handler struct {
@@ -23,6 +27,8 @@ type Handler struct {
 	tracker         *tunnelstate.ConnTracker
 	cliFlags        map[string]string
 	icmpSources     []string
+	icmpProxy       *ICMPProxyStatus
+	usage           *usage.Accounting
 }
 
 func NewDiagnosticHandler(
@@ -34,6 +40,8 @@ func NewDiagnosticHandler(
 	tracker *tunnelstate.ConnTracker,
 	cliFlags map[string]string,
 	icmpSources []string,
+	icmpProxy *ICMPProxyStatus,
+	usageAccounting *usage.Accounting,
 ) *Handler {
 	logger := log.With().Logger()
 	if timeout == 0 {
@@ -50,6 +58,8 @@ func NewDiagnosticHandler(
 		tracker:         tracker,
 		cliFlags:        cliFlags,
 		icmpSources:     icmpSources,
+		icmpProxy:       icmpProxy,
+		usage:           usageAccounting,
 	}
 }
 
@@ -94,6 +104,23 @@ type TunnelState struct {
 	ConnectorID uuid.UUID                           `json:"connectorID,omitempty"`
 	Connections []tunnelstate.IndexedConnectionInfo `json:"connections,omitempty"`
 	ICMPSources []string                            `json:"icmp_sources,omitempty"`
+	ICMPProxy   *ICMPProxyStatus                    `json:"icmp_proxy,omitempty"`
+	// ConnectionHistory are the latest events of each connection index with the edge IP and protocol they happened on
+	ConnectionHistory []tunnelstate.ConnectionHistory `json:"connection_history,omitempty"`
+	// OriginCAPools are the caPool directories of the ingress rules, with the error of their last reload if it failed
+	OriginCAPools []tlsconfig.OriginCAPoolStatus `json:"origin_ca_pools,omitempty"`
+	// Usage are the bytes proxied per ingress hostname and private network, including previous runs
+	Usage *usage.Report `json:"usage,omitempty"`
+	// Helpers are the helper servers started by the ingress rules, restarted whenever they crash
+	Helpers []ingress.HelperStatus `json:"helpers,omitempty"`
+	// Subsystems are the DNS refresh loop and the ICMP server, restarted whenever they stop
+	Subsystems []supervisor.SubsystemStatus `json:"subsystems,omitempty"`
+}
+
+// ICMPProxyStatus tells whether the ICMP proxy is enabled, degraded or disabled, and why it is not enabled.
+type ICMPProxyStatus struct {
+	State  string `json:"state"`
+	Reason string `json:"reason,omitempty"`
 }
 
 func (handler *Handler) TunnelStateHandler(writer http.ResponseWriter, _ *http.Request) {
@@ -107,6 +134,12 @@ func (handler *Handler) TunnelStateHandler(writer http.ResponseWriter, _ *http.R
 		handler.connectorID,
 		handler.tracker.GetActiveConnections(),
 		handler.icmpSources,
+		handler.icmpProxy,
+		handler.tracker.History(),
+		tlsconfig.OriginCAPoolStatuses(),
+		handler.usage.Report(),
+		ingress.HelperStatuses(),
+		supervisor.SubsystemStatuses(),
 	}
 	encoder := json.NewEncoder(writer)
 
@@ -86,7 +86,7 @@ func TestSystemHandler(t *testing.T) {
 			handler := diagnostic.NewDiagnosticHandler(&log, 0, &SystemCollectorMock{
 				systemInfo: tCase.systemInfo,
 				err:        tCase.err,
-			}, uuid.New(), uuid.New(), nil, map[string]string{}, nil)
+			}, uuid.New(), uuid.New(), nil, map[string]string{}, nil, nil, nil)
 			recorder := httptest.NewRecorder()
 			ctx := context.Background()
 			request, err := http.NewRequestWithContext(ctx, http.MethodGet, "/diag/system", nil)
@@ -115,6 +115,7 @@ func TestTunnelStateHandler(t *testing.T) {
 		clientID    uuid.UUID
 		connections []tunnelstate.IndexedConnectionInfo
 		icmpSources []string
+		icmpProxy   *diagnostic.ICMPProxyStatus
 	}{
 		{
 			name:     "case1",
@@ -126,6 +127,7 @@ func TestTunnelStateHandler(t *testing.T) {
 			tunnelID:    uuid.New(),
 			clientID:    uuid.New(),
 			icmpSources: []string{"172.17.0.3", "::1"},
+			icmpProxy:   &diagnostic.ICMPProxyStatus{State: "degraded", Reason: "only ICMPv4 is proxied"},
 			connections: []tunnelstate.IndexedConnectionInfo{{
 				ConnectionInfo: tunnelstate.ConnectionInfo{
 					IsConnected: true,
@@ -150,6 +152,8 @@ func TestTunnelStateHandler(t *testing.T) {
 				tracker,
 				map[string]string{},
 				tCase.icmpSources,
+				tCase.icmpProxy,
+				nil,
 			)
 			recorder := httptest.NewRecorder()
 			handler.TunnelStateHandler(recorder, nil)
@@ -163,6 +167,8 @@ func TestTunnelStateHandler(t *testing.T) {
 			assert.Equal(t, tCase.clientID, response.ConnectorID)
 			assert.Equal(t, tCase.connections, response.Connections)
 			assert.Equal(t, tCase.icmpSources, response.ICMPSources)
+			assert.Equal(t, tCase.icmpProxy, response.ICMPProxy)
+			assert.Len(t, response.ConnectionHistory, len(tCase.connections))
 		})
 	}
 }
@@ -207,7 +213,7 @@ func TestConfigurationHandler(t *testing.T) {
 
 			var response map[string]string
 
-			handler := diagnostic.NewDiagnosticHandler(&log, 0, nil, uuid.New(), uuid.New(), nil, tCase.flags, nil)
+			handler := diagnostic.NewDiagnosticHandler(&log, 0, nil, uuid.New(), uuid.New(), nil, tCase.flags, nil, nil, nil)
 			recorder := httptest.NewRecorder()
 			handler.ConfigurationHandler(recorder, nil)
 			decoder := json.NewDecoder(recorder.Body)
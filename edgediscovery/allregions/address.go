@@ -1,5 +1,12 @@
 package allregions
 
+import (
+	"math/rand"
+	"net"
+	"slices"
+	"strings"
+)
+
 // Region contains cloudflared edge addresses. The edge is partitioned into several regions for
 // redundancy purposes.
 type AddrSet map[*EdgeAddr]UsedBy
@@ -37,6 +44,67 @@ func (a AddrSet) GetUnusedIP(excluding *EdgeAddr) *EdgeAddr {
 	return nil
 }
 
+// GetBestUnusedIP returns the unused address with the highest score in this region, excluding the given address.
+// Addresses with the same score are picked randomly.
+// Returns nil if all addresses are in use.
+func (a AddrSet) GetBestUnusedIP(excluding *EdgeAddr, scorer Scorer) *EdgeAddr {
+	var best *EdgeAddr
+	var bestScore float64
+	for addr, usedby := range a {
+		if usedby.Used || addr == excluding {
+			continue
+		}
+		if score := scorer.Score(addr); best == nil || score > bestScore {
+			best = addr
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// PickUnusedIP returns an unused address in this region picked with r, excluding the given address. Unlike
+// GetUnusedIP the address only depends on r, so the same addresses are picked again from the same seed. If scorer
+// isn't nil, it is picked among the unused addresses with the highest score.
+// Returns nil if all addresses are in use.
+func (a AddrSet) PickUnusedIP(excluding *EdgeAddr, scorer Scorer, r *rand.Rand) *EdgeAddr {
+	var candidates []*EdgeAddr
+	var bestScore float64
+	for addr, usedby := range a {
+		if usedby.Used || addr == excluding {
+			continue
+		}
+		if scorer != nil {
+			score := scorer.Score(addr)
+			if len(candidates) > 0 && score < bestScore {
+				continue
+			}
+			if len(candidates) == 0 || score > bestScore {
+				candidates = candidates[:0]
+				bestScore = score
+			}
+		}
+		candidates = append(candidates, addr)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	slices.SortFunc(candidates, func(a, b *EdgeAddr) int {
+		return strings.Compare(a.UDP.String(), b.UDP.String())
+	})
+	return candidates[r.Intn(len(candidates))]
+}
+
+// GetUnusedAddrWithIP returns the unused address with the given IP in this region.
+// Returns nil if there is no such address or it is in use.
+func (a AddrSet) GetUnusedAddrWithIP(ip net.IP) *EdgeAddr {
+	for addr, usedby := range a {
+		if !usedby.Used && addr.UDP.IP.Equal(ip) {
+			return addr
+		}
+	}
+	return nil
+}
+
 // Use the address, assigning it to a proxy connection.
 func (a AddrSet) Use(addr *EdgeAddr, connID int) {
 	if addr == nil {
@@ -1,6 +1,7 @@
 package allregions
 
 import (
+	"math/rand"
 	"reflect"
 	"testing"
 )
@@ -169,6 +170,73 @@ func TestAddrSet_GetUnusedIP(t *testing.T) {
 	}
 }
 
+type mapScorer map[*EdgeAddr]float64
+
+func (m mapScorer) Score(addr *EdgeAddr) float64 {
+	return m[addr]
+}
+
+func TestAddrSet_GetBestUnusedIP(t *testing.T) {
+	scorer := mapScorer{&addr0: 0.9, &addr1: 0.5, &addr2: 0.1}
+	type args struct {
+		excluding *EdgeAddr
+	}
+	tests := []struct {
+		name    string
+		addrSet AddrSet
+		args    args
+		want    *EdgeAddr
+	}{
+		{
+			name: "happy test picks best",
+			addrSet: AddrSet{
+				&addr0: Unused(),
+				&addr1: Unused(),
+				&addr2: Unused(),
+			},
+			args: args{excluding: nil},
+			want: &addr0,
+		},
+		{
+			name: "happy test skips used",
+			addrSet: AddrSet{
+				&addr0: InUse(0),
+				&addr1: Unused(),
+				&addr2: Unused(),
+			},
+			args: args{excluding: nil},
+			want: &addr1,
+		},
+		{
+			name: "happy test skips excluding",
+			addrSet: AddrSet{
+				&addr0: Unused(),
+				&addr1: InUse(1),
+				&addr2: Unused(),
+			},
+			args: args{excluding: &addr0},
+			want: &addr2,
+		},
+		{
+			name: "sad test",
+			addrSet: AddrSet{
+				&addr0: Unused(),
+				&addr1: InUse(1),
+				&addr2: InUse(2),
+			},
+			args: args{excluding: &addr0},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.addrSet.GetBestUnusedIP(tt.args.excluding, scorer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Region.GetBestUnusedIP() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestAddrSet_GiveBack(t *testing.T) {
 	type args struct {
 		addr *EdgeAddr
@@ -245,3 +313,40 @@ func TestAddrSet_GetAnyAddress(t *testing.T) {
 		})
 	}
 }
+
+func TestAddrSet_PickUnusedIP(t *testing.T) {
+	addrSet := AddrSet{
+		&addr0: Unused(),
+		&addr1: InUse(1),
+		&addr2: Unused(),
+		&addr3: Unused(),
+	}
+	picks := func(seed int64, scorer Scorer) []*EdgeAddr {
+		r := rand.New(rand.NewSource(seed))
+		var addrs []*EdgeAddr
+		for i := 0; i < 10; i++ {
+			addrs = append(addrs, addrSet.PickUnusedIP(&addr3, scorer, r))
+		}
+		return addrs
+	}
+	for seed := int64(0); seed < 10; seed++ {
+		got := picks(seed, nil)
+		if !reflect.DeepEqual(got, picks(seed, nil)) {
+			t.Errorf("AddrSet.PickUnusedIP() picked different addresses from seed %d", seed)
+		}
+		for _, addr := range got {
+			if addr != &addr0 && addr != &addr2 {
+				t.Errorf("AddrSet.PickUnusedIP() = %v, want an unused address other than the excluded one", addr)
+			}
+		}
+	}
+	scorer := mapScorer{&addr0: 0.1, &addr2: 0.5, &addr3: 0.9}
+	for _, addr := range picks(0, scorer) {
+		if addr != &addr2 {
+			t.Errorf("AddrSet.PickUnusedIP() = %v, want the best scoring address %v", addr, &addr2)
+		}
+	}
+	if got := (AddrSet{&addr0: InUse(0)}).PickUnusedIP(nil, nil, rand.New(rand.NewSource(0))); got != nil {
+		t.Errorf("AddrSet.PickUnusedIP() = %v, want nil when all addresses are in use", got)
+	}
+}
@@ -56,6 +56,32 @@ func (c ConfigIPVersion) String() string {
 	}
 }
 
+// RotationPolicy is the selection of which region a connection's new address comes from when its address is
+// rotated after a failure
+type RotationPolicy int8
+
+const (
+	// RotateBalanced takes the new address from the region with the most unused addresses
+	RotateBalanced RotationPolicy = iota
+	// RotateSameRegion prefers the region of the old address, preserving latency
+	RotateSameRegion
+	// RotateOtherRegion prefers the other region than the old address's, maximizing availability
+	RotateOtherRegion
+)
+
+func (p RotationPolicy) String() string {
+	switch p {
+	case RotateBalanced:
+		return "balanced"
+	case RotateSameRegion:
+		return "same-region"
+	case RotateOtherRegion:
+		return "other-region"
+	default:
+		return ""
+	}
+}
+
 // IPVersion is the IP version of an EdgeAddr
 type EdgeIPVersion int8
 
@@ -108,14 +134,18 @@ var friendlyDNSErrorLines = []string{
 	`     https://developers.cloudflare.com/1.1.1.1/setting-up-1.1.1.1/`,
 }
 
-// EdgeDiscovery implements HA service discovery lookup.
-func edgeDiscovery(log *zerolog.Logger, srvService string) ([][]*EdgeAddr, error) {
+// EdgeDiscovery implements HA service discovery lookup. The lookups are answered by hosts instead of DNS if it isn't nil.
+func edgeDiscovery(log *zerolog.Logger, srvService string, hosts *EdgeHosts) ([][]*EdgeAddr, error) {
 	logger := log.With().Int(management.EventTypeKey, int(management.Cloudflared)).Logger()
 	logger.Debug().
 		Int(management.EventTypeKey, int(management.Cloudflared)).
 		Str("domain", "_"+srvService+"._"+srvProto+"."+srvName).
 		Msg("edge discovery: looking up edge SRV record")
 
+	if hosts != nil {
+		return resolveEdgeHosts(&logger, srvService, hosts)
+	}
+
 	_, addrs, err := netLookupSRV(srvService, srvProto, srvName)
 	if err != nil {
 		_, fallbackAddrs, fallbackErr := fallbackLookupSRV(srvService, srvProto, srvName)
@@ -131,9 +161,24 @@ func edgeDiscovery(log *zerolog.Logger, srvService string) ([][]*EdgeAddr, error
 		addrs = fallbackAddrs
 	}
 
+	return resolveSRVs(&logger, addrs, netLookupIP)
+}
+
+// resolveEdgeHosts looks up the edge SRV record and the addresses it points to in the edge hosts file, without falling
+// back to DNS.
+func resolveEdgeHosts(logger *zerolog.Logger, srvService string, hosts *EdgeHosts) ([][]*EdgeAddr, error) {
+	_, addrs, err := hosts.LookupSRV(srvService, srvProto, srvName)
+	if err != nil {
+		logger.Err(err).Msg("edge discovery: error looking up Cloudflare edge IPs in the edge hosts file")
+		return nil, err
+	}
+	return resolveSRVs(logger, addrs, hosts.LookupIP)
+}
+
+func resolveSRVs(logger *zerolog.Logger, addrs []*net.SRV, lookupIP func(string) ([]net.IP, error)) ([][]*EdgeAddr, error) {
 	var resolvedAddrPerCNAME [][]*EdgeAddr
 	for _, addr := range addrs {
-		edgeAddrs, err := resolveSRV(addr)
+		edgeAddrs, err := resolveSRV(addr, lookupIP)
 		if err != nil {
 			return nil, err
 		}
@@ -169,8 +214,8 @@ func lookupSRVWithDOT(srvService string, srvProto string, srvName string) (cname
 	return r.LookupSRV(ctx, srvService, srvProto, srvName)
 }
 
-func resolveSRV(srv *net.SRV) ([]*EdgeAddr, error) {
-	ips, err := netLookupIP(srv.Target)
+func resolveSRV(srv *net.SRV, lookupIP func(string) ([]net.IP, error)) ([]*EdgeAddr, error) {
+	ips, err := lookupIP(srv.Target)
 	if err != nil {
 		return nil, errors.Wrapf(err, "Couldn't resolve SRV record %v", srv)
 	}
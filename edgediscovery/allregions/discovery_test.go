@@ -25,7 +25,7 @@ func TestEdgeDiscovery(t *testing.T) {
 	}
 
 	l := zerolog.Nop()
-	addrLists, err := edgeDiscovery(&l, "")
+	addrLists, err := edgeDiscovery(&l, "", nil)
 	assert.NoError(t, err)
 	actualAddrSet := map[string]bool{}
 	for _, addrs := range addrLists {
@@ -40,7 +40,7 @@ func TestEdgeDiscovery(t *testing.T) {
 func TestRealEdgeDiscovery(t *testing.T) {
 	l := zerolog.Nop()
 	// 不设置 mock，使用真实的 DNS 查询
-	addrLists, err := edgeDiscovery(&l, "v2-origintunneld")
+	addrLists, err := edgeDiscovery(&l, "v2-origintunneld", nil)
 	assert.NoError(t, err)
 
 	// 打印真实的边缘 IP
@@ -0,0 +1,127 @@
+package allregions
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	yaml "gopkg.in/yaml.v3"
+)
+
+// EdgeHosts maps the SRV and hostname lookups of edge discovery to static responses, like a hosts file for the edge.
+// It lets labs without public DNS run discovery and failover against internal mock edges. Names are matched without
+// the trailing dot and regardless of case.
+//
+// Example:
+//
+//	srv:
+//	  _v2-origintunneld._tcp.argotunnel.com:
+//	    - target: region1.mock-edge.lab
+//	      port: 7844
+//	    - target: region2.mock-edge.lab
+//	      port: 7844
+//	hosts:
+//	  region1.mock-edge.lab: [10.0.1.1, 10.0.1.2]
+//	  region2.mock-edge.lab: [10.0.2.1, 10.0.2.2]
+type EdgeHosts struct {
+	SRV   map[string][]EdgeHostsSRV `yaml:"srv"`
+	Hosts map[string][]string       `yaml:"hosts"`
+}
+
+// EdgeHostsSRV is a static SRV record of EdgeHosts.
+type EdgeHostsSRV struct {
+	Target   string `yaml:"target"`
+	Port     uint16 `yaml:"port"`
+	Priority uint16 `yaml:"priority"`
+	Weight   uint16 `yaml:"weight"`
+}
+
+// LoadEdgeHosts reads the EdgeHosts of the YAML file at path, validating that every SRV target resolves.
+func LoadEdgeHosts(path string) (*EdgeHosts, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read the edge hosts file %s: %w", path, err)
+	}
+	var hosts EdgeHosts
+	if err := yaml.Unmarshal(content, &hosts); err != nil {
+		return nil, fmt.Errorf("invalid edge hosts file %s: %w", path, err)
+	}
+	if err := hosts.normalize(); err != nil {
+		return nil, fmt.Errorf("invalid edge hosts file %s: %w", path, err)
+	}
+	return &hosts, nil
+}
+
+func (h *EdgeHosts) normalize() error {
+	if len(h.SRV) == 0 {
+		return fmt.Errorf("no SRV records")
+	}
+	srv := make(map[string][]EdgeHostsSRV, len(h.SRV))
+	for name, records := range h.SRV {
+		if len(records) == 0 {
+			return fmt.Errorf("SRV %s has no records", name)
+		}
+		srv[normalizeHostName(name)] = records
+	}
+	hosts := make(map[string][]string, len(h.Hosts))
+	for name, ips := range h.Hosts {
+		for _, ip := range ips {
+			if net.ParseIP(ip) == nil {
+				return fmt.Errorf("host %s has an invalid IP %q", name, ip)
+			}
+		}
+		hosts[normalizeHostName(name)] = ips
+	}
+	h.SRV, h.Hosts = srv, hosts
+	for name, records := range h.SRV {
+		for _, record := range records {
+			if record.Port == 0 {
+				return fmt.Errorf("SRV %s has no port for %s", name, record.Target)
+			}
+			if _, err := h.LookupIP(record.Target); err != nil {
+				return fmt.Errorf("SRV %s: %w", name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// LookupSRV returns the static SRV records of _service._proto.name, with the same signature as net.LookupSRV.
+func (h *EdgeHosts) LookupSRV(service, proto, name string) (string, []*net.SRV, error) {
+	domain := "_" + service + "._" + proto + "." + name
+	records, ok := h.SRV[normalizeHostName(domain)]
+	if !ok {
+		return "", nil, fmt.Errorf("no SRV records for %s in the edge hosts file", domain)
+	}
+	addrs := make([]*net.SRV, len(records))
+	for i, record := range records {
+		addrs[i] = &net.SRV{
+			Target:   record.Target,
+			Port:     record.Port,
+			Priority: record.Priority,
+			Weight:   record.Weight,
+		}
+	}
+	return domain, addrs, nil
+}
+
+// LookupIP returns the static IPs of host, or host itself if it's an IP, with the same signature as net.LookupIP.
+func (h *EdgeHosts) LookupIP(host string) ([]net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return []net.IP{ip}, nil
+	}
+	addrs, ok := h.Hosts[normalizeHostName(host)]
+	if !ok || len(addrs) == 0 {
+		return nil, fmt.Errorf("no IPs for %s in the edge hosts file", host)
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = net.ParseIP(addr)
+	}
+	return ips, nil
+}
+
+func normalizeHostName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,63 @@
+package allregions
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeEdgeHosts(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "edge-hosts.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
+
+func TestEdgeHostsDiscovery(t *testing.T) {
+	path := writeEdgeHosts(t, `
+srv:
+  _v2-origintunneld._tcp.argotunnel.com.:
+    - target: Region1.mock-edge.lab
+      port: 7844
+    - target: 10.0.2.1
+      port: 7845
+hosts:
+  region1.mock-edge.lab: [10.0.1.1, "fd00::1"]
+`)
+	hosts, err := LoadEdgeHosts(path)
+	require.NoError(t, err)
+
+	l := zerolog.Nop()
+	addrLists, err := edgeDiscovery(&l, srvService, hosts)
+	require.NoError(t, err)
+	require.Len(t, addrLists, 2)
+	require.Len(t, addrLists[0], 2)
+	assert.Equal(t, "10.0.1.1:7844", addrLists[0][0].TCP.String())
+	assert.Equal(t, V4, addrLists[0][0].IPVersion)
+	assert.Equal(t, "[fd00::1]:7844", addrLists[0][1].UDP.String())
+	assert.Equal(t, V6, addrLists[0][1].IPVersion)
+	assert.Equal(t, "10.0.2.1:7845", addrLists[1][0].TCP.String())
+
+	// Other regions aren't resolved from DNS
+	_, err = edgeDiscovery(&l, getRegionalServiceName("us"), hosts)
+	assert.Error(t, err)
+}
+
+func TestLoadEdgeHostsInvalid(t *testing.T) {
+	for name, content := range map[string]string{
+		"no SRV":            `hosts: {a.lab: [10.0.0.1]}`,
+		"no port":           "srv:\n  _v2-origintunneld._tcp.argotunnel.com: [{target: 10.0.0.1}]",
+		"unresolved target": "srv:\n  _v2-origintunneld._tcp.argotunnel.com: [{target: a.lab, port: 7844}]",
+		"invalid IP":        "srv:\n  _v2-origintunneld._tcp.argotunnel.com: [{target: a.lab, port: 7844}]\nhosts: {a.lab: [10.0.0]}",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := LoadEdgeHosts(writeEdgeHosts(t, content))
+			assert.Error(t, err)
+		})
+	}
+	_, err := LoadEdgeHosts(filepath.Join(t.TempDir(), "missing.yaml"))
+	assert.Error(t, err)
+}
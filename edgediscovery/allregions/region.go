@@ -1,6 +1,10 @@
 package allregions
 
-import "time"
+import (
+	"math/rand"
+	"net"
+	"time"
+)
 
 const (
 	timeoutDuration = 10 * time.Minute
@@ -15,6 +19,10 @@ type Region struct {
 	secondary       AddrSet
 	primaryTimeout  time.Time
 	timeoutDuration time.Duration
+	// scorer ranks unused addresses, nil picks them randomly
+	scorer Scorer
+	// rand, if set, picks among the unused addresses instead of the map iteration order
+	rand *rand.Rand
 }
 
 // NewRegion creates a region with the given addresses, which are all unused.
@@ -82,16 +90,44 @@ func (r *Region) AddrUsedBy(connID int) *EdgeAddr {
 	return edgeAddr
 }
 
+// Contains returns true if the address is in this region.
+func (r *Region) Contains(addr *EdgeAddr) bool {
+	if _, ok := r.primary[addr]; ok {
+		return true
+	}
+	_, ok := r.secondary[addr]
+	return ok
+}
+
 // AvailableAddrs counts how many unused addresses this region contains.
 func (r Region) AvailableAddrs() int {
 	return r.active.AvailableAddrs()
 }
 
-// AssignAnyAddress returns a random unused address in this region now
-// assigned to the connID excluding the provided EdgeAddr.
+// AssignAnyAddress returns an unused address in this region now assigned to the connID excluding the
+// provided EdgeAddr. The address is random, or the best scoring one if the region has a scorer.
 // Returns nil if all addresses are in use for the region.
 func (r Region) AssignAnyAddress(connID int, excluding *EdgeAddr) *EdgeAddr {
-	if addr := r.active.GetUnusedIP(excluding); addr != nil {
+	var addr *EdgeAddr
+	if r.rand != nil {
+		addr = r.active.PickUnusedIP(excluding, r.scorer, r.rand)
+	} else if r.scorer != nil {
+		addr = r.active.GetBestUnusedIP(excluding, r.scorer)
+	} else {
+		addr = r.active.GetUnusedIP(excluding)
+	}
+	if addr == nil {
+		return nil
+	}
+	r.active.Use(addr, connID)
+	return addr
+}
+
+// AssignAddressWithIP returns the unused address with the given IP in this region now
+// assigned to the connID.
+// Returns nil if the active addresses don't contain it or it is in use.
+func (r Region) AssignAddressWithIP(connID int, ip net.IP) *EdgeAddr {
+	if addr := r.active.GetUnusedAddrWithIP(ip); addr != nil {
 		r.active.Use(addr, connID)
 		return addr
 	}
@@ -3,24 +3,33 @@ package allregions
 import (
 	"fmt"
 	"math/rand"
+	"net"
 
 	"github.com/rs/zerolog"
 )
 
+// Scorer ranks edge addresses, e.g. by how reliable connections to them have been. Higher is better.
+type Scorer interface {
+	Score(addr *EdgeAddr) float64
+}
+
 // Regions stores Cloudflare edge network IPs, partitioned into two regions.
 // This is NOT thread-safe. Users of this package should use it with a lock.
 type Regions struct {
 	region1 Region
 	region2 Region
+	// rand, if set, is used instead of the global source to pick between regions
+	rand *rand.Rand
 }
 
 // ------------------------------------
 // Constructors
 // ------------------------------------
 
-// ResolveEdge resolves the Cloudflare edge, returning all regions discovered.
-func ResolveEdge(log *zerolog.Logger, region string, overrideIPVersion ConfigIPVersion) (*Regions, error) {
-	edgeAddrs, err := edgeDiscovery(log, getRegionalServiceName(region))
+// ResolveEdge resolves the Cloudflare edge, returning all regions discovered. The edge is resolved from hosts instead
+// of DNS if it isn't nil.
+func ResolveEdge(log *zerolog.Logger, region string, overrideIPVersion ConfigIPVersion, hosts *EdgeHosts) (*Regions, error) {
+	edgeAddrs, err := edgeDiscovery(log, getRegionalServiceName(region), hosts)
 	if err != nil {
 		return nil, err
 	}
@@ -66,6 +75,38 @@ func NewNoResolve(addrs []*EdgeAddr) *Regions {
 // Methods
 // ------------------------------------
 
+// SetScorer makes both regions hand out their best scoring unused addresses instead of random ones.
+func (rs *Regions) SetScorer(scorer Scorer) {
+	rs.region1.scorer = scorer
+	rs.region2.scorer = scorer
+}
+
+// SetRand makes both regions, and the choice between them, pick addresses with r instead of the global random
+// source, so that the sequence of addresses handed out can be reproduced from a seed.
+func (rs *Regions) SetRand(r *rand.Rand) {
+	rs.rand = r
+	rs.region1.rand = r
+	rs.region2.rand = r
+}
+
+// Refresh replaces the addresses of rs with the ones of fresh, e.g. resolved again before a planned network change.
+// Connections keep the address they use if fresh has an address with the same IP, the others are given a fresh
+// address when they ask for one again. The scorer and random source of rs are kept.
+func (rs *Regions) Refresh(fresh *Regions) {
+	fresh.region1.scorer, fresh.region2.scorer = rs.region1.scorer, rs.region2.scorer
+	fresh.SetRand(rs.rand)
+	for _, region := range []*Region{&rs.region1, &rs.region2} {
+		for _, addrs := range []AddrSet{region.primary, region.secondary} {
+			for addr, used := range addrs {
+				if used.Used {
+					fresh.GetAddrWithIP(addr.UDP.IP, used.ConnID)
+				}
+			}
+		}
+	}
+	*rs = *fresh
+}
+
 // GetAnyAddress returns an arbitrary address from the larger region.
 func (rs *Regions) GetAnyAddress() *EdgeAddr {
 	if addr := rs.region1.GetAnyAddress(); addr != nil {
@@ -91,7 +132,7 @@ func (rs *Regions) GetUnusedAddr(excluding *EdgeAddr, connID int) *EdgeAddr {
 	// evenly across both regions.
 	if rs.region1.AvailableAddrs() == rs.region2.AvailableAddrs() {
 		regions := []Region{rs.region1, rs.region2}
-		firstChoice := rand.Intn(2)
+		firstChoice := rs.intn(2)
 		return getAddrs(excluding, connID, &regions[firstChoice], &regions[1-firstChoice])
 	}
 
@@ -102,6 +143,39 @@ func (rs *Regions) GetUnusedAddr(excluding *EdgeAddr, connID int) *EdgeAddr {
 	return getAddrs(excluding, connID, &rs.region2, &rs.region1)
 }
 
+func (rs *Regions) intn(n int) int {
+	if rs.rand != nil {
+		return rs.rand.Intn(n)
+	}
+	return rand.Intn(n)
+}
+
+// GetAddrWithIP assigns the unused addr with the given IP to the connection, e.g. to reuse the addr it
+// registered on before a restart. Returns nil if the edge doesn't have the addr or it is in use.
+func (rs *Regions) GetAddrWithIP(ip net.IP, connID int) *EdgeAddr {
+	if addr := rs.region1.AssignAddressWithIP(connID, ip); addr != nil {
+		return addr
+	}
+	return rs.region2.AssignAddressWithIP(connID, ip)
+}
+
+// GetUnusedAddrWithPolicy gets an unused addr from the edge, excluding the given addr, from the region the
+// policy prefers relative to the excluded addr. Falls back to the other region if the preferred one has no
+// unused addrs left.
+func (rs *Regions) GetUnusedAddrWithPolicy(excluding *EdgeAddr, connID int, policy RotationPolicy) *EdgeAddr {
+	if excluding == nil || policy == RotateBalanced {
+		return rs.GetUnusedAddr(excluding, connID)
+	}
+	same, other := &rs.region1, &rs.region2
+	if !rs.region1.Contains(excluding) {
+		same, other = other, same
+	}
+	if policy == RotateSameRegion {
+		return getAddrs(excluding, connID, same, other)
+	}
+	return getAddrs(excluding, connID, other, same)
+}
+
 // getAddrs tries to grab address form `first` region, then `second` region
 // this is an unrolled loop over 2 element array
 func getAddrs(excluding *EdgeAddr, connID int, first *Region, second *Region) *EdgeAddr {
@@ -1,6 +1,7 @@
 package allregions
 
 import (
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -212,6 +213,55 @@ func TestRegions_GetUnusedAddr_Excluding_Region2(t *testing.T) {
 	}
 }
 
+func TestRegions_GetUnusedAddrWithPolicy(t *testing.T) {
+	// addr0 and addr2 are in region1, addr1 and addr3 are in region2
+	tests := []struct {
+		name     string
+		policy   RotationPolicy
+		setup    func(rs *Regions)
+		expected []*EdgeAddr
+	}{
+		{
+			name:     "same region",
+			policy:   RotateSameRegion,
+			expected: []*EdgeAddr{&addr2},
+		},
+		{
+			name:     "other region",
+			policy:   RotateOtherRegion,
+			expected: []*EdgeAddr{&addr1, &addr3},
+		},
+		{
+			name:   "same region falls back to other region",
+			policy: RotateSameRegion,
+			setup: func(rs *Regions) {
+				rs.region1.active.Use(&addr2, 1)
+			},
+			expected: []*EdgeAddr{&addr1, &addr3},
+		},
+		{
+			name:   "other region falls back to same region",
+			policy: RotateOtherRegion,
+			setup: func(rs *Regions) {
+				rs.region2.active.Use(&addr1, 1)
+				rs.region2.active.Use(&addr3, 2)
+			},
+			expected: []*EdgeAddr{&addr2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rs := makeRegions(v4Addrs, IPv4Only)
+			if tt.setup != nil {
+				tt.setup(&rs)
+			}
+			addr := rs.GetUnusedAddrWithPolicy(&addr0, 0, tt.policy)
+			assert.Contains(t, tt.expected, addr)
+			assert.Equal(t, addr, rs.AddrUsedBy(0))
+		})
+	}
+}
+
 func TestNewNoResolveBalancesRegions(t *testing.T) {
 	type args struct {
 		addrs []*EdgeAddr
@@ -260,3 +310,36 @@ func abs(x int) int {
 	}
 	return -x
 }
+
+func TestRegions_SetRand(t *testing.T) {
+	addrsHandedOut := func(seed int64) []*EdgeAddr {
+		rs := makeRegions([]*EdgeAddr{&addr0, &addr1, &addr2, &addr3}, Auto)
+		rs.SetRand(rand.New(rand.NewSource(seed)))
+		var addrs []*EdgeAddr
+		for connID := 0; connID < 4; connID++ {
+			addrs = append(addrs, rs.GetUnusedAddr(nil, connID))
+			// Free every address so that both regions are always equally available
+			rs.GiveBack(addrs[connID], false)
+		}
+		return addrs
+	}
+	for seed := int64(0); seed < 10; seed++ {
+		assert.Equal(t, addrsHandedOut(seed), addrsHandedOut(seed))
+	}
+}
+
+func TestRegions_Refresh(t *testing.T) {
+	rs := makeRegions([]*EdgeAddr{&addr0, &addr1, &addr2, &addr3}, Auto)
+	kept := rs.GetAddrWithIP(addr0.UDP.IP, 0)
+	assert.NotNil(t, kept)
+	assert.NotNil(t, rs.GetAddrWithIP(addr1.UDP.IP, 1))
+
+	// The fresh edge still has the address of connection 0, but no longer the one of connection 1
+	sameIP := addr0
+	fresh := makeRegions([]*EdgeAddr{&sameIP, &addr2, &addr3}, Auto)
+	rs.Refresh(&fresh)
+	assert.Equal(t, &sameIP, rs.AddrUsedBy(0))
+	assert.Nil(t, rs.AddrUsedBy(1))
+	assert.Equal(t, 2, rs.AvailableAddrs())
+	assert.False(t, rs.GiveBack(&addr1, false))
+}
@@ -20,12 +20,18 @@ func DialEdge(
 	edgeTCPAddr *net.TCPAddr,
 	localIP net.IP,
 ) (net.Conn, error) {
-	return DialEdgeWithProxy(ctx, timeout, tlsConfig, edgeTCPAddr, localIP, "")
+	return DialEdgeWithProxy(ctx, timeout, tlsConfig, edgeTCPAddr, localIP, "", nil, nil, nil, nil, true, TCPOptions{})
 }
 
 // DialEdgeWithProxy makes a TLS connection to a Cloudflare edge node with optional SOCKS5 proxy support
-// proxyURL 格式: "socks5://[user:pass@]host:port" 或 "" (不使用代理)
-// 如果代理连接失败，会自动降级到直连方式
+// proxyURL 格式: "socks5://[user:pass@]host:port"、"http(s)://[user:pass@]host:port" (HTTP CONNECT)、
+// "ssh://user@host:port" (SSH 跳板机) 或 "" (不使用代理)
+// proxyAuth 为代理认证信息，不为 nil 时优先于 proxyURL 中的用户信息
+// proxyTLS 为 https 代理的 TLS 配置，例如出示给代理的客户端证书
+// proxySSH 为 ssh 代理的跳板机，提供认证用的私钥和主机密钥校验
+// proxyFault 不为 nil 时在每次代理拨号前调用，返回的错误作为代理拨号的错误，用于故障注入
+// directFallback 为 true 时，如果代理连接失败，会自动降级到直连方式，否则返回代理拨号的错误
+// tcpOptions 为直连时的 TCP 套接字选项
 func DialEdgeWithProxy(
 	ctx context.Context,
 	timeout time.Duration,
@@ -33,6 +39,12 @@ func DialEdgeWithProxy(
 	edgeTCPAddr *net.TCPAddr,
 	localIP net.IP,
 	proxyURL string,
+	proxyAuth *proxy.Auth,
+	proxyTLS ProxyTLSConfigs,
+	proxySSH *SSHJumpHost,
+	proxyFault func() error,
+	directFallback bool,
+	tcpOptions TCPOptions,
 ) (net.Conn, error) {
 	// Inherit from parent context so we can cancel (Ctrl-C) while dialing
 	dialCtx, dialCancel := context.WithTimeout(ctx, timeout)
@@ -41,10 +53,22 @@ func DialEdgeWithProxy(
 	var edgeConn net.Conn
 	var err error
 
+	// 分别记录拨号和TLS握手的耗时
+	dialStart := time.Now()
 	// 如果指定了代理，先尝试通过代理连接
 	if proxyURL != "" {
-		edgeConn, err = dialViaProxy(dialCtx, proxyURL, edgeTCPAddr.String(), localIP)
+		if proxyFault != nil {
+			err = proxyFault()
+		}
+		if err == nil {
+			edgeConn, err = dialViaProxy(dialCtx, proxyURL, proxyAuth, proxyTLS, proxySSH, edgeTCPAddr.String(), localIP)
+		}
 		if err != nil {
+			if !directFallback {
+				// 不允许绕过代理直连
+				ObserveConnectPhase(ConnectPhaseDial, connectProtocolHTTP2, dialStart, err)
+				return nil, newDialError(err, "proxy dial error")
+			}
 			// 代理失败，记录错误但继续尝试直连
 			// 这里可以添加日志记录
 			// log.Warn().Err(err).Msg("Proxy connection failed, falling back to direct connection")
@@ -53,17 +77,29 @@ func DialEdgeWithProxy(
 
 	// 如果没有指定代理，或者代理连接失败，则使用直连
 	if edgeConn == nil {
-		edgeConn, err = dialDirect(dialCtx, edgeTCPAddr.String(), localIP)
+		edgeConn, err = dialDirect(dialCtx, edgeTCPAddr.String(), localIP, tcpOptions)
 		if err != nil {
+			ObserveConnectPhase(ConnectPhaseDial, connectProtocolHTTP2, dialStart, err)
 			return nil, newDialError(err, "DialContext error")
 		}
+		// 经由代理的连接不设置套接字选项，其数据包发往代理而非边缘
+		if err = tcpOptions.apply(edgeConn, edgeTCPAddr.IP); err != nil {
+			edgeConn.Close()
+			return nil, newDialError(err, "failed to set socket options")
+		}
 	}
 
+	ObserveConnectPhase(ConnectPhaseDial, connectProtocolHTTP2, dialStart, nil)
+
 	// 建立 TLS 连接
 	tlsEdgeConn := tls.Client(edgeConn, tlsConfig)
 	tlsEdgeConn.SetDeadline(time.Now().Add(timeout))
 
-	if err = tlsEdgeConn.Handshake(); err != nil {
+	handshakeStart := time.Now()
+	// SSH 跳板机的通道不支持截止时间，握手超时时由 dialCtx 关闭连接
+	err = tlsEdgeConn.HandshakeContext(dialCtx)
+	ObserveConnectPhase(ConnectPhaseHandshake, connectProtocolHTTP2, handshakeStart, err)
+	if err != nil {
 		return nil, newDialError(err, "TLS handshake with edge error")
 	}
 	// clear the deadline on the conn; http2 has its own timeouts
@@ -71,13 +107,32 @@ func DialEdgeWithProxy(
 	return tlsEdgeConn, nil
 }
 
-// dialViaProxy 通过 SOCKS5 代理建立连接
-func dialViaProxy(ctx context.Context, proxyURL string, address string, localIP net.IP) (net.Conn, error) {
+// dialViaProxy 通过 SOCKS5、HTTP CONNECT 代理或 SSH 跳板机建立连接
+func dialViaProxy(ctx context.Context, proxyURL string, auth *proxy.Auth, tlsConfigs ProxyTLSConfigs, jumpHost *SSHJumpHost, address string, localIP net.IP) (net.Conn, error) {
 	// 解析代理 URL
 	u, err := url.Parse(proxyURL)
 	if err != nil {
 		return nil, errors.Wrap(err, "invalid proxy URL")
 	}
+	// 未单独提供认证信息时使用 URL 中的用户信息
+	if auth == nil && u.User != nil {
+		auth = &proxy.Auth{
+			User: u.User.Username(),
+		}
+		if password, ok := u.User.Password(); ok {
+			auth.Password = password
+		}
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return dialViaHTTPProxy(ctx, u, auth, tlsConfigs, address, localIP)
+	case "ssh":
+		var user string
+		if auth != nil {
+			user = auth.User
+		}
+		return jumpHost.dial(ctx, u, user, address, localIP)
+	}
 
 	// 创建基础 dialer
 	var baseDial proxy.Dialer = proxy.Direct
@@ -89,17 +144,6 @@ func dialViaProxy(ctx context.Context, proxyURL string, address string, localIP
 		}
 	}
 
-	// 创建代理 dialer
-	var auth *proxy.Auth
-	if u.User != nil {
-		auth = &proxy.Auth{
-			User: u.User.Username(),
-		}
-		if password, ok := u.User.Password(); ok {
-			auth.Password = password
-		}
-	}
-
 	// 获取代理地址和端口
 	proxyAddr := u.Host
 	if u.Port() == "" {
@@ -130,12 +174,8 @@ func dialViaProxy(ctx context.Context, proxyURL string, address string, localIP
 }
 
 // dialDirect 直接建立 TCP 连接（不通过代理）
-func dialDirect(ctx context.Context, address string, localIP net.IP) (net.Conn, error) {
-	dialer := &net.Dialer{}
-	if localIP != nil {
-		dialer.LocalAddr = &net.TCPAddr{IP: localIP, Port: 0}
-	}
-	return dialer.DialContext(ctx, "tcp", address)
+func dialDirect(ctx context.Context, address string, localIP net.IP, tcpOptions TCPOptions) (net.Conn, error) {
+	return tcpOptions.dialer(localIP).DialContext(ctx, "tcp", address)
 }
 
 // DialError is an error returned from DialEdge
@@ -0,0 +1,129 @@
+package edgediscovery
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDialEdgeWithProxyFault(t *testing.T) {
+	edge := httptest.NewTLSServer(http.NotFoundHandler())
+	defer edge.Close()
+	edgeAddr := edge.Listener.Addr().(*net.TCPAddr)
+
+	faults := 0
+	proxyFault := func() error {
+		faults++
+		return errors.New("injected edge proxy dial failure")
+	}
+	// The proxy isn't dialed once the fault fails the dial, which falls back to a direct connection
+	conn, err := DialEdgeWithProxy(context.Background(), time.Second, &tls.Config{InsecureSkipVerify: true}, edgeAddr, nil,
+		"socks5://127.0.0.1:1", nil, nil, nil, proxyFault, true, TCPOptions{})
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, 1, faults)
+	assert.Equal(t, edgeAddr.String(), conn.RemoteAddr().String())
+}
+
+func TestDialEdgeWithProxyNoDirectFallback(t *testing.T) {
+	edge := httptest.NewTLSServer(http.NotFoundHandler())
+	defer edge.Close()
+	edgeAddr := edge.Listener.Addr().(*net.TCPAddr)
+
+	proxyFault := func() error {
+		return errors.New("injected edge proxy dial failure")
+	}
+	// The edge is reachable directly, but the dial fails with the proxy
+	_, err := DialEdgeWithProxy(context.Background(), time.Second, &tls.Config{InsecureSkipVerify: true}, edgeAddr, nil,
+		"socks5://127.0.0.1:1", nil, nil, nil, proxyFault, false, TCPOptions{})
+	var dialErr DialError
+	require.ErrorAs(t, err, &dialErr)
+	assert.ErrorContains(t, err, "injected edge proxy dial failure")
+}
+
+// selfSignedClientCert returns a client certificate with commonName.
+func selfSignedClientCert(t *testing.T, commonName string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// connectProxy is an HTTPS CONNECT proxy only accepting clients presenting a certificate for cloudflared.
+func connectProxy(t *testing.T) *httptest.Server {
+	proxy := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect || len(r.TLS.PeerCertificates) == 0 || r.TLS.PeerCertificates[0].Subject.CommonName != "cloudflared" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+		client, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer client.Close()
+		_, _ = client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		go func() {
+			_, _ = io.Copy(target, client)
+		}()
+		_, _ = io.Copy(client, target)
+	}))
+	proxy.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	proxy.StartTLS()
+	t.Cleanup(proxy.Close)
+	return proxy
+}
+
+func TestDialEdgeWithHTTPSProxyClientCert(t *testing.T) {
+	edge := httptest.NewTLSServer(http.NotFoundHandler())
+	defer edge.Close()
+	edgeAddr := edge.Listener.Addr().(*net.TCPAddr)
+	proxy := connectProxy(t)
+	proxyAddr := proxy.Listener.Addr().String()
+
+	proxyCAs := proxy.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	proxyTLS := ProxyTLSConfigs{
+		proxyAddr: {Certificates: []tls.Certificate{selfSignedClientCert(t, "cloudflared")}, RootCAs: proxyCAs},
+		AnyProxy:  {Certificates: []tls.Certificate{selfSignedClientCert(t, "someone-else")}, RootCAs: proxyCAs},
+	}
+	conn, err := DialEdgeWithProxy(context.Background(), time.Second, &tls.Config{InsecureSkipVerify: true}, edgeAddr, nil,
+		"https://"+proxyAddr, nil, proxyTLS, nil, nil, false, TCPOptions{})
+	require.NoError(t, err)
+	defer conn.Close()
+	// The connection goes through the proxy
+	assert.Equal(t, proxyAddr, conn.RemoteAddr().String())
+
+	// The proxy rejects the certificate of the other proxies
+	delete(proxyTLS, proxyAddr)
+	_, err = DialEdgeWithProxy(context.Background(), time.Second, &tls.Config{InsecureSkipVerify: true}, edgeAddr, nil,
+		"https://"+proxyAddr, nil, proxyTLS, nil, nil, false, TCPOptions{})
+	assert.ErrorContains(t, err, "403")
+}
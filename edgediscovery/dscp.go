@@ -0,0 +1,29 @@
+package edgediscovery
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// MaxDSCP is the highest DSCP value, DSCP being the upper 6 bits of the IPv4 TOS byte and IPv6 traffic class.
+const MaxDSCP = 63
+
+// SetDSCP marks the packets conn sends to edgeIP with dscp, so that QoS policies can prioritize or deprioritize
+// tunnel traffic. A dscp of 0 leaves conn unmarked.
+func SetDSCP(conn net.Conn, edgeIP net.IP, dscp uint8) error {
+	if dscp == 0 {
+		return nil
+	}
+	if dscp > MaxDSCP {
+		return fmt.Errorf("DSCP %d is not between 0 and %d", dscp, MaxDSCP)
+	}
+	// The lower 2 bits are left to ECN
+	tos := int(dscp) << 2
+	if edgeIP.To4() != nil {
+		return ipv4.NewConn(conn).SetTOS(tos)
+	}
+	return ipv6.NewConn(conn).SetTrafficClass(tos)
+}
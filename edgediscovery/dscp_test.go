@@ -0,0 +1,78 @@
+package edgediscovery
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+func TestSetDSCP(t *testing.T) {
+	tests := []struct {
+		name    string
+		network string
+		address string
+	}{
+		{name: "tcp ipv4", network: "tcp4", address: "127.0.0.1:0"},
+		{name: "tcp ipv6", network: "tcp6", address: "[::1]:0"},
+		{name: "udp ipv4", network: "udp4", address: "127.0.0.1:0"},
+		{name: "udp ipv6", network: "udp6", address: "[::1]:0"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conn, edgeIP := dialLoopback(t, test.network, test.address)
+
+			require.NoError(t, SetDSCP(conn, edgeIP, 0))
+			assert.Equal(t, 0, trafficClass(t, conn, edgeIP))
+
+			// Expedited forwarding
+			require.NoError(t, SetDSCP(conn, edgeIP, 46))
+			assert.Equal(t, 46<<2, trafficClass(t, conn, edgeIP))
+
+			assert.Error(t, SetDSCP(conn, edgeIP, MaxDSCP+1))
+		})
+	}
+}
+
+// dialLoopback connects to a listener on address and returns the connection and the IP it is connected to.
+func dialLoopback(t *testing.T, network, address string) (net.Conn, net.IP) {
+	var remote net.Addr
+	switch network {
+	case "tcp4", "tcp6":
+		listener, err := net.Listen(network, address)
+		if err != nil {
+			t.Skipf("%s is not available: %v", network, err)
+		}
+		t.Cleanup(func() { _ = listener.Close() })
+		remote = listener.Addr()
+	default:
+		listener, err := net.ListenPacket(network, address)
+		if err != nil {
+			t.Skipf("%s is not available: %v", network, err)
+		}
+		t.Cleanup(func() { _ = listener.Close() })
+		remote = listener.LocalAddr()
+	}
+	conn, err := net.Dial(network, remote.String())
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	host, _, err := net.SplitHostPort(remote.String())
+	require.NoError(t, err)
+	return conn, net.ParseIP(host)
+}
+
+// trafficClass reads the TOS byte or traffic class back from the socket.
+func trafficClass(t *testing.T, conn net.Conn, edgeIP net.IP) int {
+	var tos int
+	var err error
+	if edgeIP.To4() != nil {
+		tos, err = ipv4.NewConn(conn).TOS()
+	} else {
+		tos, err = ipv6.NewConn(conn).TrafficClass()
+	}
+	require.NoError(t, err)
+	return tos
+}
@@ -1,7 +1,9 @@
 package edgediscovery
 
 import (
+	"math/rand"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 
@@ -24,7 +26,11 @@ func (e ErrNoAddressesLeft) Error() string {
 
 // Edge finds addresses on the Cloudflare edge and hands them out to connections.
 type Edge struct {
-	regions *allregions.Regions
+	regions   *allregions.Regions
+	sticky    *StickyAddrs
+	scorecard *Scorecard
+	guard     *RegistrationGuard
+	rotation  allregions.RotationPolicy
 	sync.Mutex
 	log *zerolog.Logger
 }
@@ -34,9 +40,11 @@ type Edge struct {
 // ------------------------------------
 
 // ResolveEdge runs the initial discovery of the Cloudflare edge, finding Addrs that can be allocated
-// to connections.
-func ResolveEdge(log *zerolog.Logger, region string, edgeIpVersion allregions.ConfigIPVersion) (*Edge, error) {
-	regions, err := allregions.ResolveEdge(log, region, edgeIpVersion)
+// to connections. The lookups are answered by hosts instead of DNS if it isn't nil.
+func ResolveEdge(log *zerolog.Logger, region string, edgeIpVersion allregions.ConfigIPVersion, hosts *allregions.EdgeHosts) (*Edge, error) {
+	start := time.Now()
+	regions, err := allregions.ResolveEdge(log, region, edgeIpVersion, hosts)
+	ObserveConnectPhase(ConnectPhaseDNS, "", start, err)
 	if err != nil {
 		return new(Edge), err
 	}
@@ -48,7 +56,9 @@ func ResolveEdge(log *zerolog.Logger, region string, edgeIpVersion allregions.Co
 
 // StaticEdge creates a list of edge addresses from the list of hostnames. Mainly used for testing connectivity.
 func StaticEdge(log *zerolog.Logger, hostnames []string) (*Edge, error) {
+	start := time.Now()
 	regions, err := allregions.StaticEdge(hostnames, log)
+	ObserveConnectPhase(ConnectPhaseDNS, "", start, err)
 	if err != nil {
 		return new(Edge), err
 	}
@@ -62,6 +72,63 @@ func StaticEdge(log *zerolog.Logger, hostnames []string) (*Edge, error) {
 // Methods
 // ------------------------------------
 
+// UseStickyAddrs makes connections prefer the edge Addrs they registered on before a restart,
+// and remember the ones they register on from now on.
+func (ed *Edge) UseStickyAddrs(sticky *StickyAddrs) {
+	ed.Lock()
+	defer ed.Unlock()
+	ed.sticky = sticky
+}
+
+// UseScorecard makes connections prefer the edge Addrs that worked best for them, and records how they fare
+// from now on.
+func (ed *Edge) UseScorecard(scorecard *Scorecard) {
+	ed.Lock()
+	defer ed.Unlock()
+	ed.scorecard = scorecard
+	if scorecard != nil {
+		ed.regions.SetScorer(scorecard)
+	}
+}
+
+// UseRegistrationGuard makes connections avoid the edge Addrs other connections are registered on, and tracks their
+// registrations from now on.
+func (ed *Edge) UseRegistrationGuard(guard *RegistrationGuard) {
+	ed.Lock()
+	defer ed.Unlock()
+	ed.guard = guard
+}
+
+// SetRand makes the Addrs handed out depend only on r, e.g. to reproduce them from a seed, instead of the global
+// random source.
+func (ed *Edge) SetRand(r *rand.Rand) {
+	ed.Lock()
+	defer ed.Unlock()
+	ed.regions.SetRand(r)
+}
+
+// SetRotationPolicy sets which region GetDifferentAddr prefers for a connection's new Addr.
+func (ed *Edge) SetRotationPolicy(policy allregions.RotationPolicy) {
+	ed.Lock()
+	defer ed.Unlock()
+	ed.rotation = policy
+}
+
+// Refresh replaces the Addrs with the ones of fresh, e.g. resolved again before a planned network change.
+// Connections keep the Addr they use if fresh has it too, and are given one of the fresh Addrs otherwise.
+func (ed *Edge) Refresh(fresh *Edge) {
+	fresh.Lock()
+	regions := fresh.regions
+	fresh.Unlock()
+	ed.Lock()
+	defer ed.Unlock()
+	ed.regions.Refresh(regions)
+	ed.log.Debug().
+		Int(management.EventTypeKey, int(management.Cloudflared)).
+		Int("available", ed.regions.AvailableAddrs()).
+		Msg("edge discovery: refreshed edge addresses")
+}
+
 // GetAddrForRPC gives this connection an edge Addr.
 func (ed *Edge) GetAddrForRPC() (*allregions.EdgeAddr, error) {
 	ed.Lock()
@@ -88,8 +155,18 @@ func (ed *Edge) GetAddr(connIndex int) (*allregions.EdgeAddr, error) {
 		return addr, nil
 	}
 
+	// If this connection registered on an edge addr before a restart, prefer it.
+	if ip := ed.sticky.Get(connIndex); ip != nil && !ed.guard.Taken(connIndex, ip) {
+		if addr := ed.regions.GetAddrWithIP(ip, connIndex); addr != nil {
+			log.Debug().IPAddr(LogFieldIPAddress, addr.UDP.IP).Msg("edge discovery: giving connection the address it previously registered on")
+			return addr, nil
+		}
+	}
+
 	// Otherwise, give it an unused one
-	addr := ed.regions.GetUnusedAddr(nil, connIndex)
+	addr := ed.pickUntaken(connIndex, func() *allregions.EdgeAddr {
+		return ed.regions.GetUnusedAddr(nil, connIndex)
+	})
 	if addr == nil {
 		log.Debug().Msg("edge discovery: no addresses left in pool to give proxy connection")
 		return nil, errNoAddressesLeft
@@ -98,6 +175,39 @@ func (ed *Edge) GetAddr(connIndex int) (*allregions.EdgeAddr, error) {
 	return addr, nil
 }
 
+// GetRaceAddrs gives this proxy connection an edge Addr like GetAddr, followed by up to n-1 other unused Addrs,
+// preferably from the other region, that the connection can race it against. All of them are assigned to the
+// connection until KeepRaceWinner gives back the ones that lost.
+func (ed *Edge) GetRaceAddrs(connIndex int, n int) ([]*allregions.EdgeAddr, error) {
+	addr, err := ed.GetAddr(connIndex)
+	if err != nil {
+		return nil, err
+	}
+	ed.Lock()
+	defer ed.Unlock()
+	addrs := []*allregions.EdgeAddr{addr}
+	for len(addrs) < n {
+		candidate := ed.regions.GetUnusedAddrWithPolicy(addr, connIndex, allregions.RotateOtherRegion)
+		if candidate == nil {
+			break
+		}
+		addrs = append(addrs, candidate)
+	}
+	return addrs, nil
+}
+
+// KeepRaceWinner gives back the Addrs from GetRaceAddrs other than the winner, which stays assigned to the
+// connection.
+func (ed *Edge) KeepRaceWinner(winner *allregions.EdgeAddr, addrs []*allregions.EdgeAddr) {
+	ed.Lock()
+	defer ed.Unlock()
+	for _, addr := range addrs {
+		if addr != winner {
+			ed.regions.GiveBack(addr, false)
+		}
+	}
+}
+
 // GetDifferentAddr gives back the proxy connection's edge Addr and uses a new one.
 func (ed *Edge) GetDifferentAddr(connIndex int, hasConnectivityError bool) (*allregions.EdgeAddr, error) {
 	log := ed.log.With().
@@ -111,7 +221,12 @@ func (ed *Edge) GetDifferentAddr(connIndex int, hasConnectivityError bool) (*all
 	if oldAddr != nil {
 		ed.regions.GiveBack(oldAddr, hasConnectivityError)
 	}
-	addr := ed.regions.GetUnusedAddr(oldAddr, connIndex)
+	if err := ed.sticky.Forget(connIndex); err != nil {
+		log.Warn().Err(err).Msg("edge discovery: failed to forget the address this connection registered on")
+	}
+	addr := ed.pickUntaken(connIndex, func() *allregions.EdgeAddr {
+		return ed.regions.GetUnusedAddrWithPolicy(oldAddr, connIndex, ed.rotation)
+	})
 	if addr == nil {
 		log.Debug().Msg("edge discovery: no addresses left in pool to give proxy connection")
 		// note: if oldAddr were not nil, it will become available on the next iteration
@@ -124,6 +239,73 @@ func (ed *Edge) GetDifferentAddr(connIndex int, hasConnectivityError bool) (*all
 	return addr, nil
 }
 
+// pickUntaken assigns the Addrs pick returns to the connection until one isn't taken by another registration, and
+// gives back the taken ones. The first Addr picked is kept if they are all taken. Must be called with ed locked.
+func (ed *Edge) pickUntaken(connIndex int, pick func() *allregions.EdgeAddr) *allregions.EdgeAddr {
+	var taken []*allregions.EdgeAddr
+	addr := pick()
+	for addr != nil && ed.guard.Taken(connIndex, addr.UDP.IP) {
+		taken = append(taken, addr)
+		addr = pick()
+	}
+	if addr == nil && len(taken) > 0 {
+		addr, taken = taken[0], taken[1:]
+	}
+	for _, t := range taken {
+		ed.regions.GiveBack(t, false)
+	}
+	return addr
+}
+
+// Registered remembers the edge Addr the connection registered on, so that it is preferred after a restart, and
+// scores the Addr by how long registering took. The Addr is held by the connection until Unregistered.
+func (ed *Edge) Registered(connIndex int, addr *allregions.EdgeAddr, registrationTime time.Duration) {
+	ed.Lock()
+	sticky, scorecard, guard := ed.sticky, ed.scorecard, ed.guard
+	ed.Unlock()
+	log := ed.log.With().
+		Int(LogFieldConnIndex, connIndex).
+		Int(management.EventTypeKey, int(management.Cloudflared)).
+		Logger()
+	if err := guard.Hold(connIndex, addr.UDP.IP); err != nil {
+		log.Warn().Err(err).Msg("edge discovery: connection registered on an address another connection holds")
+	}
+	if err := sticky.Remember(connIndex, addr.UDP.IP); err != nil {
+		log.Warn().Err(err).Msg("edge discovery: failed to remember the address this connection registered on")
+	}
+	if err := scorecard.RecordRegistration(addr.UDP.IP.String(), registrationTime); err != nil {
+		log.Warn().Err(err).Msg("edge discovery: failed to score address")
+	}
+}
+
+// Unregistered notes that the connection is no longer registered, other connections may be given its Addr.
+func (ed *Edge) Unregistered(connIndex int) {
+	ed.Lock()
+	guard := ed.guard
+	ed.Unlock()
+	guard.Release(connIndex)
+}
+
+// HandshakeFailed scores the edge Addr down because connecting to it failed before registering.
+func (ed *Edge) HandshakeFailed(addr *allregions.EdgeAddr) {
+	ed.Lock()
+	scorecard := ed.scorecard
+	ed.Unlock()
+	if err := scorecard.RecordHandshakeFailure(addr.UDP.IP.String()); err != nil {
+		ed.log.Warn().Int(management.EventTypeKey, int(management.Cloudflared)).Err(err).Msg("edge discovery: failed to score address")
+	}
+}
+
+// ConnectionFailed scores the edge Addr by how long a connection registered on it lived before failing.
+func (ed *Edge) ConnectionFailed(addr *allregions.EdgeAddr, lifetime time.Duration) {
+	ed.Lock()
+	scorecard := ed.scorecard
+	ed.Unlock()
+	if err := scorecard.RecordFailure(addr.UDP.IP.String(), lifetime); err != nil {
+		ed.log.Warn().Int(management.EventTypeKey, int(management.Cloudflared)).Err(err).Msg("edge discovery: failed to score address")
+	}
+}
+
 // AvailableAddrs returns how many unused addresses there are left.
 func (ed *Edge) AvailableAddrs() int {
 	ed.Lock()
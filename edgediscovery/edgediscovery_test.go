@@ -253,6 +253,54 @@ func TestGetDifferentAddr(t *testing.T) {
 	assert.Equal(t, 3, edge.AvailableAddrs())
 }
 
+func TestGetDifferentAddrRotationPolicy(t *testing.T) {
+	// addr0 and addr2 are in one region, addr1 and addr3 in the other
+	sameRegion := map[*allregions.EdgeAddr]*allregions.EdgeAddr{&addr0: &addr2, &addr2: &addr0, &addr1: &addr3, &addr3: &addr1}
+
+	edge := MockEdge(&testLogger, []*allregions.EdgeAddr{&addr0, &addr1, &addr2, &addr3})
+	edge.SetRotationPolicy(allregions.RotateSameRegion)
+	addr, err := edge.GetAddr(0)
+	assert.NoError(t, err)
+	rotated, err := edge.GetDifferentAddr(0, true)
+	assert.NoError(t, err)
+	assert.Equal(t, sameRegion[addr], rotated)
+
+	edge = MockEdge(&testLogger, []*allregions.EdgeAddr{&addr0, &addr1, &addr2, &addr3})
+	edge.SetRotationPolicy(allregions.RotateOtherRegion)
+	addr, err = edge.GetAddr(0)
+	assert.NoError(t, err)
+	rotated, err = edge.GetDifferentAddr(0, true)
+	assert.NoError(t, err)
+	assert.NotEqual(t, addr, rotated)
+	assert.NotEqual(t, sameRegion[addr], rotated)
+}
+
+func TestGetRaceAddrs(t *testing.T) {
+	// addr0 and addr2 are in one region, addr1 and addr3 in the other
+	sameRegion := map[*allregions.EdgeAddr]*allregions.EdgeAddr{&addr0: &addr2, &addr2: &addr0, &addr1: &addr3, &addr3: &addr1}
+
+	edge := MockEdge(&testLogger, []*allregions.EdgeAddr{&addr0, &addr1, &addr2, &addr3})
+	const connID = 0
+	addrs, err := edge.GetRaceAddrs(connID, 2)
+	assert.NoError(t, err)
+	assert.Len(t, addrs, 2)
+	assert.NotEqual(t, sameRegion[addrs[0]], addrs[1])
+	assert.Equal(t, 2, edge.AvailableAddrs())
+
+	// The winner stays assigned to the connection, the loser goes back to the pool
+	edge.KeepRaceWinner(addrs[1], addrs)
+	assert.Equal(t, 3, edge.AvailableAddrs())
+	addr, err := edge.GetAddr(connID)
+	assert.NoError(t, err)
+	assert.Equal(t, addrs[1], addr)
+
+	// There are never more candidates than addresses
+	edge = MockEdge(&testLogger, []*allregions.EdgeAddr{&addr0, &addr1})
+	addrs, err = edge.GetRaceAddrs(connID, 3)
+	assert.NoError(t, err)
+	assert.Len(t, addrs, 2)
+}
+
 // MockEdge creates a Cloudflare Edge from arbitrary TCP addresses. Used for testing.
 func MockEdge(log *zerolog.Logger, addrs []*allregions.EdgeAddr) *Edge {
 	regions := allregions.NewNoResolve(addrs)
@@ -0,0 +1,48 @@
+package edgediscovery
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Phases of connecting to the edge and registering a tunnel connection, timed separately so that a slow connection
+// can be attributed to the phase taking the time.
+const (
+	// ConnectPhaseDNS is the lookup of the edge addresses
+	ConnectPhaseDNS = "dns"
+	// ConnectPhaseDial is the TCP dial, or opening the UDP socket for QUIC
+	ConnectPhaseDial = "dial"
+	// ConnectPhaseHandshake is the TLS or QUIC handshake
+	ConnectPhaseHandshake = "handshake"
+	// ConnectPhaseRegister is the RegisterConnection RPC
+	ConnectPhaseRegister = "register"
+)
+
+// connectProtocolHTTP2 is the protocol of the connections dialed by DialEdge, that serve HTTP/2 over TLS
+const connectProtocolHTTP2 = "http2"
+
+var connectPhaseDuration = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Namespace: "cloudflared",
+		Subsystem: "tunnel",
+		Name:      "connect_phase_duration_seconds",
+		Help:      "Time spent in each phase of connecting to the edge and registering a connection, per attempt",
+		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 15, 30},
+	},
+	[]string{"phase", "protocol", "result"},
+)
+
+func init() {
+	prometheus.MustRegister(connectPhaseDuration)
+}
+
+// ObserveConnectPhase records the time spent in phase since start, by a connection attempt over protocol that
+// failed with err if it isn't nil. The protocol is empty for phases that don't depend on it.
+func ObserveConnectPhase(phase, protocol string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	connectPhaseDuration.WithLabelValues(phase, protocol, result).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,48 @@
+package edgediscovery
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+)
+
+func connectPhaseCount(t *testing.T, phase, protocol, result string) uint64 {
+	var metric dto.Metric
+	observer := connectPhaseDuration.WithLabelValues(phase, protocol, result)
+	require.NoError(t, observer.(prometheus.Metric).Write(&metric))
+	return metric.GetHistogram().GetSampleCount()
+}
+
+func TestDialEdgeObservesConnectPhases(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	addr := listener.Addr().(*net.TCPAddr)
+	// The edge accepts the connection but never completes the handshake
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			defer conn.Close()
+			_, _ = conn.Read(make([]byte, 1))
+		}
+	}()
+
+	dials := connectPhaseCount(t, ConnectPhaseDial, connectProtocolHTTP2, "success")
+	handshakes := connectPhaseCount(t, ConnectPhaseHandshake, connectProtocolHTTP2, "failure")
+	_, err = DialEdge(context.Background(), 100*time.Millisecond, &tls.Config{ServerName: "edge"}, addr, nil)
+	require.Error(t, err)
+	require.Equal(t, dials+1, connectPhaseCount(t, ConnectPhaseDial, connectProtocolHTTP2, "success"))
+	require.Equal(t, handshakes+1, connectPhaseCount(t, ConnectPhaseHandshake, connectProtocolHTTP2, "failure"))
+
+	// Nothing listens anymore, the dial itself fails
+	require.NoError(t, listener.Close())
+	failedDials := connectPhaseCount(t, ConnectPhaseDial, connectProtocolHTTP2, "failure")
+	_, err = DialEdge(context.Background(), 100*time.Millisecond, &tls.Config{ServerName: "edge"}, addr, nil)
+	require.Error(t, err)
+	require.Equal(t, failedDials+1, connectPhaseCount(t, ConnectPhaseDial, connectProtocolHTTP2, "failure"))
+}
@@ -0,0 +1,50 @@
+package edgediscovery
+
+import (
+	"errors"
+	"net"
+)
+
+const (
+	// MinQUICDatagramSize is the smallest UDP payload a path must carry for QUIC to work, see RFC 9000 section 14.
+	MinQUICDatagramSize = 1200
+	// maxProbedDatagramSize is the largest UDP payload probed, the largest packet quic-go sends.
+	maxProbedDatagramSize = 1452
+)
+
+var errMTUProbeUnsupported = errors.New("MTU probing is not supported on this platform")
+
+// ProbeDatagramSize finds the largest UDP payload the host can send to edgeAddr without fragmenting it, as far
+// as the OS knows the path MTU, e.g. from the interface MTU or ICMP "fragmentation needed" messages. It binary
+// searches by sending datagrams with the don't fragment bit, which QUIC servers drop.
+func ProbeDatagramSize(edgeAddr *net.UDPAddr, localIP net.IP) (int, error) {
+	var localAddr *net.UDPAddr
+	if localIP != nil {
+		localAddr = &net.UDPAddr{IP: localIP}
+	}
+	conn, err := net.DialUDP("udp", localAddr, edgeAddr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	if err := setDontFragment(conn, edgeAddr.IP.To4() != nil); err != nil {
+		return 0, err
+	}
+
+	// A clear fixed bit, the second most significant bit of the first byte, makes QUIC servers drop the datagram
+	payload := make([]byte, maxProbedDatagramSize)
+	low, high := 0, maxProbedDatagramSize
+	for low < high {
+		size := (low + high + 1) / 2
+		fits, err := datagramFits(conn, payload[:size])
+		if err != nil {
+			return 0, err
+		}
+		if fits {
+			low = size
+		} else {
+			high = size - 1
+		}
+	}
+	return low, nil
+}
@@ -0,0 +1,41 @@
+//go:build linux
+
+package edgediscovery
+
+import (
+	"errors"
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+func setDontFragment(conn *net.UDPConn, ipv4 bool) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		if ipv4 {
+			sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_DO)
+		} else {
+			sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_DO)
+		}
+	}); err != nil {
+		return err
+	}
+	return sockErr
+}
+
+// datagramFits returns false if the OS refuses to send payload because it exceeds the path MTU.
+func datagramFits(conn *net.UDPConn, payload []byte) (bool, error) {
+	_, err := conn.Write(payload)
+	if errors.Is(err, unix.ECONNREFUSED) {
+		// The ICMP error of an earlier datagram was reported instead of sending this one
+		_, err = conn.Write(payload)
+	}
+	if errors.Is(err, unix.EMSGSIZE) {
+		return false, nil
+	}
+	return err == nil, err
+}
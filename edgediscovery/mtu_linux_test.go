@@ -0,0 +1,34 @@
+//go:build linux
+
+package edgediscovery
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProbeDatagramSize(t *testing.T) {
+	listener, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	defer listener.Close()
+
+	// The loopback MTU is larger than any probed size
+	size, err := ProbeDatagramSize(listener.LocalAddr().(*net.UDPAddr), nil)
+	require.NoError(t, err)
+	assert.Equal(t, maxProbedDatagramSize, size)
+}
+
+func TestProbeDatagramSizeClosedPort(t *testing.T) {
+	listener, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	addr := listener.LocalAddr().(*net.UDPAddr)
+	require.NoError(t, listener.Close())
+
+	// Nothing answering the probes doesn't make them fail
+	size, err := ProbeDatagramSize(addr, nil)
+	require.NoError(t, err)
+	assert.Equal(t, maxProbedDatagramSize, size)
+}
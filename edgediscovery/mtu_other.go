@@ -0,0 +1,15 @@
+//go:build !linux
+
+package edgediscovery
+
+import (
+	"net"
+)
+
+func setDontFragment(_ *net.UDPConn, _ bool) error {
+	return errMTUProbeUnsupported
+}
+
+func datagramFits(_ *net.UDPConn, _ []byte) (bool, error) {
+	return false, errMTUProbeUnsupported
+}
@@ -0,0 +1,387 @@
+package pac
+
+import (
+	"encoding/binary"
+	"math"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Env is what the PAC functions learn about the host evaluating the PAC file.
+type Env struct {
+	// LookupIP resolves host for dnsResolve, isResolvable and isInNet, nil if it can't be resolved
+	LookupIP func(host string) []net.IP
+	// MyIP is the address returned by myIpAddress
+	MyIP net.IP
+	// Now is the time of weekdayRange and timeRange
+	Now time.Time
+}
+
+func (env Env) resolve(host string) net.IP {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip
+	}
+	if env.LookupIP == nil {
+		return nil
+	}
+	ips := env.LookupIP(host)
+	for _, ip := range ips {
+		// The PAC functions predate IPv6 and expect IPv4 addresses
+		if ip.To4() != nil {
+			return ip
+		}
+	}
+	if len(ips) > 0 {
+		return ips[0]
+	}
+	return nil
+}
+
+// globals returns the PAC functions available to a PAC file.
+func (env Env) globals() map[string]value {
+	return map[string]value{
+		"isPlainHostName": builtin(func(args []value) (value, error) {
+			return !strings.Contains(arg(args, 0), "."), nil
+		}),
+		"dnsDomainIs": builtin(func(args []value) (value, error) {
+			return strings.HasSuffix(strings.ToLower(arg(args, 0)), strings.ToLower(arg(args, 1))), nil
+		}),
+		"localHostOrDomainIs": builtin(func(args []value) (value, error) {
+			host, hostdom := strings.ToLower(arg(args, 0)), strings.ToLower(arg(args, 1))
+			if host == hostdom {
+				return true, nil
+			}
+			return !strings.Contains(host, ".") && strings.HasPrefix(hostdom, host+"."), nil
+		}),
+		"isResolvable": builtin(func(args []value) (value, error) {
+			return env.resolve(arg(args, 0)) != nil, nil
+		}),
+		"isInNet": builtin(func(args []value) (value, error) {
+			ip := env.resolve(arg(args, 0)).To4()
+			pattern, mask := net.ParseIP(arg(args, 1)).To4(), net.ParseIP(arg(args, 2)).To4()
+			if ip == nil || pattern == nil || mask == nil {
+				return false, nil
+			}
+			return ip.Mask(net.IPMask(mask)).Equal(pattern.Mask(net.IPMask(mask))), nil
+		}),
+		"dnsResolve": builtin(func(args []value) (value, error) {
+			if ip := env.resolve(arg(args, 0)); ip != nil {
+				return ip.String(), nil
+			}
+			return nil, nil
+		}),
+		"convert_addr": builtin(func(args []value) (value, error) {
+			ip := net.ParseIP(arg(args, 0)).To4()
+			if ip == nil {
+				return math.NaN(), nil
+			}
+			return float64(binary.BigEndian.Uint32(ip)), nil
+		}),
+		"myIpAddress": builtin(func(args []value) (value, error) {
+			if env.MyIP == nil {
+				return "127.0.0.1", nil
+			}
+			return env.MyIP.String(), nil
+		}),
+		"dnsDomainLevels": builtin(func(args []value) (value, error) {
+			return float64(strings.Count(arg(args, 0), ".")), nil
+		}),
+		"shExpMatch": builtin(func(args []value) (value, error) {
+			return shExpMatch(arg(args, 0), arg(args, 1)), nil
+		}),
+		"weekdayRange": builtin(env.weekdayRange),
+		"timeRange":    builtin(env.timeRange),
+		"dateRange": builtin(func(args []value) (value, error) {
+			return nil, errors.New("dateRange isn't supported")
+		}),
+		"alert": builtin(func(args []value) (value, error) {
+			return nil, nil
+		}),
+	}
+}
+
+// arg returns the i-th argument as a string, "undefined" if it's missing.
+func arg(args []value, i int) string {
+	if i >= len(args) {
+		return "undefined"
+	}
+	return toString(args[i])
+}
+
+// shExpMatch matches str against a shell expression, where * matches any characters including / and ? matches one.
+func shExpMatch(str, shexp string) bool {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	for _, part := range strings.SplitAfter(shexp, "") {
+		switch part {
+		case "*":
+			pattern.WriteString(".*")
+		case "?":
+			pattern.WriteString(".")
+		default:
+			pattern.WriteString(regexp.QuoteMeta(part))
+		}
+	}
+	pattern.WriteString("$")
+	matched, err := regexp.MatchString(pattern.String(), str)
+	return err == nil && matched
+}
+
+var weekdays = []string{"SUN", "MON", "TUE", "WED", "THU", "FRI", "SAT"}
+
+// gmt removes the trailing "GMT" argument, returning whether it was there.
+func gmt(args []value) ([]value, bool) {
+	if len(args) > 0 && toString(args[len(args)-1]) == "GMT" {
+		return args[:len(args)-1], true
+	}
+	return args, false
+}
+
+func (env Env) now(utc bool) time.Time {
+	now := env.Now
+	if now.IsZero() {
+		now = time.Now()
+	}
+	if utc {
+		return now.UTC()
+	}
+	return now.Local()
+}
+
+// weekdayRange implements weekdayRange(wd1[, wd2][, "GMT"]).
+func (env Env) weekdayRange(args []value) (value, error) {
+	args, utc := gmt(args)
+	if len(args) == 0 || len(args) > 2 {
+		return nil, errors.New("weekdayRange expects one or two week days")
+	}
+	days := make([]int, len(args))
+	for i, day := range args {
+		days[i] = -1
+		for n, name := range weekdays {
+			if toString(day) == name {
+				days[i] = n
+			}
+		}
+		if days[i] < 0 {
+			return nil, errors.Errorf("invalid week day %s", toString(day))
+		}
+	}
+	today := int(env.now(utc).Weekday())
+	if len(days) == 1 {
+		return today == days[0], nil
+	}
+	return inRange(today, days[0], days[1]), nil
+}
+
+// timeRange implements timeRange(hour1[, hour2][, "GMT"]).
+func (env Env) timeRange(args []value) (value, error) {
+	args, utc := gmt(args)
+	if len(args) == 0 || len(args) > 2 {
+		return nil, errors.New("timeRange only supports one or two hours")
+	}
+	hour := env.now(utc).Hour()
+	if len(args) == 1 {
+		return hour == int(toNumber(args[0])), nil
+	}
+	// The end hour is excluded, timeRange(9, 17) ends at 17:00
+	return inRange(hour, int(toNumber(args[0])), int(toNumber(args[1]))-1), nil
+}
+
+// inRange returns whether n is within [from, to], wrapping around if from is after to.
+func inRange(n, from, to int) bool {
+	if from <= to {
+		return n >= from && n <= to
+	}
+	return n >= from || n <= to
+}
+
+// member returns the property name of object, the methods of strings and arrays being bound to them.
+func member(object value, name string) (value, error) {
+	switch object := object.(type) {
+	case nil:
+		return nil, errors.Errorf("can't read %s of undefined", name)
+	case string:
+		if name == "length" {
+			return float64(len(object)), nil
+		}
+		return stringMethod(object, name), nil
+	case *array:
+		if name == "length" {
+			return float64(len(object.elements)), nil
+		}
+		return arrayMethod(object, name), nil
+	}
+	return nil, nil
+}
+
+func stringMethod(s, name string) value {
+	switch name {
+	case "toLowerCase":
+		return builtin(func(args []value) (value, error) { return strings.ToLower(s), nil })
+	case "toUpperCase":
+		return builtin(func(args []value) (value, error) { return strings.ToUpper(s), nil })
+	case "trim":
+		return builtin(func(args []value) (value, error) { return strings.TrimSpace(s), nil })
+	case "toString":
+		return builtin(func(args []value) (value, error) { return s, nil })
+	case "indexOf":
+		return builtin(func(args []value) (value, error) {
+			from := clamp(intArg(args, 1, 0), len(s))
+			i := strings.Index(s[from:], arg(args, 0))
+			if i < 0 {
+				return float64(-1), nil
+			}
+			return float64(from + i), nil
+		})
+	case "lastIndexOf":
+		return builtin(func(args []value) (value, error) {
+			return float64(strings.LastIndex(s, arg(args, 0))), nil
+		})
+	case "includes":
+		return builtin(func(args []value) (value, error) { return strings.Contains(s, arg(args, 0)), nil })
+	case "startsWith":
+		return builtin(func(args []value) (value, error) { return strings.HasPrefix(s, arg(args, 0)), nil })
+	case "endsWith":
+		return builtin(func(args []value) (value, error) { return strings.HasSuffix(s, arg(args, 0)), nil })
+	case "charAt":
+		return builtin(func(args []value) (value, error) {
+			i := intArg(args, 0, 0)
+			if i < 0 || i >= len(s) {
+				return "", nil
+			}
+			return s[i : i+1], nil
+		})
+	case "substring":
+		return builtin(func(args []value) (value, error) {
+			start, end := clamp(intArg(args, 0, 0), len(s)), clamp(intArg(args, 1, len(s)), len(s))
+			if start > end {
+				start, end = end, start
+			}
+			return s[start:end], nil
+		})
+	case "slice":
+		return builtin(func(args []value) (value, error) {
+			start, end := relative(intArg(args, 0, 0), len(s)), relative(intArg(args, 1, len(s)), len(s))
+			if start >= end {
+				return "", nil
+			}
+			return s[start:end], nil
+		})
+	case "substr":
+		return builtin(func(args []value) (value, error) {
+			start := relative(intArg(args, 0, 0), len(s))
+			end := clamp(start+intArg(args, 1, len(s)), len(s))
+			if start >= end {
+				return "", nil
+			}
+			return s[start:end], nil
+		})
+	case "split":
+		return builtin(func(args []value) (value, error) {
+			var parts []string
+			if len(args) == 0 || args[0] == nil {
+				parts = []string{s}
+			} else {
+				parts = strings.Split(s, toString(args[0]))
+			}
+			if limit := intArg(args, 1, len(parts)); limit >= 0 && limit < len(parts) {
+				parts = parts[:limit]
+			}
+			elements := make([]value, len(parts))
+			for i, part := range parts {
+				elements[i] = part
+			}
+			return &array{elements: elements}, nil
+		})
+	case "replace":
+		return builtin(func(args []value) (value, error) {
+			return strings.Replace(s, arg(args, 0), arg(args, 1), 1), nil
+		})
+	}
+	return nil
+}
+
+func arrayMethod(a *array, name string) value {
+	switch name {
+	case "indexOf", "includes":
+		return builtin(func(args []value) (value, error) {
+			var search value
+			if len(args) > 0 {
+				search = args[0]
+			}
+			for i, element := range a.elements {
+				if strictEquals(element, search) {
+					if name == "includes" {
+						return true, nil
+					}
+					return float64(i), nil
+				}
+			}
+			if name == "includes" {
+				return false, nil
+			}
+			return float64(-1), nil
+		})
+	case "join":
+		return builtin(func(args []value) (value, error) {
+			sep := ","
+			if len(args) > 0 && args[0] != nil {
+				sep = toString(args[0])
+			}
+			parts := make([]string, len(a.elements))
+			for i, element := range a.elements {
+				if element != nil {
+					parts[i] = toString(element)
+				}
+			}
+			return strings.Join(parts, sep), nil
+		})
+	case "push":
+		return builtin(func(args []value) (value, error) {
+			a.elements = append(a.elements, args...)
+			return float64(len(a.elements)), nil
+		})
+	case "toString":
+		return builtin(func(args []value) (value, error) { return toString(a), nil })
+	}
+	return nil
+}
+
+// intArg returns the i-th argument as an integer, def if it's missing or undefined.
+func intArg(args []value, i, def int) int {
+	if i >= len(args) || args[i] == nil {
+		return def
+	}
+	n := toNumber(args[i])
+	switch {
+	case math.IsNaN(n):
+		return 0
+	case n > math.MaxInt32:
+		return math.MaxInt32
+	case n < math.MinInt32:
+		return math.MinInt32
+	}
+	return int(n)
+}
+
+func clamp(i, length int) int {
+	if i < 0 {
+		return 0
+	}
+	if i > length {
+		return length
+	}
+	return i
+}
+
+// relative clamps i to [0, length], counting from the end if it's negative.
+func relative(i, length int) int {
+	if i < 0 {
+		i += length
+	}
+	return clamp(i, length)
+}
@@ -0,0 +1,602 @@
+package pac
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxSteps bounds the statements and calls of an evaluation, so that a PAC file looping forever can't hang dials
+	maxSteps = 1_000_000
+	// maxCallDepth bounds the recursion of the functions of a PAC file
+	maxCallDepth = 200
+)
+
+// value is a JavaScript value: nil (undefined or null), bool, float64, string, *array, *closure or builtin.
+type value interface{}
+
+type array struct {
+	elements []value
+}
+
+type closure struct {
+	fn    functionExpr
+	scope *scope
+}
+
+type builtin func(args []value) (value, error)
+
+type scope struct {
+	vars   map[string]value
+	parent *scope
+}
+
+func newScope(parent *scope) *scope {
+	return &scope{vars: map[string]value{}, parent: parent}
+}
+
+func (s *scope) lookup(name string) (value, bool) {
+	for ; s != nil; s = s.parent {
+		if v, ok := s.vars[name]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+// set assigns name in the closest scope declaring it, or the global scope if none does.
+func (s *scope) set(name string, v value) {
+	for current := s; ; current = current.parent {
+		if _, ok := current.vars[name]; ok || current.parent == nil {
+			current.vars[name] = v
+			return
+		}
+	}
+}
+
+type control int
+
+const (
+	controlNormal control = iota
+	controlReturn
+	controlBreak
+	controlContinue
+)
+
+type interpreter struct {
+	steps int
+	depth int
+}
+
+func (in *interpreter) step() error {
+	in.steps++
+	if in.steps > maxSteps {
+		return errors.New("the PAC file takes too long to evaluate")
+	}
+	return nil
+}
+
+func (in *interpreter) execAll(stmts []node, s *scope) (control, value, error) {
+	for _, stmt := range stmts {
+		ctrl, v, err := in.exec(stmt, s)
+		if err != nil || ctrl != controlNormal {
+			return ctrl, v, err
+		}
+	}
+	return controlNormal, nil, nil
+}
+
+func (in *interpreter) exec(stmt node, s *scope) (control, value, error) {
+	if err := in.step(); err != nil {
+		return controlNormal, nil, err
+	}
+	switch stmt := stmt.(type) {
+	case emptyStmt:
+	case exprStmt:
+		_, err := in.eval(stmt.x, s)
+		return controlNormal, nil, err
+	case varStmt:
+		for i, name := range stmt.names {
+			var v value
+			if stmt.inits[i] != nil {
+				var err error
+				if v, err = in.eval(stmt.inits[i], s); err != nil {
+					return controlNormal, nil, err
+				}
+			} else if existing, ok := s.vars[name]; ok {
+				v = existing
+			}
+			s.vars[name] = v
+		}
+	case blockStmt:
+		return in.execAll(stmt.body, s)
+	case ifStmt:
+		cond, err := in.eval(stmt.cond, s)
+		if err != nil {
+			return controlNormal, nil, err
+		}
+		if truthy(cond) {
+			return in.exec(stmt.then, s)
+		} else if stmt.otherwise != nil {
+			return in.exec(stmt.otherwise, s)
+		}
+	case returnStmt:
+		if stmt.x == nil {
+			return controlReturn, nil, nil
+		}
+		v, err := in.eval(stmt.x, s)
+		return controlReturn, v, err
+	case forStmt:
+		if stmt.init != nil {
+			var err error
+			if init, ok := stmt.init.(varStmt); ok {
+				_, _, err = in.exec(init, s)
+			} else {
+				_, err = in.eval(stmt.init, s)
+			}
+			if err != nil {
+				return controlNormal, nil, err
+			}
+		}
+		return in.loop(stmt.cond, stmt.update, stmt.body, s)
+	case whileStmt:
+		return in.loop(stmt.cond, nil, stmt.body, s)
+	case breakStmt:
+		return controlBreak, nil, nil
+	case contStmt:
+		return controlContinue, nil, nil
+	default:
+		return controlNormal, nil, errors.Errorf("unsupported statement %T", stmt)
+	}
+	return controlNormal, nil, nil
+}
+
+func (in *interpreter) loop(cond, update, body node, s *scope) (control, value, error) {
+	for {
+		if cond != nil {
+			v, err := in.eval(cond, s)
+			if err != nil {
+				return controlNormal, nil, err
+			}
+			if !truthy(v) {
+				return controlNormal, nil, nil
+			}
+		}
+		ctrl, v, err := in.exec(body, s)
+		if err != nil || ctrl == controlReturn {
+			return ctrl, v, err
+		}
+		if ctrl == controlBreak {
+			return controlNormal, nil, nil
+		}
+		if update != nil {
+			if _, err := in.eval(update, s); err != nil {
+				return controlNormal, nil, err
+			}
+		} else if err := in.step(); err != nil {
+			return controlNormal, nil, err
+		}
+	}
+}
+
+func (in *interpreter) eval(x node, s *scope) (value, error) {
+	switch x := x.(type) {
+	case literal:
+		return x.value, nil
+	case identifier:
+		v, ok := s.lookup(x.name)
+		if !ok {
+			return nil, errors.Errorf("%s is not defined", x.name)
+		}
+		return v, nil
+	case arrayLit:
+		elements := make([]value, len(x.elements))
+		for i, element := range x.elements {
+			v, err := in.eval(element, s)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = v
+		}
+		return &array{elements: elements}, nil
+	case functionExpr:
+		return &closure{fn: x, scope: s}, nil
+	case unaryExpr:
+		v, err := in.eval(x.x, s)
+		if err != nil {
+			return nil, err
+		}
+		switch x.op {
+		case "!":
+			return !truthy(v), nil
+		case "-":
+			return -toNumber(v), nil
+		case "+":
+			return toNumber(v), nil
+		default:
+			return typeOf(v), nil
+		}
+	case binaryExpr:
+		return in.binary(x, s)
+	case conditionalExpr:
+		cond, err := in.eval(x.cond, s)
+		if err != nil {
+			return nil, err
+		}
+		if truthy(cond) {
+			return in.eval(x.then, s)
+		}
+		return in.eval(x.otherwise, s)
+	case assignExpr:
+		v, err := in.eval(x.value, s)
+		if err != nil {
+			return nil, err
+		}
+		if x.op != "=" {
+			current, err := in.eval(x.target, s)
+			if err != nil {
+				return nil, err
+			}
+			if x.op == "+=" {
+				v = add(current, v)
+			} else {
+				v = toNumber(current) - toNumber(v)
+			}
+		}
+		return v, in.assign(x.target, v, s)
+	case updateExpr:
+		current, err := in.eval(x.target, s)
+		if err != nil {
+			return nil, err
+		}
+		old := toNumber(current)
+		updated := old + 1
+		if x.op == "--" {
+			updated = old - 1
+		}
+		if err := in.assign(x.target, updated, s); err != nil {
+			return nil, err
+		}
+		if x.prefix {
+			return updated, nil
+		}
+		return old, nil
+	case callExpr:
+		return in.call(x, s)
+	case memberExpr:
+		object, err := in.eval(x.object, s)
+		if err != nil {
+			return nil, err
+		}
+		return member(object, x.name)
+	case indexExpr:
+		object, err := in.eval(x.object, s)
+		if err != nil {
+			return nil, err
+		}
+		index, err := in.eval(x.index, s)
+		if err != nil {
+			return nil, err
+		}
+		return indexOf(object, index)
+	}
+	return nil, errors.Errorf("unsupported expression %T", x)
+}
+
+func (in *interpreter) binary(x binaryExpr, s *scope) (value, error) {
+	left, err := in.eval(x.left, s)
+	if err != nil {
+		return nil, err
+	}
+	switch x.op {
+	case "&&":
+		if !truthy(left) {
+			return left, nil
+		}
+		return in.eval(x.right, s)
+	case "||":
+		if truthy(left) {
+			return left, nil
+		}
+		return in.eval(x.right, s)
+	}
+	right, err := in.eval(x.right, s)
+	if err != nil {
+		return nil, err
+	}
+	switch x.op {
+	case "+":
+		return add(left, right), nil
+	case "-":
+		return toNumber(left) - toNumber(right), nil
+	case "*":
+		return toNumber(left) * toNumber(right), nil
+	case "/":
+		return toNumber(left) / toNumber(right), nil
+	case "%":
+		return math.Mod(toNumber(left), toNumber(right)), nil
+	case "===":
+		return strictEquals(left, right), nil
+	case "!==":
+		return !strictEquals(left, right), nil
+	case "==":
+		return looseEquals(left, right), nil
+	case "!=":
+		return !looseEquals(left, right), nil
+	}
+	if l, ok := left.(string); ok {
+		if r, ok := right.(string); ok {
+			switch x.op {
+			case "<":
+				return l < r, nil
+			case ">":
+				return l > r, nil
+			case "<=":
+				return l <= r, nil
+			default:
+				return l >= r, nil
+			}
+		}
+	}
+	l, r := toNumber(left), toNumber(right)
+	switch x.op {
+	case "<":
+		return l < r, nil
+	case ">":
+		return l > r, nil
+	case "<=":
+		return l <= r, nil
+	default:
+		return l >= r, nil
+	}
+}
+
+func (in *interpreter) assign(target node, v value, s *scope) error {
+	switch target := target.(type) {
+	case identifier:
+		s.set(target.name, v)
+		return nil
+	case indexExpr:
+		object, err := in.eval(target.object, s)
+		if err != nil {
+			return err
+		}
+		index, err := in.eval(target.index, s)
+		if err != nil {
+			return err
+		}
+		arr, ok := object.(*array)
+		if !ok {
+			return errors.Errorf("can't assign an element of %s", typeOf(object))
+		}
+		i := int(toNumber(index))
+		if i < 0 || i > len(arr.elements) {
+			return errors.Errorf("index %d out of range", i)
+		}
+		if i == len(arr.elements) {
+			arr.elements = append(arr.elements, v)
+		} else {
+			arr.elements[i] = v
+		}
+		return nil
+	}
+	return errors.New("invalid assignment target")
+}
+
+func (in *interpreter) call(x callExpr, s *scope) (value, error) {
+	if err := in.step(); err != nil {
+		return nil, err
+	}
+	callee, err := in.eval(x.callee, s)
+	if err != nil {
+		return nil, err
+	}
+	args := make([]value, len(x.args))
+	for i, arg := range x.args {
+		if args[i], err = in.eval(arg, s); err != nil {
+			return nil, err
+		}
+	}
+	switch fn := callee.(type) {
+	case builtin:
+		return fn(args)
+	case *closure:
+		return in.invoke(fn, args)
+	}
+	return nil, errors.Errorf("%s is not a function", describe(x.callee))
+}
+
+func (in *interpreter) invoke(fn *closure, args []value) (value, error) {
+	if in.depth >= maxCallDepth {
+		return nil, errors.New("too much recursion")
+	}
+	in.depth++
+	defer func() { in.depth-- }()
+
+	local := newScope(fn.scope)
+	for i, param := range fn.fn.params {
+		var arg value
+		if i < len(args) {
+			arg = args[i]
+		}
+		local.vars[param] = arg
+	}
+	_, v, err := in.execAll(fn.fn.body, local)
+	return v, err
+}
+
+func describe(x node) string {
+	switch x := x.(type) {
+	case identifier:
+		return x.name
+	case memberExpr:
+		return describe(x.object) + "." + x.name
+	}
+	return "expression"
+}
+
+func truthy(v value) bool {
+	switch v := v.(type) {
+	case nil:
+		return false
+	case bool:
+		return v
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case string:
+		return v != ""
+	}
+	return true
+}
+
+func typeOf(v value) string {
+	switch v.(type) {
+	case nil:
+		return "undefined"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	case *closure, builtin:
+		return "function"
+	}
+	return "object"
+}
+
+func toNumber(v value) float64 {
+	switch v := v.(type) {
+	case nil:
+		return math.NaN()
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	case float64:
+		return v
+	case string:
+		v = strings.TrimSpace(v)
+		if v == "" {
+			return 0
+		}
+		n, err := parseNumber(v)
+		if err != nil {
+			return math.NaN()
+		}
+		return n
+	}
+	return math.NaN()
+}
+
+func toString(v value) string {
+	switch v := v.(type) {
+	case nil:
+		return "undefined"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		switch {
+		case math.IsNaN(v):
+			return "NaN"
+		case math.IsInf(v, 1):
+			return "Infinity"
+		case math.IsInf(v, -1):
+			return "-Infinity"
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	case *array:
+		parts := make([]string, len(v.elements))
+		for i, element := range v.elements {
+			if element != nil {
+				parts[i] = toString(element)
+			}
+		}
+		return strings.Join(parts, ",")
+	}
+	return "function"
+}
+
+func add(left, right value) value {
+	_, ls := left.(string)
+	_, rs := right.(string)
+	_, la := left.(*array)
+	_, ra := right.(*array)
+	if ls || rs || la || ra {
+		return toString(left) + toString(right)
+	}
+	return toNumber(left) + toNumber(right)
+}
+
+func strictEquals(left, right value) bool {
+	switch l := left.(type) {
+	case nil:
+		return right == nil
+	case bool, string:
+		return left == right
+	case float64:
+		r, ok := right.(float64)
+		return ok && l == r
+	case *array:
+		r, ok := right.(*array)
+		return ok && l == r
+	case *closure:
+		r, ok := right.(*closure)
+		return ok && l == r
+	}
+	return false
+}
+
+func looseEquals(left, right value) bool {
+	if left == nil || right == nil {
+		return left == nil && right == nil
+	}
+	switch left.(type) {
+	case float64, bool:
+		switch right.(type) {
+		case string, bool, float64:
+			return toNumber(left) == toNumber(right)
+		}
+	case string:
+		switch right.(type) {
+		case float64, bool:
+			return toNumber(left) == toNumber(right)
+		}
+	}
+	return strictEquals(left, right)
+}
+
+func indexOf(object, index value) (value, error) {
+	switch object := object.(type) {
+	case string:
+		if i, ok := toIndex(index); ok && i < len(object) {
+			return object[i : i+1], nil
+		}
+		return member(object, toString(index))
+	case *array:
+		if i, ok := toIndex(index); ok {
+			if i < len(object.elements) {
+				return object.elements[i], nil
+			}
+			return nil, nil
+		}
+		return member(object, toString(index))
+	case nil:
+		return nil, errors.Errorf("can't read %s of undefined", toString(index))
+	}
+	return nil, nil
+}
+
+func toIndex(v value) (int, bool) {
+	n := toNumber(v)
+	if n < 0 || n != math.Trunc(n) {
+		return 0, false
+	}
+	return int(n), true
+}
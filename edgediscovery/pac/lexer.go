@@ -0,0 +1,165 @@
+package pac
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenPunct
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	line int
+}
+
+// punctuators are ordered so that the longest ones match first
+var punctuators = []string{
+	"===", "!==",
+	"==", "!=", "<=", ">=", "&&", "||", "++", "--", "+=", "-=",
+	"{", "}", "(", ")", "[", "]", ";", ",", ".", "?", ":", "=", "+", "-", "*", "/", "%", "<", ">", "!",
+}
+
+// tokenize splits the source of a PAC file into tokens. Regular expression literals aren't supported, a / is always
+// the division operator.
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == '\f' || c == '\v':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "/*"):
+			end := strings.Index(src[i+2:], "*/")
+			if end < 0 {
+				return nil, errors.Errorf("line %d: unterminated comment", line)
+			}
+			line += strings.Count(src[i:i+2+end], "\n")
+			i += end + 4
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && (isIdentStart(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: src[start:i], line: line})
+		case isDigit(c) || (c == '.' && i+1 < len(src) && isDigit(src[i+1])):
+			start := i
+			for i < len(src) && (isDigit(src[i]) || src[i] == '.' || src[i] == 'x' || src[i] == 'X' ||
+				(src[i] >= 'a' && src[i] <= 'f') || (src[i] >= 'A' && src[i] <= 'F')) {
+				i++
+			}
+			text := src[start:i]
+			num, err := parseNumber(text)
+			if err != nil {
+				return nil, errors.Errorf("line %d: invalid number %s", line, text)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: text, num: num, line: line})
+		case c == '"' || c == '\'':
+			text, n, err := readString(src[i:])
+			if err != nil {
+				return nil, errors.Wrapf(err, "line %d", line)
+			}
+			tokens = append(tokens, token{kind: tokenString, text: text, line: line})
+			i += n
+		default:
+			matched := false
+			for _, punct := range punctuators {
+				if strings.HasPrefix(src[i:], punct) {
+					tokens = append(tokens, token{kind: tokenPunct, text: punct, line: line})
+					i += len(punct)
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				return nil, errors.Errorf("line %d: unexpected character %q", line, c)
+			}
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, line: line}), nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c == '$' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func parseNumber(text string) (float64, error) {
+	if strings.HasPrefix(text, "0x") || strings.HasPrefix(text, "0X") {
+		n, err := strconv.ParseUint(text[2:], 16, 64)
+		return float64(n), err
+	}
+	return strconv.ParseFloat(text, 64)
+}
+
+// readString reads the string literal at the start of src, returning its value and length in src.
+func readString(src string) (string, int, error) {
+	quote := src[0]
+	var value strings.Builder
+	for i := 1; i < len(src); i++ {
+		switch c := src[i]; c {
+		case quote:
+			return value.String(), i + 1, nil
+		case '\n':
+			return "", 0, errors.New("unterminated string")
+		case '\\':
+			i++
+			if i >= len(src) {
+				return "", 0, errors.New("unterminated string")
+			}
+			switch e := src[i]; e {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'r':
+				value.WriteByte('\r')
+			case '0':
+				value.WriteByte(0)
+			case 'x', 'u':
+				size := 2
+				if e == 'u' {
+					size = 4
+				}
+				if i+size >= len(src) {
+					return "", 0, errors.New("invalid escape sequence")
+				}
+				code, err := strconv.ParseUint(src[i+1:i+1+size], 16, 32)
+				if err != nil {
+					return "", 0, errors.New("invalid escape sequence")
+				}
+				value.WriteRune(rune(code))
+				i += size
+			case '\n':
+				// Line continuation
+			default:
+				value.WriteByte(e)
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", 0, errors.New("unterminated string")
+}
@@ -0,0 +1,105 @@
+// Package pac evaluates proxy auto-config (PAC) files to select the proxy to reach an address through.
+//
+// PAC files are JavaScript, of which a constrained interpreter supports what PAC files commonly use: functions,
+// variables, if, for and while statements, string and array methods, and the PAC functions such as shExpMatch,
+// dnsDomainIs and isInNet. Regular expressions, objects and dateRange aren't supported.
+package pac
+
+import (
+	"net"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const entryPoint = "FindProxyForURL"
+
+// Script is a compiled PAC file.
+type Script struct {
+	program []node
+}
+
+// Compile parses the source of a PAC file and checks that it defines FindProxyForURL.
+func Compile(src string) (*Script, error) {
+	program, err := parse(src)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid PAC file")
+	}
+	script := &Script{program: program}
+	if _, _, err := script.load(Env{}); err != nil {
+		return nil, err
+	}
+	return script, nil
+}
+
+// load runs the top level statements of the PAC file, returning its FindProxyForURL function.
+func (s *Script) load(env Env) (*interpreter, *closure, error) {
+	global := newScope(nil)
+	for name, fn := range env.globals() {
+		global.vars[name] = fn
+	}
+	in := &interpreter{}
+	if _, _, err := in.execAll(s.program, global); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to evaluate the PAC file")
+	}
+	fn, ok := global.vars[entryPoint].(*closure)
+	if !ok {
+		return nil, nil, errors.Errorf("the PAC file doesn't define the %s function", entryPoint)
+	}
+	return in, fn, nil
+}
+
+// FindProxyForURL calls the FindProxyForURL function of the PAC file, returning its result such as
+// "PROXY proxy.example.com:8080; DIRECT".
+func (s *Script) FindProxyForURL(env Env, url, host string) (string, error) {
+	in, fn, err := s.load(env)
+	if err != nil {
+		return "", err
+	}
+	result, err := in.invoke(fn, []value{url, host})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to evaluate %s", entryPoint)
+	}
+	if result == nil {
+		return "", errors.Errorf("%s didn't return a result", entryPoint)
+	}
+	return toString(result), nil
+}
+
+// ParseResult returns the proxy URLs of the result of FindProxyForURL in order of preference, an empty one for a
+// DIRECT connection. SOCKS4 proxies aren't supported and are left out.
+func ParseResult(result string) ([]string, error) {
+	var proxies []string
+	for _, entry := range strings.Split(result, ";") {
+		fields := strings.Fields(entry)
+		if len(fields) == 0 {
+			continue
+		}
+		kind := strings.ToUpper(fields[0])
+		if kind == "DIRECT" {
+			proxies = append(proxies, "")
+			continue
+		}
+		if len(fields) != 2 {
+			return nil, errors.Errorf("invalid proxy %q", strings.TrimSpace(entry))
+		}
+		if _, _, err := net.SplitHostPort(fields[1]); err != nil {
+			return nil, errors.Errorf("invalid proxy %q, expected host:port", strings.TrimSpace(entry))
+		}
+		switch kind {
+		case "PROXY", "HTTP":
+			proxies = append(proxies, "http://"+fields[1])
+		case "HTTPS":
+			proxies = append(proxies, "https://"+fields[1])
+		case "SOCKS", "SOCKS5":
+			proxies = append(proxies, "socks5://"+fields[1])
+		case "SOCKS4":
+		default:
+			return nil, errors.Errorf("invalid proxy type %q", fields[0])
+		}
+	}
+	if len(proxies) == 0 {
+		return nil, errors.Errorf("no supported proxy in %q", result)
+	}
+	return proxies, nil
+}
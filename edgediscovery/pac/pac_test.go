@@ -0,0 +1,171 @@
+package pac
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testPAC = `
+// Corporate proxy selection
+var bypass = ["*.internal.example.com", "intranet"];
+
+function isBypassed(host) {
+	for (var i = 0; i < bypass.length; i++) {
+		if (shExpMatch(host, bypass[i])) return true
+	}
+	return false;
+}
+
+function FindProxyForURL(url, host) {
+	host = host.toLowerCase();
+	if (isPlainHostName(host) || isBypassed(host))
+		return "DIRECT";
+	/* The edge goes through the egress proxies */
+	if (isInNet(host, "198.41.192.0", "255.255.255.0")) {
+		return "HTTPS egress-1.example.com:3129; PROXY egress-2.example.com:3128; DIRECT";
+	}
+	if (dnsDomainIs(host, ".example.net") && url.substring(0, 6) == "https:")
+		return myIpAddress() === "10.1.2.3" ? "SOCKS5 socks.example.com:1080" : 'SOCKS4 old.example.com:1080; DIRECT';
+	return "PROXY " + ["proxy", "example", "com"].join(".") + ":" + (3000 + 128);
+}
+`
+
+func TestFindProxyForURL(t *testing.T) {
+	script, err := Compile(testPAC)
+	require.NoError(t, err)
+
+	tests := []struct {
+		url, host string
+		myIP      net.IP
+		expected  string
+	}{
+		{url: "https://intranet/", host: "intranet", expected: "DIRECT"},
+		{url: "https://git.internal.example.com/", host: "GIT.internal.example.com", expected: "DIRECT"},
+		{url: "https://198.41.192.7:7844/", host: "198.41.192.7", expected: "HTTPS egress-1.example.com:3129; PROXY egress-2.example.com:3128; DIRECT"},
+		{url: "https://www.example.net/", host: "www.example.net", myIP: net.ParseIP("10.1.2.3"), expected: "SOCKS5 socks.example.com:1080"},
+		{url: "https://www.example.net/", host: "www.example.net", expected: "SOCKS4 old.example.com:1080; DIRECT"},
+		{url: "http://www.example.net/", host: "www.example.net", expected: "PROXY proxy.example.com:3128"},
+	}
+	for _, test := range tests {
+		result, err := script.FindProxyForURL(Env{MyIP: test.myIP}, test.url, test.host)
+		require.NoError(t, err, test.host)
+		assert.Equal(t, test.expected, result, test.host)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		"function FindProxyForURL(url, host) { return 'DIRECT'",
+		"function FindProxyForURL(url, host) { return /direct/; }",
+		"function findProxy(url, host) { return 'DIRECT'; }",
+		"var x = 'unterminated;",
+		"undefinedFunction();",
+	} {
+		_, err := Compile(src)
+		assert.Error(t, err, src)
+	}
+}
+
+func TestFindProxyForURLErrors(t *testing.T) {
+	for _, src := range []string{
+		"function FindProxyForURL(url, host) { while (true) {} }",
+		"function FindProxyForURL(url, host) { return FindProxyForURL(url, host); }",
+		"function FindProxyForURL(url, host) { return host.match(/x/); }",
+		"function FindProxyForURL(url, host) { return host.unknownMethod(); }",
+		"function FindProxyForURL(url, host) { }",
+	} {
+		script, err := Compile(src)
+		if err != nil {
+			continue
+		}
+		_, err = script.FindProxyForURL(Env{}, "https://198.41.192.7:7844/", "198.41.192.7")
+		assert.Error(t, err, src)
+	}
+}
+
+func TestPACFunctions(t *testing.T) {
+	env := Env{
+		LookupIP: func(host string) []net.IP {
+			if host == "proxy.example.com" {
+				return []net.IP{net.ParseIP("2001:db8::1"), net.ParseIP("10.0.0.8")}
+			}
+			return nil
+		},
+		Now: time.Date(2026, 10, 16, 10, 30, 0, 0, time.UTC),
+	}
+	tests := map[string]string{
+		`dnsResolve("proxy.example.com")`:                                                 "10.0.0.8",
+		`dnsResolve("unknown.example.com")`:                                               "undefined",
+		`isResolvable("unknown.example.com")`:                                             "false",
+		`isInNet("proxy.example.com", "10.0.0.0", "255.0.0.0")`:                           "true",
+		`isInNet("192.168.1.1", "10.0.0.0", "255.0.0.0")`:                                 "false",
+		`localHostOrDomainIs("www", "www.example.com")`:                                   "true",
+		`localHostOrDomainIs("www.example.org", "www.example.com")`:                       "false",
+		`dnsDomainLevels("www.example.com")`:                                              "2",
+		`shExpMatch("https://a.example.com/path", "*.example.com/*")`:                     "true",
+		`shExpMatch("example.com", "?xample.co")`:                                         "false",
+		`convert_addr("10.0.0.1")`:                                                        "167772161",
+		`weekdayRange("MON", "FRI", "GMT")`:                                               "true",
+		`weekdayRange("SAT", "GMT")`:                                                      "false",
+		`timeRange(9, 17, "GMT")`:                                                         "true",
+		`timeRange(22, 6, "GMT")`:                                                         "false",
+		`"a.b.c".split(".").length + "-" + "abc".slice(-2) + "-" + "abcdef".substr(1, 2)`: "3-bc-bc",
+		`typeof undefinedValue + typeof host + typeof shExpMatch`:                         "undefinedstringfunction",
+	}
+	for expression, expected := range tests {
+		script, err := Compile("var undefinedValue; function FindProxyForURL(url, host) { return '' + (" + expression + "); }")
+		require.NoError(t, err, expression)
+		result, err := script.FindProxyForURL(env, "", "")
+		require.NoError(t, err, expression)
+		assert.Equal(t, expected, result, expression)
+	}
+}
+
+func TestParseResult(t *testing.T) {
+	proxies, err := ParseResult("HTTPS egress-1.example.com:3129; PROXY egress-2.example.com:3128;SOCKS4 old.example.com:1080; socks socks.example.com:1080; DIRECT")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://egress-1.example.com:3129", "http://egress-2.example.com:3128", "socks5://socks.example.com:1080", ""}, proxies)
+
+	for _, invalid := range []string{"", "SOCKS4 old.example.com:1080", "PROXY proxy.example.com", "FTP proxy.example.com:21"} {
+		_, err := ParseResult(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestSelectorCachesSelections(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "proxy.pac")
+	require.NoError(t, os.WriteFile(path, []byte(testPAC), 0600))
+	log := zerolog.Nop()
+	selector, err := NewSelector(context.Background(), "file://"+path, &log)
+	require.NoError(t, err)
+	selector.localIP = func(*net.TCPAddr) net.IP { return nil }
+
+	addr := &net.TCPAddr{IP: net.ParseIP("198.41.192.7"), Port: 7844}
+	proxies, err := selector.ProxiesFor(context.Background(), addr)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"https://egress-1.example.com:3129", "http://egress-2.example.com:3128", ""}, proxies)
+
+	// The selection is cached, the PAC file isn't evaluated again until it expires
+	selector.script = nil
+	cached, err := selector.ProxiesFor(context.Background(), addr)
+	require.NoError(t, err)
+	assert.Equal(t, proxies, cached)
+
+	// The PAC file is fetched again after the refresh interval, dropping the cached selections
+	require.NoError(t, os.WriteFile(path, []byte(`function FindProxyForURL(url, host) { return "DIRECT"; }`), 0600))
+	selector.loadedAt = time.Now().Add(-refreshInterval)
+	proxies, err = selector.ProxiesFor(context.Background(), addr)
+	require.NoError(t, err)
+	assert.Equal(t, []string{""}, proxies)
+
+	_, err = NewSelector(context.Background(), filepath.Join(t.TempDir(), "missing.pac"), &log)
+	assert.Error(t, err)
+}
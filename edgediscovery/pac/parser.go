@@ -0,0 +1,552 @@
+package pac
+
+import (
+	"github.com/pkg/errors"
+)
+
+type node interface{}
+
+// Expressions
+type (
+	literal    struct{ value value }
+	identifier struct{ name string }
+	arrayLit   struct{ elements []node }
+	unaryExpr  struct {
+		op string
+		x  node
+	}
+	binaryExpr struct {
+		op          string
+		left, right node
+	}
+	conditionalExpr struct{ cond, then, otherwise node }
+	assignExpr      struct {
+		op            string
+		target, value node
+	}
+	updateExpr struct {
+		op     string
+		prefix bool
+		target node
+	}
+	callExpr struct {
+		callee node
+		args   []node
+	}
+	memberExpr struct {
+		object node
+		name   string
+	}
+	indexExpr    struct{ object, index node }
+	functionExpr struct {
+		name   string
+		params []string
+		body   []node
+	}
+)
+
+// Statements
+type (
+	varStmt struct {
+		names []string
+		inits []node
+	}
+	exprStmt   struct{ x node }
+	blockStmt  struct{ body []node }
+	ifStmt     struct{ cond, then, otherwise node }
+	returnStmt struct{ x node }
+	forStmt    struct{ init, cond, update, body node }
+	whileStmt  struct{ cond, body node }
+	breakStmt  struct{}
+	contStmt   struct{}
+	emptyStmt  struct{}
+)
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// parse parses the statements of a PAC file.
+func parse(src string) ([]node, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	var program []node
+	for p.peek().kind != tokenEOF {
+		stmt, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		program = append(program, stmt)
+	}
+	return program, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) is(text string) bool {
+	t := p.peek()
+	return (t.kind == tokenPunct || t.kind == tokenIdent) && t.text == text
+}
+
+func (p *parser) accept(text string) bool {
+	if p.is(text) {
+		p.next()
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(text string) error {
+	if !p.accept(text) {
+		return p.unexpected()
+	}
+	return nil
+}
+
+func (p *parser) unexpected() error {
+	t := p.peek()
+	if t.kind == tokenEOF {
+		return errors.Errorf("line %d: unexpected end of file", t.line)
+	}
+	return errors.Errorf("line %d: unexpected %q", t.line, t.text)
+}
+
+func (p *parser) ident() (string, error) {
+	t := p.peek()
+	if t.kind != tokenIdent {
+		return "", p.unexpected()
+	}
+	p.next()
+	return t.text, nil
+}
+
+// endStatement consumes the semicolon ending a statement, which may be left out at the end of a line or block.
+func (p *parser) endStatement() error {
+	if p.accept(";") {
+		return nil
+	}
+	if t := p.peek(); t.kind == tokenEOF || p.is("}") || (p.pos > 0 && t.line > p.tokens[p.pos-1].line) {
+		return nil
+	}
+	return p.unexpected()
+}
+
+func (p *parser) statement() (node, error) {
+	switch {
+	case p.accept(";"):
+		return emptyStmt{}, nil
+	case p.is("{"):
+		return p.block()
+	case p.is("var") || p.is("let") || p.is("const"):
+		p.next()
+		stmt, err := p.varDecl()
+		if err != nil {
+			return nil, err
+		}
+		return stmt, p.endStatement()
+	case p.is("function"):
+		p.next()
+		fn, err := p.function(true)
+		if err != nil {
+			return nil, err
+		}
+		return varStmt{names: []string{fn.name}, inits: []node{fn}}, nil
+	case p.accept("if"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		cond, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		then, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		var otherwise node
+		if p.accept("else") {
+			if otherwise, err = p.statement(); err != nil {
+				return nil, err
+			}
+		}
+		return ifStmt{cond: cond, then: then, otherwise: otherwise}, nil
+	case p.accept("return"):
+		if p.is(";") || p.is("}") || p.peek().kind == tokenEOF || p.peek().line > p.tokens[p.pos-1].line {
+			return returnStmt{}, p.endStatement()
+		}
+		x, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		return returnStmt{x: x}, p.endStatement()
+	case p.accept("for"):
+		return p.forLoop()
+	case p.accept("while"):
+		if err := p.expect("("); err != nil {
+			return nil, err
+		}
+		cond, err := p.expression()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		body, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		return whileStmt{cond: cond, body: body}, nil
+	case p.accept("break"):
+		return breakStmt{}, p.endStatement()
+	case p.accept("continue"):
+		return contStmt{}, p.endStatement()
+	}
+	x, err := p.expression()
+	if err != nil {
+		return nil, err
+	}
+	return exprStmt{x: x}, p.endStatement()
+}
+
+func (p *parser) block() (blockStmt, error) {
+	if err := p.expect("{"); err != nil {
+		return blockStmt{}, err
+	}
+	var body []node
+	for !p.accept("}") {
+		if p.peek().kind == tokenEOF {
+			return blockStmt{}, p.unexpected()
+		}
+		stmt, err := p.statement()
+		if err != nil {
+			return blockStmt{}, err
+		}
+		body = append(body, stmt)
+	}
+	return blockStmt{body: body}, nil
+}
+
+func (p *parser) varDecl() (varStmt, error) {
+	var stmt varStmt
+	for {
+		name, err := p.ident()
+		if err != nil {
+			return varStmt{}, err
+		}
+		var init node
+		if p.accept("=") {
+			if init, err = p.assignment(); err != nil {
+				return varStmt{}, err
+			}
+		}
+		stmt.names = append(stmt.names, name)
+		stmt.inits = append(stmt.inits, init)
+		if !p.accept(",") {
+			return stmt, nil
+		}
+	}
+}
+
+func (p *parser) forLoop() (node, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var loop forStmt
+	var err error
+	if p.is("var") || p.is("let") {
+		p.next()
+		if loop.init, err = p.varDecl(); err != nil {
+			return nil, err
+		}
+	} else if !p.is(";") {
+		if loop.init, err = p.expression(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	if !p.is(";") {
+		if loop.cond, err = p.expression(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(";"); err != nil {
+		return nil, err
+	}
+	if !p.is(")") {
+		if loop.update, err = p.expression(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if loop.body, err = p.statement(); err != nil {
+		return nil, err
+	}
+	return loop, nil
+}
+
+// function parses the rest of a function after the function keyword.
+func (p *parser) function(named bool) (functionExpr, error) {
+	var fn functionExpr
+	var err error
+	if named || p.peek().kind == tokenIdent {
+		if fn.name, err = p.ident(); err != nil {
+			return functionExpr{}, err
+		}
+	}
+	if err := p.expect("("); err != nil {
+		return functionExpr{}, err
+	}
+	for !p.accept(")") {
+		if len(fn.params) > 0 {
+			if err := p.expect(","); err != nil {
+				return functionExpr{}, err
+			}
+		}
+		param, err := p.ident()
+		if err != nil {
+			return functionExpr{}, err
+		}
+		fn.params = append(fn.params, param)
+	}
+	body, err := p.block()
+	if err != nil {
+		return functionExpr{}, err
+	}
+	fn.body = body.body
+	return fn, nil
+}
+
+func (p *parser) expression() (node, error) {
+	return p.assignment()
+}
+
+func (p *parser) assignment() (node, error) {
+	target, err := p.conditional()
+	if err != nil {
+		return nil, err
+	}
+	if p.is("=") || p.is("+=") || p.is("-=") {
+		op := p.next().text
+		switch target.(type) {
+		case identifier, memberExpr, indexExpr:
+		default:
+			return nil, errors.Errorf("line %d: invalid assignment target", p.peek().line)
+		}
+		value, err := p.assignment()
+		if err != nil {
+			return nil, err
+		}
+		return assignExpr{op: op, target: target, value: value}, nil
+	}
+	return target, nil
+}
+
+func (p *parser) conditional() (node, error) {
+	cond, err := p.binary(0)
+	if err != nil {
+		return nil, err
+	}
+	if !p.accept("?") {
+		return cond, nil
+	}
+	then, err := p.assignment()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	otherwise, err := p.assignment()
+	if err != nil {
+		return nil, err
+	}
+	return conditionalExpr{cond: cond, then: then, otherwise: otherwise}, nil
+}
+
+// binaryPrecedence lists the binary operators from the lowest precedence to the highest
+var binaryPrecedence = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "===", "!=="},
+	{"<", ">", "<=", ">="},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+func (p *parser) binary(level int) (node, error) {
+	if level == len(binaryPrecedence) {
+		return p.unary()
+	}
+	left, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.binaryOp(level)
+		if !ok {
+			return left, nil
+		}
+		right, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op: op, left: left, right: right}
+	}
+}
+
+func (p *parser) binaryOp(level int) (string, bool) {
+	if p.peek().kind != tokenPunct {
+		return "", false
+	}
+	for _, op := range binaryPrecedence[level] {
+		if p.accept(op) {
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) unary() (node, error) {
+	if p.is("!") || p.is("-") || p.is("+") || p.is("typeof") {
+		op := p.next().text
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return unaryExpr{op: op, x: x}, nil
+	}
+	if p.is("++") || p.is("--") {
+		op := p.next().text
+		target, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return updateExpr{op: op, prefix: true, target: target}, nil
+	}
+	x, err := p.postfix()
+	if err != nil {
+		return nil, err
+	}
+	if p.is("++") || p.is("--") {
+		return updateExpr{op: p.next().text, target: x}, nil
+	}
+	return x, nil
+}
+
+func (p *parser) postfix() (node, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch {
+		case p.accept("."):
+			name, err := p.ident()
+			if err != nil {
+				return nil, err
+			}
+			x = memberExpr{object: x, name: name}
+		case p.accept("["):
+			index, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect("]"); err != nil {
+				return nil, err
+			}
+			x = indexExpr{object: x, index: index}
+		case p.accept("("):
+			args, err := p.list(")")
+			if err != nil {
+				return nil, err
+			}
+			x = callExpr{callee: x, args: args}
+		default:
+			return x, nil
+		}
+	}
+}
+
+// list parses the comma separated expressions up to end.
+func (p *parser) list(end string) ([]node, error) {
+	var elements []node
+	for !p.accept(end) {
+		if len(elements) > 0 {
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+			if p.accept(end) {
+				break
+			}
+		}
+		x, err := p.assignment()
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, x)
+	}
+	return elements, nil
+}
+
+func (p *parser) primary() (node, error) {
+	t := p.peek()
+	switch t.kind {
+	case tokenNumber:
+		p.next()
+		return literal{value: t.num}, nil
+	case tokenString:
+		p.next()
+		return literal{value: t.text}, nil
+	case tokenIdent:
+		p.next()
+		switch t.text {
+		case "true":
+			return literal{value: true}, nil
+		case "false":
+			return literal{value: false}, nil
+		case "null", "undefined":
+			return literal{value: nil}, nil
+		case "function":
+			return p.function(false)
+		}
+		return identifier{name: t.text}, nil
+	case tokenPunct:
+		switch {
+		case p.accept("("):
+			x, err := p.expression()
+			if err != nil {
+				return nil, err
+			}
+			return x, p.expect(")")
+		case p.accept("["):
+			elements, err := p.list("]")
+			if err != nil {
+				return nil, err
+			}
+			return arrayLit{elements: elements}, nil
+		}
+	}
+	return nil, p.unexpected()
+}
@@ -0,0 +1,171 @@
+package pac
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// maxFileSize bounds the size of the PAC file
+	maxFileSize  = 1 << 20
+	fetchTimeout = 10 * time.Second
+	// refreshInterval is how often the PAC file is fetched again, the managed environments serving it change it
+	// without notice
+	refreshInterval = time.Hour
+	// cacheTTL is how long the proxies selected for an address are used before the PAC file is evaluated again
+	cacheTTL = 5 * time.Minute
+)
+
+// Selector selects the proxies to reach addresses through with a PAC file, caching the result of each address.
+type Selector struct {
+	location string
+	log      *zerolog.Logger
+	// lookupIP and localIP are replaced in tests
+	lookupIP func(ctx context.Context, host string) ([]net.IP, error)
+	localIP  func(addr *net.TCPAddr) net.IP
+
+	lock     sync.Mutex
+	script   *Script
+	loadedAt time.Time
+	cache    map[string]selection
+}
+
+type selection struct {
+	proxies []string
+	expires time.Time
+}
+
+// NewSelector loads the PAC file at location, an http(s):// or file:// URL or a path.
+func NewSelector(ctx context.Context, location string, log *zerolog.Logger) (*Selector, error) {
+	s := &Selector{
+		location: location,
+		log:      log,
+		lookupIP: func(ctx context.Context, host string) ([]net.IP, error) {
+			return net.DefaultResolver.LookupIP(ctx, "ip", host)
+		},
+		localIP: routeLocalIP,
+		cache:   map[string]selection{},
+	}
+	script, err := s.fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s.script, s.loadedAt = script, time.Now()
+	return s, nil
+}
+
+// ProxiesFor returns the proxy URLs to reach addr through in order of preference, an empty one for a direct
+// connection.
+func (s *Selector) ProxiesFor(ctx context.Context, addr *net.TCPAddr) ([]string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.loadedAt) >= refreshInterval {
+		s.refresh(ctx, now)
+	}
+	key := addr.String()
+	if cached, ok := s.cache[key]; ok && now.Before(cached.expires) {
+		return cached.proxies, nil
+	}
+
+	env := Env{
+		LookupIP: func(host string) []net.IP {
+			ips, err := s.lookupIP(ctx, host)
+			if err != nil {
+				return nil
+			}
+			return ips
+		},
+		MyIP: s.localIP(addr),
+		Now:  now,
+	}
+	host := addr.IP.String()
+	result, err := s.script.FindProxyForURL(env, fmt.Sprintf("https://%s/", key), host)
+	if err != nil {
+		return nil, err
+	}
+	proxies, err := ParseResult(result)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid result of the PAC file for %s", key)
+	}
+	s.cache[key] = selection{proxies: proxies, expires: now.Add(cacheTTL)}
+	return proxies, nil
+}
+
+// refresh fetches the PAC file again, keeping the previous one if that fails.
+func (s *Selector) refresh(ctx context.Context, now time.Time) {
+	s.loadedAt = now
+	script, err := s.fetch(ctx)
+	if err != nil {
+		s.log.Warn().Err(err).Str("location", s.location).Msg("Failed to refresh the PAC file, using the previous one")
+		return
+	}
+	s.script = script
+	s.cache = map[string]selection{}
+}
+
+func (s *Selector) fetch(ctx context.Context) (*Script, error) {
+	var body io.ReadCloser
+	u, err := url.Parse(s.location)
+	switch {
+	case err == nil && (u.Scheme == "http" || u.Scheme == "https"):
+		ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.location, nil)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid PAC file URL")
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to fetch the PAC file")
+		}
+		if resp.StatusCode != http.StatusOK {
+			_ = resp.Body.Close()
+			return nil, errors.Errorf("failed to fetch the PAC file: %s", resp.Status)
+		}
+		body = resp.Body
+	default:
+		path := s.location
+		if err == nil && u.Scheme == "file" {
+			path = u.Path
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read the PAC file")
+		}
+		body = file
+	}
+	defer body.Close()
+
+	src, err := io.ReadAll(io.LimitReader(body, maxFileSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the PAC file")
+	}
+	if len(src) > maxFileSize {
+		return nil, errors.Errorf("the PAC file is larger than %d bytes", maxFileSize)
+	}
+	return Compile(strings.TrimPrefix(string(src), "\ufeff"))
+}
+
+// routeLocalIP returns the local address of the route to addr, which myIpAddress returns. Dialing UDP doesn't send
+// anything.
+func routeLocalIP(addr *net.TCPAddr) net.IP {
+	conn, err := net.Dial("udp", net.JoinHostPort(addr.IP.String(), "443"))
+	if err != nil {
+		return nil
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).IP
+}
@@ -0,0 +1,125 @@
+package edgediscovery
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/proxy"
+)
+
+// ProxyTLSConfigs 是 HTTPS 代理的 TLS 配置，按代理的 host:port 区分，"*" 适用于其他所有代理。
+// 企业的 TLS 检查网关可能要求客户端证书，它与连接 Edge 的 TLS 凭据相互独立
+type ProxyTLSConfigs map[string]*tls.Config
+
+// AnyProxy is the key of the ProxyTLSConfigs of the proxies without their own configuration
+const AnyProxy = "*"
+
+// For returns a copy of the TLS configuration of the proxy at hostport, to connect to it as serverName unless the
+// configuration sets another one.
+func (c ProxyTLSConfigs) For(hostport, serverName string) *tls.Config {
+	config, ok := c[hostport]
+	if !ok {
+		config = c[AnyProxy]
+	}
+	if config == nil {
+		config = &tls.Config{}
+	}
+	config = config.Clone()
+	if config.ServerName == "" {
+		config.ServerName = serverName
+	}
+	return config
+}
+
+// dialViaHTTPProxy 通过 HTTP CONNECT 代理建立连接，https 代理先完成与代理的 TLS 握手（可出示客户端证书）
+func dialViaHTTPProxy(ctx context.Context, u *url.URL, auth *proxy.Auth, tlsConfigs ProxyTLSConfigs, address string, localIP net.IP) (net.Conn, error) {
+	proxyAddr := u.Host
+	if u.Port() == "" {
+		port := "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+		proxyAddr = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	dialer := &net.Dialer{}
+	if localIP != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: localIP, Port: 0}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", proxyAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "proxy dial failed")
+	}
+	// 握手和 CONNECT 请求同样受 ctx 约束
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+	defer stop()
+
+	if u.Scheme == "https" {
+		tlsConn := tls.Client(conn, tlsConfigs.For(proxyAddr, u.Hostname()))
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			_ = conn.Close()
+			return nil, errors.Wrap(err, "TLS handshake with the proxy failed")
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+	if auth != nil {
+		credentials := base64.StdEncoding.EncodeToString([]byte(auth.User + ":" + auth.Password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "failed to send the CONNECT request to the proxy")
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "failed to read the CONNECT response of the proxy")
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		_ = conn.Close()
+		return nil, fmt.Errorf("proxy refused to CONNECT to %s: %s", address, resp.Status)
+	}
+
+	if !stop() {
+		// ctx 已结束，截止时间已被提前
+		_ = conn.Close()
+		return nil, ctx.Err()
+	}
+	_ = conn.SetDeadline(time.Time{})
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn 保留读取 CONNECT 响应时多读的数据
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
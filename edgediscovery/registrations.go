@@ -0,0 +1,126 @@
+package edgediscovery
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// RegistrationGuard tracks the edge IPs hosting the registrations of this process, so that two connections aren't
+// given the same IP: the edge refuses the second registration as a duplicate. With a lock directory, sibling
+// processes on the host sharing it are guarded too: each registration holds a lock file named after its IP for as
+// long as it lasts, and the lock goes away with the process if it dies. A nil RegistrationGuard guards nothing.
+type RegistrationGuard struct {
+	dir string
+
+	mu   sync.Mutex
+	held map[int]heldRegistration
+}
+
+type heldRegistration struct {
+	ip string
+	// lock is the lock file held for sibling processes, nil without a lock directory
+	lock *os.File
+}
+
+// NewRegistrationGuard returns a RegistrationGuard sharing the lock files in dir with sibling processes, or guarding
+// only this process if dir is empty. On error, the returned guard still guards this process.
+func NewRegistrationGuard(dir string) (*RegistrationGuard, error) {
+	g := &RegistrationGuard{held: make(map[int]heldRegistration)}
+	if dir == "" {
+		return g, nil
+	}
+	if !lockFilesSupported {
+		return g, errors.New("edge registration lock files are only supported on Unix")
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return g, errors.Wrap(err, "failed to create the edge registration lock directory")
+	}
+	g.dir = dir
+	return g, nil
+}
+
+// Taken returns whether a connection other than connIndex, of this process or of a sibling, is registered on ip.
+func (g *RegistrationGuard) Taken(connIndex int, ip net.IP) bool {
+	if g == nil {
+		return false
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for index, held := range g.held {
+		if held.ip == ip.String() {
+			return index != connIndex
+		}
+	}
+	if g.dir == "" {
+		return false
+	}
+	lock, err := g.tryLock(ip)
+	if err != nil {
+		return errors.Is(err, errLocked)
+	}
+	_ = lock.Close()
+	return false
+}
+
+// Hold notes that connIndex registered on ip, until Release. Returns an error if a sibling process holds ip too,
+// the connection is still held by this process.
+func (g *RegistrationGuard) Hold(connIndex int, ip net.IP) error {
+	if g == nil {
+		return nil
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.releaseLocked(connIndex)
+	held := heldRegistration{ip: ip.String()}
+	var err error
+	if g.dir != "" {
+		held.lock, err = g.tryLock(ip)
+		if errors.Is(err, errLocked) {
+			err = errors.Errorf("a sibling cloudflared is registered on %s too", ip)
+		}
+	}
+	g.held[connIndex] = held
+	return err
+}
+
+// Release notes that connIndex is no longer registered.
+func (g *RegistrationGuard) Release(connIndex int) {
+	if g == nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.releaseLocked(connIndex)
+}
+
+func (g *RegistrationGuard) releaseLocked(connIndex int) {
+	held, ok := g.held[connIndex]
+	if !ok {
+		return
+	}
+	delete(g.held, connIndex)
+	if held.lock != nil {
+		// Closing the file releases its lock. The file is left for the next registration on the IP.
+		_ = held.lock.Close()
+	}
+}
+
+// tryLock opens the lock file of ip and locks it without waiting. Returns errLocked if another process holds it.
+func (g *RegistrationGuard) tryLock(ip net.IP) (*os.File, error) {
+	// IPv6 addresses are named with dashes, colons aren't valid in file names everywhere
+	name := strings.ReplaceAll(ip.String(), ":", "-") + ".lock"
+	f, err := os.OpenFile(filepath.Join(g.dir, name), os.O_RDWR|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	if err := lockFile(f); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return f, nil
+}
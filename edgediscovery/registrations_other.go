@@ -0,0 +1,17 @@
+//go:build !unix
+
+package edgediscovery
+
+import (
+	"errors"
+	"os"
+)
+
+// Lock files are only supported on Unix, registrations are only guarded within the process elsewhere.
+const lockFilesSupported = false
+
+var errLocked = errors.New("locked by another process")
+
+func lockFile(*os.File) error {
+	return errors.ErrUnsupported
+}
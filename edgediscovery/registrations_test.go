@@ -0,0 +1,84 @@
+package edgediscovery
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+)
+
+func TestRegistrationGuard(t *testing.T) {
+	guard, err := NewRegistrationGuard("")
+	require.NoError(t, err)
+	ip := net.ParseIP("198.41.200.1")
+
+	require.NoError(t, guard.Hold(0, ip))
+	assert.False(t, guard.Taken(0, ip))
+	assert.True(t, guard.Taken(1, ip))
+	assert.False(t, guard.Taken(1, net.ParseIP("198.41.200.2")))
+	guard.Release(0)
+	assert.False(t, guard.Taken(1, ip))
+
+	var disabled *RegistrationGuard
+	require.NoError(t, disabled.Hold(0, ip))
+	assert.False(t, disabled.Taken(1, ip))
+	disabled.Release(0)
+}
+
+func TestRegistrationGuardSiblings(t *testing.T) {
+	if !lockFilesSupported {
+		t.Skip("lock files are only supported on Unix")
+	}
+	dir := t.TempDir()
+	// Lock files are locked per open file, so two guards of this process stand for sibling processes
+	guard, err := NewRegistrationGuard(dir)
+	require.NoError(t, err)
+	sibling, err := NewRegistrationGuard(dir)
+	require.NoError(t, err)
+	ip := net.ParseIP("2606:4700:a0::1")
+
+	require.NoError(t, sibling.Hold(0, ip))
+	assert.True(t, guard.Taken(0, ip))
+	assert.Error(t, guard.Hold(0, ip))
+	guard.Release(0)
+
+	sibling.Release(0)
+	assert.False(t, guard.Taken(0, ip))
+	require.NoError(t, guard.Hold(0, ip))
+	assert.True(t, sibling.Taken(0, ip))
+}
+
+func TestEdgeAvoidsTakenAddrs(t *testing.T) {
+	if !lockFilesSupported {
+		t.Skip("lock files are only supported on Unix")
+	}
+	dir := t.TempDir()
+	sibling, err := NewRegistrationGuard(dir)
+	require.NoError(t, err)
+	guard, err := NewRegistrationGuard(dir)
+	require.NoError(t, err)
+	edge := MockEdge(&testLogger, []*allregions.EdgeAddr{&addr0, &addr1})
+	edge.UseRegistrationGuard(guard)
+
+	// A sibling process is registered on addr0, the connection is given addr1
+	require.NoError(t, sibling.Hold(0, addr0.UDP.IP))
+	addr, err := edge.GetAddr(0)
+	require.NoError(t, err)
+	assert.Equal(t, &addr1, addr)
+	edge.Registered(0, addr, 0)
+	// addr0 was given back
+	assert.Equal(t, 1, edge.AvailableAddrs())
+
+	// With every Addr taken, the connection still gets one
+	addr, err = edge.GetAddr(1)
+	require.NoError(t, err)
+	assert.Equal(t, &addr0, addr)
+
+	// Once unregistered, the Addr of the connection is free for the sibling
+	assert.True(t, sibling.Taken(1, addr1.UDP.IP))
+	edge.Unregistered(0)
+	assert.False(t, sibling.Taken(1, addr1.UDP.IP))
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+package edgediscovery
+
+import (
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+const lockFilesSupported = true
+
+var errLocked = errors.New("locked by another process")
+
+// lockFile takes an exclusive lock on f without waiting, released when f is closed or the process exits.
+func lockFile(f *os.File) error {
+	err := unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if errors.Is(err, unix.EWOULDBLOCK) {
+		return errLocked
+	}
+	return err
+}
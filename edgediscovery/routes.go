@@ -0,0 +1,36 @@
+package edgediscovery
+
+import (
+	"net"
+)
+
+// Addresses of the Cloudflare edge that are looked up a route for. Any public address would do, these are the
+// ones the tunnel connects to.
+var (
+	routeProbeV4 = &net.UDPAddr{IP: net.ParseIP("198.41.192.7"), Port: 7844}
+	routeProbeV6 = &net.UDPAddr{IP: net.ParseIP("2606:4700:a0::1"), Port: 7844}
+)
+
+// IPRoutes tells which IP versions the host can reach the edge with.
+type IPRoutes struct {
+	V4 bool
+	V6 bool
+}
+
+// DetectIPRoutes checks whether the host has an IPv4 and an IPv6 route to the edge, e.g. an IPv6-only host has
+// no IPv4 default route. Dialing UDP only looks up a route, nothing is sent.
+func DetectIPRoutes() IPRoutes {
+	return IPRoutes{
+		V4: hasRoute("udp4", routeProbeV4),
+		V6: hasRoute("udp6", routeProbeV6),
+	}
+}
+
+func hasRoute(network string, addr *net.UDPAddr) bool {
+	conn, err := net.DialUDP(network, nil, addr)
+	if err != nil {
+		return false
+	}
+	_ = conn.Close()
+	return true
+}
@@ -0,0 +1,139 @@
+package edgediscovery
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+)
+
+const (
+	// Stats of edge IPs that weren't used for this long are dropped, the path to them may well have changed
+	scorecardMaxAge = 7 * 24 * time.Hour
+	// Once an edge IP has this many attempts its stats are halved, so that recent behaviour outweighs old one
+	scorecardDecayAttempts = 100
+	// Connections failing sooner than this after registering are penalized
+	scorecardLifetimeScale = 5 * time.Minute
+)
+
+// Scorecard keeps per edge IP stats of how connections to it went: how often the handshake succeeded, how long
+// registering took and how long connections lived before failing. It ranks edge IPs by these stats, so that
+// chronically bad colos or paths from this network are de-prioritized when handing out addresses. If it has a
+// path, the stats are persisted there to carry over across restarts.
+// A nil Scorecard records nothing.
+type Scorecard struct {
+	path string
+	now  func() time.Time
+
+	mu    sync.Mutex
+	stats map[string]*edgeIPStats
+}
+
+type edgeIPStats struct {
+	Attempts          int           `json:"attempts"`
+	Registrations     int           `json:"registrations"`
+	RegistrationTime  time.Duration `json:"registrationTime"`
+	Failures          int           `json:"failures"`
+	LifetimeOnFailure time.Duration `json:"lifetimeOnFailure"`
+	LastUsed          time.Time     `json:"lastUsed"`
+}
+
+// NewScorecard creates a Scorecard, loading the stats persisted at path if it isn't empty. On error the
+// Scorecard starts empty and is returned along with the error, so that callers can still use it.
+func NewScorecard(path string) (*Scorecard, error) {
+	sc := &Scorecard{
+		path:  path,
+		now:   time.Now,
+		stats: make(map[string]*edgeIPStats),
+	}
+	if path == "" {
+		return sc, nil
+	}
+	var stats map[string]*edgeIPStats
+	if err := readStateFile(path, &stats); err != nil {
+		return sc, errors.Wrap(err, "failed to load edge scorecard")
+	}
+	for ip, s := range stats {
+		if s != nil && sc.now().Sub(s.LastUsed) < scorecardMaxAge {
+			sc.stats[ip] = s
+		}
+	}
+	return sc, nil
+}
+
+// Score implements allregions.Scorer. An edge IP without stats scores 0.5, one whose connections always
+// register quickly and last scores close to 1, and one whose handshakes keep failing scores close to 0.
+func (sc *Scorecard) Score(addr *allregions.EdgeAddr) float64 {
+	if sc == nil {
+		return 0.5
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	s, ok := sc.stats[addr.UDP.IP.String()]
+	if !ok {
+		return 0.5
+	}
+	// Smoothed so that a single attempt doesn't make an edge IP perfect or hopeless
+	score := float64(s.Registrations+1) / float64(s.Attempts+2)
+	if s.Registrations > 0 {
+		meanRegistration := s.RegistrationTime / time.Duration(s.Registrations)
+		score /= 1 + meanRegistration.Seconds()
+	}
+	if s.Failures > 0 {
+		meanLifetime := s.LifetimeOnFailure / time.Duration(s.Failures)
+		score *= float64(meanLifetime+scorecardLifetimeScale/10) / float64(meanLifetime+scorecardLifetimeScale)
+	}
+	return score
+}
+
+// RecordHandshakeFailure records that connecting to ip failed before the connection registered.
+func (sc *Scorecard) RecordHandshakeFailure(ip string) error {
+	return sc.record(ip, func(s *edgeIPStats) {
+		s.Attempts++
+	})
+}
+
+// RecordRegistration records that a connection to ip registered, registrationTime after it started connecting.
+func (sc *Scorecard) RecordRegistration(ip string, registrationTime time.Duration) error {
+	return sc.record(ip, func(s *edgeIPStats) {
+		s.Attempts++
+		s.Registrations++
+		s.RegistrationTime += registrationTime
+	})
+}
+
+// RecordFailure records that a registered connection to ip failed after lifetime.
+func (sc *Scorecard) RecordFailure(ip string, lifetime time.Duration) error {
+	return sc.record(ip, func(s *edgeIPStats) {
+		s.Failures++
+		s.LifetimeOnFailure += lifetime
+	})
+}
+
+func (sc *Scorecard) record(ip string, update func(*edgeIPStats)) error {
+	if sc == nil {
+		return nil
+	}
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	s, ok := sc.stats[ip]
+	if !ok {
+		s = &edgeIPStats{}
+		sc.stats[ip] = s
+	}
+	update(s)
+	s.LastUsed = sc.now()
+	if s.Attempts >= scorecardDecayAttempts {
+		s.Attempts /= 2
+		s.Registrations /= 2
+		s.RegistrationTime /= 2
+		s.Failures /= 2
+		s.LifetimeOnFailure /= 2
+	}
+	if sc.path == "" {
+		return nil
+	}
+	return errors.Wrap(writeStateFile(sc.path, sc.stats), "failed to save edge scorecard")
+}
@@ -0,0 +1,96 @@
+package edgediscovery
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+)
+
+func TestScorecardScore(t *testing.T) {
+	sc, err := NewScorecard("")
+	require.NoError(t, err)
+
+	// Without stats every edge IP is equal
+	assert.Equal(t, 0.5, sc.Score(&addr0))
+
+	// Registering quickly and staying up beats the unknown
+	for i := 0; i < 5; i++ {
+		require.NoError(t, sc.RecordRegistration(addr0.UDP.IP.String(), 100*time.Millisecond))
+	}
+	assert.Greater(t, sc.Score(&addr0), 0.5)
+
+	// Failing handshakes fall below the unknown
+	for i := 0; i < 5; i++ {
+		require.NoError(t, sc.RecordHandshakeFailure(addr1.UDP.IP.String()))
+	}
+	assert.Less(t, sc.Score(&addr1), 0.5)
+
+	// Registering slowly is worse than registering quickly
+	for i := 0; i < 5; i++ {
+		require.NoError(t, sc.RecordRegistration(addr2.UDP.IP.String(), 3*time.Second))
+	}
+	assert.Less(t, sc.Score(&addr2), sc.Score(&addr0))
+
+	// Connections that fail soon after registering are worse than ones that last
+	for i := 0; i < 5; i++ {
+		require.NoError(t, sc.RecordRegistration(addr3.UDP.IP.String(), 100*time.Millisecond))
+		require.NoError(t, sc.RecordFailure(addr3.UDP.IP.String(), 10*time.Second))
+	}
+	assert.Less(t, sc.Score(&addr3), sc.Score(&addr0))
+}
+
+func TestScorecardDecay(t *testing.T) {
+	sc, err := NewScorecard("")
+	require.NoError(t, err)
+	for i := 0; i < 3*scorecardDecayAttempts; i++ {
+		require.NoError(t, sc.RecordHandshakeFailure(addr0.UDP.IP.String()))
+	}
+	assert.Less(t, sc.stats[addr0.UDP.IP.String()].Attempts, scorecardDecayAttempts)
+
+	// A bad edge IP recovers once it starts working again
+	for i := 0; i < scorecardDecayAttempts; i++ {
+		require.NoError(t, sc.RecordRegistration(addr0.UDP.IP.String(), 100*time.Millisecond))
+	}
+	assert.Greater(t, sc.Score(&addr0), 0.5)
+}
+
+func TestScorecardPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scorecard.json")
+	sc, err := NewScorecard(path)
+	require.NoError(t, err)
+	require.NoError(t, sc.RecordRegistration(addr0.UDP.IP.String(), 100*time.Millisecond))
+	require.NoError(t, sc.RecordHandshakeFailure(addr1.UDP.IP.String()))
+	sc.now = func() time.Time { return time.Now().Add(-2 * scorecardMaxAge) }
+	require.NoError(t, sc.RecordHandshakeFailure(addr2.UDP.IP.String()))
+
+	restarted, err := NewScorecard(path)
+	require.NoError(t, err)
+	assert.Equal(t, sc.Score(&addr0), restarted.Score(&addr0))
+	assert.Equal(t, sc.Score(&addr1), restarted.Score(&addr1))
+	// Stale stats are dropped
+	assert.Equal(t, 0.5, restarted.Score(&addr2))
+}
+
+func TestGetAddrPrefersBestScore(t *testing.T) {
+	edge := MockEdge(&testLogger, []*allregions.EdgeAddr{&addr0, &addr1, &addr2, &addr3})
+	sc, err := NewScorecard("")
+	require.NoError(t, err)
+	edge.UseScorecard(sc)
+
+	// Addresses are still spread across both regions, but within each region the best one is picked
+	edge.Registered(0, &addr0, 100*time.Millisecond)
+	edge.Registered(0, &addr1, 100*time.Millisecond)
+	edge.HandshakeFailed(&addr2)
+	edge.HandshakeFailed(&addr3)
+
+	first, err := edge.GetAddr(0)
+	require.NoError(t, err)
+	second, err := edge.GetAddr(1)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []*allregions.EdgeAddr{&addr0, &addr1}, []*allregions.EdgeAddr{first, second})
+}
@@ -0,0 +1,338 @@
+package edgediscovery
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha1" // nolint: gosec
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	defaultSSHPort = "22"
+	// sshKeepaliveInterval 是向跳板机发送保活请求的间隔，跳板机无响应时关闭 SSH 连接，之后的拨号重新建立连接
+	sshKeepaliveInterval = 30 * time.Second
+	sshKeepaliveTimeout  = 15 * time.Second
+)
+
+// SSHJumpHost 通过 SSH 跳板机（ssh://user@bastion:port）的 direct-tcpip 通道连接边缘，
+// 用于仅允许 SSH 出站到跳板机的网络。到同一跳板机的所有边缘连接共用一个 SSH 连接
+type SSHJumpHost struct {
+	signer          ssh.Signer
+	hostKeyCallback ssh.HostKeyCallback
+
+	lock    sync.Mutex
+	clients map[string]*sshJumpClient
+}
+
+type sshJumpClient struct {
+	*ssh.Client
+	conn net.Conn
+}
+
+// NewSSHJumpHost 创建使用私钥 signer 认证、使用 hostKeyCallback 校验跳板机主机密钥的 SSH 跳板机
+func NewSSHJumpHost(signer ssh.Signer, hostKeyCallback ssh.HostKeyCallback) *SSHJumpHost {
+	return &SSHJumpHost{
+		signer:          signer,
+		hostKeyCallback: hostKeyCallback,
+		clients:         map[string]*sshJumpClient{},
+	}
+}
+
+// dial 以 user（代理 URL 中的用户名或代理用户名）的身份经由跳板机 u 建立到 address 的连接
+func (j *SSHJumpHost) dial(ctx context.Context, u *url.URL, user string, address string, localIP net.IP) (net.Conn, error) {
+	if j == nil {
+		return nil, errors.New("ssh proxy requires a private key to authenticate with the jump host")
+	}
+	if user == "" {
+		return nil, errors.New("ssh proxy requires a user, e.g. ssh://user@bastion")
+	}
+	jumpAddr := u.Host
+	if u.Port() == "" {
+		jumpAddr = net.JoinHostPort(u.Hostname(), defaultSSHPort)
+	}
+	client, err := j.client(ctx, user, jumpAddr, localIP)
+	if err != nil {
+		return nil, err
+	}
+	channel, err := client.DialContext(ctx, "tcp", address)
+	if err != nil {
+		// SSH 连接可能已失效，关闭后下次拨号重新建立
+		j.forget(user+"@"+jumpAddr, client)
+		return nil, errors.Wrap(err, "jump host failed to connect to the edge")
+	}
+	return &sshChannelConn{Conn: channel, jump: client.conn}, nil
+}
+
+// client 返回到跳板机的 SSH 连接，没有可用连接时建立一个
+func (j *SSHJumpHost) client(ctx context.Context, user, jumpAddr string, localIP net.IP) (*sshJumpClient, error) {
+	key := user + "@" + jumpAddr
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if client, ok := j.clients[key]; ok {
+		return client, nil
+	}
+
+	dialer := &net.Dialer{}
+	if localIP != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: localIP, Port: 0}
+	}
+	conn, err := dialer.DialContext(ctx, "tcp", jumpAddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to connect to the jump host")
+	}
+	// SSH 握手同样受 ctx 约束
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() {
+		_ = conn.SetDeadline(time.Unix(1, 0))
+	})
+	config := &ssh.ClientConfig{
+		User:            user,
+		Auth:            []ssh.AuthMethod{ssh.PublicKeys(j.signer)},
+		HostKeyCallback: j.hostKeyCallback,
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, jumpAddr, config)
+	if !stop() {
+		if err == nil {
+			_ = sshConn.Close()
+		}
+		_ = conn.Close()
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, errors.Wrap(err, "SSH handshake with the jump host failed")
+	}
+	_ = conn.SetDeadline(time.Time{})
+
+	client := &sshJumpClient{Client: ssh.NewClient(sshConn, chans, reqs), conn: conn}
+	j.clients[key] = client
+	go func() {
+		_ = client.Wait()
+		j.forget(key, client)
+	}()
+	go client.keepalive()
+	return client, nil
+}
+
+// forget 关闭并移除失效的 SSH 连接
+func (j *SSHJumpHost) forget(key string, client *sshJumpClient) {
+	_ = client.Close()
+	j.lock.Lock()
+	defer j.lock.Unlock()
+	if j.clients[key] == client {
+		delete(j.clients, key)
+	}
+}
+
+// keepalive 定期向跳板机发送保活请求，跳板机无响应时关闭连接
+func (c *sshJumpClient) keepalive() {
+	ticker := time.NewTicker(sshKeepaliveInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		timer := time.AfterFunc(sshKeepaliveTimeout, func() {
+			_ = c.Close()
+		})
+		_, _, err := c.SendRequest("keepalive@openssh.com", true, nil)
+		timer.Stop()
+		if err != nil {
+			_ = c.Close()
+			return
+		}
+	}
+}
+
+// sshChannelConn 是到边缘的 direct-tcpip 通道，与其他代理一样以到跳板机的连接地址作为本地和远端地址
+type sshChannelConn struct {
+	net.Conn
+	jump net.Conn
+}
+
+func (c *sshChannelConn) LocalAddr() net.Addr {
+	return c.jump.LocalAddr()
+}
+
+func (c *sshChannelConn) RemoteAddr() net.Addr {
+	return c.jump.RemoteAddr()
+}
+
+// SSHHostKeyCallback 返回校验跳板机主机密钥的函数：主机密钥的 SHA256 指纹（如 ssh-keygen -lf 的输出
+// SHA256:...）在 fingerprints 中，或者与 known_hosts 格式的 knownHostsFile 中该主机的记录一致
+func SSHHostKeyCallback(knownHostsFile string, fingerprints []string) (ssh.HostKeyCallback, error) {
+	for _, fingerprint := range fingerprints {
+		if !strings.HasPrefix(fingerprint, "SHA256:") {
+			return nil, fmt.Errorf("invalid host key fingerprint %q, expected SHA256:...", fingerprint)
+		}
+	}
+	var knownHosts []knownHost
+	if knownHostsFile != "" {
+		var err error
+		if knownHosts, err = readKnownHosts(knownHostsFile); err != nil {
+			return nil, err
+		}
+	}
+	if len(knownHosts) == 0 && len(fingerprints) == 0 {
+		return nil, errors.New("the host key of the jump host can't be verified without known hosts or host key fingerprints")
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		fingerprint := ssh.FingerprintSHA256(key)
+		for _, expected := range fingerprints {
+			if fingerprint == expected {
+				return nil
+			}
+		}
+		candidates := knownHostNames(hostname, remote)
+		found := false
+		for _, entry := range knownHosts {
+			if !entry.matches(candidates) {
+				continue
+			}
+			sameKey := bytes.Equal(entry.key.Marshal(), key.Marshal())
+			if entry.revoked && sameKey {
+				return fmt.Errorf("the host key %s of %s is revoked", fingerprint, hostname)
+			}
+			if !entry.revoked && entry.key.Type() == key.Type() {
+				if sameKey {
+					found = true
+				} else {
+					return fmt.Errorf("the host key %s of %s doesn't match the known hosts, the jump host may be impersonated", fingerprint, hostname)
+				}
+			}
+		}
+		if !found {
+			return fmt.Errorf("the host key %s of %s is unknown", fingerprint, hostname)
+		}
+		return nil
+	}, nil
+}
+
+type knownHost struct {
+	patterns []string
+	key      ssh.PublicKey
+	revoked  bool
+}
+
+// readKnownHosts 读取 known_hosts 文件，@cert-authority 记录不受支持，会被忽略
+func readKnownHosts(path string) ([]knownHost, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the known hosts")
+	}
+	var entries []knownHost
+	for rest := content; len(rest) > 0; {
+		var marker string
+		var hosts []string
+		var key ssh.PublicKey
+		marker, hosts, key, _, rest, err = ssh.ParseKnownHosts(rest)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid known hosts %s", path)
+		}
+		if marker == "cert-authority" {
+			continue
+		}
+		entries = append(entries, knownHost{patterns: hosts, key: key, revoked: marker == "revoked"})
+	}
+	return entries, nil
+}
+
+// knownHostNames 返回跳板机在 known_hosts 中可能的名称：主机名和 IP 地址，非 22 端口时为 [host]:port
+func knownHostNames(hostname string, remote net.Addr) []string {
+	var names []string
+	add := func(hostport string) {
+		host, port, err := net.SplitHostPort(hostport)
+		if err != nil {
+			return
+		}
+		if port == defaultSSHPort {
+			names = append(names, host)
+		} else {
+			names = append(names, "["+host+"]:"+port)
+		}
+	}
+	add(hostname)
+	if tcpAddr, ok := remote.(*net.TCPAddr); ok {
+		add(net.JoinHostPort(tcpAddr.IP.String(), strconv.Itoa(tcpAddr.Port)))
+	}
+	return names
+}
+
+// matches 按 sshd(8) 的规则匹配主机名：支持通配符、!否定和 |1|salt|hash 形式的哈希主机名
+func (h knownHost) matches(names []string) bool {
+	matched := false
+	for _, name := range names {
+		for _, pattern := range h.patterns {
+			negated := strings.HasPrefix(pattern, "!")
+			if knownHostMatches(strings.TrimPrefix(pattern, "!"), name) {
+				if negated {
+					return false
+				}
+				matched = true
+			}
+		}
+	}
+	return matched
+}
+
+func knownHostMatches(pattern, name string) bool {
+	if hashed, ok := strings.CutPrefix(pattern, "|1|"); ok {
+		encodedSalt, encodedHash, ok := strings.Cut(hashed, "|")
+		if !ok {
+			return false
+		}
+		salt, err := base64.StdEncoding.DecodeString(encodedSalt)
+		if err != nil {
+			return false
+		}
+		hash, err := base64.StdEncoding.DecodeString(encodedHash)
+		if err != nil {
+			return false
+		}
+		mac := hmac.New(sha1.New, salt)
+		mac.Write([]byte(name))
+		return hmac.Equal(mac.Sum(nil), hash)
+	}
+	return wildcardMatch(strings.ToLower(pattern), strings.ToLower(name))
+}
+
+// wildcardMatch 匹配 * 和 ? 通配符，其他字符（包括 [host]:port 中的方括号）按字面匹配
+func wildcardMatch(pattern, name string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(name); i >= 0; i-- {
+				if wildcardMatch(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if name == "" {
+				return false
+			}
+		default:
+			if name == "" || name[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return name == ""
+}
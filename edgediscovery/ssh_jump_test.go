@@ -0,0 +1,180 @@
+package edgediscovery
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/net/proxy"
+)
+
+func newSigner(t *testing.T) ssh.Signer {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(key)
+	require.NoError(t, err)
+	return signer
+}
+
+// jumpHost serves SSH on a local port, forwarding the direct-tcpip channels of the clients authenticating with
+// clientKey as user.
+func jumpHost(t *testing.T, hostKey ssh.Signer, user string, clientKey ssh.PublicKey) net.Listener {
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if conn.User() == user && string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("unknown key for %s", conn.User())
+		},
+	}
+	config.AddHostKey(hostKey)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				_, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					_ = conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for newChannel := range chans {
+					var target struct {
+						Host     string
+						Port     uint32
+						OrigHost string
+						OrigPort uint32
+					}
+					if newChannel.ChannelType() != "direct-tcpip" || ssh.Unmarshal(newChannel.ExtraData(), &target) != nil {
+						_ = newChannel.Reject(ssh.UnknownChannelType, "only direct-tcpip is supported")
+						continue
+					}
+					upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, fmt.Sprint(target.Port)))
+					if err != nil {
+						_ = newChannel.Reject(ssh.ConnectionFailed, err.Error())
+						continue
+					}
+					channel, requests, err := newChannel.Accept()
+					if err != nil {
+						_ = upstream.Close()
+						continue
+					}
+					go ssh.DiscardRequests(requests)
+					go func() {
+						_, _ = io.Copy(upstream, channel)
+						_ = upstream.Close()
+					}()
+					go func() {
+						_, _ = io.Copy(channel, upstream)
+						_ = channel.Close()
+					}()
+				}
+			}()
+		}
+	}()
+	return listener
+}
+
+func TestDialEdgeThroughSSHJumpHost(t *testing.T) {
+	edge := httptest.NewTLSServer(http.NotFoundHandler())
+	defer edge.Close()
+	edgeAddr := edge.Listener.Addr().(*net.TCPAddr)
+
+	hostKey, clientKey := newSigner(t), newSigner(t)
+	bastion := jumpHost(t, hostKey, "tunnel", clientKey.PublicKey())
+	proxyURL := "ssh://" + bastion.Addr().String()
+	auth := &proxy.Auth{User: "tunnel"}
+
+	hostKeyCallback, err := SSHHostKeyCallback("", []string{ssh.FingerprintSHA256(hostKey.PublicKey())})
+	require.NoError(t, err)
+	jump := NewSSHJumpHost(clientKey, hostKeyCallback)
+	for i := 0; i < 2; i++ {
+		conn, err := DialEdgeWithProxy(context.Background(), time.Second, &tls.Config{InsecureSkipVerify: true}, edgeAddr, nil,
+			proxyURL, auth, nil, jump, nil, false, TCPOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, bastion.Addr().String(), conn.RemoteAddr().String())
+		require.NoError(t, conn.Close())
+	}
+	// The edge connections share the SSH connection
+	assert.Len(t, jump.clients, 1)
+
+	// A jump host with another host key is rejected
+	otherHostKey, err := SSHHostKeyCallback("", []string{ssh.FingerprintSHA256(newSigner(t).PublicKey())})
+	require.NoError(t, err)
+	_, err = DialEdgeWithProxy(context.Background(), time.Second, &tls.Config{InsecureSkipVerify: true}, edgeAddr, nil,
+		proxyURL, auth, nil, NewSSHJumpHost(clientKey, otherHostKey), nil, false, TCPOptions{})
+	assert.ErrorContains(t, err, "is unknown")
+
+	// The jump host requires the key of the user
+	_, err = DialEdgeWithProxy(context.Background(), time.Second, &tls.Config{InsecureSkipVerify: true}, edgeAddr, nil,
+		proxyURL, auth, nil, NewSSHJumpHost(newSigner(t), hostKeyCallback), nil, false, TCPOptions{})
+	assert.ErrorContains(t, err, "unable to authenticate")
+}
+
+func TestSSHHostKeyCallbackKnownHosts(t *testing.T) {
+	hostKey, otherKey := newSigner(t).PublicKey(), newSigner(t).PublicKey()
+	authorized := func(key ssh.PublicKey) string {
+		return string(ssh.MarshalAuthorizedKey(key))
+	}
+	salt := []byte("0123456789abcdefghij")
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte("[hashed.example.com]:2222"))
+	hashed := fmt.Sprintf("|1|%s|%s", base64.StdEncoding.EncodeToString(salt), base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, os.WriteFile(knownHosts, []byte(
+		"bastion.example.com,192.0.2.10 "+authorized(hostKey)+
+			"[bastion.example.com]:2222 "+authorized(otherKey)+
+			hashed+" "+authorized(hostKey)+
+			"*.corp.example.com,!evil.corp.example.com "+authorized(hostKey)+
+			"@revoked * "+authorized(otherKey)+
+			"# comment\n"), 0o600))
+	callback, err := SSHHostKeyCallback(knownHosts, nil)
+	require.NoError(t, err)
+
+	remote := &net.TCPAddr{IP: net.ParseIP("198.51.100.1"), Port: 22}
+	assert.NoError(t, callback("bastion.example.com:22", remote, hostKey))
+	assert.NoError(t, callback("jump.example.org:22", &net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 22}, hostKey))
+	assert.NoError(t, callback("hashed.example.com:2222", remote, hostKey))
+	assert.NoError(t, callback("ssh.corp.example.com:22", remote, hostKey))
+	assert.ErrorContains(t, callback("evil.corp.example.com:22", remote, hostKey), "is unknown")
+	assert.ErrorContains(t, callback("unknown.example.com:22", remote, hostKey), "is unknown")
+	assert.ErrorContains(t, callback("bastion.example.com:2222", remote, otherKey), "revoked")
+
+	_, err = SSHHostKeyCallback("", nil)
+	assert.Error(t, err)
+	_, err = SSHHostKeyCallback("", []string{"MD5:00:11"})
+	assert.Error(t, err)
+}
+
+func TestSSHHostKeyCallbackKeyMismatch(t *testing.T) {
+	hostKey := newSigner(t).PublicKey()
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	require.NoError(t, os.WriteFile(knownHosts, []byte("[127.0.0.1]:2222 "+string(ssh.MarshalAuthorizedKey(hostKey))), 0o600))
+	callback, err := SSHHostKeyCallback(knownHosts, nil)
+	require.NoError(t, err)
+	assert.ErrorContains(t, callback("127.0.0.1:2222", nil, newSigner(t).PublicKey()), "may be impersonated")
+}
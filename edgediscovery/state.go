@@ -0,0 +1,43 @@
+package edgediscovery
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+)
+
+// readStateFile decodes the JSON state file at path into v. A missing file leaves v untouched and is not an error.
+func readStateFile(path string, v any) error {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to read state file %s", path)
+	}
+	return errors.Wrapf(json.Unmarshal(content, v), "failed to parse state file %s", path)
+}
+
+// writeStateFile encodes v as JSON to a temporary file and renames it over the state file at path, so that a
+// crash never leaves a truncated file behind.
+func writeStateFile(path string, v any) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "failed to write state file %s", path)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return errors.Wrapf(err, "failed to write state file %s", path)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "failed to write state file %s", path)
+	}
+	return errors.Wrapf(os.Rename(tmp.Name(), path), "failed to write state file %s", path)
+}
@@ -0,0 +1,92 @@
+package edgediscovery
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// StickyAddrs remembers which edge IP each connection last registered on, persisted to a small state file so
+// that a restarted cloudflared prefers the same addresses. This reduces registration churn, and duplicate
+// connection errors from the edge when cloudflared restarts quickly. Entries older than the TTL are ignored.
+// A nil StickyAddrs remembers nothing.
+type StickyAddrs struct {
+	path string
+	ttl  time.Duration
+	now  func() time.Time
+
+	mu    sync.Mutex
+	addrs map[int]stickyAddr
+}
+
+type stickyAddr struct {
+	IP           net.IP    `json:"ip"`
+	RegisteredAt time.Time `json:"registeredAt"`
+}
+
+// LoadStickyAddrs reads the state file at path. A missing file is not an error, the state simply starts empty.
+// On any other error an empty state is returned along with the error, so that callers can still use it.
+func LoadStickyAddrs(path string, ttl time.Duration) (*StickyAddrs, error) {
+	s := &StickyAddrs{
+		path:  path,
+		ttl:   ttl,
+		now:   time.Now,
+		addrs: make(map[int]stickyAddr),
+	}
+	var addrs map[int]stickyAddr
+	if err := readStateFile(path, &addrs); err != nil {
+		return s, errors.Wrap(err, "failed to load edge address state")
+	}
+	for connIndex, addr := range addrs {
+		if addr.IP != nil {
+			s.addrs[connIndex] = addr
+		}
+	}
+	return s, nil
+}
+
+// Get returns the IP the connection last registered on, or nil if there is none or it is older than the TTL.
+func (s *StickyAddrs) Get(connIndex int) net.IP {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addr, ok := s.addrs[connIndex]
+	if !ok || s.now().Sub(addr.RegisteredAt) > s.ttl {
+		return nil
+	}
+	return addr.IP
+}
+
+// Remember records that the connection registered on ip and persists the state.
+func (s *StickyAddrs) Remember(connIndex int, ip net.IP) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addrs[connIndex] = stickyAddr{IP: ip, RegisteredAt: s.now()}
+	return s.save()
+}
+
+// Forget drops the IP remembered for the connection and persists the state.
+func (s *StickyAddrs) Forget(connIndex int) error {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.addrs[connIndex]; !ok {
+		return nil
+	}
+	delete(s.addrs, connIndex)
+	return s.save()
+}
+
+// save persists the state. Must be called with s.mu held.
+func (s *StickyAddrs) save() error {
+	return errors.Wrap(writeStateFile(s.path, s.addrs), "failed to save edge address state")
+}
@@ -0,0 +1,116 @@
+package edgediscovery
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+)
+
+func TestStickyAddrsPersist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edge-addrs.json")
+
+	sticky, err := LoadStickyAddrs(path, time.Hour)
+	require.NoError(t, err)
+	assert.Nil(t, sticky.Get(0))
+
+	require.NoError(t, sticky.Remember(0, addr1.UDP.IP))
+	require.NoError(t, sticky.Remember(1, addr6.UDP.IP))
+
+	restarted, err := LoadStickyAddrs(path, time.Hour)
+	require.NoError(t, err)
+	assert.True(t, addr1.UDP.IP.Equal(restarted.Get(0)))
+	assert.True(t, addr6.UDP.IP.Equal(restarted.Get(1)))
+	assert.Nil(t, restarted.Get(2))
+
+	require.NoError(t, restarted.Forget(0))
+	restarted, err = LoadStickyAddrs(path, time.Hour)
+	require.NoError(t, err)
+	assert.Nil(t, restarted.Get(0))
+	assert.True(t, addr6.UDP.IP.Equal(restarted.Get(1)))
+}
+
+func TestStickyAddrsExpire(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edge-addrs.json")
+	sticky, err := LoadStickyAddrs(path, time.Minute)
+	require.NoError(t, err)
+	now := time.Now()
+	sticky.now = func() time.Time { return now }
+
+	require.NoError(t, sticky.Remember(0, addr1.UDP.IP))
+	now = now.Add(59 * time.Second)
+	assert.True(t, addr1.UDP.IP.Equal(sticky.Get(0)))
+	now = now.Add(2 * time.Second)
+	assert.Nil(t, sticky.Get(0))
+}
+
+func TestStickyAddrsCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edge-addrs.json")
+	require.NoError(t, os.WriteFile(path, []byte("not json"), 0600))
+
+	sticky, err := LoadStickyAddrs(path, time.Hour)
+	require.Error(t, err)
+	require.NotNil(t, sticky)
+	assert.Nil(t, sticky.Get(0))
+
+	// The state is still usable, and overwrites the corrupt file
+	require.NoError(t, sticky.Remember(0, addr1.UDP.IP))
+	restarted, err := LoadStickyAddrs(path, time.Hour)
+	require.NoError(t, err)
+	assert.True(t, addr1.UDP.IP.Equal(restarted.Get(0)))
+}
+
+func TestGetAddrPrefersStickyAddr(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "edge-addrs.json")
+
+	edge := MockEdge(&testLogger, []*allregions.EdgeAddr{&addr0, &addr1, &addr2, &addr3})
+	sticky, err := LoadStickyAddrs(path, time.Hour)
+	require.NoError(t, err)
+	edge.UseStickyAddrs(sticky)
+	edge.Registered(0, &addr2, time.Second)
+	edge.Registered(1, &addr3, time.Second)
+
+	// Simulate a restart
+	edge = MockEdge(&testLogger, []*allregions.EdgeAddr{&addr0, &addr1, &addr2, &addr3})
+	sticky, err = LoadStickyAddrs(path, time.Hour)
+	require.NoError(t, err)
+	edge.UseStickyAddrs(sticky)
+
+	addr, err := edge.GetAddr(0)
+	require.NoError(t, err)
+	assert.Equal(t, &addr2, addr)
+	addr, err = edge.GetAddr(1)
+	require.NoError(t, err)
+	assert.Equal(t, &addr3, addr)
+
+	// Rotating away from the address forgets it
+	addr, err = edge.GetDifferentAddr(0, true)
+	require.NoError(t, err)
+	assert.NotEqual(t, &addr2, addr)
+	assert.Nil(t, sticky.Get(0))
+}
+
+func TestGetAddrStickyAddrInUse(t *testing.T) {
+	edge := MockEdge(&testLogger, []*allregions.EdgeAddr{&addr0, &addr1})
+	sticky, err := LoadStickyAddrs(filepath.Join(t.TempDir(), "edge-addrs.json"), time.Hour)
+	require.NoError(t, err)
+	edge.UseStickyAddrs(sticky)
+	require.NoError(t, sticky.Remember(1, addr0.UDP.IP))
+
+	// Another connection already took the address connection 1 registered on
+	taken, err := edge.GetAddr(0)
+	require.NoError(t, err)
+	if taken != &addr0 {
+		_, err = edge.GetDifferentAddr(0, false)
+		require.NoError(t, err)
+	}
+
+	addr, err := edge.GetAddr(1)
+	require.NoError(t, err)
+	assert.Equal(t, &addr1, addr)
+}
@@ -0,0 +1,60 @@
+package edgediscovery
+
+import (
+	"net"
+	"syscall"
+	"time"
+)
+
+// TCPOptions are the socket options of TCP connections dialed directly to the edge, i.e. HTTP2 connections. Tuning
+// them cuts the time it takes to reconnect and to detect a hung connection. The zero value leaves the defaults.
+type TCPOptions struct {
+	// DSCP marks the packets of the connection, 0 leaves them unmarked
+	DSCP uint8
+	// FastOpen sends the TLS client hello with the SYN, where supported (Linux)
+	FastOpen bool
+	// Nagle enables Nagle's algorithm, which Go disables by default
+	Nagle bool
+	// KeepAliveInterval is how long the connection is idle before the first keepalive probe, and between
+	// probes. 0 uses the Go default of 15 seconds.
+	KeepAliveInterval time.Duration
+	// KeepAliveCount is how many unanswered keepalive probes close the connection. 0 uses the OS default.
+	KeepAliveCount int
+	// UserTimeout is how long transmitted data may stay unacknowledged before the connection is closed, where
+	// supported (Linux). 0 uses the OS default.
+	UserTimeout time.Duration
+}
+
+func (o TCPOptions) dialer(localIP net.IP) *net.Dialer {
+	dialer := &net.Dialer{
+		KeepAliveConfig: net.KeepAliveConfig{
+			Enable:   true,
+			Idle:     o.KeepAliveInterval,
+			Interval: o.KeepAliveInterval,
+			Count:    o.KeepAliveCount,
+		},
+		Control: func(_, _ string, c syscall.RawConn) error {
+			var err error
+			if controlErr := c.Control(func(fd uintptr) {
+				err = o.setPlatformOptions(fd)
+			}); controlErr != nil {
+				return controlErr
+			}
+			return err
+		},
+	}
+	if localIP != nil {
+		dialer.LocalAddr = &net.TCPAddr{IP: localIP, Port: 0}
+	}
+	return dialer
+}
+
+// apply sets the options that can only be set once the connection is established.
+func (o TCPOptions) apply(conn net.Conn, edgeIP net.IP) error {
+	if tcpConn, ok := conn.(*net.TCPConn); ok {
+		if err := tcpConn.SetNoDelay(!o.Nagle); err != nil {
+			return err
+		}
+	}
+	return SetDSCP(conn, edgeIP, o.DSCP)
+}
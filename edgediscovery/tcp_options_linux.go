@@ -0,0 +1,24 @@
+//go:build linux
+
+package edgediscovery
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// setPlatformOptions sets the options that must be set before connecting.
+func (o TCPOptions) setPlatformOptions(fd uintptr) error {
+	if o.FastOpen {
+		// Kernels older than 4.11 don't support it, the connection is then opened without it
+		err := unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_FASTOPEN_CONNECT, 1)
+		if err != nil && err != unix.ENOPROTOOPT {
+			return err
+		}
+	}
+	if o.UserTimeout > 0 {
+		if err := unix.SetsockoptInt(int(fd), unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT, int(o.UserTimeout.Milliseconds())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
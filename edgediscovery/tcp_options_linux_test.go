@@ -0,0 +1,92 @@
+//go:build linux
+
+package edgediscovery
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func TestTCPOptions(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  TCPOptions
+		expected map[string]int
+	}{
+		{
+			name:    "defaults",
+			options: TCPOptions{},
+			expected: map[string]int{
+				"nodelay":     1,
+				"keepalive":   1,
+				"keepintvl":   15,
+				"usertimeout": 0,
+				"tos":         0,
+			},
+		},
+		{
+			name: "tuned",
+			options: TCPOptions{
+				DSCP:              10,
+				FastOpen:          true,
+				Nagle:             true,
+				KeepAliveInterval: 5 * time.Second,
+				KeepAliveCount:    3,
+				UserTimeout:       20 * time.Second,
+			},
+			expected: map[string]int{
+				"nodelay":     0,
+				"keepalive":   1,
+				"keepidle":    5,
+				"keepintvl":   5,
+				"keepcnt":     3,
+				"usertimeout": 20000,
+				"tos":         10 << 2,
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			listener, err := net.Listen("tcp4", "127.0.0.1:0")
+			require.NoError(t, err)
+			defer listener.Close()
+
+			conn, err := dialDirect(context.Background(), listener.Addr().String(), nil, test.options)
+			require.NoError(t, err)
+			defer conn.Close()
+			require.NoError(t, test.options.apply(conn, net.IPv4(127, 0, 0, 1)))
+
+			options := map[string]struct{ level, opt int }{
+				"nodelay":     {unix.IPPROTO_TCP, unix.TCP_NODELAY},
+				"keepalive":   {unix.SOL_SOCKET, unix.SO_KEEPALIVE},
+				"keepidle":    {unix.IPPROTO_TCP, unix.TCP_KEEPIDLE},
+				"keepintvl":   {unix.IPPROTO_TCP, unix.TCP_KEEPINTVL},
+				"keepcnt":     {unix.IPPROTO_TCP, unix.TCP_KEEPCNT},
+				"usertimeout": {unix.IPPROTO_TCP, unix.TCP_USER_TIMEOUT},
+				"tos":         {unix.IPPROTO_IP, unix.IP_TOS},
+			}
+			for name, expected := range test.expected {
+				assert.Equal(t, expected, getsockopt(t, conn, options[name].level, options[name].opt), name)
+			}
+		})
+	}
+}
+
+func getsockopt(t *testing.T, conn net.Conn, level, opt int) int {
+	rawConn, err := conn.(syscall.Conn).SyscallConn()
+	require.NoError(t, err)
+	var value int
+	var sockErr error
+	require.NoError(t, rawConn.Control(func(fd uintptr) {
+		value, sockErr = unix.GetsockoptInt(int(fd), level, opt)
+	}))
+	require.NoError(t, sockErr)
+	return value
+}
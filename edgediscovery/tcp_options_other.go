@@ -0,0 +1,8 @@
+//go:build !linux
+
+package edgediscovery
+
+// setPlatformOptions ignores FastOpen and UserTimeout, which are only supported on Linux.
+func (o TCPOptions) setPlatformOptions(_ uintptr) error {
+	return nil
+}
@@ -0,0 +1,152 @@
+// Package errorreport aggregates recurring errors before reporting them, so that an error repeated by every
+// connection during an edge incident is reported once per interval with how often it occurred, rather than flooding
+// Sentry and the logs.
+package errorreport
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"github.com/rs/zerolog"
+)
+
+// DefaultInterval is how often summaries of the errors captured are reported by default.
+const DefaultInterval = 5 * time.Minute
+
+// maxGroups bounds the distinct errors held between reports, further errors are only counted as dropped.
+const maxGroups = 100
+
+// Summary describes the occurrences of an error since it was last reported.
+type Summary struct {
+	// Key groups the occurrences, it's the type and message of the error
+	Key         string
+	Count       int
+	FirstSeen   time.Time
+	LastSeen    time.Time
+	SampleStack string
+}
+
+// Aggregator groups the errors captured and reports a Summary of each every interval.
+type Aggregator struct {
+	lock    sync.Mutex
+	groups  map[string]*Summary
+	dropped int
+
+	// report sends a summary to Sentry, it's replaced in tests
+	report func(Summary)
+	now    func() time.Time
+}
+
+func NewAggregator() *Aggregator {
+	return &Aggregator{
+		groups: make(map[string]*Summary),
+		report: reportToSentry,
+		now:    time.Now,
+	}
+}
+
+var defaultAggregator = NewAggregator()
+
+// Capture records an occurrence of err with the default Aggregator.
+func Capture(err error) {
+	defaultAggregator.Capture(err)
+}
+
+// Run reports the errors captured with the default Aggregator every interval until ctx is done.
+func Run(ctx context.Context, interval time.Duration, log *zerolog.Logger) {
+	defaultAggregator.Run(ctx, interval, log)
+}
+
+// Capture records an occurrence of err, the stack of its first occurrence is kept as a sample.
+func (a *Aggregator) Capture(err error) {
+	if err == nil {
+		return
+	}
+	key := fmt.Sprintf("%T: %s", err, err)
+	now := a.now()
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	if group, ok := a.groups[key]; ok {
+		group.Count++
+		group.LastSeen = now
+		return
+	}
+	if len(a.groups) >= maxGroups {
+		a.dropped++
+		return
+	}
+	a.groups[key] = &Summary{
+		Key:         key,
+		Count:       1,
+		FirstSeen:   now,
+		LastSeen:    now,
+		SampleStack: string(debug.Stack()),
+	}
+}
+
+// Run reports the errors captured every interval until ctx is done, then reports those captured since the last
+// interval. Summaries are sent to Sentry and logged, which streams them to management sessions.
+func (a *Aggregator) Run(ctx context.Context, interval time.Duration, log *zerolog.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			a.Flush(log)
+			sentry.Flush(2 * time.Second)
+			return
+		case <-ticker.C:
+			a.Flush(log)
+		}
+	}
+}
+
+// Flush reports the errors captured since the last report.
+func (a *Aggregator) Flush(log *zerolog.Logger) {
+	a.lock.Lock()
+	summaries := make([]Summary, 0, len(a.groups))
+	for _, group := range a.groups {
+		summaries = append(summaries, *group)
+	}
+	dropped := a.dropped
+	a.groups = make(map[string]*Summary)
+	a.dropped = 0
+	a.lock.Unlock()
+
+	sort.Slice(summaries, func(i, j int) bool {
+		return summaries[i].FirstSeen.Before(summaries[j].FirstSeen)
+	})
+	for _, summary := range summaries {
+		log.Warn().
+			Str("error", summary.Key).
+			Int("count", summary.Count).
+			Time("firstSeen", summary.FirstSeen).
+			Time("lastSeen", summary.LastSeen).
+			Msg("Recurring error")
+		a.report(summary)
+	}
+	if dropped > 0 {
+		log.Warn().Int("count", dropped).Msg("Errors not reported as too many distinct errors occurred")
+	}
+}
+
+func reportToSentry(summary Summary) {
+	event := sentry.NewEvent()
+	event.Level = sentry.LevelError
+	event.Message = fmt.Sprintf("%s (occurred %d times)", summary.Key, summary.Count)
+	// Group the summaries of the same error together, whatever their count
+	event.Fingerprint = []string{summary.Key}
+	event.Extra = map[string]interface{}{
+		"count":        summary.Count,
+		"first_seen":   summary.FirstSeen.UTC().Format(time.RFC3339),
+		"last_seen":    summary.LastSeen.UTC().Format(time.RFC3339),
+		"sample_stack": summary.SampleStack,
+	}
+	sentry.CaptureEvent(event)
+}
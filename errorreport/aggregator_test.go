@@ -0,0 +1,80 @@
+package errorreport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAggregator(reported *[]Summary) *Aggregator {
+	a := NewAggregator()
+	a.report = func(summary Summary) {
+		*reported = append(*reported, summary)
+	}
+	return a
+}
+
+func TestAggregatorGroupsRecurringErrors(t *testing.T) {
+	var reported []Summary
+	a := newTestAggregator(&reported)
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	a.now = func() time.Time { return now }
+
+	for i := 0; i < 10; i++ {
+		a.Capture(errors.New("edge unreachable"))
+		now = now.Add(time.Second)
+	}
+	a.Capture(fmt.Errorf("certificate expired"))
+	a.Capture(nil)
+
+	var logs bytes.Buffer
+	log := zerolog.New(&logs)
+	a.Flush(&log)
+
+	require.Len(t, reported, 2)
+	require.Equal(t, "*errors.errorString: edge unreachable", reported[0].Key)
+	require.Equal(t, 10, reported[0].Count)
+	require.Equal(t, start, reported[0].FirstSeen)
+	require.Equal(t, start.Add(9*time.Second), reported[0].LastSeen)
+	require.Contains(t, reported[0].SampleStack, "TestAggregatorGroupsRecurringErrors")
+	require.Equal(t, 1, reported[1].Count)
+	require.Contains(t, logs.String(), `"count":10`)
+
+	// Errors are only reported once
+	reported = nil
+	a.Flush(&log)
+	require.Empty(t, reported)
+}
+
+func TestAggregatorBoundsGroups(t *testing.T) {
+	var reported []Summary
+	a := newTestAggregator(&reported)
+	for i := 0; i < maxGroups+5; i++ {
+		a.Capture(fmt.Errorf("error %d", i))
+	}
+
+	var logs bytes.Buffer
+	log := zerolog.New(&logs)
+	a.Flush(&log)
+	require.Len(t, reported, maxGroups)
+	require.Contains(t, logs.String(), `"count":5`)
+}
+
+func TestAggregatorRunFlushesOnShutdown(t *testing.T) {
+	var reported []Summary
+	a := newTestAggregator(&reported)
+	a.Capture(errors.New("edge unreachable"))
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	log := zerolog.Nop()
+	a.Run(ctx, time.Hour, &log)
+	require.Len(t, reported, 1)
+}
@@ -0,0 +1,235 @@
+// Package faultinject injects faults into the paths cloudflared uses to reach the edge and origins, so that the
+// supervisor's retry and fallback logic can be exercised deterministically in integration tests and staging.
+// A nil *Injector injects nothing, so callers never need to check whether fault injection is enabled.
+package faultinject
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrProxyDial is the error of the dials through the edge proxy failed on purpose.
+var ErrProxyDial = errors.New("injected edge proxy dial failure")
+
+const (
+	quicPacketDropKey  = "quic-packet-drop"
+	originDialDelayKey = "origin-dial-delay"
+	dupConnKey         = "dup-conn"
+	proxyDialFailKey   = "proxy-dial-fail"
+	seedKey            = "seed"
+)
+
+// Config describes the faults to inject.
+type Config struct {
+	// QUICPacketDropRate is the fraction, between 0 and 1, of QUIC packets dropped in each direction.
+	QUICPacketDropRate float64
+	// OriginDialDelay is added before every TCP and UDP dial to an origin.
+	OriginDialDelay time.Duration
+	// DupConnRegistrations maps a connection index to the number of times its connection fails with a
+	// duplicate connection error before being allowed to register.
+	DupConnRegistrations map[uint8]uint
+	// ProxyDialFailRate is the fraction, between 0 and 1, of dials through the edge proxy that fail.
+	ProxyDialFailRate float64
+	// Seed makes the random faults reproducible.
+	Seed int64
+}
+
+// ParseConfig parses faults given as key=value pairs:
+//
+//	quic-packet-drop=0.1     drop 10% of QUIC packets
+//	origin-dial-delay=2s     delay origin dials by 2 seconds
+//	dup-conn=1[:count]       fail registration of connection 1 with a duplicate connection error, count times (default 1)
+//	proxy-dial-fail=0.5      fail 50% of dials through the edge proxy
+//	seed=42                  seed of the random faults
+func ParseConfig(specs []string) (Config, error) {
+	cfg := Config{
+		DupConnRegistrations: make(map[uint8]uint),
+		Seed:                 time.Now().UnixNano(),
+	}
+	for _, spec := range specs {
+		key, value, ok := strings.Cut(spec, "=")
+		if !ok {
+			return Config{}, fmt.Errorf("fault %q is not in the key=value format", spec)
+		}
+		var err error
+		switch key {
+		case quicPacketDropKey:
+			cfg.QUICPacketDropRate, err = parseRate(value)
+		case originDialDelayKey:
+			cfg.OriginDialDelay, err = time.ParseDuration(value)
+		case dupConnKey:
+			err = parseDupConn(value, cfg.DupConnRegistrations)
+		case proxyDialFailKey:
+			cfg.ProxyDialFailRate, err = parseRate(value)
+		case seedKey:
+			cfg.Seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			return Config{}, fmt.Errorf("unknown fault %q", key)
+		}
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid value for fault %s: %w", key, err)
+		}
+	}
+	return cfg, nil
+}
+
+func parseRate(value string) (float64, error) {
+	rate, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("%v is not between 0 and 1", rate)
+	}
+	return rate, nil
+}
+
+func parseDupConn(value string, registrations map[uint8]uint) error {
+	indexStr, countStr, hasCount := strings.Cut(value, ":")
+	connIndex, err := strconv.ParseUint(indexStr, 10, 8)
+	if err != nil {
+		return err
+	}
+	count := uint64(1)
+	if hasCount {
+		if count, err = strconv.ParseUint(countStr, 10, 32); err != nil {
+			return err
+		}
+	}
+	registrations[uint8(connIndex)] += uint(count)
+	return nil
+}
+
+// Injector decides when to inject the configured faults.
+type Injector struct {
+	cfg Config
+
+	mu sync.Mutex
+	// dupConnRemaining counts down the duplicate connection errors left to inject per connection index.
+	dupConnRemaining map[uint8]uint
+	rand             *rand.Rand
+}
+
+// New returns an Injector for cfg.
+func New(cfg Config) *Injector {
+	dupConnRemaining := make(map[uint8]uint, len(cfg.DupConnRegistrations))
+	for connIndex, count := range cfg.DupConnRegistrations {
+		dupConnRemaining[connIndex] = count
+	}
+	return &Injector{
+		cfg:              cfg,
+		dupConnRemaining: dupConnRemaining,
+		// nolint: gosec
+		rand: rand.New(rand.NewSource(cfg.Seed)),
+	}
+}
+
+// String describes the injected faults, to be logged when fault injection is enabled.
+func (i *Injector) String() string {
+	if i == nil {
+		return "none"
+	}
+	return fmt.Sprintf("%s=%v %s=%v %s=%v %s=%v %s=%d",
+		quicPacketDropKey, i.cfg.QUICPacketDropRate,
+		originDialDelayKey, i.cfg.OriginDialDelay,
+		dupConnKey, i.cfg.DupConnRegistrations,
+		proxyDialFailKey, i.cfg.ProxyDialFailRate,
+		seedKey, i.cfg.Seed,
+	)
+}
+
+func (i *Injector) chance(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.rand.Float64() < rate
+}
+
+// DropQUICPacket returns true if the next QUIC packet should be dropped.
+func (i *Injector) DropQUICPacket() bool {
+	return i != nil && i.chance(i.cfg.QUICPacketDropRate)
+}
+
+// WrapPacketConn returns conn dropping packets at the configured rate, or conn itself if no packets are dropped.
+func (i *Injector) WrapPacketConn(conn net.PacketConn) net.PacketConn {
+	if i == nil || i.cfg.QUICPacketDropRate <= 0 {
+		return conn
+	}
+	return &lossyPacketConn{
+		PacketConn: conn,
+		injector:   i,
+	}
+}
+
+// DelayOriginDial waits for the configured origin dial delay, or until ctx is done.
+func (i *Injector) DelayOriginDial(ctx context.Context) error {
+	if i == nil || i.cfg.OriginDialDelay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(i.cfg.OriginDialDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ForceDupConn returns true if the connection with connIndex should fail with a duplicate connection error.
+func (i *Injector) ForceDupConn(connIndex uint8) bool {
+	if i == nil {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if i.dupConnRemaining[connIndex] == 0 {
+		return false
+	}
+	i.dupConnRemaining[connIndex]--
+	return true
+}
+
+// FailProxyDial returns true if the next dial through the edge proxy should fail.
+func (i *Injector) FailProxyDial() bool {
+	return i != nil && i.chance(i.cfg.ProxyDialFailRate)
+}
+
+// ProxyDialError returns ErrProxyDial if the next dial through the edge proxy should fail, nil otherwise.
+func (i *Injector) ProxyDialError() error {
+	if i.FailProxyDial() {
+		return ErrProxyDial
+	}
+	return nil
+}
+
+// lossyPacketConn silently drops packets in both directions, like a lossy network would.
+type lossyPacketConn struct {
+	net.PacketConn
+	injector *Injector
+}
+
+func (c *lossyPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(p)
+		if err != nil || !c.injector.DropQUICPacket() {
+			return n, addr, err
+		}
+	}
+}
+
+func (c *lossyPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	if c.injector.DropQUICPacket() {
+		return len(p), nil
+	}
+	return c.PacketConn.WriteTo(p, addr)
+}
@@ -0,0 +1,108 @@
+package faultinject
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := ParseConfig([]string{
+		"quic-packet-drop=0.25",
+		"origin-dial-delay=2s",
+		"dup-conn=1",
+		"dup-conn=2:3",
+		"dup-conn=1",
+		"proxy-dial-fail=1",
+		"seed=42",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, Config{
+		QUICPacketDropRate:   0.25,
+		OriginDialDelay:      2 * time.Second,
+		DupConnRegistrations: map[uint8]uint{1: 2, 2: 3},
+		ProxyDialFailRate:    1,
+		Seed:                 42,
+	}, cfg)
+
+	for _, spec := range []string{
+		"quic-packet-drop",
+		"quic-packet-drop=1.5",
+		"origin-dial-delay=soon",
+		"dup-conn=256",
+		"dup-conn=1:-1",
+		"unknown=1",
+	} {
+		_, err := ParseConfig([]string{spec})
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestNilInjector(t *testing.T) {
+	var injector *Injector
+	assert.False(t, injector.DropQUICPacket())
+	assert.False(t, injector.ForceDupConn(0))
+	assert.False(t, injector.FailProxyDial())
+	assert.NoError(t, injector.ProxyDialError())
+	assert.NoError(t, injector.DelayOriginDial(t.Context()))
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, conn, injector.WrapPacketConn(conn))
+}
+
+func TestForceDupConn(t *testing.T) {
+	injector := New(Config{DupConnRegistrations: map[uint8]uint{1: 2}})
+	assert.False(t, injector.ForceDupConn(0))
+	assert.True(t, injector.ForceDupConn(1))
+	assert.True(t, injector.ForceDupConn(1))
+	assert.False(t, injector.ForceDupConn(1))
+}
+
+func TestProxyDialError(t *testing.T) {
+	assert.ErrorIs(t, New(Config{ProxyDialFailRate: 1}).ProxyDialError(), ErrProxyDial)
+	assert.NoError(t, New(Config{}).ProxyDialError())
+}
+
+func TestRandomFaultsAreReproducible(t *testing.T) {
+	cfg := Config{ProxyDialFailRate: 0.5, Seed: 7}
+	first, second := New(cfg), New(cfg)
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, first.FailProxyDial(), second.FailProxyDial())
+	}
+}
+
+func TestDelayOriginDial(t *testing.T) {
+	injector := New(Config{OriginDialDelay: time.Hour})
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, injector.DelayOriginDial(ctx), context.DeadlineExceeded)
+}
+
+func TestLossyPacketConn(t *testing.T) {
+	receiver, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer receiver.Close()
+	sender, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer sender.Close()
+
+	lossySender := New(Config{QUICPacketDropRate: 1}).WrapPacketConn(sender)
+	n, err := lossySender.WriteTo([]byte("dropped"), receiver.LocalAddr())
+	require.NoError(t, err)
+	assert.Equal(t, len("dropped"), n)
+
+	_, err = sender.WriteTo([]byte("delivered"), receiver.LocalAddr())
+	require.NoError(t, err)
+
+	require.NoError(t, receiver.SetReadDeadline(time.Now().Add(time.Second)))
+	buf := make([]byte, 32)
+	n, _, err = receiver.ReadFrom(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "delivered", string(buf[:n]))
+}
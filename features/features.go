@@ -11,6 +11,9 @@ const (
 	FeatureQUICSupportEOF    = "support_quic_eof"
 	FeatureManagementLogs    = "management_logs"
 	FeatureDatagramV3_2      = "support_datagram_v3_2"
+	// FeatureHybridProtocols tells the edge the connector serves QUIC and HTTP2 connections at once, so that it
+	// steers UDP and ICMP to the QUIC ones
+	FeatureHybridProtocols = "hybrid_protocols"
 
 	DeprecatedFeatureDatagramV3   = "support_datagram_v3"   // Deprecated: TUN-9291
 	DeprecatedFeatureDatagramV3_1 = "support_datagram_v3_1" // Deprecated: TUN-9883
@@ -52,6 +55,8 @@ const (
 	// If the user passes the --post-quantum flag, we override
 	// CurvePreferences to only support hybrid post-quantum key agreements.
 	PostQuantumStrict
+	// If post-quantum is disabled by the feature overrides, only classical key agreements are offered.
+	PostQuantumDisabled
 )
 
 type DatagramVersion string
@@ -0,0 +1,67 @@
+package features
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/rs/zerolog"
+)
+
+// Features that can be forced on or off by the Overrides
+var overridableFeatures = []string{
+	FeatureSerializedHeaders,
+	FeatureQuickReconnects,
+	FeatureAllowRemoteConfig,
+	FeatureDatagramV2,
+	FeaturePostQuantum,
+	FeatureQUICSupportEOF,
+	FeatureManagementLogs,
+	FeatureDatagramV3_2,
+	FeatureHybridProtocols,
+}
+
+// Overrides force features on or off, over the CLI flags and the features selected remotely, so that a regression
+// of a single feature can be worked around without downgrading cloudflared.
+//
+// Forcing a datagram version on, or the other off, selects that version. Forcing post-quantum on is the same as
+// --post-quantum, forcing it off stops offering post-quantum key agreements to the edge altogether.
+type Overrides struct {
+	Enable  []string
+	Disable []string
+}
+
+func (o Overrides) validate(pq bool) error {
+	for _, feature := range slices.Concat(o.Enable, o.Disable) {
+		if !slices.Contains(overridableFeatures, feature) {
+			return fmt.Errorf("unknown feature %q, must be one of %v", feature, overridableFeatures)
+		}
+		if slices.Contains(o.Enable, feature) && slices.Contains(o.Disable, feature) {
+			return fmt.Errorf("feature %q is both enabled and disabled", feature)
+		}
+	}
+	if o.selects(FeatureDatagramV2) && o.selects(FeatureDatagramV3_2) {
+		return fmt.Errorf("only one of %s and %s can be selected", FeatureDatagramV2, FeatureDatagramV3_2)
+	}
+	if pq && slices.Contains(o.Disable, FeaturePostQuantum) {
+		return fmt.Errorf("%s can't be disabled when post-quantum is enforced", FeaturePostQuantum)
+	}
+	return nil
+}
+
+// selects tells whether the overrides select the given datagram version, by enabling it or disabling the other one.
+func (o Overrides) selects(datagramVersion string) bool {
+	other := FeatureDatagramV2
+	if datagramVersion == FeatureDatagramV2 {
+		other = FeatureDatagramV3_2
+	}
+	return slices.Contains(o.Enable, datagramVersion) || slices.Contains(o.Disable, other)
+}
+
+func (o Overrides) logWarnings(log *zerolog.Logger) {
+	for _, feature := range o.Enable {
+		log.Warn().Str("feature", feature).Msg("Feature is force-enabled by the configuration, regardless of the features selected for this account")
+	}
+	for _, feature := range o.Disable {
+		log.Warn().Str("feature", feature).Msg("Feature is force-disabled by the configuration, regardless of the features selected for this account")
+	}
+}
@@ -30,8 +30,14 @@ type featuresRecord struct {
 	// PostQuantumPercentage int32 `json:"pq"` // Removed in TUN-7970
 }
 
-func NewFeatureSelector(ctx context.Context, accountTag string, cliFeatures []string, pq bool, logger *zerolog.Logger) (FeatureSelector, error) {
-	return newFeatureSelector(ctx, accountTag, logger, newDNSResolver(), cliFeatures, pq, defaultLookupFreq)
+func NewFeatureSelector(ctx context.Context, accountTag string, cliFeatures []string, pq bool, overrides Overrides, logger *zerolog.Logger) (FeatureSelector, error) {
+	return newFeatureSelector(ctx, accountTag, logger, newDNSResolver(), cliFeatures, pq, overrides, defaultLookupFreq)
+}
+
+// NewLocalFeatureSelector is like NewFeatureSelector, but never looks up the remote features in DNS, e.g. when DNS
+// lookups must not leave the host directly. The features are the ones of the CLI and of the overrides only.
+func NewLocalFeatureSelector(ctx context.Context, accountTag string, cliFeatures []string, pq bool, overrides Overrides, logger *zerolog.Logger) (FeatureSelector, error) {
+	return newFeatureSelector(ctx, accountTag, logger, localResolver{}, cliFeatures, pq, overrides, defaultLookupFreq)
 }
 
 type FeatureSelector interface {
@@ -46,19 +52,29 @@ type featureSelector struct {
 
 	staticFeatures staticFeatures
 	cliFeatures    []string
+	overrides      Overrides
 
 	// lock protects concurrent access to dynamic features
 	lock           sync.RWMutex
 	remoteFeatures featuresRecord
 }
 
-func newFeatureSelector(ctx context.Context, accountTag string, logger *zerolog.Logger, resolver resolver, cliFeatures []string, pq bool, refreshFreq time.Duration) (*featureSelector, error) {
+func newFeatureSelector(ctx context.Context, accountTag string, logger *zerolog.Logger, resolver resolver, cliFeatures []string, pq bool, overrides Overrides, refreshFreq time.Duration) (*featureSelector, error) {
+	if err := overrides.validate(pq); err != nil {
+		return nil, err
+	}
+	overrides.logWarnings(logger)
+	pq = pq || slices.Contains(overrides.Enable, FeaturePostQuantum)
+
 	// Combine default features and user-provided features
 	var pqMode *PostQuantumMode
 	if pq {
 		mode := PostQuantumStrict
 		pqMode = &mode
 		cliFeatures = append(cliFeatures, FeaturePostQuantum)
+	} else if slices.Contains(overrides.Disable, FeaturePostQuantum) {
+		mode := PostQuantumDisabled
+		pqMode = &mode
 	}
 	staticFeatures := staticFeatures{
 		PostQuantumMode: pqMode,
@@ -69,6 +85,7 @@ func newFeatureSelector(ctx context.Context, accountTag string, logger *zerolog.
 		resolver:       resolver,
 		staticFeatures: staticFeatures,
 		cliFeatures:    dedupAndRemoveFeatures(cliFeatures),
+		overrides:      overrides,
 	}
 
 	// Load the remote features
@@ -105,6 +122,13 @@ func (fs *featureSelector) postQuantumMode() PostQuantumMode {
 }
 
 func (fs *featureSelector) datagramVersion() DatagramVersion {
+	// The overrides of the configuration take priority over everything else
+	if fs.overrides.selects(FeatureDatagramV3_2) {
+		return DatagramV3
+	}
+	if fs.overrides.selects(FeatureDatagramV2) {
+		return DatagramV2
+	}
 	// If user provides the feature via the cli, we take it as priority over remote feature evaluation
 	if slices.Contains(fs.cliFeatures, FeatureDatagramV3_2) {
 		return DatagramV3
@@ -124,7 +148,10 @@ func (fs *featureSelector) datagramVersion() DatagramVersion {
 // clientFeatures will return the list of currently available features that cloudflared should provide to the edge.
 func (fs *featureSelector) clientFeatures() []string {
 	// Evaluate any remote features along with static feature list to construct the list of features
-	return dedupAndRemoveFeatures(slices.Concat(defaultFeatures, fs.cliFeatures, []string{string(fs.datagramVersion())}))
+	features := dedupAndRemoveFeatures(slices.Concat(defaultFeatures, fs.cliFeatures, fs.overrides.Enable, []string{string(fs.datagramVersion())}))
+	return slices.DeleteFunc(features, func(feature string) bool {
+		return slices.Contains(fs.overrides.Disable, feature)
+	})
 }
 
 func (fs *featureSelector) refresh(ctx context.Context) error {
@@ -166,6 +193,13 @@ type resolver interface {
 	lookupRecord(ctx context.Context) ([]byte, error)
 }
 
+// localResolver returns no remote features.
+type localResolver struct{}
+
+func (localResolver) lookupRecord(context.Context) ([]byte, error) {
+	return []byte("{}"), nil
+}
+
 type dnsResolver struct {
 	resolver *net.Resolver
 }
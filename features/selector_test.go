@@ -77,7 +77,7 @@ func TestFeaturePrecedenceEvaluationPostQuantum(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			resolver := &staticResolver{record: featuresRecord{}}
-			selector, err := newFeatureSelector(t.Context(), test.name, &logger, resolver, []string{}, test.cli, time.Second)
+			selector, err := newFeatureSelector(t.Context(), test.name, &logger, resolver, []string{}, test.cli, Overrides{}, time.Second)
 			require.NoError(t, err)
 			snapshot := selector.Snapshot()
 			require.ElementsMatch(t, test.expectedFeatures, snapshot.FeaturesList)
@@ -121,7 +121,7 @@ func TestFeaturePrecedenceEvaluationDatagramVersion(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			resolver := &staticResolver{record: test.remote}
-			selector, err := newFeatureSelector(t.Context(), test.name, &logger, resolver, test.cli, false, time.Second)
+			selector, err := newFeatureSelector(t.Context(), test.name, &logger, resolver, test.cli, false, Overrides{}, time.Second)
 			require.NoError(t, err)
 			snapshot := selector.Snapshot()
 			require.ElementsMatch(t, test.expectedFeatures, snapshot.FeaturesList)
@@ -161,7 +161,7 @@ func TestDeprecatedFeaturesRemoved(t *testing.T) {
 	for _, test := range tests {
 		t.Run(test.name, func(t *testing.T) {
 			resolver := &staticResolver{record: test.remote}
-			selector, err := newFeatureSelector(t.Context(), test.name, &logger, resolver, test.cli, false, time.Second)
+			selector, err := newFeatureSelector(t.Context(), test.name, &logger, resolver, test.cli, false, Overrides{}, time.Second)
 			require.NoError(t, err)
 			snapshot := selector.Snapshot()
 			require.ElementsMatch(t, test.expectedFeatures, snapshot.FeaturesList)
@@ -169,6 +169,106 @@ func TestDeprecatedFeaturesRemoved(t *testing.T) {
 	}
 }
 
+func TestFeatureOverrides(t *testing.T) {
+	logger := zerolog.Nop()
+	tests := []struct {
+		name                string
+		cli                 []string
+		pq                  bool
+		remote              featuresRecord
+		overrides           Overrides
+		expectedFeatures    []string
+		expectedVersion     DatagramVersion
+		expectedPostQuantum PostQuantumMode
+	}{
+		{
+			name:             "disable_remote_datagram_v3",
+			remote:           featuresRecord{DatagramV3Percentage: 100},
+			overrides:        Overrides{Disable: []string{FeatureDatagramV3_2}},
+			expectedFeatures: defaultFeatures,
+			expectedVersion:  DatagramV2,
+		},
+		{
+			name:             "disable_cli_datagram_v2",
+			cli:              []string{FeatureDatagramV2},
+			overrides:        Overrides{Disable: []string{FeatureDatagramV2}},
+			expectedFeatures: []string{FeatureAllowRemoteConfig, FeatureSerializedHeaders, FeatureQUICSupportEOF, FeatureManagementLogs, FeatureDatagramV3_2},
+			expectedVersion:  DatagramV3,
+		},
+		{
+			name:             "enable_and_disable",
+			overrides:        Overrides{Enable: []string{FeatureQuickReconnects}, Disable: []string{FeatureManagementLogs}},
+			expectedFeatures: []string{FeatureAllowRemoteConfig, FeatureSerializedHeaders, FeatureDatagramV2, FeatureQUICSupportEOF, FeatureQuickReconnects},
+			expectedVersion:  DatagramV2,
+		},
+		{
+			name:                "enable_post_quantum",
+			overrides:           Overrides{Enable: []string{FeaturePostQuantum}},
+			expectedFeatures:    dedupAndRemoveFeatures(append(defaultFeatures, FeaturePostQuantum)),
+			expectedVersion:     DatagramV2,
+			expectedPostQuantum: PostQuantumStrict,
+		},
+		{
+			name:                "disable_post_quantum",
+			overrides:           Overrides{Disable: []string{FeaturePostQuantum}},
+			expectedFeatures:    defaultFeatures,
+			expectedVersion:     DatagramV2,
+			expectedPostQuantum: PostQuantumDisabled,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := &staticResolver{record: test.remote}
+			selector, err := newFeatureSelector(t.Context(), testAccountTag, &logger, resolver, test.cli, test.pq, test.overrides, time.Second)
+			require.NoError(t, err)
+			snapshot := selector.Snapshot()
+			require.ElementsMatch(t, test.expectedFeatures, snapshot.FeaturesList)
+			require.Equal(t, test.expectedVersion, snapshot.DatagramVersion)
+			require.Equal(t, test.expectedPostQuantum, snapshot.PostQuantum)
+		})
+	}
+}
+
+func TestInvalidFeatureOverrides(t *testing.T) {
+	logger := zerolog.Nop()
+	tests := []struct {
+		name      string
+		pq        bool
+		overrides Overrides
+	}{
+		{
+			name:      "unknown",
+			overrides: Overrides{Enable: []string{"support_datagram_v4"}},
+		},
+		{
+			name:      "deprecated",
+			overrides: Overrides{Disable: []string{DeprecatedFeatureDatagramV3_1}},
+		},
+		{
+			name:      "enabled_and_disabled",
+			overrides: Overrides{Enable: []string{FeatureQuickReconnects}, Disable: []string{FeatureQuickReconnects}},
+		},
+		{
+			name:      "both_datagram_versions",
+			overrides: Overrides{Enable: []string{FeatureDatagramV2, FeatureDatagramV3_2}},
+		},
+		{
+			name:      "post_quantum_enforced",
+			pq:        true,
+			overrides: Overrides{Disable: []string{FeaturePostQuantum}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resolver := &staticResolver{record: featuresRecord{}}
+			_, err := newFeatureSelector(t.Context(), testAccountTag, &logger, resolver, []string{}, test.pq, test.overrides, time.Second)
+			require.Error(t, err)
+		})
+	}
+}
+
 func TestRefreshFeaturesRecord(t *testing.T) {
 	percentages := []uint32{0, 10, testAccountHash - 1, testAccountHash, testAccountHash + 1, 100, 101, 1000}
 	selector := newTestSelector(t, percentages, false, time.Minute)
@@ -230,7 +330,7 @@ func newTestSelector(t *testing.T, percentages []uint32, pq bool, refreshFreq ti
 		percentages: percentages,
 	}
 
-	selector, err := newFeatureSelector(t.Context(), testAccountTag, &logger, resolver, []string{}, pq, refreshFreq)
+	selector, err := newFeatureSelector(t.Context(), testAccountTag, &logger, resolver, []string{}, pq, Overrides{}, refreshFreq)
 	require.NoError(t, err)
 
 	return selector
@@ -261,3 +361,12 @@ type staticResolver struct {
 func (r *staticResolver) lookupRecord(ctx context.Context) ([]byte, error) {
 	return json.Marshal(r.record)
 }
+
+func TestLocalFeatureSelector(t *testing.T) {
+	logger := zerolog.Nop()
+	selector, err := NewLocalFeatureSelector(t.Context(), testAccountTag, []string{FeatureDatagramV3_2}, false, Overrides{}, &logger)
+	require.NoError(t, err)
+	snapshot := selector.Snapshot()
+	require.Equal(t, DatagramV3, snapshot.DatagramVersion)
+	require.Equal(t, PostQuantumPrefer, snapshot.PostQuantum)
+}
@@ -0,0 +1,246 @@
+// Package fleet fetches the configuration of cloudflared from an HTTPS endpoint of the operator, so that thousands of
+// similar connectors run from a single template instead of a configuration file managed on every host.
+package fleet
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"text/template"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	yaml "gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultRefreshInterval is how often the configuration is fetched again by default.
+	DefaultRefreshInterval = 10 * time.Minute
+	// MinRefreshInterval is the shortest interval between two fetches of the configuration.
+	MinRefreshInterval = time.Minute
+	// DefaultCacheFile is the name of the file the configuration is cached in by default.
+	DefaultCacheFile = "fleet-config.yml"
+
+	fetchTimeout = 30 * time.Second
+	// maxConfigSize bounds the configuration read from the endpoint
+	maxConfigSize = 1 << 20
+
+	headerConnectorID = "Cf-Connector-Id"
+	headerHostname    = "Cf-Connector-Hostname"
+	headerOSArch      = "Cf-Connector-Os-Arch"
+)
+
+var fetches = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: "cloudflared",
+		Subsystem: "fleet",
+		Name:      "config_fetches_total",
+		Help:      "Fetches of the configuration from the fleet endpoint, by result: unchanged, changed or failed",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(fetches)
+}
+
+// Identity identifies the connector to the fleet endpoint. It's also the data the configuration is rendered with as a
+// text/template, e.g. `tag: [host={{ .Hostname }}]`.
+type Identity struct {
+	// ConnectorID is the connector ID that stays the same across restarts, empty without a connector ID file
+	ConnectorID string
+	Hostname    string
+	OS          string
+	Arch        string
+	Version     string
+}
+
+// ChangedError is returned by Fetcher.Run when the configuration of the endpoint changed, cloudflared is then
+// restarted to run with it.
+type ChangedError struct {
+	CachePath string
+}
+
+func (e *ChangedError) Error() string {
+	return fmt.Sprintf("the fleet configuration changed and was saved to %s, restarting to apply it", e.CachePath)
+}
+
+// Fetcher fetches the configuration from the fleet endpoint and caches it in a local file, which cloudflared then
+// reads as its configuration file. The cache lets cloudflared start while the endpoint is unreachable.
+type Fetcher struct {
+	endpoint  string
+	token     string
+	cachePath string
+	identity  Identity
+	client    *http.Client
+	log       *zerolog.Logger
+}
+
+// NewFetcher returns a Fetcher of the HTTPS endpoint, authenticated with token if it isn't empty.
+func NewFetcher(endpoint, token, cachePath string, identity Identity, log *zerolog.Logger) (*Fetcher, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid fleet configuration URL")
+	}
+	if u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("the fleet configuration URL %s must be an https URL", endpoint)
+	}
+	if cachePath == "" {
+		return nil, errors.New("the fleet configuration needs a cache file")
+	}
+	return &Fetcher{
+		endpoint:  endpoint,
+		token:     token,
+		cachePath: cachePath,
+		identity:  identity,
+		client:    &http.Client{Timeout: fetchTimeout},
+		log:       log,
+	}, nil
+}
+
+// CachePath is the file the configuration is cached in.
+func (f *Fetcher) CachePath() string {
+	return f.cachePath
+}
+
+// Bootstrap fetches the configuration and caches it. If the endpoint fails, the configuration cached by a previous run
+// is used instead, and it's only an error if there is none.
+func (f *Fetcher) Bootstrap(ctx context.Context) error {
+	_, err := f.refresh(ctx)
+	if err == nil {
+		return nil
+	}
+	if _, statErr := os.Stat(f.cachePath); statErr != nil {
+		return errors.Wrap(err, "unable to fetch the fleet configuration and none is cached")
+	}
+	f.log.Warn().Err(err).Msgf("Unable to fetch the fleet configuration, starting with the one cached in %s", f.cachePath)
+	return nil
+}
+
+// Run fetches the configuration every interval until ctx is done, and returns a ChangedError once it differs from the
+// cached one. Failed fetches are logged and retried on the next interval.
+func (f *Fetcher) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		changed, err := f.refresh(ctx)
+		if err != nil {
+			f.log.Err(err).Msg("Unable to refresh the fleet configuration")
+			continue
+		}
+		if changed {
+			return &ChangedError{CachePath: f.cachePath}
+		}
+	}
+}
+
+// refresh fetches the configuration, and caches it if it differs from the cached one.
+func (f *Fetcher) refresh(ctx context.Context) (changed bool, err error) {
+	config, err := f.fetch(ctx)
+	if err != nil {
+		fetches.WithLabelValues("failed").Inc()
+		return false, err
+	}
+	cached, err := os.ReadFile(f.cachePath)
+	if err == nil && bytes.Equal(cached, config) {
+		fetches.WithLabelValues("unchanged").Inc()
+		return false, nil
+	}
+	if err := writeCache(f.cachePath, config); err != nil {
+		fetches.WithLabelValues("failed").Inc()
+		return false, errors.Wrapf(err, "unable to cache the fleet configuration in %s", f.cachePath)
+	}
+	fetches.WithLabelValues("changed").Inc()
+	f.log.Info().Msgf("Fetched a new fleet configuration, cached in %s", f.cachePath)
+	return true, nil
+}
+
+// fetch returns the configuration of the endpoint rendered for the identity of the connector.
+func (f *Fetcher) fetch(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.endpoint, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", "cloudflared/"+f.identity.Version)
+	req.Header.Set("Accept", "application/yaml")
+	if f.identity.ConnectorID != "" {
+		req.Header.Set(headerConnectorID, f.identity.ConnectorID)
+	}
+	req.Header.Set(headerHostname, f.identity.Hostname)
+	req.Header.Set(headerOSArch, f.identity.OS+"_"+f.identity.Arch)
+	if f.token != "" {
+		req.Header.Set("Authorization", "Bearer "+f.token)
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to reach the fleet configuration endpoint")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("the fleet configuration endpoint responded with status %d", resp.StatusCode)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxConfigSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read the fleet configuration")
+	}
+	if len(body) > maxConfigSize {
+		return nil, fmt.Errorf("the fleet configuration exceeds %d bytes", maxConfigSize)
+	}
+	return Render(body, f.identity)
+}
+
+// Render renders the configuration template with identity, and validates it's a YAML mapping.
+func Render(config []byte, identity Identity) ([]byte, error) {
+	tmpl, err := template.New("fleet").Option("missingkey=error").Parse(string(config))
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid fleet configuration template")
+	}
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, identity); err != nil {
+		return nil, errors.Wrap(err, "unable to render the fleet configuration template")
+	}
+	var settings map[string]any
+	if err := yaml.Unmarshal(rendered.Bytes(), &settings); err != nil {
+		return nil, errors.Wrap(err, "the fleet configuration isn't a YAML mapping")
+	}
+	if len(settings) == 0 {
+		return nil, errors.New("the fleet configuration is empty")
+	}
+	return rendered.Bytes(), nil
+}
+
+// writeCache writes config to a temporary file renamed over path, so that a crash never leaves a truncated cache
+// behind. The configuration may hold secrets, so the file is only readable by its owner.
+func writeCache(path string, config []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(config); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,106 @@
+package fleet
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testIdentity = Identity{
+	ConnectorID: "7a1a2d3c-4b5e-4f60-8a7b-9c0d1e2f3a4b",
+	Hostname:    "edge-host-1",
+	OS:          "linux",
+	Arch:        "amd64",
+	Version:     "2025.1.0",
+}
+
+func newTestFetcher(t *testing.T, handler http.HandlerFunc) *Fetcher {
+	server := httptest.NewTLSServer(handler)
+	t.Cleanup(server.Close)
+	log := zerolog.Nop()
+	fetcher, err := NewFetcher(server.URL, "secret", filepath.Join(t.TempDir(), DefaultCacheFile), testIdentity, &log)
+	require.NoError(t, err)
+	fetcher.client = server.Client()
+	return fetcher
+}
+
+func TestBootstrap(t *testing.T) {
+	fetcher := newTestFetcher(t, func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		assert.Equal(t, testIdentity.ConnectorID, r.Header.Get(headerConnectorID))
+		assert.Equal(t, "linux_amd64", r.Header.Get(headerOSArch))
+		_, _ = w.Write([]byte("tunnel: fleet\ntag: [host={{ .Hostname }}]\n"))
+	})
+
+	require.NoError(t, fetcher.Bootstrap(context.Background()))
+	cached, err := os.ReadFile(fetcher.CachePath())
+	require.NoError(t, err)
+	assert.Equal(t, "tunnel: fleet\ntag: [host=edge-host-1]\n", string(cached))
+}
+
+func TestBootstrapFallsBackToCache(t *testing.T) {
+	fetcher := newTestFetcher(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	// Nothing is cached yet
+	assert.Error(t, fetcher.Bootstrap(context.Background()))
+
+	require.NoError(t, os.WriteFile(fetcher.CachePath(), []byte("tunnel: cached\n"), 0600))
+	require.NoError(t, fetcher.Bootstrap(context.Background()))
+	cached, err := os.ReadFile(fetcher.CachePath())
+	require.NoError(t, err)
+	assert.Equal(t, "tunnel: cached\n", string(cached))
+}
+
+func TestRunReturnsOnChange(t *testing.T) {
+	var requests atomic.Int32
+	fetcher := newTestFetcher(t, func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) < 3 {
+			_, _ = w.Write([]byte("tunnel: fleet\n"))
+			return
+		}
+		_, _ = w.Write([]byte("tunnel: fleet\nha-connections: 2\n"))
+	})
+	require.NoError(t, fetcher.Bootstrap(context.Background()))
+
+	err := fetcher.Run(context.Background(), 10*time.Millisecond)
+	var changedErr *ChangedError
+	require.ErrorAs(t, err, &changedErr)
+	assert.Equal(t, fetcher.CachePath(), changedErr.CachePath)
+	assert.Equal(t, int32(3), requests.Load())
+	cached, err := os.ReadFile(fetcher.CachePath())
+	require.NoError(t, err)
+	assert.Equal(t, "tunnel: fleet\nha-connections: 2\n", string(cached))
+}
+
+func TestRender(t *testing.T) {
+	for name, config := range map[string]string{
+		"unknown field": "tunnel: {{ .Region }}\n",
+		"invalid YAML":  "tunnel: [fleet\n",
+		"not a mapping": "- tunnel\n",
+		"empty":         "",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Render([]byte(config), testIdentity)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestNewFetcherRequiresHTTPS(t *testing.T) {
+	log := zerolog.Nop()
+	_, err := NewFetcher("http://fleet.example.com/config", "", "fleet-config.yml", testIdentity, &log)
+	assert.Error(t, err)
+	_, err = NewFetcher("https://fleet.example.com/config", "", "", testIdentity, &log)
+	assert.Error(t, err)
+}
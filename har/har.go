@@ -0,0 +1,189 @@
+// Package har records proxied HTTP requests as HAR 1.2 entries, which browsers' developer tools and HAR viewers
+// open, for debugging requests to origins after the fact.
+package har
+
+import (
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cloudflare/cloudflared/redact"
+)
+
+const version = "1.2"
+
+// sensitiveHeaders carry credentials, their values are redacted whatever they look like.
+var sensitiveHeaders = map[string]bool{
+	"Authorization":           true,
+	"Proxy-Authorization":     true,
+	"Cookie":                  true,
+	"Set-Cookie":              true,
+	"Cf-Access-Jwt-Assertion": true,
+	"Cf-Access-Token":         true,
+}
+
+// File is a HAR file.
+type File struct {
+	Log Log `json:"log"`
+}
+
+type Log struct {
+	Version string  `json:"version"`
+	Creator Creator `json:"creator"`
+	Entries []Entry `json:"entries"`
+	Comment string  `json:"comment,omitempty"`
+}
+
+type Creator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type Entry struct {
+	StartedDateTime time.Time `json:"startedDateTime"`
+	// Total time of the request, in milliseconds
+	Time     float64  `json:"time"`
+	Request  Request  `json:"request"`
+	Response Response `json:"response"`
+	Cache    struct{} `json:"cache"`
+	Timings  Timings  `json:"timings"`
+	Comment  string   `json:"comment,omitempty"`
+}
+
+type Request struct {
+	Method      string      `json:"method"`
+	URL         string      `json:"url"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	QueryString []NameValue `json:"queryString"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+type Response struct {
+	Status      int         `json:"status"`
+	StatusText  string      `json:"statusText"`
+	HTTPVersion string      `json:"httpVersion"`
+	Cookies     []NameValue `json:"cookies"`
+	Headers     []NameValue `json:"headers"`
+	Content     Content     `json:"content"`
+	RedirectURL string      `json:"redirectURL"`
+	HeadersSize int         `json:"headersSize"`
+	BodySize    int64       `json:"bodySize"`
+}
+
+type Content struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+}
+
+// Timings are in milliseconds. Send isn't measured, the request is streamed to the origin as it arrives.
+type Timings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+type NameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Exchange is what the proxy knows of a request once it is answered.
+type Exchange struct {
+	Request *http.Request
+	Start   time.Time
+	// HeadersTime is when the headers of the response were written, zero if they weren't
+	HeadersTime time.Time
+	End         time.Time
+	Status      int
+	Header      http.Header
+	Bytes       int64
+	// Error that failed the request, if any
+	Error error
+}
+
+// NewEntry returns the entry of e, with the credentials and secrets of the request and response redacted.
+func NewEntry(e Exchange) Entry {
+	req := e.Request
+	reqURL := url.URL{Scheme: "https", Host: req.Host, Path: req.URL.Path, RawQuery: req.URL.RawQuery}
+	entry := Entry{
+		StartedDateTime: e.Start,
+		Time:            milliseconds(e.End.Sub(e.Start)),
+		Request: Request{
+			Method:      req.Method,
+			URL:         redact.String(reqURL.String()),
+			HTTPVersion: req.Proto,
+			Cookies:     []NameValue{},
+			Headers:     headers(req.Header),
+			QueryString: queryString(req.URL.Query()),
+			HeadersSize: -1,
+			BodySize:    req.ContentLength,
+		},
+		Response: Response{
+			Status:      e.Status,
+			StatusText:  http.StatusText(e.Status),
+			HTTPVersion: req.Proto,
+			Cookies:     []NameValue{},
+			Headers:     headers(e.Header),
+			Content:     Content{Size: e.Bytes, MimeType: e.Header.Get("Content-Type")},
+			RedirectURL: e.Header.Get("Location"),
+			HeadersSize: -1,
+			BodySize:    e.Bytes,
+		},
+	}
+	if e.HeadersTime.IsZero() {
+		entry.Timings.Wait = entry.Time
+	} else {
+		entry.Timings.Wait = milliseconds(e.HeadersTime.Sub(e.Start))
+		entry.Timings.Receive = milliseconds(e.End.Sub(e.HeadersTime))
+	}
+	if e.Error != nil {
+		entry.Comment = redact.Error(e.Error)
+	}
+	return entry
+}
+
+func headers(header http.Header) []NameValue {
+	values := []NameValue{}
+	for name, headerValues := range header {
+		for _, value := range headerValues {
+			if sensitiveHeaders[http.CanonicalHeaderKey(name)] {
+				value = redact.Placeholder
+			} else {
+				// The patterns of redact match headers by their name, e.g. X-Api-Key
+				_, value, _ = strings.Cut(redact.String(name+": "+value), ": ")
+			}
+			values = append(values, NameValue{Name: name, Value: value})
+		}
+	}
+	sortNameValues(values)
+	return values
+}
+
+func queryString(query url.Values) []NameValue {
+	values := []NameValue{}
+	for name, queryValues := range query {
+		for _, value := range queryValues {
+			// The patterns of redact match key=value pairs, so the pair is redacted as a whole
+			pair := redact.String(name + "=" + value)
+			name, value, _ := strings.Cut(pair, "=")
+			values = append(values, NameValue{Name: name, Value: value})
+		}
+	}
+	sortNameValues(values)
+	return values
+}
+
+func sortNameValues(values []NameValue) {
+	sort.SliceStable(values, func(i, j int) bool {
+		return values[i].Name < values[j].Name
+	})
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
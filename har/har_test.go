@@ -0,0 +1,137 @@
+package har
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/redact"
+)
+
+func TestNewEntry(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "https://app.example.com/orders?id=1&token=s3cr3t-value", nil)
+	req.Header.Set("Authorization", "Bearer abc")
+	req.Header.Set("Cookie", "session=abc")
+	req.Header.Set("X-Api-Key", "k3y-value-secret")
+	req.Header.Set("Accept", "application/json")
+	start := time.Now()
+
+	entry := NewEntry(Exchange{
+		Request:     req,
+		Start:       start,
+		HeadersTime: start.Add(30 * time.Millisecond),
+		End:         start.Add(50 * time.Millisecond),
+		Status:      http.StatusBadGateway,
+		Header:      http.Header{"Content-Type": []string{"text/plain"}, "Set-Cookie": []string{"session=abc"}},
+		Bytes:       12,
+		Error:       errors.New("origin timed out"),
+	})
+	require.Equal(t, "https://app.example.com/orders?id=1&token="+redact.Placeholder, entry.Request.URL)
+	require.Equal(t, []NameValue{
+		{Name: "Accept", Value: "application/json"},
+		{Name: "Authorization", Value: redact.Placeholder},
+		{Name: "Cookie", Value: redact.Placeholder},
+		{Name: "X-Api-Key", Value: redact.Placeholder},
+	}, entry.Request.Headers)
+	require.Equal(t, []NameValue{{Name: "id", Value: "1"}, {Name: "token", Value: redact.Placeholder}}, entry.Request.QueryString)
+	require.Equal(t, []NameValue{
+		{Name: "Content-Type", Value: "text/plain"},
+		{Name: "Set-Cookie", Value: redact.Placeholder},
+	}, entry.Response.Headers)
+	require.Equal(t, "Bad Gateway", entry.Response.StatusText)
+	require.Equal(t, Content{Size: 12, MimeType: "text/plain"}, entry.Response.Content)
+	require.Equal(t, float64(50), entry.Time)
+	require.Equal(t, Timings{Wait: 30, Receive: 20}, entry.Timings)
+	require.Equal(t, "origin timed out", entry.Comment)
+}
+
+func TestRecorderCapture(t *testing.T) {
+	// A nil recorder, when the diagnostic services are disabled, records nothing
+	var disabled *Recorder
+	disabled.Record(Exchange{})
+
+	recorder := NewRecorder("2026.10.0", 0)
+	record := func(host string) {
+		recorder.Record(Exchange{Request: httptest.NewRequest(http.MethodGet, "https://"+host, nil), Status: http.StatusOK})
+	}
+	// Requests are only recorded while captured
+	record("app.example.com")
+
+	var captured bytes.Buffer
+	done := make(chan error)
+	go func() {
+		done <- recorder.Capture(context.Background(), &captured, "app.example.com", time.Minute, 1024)
+	}()
+	require.Eventually(t, func() bool { return recorder.activeCaptures.Load() == 1 }, time.Second, time.Millisecond)
+	record("api.example.com")
+	record("APP.example.com")
+	// The capture ends once it would exceed its size
+	for i := 0; i < 10; i++ {
+		record("app.example.com")
+	}
+
+	require.NoError(t, <-done)
+	var file File
+	require.NoError(t, json.Unmarshal(captured.Bytes(), &file))
+	require.Equal(t, Creator{Name: "cloudflared", Version: "2026.10.0"}, file.Log.Creator)
+	require.NotEmpty(t, file.Log.Entries)
+	require.Less(t, len(file.Log.Entries), 11)
+	for _, entry := range file.Log.Entries {
+		require.Contains(t, strings.ToLower(entry.Request.URL), "//app.example.com")
+	}
+	require.EqualValues(t, 0, recorder.activeCaptures.Load())
+}
+
+func TestRecorderCaptureDropsEntries(t *testing.T) {
+	recorder := NewRecorder("2026.10.0", 0)
+	// The client doesn't read the capture until the proxy moved on
+	reader, writer := io.Pipe()
+	done := make(chan error)
+	go func() {
+		done <- recorder.Capture(context.Background(), writer, "", 10*time.Millisecond, 1024*1024)
+		writer.Close()
+	}()
+	require.Eventually(t, func() bool { return recorder.activeCaptures.Load() == 1 }, time.Second, time.Millisecond)
+	for i := 0; i < 2*captureBuffer; i++ {
+		recorder.Record(Exchange{Request: httptest.NewRequest(http.MethodGet, "https://app.example.com", nil), Status: http.StatusOK})
+	}
+
+	var file File
+	require.NoError(t, json.NewDecoder(reader).Decode(&file))
+	require.NoError(t, <-done)
+	// Only the entries the capture buffers are kept
+	require.Len(t, file.Log.Entries, captureBuffer)
+	require.Equal(t, fmt.Sprintf("%d requests were left out, proxied faster than the capture was downloaded", captureBuffer), file.Log.Comment)
+}
+
+func TestRecorderFailures(t *testing.T) {
+	recorder := NewRecorder("2026.10.0", 3)
+	record := func(path string, status int, err error) {
+		recorder.Record(Exchange{Request: httptest.NewRequest(http.MethodGet, "https://app.example.com"+path, nil), Status: status, Error: err})
+	}
+	record("/ok", http.StatusOK, nil)
+	record("/1", http.StatusBadGateway, nil)
+	require.Len(t, recorder.Failures().Log.Entries, 1)
+
+	record("/2", http.StatusServiceUnavailable, nil)
+	record("/not-found", http.StatusNotFound, nil)
+	record("/3", http.StatusOK, errors.New("stream reset"))
+	record("/4", http.StatusGatewayTimeout, nil)
+
+	var paths []string
+	for _, entry := range recorder.Failures().Log.Entries {
+		paths = append(paths, strings.TrimPrefix(entry.Request.URL, "https://app.example.com"))
+	}
+	// Only the last 3 are kept, the oldest first
+	require.Equal(t, []string{"/2", "/3", "/4"}, paths)
+}
@@ -0,0 +1,244 @@
+package har
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultMaxFailures is the number of failed requests a Recorder keeps by default.
+const DefaultMaxFailures = 100
+
+// Recorder hands the exchanges of the proxy to the captures in progress, and keeps the last failed ones. A nil
+// Recorder records nothing.
+type Recorder struct {
+	creator Creator
+
+	// failures is a ring of the last maxFailures failed exchanges, the oldest at nextFailure once it is full
+	failuresLock sync.Mutex
+	failures     []Entry
+	nextFailure  int
+	maxFailures  int
+
+	capturesLock sync.Mutex
+	captures     map[*capture]struct{}
+	// activeCaptures lets the proxy skip building entries while nothing is captured
+	activeCaptures atomic.Int32
+}
+
+// captureBuffer is the number of entries a capture holds while they are written, entries coming faster are dropped
+// so that a slow client can't hold on to the memory of the proxy.
+const captureBuffer = 64
+
+// capture receives the entries of the requests to a hostname, or to any hostname when it is empty.
+type capture struct {
+	hostname string
+	entries  chan *Entry
+	dropped  atomic.Int64
+}
+
+// NewRecorder returns a Recorder keeping the last maxFailures failed exchanges.
+func NewRecorder(cloudflaredVersion string, maxFailures int) *Recorder {
+	return &Recorder{
+		creator:     Creator{Name: "cloudflared", Version: cloudflaredVersion},
+		maxFailures: maxFailures,
+		captures:    make(map[*capture]struct{}),
+	}
+}
+
+// Record keeps the exchange if it failed, with a 5xx status or an error, and adds it to the captures of its
+// hostname.
+func (r *Recorder) Record(e Exchange) {
+	if r == nil {
+		return
+	}
+	failed := r.maxFailures > 0 && (e.Status >= 500 || e.Error != nil)
+	if !failed && r.activeCaptures.Load() == 0 {
+		return
+	}
+	var entry *Entry
+	if failed {
+		entry = r.addFailure(e)
+	}
+	if r.activeCaptures.Load() > 0 {
+		r.addToCaptures(e, entry)
+	}
+}
+
+func (r *Recorder) addFailure(e Exchange) *Entry {
+	entry := NewEntry(e)
+	r.failuresLock.Lock()
+	defer r.failuresLock.Unlock()
+	if len(r.failures) < r.maxFailures {
+		r.failures = append(r.failures, entry)
+	} else {
+		r.failures[r.nextFailure] = entry
+		r.nextFailure = (r.nextFailure + 1) % r.maxFailures
+	}
+	return &entry
+}
+
+// Failures returns the last failed exchanges, the oldest first.
+func (r *Recorder) Failures() File {
+	r.failuresLock.Lock()
+	defer r.failuresLock.Unlock()
+	entries := make([]Entry, 0, len(r.failures))
+	entries = append(entries, r.failures[r.nextFailure:]...)
+	entries = append(entries, r.failures[:r.nextFailure]...)
+	return r.file(entries)
+}
+
+// addToCaptures hands the exchange to the captures of its hostname, entry being its entry if it was already built.
+func (r *Recorder) addToCaptures(e Exchange, entry *Entry) {
+	r.capturesLock.Lock()
+	defer r.capturesLock.Unlock()
+	for c := range r.captures {
+		if c.hostname != "" && !strings.EqualFold(c.hostname, e.Request.Host) {
+			continue
+		}
+		if entry == nil {
+			built := NewEntry(e)
+			entry = &built
+		}
+		select {
+		case c.entries <- entry:
+		default:
+			c.dropped.Add(1)
+		}
+	}
+}
+
+// Capture writes the requests to hostname, or to any hostname when it is empty, to w as a HAR file as they are
+// proxied, until duration elapses, ctx is done or the entries would exceed maxSize bytes. Entries are streamed rather
+// than held in memory; those proxied faster than w takes them are dropped, and counted in the comment of the file.
+func (r *Recorder) Capture(ctx context.Context, w io.Writer, hostname string, duration time.Duration, maxSize int) error {
+	c := &capture{
+		hostname: hostname,
+		entries:  make(chan *Entry, captureBuffer),
+	}
+	r.capturesLock.Lock()
+	r.captures[c] = struct{}{}
+	r.capturesLock.Unlock()
+	r.activeCaptures.Add(1)
+	defer func() {
+		r.activeCaptures.Add(-1)
+		r.capturesLock.Lock()
+		delete(r.captures, c)
+		r.capturesLock.Unlock()
+	}()
+
+	stream := newEntryStream(w, r.file(nil))
+	if err := stream.start(); err != nil {
+		return err
+	}
+	size := 0
+	// write writes entry unless it would exceed maxSize, returns whether it was written
+	write := func(entry *Entry) (bool, error) {
+		encoded, err := json.Marshal(entry)
+		if err != nil {
+			return false, err
+		}
+		if size+len(encoded) > maxSize {
+			return false, nil
+		}
+		size += len(encoded)
+		return true, stream.write(encoded)
+	}
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+capture:
+	for {
+		select {
+		case entry := <-c.entries:
+			if written, err := write(entry); err != nil {
+				return err
+			} else if !written {
+				break capture
+			}
+		case <-timer.C:
+			// The entries proxied during the capture that weren't written yet are still written
+			for len(c.entries) > 0 {
+				if written, err := write(<-c.entries); err != nil {
+					return err
+				} else if !written {
+					break
+				}
+			}
+			break capture
+		case <-ctx.Done():
+			break capture
+		}
+	}
+	var comment string
+	if dropped := c.dropped.Load(); dropped > 0 {
+		comment = fmt.Sprintf("%d requests were left out, proxied faster than the capture was downloaded", dropped)
+	}
+	return stream.end(comment)
+}
+
+func (r *Recorder) file(entries []Entry) File {
+	return File{Log: Log{Version: version, Creator: r.creator, Entries: entries}}
+}
+
+// entryStream writes a HAR file one entry at a time.
+type entryStream struct {
+	w       io.Writer
+	file    File
+	entries int
+}
+
+func newEntryStream(w io.Writer, file File) *entryStream {
+	return &entryStream{w: w, file: file}
+}
+
+// start writes the file up to its first entry.
+func (s *entryStream) start() error {
+	head, err := json.Marshal(s.file.Log.Creator)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(s.w, `{"log":{"version":%q,"creator":%s,"entries":[`, s.file.Log.Version, head)
+	s.flush()
+	return err
+}
+
+func (s *entryStream) write(entry []byte) error {
+	if s.entries > 0 {
+		if _, err := s.w.Write([]byte(",")); err != nil {
+			return err
+		}
+	}
+	s.entries++
+	if _, err := s.w.Write(entry); err != nil {
+		return err
+	}
+	s.flush()
+	return nil
+}
+
+// end writes the rest of the file after the last entry.
+func (s *entryStream) end(comment string) error {
+	tail := "]"
+	if comment != "" {
+		encoded, err := json.Marshal(comment)
+		if err != nil {
+			return err
+		}
+		tail += `,"comment":` + string(encoded)
+	}
+	_, err := io.WriteString(s.w, tail+"}}\n")
+	s.flush()
+	return err
+}
+
+func (s *entryStream) flush() {
+	if flusher, ok := s.w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
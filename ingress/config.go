@@ -19,26 +19,32 @@ var (
 	defaultKeepAliveTimeout          = config.CustomDuration{Duration: 90 * time.Second}
 )
 
+const defaultRequestBodyBufferMaxSize = 64 * (1 << 20) // 64 MB
+
 const (
-	defaultProxyAddress           = "127.0.0.1"
-	defaultKeepAliveConnections   = 100
-	defaultMaxActiveFlows         = 0 // unlimited
-	SSHServerFlag                 = "ssh-server"
-	Socks5Flag                    = "socks5"
-	ProxyConnectTimeoutFlag       = "proxy-connect-timeout"
-	ProxyTLSTimeoutFlag           = "proxy-tls-timeout"
-	ProxyTCPKeepAliveFlag         = "proxy-tcp-keepalive"
-	ProxyNoHappyEyeballsFlag      = "proxy-no-happy-eyeballs"
-	ProxyKeepAliveConnectionsFlag = "proxy-keepalive-connections"
-	ProxyKeepAliveTimeoutFlag     = "proxy-keepalive-timeout"
-	HTTPHostHeaderFlag            = "http-host-header"
-	OriginServerNameFlag          = "origin-server-name"
-	MatchSNIToHostFlag            = "match-sni-to-host"
-	NoTLSVerifyFlag               = "no-tls-verify"
-	NoChunkedEncodingFlag         = "no-chunked-encoding"
-	ProxyAddressFlag              = "proxy-address"
-	ProxyPortFlag                 = "proxy-port"
-	Http2OriginFlag               = "http2-origin"
+	defaultProxyAddress            = "127.0.0.1"
+	defaultKeepAliveConnections    = 100
+	defaultMaxActiveFlows          = 0 // unlimited
+	SSHServerFlag                  = "ssh-server"
+	Socks5Flag                     = "socks5"
+	ProxyConnectTimeoutFlag        = "proxy-connect-timeout"
+	ProxyTLSTimeoutFlag            = "proxy-tls-timeout"
+	ProxyResponseHeaderTimeoutFlag = "proxy-response-header-timeout"
+	ProxyTCPKeepAliveFlag          = "proxy-tcp-keepalive"
+	ProxyNoHappyEyeballsFlag       = "proxy-no-happy-eyeballs"
+	ProxyKeepAliveConnectionsFlag  = "proxy-keepalive-connections"
+	ProxyKeepAliveTimeoutFlag      = "proxy-keepalive-timeout"
+	HTTPHostHeaderFlag             = "http-host-header"
+	OriginServerNameFlag           = "origin-server-name"
+	MatchSNIToHostFlag             = "match-sni-to-host"
+	NoTLSVerifyFlag                = "no-tls-verify"
+	NoChunkedEncodingFlag          = "no-chunked-encoding"
+	ProxyAddressFlag               = "proxy-address"
+	ProxyPortFlag                  = "proxy-port"
+	Http2OriginFlag                = "http2-origin"
+	Http3OriginFlag                = "http3-origin"
+	RequestBodyBufferDirFlag       = "request-body-buffer-dir"
+	RequestBodyBufferMaxSizeFlag   = "request-body-buffer-max-size"
 )
 
 const (
@@ -49,6 +55,10 @@ type WarpRoutingConfig struct {
 	ConnectTimeout config.CustomDuration `yaml:"connectTimeout" json:"connectTimeout,omitempty"`
 	MaxActiveFlows uint64                `yaml:"maxActiveFlows" json:"MaxActiveFlows,omitempty"`
 	TCPKeepAlive   config.CustomDuration `yaml:"tcpKeepAlive" json:"tcpKeepAlive,omitempty"`
+	// TCP socket options of connections to origins, 0 keeps the OS defaults
+	TCPMaxSegmentSize  int `yaml:"tcpMaxSegmentSize" json:"tcpMaxSegmentSize,omitempty"`
+	TCPReadBufferSize  int `yaml:"tcpReadBufferSize" json:"tcpReadBufferSize,omitempty"`
+	TCPWriteBufferSize int `yaml:"tcpWriteBufferSize" json:"tcpWriteBufferSize,omitempty"`
 }
 
 func NewWarpRoutingConfig(raw *config.WarpRoutingConfig) WarpRoutingConfig {
@@ -66,6 +76,15 @@ func NewWarpRoutingConfig(raw *config.WarpRoutingConfig) WarpRoutingConfig {
 	if raw.TCPKeepAlive != nil {
 		cfg.TCPKeepAlive = *raw.TCPKeepAlive
 	}
+	if raw.TCPMaxSegmentSize != nil {
+		cfg.TCPMaxSegmentSize = *raw.TCPMaxSegmentSize
+	}
+	if raw.TCPReadBufferSize != nil {
+		cfg.TCPReadBufferSize = *raw.TCPReadBufferSize
+	}
+	if raw.TCPWriteBufferSize != nil {
+		cfg.TCPWriteBufferSize = *raw.TCPWriteBufferSize
+	}
 	return cfg
 }
 
@@ -80,6 +99,15 @@ func (c *WarpRoutingConfig) RawConfig() config.WarpRoutingConfig {
 	if c.TCPKeepAlive.Duration != defaultTCPKeepAlive.Duration {
 		raw.TCPKeepAlive = &c.TCPKeepAlive
 	}
+	if c.TCPMaxSegmentSize != 0 {
+		raw.TCPMaxSegmentSize = &c.TCPMaxSegmentSize
+	}
+	if c.TCPReadBufferSize != 0 {
+		raw.TCPReadBufferSize = &c.TCPReadBufferSize
+	}
+	if c.TCPWriteBufferSize != 0 {
+		raw.TCPWriteBufferSize = &c.TCPWriteBufferSize
+	}
 	return raw
 }
 
@@ -122,6 +150,7 @@ func (rc *RemoteConfig) UnmarshalJSON(b []byte) error {
 func originRequestFromSingleRule(c *cli.Context) OriginRequestConfig {
 	var connectTimeout = defaultHTTPConnectTimeout
 	var tlsTimeout = defaultTLSTimeout
+	var responseHeaderTimeout config.CustomDuration
 	var tcpKeepAlive = defaultTCPKeepAlive
 	var noHappyEyeballs bool
 	var keepAliveConnections = defaultKeepAliveConnections
@@ -137,12 +166,18 @@ func originRequestFromSingleRule(c *cli.Context) OriginRequestConfig {
 	var proxyPort uint
 	var proxyType string
 	var http2Origin bool
+	var http3Origin bool
+	var requestBodyBufferDir string
+	var requestBodyBufferMaxSize = defaultRequestBodyBufferMaxSize
 	if flag := ProxyConnectTimeoutFlag; c.IsSet(flag) {
 		connectTimeout = config.CustomDuration{Duration: c.Duration(flag)}
 	}
 	if flag := ProxyTLSTimeoutFlag; c.IsSet(flag) {
 		tlsTimeout = config.CustomDuration{Duration: c.Duration(flag)}
 	}
+	if flag := ProxyResponseHeaderTimeoutFlag; c.IsSet(flag) {
+		responseHeaderTimeout = config.CustomDuration{Duration: c.Duration(flag)}
+	}
 	if flag := ProxyTCPKeepAliveFlag; c.IsSet(flag) {
 		tcpKeepAlive = config.CustomDuration{Duration: c.Duration(flag)}
 	}
@@ -187,28 +222,41 @@ func originRequestFromSingleRule(c *cli.Context) OriginRequestConfig {
 	if flag := Http2OriginFlag; c.IsSet(flag) {
 		http2Origin = c.Bool(flag)
 	}
+	if flag := Http3OriginFlag; c.IsSet(flag) {
+		http3Origin = c.Bool(flag)
+	}
+	if flag := RequestBodyBufferDirFlag; c.IsSet(flag) {
+		requestBodyBufferDir = c.String(flag)
+	}
+	if flag := RequestBodyBufferMaxSizeFlag; c.IsSet(flag) {
+		requestBodyBufferMaxSize = c.Int(flag)
+	}
 	if c.IsSet(Socks5Flag) {
 		proxyType = socksProxy
 	}
 
 	return OriginRequestConfig{
-		ConnectTimeout:         connectTimeout,
-		TLSTimeout:             tlsTimeout,
-		TCPKeepAlive:           tcpKeepAlive,
-		NoHappyEyeballs:        noHappyEyeballs,
-		KeepAliveConnections:   keepAliveConnections,
-		KeepAliveTimeout:       keepAliveTimeout,
-		HTTPHostHeader:         httpHostHeader,
-		OriginServerName:       originServerName,
-		MatchSNIToHost:         matchSNItoHost,
-		CAPool:                 caPool,
-		NoTLSVerify:            noTLSVerify,
-		DisableChunkedEncoding: disableChunkedEncoding,
-		BastionMode:            bastionMode,
-		ProxyAddress:           proxyAddress,
-		ProxyPort:              proxyPort,
-		ProxyType:              proxyType,
-		Http2Origin:            http2Origin,
+		ConnectTimeout:           connectTimeout,
+		TLSTimeout:               tlsTimeout,
+		ResponseHeaderTimeout:    responseHeaderTimeout,
+		TCPKeepAlive:             tcpKeepAlive,
+		NoHappyEyeballs:          noHappyEyeballs,
+		KeepAliveConnections:     keepAliveConnections,
+		KeepAliveTimeout:         keepAliveTimeout,
+		HTTPHostHeader:           httpHostHeader,
+		OriginServerName:         originServerName,
+		MatchSNIToHost:           matchSNItoHost,
+		CAPool:                   caPool,
+		NoTLSVerify:              noTLSVerify,
+		DisableChunkedEncoding:   disableChunkedEncoding,
+		BastionMode:              bastionMode,
+		ProxyAddress:             proxyAddress,
+		ProxyPort:                proxyPort,
+		ProxyType:                proxyType,
+		Http2Origin:              http2Origin,
+		Http3Origin:              http3Origin,
+		RequestBodyBufferDir:     requestBodyBufferDir,
+		RequestBodyBufferMaxSize: requestBodyBufferMaxSize,
 	}
 }
 
@@ -220,6 +268,8 @@ func originRequestFromConfig(c config.OriginRequestConfig) OriginRequestConfig {
 		KeepAliveConnections: defaultKeepAliveConnections,
 		KeepAliveTimeout:     defaultKeepAliveTimeout,
 		ProxyAddress:         defaultProxyAddress,
+
+		RequestBodyBufferMaxSize: defaultRequestBodyBufferMaxSize,
 	}
 	if c.ConnectTimeout != nil {
 		out.ConnectTimeout = *c.ConnectTimeout
@@ -227,6 +277,9 @@ func originRequestFromConfig(c config.OriginRequestConfig) OriginRequestConfig {
 	if c.TLSTimeout != nil {
 		out.TLSTimeout = *c.TLSTimeout
 	}
+	if c.ResponseHeaderTimeout != nil {
+		out.ResponseHeaderTimeout = *c.ResponseHeaderTimeout
+	}
 	if c.TCPKeepAlive != nil {
 		out.TCPKeepAlive = *c.TCPKeepAlive
 	}
@@ -280,9 +333,69 @@ func originRequestFromConfig(c config.OriginRequestConfig) OriginRequestConfig {
 	if c.Http2Origin != nil {
 		out.Http2Origin = *c.Http2Origin
 	}
+	if c.Http3Origin != nil {
+		out.Http3Origin = *c.Http3Origin
+	}
+	if c.RequestBodyBufferDir != nil {
+		out.RequestBodyBufferDir = *c.RequestBodyBufferDir
+	}
+	if c.RequestBodyBufferMaxSize != nil {
+		out.RequestBodyBufferMaxSize = *c.RequestBodyBufferMaxSize
+	}
 	if c.Access != nil {
 		out.Access = *c.Access
 	}
+	if c.TagHeaders != nil {
+		out.TagHeaders = c.TagHeaders
+	}
+	if c.RequestIDHeader != nil {
+		out.RequestIDHeader = *c.RequestIDHeader
+	}
+	if c.TLSMinVersion != nil {
+		out.TLSMinVersion = *c.TLSMinVersion
+	}
+	if c.TLSMaxVersion != nil {
+		out.TLSMaxVersion = *c.TLSMaxVersion
+	}
+	if c.TLSCipherSuites != nil {
+		out.TLSCipherSuites = c.TLSCipherSuites
+	}
+	if c.OriginCertFingerprint != nil {
+		out.OriginCertFingerprint = *c.OriginCertFingerprint
+	}
+	if c.Socks != nil {
+		out.Socks = c.Socks
+	}
+	if c.SessionRecording != nil {
+		out.SessionRecording = c.SessionRecording
+	}
+	if c.LatencyMode != nil {
+		out.LatencyMode = *c.LatencyMode
+	}
+	if c.ProxyProtocol != nil {
+		out.ProxyProtocol = *c.ProxyProtocol
+	}
+	if c.UDPIdleTimeout != nil {
+		out.UDPIdleTimeout = *c.UDPIdleTimeout
+	}
+	if c.CORS != nil {
+		out.CORS = c.CORS
+	}
+	if c.ExtAuthz != nil {
+		out.ExtAuthz = c.ExtAuthz
+	}
+	if c.DNS != nil {
+		out.DNS = c.DNS
+	}
+	if c.Warmup != nil {
+		out.Warmup = c.Warmup
+	}
+	if c.Transform != nil {
+		out.Transform = c.Transform
+	}
+	if c.Middleware != nil {
+		out.Middleware = c.Middleware
+	}
 	return out
 }
 
@@ -294,6 +407,8 @@ type OriginRequestConfig struct {
 	ConnectTimeout config.CustomDuration `yaml:"connectTimeout" json:"connectTimeout"`
 	// HTTP proxy timeout for completing a TLS handshake
 	TLSTimeout config.CustomDuration `yaml:"tlsTimeout" json:"tlsTimeout"`
+	// HTTP proxy timeout for receiving the response headers once the request is sent, 0 waits indefinitely
+	ResponseHeaderTimeout config.CustomDuration `yaml:"responseHeaderTimeout" json:"responseHeaderTimeout"`
 	// HTTP proxy TCP keepalive duration
 	TCPKeepAlive config.CustomDuration `yaml:"tcpKeepAlive" json:"tcpKeepAlive"`
 	// HTTP proxy should disable "happy eyeballs" for IPv4/v6 fallback
@@ -310,6 +425,8 @@ type OriginRequestConfig struct {
 	MatchSNIToHost bool `yaml:"matchSNItoHost" json:"matchSNItoHost"`
 	// Path to the CA for the certificate of your origin.
 	// This option should be used only if your certificate is not signed by Cloudflare.
+	// If the path is a directory, every certificate in it is trusted and the directory is re-scanned
+	// periodically so that rotated CAs are picked up without a restart.
 	CAPool string `yaml:"caPool" json:"caPool"`
 	// Disables TLS verification of the certificate presented by your origin.
 	// Will allow any certificate from the origin to be accepted.
@@ -330,9 +447,51 @@ type OriginRequestConfig struct {
 	IPRules []ipaccess.Rule `yaml:"ipRules" json:"ipRules"`
 	// Attempt to connect to origin with HTTP/2
 	Http2Origin bool `yaml:"http2Origin" json:"http2Origin"`
+	// Attempt to connect to https origins with HTTP/3, falling back to HTTP/2 or HTTP/1.1
+	Http3Origin bool `yaml:"http3Origin" json:"http3Origin"`
+	// Directory request bodies are buffered to, so that the edge stream doesn't stall while the origin reads them
+	// slowly. Buffering is disabled when empty.
+	RequestBodyBufferDir string `yaml:"requestBodyBufferDir" json:"requestBodyBufferDir"`
+	// Maximum bytes of a request body buffered to disk, once reached the body is only read as fast as the origin
+	// reads it.
+	RequestBodyBufferMaxSize int `yaml:"requestBodyBufferMaxSize" json:"requestBodyBufferMaxSize"`
 
 	// Access holds all access related configs
 	Access config.AccessConfig `yaml:"access" json:"access,omitempty"`
+	// Headers added to requests to the origin, mapping each header name to the registration tag whose value it is
+	// set to
+	TagHeaders map[string]string `yaml:"tagHeaders" json:"tagHeaders,omitempty"`
+	// Header the ID of each request is sent to the origin in, not sent when empty
+	RequestIDHeader string `yaml:"requestIDHeader" json:"requestIDHeader"`
+	// Minimum and maximum TLS versions to reach https origins with, Go's defaults when empty
+	TLSMinVersion string `yaml:"tlsMinVersion" json:"tlsMinVersion"`
+	TLSMaxVersion string `yaml:"tlsMaxVersion" json:"tlsMaxVersion"`
+	// TLS 1.0-1.2 cipher suites allowed with https origins, Go's defaults when empty
+	TLSCipherSuites []string `yaml:"tlsCipherSuites" json:"tlsCipherSuites"`
+	// SHA-256 fingerprint of the certificate https origins must present, replacing the verification against CAs
+	OriginCertFingerprint string `yaml:"originCertFingerprint" json:"originCertFingerprint"`
+	// Users, client CIDRs and limits of the socks-proxy service
+	Socks *config.SocksConfig `yaml:"socks" json:"socks,omitempty"`
+	// Recording of the sessions of bastion and TCP services
+	SessionRecording *config.SessionRecordingConfig `yaml:"sessionRecording" json:"sessionRecording,omitempty"`
+	// Prioritizes the responsiveness of interactive TCP services, e.g. RDP and VNC
+	LatencyMode bool `yaml:"latencyMode" json:"latencyMode"`
+	// Sends a PROXY protocol v2 header with the IP of the client to TCP services
+	ProxyProtocol bool `yaml:"proxyProtocol" json:"proxyProtocol"`
+	// Idle timeout of the sessions of udp:// services, defaultUDPIdleTimeout when 0
+	UDPIdleTimeout config.CustomDuration `yaml:"udpIdleTimeout" json:"udpIdleTimeout"`
+	// CORS policy handled by cloudflared instead of the origin
+	CORS *config.CORSConfig `yaml:"cors" json:"cors,omitempty"`
+	// External service authorizing the requests
+	ExtAuthz *config.ExtAuthzConfig `yaml:"extAuthz" json:"extAuthz,omitempty"`
+	// Resolution of the origin hostname, through the resolvers of the host when nil
+	DNS *config.OriginDNSConfig `yaml:"dns" json:"dns,omitempty"`
+	// Connections kept open to HTTP origins
+	Warmup *config.OriginWarmupConfig `yaml:"warmup" json:"warmup,omitempty"`
+	// Script transforming the requests to the origin and its responses
+	Transform *config.TransformConfig `yaml:"transform" json:"transform,omitempty"`
+	// Order of the middleware of the rule, defaultMiddlewareChain when empty
+	Middleware []string `yaml:"middleware" json:"middleware,omitempty"`
 }
 
 func (defaults *OriginRequestConfig) setConnectTimeout(overrides config.OriginRequestConfig) {
@@ -347,6 +506,12 @@ func (defaults *OriginRequestConfig) setTLSTimeout(overrides config.OriginReques
 	}
 }
 
+func (defaults *OriginRequestConfig) setResponseHeaderTimeout(overrides config.OriginRequestConfig) {
+	if val := overrides.ResponseHeaderTimeout; val != nil {
+		defaults.ResponseHeaderTimeout = *val
+	}
+}
+
 func (defaults *OriginRequestConfig) setNoHappyEyeballs(overrides config.OriginRequestConfig) {
 	if val := overrides.NoHappyEyeballs; val != nil {
 		defaults.NoHappyEyeballs = *val
@@ -450,12 +615,132 @@ func (defaults *OriginRequestConfig) setHttp2Origin(overrides config.OriginReque
 	}
 }
 
+func (defaults *OriginRequestConfig) setHttp3Origin(overrides config.OriginRequestConfig) {
+	if val := overrides.Http3Origin; val != nil {
+		defaults.Http3Origin = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setRequestBodyBufferDir(overrides config.OriginRequestConfig) {
+	if val := overrides.RequestBodyBufferDir; val != nil {
+		defaults.RequestBodyBufferDir = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setRequestBodyBufferMaxSize(overrides config.OriginRequestConfig) {
+	if val := overrides.RequestBodyBufferMaxSize; val != nil {
+		defaults.RequestBodyBufferMaxSize = *val
+	}
+}
+
 func (defaults *OriginRequestConfig) setAccess(overrides config.OriginRequestConfig) {
 	if val := overrides.Access; val != nil {
 		defaults.Access = *val
 	}
 }
 
+func (defaults *OriginRequestConfig) setTagHeaders(overrides config.OriginRequestConfig) {
+	if val := overrides.TagHeaders; val != nil {
+		defaults.TagHeaders = val
+	}
+}
+
+func (defaults *OriginRequestConfig) setRequestIDHeader(overrides config.OriginRequestConfig) {
+	if val := overrides.RequestIDHeader; val != nil {
+		defaults.RequestIDHeader = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setTLSMinVersion(overrides config.OriginRequestConfig) {
+	if val := overrides.TLSMinVersion; val != nil {
+		defaults.TLSMinVersion = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setTLSMaxVersion(overrides config.OriginRequestConfig) {
+	if val := overrides.TLSMaxVersion; val != nil {
+		defaults.TLSMaxVersion = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setTLSCipherSuites(overrides config.OriginRequestConfig) {
+	if val := overrides.TLSCipherSuites; val != nil {
+		defaults.TLSCipherSuites = val
+	}
+}
+
+func (defaults *OriginRequestConfig) setOriginCertFingerprint(overrides config.OriginRequestConfig) {
+	if val := overrides.OriginCertFingerprint; val != nil {
+		defaults.OriginCertFingerprint = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setSocks(overrides config.OriginRequestConfig) {
+	if val := overrides.Socks; val != nil {
+		defaults.Socks = val
+	}
+}
+
+func (defaults *OriginRequestConfig) setSessionRecording(overrides config.OriginRequestConfig) {
+	if val := overrides.SessionRecording; val != nil {
+		defaults.SessionRecording = val
+	}
+}
+
+func (defaults *OriginRequestConfig) setLatencyMode(overrides config.OriginRequestConfig) {
+	if val := overrides.LatencyMode; val != nil {
+		defaults.LatencyMode = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setProxyProtocol(overrides config.OriginRequestConfig) {
+	if val := overrides.ProxyProtocol; val != nil {
+		defaults.ProxyProtocol = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setUDPIdleTimeout(overrides config.OriginRequestConfig) {
+	if val := overrides.UDPIdleTimeout; val != nil {
+		defaults.UDPIdleTimeout = *val
+	}
+}
+
+func (defaults *OriginRequestConfig) setCORS(overrides config.OriginRequestConfig) {
+	if val := overrides.CORS; val != nil {
+		defaults.CORS = val
+	}
+}
+
+func (defaults *OriginRequestConfig) setExtAuthz(overrides config.OriginRequestConfig) {
+	if val := overrides.ExtAuthz; val != nil {
+		defaults.ExtAuthz = val
+	}
+}
+
+func (defaults *OriginRequestConfig) setDNS(overrides config.OriginRequestConfig) {
+	if val := overrides.DNS; val != nil {
+		defaults.DNS = val
+	}
+}
+
+func (defaults *OriginRequestConfig) setWarmup(overrides config.OriginRequestConfig) {
+	if val := overrides.Warmup; val != nil {
+		defaults.Warmup = val
+	}
+}
+
+func (defaults *OriginRequestConfig) setTransform(overrides config.OriginRequestConfig) {
+	if val := overrides.Transform; val != nil {
+		defaults.Transform = val
+	}
+}
+
+func (defaults *OriginRequestConfig) setMiddleware(overrides config.OriginRequestConfig) {
+	if val := overrides.Middleware; val != nil {
+		defaults.Middleware = val
+	}
+}
+
 // SetConfig gets config for the requests that cloudflared sends to origins.
 // Each field has a setter method which sets a value for the field by trying to find:
 //  1. The user config for this rule
@@ -468,6 +753,7 @@ func setConfig(defaults OriginRequestConfig, overrides config.OriginRequestConfi
 	cfg := defaults
 	cfg.setConnectTimeout(overrides)
 	cfg.setTLSTimeout(overrides)
+	cfg.setResponseHeaderTimeout(overrides)
 	cfg.setNoHappyEyeballs(overrides)
 	cfg.setKeepAliveConnections(overrides)
 	cfg.setKeepAliveTimeout(overrides)
@@ -484,7 +770,27 @@ func setConfig(defaults OriginRequestConfig, overrides config.OriginRequestConfi
 	cfg.setProxyType(overrides)
 	cfg.setIPRules(overrides)
 	cfg.setHttp2Origin(overrides)
+	cfg.setHttp3Origin(overrides)
+	cfg.setRequestBodyBufferDir(overrides)
+	cfg.setRequestBodyBufferMaxSize(overrides)
 	cfg.setAccess(overrides)
+	cfg.setTagHeaders(overrides)
+	cfg.setRequestIDHeader(overrides)
+	cfg.setTLSMinVersion(overrides)
+	cfg.setTLSMaxVersion(overrides)
+	cfg.setTLSCipherSuites(overrides)
+	cfg.setOriginCertFingerprint(overrides)
+	cfg.setSocks(overrides)
+	cfg.setSessionRecording(overrides)
+	cfg.setLatencyMode(overrides)
+	cfg.setProxyProtocol(overrides)
+	cfg.setUDPIdleTimeout(overrides)
+	cfg.setCORS(overrides)
+	cfg.setExtAuthz(overrides)
+	cfg.setDNS(overrides)
+	cfg.setWarmup(overrides)
+	cfg.setTransform(overrides)
+	cfg.setMiddleware(overrides)
 
 	return cfg
 }
@@ -492,11 +798,17 @@ func setConfig(defaults OriginRequestConfig, overrides config.OriginRequestConfi
 func ConvertToRawOriginConfig(c OriginRequestConfig) config.OriginRequestConfig {
 	var connectTimeout *config.CustomDuration
 	var tlsTimeout *config.CustomDuration
+	var responseHeaderTimeout *config.CustomDuration
 	var tcpKeepAlive *config.CustomDuration
 	var keepAliveConnections *int
 	var keepAliveTimeout *config.CustomDuration
 	var proxyAddress *string
+	var requestBodyBufferMaxSize *int
 	var access *config.AccessConfig
+	var tagHeaders map[string]string
+	var tlsCipherSuites []string
+	var udpIdleTimeout *config.CustomDuration
+	var middlewareChain []string
 
 	if c.ConnectTimeout != defaultHTTPConnectTimeout {
 		connectTimeout = &c.ConnectTimeout
@@ -504,6 +816,12 @@ func ConvertToRawOriginConfig(c OriginRequestConfig) config.OriginRequestConfig
 	if c.TLSTimeout != defaultTLSTimeout {
 		tlsTimeout = &c.TLSTimeout
 	}
+	if c.ResponseHeaderTimeout.Duration != 0 {
+		responseHeaderTimeout = &c.ResponseHeaderTimeout
+	}
+	if c.UDPIdleTimeout.Duration != 0 {
+		udpIdleTimeout = &c.UDPIdleTimeout
+	}
 	if c.TCPKeepAlive != defaultTCPKeepAlive {
 		tcpKeepAlive = &c.TCPKeepAlive
 	}
@@ -516,30 +834,63 @@ func ConvertToRawOriginConfig(c OriginRequestConfig) config.OriginRequestConfig
 	if c.ProxyAddress != defaultProxyAddress {
 		proxyAddress = &c.ProxyAddress
 	}
+	if c.RequestBodyBufferMaxSize != defaultRequestBodyBufferMaxSize {
+		requestBodyBufferMaxSize = &c.RequestBodyBufferMaxSize
+	}
 	if c.Access.Required {
 		access = &c.Access
 	}
+	if len(c.TagHeaders) > 0 {
+		tagHeaders = c.TagHeaders
+	}
+	if len(c.TLSCipherSuites) > 0 {
+		tlsCipherSuites = c.TLSCipherSuites
+	}
+	if len(c.Middleware) > 0 {
+		middlewareChain = c.Middleware
+	}
 
 	return config.OriginRequestConfig{
-		ConnectTimeout:         connectTimeout,
-		TLSTimeout:             tlsTimeout,
-		TCPKeepAlive:           tcpKeepAlive,
-		NoHappyEyeballs:        defaultBoolToNil(c.NoHappyEyeballs),
-		KeepAliveConnections:   keepAliveConnections,
-		KeepAliveTimeout:       keepAliveTimeout,
-		HTTPHostHeader:         emptyStringToNil(c.HTTPHostHeader),
-		OriginServerName:       emptyStringToNil(c.OriginServerName),
-		MatchSNIToHost:         defaultBoolToNil(c.MatchSNIToHost),
-		CAPool:                 emptyStringToNil(c.CAPool),
-		NoTLSVerify:            defaultBoolToNil(c.NoTLSVerify),
-		DisableChunkedEncoding: defaultBoolToNil(c.DisableChunkedEncoding),
-		BastionMode:            defaultBoolToNil(c.BastionMode),
-		ProxyAddress:           proxyAddress,
-		ProxyPort:              zeroUIntToNil(c.ProxyPort),
-		ProxyType:              emptyStringToNil(c.ProxyType),
-		IPRules:                convertToRawIPRules(c.IPRules),
-		Http2Origin:            defaultBoolToNil(c.Http2Origin),
-		Access:                 access,
+		ConnectTimeout:           connectTimeout,
+		TLSTimeout:               tlsTimeout,
+		ResponseHeaderTimeout:    responseHeaderTimeout,
+		TCPKeepAlive:             tcpKeepAlive,
+		NoHappyEyeballs:          defaultBoolToNil(c.NoHappyEyeballs),
+		KeepAliveConnections:     keepAliveConnections,
+		KeepAliveTimeout:         keepAliveTimeout,
+		HTTPHostHeader:           emptyStringToNil(c.HTTPHostHeader),
+		OriginServerName:         emptyStringToNil(c.OriginServerName),
+		MatchSNIToHost:           defaultBoolToNil(c.MatchSNIToHost),
+		CAPool:                   emptyStringToNil(c.CAPool),
+		NoTLSVerify:              defaultBoolToNil(c.NoTLSVerify),
+		DisableChunkedEncoding:   defaultBoolToNil(c.DisableChunkedEncoding),
+		BastionMode:              defaultBoolToNil(c.BastionMode),
+		ProxyAddress:             proxyAddress,
+		ProxyPort:                zeroUIntToNil(c.ProxyPort),
+		ProxyType:                emptyStringToNil(c.ProxyType),
+		IPRules:                  convertToRawIPRules(c.IPRules),
+		Http2Origin:              defaultBoolToNil(c.Http2Origin),
+		Http3Origin:              defaultBoolToNil(c.Http3Origin),
+		RequestBodyBufferDir:     emptyStringToNil(c.RequestBodyBufferDir),
+		RequestBodyBufferMaxSize: requestBodyBufferMaxSize,
+		Access:                   access,
+		TagHeaders:               tagHeaders,
+		RequestIDHeader:          emptyStringToNil(c.RequestIDHeader),
+		TLSMinVersion:            emptyStringToNil(c.TLSMinVersion),
+		TLSMaxVersion:            emptyStringToNil(c.TLSMaxVersion),
+		TLSCipherSuites:          tlsCipherSuites,
+		OriginCertFingerprint:    emptyStringToNil(c.OriginCertFingerprint),
+		Socks:                    c.Socks,
+		SessionRecording:         c.SessionRecording,
+		LatencyMode:              defaultBoolToNil(c.LatencyMode),
+		ProxyProtocol:            defaultBoolToNil(c.ProxyProtocol),
+		UDPIdleTimeout:           udpIdleTimeout,
+		CORS:                     c.CORS,
+		ExtAuthz:                 c.ExtAuthz,
+		DNS:                      c.DNS,
+		Warmup:                   c.Warmup,
+		Transform:                c.Transform,
+		Middleware:               middlewareChain,
 	}
 }
 
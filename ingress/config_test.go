@@ -27,6 +27,19 @@ func TestCorrespondingFields(t *testing.T) {
 	)
 }
 
+func TestWarpRoutingConfigRawConfig(t *testing.T) {
+	mss, readBuffer := 1220, 1<<20
+	raw := config.WarpRoutingConfig{
+		TCPMaxSegmentSize: &mss,
+		TCPReadBufferSize: &readBuffer,
+	}
+	cfg := NewWarpRoutingConfig(&raw)
+	require.Equal(t, 1220, cfg.TCPMaxSegmentSize)
+	require.Equal(t, 1<<20, cfg.TCPReadBufferSize)
+	require.Equal(t, 0, cfg.TCPWriteBufferSize)
+	require.Equal(t, raw, cfg.RawConfig())
+}
+
 func CountFields(t *testing.T, val interface{}) int {
 	b, err := yaml.Marshal(val)
 	require.NoError(t, err)
@@ -76,6 +89,7 @@ func TestOriginRequestConfigOverrides(t *testing.T) {
 		expected0 := OriginRequestConfig{
 			ConnectTimeout:         config.CustomDuration{Duration: 1 * time.Minute},
 			TLSTimeout:             config.CustomDuration{Duration: 1 * time.Second},
+			ResponseHeaderTimeout:  config.CustomDuration{Duration: 10 * time.Second},
 			TCPKeepAlive:           config.CustomDuration{Duration: 1 * time.Second},
 			NoHappyEyeballs:        true,
 			KeepAliveTimeout:       config.CustomDuration{Duration: 1 * time.Second},
@@ -93,6 +107,7 @@ func TestOriginRequestConfigOverrides(t *testing.T) {
 				newIPRule(t, "10.0.0.0/8", []int{80, 8080}, false),
 				newIPRule(t, "fc00::/7", []int{443, 4443}, true),
 			},
+			RequestBodyBufferMaxSize: 1 << 20,
 		}
 		require.Equal(t, expected0, actual0)
 
@@ -101,6 +116,7 @@ func TestOriginRequestConfigOverrides(t *testing.T) {
 		expected1 := OriginRequestConfig{
 			ConnectTimeout:         config.CustomDuration{Duration: 2 * time.Minute},
 			TLSTimeout:             config.CustomDuration{Duration: 2 * time.Second},
+			ResponseHeaderTimeout:  config.CustomDuration{Duration: 20 * time.Second},
 			TCPKeepAlive:           config.CustomDuration{Duration: 2 * time.Second},
 			NoHappyEyeballs:        false,
 			KeepAliveTimeout:       config.CustomDuration{Duration: 2 * time.Second},
@@ -118,6 +134,7 @@ func TestOriginRequestConfigOverrides(t *testing.T) {
 				newIPRule(t, "10.0.0.0/16", []int{3000, 3030}, false),
 				newIPRule(t, "192.16.0.0/24", []int{5000, 5050}, true),
 			},
+			RequestBodyBufferMaxSize: 2 << 20,
 		}
 		require.Equal(t, expected1, actual1)
 	}
@@ -126,6 +143,8 @@ func TestOriginRequestConfigOverrides(t *testing.T) {
 originRequest:
   connectTimeout: 1m
   tlsTimeout: 1s
+  responseHeaderTimeout: 10s
+  requestBodyBufferMaxSize: 1048576
   noHappyEyeballs: true
   tcpKeepAlive: 1s
   keepAliveConnections: 1
@@ -158,6 +177,8 @@ ingress:
   originRequest:
     connectTimeout: 2m
     tlsTimeout: 2s
+    responseHeaderTimeout: 20s
+    requestBodyBufferMaxSize: 2097152
     noHappyEyeballs: false
     tcpKeepAlive: 2s
     keepAliveConnections: 2
@@ -193,6 +214,8 @@ ingress:
     "originRequest": {
         "connectTimeout": 60,
 		"tlsTimeout": 1,
+		"responseHeaderTimeout": 10,
+		"requestBodyBufferMaxSize": 1048576,
 		"noHappyEyeballs": true,
 		"tcpKeepAlive": 1,
 		"keepAliveConnections": 1,
@@ -230,6 +253,8 @@ ingress:
 			"originRequest": {
 				"connectTimeout": 120,
 				"tlsTimeout": 2,
+				"responseHeaderTimeout": 20,
+				"requestBodyBufferMaxSize": 2097152,
 				"noHappyEyeballs": false,
 				"tcpKeepAlive": 2,
 				"keepAliveConnections": 2,
@@ -280,6 +305,8 @@ func TestOriginRequestConfigDefaults(t *testing.T) {
 			KeepAliveConnections: defaultKeepAliveConnections,
 			KeepAliveTimeout:     defaultKeepAliveTimeout,
 			ProxyAddress:         defaultProxyAddress,
+
+			RequestBodyBufferMaxSize: defaultRequestBodyBufferMaxSize,
 		}
 		require.Equal(t, expected0, actual0)
 
@@ -305,6 +332,7 @@ func TestOriginRequestConfigDefaults(t *testing.T) {
 				newIPRule(t, "10.0.0.0/16", []int{3000, 3030}, false),
 				newIPRule(t, "192.16.0.0/24", []int{5000, 5050}, true),
 			},
+			RequestBodyBufferMaxSize: defaultRequestBodyBufferMaxSize,
 		}
 		require.Equal(t, expected1, actual1)
 	}
@@ -410,6 +438,8 @@ func TestDefaultConfigFromCLI(t *testing.T) {
 		KeepAliveConnections: defaultKeepAliveConnections,
 		KeepAliveTimeout:     defaultKeepAliveTimeout,
 		ProxyAddress:         defaultProxyAddress,
+
+		RequestBodyBufferMaxSize: defaultRequestBodyBufferMaxSize,
 	}
 	actual := originRequestFromSingleRule(c)
 	require.Equal(t, expected, actual)
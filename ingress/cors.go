@@ -0,0 +1,171 @@
+package ingress
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+var defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+
+// CORSPolicy answers CORS preflight requests and adds CORS headers to responses on behalf of origins that can't
+// be modified to do it themselves.
+type CORSPolicy struct {
+	anyOrigin bool
+	origins   map[string]bool
+	// subdomains are the suffixes, e.g. ".example.com", the hosts of allowed origins with a wildcard end with, per
+	// scheme
+	subdomains       map[string][]string
+	methods          string
+	anyHeader        bool
+	headers          string
+	exposedHeaders   string
+	allowCredentials bool
+	maxAge           string
+}
+
+// NewCORSPolicy returns the policy of cfg, or nil when cfg is nil.
+func NewCORSPolicy(cfg *config.CORSConfig) (*CORSPolicy, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if len(cfg.AllowedOrigins) == 0 {
+		return nil, fmt.Errorf("cors requires allowedOrigins")
+	}
+	policy := CORSPolicy{
+		origins:          make(map[string]bool),
+		subdomains:       make(map[string][]string),
+		exposedHeaders:   strings.Join(cfg.ExposedHeaders, ", "),
+		allowCredentials: cfg.AllowCredentials,
+	}
+	for _, origin := range cfg.AllowedOrigins {
+		if origin == "*" {
+			policy.anyOrigin = true
+			continue
+		}
+		u, err := url.Parse(origin)
+		if err != nil || u.Scheme == "" || u.Host == "" || (u.Path != "" && u.Path != "/") || u.RawQuery != "" {
+			return nil, fmt.Errorf("invalid allowed origin %s, expected an origin such as https://example.com", origin)
+		}
+		if strings.Contains(u.Host, "*") {
+			suffix, ok := strings.CutPrefix(u.Host, "*")
+			if !ok || !strings.HasPrefix(suffix, ".") || strings.Contains(suffix, "*") {
+				return nil, fmt.Errorf("invalid allowed origin %s, only a leading *. wildcard is supported", origin)
+			}
+			policy.subdomains[u.Scheme] = append(policy.subdomains[u.Scheme], strings.ToLower(suffix))
+			continue
+		}
+		policy.origins[strings.ToLower(u.Scheme+"://"+u.Host)] = true
+	}
+
+	methods := defaultCORSMethods
+	if len(cfg.AllowedMethods) > 0 {
+		methods = make([]string, len(cfg.AllowedMethods))
+		for i, method := range cfg.AllowedMethods {
+			methods[i] = strings.ToUpper(method)
+		}
+	}
+	policy.methods = strings.Join(methods, ", ")
+
+	var headers []string
+	for _, header := range cfg.AllowedHeaders {
+		if header == "*" {
+			policy.anyHeader = true
+			continue
+		}
+		headers = append(headers, header)
+	}
+	policy.headers = strings.Join(headers, ", ")
+
+	if cfg.MaxAge != nil {
+		policy.maxAge = strconv.Itoa(int(cfg.MaxAge.Seconds()))
+	}
+	return &policy, nil
+}
+
+func (p *CORSPolicy) allowsOrigin(origin string) bool {
+	if p.anyOrigin {
+		return true
+	}
+	origin = strings.ToLower(origin)
+	if p.origins[origin] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	for _, suffix := range p.subdomains[u.Scheme] {
+		// The suffix includes the port of the allowed origin, if any
+		if strings.HasSuffix(u.Host, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Preflight returns the headers answering r if it is a preflight request. The headers don't allow anything when
+// the origin of r isn't allowed, so browsers block the actual request.
+func (p *CORSPolicy) Preflight(r *http.Request) (http.Header, bool) {
+	origin := r.Header.Get("Origin")
+	if r.Method != http.MethodOptions || origin == "" || r.Header.Get("Access-Control-Request-Method") == "" {
+		return nil, false
+	}
+	headers := http.Header{}
+	headers.Add("Vary", "Origin")
+	headers.Add("Vary", "Access-Control-Request-Method")
+	headers.Add("Vary", "Access-Control-Request-Headers")
+	if !p.allowsOrigin(origin) {
+		return headers, true
+	}
+	p.setAllowOrigin(origin, headers)
+	headers.Set("Access-Control-Allow-Methods", p.methods)
+	if p.anyHeader {
+		if requested := r.Header.Get("Access-Control-Request-Headers"); requested != "" {
+			headers.Set("Access-Control-Allow-Headers", requested)
+		}
+	} else if p.headers != "" {
+		headers.Set("Access-Control-Allow-Headers", p.headers)
+	}
+	if p.maxAge != "" {
+		headers.Set("Access-Control-Max-Age", p.maxAge)
+	}
+	return headers, true
+}
+
+// SetResponseHeaders sets the CORS headers of the response to r, replacing those of the origin.
+func (p *CORSPolicy) SetResponseHeaders(r *http.Request, headers http.Header) {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return
+	}
+	for name := range headers {
+		if strings.HasPrefix(name, "Access-Control-") {
+			headers.Del(name)
+		}
+	}
+	headers.Add("Vary", "Origin")
+	if !p.allowsOrigin(origin) {
+		return
+	}
+	p.setAllowOrigin(origin, headers)
+	if p.exposedHeaders != "" {
+		headers.Set("Access-Control-Expose-Headers", p.exposedHeaders)
+	}
+}
+
+func (p *CORSPolicy) setAllowOrigin(origin string, headers http.Header) {
+	// Browsers reject credentialed requests allowed by a wildcard, so the origin is echoed instead
+	if p.anyOrigin && !p.allowCredentials {
+		headers.Set("Access-Control-Allow-Origin", "*")
+	} else {
+		headers.Set("Access-Control-Allow-Origin", origin)
+	}
+	if p.allowCredentials {
+		headers.Set("Access-Control-Allow-Credentials", "true")
+	}
+}
@@ -0,0 +1,91 @@
+package ingress
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+func TestNewCORSPolicy(t *testing.T) {
+	policy, err := NewCORSPolicy(nil)
+	require.NoError(t, err)
+	require.Nil(t, policy)
+
+	invalid := []*config.CORSConfig{
+		{},
+		{AllowedOrigins: []string{"example.com"}},
+		{AllowedOrigins: []string{"https://example.com/path"}},
+		{AllowedOrigins: []string{"https://a*.example.com"}},
+		{AllowedOrigins: []string{"https://*.*.example.com"}},
+	}
+	for _, cfg := range invalid {
+		_, err := NewCORSPolicy(cfg)
+		require.Error(t, err, cfg.AllowedOrigins)
+	}
+}
+
+func TestCORSPolicyAllowsOrigin(t *testing.T) {
+	policy, err := NewCORSPolicy(&config.CORSConfig{
+		AllowedOrigins: []string{"https://app.example.com", "https://*.example.org", "http://*.example.net:8080"},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		origin  string
+		allowed bool
+	}{
+		{"https://app.example.com", true},
+		{"https://APP.example.com", true},
+		{"http://app.example.com", false},
+		{"https://app.example.com:8443", false},
+		{"https://a.b.example.org", true},
+		{"https://example.org", false},
+		{"https://evilexample.org", false},
+		{"http://a.example.net:8080", true},
+		{"http://a.example.net", false},
+		{"null", false},
+	}
+	for _, test := range tests {
+		require.Equal(t, test.allowed, policy.allowsOrigin(test.origin), test.origin)
+	}
+}
+
+func TestCORSPolicyAnyOrigin(t *testing.T) {
+	policy, err := NewCORSPolicy(&config.CORSConfig{
+		AllowedOrigins: []string{"*"},
+		AllowedHeaders: []string{"*"},
+		ExposedHeaders: []string{"X-Request-Id"},
+	})
+	require.NoError(t, err)
+
+	req, err := http.NewRequest(http.MethodOptions, "https://api.example.com", nil)
+	require.NoError(t, err)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", http.MethodPost)
+	req.Header.Set("Access-Control-Request-Headers", "X-Custom")
+	headers, ok := policy.Preflight(req)
+	require.True(t, ok)
+	require.Equal(t, "*", headers.Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "GET, HEAD, POST", headers.Get("Access-Control-Allow-Methods"))
+	require.Equal(t, "X-Custom", headers.Get("Access-Control-Allow-Headers"))
+
+	req.Method = http.MethodGet
+	_, ok = policy.Preflight(req)
+	require.False(t, ok)
+
+	headers = http.Header{"Access-Control-Allow-Origin": []string{"https://origin.example.com"}}
+	policy.SetResponseHeaders(req, headers)
+	require.Equal(t, "*", headers.Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "X-Request-Id", headers.Get("Access-Control-Expose-Headers"))
+
+	// Credentialed requests can't be allowed by a wildcard
+	policy, err = NewCORSPolicy(&config.CORSConfig{AllowedOrigins: []string{"*"}, AllowCredentials: true})
+	require.NoError(t, err)
+	headers = http.Header{}
+	policy.SetResponseHeaders(req, headers)
+	require.Equal(t, "https://app.example.com", headers.Get("Access-Control-Allow-Origin"))
+	require.Equal(t, "true", headers.Get("Access-Control-Allow-Credentials"))
+}
@@ -0,0 +1,162 @@
+package ingress
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/retry"
+)
+
+// States of a helper server reported by HelperStatuses.
+const (
+	HelperRunning    = "running"
+	HelperRestarting = "restarting"
+)
+
+const (
+	// helperRestartMaxRetries caps the backoff between restarts of a helper that keeps crashing at about a minute
+	helperRestartMaxRetries = 6
+	helperRestartBaseTime   = time.Second
+)
+
+var (
+	errHelperExited = errors.New("exited")
+
+	helperRestarts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "ingress",
+		Name:      "helper_restarts_total",
+		Help:      "Count of restarts of the helper servers started by ingress rules after they exited unexpectedly, by helper",
+	}, []string{"helper"})
+)
+
+func init() {
+	prometheus.MustRegister(helperRestarts)
+}
+
+// activeHelpers are the helpers of the ingress rules in use, reported by HelperStatuses.
+var activeHelpers struct {
+	sync.Mutex
+	helpers map[*helper]struct{}
+}
+
+// HelperStatus reports the state of a helper server started by an ingress rule, e.g. the Hello World server.
+type HelperStatus struct {
+	Name    string `json:"name"`
+	Address string `json:"address,omitempty"`
+	// State is HelperRunning, or HelperRestarting while waiting to restart after the helper exited
+	State    string    `json:"state"`
+	Since    time.Time `json:"since"`
+	Restarts int       `json:"restarts"`
+	// Error is why the helper last exited
+	Error string `json:"error,omitempty"`
+}
+
+// helper keeps a helper server of an ingress rule running, restarting it with backoff whenever it exits or panics.
+// A helper that spawns a process must wait for it before returning, so that it is reaped and never left a zombie.
+type helper struct {
+	log     *zerolog.Logger
+	backoff retry.BackoffHandler
+
+	lock   sync.Mutex
+	status HelperStatus
+}
+
+func newHelper(name, address string, log *zerolog.Logger) *helper {
+	return &helper{
+		log:     log,
+		backoff: retry.NewBackoff(helperRestartMaxRetries, helperRestartBaseTime, true),
+		status:  HelperStatus{Name: name, Address: address},
+	}
+}
+
+// supervise runs the helper until shutdownC is closed, reporting it in HelperStatuses meanwhile. run is expected to
+// return once shutdownC is closed.
+func (h *helper) supervise(shutdownC <-chan struct{}, run func() error) {
+	activeHelpers.Lock()
+	if activeHelpers.helpers == nil {
+		activeHelpers.helpers = make(map[*helper]struct{})
+	}
+	activeHelpers.helpers[h] = struct{}{}
+	activeHelpers.Unlock()
+	defer func() {
+		activeHelpers.Lock()
+		delete(activeHelpers.helpers, h)
+		activeHelpers.Unlock()
+	}()
+
+	for {
+		h.setState(HelperRunning, nil)
+		// A helper that ran for long enough restarts with the shortest backoff again
+		h.backoff.SetGracePeriod()
+		err := h.run(run)
+		select {
+		case <-shutdownC:
+			return
+		default:
+		}
+		if err == nil {
+			err = errHelperExited
+		}
+		h.setState(HelperRestarting, err)
+		h.log.Err(err).Str("helper", h.status.Name).Msg("Helper exited unexpectedly, restarting it")
+		select {
+		case <-shutdownC:
+			return
+		case <-h.backoff.BackoffTimer():
+		}
+		helperRestarts.WithLabelValues(h.status.Name).Inc()
+		h.lock.Lock()
+		h.status.Restarts++
+		h.lock.Unlock()
+	}
+}
+
+// run runs the helper once, turning a panic into an error so that it doesn't crash cloudflared.
+func (h *helper) run(run func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return run()
+}
+
+func (h *helper) setState(state string, err error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.status.State = state
+	h.status.Since = time.Now()
+	if err != nil {
+		h.status.Error = err.Error()
+	}
+}
+
+func (h *helper) Status() HelperStatus {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.status
+}
+
+// HelperStatuses returns the state of the helper servers started by the ingress rules in use, sorted by name.
+func HelperStatuses() []HelperStatus {
+	activeHelpers.Lock()
+	defer activeHelpers.Unlock()
+	statuses := make([]HelperStatus, 0, len(activeHelpers.helpers))
+	for h := range activeHelpers.helpers {
+		statuses = append(statuses, h.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Name != statuses[j].Name {
+			return statuses[i].Name < statuses[j].Name
+		}
+		return statuses[i].Address < statuses[j].Address
+	})
+	return statuses
+}
@@ -0,0 +1,71 @@
+package ingress
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/retry"
+)
+
+func TestHelperRestartsAfterCrash(t *testing.T) {
+	log := zerolog.Nop()
+	h := newHelper("test_helper", "127.0.0.1:1234", &log)
+	h.backoff = retry.NewBackoff(helperRestartMaxRetries, time.Millisecond, true)
+
+	shutdownC := make(chan struct{})
+	runs := make(chan int, 3)
+	stopped := make(chan struct{})
+	calls := 0
+	go func() {
+		defer close(stopped)
+		h.supervise(shutdownC, func() error {
+			calls++
+			runs <- calls
+			switch calls {
+			case 1:
+				return errors.New("listener closed")
+			case 2:
+				panic("crashed")
+			}
+			<-shutdownC
+			return nil
+		})
+	}()
+
+	for i := 1; i <= 3; i++ {
+		select {
+		case run := <-runs:
+			assert.Equal(t, i, run)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "helper was not restarted")
+		}
+	}
+	var status HelperStatus
+	require.Eventually(t, func() bool {
+		var ok bool
+		status, ok = helperStatus("test_helper")
+		return ok && status.State == HelperRunning
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, "127.0.0.1:1234", status.Address)
+	assert.Equal(t, 2, status.Restarts)
+	assert.Equal(t, "panic: crashed", status.Error)
+
+	close(shutdownC)
+	<-stopped
+	_, ok := helperStatus("test_helper")
+	assert.False(t, ok)
+}
+
+func helperStatus(name string) (HelperStatus, bool) {
+	for _, status := range HelperStatuses() {
+		if status.Name == name {
+			return status, true
+		}
+	}
+	return HelperStatus{}, false
+}
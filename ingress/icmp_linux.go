@@ -10,16 +10,19 @@ package ingress
 import (
 	"context"
 	"fmt"
+	"math/rand/v2"
 	"net"
 	"net/netip"
 	"os"
 	"regexp"
 	"strconv"
+	"syscall"
 	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/sys/unix"
 
 	"github.com/cloudflare/cloudflared/packet"
 	"github.com/cloudflare/cloudflared/tracing"
@@ -28,6 +31,8 @@ import (
 const (
 	// https://lwn.net/Articles/550551/ IPv4 and IPv6 share the same path
 	pingGroupPath = "/proc/sys/net/ipv4/ping_group_range"
+	// maxSourcePortAttempts bounds how many ports of the source port range are tried before giving up
+	maxSourcePortAttempts = 16
 )
 
 var (
@@ -37,33 +42,37 @@ var (
 type icmpProxy struct {
 	srcFunnelTracker *packet.FunnelTracker
 	listenIP         netip.Addr
+	sourcePorts      PortRange
 	logger           *zerolog.Logger
 	idleTimeout      time.Duration
 }
 
-func newICMPProxy(listenIP netip.Addr, logger *zerolog.Logger, idleTimeout time.Duration) (*icmpProxy, error) {
-	if err := testPermission(listenIP, logger); err != nil {
+func newICMPProxy(listenIP netip.Addr, sourcePorts PortRange, logger *zerolog.Logger, idleTimeout time.Duration) (*icmpProxy, error) {
+	if err := testPermission(listenIP, sourcePorts, logger); err != nil {
 		return nil, err
 	}
 	return &icmpProxy{
 		srcFunnelTracker: packet.NewFunnelTracker(),
 		listenIP:         listenIP,
+		sourcePorts:      sourcePorts,
 		logger:           logger,
 		idleTimeout:      idleTimeout,
 	}, nil
 }
 
-func testPermission(listenIP netip.Addr, logger *zerolog.Logger) error {
-	// Opens a non-privileged ICMP socket. On Linux the group ID of the process needs to be in ping_group_range
-	// Only check ping_group_range once for IPv4
-	if listenIP.Is4() {
-		if err := checkInPingGroup(); err != nil {
-			logger.Warn().Err(err).Msgf("The user running cloudflared process has a GID (group ID) that is not within ping_group_range. You might need to add that user to a group within that range, or instead update the range to encompass a group the user is already in by modifying %s. Otherwise cloudflared will not be able to ping this network", pingGroupPath)
-			return err
-		}
-	}
-	conn, err := newICMPConn(listenIP)
+func testPermission(listenIP netip.Addr, sourcePorts PortRange, logger *zerolog.Logger) error {
+	// Opens a non-privileged ICMP socket. On Linux the group ID of the process needs to be in ping_group_range.
+	// The kernel also accepts supplementary groups, which checkInPingGroup doesn't look at, so whether the socket
+	// can be opened decides; ping_group_range only explains why it can't.
+	conn, err := listenICMP(listenIP, sourcePorts)
 	if err != nil {
+		// Only check ping_group_range for IPv4, IPv6 shares the same range
+		if listenIP.Is4() {
+			if groupErr := checkInPingGroup(); groupErr != nil {
+				logger.Warn().Err(groupErr).Msgf("The user running cloudflared process has a GID (group ID) that is not within ping_group_range. You might need to add that user to a group within that range, or instead update the range to encompass a group the user is already in by modifying %s. Otherwise cloudflared will not be able to ping this network", pingGroupPath)
+				return errors.Wrapf(err, "%v", groupErr)
+			}
+		}
 		return err
 	}
 	// This conn is only to test if cloudflared has permission to open this type of socket
@@ -71,6 +80,57 @@ func testPermission(listenIP netip.Addr, logger *zerolog.Logger) error {
 	return nil
 }
 
+// listenICMP opens a non-privileged ICMP socket on listenIP. Its port, which is the echo ID of the requests sent
+// through it, is picked from sourcePorts unless the range is zero.
+func listenICMP(listenIP netip.Addr, sourcePorts PortRange) (net.PacketConn, error) {
+	if sourcePorts.IsZero() {
+		return newICMPConn(listenIP)
+	}
+	size := sourcePorts.size()
+	start := rand.IntN(size) // #nosec G404
+	var err error
+	for i := 0; i < min(size, maxSourcePortAttempts); i++ {
+		port := int(sourcePorts.Min) + (start+i)%size
+		var conn net.PacketConn
+		conn, err = listenICMPPort(listenIP, port)
+		if err == nil {
+			return conn, nil
+		}
+		if !errors.Is(err, syscall.EADDRINUSE) {
+			return nil, err
+		}
+	}
+	return nil, errors.Wrapf(err, "no free port in ICMP source port range %s", sourcePorts)
+}
+
+func listenICMPPort(listenIP netip.Addr, port int) (net.PacketConn, error) {
+	family, proto := unix.AF_INET, unix.IPPROTO_ICMP
+	var sockAddr unix.Sockaddr = &unix.SockaddrInet4{Port: port, Addr: listenIP.As4()}
+	if listenIP.Is6() {
+		family, proto = unix.AF_INET6, unix.IPPROTO_ICMPV6
+		sockAddr6 := &unix.SockaddrInet6{Port: port, Addr: listenIP.As16()}
+		if zone := listenIP.Zone(); zone != "" {
+			iface, err := net.InterfaceByName(zone)
+			if err != nil {
+				return nil, err
+			}
+			sockAddr6.ZoneId = uint32(iface.Index) // #nosec G115
+		}
+		sockAddr = sockAddr6
+	}
+	fd, err := unix.Socket(family, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, proto)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+	if err := unix.Bind(fd, sockAddr); err != nil {
+		_ = unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+	file := os.NewFile(uintptr(fd), "datagram-oriented icmp")
+	defer file.Close()
+	return net.FilePacketConn(file)
+}
+
 func checkInPingGroup() error {
 	file, err := os.ReadFile(pingGroupPath)
 	if err != nil {
@@ -109,7 +169,7 @@ func (ip *icmpProxy) Request(ctx context.Context, pk *packet.ICMP, responder ICM
 
 	shouldReplaceFunnelFunc := createShouldReplaceFunnelFunc(ip.logger, responder, pk, originalEcho.ID)
 	newFunnelFunc := func() (packet.Funnel, error) {
-		conn, err := newICMPConn(ip.listenIP)
+		conn, err := listenICMP(ip.listenIP, ip.sourcePorts)
 		if err != nil {
 			tracing.EndWithErrorStatus(span, err)
 			return nil, errors.Wrap(err, "failed to open ICMP socket")
@@ -3,11 +3,35 @@
 package ingress
 
 import (
+	"net"
 	"testing"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
 	"github.com/cloudflare/cloudflared/packet"
 )
 
 func getFunnel(t *testing.T, proxy *icmpProxy, tuple flow3Tuple) (packet.Funnel, bool) {
 	return proxy.srcFunnelTracker.Get(tuple)
 }
+
+func TestListenICMPInSourcePortRange(t *testing.T) {
+	sourcePorts := PortRange{Min: 40000, Max: 40001}
+	conn, err := listenICMP(localhostIP, sourcePorts)
+	if err != nil {
+		t.Skipf("unprivileged ICMP sockets are not available: %v", err)
+	}
+	defer conn.Close()
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	assert.GreaterOrEqual(t, port, int(sourcePorts.Min))
+	assert.LessOrEqual(t, port, int(sourcePorts.Max))
+
+	other, err := listenICMP(localhostIP, sourcePorts)
+	require.NoError(t, err)
+	defer other.Close()
+	assert.NotEqual(t, port, other.LocalAddr().(*net.UDPAddr).Port)
+
+	_, err = listenICMP(localhostIP, sourcePorts)
+	require.ErrorContains(t, err, "no free port in ICMP source port range 40000-40001")
+}
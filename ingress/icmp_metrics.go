@@ -21,12 +21,19 @@ var (
 		Name:      "total_replies",
 		Help:      "Total count of ICMP replies that have been proxied from any origin",
 	})
+	icmpProxyState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "icmp",
+		Name:      "proxy_state",
+		Help:      "State of the ICMP proxy detected at startup, 1 for the current state of enabled, degraded or disabled",
+	}, []string{"state"})
 )
 
 func init() {
 	prometheus.MustRegister(
 		icmpRequests,
 		icmpReplies,
+		icmpProxyState,
 	)
 }
 
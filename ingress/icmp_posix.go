@@ -46,13 +46,13 @@ type icmpEchoFlow struct {
 	closeCallback  func() error
 	closed         *atomic.Bool
 	src            netip.Addr
-	originConn     *icmp.PacketConn
+	originConn     net.PacketConn
 	responder      ICMPResponder
 	assignedEchoID int
 	originalEchoID int
 }
 
-func newICMPEchoFlow(src netip.Addr, closeCallback func() error, originConn *icmp.PacketConn, responder ICMPResponder, assignedEchoID, originalEchoID int) *icmpEchoFlow {
+func newICMPEchoFlow(src netip.Addr, closeCallback func() error, originConn net.PacketConn, responder ICMPResponder, assignedEchoID, originalEchoID int) *icmpEchoFlow {
 	return &icmpEchoFlow{
 		ActivityTracker: packet.NewActivityTracker(),
 		closeCallback:   closeCallback,
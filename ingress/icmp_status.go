@@ -0,0 +1,113 @@
+package ingress
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// ICMPProxyState tells whether cloudflared can proxy ICMP to origins.
+type ICMPProxyState string
+
+const (
+	// ICMPProxyEnabled means both ICMPv4 and ICMPv6 can be proxied.
+	ICMPProxyEnabled ICMPProxyState = "enabled"
+	// ICMPProxyDegraded means only one of ICMPv4 or ICMPv6 can be proxied.
+	ICMPProxyDegraded ICMPProxyState = "degraded"
+	// ICMPProxyDisabled means ICMP can't be proxied at all.
+	ICMPProxyDisabled ICMPProxyState = "disabled"
+)
+
+var icmpProxyStates = []ICMPProxyState{ICMPProxyEnabled, ICMPProxyDegraded, ICMPProxyDisabled}
+
+// ICMPProxyStatus is the state of the ICMP proxy detected at startup, with the reason it is not enabled.
+type ICMPProxyStatus struct {
+	State  ICMPProxyState `json:"state"`
+	Reason string         `json:"reason,omitempty"`
+}
+
+var icmpProxyStatus atomic.Pointer[ICMPProxyStatus]
+
+// GetICMPProxyStatus returns the status of the ICMP proxy of this process.
+func GetICMPProxyStatus() ICMPProxyStatus {
+	if status := icmpProxyStatus.Load(); status != nil {
+		return *status
+	}
+	return ICMPProxyStatus{State: ICMPProxyDisabled, Reason: "ICMP proxy was not started"}
+}
+
+// DisableICMPProxy records that the ICMP proxy is not used, for a reason outside of the ICMP router.
+func DisableICMPProxy(reason string) {
+	setICMPProxyStatus(ICMPProxyStatus{State: ICMPProxyDisabled, Reason: reason})
+}
+
+func setICMPProxyStatus(status ICMPProxyStatus) {
+	icmpProxyStatus.Store(&status)
+	for _, state := range icmpProxyStates {
+		value := 0.0
+		if state == status.State {
+			value = 1
+		}
+		icmpProxyState.WithLabelValues(string(state)).Set(value)
+	}
+}
+
+// icmpRouterStatus derives the status of a router from the errors creating its ICMPv4 and ICMPv6 proxies.
+func icmpRouterStatus(ipv4Err, ipv6Err error) ICMPProxyStatus {
+	switch {
+	case ipv4Err != nil && ipv6Err != nil:
+		return ICMPProxyStatus{State: ICMPProxyDisabled, Reason: fmt.Sprintf("ICMPv4: %v; ICMPv6: %v", ipv4Err, ipv6Err)}
+	case ipv4Err != nil:
+		return ICMPProxyStatus{State: ICMPProxyDegraded, Reason: fmt.Sprintf("only ICMPv6 is proxied, ICMPv4: %v", ipv4Err)}
+	case ipv6Err != nil:
+		return ICMPProxyStatus{State: ICMPProxyDegraded, Reason: fmt.Sprintf("only ICMPv4 is proxied, ICMPv6: %v", ipv6Err)}
+	default:
+		return ICMPProxyStatus{State: ICMPProxyEnabled}
+	}
+}
+
+// PortRange is an inclusive range of ports. The zero value lets the kernel choose any port.
+type PortRange struct {
+	Min uint16
+	Max uint16
+}
+
+// ParsePortRange parses a range in the <min>-<max> format. An empty string is the zero PortRange.
+func ParsePortRange(s string) (PortRange, error) {
+	if s == "" {
+		return PortRange{}, nil
+	}
+	minPort, maxPort, ok := strings.Cut(s, "-")
+	if !ok {
+		return PortRange{}, fmt.Errorf("port range %q is not in the <min>-<max> format", s)
+	}
+	lower, err := strconv.ParseUint(strings.TrimSpace(minPort), 10, 16)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid minimum port in %q: %w", s, err)
+	}
+	upper, err := strconv.ParseUint(strings.TrimSpace(maxPort), 10, 16)
+	if err != nil {
+		return PortRange{}, fmt.Errorf("invalid maximum port in %q: %w", s, err)
+	}
+	if lower == 0 || lower > upper {
+		return PortRange{}, fmt.Errorf("port range %q must be between 1 and 65535 with the minimum not above the maximum", s)
+	}
+	return PortRange{Min: uint16(lower), Max: uint16(upper)}, nil
+}
+
+func (r PortRange) IsZero() bool {
+	return r == PortRange{}
+}
+
+func (r PortRange) String() string {
+	if r.IsZero() {
+		return "any"
+	}
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+// size is the number of ports in the range.
+func (r PortRange) size() int {
+	return int(r.Max) - int(r.Min) + 1
+}
@@ -0,0 +1,68 @@
+package ingress
+
+import (
+	"errors"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected PortRange
+		valid    bool
+	}{
+		{input: "", expected: PortRange{}, valid: true},
+		{input: "30000-30999", expected: PortRange{Min: 30000, Max: 30999}, valid: true},
+		{input: " 100 - 100 ", expected: PortRange{Min: 100, Max: 100}, valid: true},
+		{input: "100"},
+		{input: "0-100"},
+		{input: "200-100"},
+		{input: "100-65536"},
+		{input: "a-b"},
+	}
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			portRange, err := ParsePortRange(test.input)
+			if !test.valid {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, portRange)
+		})
+	}
+}
+
+func TestICMPRouterStatus(t *testing.T) {
+	errNoPermission := errors.New("permission denied")
+	assert.Equal(t, ICMPProxyStatus{State: ICMPProxyEnabled}, icmpRouterStatus(nil, nil))
+
+	status := icmpRouterStatus(errNoPermission, nil)
+	assert.Equal(t, ICMPProxyDegraded, status.State)
+	assert.Contains(t, status.Reason, "only ICMPv6")
+
+	status = icmpRouterStatus(nil, errNoPermission)
+	assert.Equal(t, ICMPProxyDegraded, status.State)
+	assert.Contains(t, status.Reason, "only ICMPv4")
+
+	status = icmpRouterStatus(errNoPermission, errNoPermission)
+	assert.Equal(t, ICMPProxyDisabled, status.State)
+	assert.Contains(t, status.Reason, "permission denied")
+}
+
+func TestDisableICMPProxy(t *testing.T) {
+	DisableICMPProxy("quick tunnel")
+	assert.Equal(t, ICMPProxyStatus{State: ICMPProxyDisabled, Reason: "quick tunnel"}, GetICMPProxyStatus())
+	assert.InDelta(t, 1.0, icmpProxyStateValue(t, ICMPProxyDisabled), 0)
+	assert.InDelta(t, 0.0, icmpProxyStateValue(t, ICMPProxyEnabled), 0)
+}
+
+func icmpProxyStateValue(t *testing.T, state ICMPProxyState) float64 {
+	m := &dto.Metric{}
+	require.NoError(t, icmpProxyState.WithLabelValues(string(state)).Write(m))
+	return m.GetGauge().GetValue()
+}
@@ -223,7 +223,8 @@ type icmpProxy struct {
 	logger        *zerolog.Logger
 }
 
-func newICMPProxy(listenIP netip.Addr, logger *zerolog.Logger, idleTimeout time.Duration) (*icmpProxy, error) {
+// The source port range is ignored, the source port of ICMP sockets can only be restricted on Linux.
+func newICMPProxy(listenIP netip.Addr, _ PortRange, logger *zerolog.Logger, idleTimeout time.Duration) (*icmpProxy, error) {
 	var (
 		srcSocketAddr *sockAddrIn6
 		handle        uintptr
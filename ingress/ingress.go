@@ -1,12 +1,14 @@
 package ingress
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
@@ -27,6 +29,8 @@ var (
 	ErrURLIncompatibleWithIngress = errors.New("You can't set the --url flag (or $TUNNEL_URL) when using multiple-origin ingress rules")
 )
 
+const defaultExtAuthzTimeout = 5 * time.Second
+
 const (
 	ServiceBastion     = "bastion"
 	ServiceSocksProxy  = "socks-proxy"
@@ -177,6 +181,9 @@ func parseSingleOriginService(c *cli.Context, allowURLFromArgs bool) (OriginServ
 				url: originURL,
 			}, nil
 		}
+		if originURL.Scheme == "udp" {
+			return newUDPOverWSService(originURL)
+		}
 		return newTCPOverWSService(originURL), nil
 	}
 	if c.IsSet("unix-socket") {
@@ -228,6 +235,26 @@ func GetDefaultIngressRules(log *zerolog.Logger) []Rule {
 	}
 }
 
+func newExternalAuthorizer(cfg *config.ExtAuthzConfig) (*middleware.ExternalAuthorizer, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("url must be an http or https URL, got %q", cfg.URL)
+	}
+	timeout := defaultExtAuthzTimeout
+	if cfg.Timeout != nil {
+		timeout = cfg.Timeout.Duration
+	}
+	var failOpen bool
+	switch cfg.FailurePolicy {
+	case "", "deny":
+	case "allow":
+		failOpen = true
+	default:
+		return nil, fmt.Errorf("failurePolicy must be allow or deny, got %q", cfg.FailurePolicy)
+	}
+	return middleware.NewExternalAuthorizer(cfg.URL, timeout, failOpen), nil
+}
+
 func validateAccessConfiguration(cfg *config.AccessConfig) error {
 	if !cfg.Required {
 		return nil
@@ -283,7 +310,12 @@ func validateIngress(ingress []config.UnvalidatedIngressRule, defaults OriginReq
 				return Ingress{}, fmt.Errorf("unable to create ip access policy for %s: %s", r.Service, err)
 			}
 
-			service = newSocksProxyOverWSService(accessPolicy)
+			socksPolicy, err := newSocksPolicy(cfg.Socks)
+			if err != nil {
+				return Ingress{}, fmt.Errorf("invalid socks configuration for %s: %s", r.Service, err)
+			}
+
+			service = newSocksProxyOverWSService(accessPolicy, socksPolicy)
 		} else if r.Service == ServiceBastion || cfg.BastionMode {
 			// Bastion mode will always start a Websocket proxy server, which will
 			// overwrite the localService.URL field when `start` is called. So,
@@ -306,26 +338,54 @@ func validateIngress(ingress []config.UnvalidatedIngressRule, defaults OriginReq
 			}
 			if isHTTPService(u) {
 				service = &httpService{url: u}
+			} else if u.Scheme == "udp" {
+				if service, err = newUDPOverWSService(u); err != nil {
+					return Ingress{}, err
+				}
 			} else {
 				service = newTCPOverWSService(u)
 			}
 		}
 
-		var handlers []middleware.Handler
+		configured := make(map[string]middleware.Handler)
 		if access := r.OriginRequest.Access; access != nil {
 			if err := validateAccessConfiguration(access); err != nil {
 				return Ingress{}, err
 			}
 			if access.Required {
-				verifier := middleware.NewJWTValidator(access.TeamName, access.Environment, access.AudTag)
-				handlers = append(handlers, verifier)
+				configured[MiddlewareAccess] = middleware.NewJWTValidator(access.TeamName, access.Environment, access.AudTag)
+			}
+		}
+		if extAuthz := r.OriginRequest.ExtAuthz; extAuthz != nil {
+			authorizer, err := newExternalAuthorizer(extAuthz)
+			if err != nil {
+				return Ingress{}, errors.Wrapf(err, "Rule #%d has an invalid extAuthz configuration", i+1)
 			}
+			configured[MiddlewareExtAuthz] = authorizer
+		}
+		handlers, err := middlewareChain(cfg.Middleware, configured)
+		if err != nil {
+			return Ingress{}, errors.Wrapf(err, "Rule #%d has an invalid middleware chain", i+1)
+		}
+
+		cors, err := NewCORSPolicy(cfg.CORS)
+		if err != nil {
+			return Ingress{}, errors.Wrapf(err, "Rule #%d has an invalid cors configuration", i+1)
+		}
+
+		transform, err := NewTransform(cfg.Transform)
+		if err != nil {
+			return Ingress{}, errors.Wrapf(err, "Rule #%d has an invalid transform configuration", i+1)
 		}
 
 		if err := validateHostname(r, i, len(ingress)); err != nil {
 			return Ingress{}, err
 		}
 
+		if err := applyOriginTLSPolicy(&tls.Config{}, cfg); err != nil {
+			return Ingress{}, errors.Wrapf(err, "Rule #%d has an invalid origin TLS policy", i+1)
+		}
+
 		isCatchAllRule := (r.Hostname == "" || r.Hostname == "*") && r.Path == ""
 		punycodeHostname := ""
 		if !isCatchAllRule {
@@ -352,6 +412,8 @@ func validateIngress(ingress []config.UnvalidatedIngressRule, defaults OriginReq
 			Service:          service,
 			Path:             pathRegexp,
 			Handlers:         handlers,
+			CORS:             cors,
+			Transform:        transform,
 			Config:           cfg,
 		}
 	}
@@ -335,6 +335,27 @@ ingress:
 				},
 			},
 		},
+		{
+			name: "UDP services",
+			args: args{rawYAML: `
+ingress:
+- service: udp://127.0.0.1:53
+`},
+			want: []Rule{
+				{
+					Service: &udpOverWSService{dest: "127.0.0.1:53"},
+					Config:  defaultConfig,
+				},
+			},
+		},
+		{
+			name: "UDP services without a port",
+			args: args{rawYAML: `
+ingress:
+- service: udp://127.0.0.1
+`},
+			wantErr: true,
+		},
 		{
 			name: "SMB services",
 			args: args{rawYAML: `
@@ -379,7 +400,7 @@ ingress:
 			want: []Rule{
 				{
 					Hostname: "socks.foo.com",
-					Service:  newSocksProxyOverWSService(accessPolicy()),
+					Service:  newSocksProxyOverWSService(accessPolicy(), nil),
 					Config: setConfig(originRequestFromConfig(config.OriginRequestConfig{}), config.OriginRequestConfig{IPRules: []config.IngressIPRule{
 						{
 							Prefix: ipRulePrefix("1.1.1.0/24"),
@@ -642,7 +663,7 @@ func TestSingleOriginServices_URL(t *testing.T) {
 		})
 	}
 
-	tcpTests := []string{"ssh", "rdp", "smb", "tcp"}
+	tcpTests := []string{"ssh", "rdp", "smb", "vnc", "tcp"}
 	for _, test := range tcpTests {
 		t.Run(test, func(t *testing.T) {
 			url := urlMustParse(test + host)
@@ -832,6 +853,102 @@ func TestParseAccessConfig(t *testing.T) {
 	}
 }
 
+func TestNewExternalAuthorizer(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         config.ExtAuthzConfig
+		expectError bool
+	}{
+		{
+			name: "URL only",
+			cfg:  config.ExtAuthzConfig{URL: "http://authz.internal/check"},
+		},
+		{
+			name: "allow on failure",
+			cfg:  config.ExtAuthzConfig{URL: "https://authz.internal", FailurePolicy: "allow"},
+		},
+		{
+			name:        "missing URL",
+			cfg:         config.ExtAuthzConfig{},
+			expectError: true,
+		},
+		{
+			name:        "gRPC URL",
+			cfg:         config.ExtAuthzConfig{URL: "grpc://authz.internal"},
+			expectError: true,
+		},
+		{
+			name:        "unknown failure policy",
+			cfg:         config.ExtAuthzConfig{URL: "https://authz.internal", FailurePolicy: "retry"},
+			expectError: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := newExternalAuthorizer(&test.cfg)
+			require.Equal(t, test.expectError, err != nil)
+		})
+	}
+}
+
+func TestParseMiddlewareChain(t *testing.T) {
+	ing, err := ParseIngress(MustReadIngress(`
+originRequest:
+  middleware: [extAuthz, access]
+ingress:
+ - hostname: app.example.com
+   service: https://localhost:8000
+   originRequest:
+     access:
+       required: true
+       teamName: team
+       audTag: [aud]
+     extAuthz:
+       url: http://authz.internal
+ - hostname: api.example.com
+   service: https://localhost:8001
+   originRequest:
+     extAuthz:
+       url: http://authz.internal
+ - service: http_status:404
+`))
+	require.NoError(t, err)
+	require.Len(t, ing.Rules[0].Handlers, 2)
+	require.Equal(t, "ExternalAuthorizer", ing.Rules[0].Handlers[0].Name())
+	require.Equal(t, "AccessJWTValidator", ing.Rules[0].Handlers[1].Name())
+	require.Len(t, ing.Rules[1].Handlers, 1)
+
+	invalid := []string{
+		// Unknown middleware
+		`
+ingress:
+ - service: https://localhost:8000
+   originRequest:
+     middleware: [cache]
+`,
+		// Configured middleware missing from the chain
+		`
+originRequest:
+  middleware: [access]
+ingress:
+ - service: https://localhost:8000
+   originRequest:
+     extAuthz:
+       url: http://authz.internal
+`,
+		`
+ingress:
+ - service: https://localhost:8000
+   originRequest:
+     middleware: [access, access]
+`,
+	}
+	for _, rawYAML := range invalid {
+		_, err := ParseIngress(MustReadIngress(rawYAML))
+		require.Error(t, err, rawYAML)
+	}
+}
+
 func MustReadIngress(s string) *config.Configuration {
 	var conf config.Configuration
 	err := yaml.Unmarshal([]byte(s), &conf)
@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	headerForwardedMethod = "X-Forwarded-Method"
+	headerForwardedHost   = "X-Forwarded-Host"
+	headerForwardedURI    = "X-Forwarded-Uri"
+)
+
+// ExternalAuthorizer is an implementation of Handler that asks an external HTTP service whether requests are
+// authorized, in the style of forward auth: the service gets the headers of the request and allows it by answering
+// with a 2xx status.
+type ExternalAuthorizer struct {
+	url      string
+	client   *http.Client
+	failOpen bool
+}
+
+// NewExternalAuthorizer returns an ExternalAuthorizer asking url, which lets requests through when the service
+// can't be reached if failOpen is set.
+func NewExternalAuthorizer(url string, timeout time.Duration, failOpen bool) *ExternalAuthorizer {
+	return &ExternalAuthorizer{
+		url: url,
+		client: &http.Client{
+			Timeout: timeout,
+			// The answer of the authorization service is the redirect itself
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		failOpen: failOpen,
+	}
+}
+
+func (a *ExternalAuthorizer) Name() string {
+	return "ExternalAuthorizer"
+}
+
+func (a *ExternalAuthorizer) Handle(ctx context.Context, r *http.Request) (*HandleResult, error) {
+	authzReq, err := http.NewRequestWithContext(ctx, http.MethodGet, a.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	authzReq.Header = r.Header.Clone()
+	authzReq.Header.Set(headerForwardedMethod, r.Method)
+	authzReq.Header.Set(headerForwardedHost, r.Host)
+	authzReq.Header.Set(headerForwardedURI, r.URL.RequestURI())
+
+	resp, err := a.client.Do(authzReq)
+	if err != nil {
+		if a.failOpen {
+			return &HandleResult{ShouldFilterRequest: false}, nil
+		}
+		return &HandleResult{
+			ShouldFilterRequest: true,
+			StatusCode:          http.StatusForbidden,
+			Reason:              fmt.Sprintf("authorization service unavailable: %v", err),
+		}, nil
+	}
+	// Drains the body so the connection to the authorization service is reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	_ = resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return &HandleResult{ShouldFilterRequest: false}, nil
+	}
+	// The body of the answer isn't forwarded, so neither are the headers describing it
+	headers := resp.Header.Clone()
+	for _, header := range []string{"Content-Length", "Content-Encoding", "Transfer-Encoding", "Connection"} {
+		headers.Del(header)
+	}
+	return &HandleResult{
+		ShouldFilterRequest: true,
+		StatusCode:          resp.StatusCode,
+		Reason:              fmt.Sprintf("authorization service denied the request with status %d", resp.StatusCode),
+		Headers:             headers,
+	}, nil
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExternalAuthorizer(t *testing.T) {
+	authz := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Header.Get(headerForwardedMethod))
+		assert.Equal(t, "app.example.com", r.Header.Get(headerForwardedHost))
+		assert.Equal(t, "/orders?id=1", r.Header.Get(headerForwardedURI))
+		switch r.Header.Get("Authorization") {
+		case "Bearer valid":
+			w.WriteHeader(http.StatusNoContent)
+		case "":
+			w.Header().Set("Location", "https://login.example.com")
+			w.WriteHeader(http.StatusFound)
+		default:
+			w.WriteHeader(http.StatusForbidden)
+		}
+	}))
+	defer authz.Close()
+
+	authorizer := NewExternalAuthorizer(authz.URL, time.Second, false)
+	handle := func(authorization string) *HandleResult {
+		req := httptest.NewRequest(http.MethodPost, "http://app.example.com/orders?id=1", nil)
+		if authorization != "" {
+			req.Header.Set("Authorization", authorization)
+		}
+		result, err := authorizer.Handle(context.Background(), req)
+		require.NoError(t, err)
+		return result
+	}
+
+	require.False(t, handle("Bearer valid").ShouldFilterRequest)
+
+	result := handle("Bearer invalid")
+	require.True(t, result.ShouldFilterRequest)
+	require.Equal(t, http.StatusForbidden, result.StatusCode)
+
+	// Redirects, e.g. to a login page, are passed on to the eyeball
+	result = handle("")
+	require.True(t, result.ShouldFilterRequest)
+	require.Equal(t, http.StatusFound, result.StatusCode)
+	require.Equal(t, "https://login.example.com", result.Headers.Get("Location"))
+}
+
+func TestExternalAuthorizerFailurePolicy(t *testing.T) {
+	authz := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(time.Second)
+	}))
+	defer authz.Close()
+	req := httptest.NewRequest(http.MethodGet, "http://app.example.com", nil)
+
+	result, err := NewExternalAuthorizer(authz.URL, 10*time.Millisecond, false).Handle(context.Background(), req)
+	require.NoError(t, err)
+	require.True(t, result.ShouldFilterRequest)
+	require.Equal(t, http.StatusForbidden, result.StatusCode)
+
+	result, err = NewExternalAuthorizer(authz.URL, 10*time.Millisecond, true).Handle(context.Background(), req)
+	require.NoError(t, err)
+	require.False(t, result.ShouldFilterRequest)
+}
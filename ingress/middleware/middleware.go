@@ -11,6 +11,8 @@ type HandleResult struct {
 	// The status code to return in case ShouldFilterRequest is true.
 	StatusCode int
 	Reason     string
+	// Headers of the response to the filtered request, e.g. the Location of a login page
+	Headers http.Header
 }
 
 type Handler interface {
@@ -0,0 +1,55 @@
+package ingress
+
+import (
+	"fmt"
+
+	"github.com/cloudflare/cloudflared/ingress/middleware"
+)
+
+// Names of the middleware of a rule, which its middleware chain orders.
+const (
+	MiddlewareAccess   = "access"
+	MiddlewareExtAuthz = "extAuthz"
+)
+
+// defaultMiddlewareChain is the order of the middleware when the rule doesn't set one.
+var defaultMiddlewareChain = []string{MiddlewareAccess, MiddlewareExtAuthz}
+
+// middlewareChain returns the configured middleware of a rule in the order of chain, or of defaultMiddlewareChain
+// when chain is empty. Middleware of the chain a rule doesn't configure is skipped, so a chain set at the top level
+// applies to every rule, but configured middleware missing from the chain is an error: leaving out, e.g., access
+// would otherwise let unauthenticated requests through.
+func middlewareChain(chain []string, configured map[string]middleware.Handler) ([]middleware.Handler, error) {
+	if len(chain) == 0 {
+		chain = defaultMiddlewareChain
+	}
+	var handlers []middleware.Handler
+	seen := make(map[string]bool, len(chain))
+	for _, name := range chain {
+		if !isMiddlewareName(name) {
+			return nil, fmt.Errorf("unknown middleware %s, expected one of %v", name, defaultMiddlewareChain)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("middleware %s is listed more than once", name)
+		}
+		seen[name] = true
+		if handler, ok := configured[name]; ok {
+			handlers = append(handlers, handler)
+		}
+	}
+	for _, name := range defaultMiddlewareChain {
+		if _, ok := configured[name]; ok && !seen[name] {
+			return nil, fmt.Errorf("middleware %s is configured but missing from the chain", name)
+		}
+	}
+	return handlers, nil
+}
+
+func isMiddlewareName(name string) bool {
+	for _, known := range defaultMiddlewareChain {
+		if name == known {
+			return true
+		}
+	}
+	return false
+}
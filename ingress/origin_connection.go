@@ -4,6 +4,7 @@ import (
 	"context"
 	"io"
 	"net"
+	"net/netip"
 	"time"
 
 	"github.com/rs/zerolog"
@@ -30,6 +31,12 @@ func DefaultStreamHandler(originConn io.ReadWriter, remoteConn net.Conn, log *ze
 	stream.Pipe(originConn, remoteConn, log)
 }
 
+// InteractiveStreamHandler is an implementation of streamHandlerFunc for interactive protocols, e.g. RDP and VNC.
+// It forwards data in small writes which take priority over bulk transfers through the tunnel.
+func InteractiveStreamHandler(originConn io.ReadWriter, remoteConn net.Conn, log *zerolog.Logger) {
+	stream.PipeInteractive(originConn, remoteConn, log)
+}
+
 // tcpConnection is an OriginConnection that directly streams to raw TCP.
 type tcpConnection struct {
 	net.Conn
@@ -75,12 +82,14 @@ func (wc *tcpOverWSConnection) Close() error {
 // details in the packet.
 type socksProxyOverWSConnection struct {
 	accessPolicy *ipaccess.Policy
+	socksPolicy  *socks.Policy
 }
 
 func (sp *socksProxyOverWSConnection) Stream(ctx context.Context, tunnelConn io.ReadWriter, log *zerolog.Logger) {
 	wsCtx, cancel := context.WithCancel(ctx)
 	wsConn := websocket.NewConn(wsCtx, tunnelConn, log)
-	socks.StreamNetHandler(wsConn, sp.accessPolicy, log)
+	clientIP, _ := ClientIPFromContext(ctx)
+	socks.StreamNetHandler(wsConn, sp.accessPolicy, sp.socksPolicy, clientIP, log)
 	cancel()
 	// Makes sure wsConn stops sending ping before terminating the stream
 	wsConn.Close()
@@ -89,3 +98,17 @@ func (sp *socksProxyOverWSConnection) Stream(ctx context.Context, tunnelConn io.
 func (sp *socksProxyOverWSConnection) Close() error {
 	return nil
 }
+
+type clientIPKey struct{}
+
+// ContextWithClientIP returns a copy of ctx carrying the IP of the client that opened a stream, which stream
+// services such as socks-proxy can restrict.
+func ContextWithClientIP(ctx context.Context, ip netip.Addr) context.Context {
+	return context.WithValue(ctx, clientIPKey{}, ip)
+}
+
+// ClientIPFromContext returns the IP of the client that opened the stream of ctx, if known.
+func ClientIPFromContext(ctx context.Context) (netip.Addr, bool) {
+	ip, ok := ctx.Value(clientIPKey{}).(netip.Addr)
+	return ip, ok
+}
@@ -2,17 +2,38 @@ package ingress
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/netip"
+	"strings"
 	"sync"
+	"syscall"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/faultinject"
 )
 
 const writeDeadlineUDP = 200 * time.Millisecond
 
+// ErrOriginDialFallback is wrapped by the errors of an origin dialer plugin that cloudflared should dial the origin
+// itself after, e.g. because the plugin is unavailable or declined to dial it.
+var ErrOriginDialFallback = errors.New("falling back to the built-in origin dialer")
+
+var pluginDials = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "origin",
+	Name:      "plugin_dials_total",
+	Help:      "Count of origin dials delegated to the origin dialer plugin, by network and result: dialed, fallback or failed",
+}, []string{"network", "result"})
+
+func init() {
+	prometheus.MustRegister(pluginDials)
+}
+
 // OriginTCPDialer provides a TCP dial operation to a requested address.
 type OriginTCPDialer interface {
 	DialTCP(ctx context.Context, addr netip.AddrPort) (net.Conn, error)
@@ -34,6 +55,11 @@ type OriginConfig struct {
 	DefaultDialer OriginDialer
 	// Timeout on write operations for TCP connections to the origin.
 	TCPWriteTimeout time.Duration
+	// Faults injected before dialing origins, if any.
+	Faults *faultinject.Injector
+	// Plugin, if any, is consulted before DefaultDialer to dial the origins without a reserved service. Its errors
+	// wrapping ErrOriginDialFallback fall back to DefaultDialer.
+	Plugin OriginDialer
 }
 
 // OriginDialerService provides a proxy TCP and UDP dialer to origin services while allowing reserved
@@ -49,6 +75,10 @@ type OriginDialerService struct {
 	defaultDialerM sync.RWMutex
 	// Write timeout for TCP connections
 	writeTimeout time.Duration
+	// Faults injected before dialing origins
+	faults *faultinject.Injector
+	// Plugin consulted before the default dialer
+	plugin OriginDialer
 
 	logger *zerolog.Logger
 }
@@ -59,6 +89,8 @@ func NewOriginDialer(config OriginConfig, logger *zerolog.Logger) *OriginDialerS
 		reservedUDPServices: map[netip.AddrPort]OriginUDPDialer{},
 		defaultDialer:       config.DefaultDialer,
 		writeTimeout:        config.TCPWriteTimeout,
+		faults:              config.Faults,
+		plugin:              config.Plugin,
 		logger:              logger,
 	}
 }
@@ -81,6 +113,9 @@ func (d *OriginDialerService) UpdateDefaultDialer(dialer *Dialer) {
 
 // DialTCP will perform a dial TCP to the requested addr.
 func (d *OriginDialerService) DialTCP(ctx context.Context, addr netip.AddrPort) (net.Conn, error) {
+	if err := d.faults.DelayOriginDial(ctx); err != nil {
+		return nil, err
+	}
 	conn, err := d.dialTCP(ctx, addr)
 	if err != nil {
 		return nil, err
@@ -98,6 +133,12 @@ func (d *OriginDialerService) dialTCP(ctx context.Context, addr netip.AddrPort)
 	if dialer, ok := d.reservedTCPServices[addr]; ok {
 		return dialer.DialTCP(ctx, addr)
 	}
+	if d.plugin != nil {
+		conn, err := d.plugin.DialTCP(ctx, addr)
+		if !d.pluginFallback("tcp", addr, err) {
+			return conn, err
+		}
+	}
 	d.defaultDialerM.RLock()
 	dialer := d.defaultDialer
 	d.defaultDialerM.RUnlock()
@@ -106,27 +147,74 @@ func (d *OriginDialerService) dialTCP(ctx context.Context, addr netip.AddrPort)
 
 // DialUDP will perform a dial UDP to the requested addr.
 func (d *OriginDialerService) DialUDP(addr netip.AddrPort) (net.Conn, error) {
+	if err := d.faults.DelayOriginDial(context.Background()); err != nil {
+		return nil, err
+	}
 	// Check to see if any reserved services are available for this addr and call their dialer instead.
 	if dialer, ok := d.reservedUDPServices[addr]; ok {
 		return dialer.DialUDP(addr)
 	}
+	if d.plugin != nil {
+		conn, err := d.plugin.DialUDP(addr)
+		if !d.pluginFallback("udp", addr, err) {
+			return conn, err
+		}
+	}
 	d.defaultDialerM.RLock()
 	dialer := d.defaultDialer
 	d.defaultDialerM.RUnlock()
 	return dialer.DialUDP(addr)
 }
 
+// pluginFallback accounts for the result of dialing addr with the plugin, and returns whether to dial it with the
+// default dialer instead.
+func (d *OriginDialerService) pluginFallback(network string, addr netip.AddrPort, err error) bool {
+	switch {
+	case err == nil:
+		pluginDials.WithLabelValues(network, "dialed").Inc()
+		return false
+	case errors.Is(err, ErrOriginDialFallback):
+		pluginDials.WithLabelValues(network, "fallback").Inc()
+		d.logger.Debug().Err(err).Msgf("Origin dialer plugin didn't dial %s %s, dialing it directly", network, addr)
+		return true
+	default:
+		pluginDials.WithLabelValues(network, "failed").Inc()
+		return false
+	}
+}
+
 type Dialer struct {
 	Dialer net.Dialer
+	// Socket buffer sizes of TCP connections, 0 keeps the OS defaults
+	tcpReadBufferSize  int
+	tcpWriteBufferSize int
 }
 
 func NewDialer(config WarpRoutingConfig) *Dialer {
-	return &Dialer{
+	d := &Dialer{
 		Dialer: net.Dialer{
 			Timeout:   config.ConnectTimeout.Duration,
 			KeepAlive: config.TCPKeepAlive.Duration,
 		},
+		tcpReadBufferSize:  config.TCPReadBufferSize,
+		tcpWriteBufferSize: config.TCPWriteBufferSize,
 	}
+	if mss := config.TCPMaxSegmentSize; mss > 0 {
+		// The MSS is advertised in the SYN, so it has to be set before connecting
+		d.Dialer.Control = func(network, _ string, c syscall.RawConn) error {
+			if !strings.HasPrefix(network, "tcp") {
+				return nil
+			}
+			var sockErr error
+			if err := c.Control(func(fd uintptr) {
+				sockErr = setTCPMaxSegmentSize(fd, mss)
+			}); err != nil {
+				return err
+			}
+			return sockErr
+		}
+	}
+	return d
 }
 
 func (d *Dialer) DialTCP(ctx context.Context, dest netip.AddrPort) (net.Conn, error) {
@@ -134,10 +222,32 @@ func (d *Dialer) DialTCP(ctx context.Context, dest netip.AddrPort) (net.Conn, er
 	if err != nil {
 		return nil, fmt.Errorf("unable to dial tcp to origin %s: %w", dest, err)
 	}
+	if err := d.setTCPBufferSizes(conn); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("unable to set tcp buffer sizes to origin %s: %w", dest, err)
+	}
 
 	return conn, nil
 }
 
+func (d *Dialer) setTCPBufferSizes(conn net.Conn) error {
+	tcpConn, ok := conn.(*net.TCPConn)
+	if !ok {
+		return nil
+	}
+	if d.tcpReadBufferSize > 0 {
+		if err := tcpConn.SetReadBuffer(d.tcpReadBufferSize); err != nil {
+			return err
+		}
+	}
+	if d.tcpWriteBufferSize > 0 {
+		if err := tcpConn.SetWriteBuffer(d.tcpWriteBufferSize); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (d *Dialer) DialUDP(dest netip.AddrPort) (net.Conn, error) {
 	conn, err := d.Dialer.Dial("udp", dest.String())
 	if err != nil {
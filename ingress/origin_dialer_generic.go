@@ -0,0 +1,8 @@
+//go:build !darwin && !linux
+
+package ingress
+
+// setTCPMaxSegmentSize is a no-op, clamping the MSS is only supported on Linux and macOS.
+func setTCPMaxSegmentSize(_ uintptr, _ int) error {
+	return nil
+}
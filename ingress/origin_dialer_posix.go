@@ -0,0 +1,10 @@
+//go:build darwin || linux
+
+package ingress
+
+import "syscall"
+
+// setTCPMaxSegmentSize clamps the MSS of the TCP socket, it must be called before connecting.
+func setTCPMaxSegmentSize(fd uintptr, mss int) error {
+	return syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_MAXSEG, mss)
+}
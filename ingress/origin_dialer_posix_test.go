@@ -0,0 +1,66 @@
+//go:build darwin || linux
+
+package ingress
+
+import (
+	"net"
+	"net/netip"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+func TestDialerTCPSocketOptions(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			_ = conn.Close()
+		}
+	}()
+
+	dialer := NewDialer(WarpRoutingConfig{
+		ConnectTimeout:     config.CustomDuration{Duration: time.Second},
+		TCPMaxSegmentSize:  1220,
+		TCPReadBufferSize:  64 * 1024,
+		TCPWriteBufferSize: 32 * 1024,
+	})
+	conn, err := dialer.DialTCP(t.Context(), netip.MustParseAddrPort(listener.Addr().String()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	rawConn, err := conn.(*net.TCPConn).SyscallConn()
+	require.NoError(t, err)
+	var mss, readBuffer, writeBuffer int
+	var sockErr error
+	require.NoError(t, rawConn.Control(func(fd uintptr) {
+		if mss, sockErr = syscall.GetsockoptInt(int(fd), syscall.IPPROTO_TCP, syscall.TCP_MAXSEG); sockErr != nil {
+			return
+		}
+		if readBuffer, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF); sockErr != nil {
+			return
+		}
+		writeBuffer, sockErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	}))
+	require.NoError(t, sockErr)
+	require.LessOrEqual(t, mss, 1220)
+	// Linux doubles the requested buffer sizes to account for bookkeeping overhead
+	require.GreaterOrEqual(t, readBuffer, 64*1024)
+	require.GreaterOrEqual(t, writeBuffer, 32*1024)
+}
+
+func TestDialerMSSClampOnlyAppliesToTCP(t *testing.T) {
+	dialer := NewDialer(WarpRoutingConfig{TCPMaxSegmentSize: 1220})
+	conn, err := dialer.DialUDP(netip.MustParseAddrPort("127.0.0.1:53"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+}
@@ -0,0 +1,220 @@
+package ingress
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+const (
+	ipPreferenceV4 = "ipv4"
+	ipPreferenceV6 = "ipv6"
+
+	defaultOriginDNSPort = "53"
+)
+
+// originResolver resolves the hostnames of origins as configured by the dns option of their rule. A nil
+// originResolver leaves the resolution to the dialer, i.e. to the resolvers of the host.
+type originResolver struct {
+	// hosts are the static addresses of hostnames, by lowercase hostname
+	hosts      map[string][]netip.Addr
+	resolvers  []string
+	preferIPv6 bool
+	preferIPv4 bool
+	client     *dns.Client
+
+	cacheM sync.Mutex
+	cache  map[string]cachedAddrs
+	now    func() time.Time
+}
+
+type cachedAddrs struct {
+	addrs   []netip.Addr
+	expires time.Time
+}
+
+// newOriginResolver returns the resolver of cfg, or nil when cfg is nil.
+func newOriginResolver(cfg *config.OriginDNSConfig) (*originResolver, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	r := originResolver{
+		hosts:  make(map[string][]netip.Addr, len(cfg.Hosts)),
+		client: &dns.Client{},
+		cache:  make(map[string]cachedAddrs),
+		now:    time.Now,
+	}
+	for host, addrs := range cfg.Hosts {
+		if len(addrs) == 0 {
+			return nil, fmt.Errorf("dns host %s has no addresses", host)
+		}
+		for _, addr := range addrs {
+			ip, err := netip.ParseAddr(addr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %s of dns host %s: %w", addr, host, err)
+			}
+			r.hosts[strings.ToLower(host)] = append(r.hosts[strings.ToLower(host)], ip)
+		}
+	}
+	for _, resolver := range cfg.Resolvers {
+		if _, err := netip.ParseAddr(resolver); err == nil {
+			resolver = net.JoinHostPort(resolver, defaultOriginDNSPort)
+		}
+		if _, err := netip.ParseAddrPort(resolver); err != nil {
+			return nil, fmt.Errorf("invalid dns resolver %s, expected an IP with an optional port", resolver)
+		}
+		r.resolvers = append(r.resolvers, resolver)
+	}
+	switch cfg.IPPreference {
+	case "":
+	case ipPreferenceV4:
+		r.preferIPv4 = true
+	case ipPreferenceV6:
+		r.preferIPv6 = true
+	default:
+		return nil, fmt.Errorf("invalid dns ipPreference %s, expected %s or %s", cfg.IPPreference, ipPreferenceV4, ipPreferenceV6)
+	}
+	return &r, nil
+}
+
+// dial connects to address with dialer, resolving its hostname with r. The addresses are dialed in turn until one
+// of them answers.
+func (r *originResolver) dial(ctx context.Context, dialer *net.Dialer, network, address string) (net.Conn, error) {
+	if r == nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := netip.ParseAddr(host); err == nil {
+		return dialer.DialContext(ctx, network, address)
+	}
+	addrs, err := r.lookup(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	var dialErr error
+	for _, addr := range r.sort(addrs) {
+		conn, err := dialer.DialContext(ctx, network, net.JoinHostPort(addr.String(), port))
+		if err == nil {
+			return conn, nil
+		}
+		dialErr = err
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, dialErr
+}
+
+// sort returns addrs with the preferred address family first, keeping the order of the answers otherwise.
+func (r *originResolver) sort(addrs []netip.Addr) []netip.Addr {
+	if !r.preferIPv4 && !r.preferIPv6 {
+		return addrs
+	}
+	sorted := slices.Clone(addrs)
+	slices.SortStableFunc(sorted, func(a, b netip.Addr) int {
+		preferred := func(addr netip.Addr) bool {
+			return addr.Is4() == r.preferIPv4
+		}
+		switch {
+		case preferred(a) == preferred(b):
+			return 0
+		case preferred(a):
+			return -1
+		default:
+			return 1
+		}
+	})
+	return sorted
+}
+
+func (r *originResolver) lookup(ctx context.Context, host string) ([]netip.Addr, error) {
+	host = strings.ToLower(host)
+	if addrs, ok := r.hosts[host]; ok {
+		return addrs, nil
+	}
+	if len(r.resolvers) == 0 {
+		addrs, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+		if err != nil {
+			return nil, err
+		}
+		for i, addr := range addrs {
+			addrs[i] = addr.Unmap()
+		}
+		return addrs, nil
+	}
+
+	r.cacheM.Lock()
+	cached, ok := r.cache[host]
+	r.cacheM.Unlock()
+	if ok && r.now().Before(cached.expires) {
+		return cached.addrs, nil
+	}
+
+	var lookupErr error
+	for _, resolver := range r.resolvers {
+		addrs, ttl, err := r.query(ctx, resolver, host)
+		if err != nil {
+			lookupErr = err
+			continue
+		}
+		r.cacheM.Lock()
+		r.cache[host] = cachedAddrs{addrs: addrs, expires: r.now().Add(ttl)}
+		r.cacheM.Unlock()
+		return addrs, nil
+	}
+	return nil, fmt.Errorf("unable to resolve %s: %w", host, lookupErr)
+}
+
+// query returns the IPv4 and IPv6 addresses of host answered by resolver, and how long they can be cached.
+func (r *originResolver) query(ctx context.Context, resolver, host string) ([]netip.Addr, time.Duration, error) {
+	var (
+		addrs  []netip.Addr
+		minTTL uint32
+	)
+	for _, qtype := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		msg := new(dns.Msg)
+		msg.SetQuestion(dns.Fqdn(host), qtype)
+		answer, _, err := r.client.ExchangeContext(ctx, msg, resolver)
+		if err != nil {
+			return nil, 0, err
+		}
+		if answer.Rcode != dns.RcodeSuccess && answer.Rcode != dns.RcodeNameError {
+			return nil, 0, fmt.Errorf("%s answered %s", resolver, dns.RcodeToString[answer.Rcode])
+		}
+		for _, rr := range answer.Answer {
+			var ip net.IP
+			switch rr := rr.(type) {
+			case *dns.A:
+				ip = rr.A
+			case *dns.AAAA:
+				ip = rr.AAAA
+			default:
+				continue
+			}
+			addr, ok := netip.AddrFromSlice(ip)
+			if !ok {
+				continue
+			}
+			addrs = append(addrs, addr.Unmap())
+			if ttl := rr.Header().Ttl; len(addrs) == 1 || ttl < minTTL {
+				minTTL = ttl
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		return nil, 0, fmt.Errorf("%s has no addresses according to %s", host, resolver)
+	}
+	return addrs, time.Duration(minTTL) * time.Second, nil
+}
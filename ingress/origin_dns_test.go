@@ -0,0 +1,111 @@
+package ingress
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+func TestNewOriginResolver(t *testing.T) {
+	resolver, err := newOriginResolver(nil)
+	require.NoError(t, err)
+	require.Nil(t, resolver)
+
+	invalid := []*config.OriginDNSConfig{
+		{Hosts: map[string][]string{"db.internal": {}}},
+		{Hosts: map[string][]string{"db.internal": {"db.example.com"}}},
+		{Resolvers: []string{"dns.internal:53"}},
+		{IPPreference: "ipv5"},
+	}
+	for _, cfg := range invalid {
+		_, err := newOriginResolver(cfg)
+		require.Error(t, err, cfg)
+	}
+
+	resolver, err = newOriginResolver(&config.OriginDNSConfig{Resolvers: []string{"10.0.0.53", "[fd00::53]:5353"}})
+	require.NoError(t, err)
+	require.Equal(t, []string{"10.0.0.53:53", "[fd00::53]:5353"}, resolver.resolvers)
+}
+
+func TestOriginResolverHosts(t *testing.T) {
+	origin, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer origin.Close()
+	_, port, err := net.SplitHostPort(origin.Addr().String())
+	require.NoError(t, err)
+
+	resolver, err := newOriginResolver(&config.OriginDNSConfig{
+		Hosts:        map[string][]string{"Origin.Internal": {"::1", "127.0.0.1"}},
+		IPPreference: ipPreferenceV4,
+	})
+	require.NoError(t, err)
+	addrs, err := resolver.lookup(context.Background(), "origin.internal")
+	require.NoError(t, err)
+	require.Equal(t, []netip.Addr{netip.MustParseAddr("127.0.0.1"), netip.MustParseAddr("::1")}, resolver.sort(addrs))
+
+	conn, err := resolver.dial(context.Background(), &net.Dialer{}, "tcp", net.JoinHostPort("origin.internal", port))
+	require.NoError(t, err)
+	require.Equal(t, origin.Addr().String(), conn.RemoteAddr().String())
+	conn.Close()
+}
+
+func TestOriginResolverCache(t *testing.T) {
+	var queries atomic.Int32
+	server := &dns.Server{Addr: "127.0.0.1:0", Net: "udp", Handler: dns.HandlerFunc(func(w dns.ResponseWriter, req *dns.Msg) {
+		queries.Add(1)
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		switch req.Question[0].Qtype {
+		case dns.TypeA:
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 30},
+				A:   net.ParseIP("10.0.0.1"),
+			})
+		case dns.TypeAAAA:
+			resp.Answer = append(resp.Answer, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: 60},
+				AAAA: net.ParseIP("fd00::1"),
+			})
+		}
+		_ = w.WriteMsg(resp)
+	})}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go func() { _ = server.ListenAndServe() }()
+	<-started
+	defer server.Shutdown()
+
+	resolver, err := newOriginResolver(&config.OriginDNSConfig{
+		Resolvers:    []string{server.PacketConn.LocalAddr().String()},
+		IPPreference: ipPreferenceV6,
+	})
+	require.NoError(t, err)
+	now := time.Now()
+	resolver.now = func() time.Time { return now }
+
+	expected := []netip.Addr{netip.MustParseAddr("10.0.0.1"), netip.MustParseAddr("fd00::1")}
+	addrs, err := resolver.lookup(context.Background(), "app.internal")
+	require.NoError(t, err)
+	require.Equal(t, expected, addrs)
+	require.Equal(t, []netip.Addr{expected[1], expected[0]}, resolver.sort(addrs))
+	require.EqualValues(t, 2, queries.Load())
+
+	// The answers are cached for the lowest TTL
+	now = now.Add(29 * time.Second)
+	_, err = resolver.lookup(context.Background(), "app.internal")
+	require.NoError(t, err)
+	require.EqualValues(t, 2, queries.Load())
+
+	now = now.Add(time.Second)
+	_, err = resolver.lookup(context.Background(), "app.internal")
+	require.NoError(t, err)
+	require.EqualValues(t, 4, queries.Load())
+}
@@ -0,0 +1,101 @@
+package ingress
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// http3RetryInterval is how long requests to an origin go over HTTP/2 or HTTP/1.1 after it couldn't be reached
+	// over QUIC, before HTTP/3 is tried again.
+	http3RetryInterval   = 5 * time.Minute
+	http3KeepAlivePeriod = 15 * time.Second
+)
+
+// http3OriginQUICConfig is the QUIC configuration shared by the HTTP/3 transports of all origins.
+var http3OriginQUICConfig = &quic.Config{
+	KeepAlivePeriod: http3KeepAlivePeriod,
+}
+
+// http3DialError is returned by the HTTP/3 transport when the origin couldn't be reached over QUIC. Nothing has been
+// sent to the origin then, so the request can be retried with the fallback transport.
+type http3DialError struct {
+	err error
+}
+
+func (e *http3DialError) Error() string {
+	return "unable to reach the origin over QUIC: " + e.err.Error()
+}
+
+func (e *http3DialError) Unwrap() error {
+	return e.err
+}
+
+// http3OriginTransport sends requests to an origin over HTTP/3, or with the fallback transport when the origin can't
+// be reached over QUIC, e.g. when UDP is blocked on the way. Connections to the origin are reused across requests.
+type http3OriginTransport struct {
+	transport *http3.Transport
+	fallback  http.RoundTripper
+	log       *zerolog.Logger
+	// fallbackUntil is the unix nano time until which requests go straight to the fallback transport
+	fallbackUntil atomic.Int64
+}
+
+func newHTTP3OriginTransport(
+	tlsConfig *tls.Config,
+	cfg OriginRequestConfig,
+	fallback http.RoundTripper,
+	shutdownC <-chan struct{},
+	log *zerolog.Logger,
+) *http3OriginTransport {
+	connectTimeout := cfg.ConnectTimeout.Duration
+	transport := &http3.Transport{
+		TLSClientConfig: tlsConfig.Clone(),
+		QUICConfig:      http3OriginQUICConfig,
+		Dial: func(ctx context.Context, addr string, tlsCfg *tls.Config, quicCfg *quic.Config) (quic.EarlyConnection, error) {
+			if connectTimeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, connectTimeout)
+				defer cancel()
+			}
+			conn, err := quic.DialAddrEarly(ctx, addr, tlsCfg, quicCfg)
+			if err != nil {
+				return nil, &http3DialError{err: err}
+			}
+			return conn, nil
+		},
+	}
+	go func() {
+		<-shutdownC
+		_ = transport.Close()
+	}()
+	return &http3OriginTransport{
+		transport: transport,
+		fallback:  fallback,
+		log:       log,
+	}
+}
+
+func (t *http3OriginTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Upgrades such as websockets can't be done over HTTP/3
+	if req.Header.Get("Upgrade") != "" || time.Now().UnixNano() < t.fallbackUntil.Load() {
+		return t.fallback.RoundTrip(req)
+	}
+	resp, err := t.transport.RoundTrip(req)
+	var dialErr *http3DialError
+	if err != nil && errors.As(err, &dialErr) && req.Context().Err() == nil {
+		t.log.Debug().Err(err).Str("host", req.URL.Host).
+			Msgf("Falling back to HTTP/2 or HTTP/1.1 for the next %s", http3RetryInterval)
+		t.fallbackUntil.Store(time.Now().Add(http3RetryInterval).UnixNano())
+		return t.fallback.RoundTrip(req)
+	}
+	return resp, err
+}
@@ -0,0 +1,97 @@
+package ingress
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+func TestHTTPServiceHTTP3Origin(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	})
+	origin := httptest.NewTLSServer(handler)
+	defer origin.Close()
+
+	// Serve HTTP/3 on the UDP port of the same address
+	udpConn, err := net.ListenPacket("udp", origin.Listener.Addr().String())
+	require.NoError(t, err)
+	h3Server := &http3.Server{
+		Handler:   handler,
+		TLSConfig: http3.ConfigureTLSConfig(origin.TLS.Clone()),
+	}
+	go func() {
+		_ = h3Server.Serve(udpConn)
+	}()
+	defer h3Server.Close()
+
+	httpService := startHTTP3TestService(t, origin.URL)
+
+	require.Equal(t, "HTTP/3.0", roundTripProto(t, httpService, nil))
+	// Websockets can't be proxied over HTTP/3
+	require.Equal(t, "HTTP/1.1", roundTripProto(t, httpService, http.Header{"Upgrade": []string{"websocket"}}))
+}
+
+func TestHTTPServiceHTTP3OriginFallback(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	}))
+	defer origin.Close()
+
+	httpService := startHTTP3TestService(t, origin.URL)
+
+	// Nothing listens on UDP, so the request falls back to HTTP/1.1
+	require.Equal(t, "HTTP/1.1", roundTripProto(t, httpService, nil))
+	require.Greater(t, httpService.http3.fallbackUntil.Load(), time.Now().UnixNano())
+	require.Equal(t, "HTTP/1.1", roundTripProto(t, httpService, nil))
+}
+
+func TestHTTPServiceHTTP3OriginIgnoredForHTTP(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Proto))
+	}))
+	defer origin.Close()
+
+	httpService := startHTTP3TestService(t, origin.URL)
+	require.Nil(t, httpService.http3)
+	require.Equal(t, "HTTP/1.1", roundTripProto(t, httpService, nil))
+}
+
+func startHTTP3TestService(t *testing.T, originURL string) *httpService {
+	u, err := url.Parse(originURL)
+	require.NoError(t, err)
+	cfg := OriginRequestConfig{
+		ConnectTimeout: config.CustomDuration{Duration: 200 * time.Millisecond},
+		NoTLSVerify:    true,
+		Http3Origin:    true,
+	}
+	httpService := &httpService{url: u}
+	shutdownC := make(chan struct{})
+	t.Cleanup(func() { close(shutdownC) })
+	require.NoError(t, httpService.start(TestLogger, shutdownC, cfg))
+	return httpService
+}
+
+func roundTripProto(t *testing.T, httpService *httpService, header http.Header) string {
+	req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+	require.NoError(t, err)
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	resp, err := httpService.RoundTrip(req)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	return string(body)
+}
@@ -62,24 +62,28 @@ type icmpRouter struct {
 }
 
 // NewICMPRouter doesn't return an error if either ipv4 proxy or ipv6 proxy can be created. The machine might only
-// support one of them.
+// support one of them. The resulting state is reported by GetICMPProxyStatus and the icmp proxy_state metric.
+// sourcePorts restricts the ports, which are also the echo IDs, of the sockets opened towards the origins on Linux.
 // funnelIdleTimeout controls how long to wait to close a funnel without send/return
-func NewICMPRouter(ipv4Addr, ipv6Addr netip.Addr, logger *zerolog.Logger, funnelIdleTimeout time.Duration) (ICMPRouterServer, error) {
-	ipv4Proxy, ipv4Err := newICMPProxy(ipv4Addr, logger, funnelIdleTimeout)
-	ipv6Proxy, ipv6Err := newICMPProxy(ipv6Addr, logger, funnelIdleTimeout)
+func NewICMPRouter(ipv4Addr, ipv6Addr netip.Addr, sourcePorts PortRange, logger *zerolog.Logger, funnelIdleTimeout time.Duration) (ICMPRouterServer, error) {
+	ipv4Proxy, ipv4Err := newICMPProxy(ipv4Addr, sourcePorts, logger, funnelIdleTimeout)
+	ipv6Proxy, ipv6Err := newICMPProxy(ipv6Addr, sourcePorts, logger, funnelIdleTimeout)
+	status := icmpRouterStatus(ipv4Err, ipv6Err)
+	setICMPProxyStatus(status)
 	if ipv4Err != nil && ipv6Err != nil {
 		err := fmt.Errorf("cannot create ICMPv4 proxy: %v nor ICMPv6 proxy: %v", ipv4Err, ipv6Err)
 		logger.Debug().Err(err).Msg("ICMP proxy feature is disabled")
 		return nil, err
 	}
 	if ipv4Err != nil {
-		logger.Debug().Err(ipv4Err).Msg("failed to create ICMPv4 proxy, only ICMPv6 proxy is created")
+		logger.Warn().Err(ipv4Err).Msg("failed to create ICMPv4 proxy, only ICMPv6 proxy is created")
 		ipv4Proxy = nil
 	}
 	if ipv6Err != nil {
-		logger.Debug().Err(ipv6Err).Msg("failed to create ICMPv6 proxy, only ICMPv4 proxy is created")
+		logger.Warn().Err(ipv6Err).Msg("failed to create ICMPv6 proxy, only ICMPv4 proxy is created")
 		ipv6Proxy = nil
 	}
+	logger.Info().Str("state", string(status.State)).Msgf("ICMP proxy is %s", status.State)
 	return &icmpRouter{
 		ipv4Proxy: ipv4Proxy,
 		ipv4Src:   ipv4Addr,
@@ -55,6 +55,9 @@ func (o *httpService) RoundTrip(req *http.Request) (*http.Response, error) {
 		o.SetOriginServerName(req)
 	}
 
+	if o.http3 != nil {
+		return o.http3.RoundTrip(req)
+	}
 	return o.transport.RoundTrip(req)
 }
 
@@ -64,11 +67,10 @@ func (o *httpService) SetOriginServerName(req *http.Request) {
 		if err != nil {
 			return nil, err
 		}
-		return tls.Client(conn, &tls.Config{
-			RootCAs:            o.transport.TLSClientConfig.RootCAs,
-			InsecureSkipVerify: o.transport.TLSClientConfig.InsecureSkipVerify, // nolint: gosec
-			ServerName:         req.Host,
-		}), nil
+		// Keep the verification and TLS policy of the origin, only the server name changes
+		tlsConfig := o.transport.TLSClientConfig.Clone()
+		tlsConfig.ServerName = req.Host
+		return tls.Client(conn, tlsConfig), nil
 	}
 }
 
@@ -105,10 +107,32 @@ func (o *tcpOverWSService) EstablishConnection(ctx context.Context, dest string,
 		dest = o.dest
 	}
 
-	conn, err := o.dialer.DialContext(ctx, "tcp", dest)
+	conn, err := o.resolver.dial(ctx, &o.dialer, "tcp", dest)
 	if err != nil {
 		return nil, err
 	}
+	if tcpConn, ok := conn.(*net.TCPConn); ok && o.latencyMode {
+		// Go disables Nagle's algorithm by default, make sure it stays off for interactive protocols
+		if err := tcpConn.SetNoDelay(true); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if o.proxyProtocol {
+		// The header isn't part of the session, so it's sent before the connection is recorded
+		clientIP, _ := ClientIPFromContext(ctx)
+		if err := writeProxyProtocolHeader(conn, clientIP, conn.RemoteAddr()); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if o.recorder != nil {
+		var clientIP string
+		if ip, ok := ClientIPFromContext(ctx); ok {
+			clientIP = ip.String()
+		}
+		conn = o.recorder.Record(conn, dest, clientIP)
+	}
 	originConn := &tcpOverWSConnection{
 		conn:          conn,
 		streamHandler: o.streamHandler,
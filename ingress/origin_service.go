@@ -8,6 +8,7 @@ import (
 	"io"
 	"net"
 	"net/http"
+	"net/netip"
 	"net/url"
 	"strconv"
 	"time"
@@ -15,9 +16,12 @@ import (
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 
+	"github.com/cloudflare/cloudflared/config"
 	"github.com/cloudflare/cloudflared/hello"
 	"github.com/cloudflare/cloudflared/ipaccess"
 	"github.com/cloudflare/cloudflared/management"
+	"github.com/cloudflare/cloudflared/recording"
+	"github.com/cloudflare/cloudflared/redact"
 	"github.com/cloudflare/cloudflared/socks"
 	"github.com/cloudflare/cloudflared/tlsconfig"
 )
@@ -54,8 +58,8 @@ func (o *unixSocketPath) String() string {
 	return fmt.Sprintf("unix%s:%s", scheme, o.path)
 }
 
-func (o *unixSocketPath) start(log *zerolog.Logger, _ <-chan struct{}, cfg OriginRequestConfig) error {
-	transport, err := newHTTPTransport(o, cfg, log)
+func (o *unixSocketPath) start(log *zerolog.Logger, shutdownC <-chan struct{}, cfg OriginRequestConfig) error {
+	transport, err := newHTTPTransport(o, cfg, shutdownC, log)
 	if err != nil {
 		return err
 	}
@@ -71,17 +75,40 @@ type httpService struct {
 	url            *url.URL
 	hostHeader     string
 	transport      *http.Transport
+	http3          *http3OriginTransport
 	matchSNIToHost bool
 }
 
-func (o *httpService) start(log *zerolog.Logger, _ <-chan struct{}, cfg OriginRequestConfig) error {
-	transport, err := newHTTPTransport(o, cfg, log)
+func (o *httpService) start(log *zerolog.Logger, shutdownC <-chan struct{}, cfg OriginRequestConfig) error {
+	transport, err := newHTTPTransport(o, cfg, shutdownC, log)
 	if err != nil {
 		return err
 	}
 	o.hostHeader = cfg.HTTPHostHeader
 	o.transport = transport
 	o.matchSNIToHost = cfg.MatchSNIToHost
+	if cfg.Http3Origin {
+		switch {
+		case o.url.Scheme != "https":
+			log.Warn().Msgf("%s is ignored for %s, HTTP/3 is only used with https origins", Http3OriginFlag, o)
+		case cfg.MatchSNIToHost:
+			log.Warn().Msgf("%s is ignored for %s, it can't be used along with %s", Http3OriginFlag, o, MatchSNIToHostFlag)
+		default:
+			o.http3 = newHTTP3OriginTransport(transport.TLSClientConfig, cfg, transport, shutdownC, log)
+		}
+	}
+	if cfg.Warmup != nil {
+		if cfg.MatchSNIToHost {
+			// The server name, and so the connection, depends on the host of each request
+			log.Warn().Msgf("warmup is ignored for %s, it can't be used along with %s", o, MatchSNIToHostFlag)
+			return nil
+		}
+		warmer, err := newOriginWarmer(o, cfg, log)
+		if err != nil {
+			return err
+		}
+		go warmer.run(shutdownC)
+	}
 	return nil
 }
 
@@ -122,6 +149,14 @@ type tcpOverWSService struct {
 	isBastion     bool
 	streamHandler streamHandlerFunc
 	dialer        net.Dialer
+	// recorder records the sessions when configured, nil otherwise
+	recorder *recording.Recorder
+	// latencyMode optimizes the connections for interactive protocols
+	latencyMode bool
+	// proxyProtocol sends a PROXY protocol v2 header to the origin
+	proxyProtocol bool
+	// resolver resolves the hostname of dest as configured for the rule
+	resolver *originResolver
 }
 
 type socksProxyOverWSService struct {
@@ -136,6 +171,8 @@ func newTCPOverWSService(url *url.URL) *tcpOverWSService {
 		addPortIfMissing(url, 3389)
 	case "smb":
 		addPortIfMissing(url, 445)
+	case "vnc":
+		addPortIfMissing(url, 5900)
 	case "tcp":
 		addPortIfMissing(url, 7864) // just a random port since there isn't a default in this case
 	}
@@ -151,16 +188,110 @@ func newBastionService() *tcpOverWSService {
 	}
 }
 
-func newSocksProxyOverWSService(accessPolicy *ipaccess.Policy) *socksProxyOverWSService {
+func newSocksProxyOverWSService(accessPolicy *ipaccess.Policy, socksPolicy *socks.Policy) *socksProxyOverWSService {
 	proxy := socksProxyOverWSService{
 		conn: &socksProxyOverWSConnection{
 			accessPolicy: accessPolicy,
+			socksPolicy:  socksPolicy,
 		},
 	}
 
 	return &proxy
 }
 
+// newSocksPolicy parses the socks configuration of a socks-proxy rule, returns nil if there is none.
+func newSocksPolicy(cfg *config.SocksConfig) (*socks.Policy, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	policyConfig := socks.PolicyConfig{
+		Users:                 make([]socks.User, 0, len(cfg.Users)),
+		ClientNetworks:        make([]netip.Prefix, 0, len(cfg.ClientCIDRs)),
+		MaxConnections:        cfg.MaxConnections,
+		MaxConnectionsPerUser: cfg.MaxConnectionsPerUser,
+		BandwidthLimit:        cfg.BandwidthLimit,
+	}
+	if cfg.MaxConnections < 0 || cfg.MaxConnectionsPerUser < 0 || cfg.BandwidthLimit < 0 {
+		return nil, fmt.Errorf("maxConnections, maxConnectionsPerUser and bandwidthLimit can't be negative")
+	}
+	seen := make(map[string]bool, len(cfg.Users))
+	for _, u := range cfg.Users {
+		if u.Username == "" || u.Password == "" {
+			return nil, fmt.Errorf("users need a username and a password")
+		}
+		// Usernames and passwords are sent with a single byte length
+		if len(u.Username) > 255 || len(u.Password) > 255 {
+			return nil, fmt.Errorf("username and password of user %s can't be longer than 255 bytes", u.Username)
+		}
+		if seen[u.Username] {
+			return nil, fmt.Errorf("user %s is configured twice", u.Username)
+		}
+		seen[u.Username] = true
+		redact.AddSecret(u.Password)
+		user := socks.User{Name: u.Username, Password: u.Password}
+		if len(u.IPRules) > 0 {
+			rules := make([]ipaccess.Rule, len(u.IPRules))
+			for i, ipRule := range u.IPRules {
+				rule, err := ipaccess.NewRuleByCIDR(ipRule.Prefix, ipRule.Ports, ipRule.Allow)
+				if err != nil {
+					return nil, fmt.Errorf("unable to create ip rule for user %s: %s", u.Username, err)
+				}
+				rules[i] = rule
+			}
+			accessPolicy, err := ipaccess.NewPolicy(false, rules)
+			if err != nil {
+				return nil, fmt.Errorf("unable to create ip access policy for user %s: %s", u.Username, err)
+			}
+			user.AccessPolicy = accessPolicy
+		}
+		policyConfig.Users = append(policyConfig.Users, user)
+	}
+	for _, cidr := range cfg.ClientCIDRs {
+		network, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse client cidr: %s", cidr)
+		}
+		policyConfig.ClientNetworks = append(policyConfig.ClientNetworks, network.Masked())
+	}
+	return socks.NewPolicy(policyConfig), nil
+}
+
+// newSessionRecorder creates the recorder of the sessions of a bastion or TCP rule, returns nil if there is no
+// session recording configured.
+func newSessionRecorder(cfg *config.SessionRecordingConfig, log *zerolog.Logger) (*recording.Recorder, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	recordingConfig := recording.Config{
+		Dir:         cfg.Dir,
+		Format:      cfg.Format,
+		MaxSize:     cfg.MaxSize,
+		MaxDuration: cfg.MaxDuration.Duration,
+	}
+	if s3 := cfg.S3; s3 != nil {
+		if s3.SecretAccessKey != "" {
+			redact.AddSecret(s3.SecretAccessKey)
+		}
+		if s3.SessionToken != "" {
+			redact.AddSecret(s3.SessionToken)
+		}
+		recordingConfig.S3 = &recording.S3Config{
+			Endpoint:        s3.Endpoint,
+			Region:          s3.Region,
+			Bucket:          s3.Bucket,
+			Prefix:          s3.Prefix,
+			AccessKeyID:     s3.AccessKeyID,
+			SecretAccessKey: s3.SecretAccessKey,
+			SessionToken:    s3.SessionToken,
+		}
+	}
+	recorder, err := recording.New(recordingConfig, log)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid sessionRecording")
+	}
+	return recorder, nil
+}
+
 func addPortIfMissing(uri *url.URL, port int) {
 	hostname := uri.Hostname()
 
@@ -184,11 +315,25 @@ func (o *tcpOverWSService) String() string {
 func (o *tcpOverWSService) start(log *zerolog.Logger, _ <-chan struct{}, cfg OriginRequestConfig) error {
 	if cfg.ProxyType == socksProxy {
 		o.streamHandler = socks.StreamHandler
+	} else if cfg.LatencyMode {
+		o.streamHandler = InteractiveStreamHandler
 	} else {
 		o.streamHandler = DefaultStreamHandler
 	}
+	o.latencyMode = cfg.LatencyMode
+	o.proxyProtocol = cfg.ProxyProtocol
 	o.dialer.Timeout = cfg.ConnectTimeout.Duration
 	o.dialer.KeepAlive = cfg.TCPKeepAlive.Duration
+	resolver, err := newOriginResolver(cfg.DNS)
+	if err != nil {
+		return err
+	}
+	o.resolver = resolver
+	recorder, err := newSessionRecorder(cfg.SessionRecording, log)
+	if err != nil {
+		return err
+	}
+	o.recorder = recorder
 	return nil
 }
 
@@ -233,8 +378,21 @@ func (o *helloWorld) start(
 	if err != nil {
 		return errors.Wrap(err, "Cannot start Hello World Server")
 	}
-	go hello.StartHelloWorldServer(log, helloListener, shutdownC)
 	o.server = helloListener
+	// The server restarts on the same address if it crashes, so that the URL of the rule stays valid
+	address := helloListener.Addr().String()
+	go newHelper(HelloWorldService, address, log).supervise(shutdownC, func() error {
+		listener := helloListener
+		if listener == nil {
+			var err error
+			if listener, err = hello.CreateTLSListener(address); err != nil {
+				return errors.Wrap(err, "Cannot restart Hello World Server")
+			}
+		}
+		// Serving closes the listener once it returns
+		helloListener = nil
+		return hello.StartHelloWorldServer(log, listener, shutdownC)
+	})
 
 	o.httpService.url = &url.URL{
 		Scheme: "https",
@@ -343,25 +501,29 @@ func (nrc *NopReadCloser) Close() error {
 	return nil
 }
 
-func newHTTPTransport(service OriginService, cfg OriginRequestConfig, log *zerolog.Logger) (*http.Transport, error) {
-	originCertPool, err := tlsconfig.LoadOriginCA(cfg.CAPool, log)
+func newHTTPTransport(service OriginService, cfg OriginRequestConfig, shutdownC <-chan struct{}, log *zerolog.Logger) (*http.Transport, error) {
+	tlsConfig, err := originTLSConfig(service, cfg, shutdownC, log)
 	if err != nil {
-		return nil, errors.Wrap(err, "Error loading cert pool")
+		return nil, err
 	}
 
 	httpTransport := http.Transport{
 		Proxy:                 http.ProxyFromEnvironment,
-		MaxIdleConns:          cfg.KeepAliveConnections,
-		MaxIdleConnsPerHost:   cfg.KeepAliveConnections,
+		MaxIdleConns:          warmupConnections(cfg.Warmup, cfg.KeepAliveConnections),
+		MaxIdleConnsPerHost:   warmupConnections(cfg.Warmup, cfg.KeepAliveConnections),
 		IdleConnTimeout:       cfg.KeepAliveTimeout.Duration,
 		TLSHandshakeTimeout:   cfg.TLSTimeout.Duration,
+		ResponseHeaderTimeout: cfg.ResponseHeaderTimeout.Duration,
 		ExpectContinueTimeout: 1 * time.Second,
-		TLSClientConfig:       &tls.Config{RootCAs: originCertPool, InsecureSkipVerify: cfg.NoTLSVerify},
+		TLSClientConfig:       tlsConfig,
 		ForceAttemptHTTP2:     cfg.Http2Origin,
 	}
 	if _, isHelloWorld := service.(*helloWorld); !isHelloWorld && cfg.OriginServerName != "" {
 		httpTransport.TLSClientConfig.ServerName = cfg.OriginServerName
 	}
+	if cfg.NoTLSVerify || cfg.OriginCertFingerprint != "" {
+		observeOriginCert(httpTransport.TLSClientConfig, service.String(), log)
+	}
 
 	dialer := &net.Dialer{
 		Timeout:   cfg.ConnectTimeout.Duration,
@@ -370,9 +532,15 @@ func newHTTPTransport(service OriginService, cfg OriginRequestConfig, log *zerol
 	if cfg.NoHappyEyeballs {
 		dialer.FallbackDelay = -1 // As of Golang 1.12, a negative delay disables "happy eyeballs"
 	}
+	resolver, err := newOriginResolver(cfg.DNS)
+	if err != nil {
+		return nil, err
+	}
 
 	// DialContext depends on which kind of origin is being used.
-	dialContext := dialer.DialContext
+	dialContext := func(ctx context.Context, network, address string) (net.Conn, error) {
+		return resolver.dial(ctx, dialer, network, address)
+	}
 	switch service := service.(type) {
 
 	// If this origin is a unix socket, enforce network type "unix".
@@ -389,6 +557,47 @@ func newHTTPTransport(service OriginService, cfg OriginRequestConfig, log *zerol
 	return &httpTransport, nil
 }
 
+// originTLSConfig returns the TLS config to reach the origin with. When caPool is a directory, its certificates are
+// re-scanned until shutdownC is closed and verified at each handshake, so that rotated CAs are picked up.
+// The TLS versions, cipher suites and pinned certificate configured for the origin are then applied.
+func originTLSConfig(service OriginService, cfg OriginRequestConfig, shutdownC <-chan struct{}, log *zerolog.Logger) (*tls.Config, error) {
+	tlsConfig, err := originTLSVerification(service, cfg, shutdownC, log)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyOriginTLSPolicy(tlsConfig, cfg); err != nil {
+		return nil, err
+	}
+	return tlsConfig, nil
+}
+
+func originTLSVerification(service OriginService, cfg OriginRequestConfig, shutdownC <-chan struct{}, log *zerolog.Logger) (*tls.Config, error) {
+	if cfg.NoTLSVerify || cfg.OriginCertFingerprint != "" || !tlsconfig.IsOriginCAPoolDir(cfg.CAPool) {
+		originCertPool, err := tlsconfig.LoadOriginCA(cfg.CAPool, log)
+		if err != nil {
+			return nil, errors.Wrap(err, "Error loading cert pool")
+		}
+		return &tls.Config{RootCAs: originCertPool, InsecureSkipVerify: cfg.NoTLSVerify}, nil // nolint: gosec
+	}
+
+	caPool, err := tlsconfig.NewOriginCAPool(cfg.CAPool, log)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error loading cert pool")
+	}
+	go caPool.Run(tlsconfig.OriginCAPoolRescanInterval, shutdownC)
+
+	serverName := cfg.OriginServerName
+	if httpService, ok := service.(*httpService); ok && serverName == "" {
+		serverName = httpService.url.Hostname()
+	}
+	return &tls.Config{
+		RootCAs: caPool.Pool(),
+		// The certificate is verified by VerifyConnection against the CAs of the directory at the time of the handshake
+		InsecureSkipVerify: true, // nolint: gosec
+		VerifyConnection:   caPool.VerifyConnection(serverName),
+	}, nil
+}
+
 // MockOriginHTTPService should only be used by other packages to mock OriginService. Set Transport to configure desired RoundTripper behavior.
 type MockOriginHTTPService struct {
 	Transport http.RoundTripper
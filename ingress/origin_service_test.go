@@ -2,9 +2,15 @@ package ingress
 
 import (
 	"net/url"
+	"reflect"
 	"testing"
+	"time"
 
+	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/socks"
 )
 
 func TestAddPortIfMissing(t *testing.T) {
@@ -27,3 +33,74 @@ func TestAddPortIfMissing(t *testing.T) {
 		})
 	}
 }
+
+func TestNewSocksPolicy(t *testing.T) {
+	policy, err := newSocksPolicy(nil)
+	require.NoError(t, err)
+	require.Nil(t, policy)
+
+	prefix := "10.0.0.0/8"
+	policy, err = newSocksPolicy(&config.SocksConfig{
+		Users: []config.SocksUser{
+			{Username: "alice", Password: "alice-password", IPRules: []config.IngressIPRule{{Prefix: &prefix, Allow: true}}},
+			{Username: "bob", Password: "bob-password"},
+		},
+		ClientCIDRs:    []string{"192.0.2.0/24", "2001:db8::/32"},
+		MaxConnections: 10,
+		BandwidthLimit: 1 << 20,
+	})
+	require.NoError(t, err)
+	require.NotNil(t, policy)
+
+	invalid := []*config.SocksConfig{
+		{Users: []config.SocksUser{{Username: "alice"}}},
+		{Users: []config.SocksUser{{Username: "alice", Password: "a"}, {Username: "alice", Password: "b"}}},
+		{Users: []config.SocksUser{{Username: "alice", Password: "a", IPRules: []config.IngressIPRule{{Allow: true}}}}},
+		{ClientCIDRs: []string{"192.0.2.1"}},
+		{MaxConnections: -1},
+	}
+	for _, cfg := range invalid {
+		_, err := newSocksPolicy(cfg)
+		require.Error(t, err)
+	}
+}
+
+func TestNewSessionRecorder(t *testing.T) {
+	log := zerolog.Nop()
+	recorder, err := newSessionRecorder(nil, &log)
+	require.NoError(t, err)
+	require.Nil(t, recorder)
+
+	recorder, err = newSessionRecorder(&config.SessionRecordingConfig{
+		Dir:         t.TempDir(),
+		Format:      "asciicast",
+		MaxDuration: config.CustomDuration{Duration: time.Hour},
+	}, &log)
+	require.NoError(t, err)
+	require.NotNil(t, recorder)
+
+	_, err = newSessionRecorder(&config.SessionRecordingConfig{Dir: t.TempDir(), Format: "mp4"}, &log)
+	require.Error(t, err)
+}
+
+func TestTCPOverWSServiceLatencyMode(t *testing.T) {
+	log := zerolog.Nop()
+	handlerOf := func(service *tcpOverWSService) uintptr {
+		return reflect.ValueOf(service.streamHandler).Pointer()
+	}
+
+	service := newTCPOverWSService(MustParseURL(t, "vnc://127.0.0.1"))
+	require.Equal(t, "127.0.0.1:5900", service.dest)
+	require.NoError(t, service.start(&log, nil, OriginRequestConfig{}))
+	require.False(t, service.latencyMode)
+	require.Equal(t, reflect.ValueOf(DefaultStreamHandler).Pointer(), handlerOf(service))
+
+	service = newTCPOverWSService(MustParseURL(t, "rdp://127.0.0.1"))
+	require.NoError(t, service.start(&log, nil, OriginRequestConfig{LatencyMode: true}))
+	require.True(t, service.latencyMode)
+	require.Equal(t, reflect.ValueOf(InteractiveStreamHandler).Pointer(), handlerOf(service))
+
+	service = newTCPOverWSService(MustParseURL(t, "tcp://127.0.0.1"))
+	require.NoError(t, service.start(&log, nil, OriginRequestConfig{LatencyMode: true, ProxyType: socksProxy}))
+	require.Equal(t, reflect.ValueOf(socks.StreamHandler).Pointer(), handlerOf(service))
+}
@@ -0,0 +1,195 @@
+package ingress
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+)
+
+var originCertChanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "origin",
+	Name:      "cert_changes_total",
+	Help:      "Count of changes of the certificates presented by origins that are pinned or not verified, by whether the new certificate is trusted",
+}, []string{"origin", "trusted"})
+
+func init() {
+	prometheus.MustRegister(originCertChanges)
+}
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// applyOriginTLSPolicy restricts the TLS versions and cipher suites of tlsConfig as configured for the origin, and
+// replaces the verification of the origin certificate with its fingerprint when one is pinned. Configured cipher
+// suites cap the maximum version at TLS 1.2 when none is set, they would be ignored by TLS 1.3 connections otherwise.
+func applyOriginTLSPolicy(tlsConfig *tls.Config, cfg OriginRequestConfig) error {
+	minVersion, err := parseTLSVersion(cfg.TLSMinVersion)
+	if err != nil {
+		return err
+	}
+	maxVersion, err := parseTLSVersion(cfg.TLSMaxVersion)
+	if err != nil {
+		return err
+	}
+	if minVersion != 0 && maxVersion != 0 && minVersion > maxVersion {
+		return fmt.Errorf("tlsMinVersion %s is higher than tlsMaxVersion %s", cfg.TLSMinVersion, cfg.TLSMaxVersion)
+	}
+	cipherSuites, err := parseCipherSuites(cfg.TLSCipherSuites)
+	if err != nil {
+		return err
+	}
+	if len(cipherSuites) > 0 {
+		// Go always enables every TLS 1.3 cipher suite, the configured ones only restrict older versions
+		if minVersion == tls.VersionTLS13 {
+			return fmt.Errorf("tlsCipherSuites don't apply to TLS 1.3, they can't be set along with tlsMinVersion 1.3")
+		}
+		if maxVersion == 0 {
+			maxVersion = tls.VersionTLS12
+		}
+	}
+	tlsConfig.MinVersion = minVersion
+	tlsConfig.MaxVersion = maxVersion
+	tlsConfig.CipherSuites = cipherSuites
+
+	if cfg.OriginCertFingerprint == "" {
+		return nil
+	}
+	fingerprints, err := parseCertFingerprints(cfg.OriginCertFingerprint)
+	if err != nil {
+		return err
+	}
+	// The pinned certificates are trusted whoever issued them, so the verification against CAs is skipped
+	tlsConfig.InsecureSkipVerify = true // nolint: gosec
+	tlsConfig.VerifyConnection = verifyCertFingerprint(fingerprints)
+	return nil
+}
+
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	if v, ok := tlsVersions[version]; ok {
+		return v, nil
+	}
+	return 0, fmt.Errorf("invalid TLS version %q, must be one of 1.0, 1.1, 1.2 or 1.3", version)
+}
+
+func parseCipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	supported := make(map[string]*tls.CipherSuite)
+	for _, suite := range tls.CipherSuites() {
+		supported[suite.Name] = suite
+	}
+	ids := make([]uint16, 0, len(names))
+	for _, name := range names {
+		suite, ok := supported[name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported or insecure TLS cipher suite %q", name)
+		}
+		if len(suite.SupportedVersions) == 1 && suite.SupportedVersions[0] == tls.VersionTLS13 {
+			return nil, fmt.Errorf("TLS 1.3 cipher suite %q isn't configurable, Go always enables every TLS 1.3 cipher suite", name)
+		}
+		ids = append(ids, suite.ID)
+	}
+	return ids, nil
+}
+
+// parseCertFingerprints parses comma separated hex encoded SHA-256 fingerprints, which may be separated by colons as
+// printed by `openssl x509 -fingerprint -sha256`. Pinning both the current and the next certificate of an origin lets
+// it rotate its certificate without failing requests.
+func parseCertFingerprints(fingerprints string) ([][]byte, error) {
+	var parsed [][]byte
+	for _, fingerprint := range strings.Split(fingerprints, ",") {
+		fingerprint = strings.TrimSpace(fingerprint)
+		decoded, err := hex.DecodeString(strings.ReplaceAll(fingerprint, ":", ""))
+		if err != nil || len(decoded) != sha256.Size {
+			return nil, fmt.Errorf("invalid originCertFingerprint %q, must be a hex encoded SHA-256 fingerprint", fingerprint)
+		}
+		parsed = append(parsed, decoded)
+	}
+	return parsed, nil
+}
+
+func verifyCertFingerprint(fingerprints [][]byte) func(tls.ConnectionState) error {
+	return func(state tls.ConnectionState) error {
+		if len(state.PeerCertificates) == 0 {
+			return fmt.Errorf("origin presented no certificate")
+		}
+		sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+		for _, fingerprint := range fingerprints {
+			if bytes.Equal(sum[:], fingerprint) {
+				return nil
+			}
+		}
+		return fmt.Errorf("origin certificate fingerprint %X doesn't match originCertFingerprint", sum)
+	}
+}
+
+// originCertObserver reports when the certificate an origin presents changes. It is meant for origins whose
+// certificate is pinned or not verified, where a new certificate is either a rotation that breaks the pin or goes
+// unnoticed. Handshakes are verified on their own, so a failed one doesn't fail the connections already established
+// and the next dial verifies the certificate again.
+type originCertObserver struct {
+	origin string
+	log    *zerolog.Logger
+
+	lock sync.Mutex
+	// last is the fingerprint of the certificate of the last handshake, nil before the first one
+	last []byte
+}
+
+// observeOriginCert wraps the verification of tlsConfig to report the changes of the certificate of origin.
+func observeOriginCert(tlsConfig *tls.Config, origin string, log *zerolog.Logger) *originCertObserver {
+	observer := &originCertObserver{origin: origin, log: log}
+	verify := tlsConfig.VerifyConnection
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		var err error
+		if verify != nil {
+			err = verify(state)
+		}
+		observer.observe(state, err)
+		return err
+	}
+	return observer
+}
+
+func (o *originCertObserver) observe(state tls.ConnectionState, verifyErr error) {
+	if len(state.PeerCertificates) == 0 {
+		return
+	}
+	sum := sha256.Sum256(state.PeerCertificates[0].Raw)
+	o.lock.Lock()
+	previous := o.last
+	o.last = sum[:]
+	o.lock.Unlock()
+	if previous == nil || bytes.Equal(previous, sum[:]) {
+		return
+	}
+
+	trusted := verifyErr == nil
+	originCertChanges.WithLabelValues(o.origin, strconv.FormatBool(trusted)).Inc()
+	event := o.log.Warn()
+	if !trusted {
+		event = o.log.Error().Err(verifyErr)
+	}
+	event.Str("origin", o.origin).
+		Str("previousFingerprint", fmt.Sprintf("%X", previous)).
+		Str("fingerprint", fmt.Sprintf("%X", sum)).
+		Str("subject", state.PeerCertificates[0].Subject.String()).
+		Msg("Origin certificate changed")
+}
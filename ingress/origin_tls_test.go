@@ -0,0 +1,198 @@
+package ingress
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOriginCertFingerprint(t *testing.T) {
+	origin := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer origin.Close()
+	sum := sha256.Sum256(origin.Certificate().Raw)
+
+	tests := []struct {
+		name        string
+		fingerprint string
+		wantErr     bool
+	}{
+		{
+			name:        "hex",
+			fingerprint: fmt.Sprintf("%x", sum),
+		},
+		{
+			name:        "colon separated",
+			fingerprint: strings.TrimSuffix(fmt.Sprintf("% X", sum), " "),
+		},
+		{
+			name:        "next certificate pinned along",
+			fingerprint: fmt.Sprintf("%x, %x", sha256.Sum256([]byte("next certificate")), sum),
+		},
+		{
+			name:        "mismatch",
+			fingerprint: fmt.Sprintf("%x", sha256.Sum256([]byte("another certificate"))),
+			wantErr:     true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fingerprint := strings.ReplaceAll(test.fingerprint, " ", ":")
+			resp, err := roundTripWithTLSPolicy(t, origin.URL, OriginRequestConfig{OriginCertFingerprint: fingerprint})
+			if test.wantErr {
+				require.ErrorContains(t, err, "doesn't match originCertFingerprint")
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, http.StatusNoContent, resp.StatusCode)
+		})
+	}
+}
+
+func TestOriginCertObserver(t *testing.T) {
+	var logs bytes.Buffer
+	log := zerolog.New(&logs)
+	tlsConfig := &tls.Config{}
+	rotated := &x509.Certificate{Raw: []byte("rotated certificate")}
+	tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+		if state.PeerCertificates[0] == rotated {
+			return fmt.Errorf("untrusted")
+		}
+		return nil
+	}
+	observeOriginCert(tlsConfig, "https://observer.internal", &log)
+	changes := func(trusted string) float64 {
+		var m dto.Metric
+		require.NoError(t, originCertChanges.WithLabelValues("https://observer.internal", trusted).Write(&m))
+		return m.GetCounter().GetValue()
+	}
+	handshake := func(cert *x509.Certificate) error {
+		return tlsConfig.VerifyConnection(tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}})
+	}
+
+	current := &x509.Certificate{Raw: []byte("current certificate")}
+	require.NoError(t, handshake(current))
+	require.NoError(t, handshake(current))
+	require.Empty(t, logs.String())
+
+	require.Error(t, handshake(rotated))
+	require.Equal(t, float64(1), changes("false"))
+	require.Contains(t, logs.String(), "Origin certificate changed")
+
+	// The next handshake is verified again
+	require.NoError(t, handshake(current))
+	require.Equal(t, float64(1), changes("true"))
+}
+
+func TestOriginTLSVersions(t *testing.T) {
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	origin.TLS = &tls.Config{MaxVersion: tls.VersionTLS12}
+	origin.StartTLS()
+	defer origin.Close()
+
+	_, err := roundTripWithTLSPolicy(t, origin.URL, OriginRequestConfig{NoTLSVerify: true, TLSMinVersion: "1.3"})
+	require.Error(t, err)
+
+	resp, err := roundTripWithTLSPolicy(t, origin.URL, OriginRequestConfig{
+		NoTLSVerify:     true,
+		TLSMaxVersion:   "1.2",
+		TLSCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint16(tls.VersionTLS12), resp.TLS.Version)
+	require.Equal(t, tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, resp.TLS.CipherSuite)
+}
+
+func TestOriginTLSCipherSuitesCapVersion(t *testing.T) {
+	tlsConfig := &tls.Config{}
+	require.NoError(t, applyOriginTLSPolicy(tlsConfig, OriginRequestConfig{
+		TLSCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+	}))
+	require.Equal(t, uint16(tls.VersionTLS12), tlsConfig.MaxVersion)
+
+	// An explicit maximum version is kept, the cipher suites only apply to connections up to TLS 1.2
+	tlsConfig = &tls.Config{}
+	require.NoError(t, applyOriginTLSPolicy(tlsConfig, OriginRequestConfig{
+		TLSMaxVersion:   "1.3",
+		TLSCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+	}))
+	require.Equal(t, uint16(tls.VersionTLS13), tlsConfig.MaxVersion)
+}
+
+func TestInvalidOriginTLSPolicy(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     OriginRequestConfig
+		wantErr string
+	}{
+		{
+			name:    "unknown version",
+			cfg:     OriginRequestConfig{TLSMinVersion: "1.4"},
+			wantErr: "invalid TLS version",
+		},
+		{
+			name:    "min above max",
+			cfg:     OriginRequestConfig{TLSMinVersion: "1.3", TLSMaxVersion: "1.2"},
+			wantErr: "is higher than tlsMaxVersion",
+		},
+		{
+			name:    "insecure cipher suite",
+			cfg:     OriginRequestConfig{TLSCipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}},
+			wantErr: "unsupported or insecure TLS cipher suite",
+		},
+		{
+			name:    "tls 1.3 cipher suite",
+			cfg:     OriginRequestConfig{TLSCipherSuites: []string{"TLS_AES_128_GCM_SHA256"}},
+			wantErr: "isn't configurable",
+		},
+		{
+			name: "cipher suites with tls 1.3 only",
+			cfg: OriginRequestConfig{
+				TLSMinVersion:   "1.3",
+				TLSCipherSuites: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"},
+			},
+			wantErr: "don't apply to TLS 1.3",
+		},
+		{
+			name:    "short fingerprint",
+			cfg:     OriginRequestConfig{OriginCertFingerprint: "AB:CD"},
+			wantErr: "invalid originCertFingerprint",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			require.ErrorContains(t, applyOriginTLSPolicy(&tls.Config{}, test.cfg), test.wantErr)
+		})
+	}
+}
+
+func roundTripWithTLSPolicy(t *testing.T, originURL string, cfg OriginRequestConfig) (*http.Response, error) {
+	u, err := url.Parse(originURL)
+	require.NoError(t, err)
+	service := &httpService{url: u}
+	shutdownC := make(chan struct{})
+	t.Cleanup(func() { close(shutdownC) })
+	require.NoError(t, service.start(TestLogger, shutdownC, cfg))
+
+	req, err := http.NewRequest(http.MethodGet, originURL, nil)
+	require.NoError(t, err)
+	resp, err := service.RoundTrip(req)
+	if err == nil {
+		_ = resp.Body.Close()
+	}
+	return resp, err
+}
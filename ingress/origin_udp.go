@@ -0,0 +1,188 @@
+package ingress
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/websocket"
+)
+
+const (
+	defaultUDPIdleTimeout = 60 * time.Second
+	maxUDPDatagramSize    = 0xFFFF
+)
+
+var (
+	udpIngressSessions = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "udp_ingress",
+		Name:      "active_sessions",
+		Help:      "Count of active sessions to udp:// services",
+	})
+	udpIngressDatagrams = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "udp_ingress",
+		Name:      "total_datagrams",
+		Help:      "Total count of datagrams proxied to and from udp:// services, by direction",
+	}, []string{"direction"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		udpIngressSessions,
+		udpIngressDatagrams,
+	)
+}
+
+var errUDPSessionIdle = errors.New("udp session idle")
+
+// udpOverWSService models UDP origins, e.g. game servers or DNS services, serving eyeballs connecting over
+// websocket. Every websocket is a session of its own, with its own socket to the origin, and every binary message
+// carries a datagram in either direction.
+type udpOverWSService struct {
+	dest        string
+	dialer      net.Dialer
+	idleTimeout time.Duration
+	resolver    *originResolver
+}
+
+func newUDPOverWSService(url *url.URL) (*udpOverWSService, error) {
+	if url.Port() == "" {
+		return nil, fmt.Errorf("%s is an invalid address, udp services must have a port", url)
+	}
+	return &udpOverWSService{
+		dest: url.Host,
+	}, nil
+}
+
+func (o *udpOverWSService) String() string {
+	return fmt.Sprintf("udp://%s", o.dest)
+}
+
+func (o *udpOverWSService) start(_ *zerolog.Logger, _ <-chan struct{}, cfg OriginRequestConfig) error {
+	o.dialer.Timeout = cfg.ConnectTimeout.Duration
+	o.idleTimeout = cfg.UDPIdleTimeout.Duration
+	if o.idleTimeout == 0 {
+		o.idleTimeout = defaultUDPIdleTimeout
+	}
+	resolver, err := newOriginResolver(cfg.DNS)
+	if err != nil {
+		return err
+	}
+	o.resolver = resolver
+	return nil
+}
+
+func (o udpOverWSService) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.String())
+}
+
+func (o *udpOverWSService) EstablishConnection(ctx context.Context, _ string, _ *zerolog.Logger) (OriginConnection, error) {
+	conn, err := o.resolver.dial(ctx, &o.dialer, "udp", o.dest)
+	if err != nil {
+		return nil, err
+	}
+	return &udpOverWSConnection{
+		conn:        conn,
+		idleTimeout: o.idleTimeout,
+	}, nil
+}
+
+// udpOverWSConnection is an OriginConnection that streams the datagrams of a UDP session over WS.
+type udpOverWSConnection struct {
+	conn        net.Conn
+	idleTimeout time.Duration
+	// lastActivity is the unix nano time of the last datagram in either direction
+	lastActivity atomic.Int64
+}
+
+func (uc *udpOverWSConnection) Stream(ctx context.Context, tunnelConn io.ReadWriter, log *zerolog.Logger) {
+	udpIngressSessions.Inc()
+	defer udpIngressSessions.Dec()
+
+	wsCtx, cancel := context.WithCancel(ctx)
+	wsConn := websocket.NewConn(wsCtx, tunnelConn, log)
+	uc.markActive()
+
+	errC := make(chan error, 2)
+	go func() {
+		errC <- uc.toOrigin(wsConn)
+	}()
+	go func() {
+		errC <- uc.fromOrigin(wsConn)
+	}()
+	if err := <-errC; err != nil && err != io.EOF {
+		log.Debug().Err(err).Msgf("udp session to %s ended", uc.conn.RemoteAddr())
+	}
+
+	// Unblocks reading from the origin, the tunnel stream is closed by the caller
+	uc.conn.Close()
+	cancel()
+	// Makes sure wsConn stops sending ping before terminating the stream
+	wsConn.Close()
+}
+
+func (uc *udpOverWSConnection) Close() error {
+	return uc.conn.Close()
+}
+
+func (uc *udpOverWSConnection) markActive() {
+	uc.lastActivity.Store(time.Now().UnixNano())
+}
+
+func (uc *udpOverWSConnection) idleSince() time.Duration {
+	return time.Since(time.Unix(0, uc.lastActivity.Load()))
+}
+
+// toOrigin reads the datagrams of the eyeball and sends them to the origin.
+func (uc *udpOverWSConnection) toOrigin(eyeball io.Reader) error {
+	datagram := make([]byte, maxUDPDatagramSize)
+	for {
+		n, err := eyeball.Read(datagram)
+		if err != nil {
+			return err
+		}
+		uc.markActive()
+		if _, err := uc.conn.Write(datagram[:n]); err != nil {
+			return err
+		}
+		udpIngressDatagrams.WithLabelValues("to_origin").Inc()
+	}
+}
+
+// fromOrigin reads the datagrams of the origin and sends them to the eyeball, until the session is idle.
+func (uc *udpOverWSConnection) fromOrigin(eyeball io.Writer) error {
+	datagram := make([]byte, maxUDPDatagramSize)
+	for {
+		if err := uc.conn.SetReadDeadline(time.Now().Add(uc.idleTimeout)); err != nil {
+			return err
+		}
+		n, err := uc.conn.Read(datagram)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				// Datagrams from the eyeball keep the session alive too
+				if uc.idleSince() >= uc.idleTimeout {
+					return errUDPSessionIdle
+				}
+				continue
+			}
+			return err
+		}
+		uc.markActive()
+		if _, err := eyeball.Write(datagram[:n]); err != nil {
+			return err
+		}
+		udpIngressDatagrams.WithLabelValues("from_origin").Inc()
+	}
+}
@@ -0,0 +1,71 @@
+package ingress
+
+import (
+	"context"
+	"net"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gobwas/ws/wsutil"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+func TestNewUDPOverWSServiceRequiresPort(t *testing.T) {
+	_, err := newUDPOverWSService(&url.URL{Scheme: "udp", Host: "127.0.0.1"})
+	require.Error(t, err)
+
+	service, err := newUDPOverWSService(&url.URL{Scheme: "udp", Host: "127.0.0.1:53"})
+	require.NoError(t, err)
+	require.Equal(t, "udp://127.0.0.1:53", service.String())
+}
+
+func TestUDPOverWSConnectionStream(t *testing.T) {
+	origin, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer origin.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := origin.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			_, _ = origin.WriteTo(append([]byte("echo-"), buf[:n]...), addr)
+		}
+	}()
+
+	log := zerolog.Nop()
+	service, err := newUDPOverWSService(&url.URL{Scheme: "udp", Host: origin.LocalAddr().String()})
+	require.NoError(t, err)
+	require.NoError(t, service.start(&log, nil, OriginRequestConfig{
+		UDPIdleTimeout: config.CustomDuration{Duration: 100 * time.Millisecond},
+	}))
+	originConn, err := service.EstablishConnection(context.Background(), service.String(), &log)
+	require.NoError(t, err)
+	defer originConn.Close()
+
+	eyeballConn, edgeConn := net.Pipe()
+	defer eyeballConn.Close()
+	done := make(chan struct{})
+	go func() {
+		originConn.Stream(context.Background(), edgeConn, &log)
+		close(done)
+	}()
+
+	for _, datagram := range []string{"ping", "pong"} {
+		require.NoError(t, wsutil.WriteClientBinary(eyeballConn, []byte(datagram)))
+		reply, err := wsutil.ReadServerBinary(eyeballConn)
+		require.NoError(t, err)
+		require.Equal(t, "echo-"+datagram, string(reply))
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("idle udp session wasn't closed")
+	}
+}
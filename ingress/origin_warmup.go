@@ -0,0 +1,143 @@
+package ingress
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+const defaultWarmupPingInterval = 30 * time.Second
+
+// originWarmer keeps connections to an HTTP origin open in the pool of its transport. Every ping sends as many
+// concurrent HEAD requests as there are connections to keep, which reuse the idle connections, resetting their idle
+// timeout, and replace those the origin closed.
+type originWarmer struct {
+	transport   http.RoundTripper
+	url         *url.URL
+	hostHeader  string
+	connections int
+	interval    time.Duration
+	timeout     time.Duration
+	log         *zerolog.Logger
+}
+
+func newOriginWarmer(service *httpService, cfg OriginRequestConfig, log *zerolog.Logger) (*originWarmer, error) {
+	warmup := cfg.Warmup
+	if warmup.Connections <= 0 {
+		return nil, fmt.Errorf("warmup of %s requires a positive number of connections", service)
+	}
+	interval := defaultWarmupPingInterval
+	if warmup.PingInterval != nil {
+		if warmup.PingInterval.Duration <= 0 {
+			return nil, fmt.Errorf("warmup of %s requires a positive pingInterval", service)
+		}
+		interval = warmup.PingInterval.Duration
+	}
+	if interval >= cfg.KeepAliveTimeout.Duration {
+		log.Warn().Msgf("Connections to %s idle out between warmup pings, pingInterval should be shorter than keepAliveTimeout", service)
+	}
+	pingURL := *service.url
+	switch pingURL.Scheme {
+	case "ws":
+		pingURL.Scheme = "http"
+	case "wss":
+		pingURL.Scheme = "https"
+	}
+	pingURL.Path = warmup.PingPath
+	if pingURL.Path == "" {
+		pingURL.Path = "/"
+	}
+	return &originWarmer{
+		transport:   service.transport,
+		url:         &pingURL,
+		hostHeader:  service.hostHeader,
+		connections: warmup.Connections,
+		interval:    interval,
+		// A ping that outlasts the interval would overlap the next one
+		timeout: interval,
+		log:     log,
+	}, nil
+}
+
+// run pings the origin until shutdownC is closed.
+func (w *originWarmer) run(shutdownC <-chan struct{}) {
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		w.ping()
+		select {
+		case <-shutdownC:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ping sends a HEAD request over each warm connection and returns how many of them failed.
+func (w *originWarmer) ping() int {
+	ctx, cancel := context.WithTimeout(context.Background(), w.timeout)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		failedM  sync.Mutex
+		failed   int
+		firstErr error
+	)
+	for i := 0; i < w.connections; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := w.head(ctx); err != nil {
+				failedM.Lock()
+				defer failedM.Unlock()
+				if failed == 0 {
+					firstErr = err
+				}
+				failed++
+			}
+		}()
+	}
+	wg.Wait()
+	if failed > 0 {
+		w.log.Debug().Err(firstErr).Msgf("%d of %d warmup pings to %s failed", failed, w.connections, w.url)
+	}
+	return failed
+}
+
+func (w *originWarmer) head(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, w.url.String(), nil)
+	if err != nil {
+		return err
+	}
+	if w.hostHeader != "" {
+		req.Host = w.hostHeader
+	}
+	resp, err := w.transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	// The connection goes back to the pool once the body is read
+	_, _ = io.Copy(io.Discard, resp.Body)
+	_ = resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("origin answered %s", resp.Status)
+	}
+	return nil
+}
+
+// warmupConnections returns how many idle connections the transport of an HTTP origin keeps with cfg.
+func warmupConnections(cfg *config.OriginWarmupConfig, keepAliveConnections int) int {
+	if cfg != nil && cfg.Connections > keepAliveConnections {
+		return cfg.Connections
+	}
+	return keepAliveConnections
+}
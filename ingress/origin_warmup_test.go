@@ -0,0 +1,75 @@
+package ingress
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+func TestOriginWarmer(t *testing.T) {
+	var newConns atomic.Int32
+	origin := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodHead, r.Method)
+		assert.Equal(t, "/health", r.URL.Path)
+		assert.Equal(t, "app.internal", r.Host)
+		// Holds the requests so that each of them needs a connection of its own
+		time.Sleep(50 * time.Millisecond)
+	}))
+	origin.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			newConns.Add(1)
+		}
+	}
+	origin.Start()
+	defer origin.Close()
+
+	originURL, err := url.Parse(origin.URL)
+	require.NoError(t, err)
+	service := &httpService{url: originURL}
+	cfg := OriginRequestConfig{
+		HTTPHostHeader:       "app.internal",
+		KeepAliveConnections: 1,
+		KeepAliveTimeout:     config.CustomDuration{Duration: time.Minute},
+		Warmup:               &config.OriginWarmupConfig{Connections: 3, PingPath: "/health"},
+	}
+	log := zerolog.Nop()
+	shutdownC := make(chan struct{})
+	defer close(shutdownC)
+	// Starts the transport only, the pings are sent by the test
+	service.transport, err = newHTTPTransport(service, cfg, shutdownC, &log)
+	require.NoError(t, err)
+	service.hostHeader = cfg.HTTPHostHeader
+
+	warmer, err := newOriginWarmer(service, cfg, &log)
+	require.NoError(t, err)
+	require.Equal(t, defaultWarmupPingInterval, warmer.interval)
+
+	require.Equal(t, 0, warmer.ping())
+	require.EqualValues(t, 3, newConns.Load())
+	// The connections stay in the pool of the transport and are reused by the following pings
+	require.Equal(t, 0, warmer.ping())
+	require.EqualValues(t, 3, newConns.Load())
+}
+
+func TestOriginWarmerInvalid(t *testing.T) {
+	log := zerolog.Nop()
+	service := &httpService{url: &url.URL{Scheme: "http", Host: "localhost:8080"}}
+	invalid := []*config.OriginWarmupConfig{
+		{},
+		{Connections: 1, PingInterval: &config.CustomDuration{}},
+	}
+	for _, warmup := range invalid {
+		_, err := newOriginWarmer(service, OriginRequestConfig{Warmup: warmup}, &log)
+		require.Error(t, err)
+	}
+}
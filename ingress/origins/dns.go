@@ -47,23 +47,33 @@ type netDial func(network string, address string) (net.Conn, error)
 type DNSResolverService struct {
 	addresses  []netip.AddrPort
 	addressesM sync.RWMutex
-	static     bool
-	dialer     ingress.OriginDialer
-	resolver   peekResolver
-	logger     *zerolog.Logger
-	metrics    Metrics
+	// Consecutive failures of the static resolvers, used to fail over to the healthy ones.
+	failures map[netip.AddrPort]int
+	static   bool
+	dialer   ingress.OriginDialer
+	resolver peekResolver
+	probe    probeResolverFunc
+	// Time after which an unanswered UDP query fails over to another static resolver.
+	queryTimeout time.Duration
+	logger       *zerolog.Logger
+	metrics      Metrics
 }
 
 func NewDNSResolverService(dialer ingress.OriginDialer, logger *zerolog.Logger, metrics Metrics) *DNSResolverService {
 	return &DNSResolverService{
-		addresses: []netip.AddrPort{defaultResolverAddr},
-		dialer:    dialer,
-		resolver:  &resolver{dialFunc: net.Dial},
-		logger:    logger,
-		metrics:   metrics,
+		addresses:    []netip.AddrPort{defaultResolverAddr},
+		failures:     map[netip.AddrPort]int{},
+		dialer:       dialer,
+		resolver:     &resolver{dialFunc: net.Dial},
+		probe:        probeResolver,
+		queryTimeout: queryFailoverTimeout,
+		logger:       logger,
+		metrics:      metrics,
 	}
 }
 
+// NewStaticDNSResolverService uses the provided resolver addresses instead of the local DNS resolver. When more than
+// one is provided, the resolvers are health checked and UDP queries fail over to another resolver when unanswered.
 func NewStaticDNSResolverService(resolverAddrs []netip.AddrPort, dialer ingress.OriginDialer, logger *zerolog.Logger, metrics Metrics) *DNSResolverService {
 	s := NewDNSResolverService(dialer, logger, metrics)
 	s.addresses = resolverAddrs
@@ -80,6 +90,9 @@ func (s *DNSResolverService) DialTCP(ctx context.Context, _ netip.AddrPort) (net
 
 func (s *DNSResolverService) DialUDP(_ netip.AddrPort) (net.Conn, error) {
 	s.metrics.IncrementDNSUDPRequests()
+	if s.failsOver() {
+		return newDNSFailoverConn(s), nil
+	}
 	dest := s.getAddress()
 	// The dialer ignores the provided address because the request will instead go to the local DNS resolver.
 	return s.dialer.DialUDP(dest)
@@ -87,9 +100,14 @@ func (s *DNSResolverService) DialUDP(_ netip.AddrPort) (net.Conn, error) {
 
 // StartRefreshLoop is a routine that is expected to run in the background to update the DNS local resolver if
 // adjusted while the cloudflared process is running.
-// Does not run when the resolver was provided with external resolver addresses via CLI.
+// When the resolver was provided with external resolver addresses via CLI, it instead health checks them if there
+// is more than one to fail over between.
 func (s *DNSResolverService) StartRefreshLoop(ctx context.Context) {
 	if s.static {
+		if s.failsOver() {
+			s.startHealthCheckLoop(ctx)
+			return
+		}
 		s.logger.Debug().Msgf("Canceled DNS local resolver refresh loop because static resolver addresses were provided: %s", s.addresses)
 		return
 	}
@@ -111,6 +129,31 @@ func (s *DNSResolverService) StartRefreshLoop(ctx context.Context) {
 	}
 }
 
+// RefreshesInBackground returns whether StartRefreshLoop keeps running until its context is done. It returns at once
+// when a single static resolver address was provided.
+func (s *DNSResolverService) RefreshesInBackground() bool {
+	return !s.static || s.failsOver()
+}
+
+func (s *DNSResolverService) startHealthCheckLoop(ctx context.Context) {
+	s.healthCheck(ctx)
+	ticker := time.NewTicker(healthCheckFreq)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.healthCheck(ctx)
+		}
+	}
+}
+
+// failsOver is true when queries can fail over between multiple static resolvers.
+func (s *DNSResolverService) failsOver() bool {
+	return s.static && len(s.addresses) > 1
+}
+
 func (s *DNSResolverService) update(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, refreshTimeout)
 	defer cancel()
@@ -138,16 +181,44 @@ func (s *DNSResolverService) update(ctx context.Context) error {
 }
 
 // returns the address from the peekResolver or from the static addresses if provided.
-// If multiple addresses are provided in the static addresses pick one randomly.
+// If multiple addresses are provided in the static addresses pick one randomly, preferring the healthy ones.
 func (s *DNSResolverService) getAddress() netip.AddrPort {
+	addr, ok := s.nextAddress(nil)
+	if !ok {
+		return defaultResolverAddr
+	}
+	return addr
+}
+
+// nextAddress picks a random address that isn't in tried, preferring the healthy ones. It returns false once
+// every address was tried.
+func (s *DNSResolverService) nextAddress(tried []netip.AddrPort) (netip.AddrPort, bool) {
 	s.addressesM.RLock()
 	defer s.addressesM.RUnlock()
-	l := len(s.addresses)
-	if l <= 0 {
-		return defaultResolverAddr
+	var healthy, unhealthy []netip.AddrPort
+	for _, addr := range s.addresses {
+		if slices.Contains(tried, addr) {
+			continue
+		}
+		if s.failures[addr] < resolverMaxFailures {
+			healthy = append(healthy, addr)
+		} else {
+			unhealthy = append(unhealthy, addr)
+		}
+	}
+	if len(healthy) > 0 {
+		return pickAddress(healthy), true
 	}
+	if len(unhealthy) > 0 {
+		return pickAddress(unhealthy), true
+	}
+	return netip.AddrPort{}, false
+}
+
+func pickAddress(addresses []netip.AddrPort) netip.AddrPort {
+	l := len(addresses)
 	if l == 1 {
-		return s.addresses[0]
+		return addresses[0]
 	}
 	// Only initialize the random selection if there is more than one element in the list.
 	var i int64 = 0
@@ -157,7 +228,7 @@ func (s *DNSResolverService) getAddress() netip.AddrPort {
 	if err == nil {
 		i = r.Int64()
 	}
-	return s.addresses[i]
+	return addresses[i]
 }
 
 // lock and update the address used for the local DNS resolver
@@ -0,0 +1,290 @@
+package origins
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+)
+
+const (
+	// A resolver is considered unhealthy after this many consecutive failed queries or one failed health check,
+	// until it answers again.
+	resolverMaxFailures = 3
+
+	healthCheckFreq    = 30 * time.Second
+	healthCheckTimeout = 5 * time.Second
+
+	// A DNS query that isn't answered within this time is retried against another resolver.
+	queryFailoverTimeout = 2 * time.Second
+
+	dnsHeaderLen  = 12
+	maxDNSRespLen = 1500
+)
+
+var errDNSConnClosed = errors.New("dns failover connection closed")
+
+// probeResolverFunc checks that the resolver at addr answers DNS queries.
+type probeResolverFunc func(ctx context.Context, addr netip.AddrPort) error
+
+// probeResolver looks up the defaultLookupHost against the resolver. A resolver that answers that the host
+// doesn't exist is still healthy: private resolvers aren't expected to resolve public records.
+func probeResolver(ctx context.Context, addr netip.AddrPort) error {
+	resolver := &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, network, addr.String())
+		},
+	}
+	_, err := resolver.LookupNetIP(ctx, "ip", defaultLookupHost)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil
+	}
+	return err
+}
+
+// healthCheck probes every static resolver and updates their health.
+func (s *DNSResolverService) healthCheck(ctx context.Context) {
+	s.addressesM.RLock()
+	addresses := slices.Clone(s.addresses)
+	s.addressesM.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, addr := range addresses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(ctx, healthCheckTimeout)
+			defer cancel()
+			if err := s.probe(ctx, addr); err != nil {
+				if s.isHealthy(addr) {
+					s.logger.Warn().Err(err).Msgf("DNS resolver %s failed its health check", addr)
+				}
+				s.markUnhealthy(addr)
+				return
+			}
+			s.markSuccess(addr)
+		}()
+	}
+	wg.Wait()
+}
+
+func (s *DNSResolverService) isHealthy(addr netip.AddrPort) bool {
+	s.addressesM.RLock()
+	defer s.addressesM.RUnlock()
+	return s.failures[addr] < resolverMaxFailures
+}
+
+func (s *DNSResolverService) markSuccess(addr netip.AddrPort) {
+	s.addressesM.Lock()
+	defer s.addressesM.Unlock()
+	if s.failures[addr] >= resolverMaxFailures {
+		s.logger.Info().Msgf("DNS resolver %s is healthy again", addr)
+	}
+	delete(s.failures, addr)
+}
+
+func (s *DNSResolverService) markFailure(addr netip.AddrPort) {
+	s.addressesM.Lock()
+	defer s.addressesM.Unlock()
+	s.failures[addr]++
+	if s.failures[addr] == resolverMaxFailures {
+		s.logger.Warn().Msgf("DNS resolver %s is unhealthy after %d unanswered queries", addr, resolverMaxFailures)
+	}
+}
+
+func (s *DNSResolverService) markUnhealthy(addr netip.AddrPort) {
+	s.addressesM.Lock()
+	defer s.addressesM.Unlock()
+	s.failures[addr] = max(s.failures[addr], resolverMaxFailures)
+}
+
+// dnsFailoverConn proxies the DNS queries of a UDP flow to the static resolvers. Each query goes to a healthy
+// resolver and, if it isn't answered in time, is sent again to another one, so that a single dead resolver
+// doesn't blackhole the flow. Answers are matched to queries by their DNS message ID; late answers to a query
+// that was already answered are dropped.
+type dnsFailoverConn struct {
+	service *DNSResolverService
+
+	mu      sync.Mutex
+	conns   map[netip.AddrPort]net.Conn
+	pending map[uint16]*pendingQuery
+
+	responses chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+type pendingQuery struct {
+	query []byte
+	addr  netip.AddrPort
+	tried []netip.AddrPort
+	timer *time.Timer
+}
+
+func newDNSFailoverConn(service *DNSResolverService) *dnsFailoverConn {
+	return &dnsFailoverConn{
+		service:   service,
+		conns:     map[netip.AddrPort]net.Conn{},
+		pending:   map[uint16]*pendingQuery{},
+		responses: make(chan []byte, 16),
+		closed:    make(chan struct{}),
+	}
+}
+
+func (c *dnsFailoverConn) Write(b []byte) (int, error) {
+	if len(b) < dnsHeaderLen {
+		return 0, errors.New("dns query too short")
+	}
+	id := binary.BigEndian.Uint16(b)
+	query := &pendingQuery{query: slices.Clone(b)}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if previous, ok := c.pending[id]; ok {
+		// The client retried the query itself, forget about the previous attempt.
+		previous.timer.Stop()
+	}
+	c.pending[id] = query
+	if err := c.send(id, query); err != nil {
+		delete(c.pending, id)
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// send writes the query to the next resolver that wasn't tried yet, failing over on write errors.
+// Must be called with c.mu held.
+func (c *dnsFailoverConn) send(id uint16, query *pendingQuery) error {
+	var lastErr error = errDNSConnClosed
+	for {
+		addr, ok := c.service.nextAddress(query.tried)
+		if !ok {
+			return lastErr
+		}
+		query.tried = append(query.tried, addr)
+		conn, err := c.conn(addr)
+		if err == nil {
+			_, err = conn.Write(query.query)
+		}
+		if err != nil {
+			c.service.markFailure(addr)
+			lastErr = err
+			continue
+		}
+		query.addr = addr
+		query.timer = time.AfterFunc(c.service.queryTimeout, func() {
+			c.failover(id, query)
+		})
+		return nil
+	}
+}
+
+// failover retries a query that the resolver didn't answer in time.
+func (c *dnsFailoverConn) failover(id uint16, query *pendingQuery) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pending[id] != query {
+		return
+	}
+	c.service.markFailure(query.addr)
+	c.service.metrics.IncrementDNSFailovers()
+	if err := c.send(id, query); err != nil {
+		// Every resolver was tried, leave it to the client to retry.
+		delete(c.pending, id)
+	}
+}
+
+// conn returns the connection to the resolver at addr, dialing it on first use. Must be called with c.mu held.
+func (c *dnsFailoverConn) conn(addr netip.AddrPort) (net.Conn, error) {
+	select {
+	case <-c.closed:
+		return nil, errDNSConnClosed
+	default:
+	}
+	if conn, ok := c.conns[addr]; ok {
+		return conn, nil
+	}
+	conn, err := c.service.dialer.DialUDP(addr)
+	if err != nil {
+		return nil, err
+	}
+	c.conns[addr] = conn
+	go c.readLoop(addr, conn)
+	return conn, nil
+}
+
+func (c *dnsFailoverConn) readLoop(addr netip.AddrPort, conn net.Conn) {
+	buf := make([]byte, maxDNSRespLen)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		if n < dnsHeaderLen {
+			continue
+		}
+		id := binary.BigEndian.Uint16(buf)
+		c.mu.Lock()
+		query, ok := c.pending[id]
+		if ok {
+			query.timer.Stop()
+			delete(c.pending, id)
+		}
+		c.mu.Unlock()
+		if !ok {
+			continue
+		}
+		c.service.markSuccess(addr)
+		select {
+		case c.responses <- slices.Clone(buf[:n]):
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *dnsFailoverConn) Read(b []byte) (int, error) {
+	select {
+	case response := <-c.responses:
+		return copy(b, response), nil
+	case <-c.closed:
+		return 0, net.ErrClosed
+	}
+}
+
+func (c *dnsFailoverConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, query := range c.pending {
+			query.timer.Stop()
+		}
+		clear(c.pending)
+		for _, conn := range c.conns {
+			_ = conn.Close()
+		}
+	})
+	return nil
+}
+
+func (c *dnsFailoverConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{}
+}
+
+func (c *dnsFailoverConn) RemoteAddr() net.Addr {
+	return net.UDPAddrFromAddrPort(VirtualDNSServiceAddr)
+}
+
+// Deadlines are not supported: writes to each resolver already have their own deadline and reads only end
+// with Close.
+func (c *dnsFailoverConn) SetDeadline(time.Time) error      { return nil }
+func (c *dnsFailoverConn) SetReadDeadline(time.Time) error  { return nil }
+func (c *dnsFailoverConn) SetWriteDeadline(time.Time) error { return nil }
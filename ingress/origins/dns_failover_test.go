@@ -0,0 +1,143 @@
+package origins
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startUDPResolver starts a fake resolver that echoes queries back when answer is true and drops them otherwise.
+func startUDPResolver(t *testing.T, answer bool) netip.AddrPort {
+	conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.MustParseAddrPort("127.0.0.1:0")))
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = conn.Close() })
+	go func() {
+		buf := make([]byte, maxDNSRespLen)
+		for {
+			n, addr, err := conn.ReadFromUDPAddrPort(buf)
+			if err != nil {
+				return
+			}
+			if answer {
+				_, _ = conn.WriteToUDPAddrPort(buf[:n], addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr).AddrPort()
+}
+
+func dnsQuery(id uint16) []byte {
+	query := make([]byte, dnsHeaderLen)
+	binary.BigEndian.PutUint16(query, id)
+	return query
+}
+
+func TestStaticDNSResolver_DialUDPFailsOver(t *testing.T) {
+	log := zerolog.Nop()
+	dead := startUDPResolver(t, false)
+	alive := startUDPResolver(t, true)
+	service := NewStaticDNSResolverService([]netip.AddrPort{dead, alive}, NewDNSDialer(), &log, &noopMetrics{})
+	service.queryTimeout = 20 * time.Millisecond
+
+	conn, err := service.DialUDP(VirtualDNSServiceAddr)
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for id := uint16(1); id <= 2*resolverMaxFailures; id++ {
+		_, err := conn.Write(dnsQuery(id))
+		require.NoError(t, err)
+		buf := make([]byte, maxDNSRespLen)
+		n, err := conn.Read(buf)
+		require.NoError(t, err)
+		require.Equal(t, dnsQuery(id), buf[:n])
+	}
+	assert.True(t, service.isHealthy(alive))
+}
+
+func TestStaticDNSResolver_DialUDPAllResolversDead(t *testing.T) {
+	log := zerolog.Nop()
+	dead1 := startUDPResolver(t, false)
+	dead2 := startUDPResolver(t, false)
+	service := NewStaticDNSResolverService([]netip.AddrPort{dead1, dead2}, NewDNSDialer(), &log, &noopMetrics{})
+	service.queryTimeout = 10 * time.Millisecond
+
+	conn, err := service.DialUDP(VirtualDNSServiceAddr)
+	require.NoError(t, err)
+	failover := conn.(*dnsFailoverConn)
+
+	_, err = conn.Write(dnsQuery(1))
+	require.NoError(t, err)
+	// The query is given up once both resolvers were tried.
+	require.Eventually(t, func() bool {
+		failover.mu.Lock()
+		defer failover.mu.Unlock()
+		return len(failover.pending) == 0
+	}, time.Second, time.Millisecond)
+
+	require.NoError(t, conn.Close())
+	_, err = conn.Read(make([]byte, maxDNSRespLen))
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestStaticDNSResolver_SingleAddressDoesNotFailOver(t *testing.T) {
+	log := zerolog.Nop()
+	addr := netip.MustParseAddrPort("127.0.0.2:53")
+	service := NewStaticDNSResolverService([]netip.AddrPort{addr}, &mockDialer{expected: addr}, &log, &noopMetrics{})
+
+	conn, err := service.DialUDP(VirtualDNSServiceAddr)
+	require.NoError(t, err)
+	assert.Nil(t, conn)
+}
+
+func TestStaticDNSResolver_PrefersHealthyResolvers(t *testing.T) {
+	log := zerolog.Nop()
+	unhealthy := netip.MustParseAddrPort("127.0.0.2:53")
+	healthy := netip.MustParseAddrPort("127.0.0.3:53")
+	service := NewStaticDNSResolverService([]netip.AddrPort{unhealthy, healthy}, NewDNSDialer(), &log, &noopMetrics{})
+
+	for range resolverMaxFailures {
+		service.markFailure(unhealthy)
+	}
+	for range 10 {
+		assert.Equal(t, healthy, service.getAddress())
+	}
+
+	// Unhealthy resolvers are still tried once the healthy ones were.
+	addr, ok := service.nextAddress([]netip.AddrPort{healthy})
+	assert.True(t, ok)
+	assert.Equal(t, unhealthy, addr)
+	_, ok = service.nextAddress([]netip.AddrPort{healthy, unhealthy})
+	assert.False(t, ok)
+
+	service.markSuccess(unhealthy)
+	assert.True(t, service.isHealthy(unhealthy))
+}
+
+func TestStaticDNSResolver_HealthCheck(t *testing.T) {
+	log := zerolog.Nop()
+	down := netip.MustParseAddrPort("127.0.0.2:53")
+	up := netip.MustParseAddrPort("127.0.0.3:53")
+	service := NewStaticDNSResolverService([]netip.AddrPort{down, up}, NewDNSDialer(), &log, &noopMetrics{})
+	service.probe = func(_ context.Context, addr netip.AddrPort) error {
+		if addr == down {
+			return errors.New("no answer")
+		}
+		return nil
+	}
+
+	service.healthCheck(t.Context())
+	assert.False(t, service.isHealthy(down))
+	assert.True(t, service.isHealthy(up))
+
+	service.probe = func(context.Context, netip.AddrPort) error { return nil }
+	service.healthCheck(t.Context())
+	assert.True(t, service.isHealthy(down))
+}
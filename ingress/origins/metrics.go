@@ -12,10 +12,12 @@ const (
 type Metrics interface {
 	IncrementDNSUDPRequests()
 	IncrementDNSTCPRequests()
+	IncrementDNSFailovers()
 }
 
 type metrics struct {
-	dnsResolverRequests *prometheus.CounterVec
+	dnsResolverRequests  *prometheus.CounterVec
+	dnsResolverFailovers prometheus.Counter
 }
 
 func (m *metrics) IncrementDNSUDPRequests() {
@@ -26,6 +28,10 @@ func (m *metrics) IncrementDNSTCPRequests() {
 	m.dnsResolverRequests.WithLabelValues("tcp").Inc()
 }
 
+func (m *metrics) IncrementDNSFailovers() {
+	m.dnsResolverFailovers.Inc()
+}
+
 func NewMetrics(registerer prometheus.Registerer) Metrics {
 	m := &metrics{
 		dnsResolverRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
@@ -34,7 +40,13 @@ func NewMetrics(registerer prometheus.Registerer) Metrics {
 			Name:      "dns_requests_total",
 			Help:      "Total count of DNS requests that have been proxied to the virtual DNS resolver origin",
 		}, []string{"protocol"}),
+		dnsResolverFailovers: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      "dns_failovers_total",
+			Help:      "Total count of DNS queries that were retried against another resolver because they were not answered in time",
+		}),
 	}
-	registerer.MustRegister(m.dnsResolverRequests)
+	registerer.MustRegister(m.dnsResolverRequests, m.dnsResolverFailovers)
 	return m
 }
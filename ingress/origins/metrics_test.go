@@ -4,3 +4,4 @@ type noopMetrics struct{}
 
 func (noopMetrics) IncrementDNSUDPRequests() {}
 func (noopMetrics) IncrementDNSTCPRequests() {}
+func (noopMetrics) IncrementDNSFailovers()   {}
@@ -0,0 +1,373 @@
+package origins
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+// The origin dialer plugin protocol lets an external process establish the connections to the origins, e.g. through a
+// serial gateway, a vendor SDK or a chain of SSH jump hosts, without modifying cloudflared.
+//
+// The plugin serves the bidirectional streaming gRPC method PluginDialMethod, with the messages encoded as JSON
+// PluginFrames (content type application/grpc+json). Every stream is a connection to an origin:
+//  1. cloudflared sends a frame with Dial set.
+//  2. The plugin answers with a frame with Result set, without Error nor Fallback once it's connected.
+//  3. Both sides then exchange frames with Data set until either closes the stream. Each UDP datagram is a frame.
+//
+// cloudflared dials the origin itself when the plugin can't be reached or answers with Fallback.
+const (
+	PluginServiceName = "cloudflared.origindialer.v1.OriginDialer"
+	PluginDialMethod  = "/" + PluginServiceName + "/Dial"
+
+	// pluginUDPDialTimeout bounds dialing UDP origins, which isn't given a context
+	pluginUDPDialTimeout = 10 * time.Second
+)
+
+// PluginFrame is a message of the origin dialer plugin protocol, with one of its fields set.
+type PluginFrame struct {
+	Dial   *PluginDialRequest `json:"dial,omitempty"`
+	Result *PluginDialResult  `json:"result,omitempty"`
+	Data   []byte             `json:"data,omitempty"`
+}
+
+// PluginDialRequest asks the plugin to connect to an origin.
+type PluginDialRequest struct {
+	// Network is tcp or udp
+	Network string `json:"network"`
+	Address string `json:"address"`
+}
+
+// PluginDialResult is the outcome of a PluginDialRequest.
+type PluginDialResult struct {
+	// Error is why the plugin failed to connect to the origin, which fails the request
+	Error string `json:"error,omitempty"`
+	// Fallback is set by a plugin that doesn't handle the origin, which cloudflared then dials itself
+	Fallback bool `json:"fallback,omitempty"`
+}
+
+// PluginCodec encodes the messages of the origin dialer plugin protocol as JSON.
+type PluginCodec struct{}
+
+func (PluginCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (PluginCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (PluginCodec) Name() string {
+	return "json"
+}
+
+func init() {
+	encoding.RegisterCodec(PluginCodec{})
+}
+
+var pluginStreamDesc = grpc.StreamDesc{
+	StreamName:    "Dial",
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+// PluginDialer dials the origins through an origin dialer plugin listening on a local gRPC target.
+type PluginDialer struct {
+	target string
+	conn   *grpc.ClientConn
+}
+
+// NewPluginDialer returns a PluginDialer of the plugin at target, either a unix socket (unix:///path/to/socket) or a
+// loopback address (127.0.0.1:port). The plugin isn't connected to until the first dial.
+func NewPluginDialer(target string) (*PluginDialer, error) {
+	if err := validatePluginTarget(target); err != nil {
+		return nil, err
+	}
+	conn, err := grpc.NewClient(target,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(PluginCodec{}.Name())),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("invalid origin dialer plugin %s: %w", target, err)
+	}
+	return &PluginDialer{
+		target: target,
+		conn:   conn,
+	}, nil
+}
+
+// validatePluginTarget only accepts local targets, since the plugin protocol is neither encrypted nor authenticated.
+func validatePluginTarget(target string) error {
+	if strings.HasPrefix(target, "unix:") {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(target)
+	if err != nil {
+		return fmt.Errorf("the origin dialer plugin %s must be a unix socket or a loopback address: %w", target, err)
+	}
+	if ip, err := netip.ParseAddr(host); host != "localhost" && (err != nil || !ip.IsLoopback()) {
+		return fmt.Errorf("the origin dialer plugin %s must be a unix socket or a loopback address", target)
+	}
+	return nil
+}
+
+// Close closes the connection to the plugin, and the origin connections going through it.
+func (d *PluginDialer) Close() error {
+	return d.conn.Close()
+}
+
+func (d *PluginDialer) DialTCP(ctx context.Context, addr netip.AddrPort) (net.Conn, error) {
+	return d.dial(ctx, "tcp", addr)
+}
+
+func (d *PluginDialer) DialUDP(addr netip.AddrPort) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), pluginUDPDialTimeout)
+	defer cancel()
+	return d.dial(ctx, "udp", addr)
+}
+
+func (d *PluginDialer) dial(ctx context.Context, network string, addr netip.AddrPort) (net.Conn, error) {
+	// The stream outlives ctx, which only bounds the dial
+	streamCtx, cancel := context.WithCancel(context.Background())
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	result, stream, err := d.openStream(streamCtx, network, addr)
+	if err != nil {
+		cancel()
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, fmt.Errorf("origin dialer plugin didn't dial %s %s in time: %w", network, addr, ctxErr)
+		}
+		return nil, fmt.Errorf("origin dialer plugin %s failed to dial %s %s: %v: %w", d.target, network, addr, err, ingress.ErrOriginDialFallback)
+	}
+	if result.Fallback {
+		cancel()
+		return nil, fmt.Errorf("origin dialer plugin declined to dial %s %s: %w", network, addr, ingress.ErrOriginDialFallback)
+	}
+	if result.Error != "" {
+		cancel()
+		return nil, fmt.Errorf("origin dialer plugin failed to dial %s %s: %s", network, addr, result.Error)
+	}
+
+	conn := &pluginConn{
+		network:         network,
+		target:          d.target,
+		remote:          addr,
+		stream:          stream,
+		cancel:          cancel,
+		frames:          make(chan []byte),
+		closed:          make(chan struct{}),
+		deadlineChanged: make(chan struct{}),
+	}
+	go conn.receive()
+	return conn, nil
+}
+
+// openStream opens a stream to the plugin and asks it to dial addr.
+func (d *PluginDialer) openStream(ctx context.Context, network string, addr netip.AddrPort) (*PluginDialResult, grpc.ClientStream, error) {
+	stream, err := d.conn.NewStream(ctx, &pluginStreamDesc, PluginDialMethod)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := stream.SendMsg(&PluginFrame{Dial: &PluginDialRequest{Network: network, Address: addr.String()}}); err != nil {
+		return nil, nil, err
+	}
+	var frame PluginFrame
+	if err := stream.RecvMsg(&frame); err != nil {
+		return nil, nil, err
+	}
+	if frame.Result == nil {
+		return nil, nil, errors.New("the plugin didn't answer with a dial result")
+	}
+	return frame.Result, stream, nil
+}
+
+// pluginConn is a connection to an origin going through a stream to the plugin. Writes are sent as they are, and reads
+// of UDP connections return one datagram at most, like a connected UDP socket.
+type pluginConn struct {
+	network string
+	target  string
+	remote  netip.AddrPort
+	stream  grpc.ClientStream
+	cancel  context.CancelFunc
+
+	// frames is the data received from the plugin, closed after recvErr is set
+	frames  chan []byte
+	recvErr error
+	// pending is the rest of a TCP frame not read yet
+	pending []byte
+
+	deadlineM       sync.Mutex
+	readDeadline    time.Time
+	deadlineChanged chan struct{}
+
+	writeM    sync.Mutex
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func (c *pluginConn) receive() {
+	defer close(c.frames)
+	for {
+		var frame PluginFrame
+		if err := c.stream.RecvMsg(&frame); err != nil {
+			select {
+			case <-c.closed:
+				c.recvErr = net.ErrClosed
+			default:
+				if err == io.EOF {
+					c.recvErr = io.EOF
+				} else {
+					c.recvErr = fmt.Errorf("origin dialer plugin stream to %s failed: %w", c.remote, err)
+				}
+			}
+			return
+		}
+		if len(frame.Data) == 0 {
+			continue
+		}
+		select {
+		case c.frames <- frame.Data:
+		case <-c.closed:
+			c.recvErr = net.ErrClosed
+			return
+		}
+	}
+}
+
+func (c *pluginConn) Read(b []byte) (int, error) {
+	if len(c.pending) == 0 {
+		data, err := c.nextFrame()
+		if err != nil {
+			return 0, err
+		}
+		c.pending = data
+	}
+	n := copy(b, c.pending)
+	if c.network == "udp" {
+		// The rest of a datagram larger than b is discarded
+		c.pending = nil
+	} else {
+		c.pending = c.pending[n:]
+	}
+	return n, nil
+}
+
+// nextFrame waits for the next data from the plugin until the read deadline, which may change while waiting.
+func (c *pluginConn) nextFrame() ([]byte, error) {
+	for {
+		c.deadlineM.Lock()
+		deadline, deadlineChanged := c.readDeadline, c.deadlineChanged
+		c.deadlineM.Unlock()
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if !deadline.IsZero() {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				return nil, os.ErrDeadlineExceeded
+			}
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+
+		select {
+		case data, ok := <-c.frames:
+			if timer != nil {
+				timer.Stop()
+			}
+			if !ok {
+				return nil, c.recvErr
+			}
+			return data, nil
+		case <-timeout:
+			return nil, os.ErrDeadlineExceeded
+		case <-deadlineChanged:
+			if timer != nil {
+				timer.Stop()
+			}
+		}
+	}
+}
+
+func (c *pluginConn) Write(b []byte) (int, error) {
+	c.writeM.Lock()
+	defer c.writeM.Unlock()
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	if err := c.stream.SendMsg(&PluginFrame{Data: b}); err != nil {
+		return 0, fmt.Errorf("origin dialer plugin stream to %s failed: %w", c.remote, err)
+	}
+	return len(b), nil
+}
+
+func (c *pluginConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.writeM.Lock()
+		_ = c.stream.CloseSend()
+		c.writeM.Unlock()
+		c.cancel()
+	})
+	return nil
+}
+
+func (c *pluginConn) LocalAddr() net.Addr {
+	return pluginAddr{network: c.network, target: c.target}
+}
+
+func (c *pluginConn) RemoteAddr() net.Addr {
+	if c.network == "udp" {
+		return net.UDPAddrFromAddrPort(c.remote)
+	}
+	return net.TCPAddrFromAddrPort(c.remote)
+}
+
+func (c *pluginConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *pluginConn) SetReadDeadline(t time.Time) error {
+	c.deadlineM.Lock()
+	defer c.deadlineM.Unlock()
+	c.readDeadline = t
+	close(c.deadlineChanged)
+	c.deadlineChanged = make(chan struct{})
+	return nil
+}
+
+// SetWriteDeadline is a no-op, writes are bounded by the flow control of the stream instead.
+func (c *pluginConn) SetWriteDeadline(time.Time) error {
+	return nil
+}
+
+// pluginAddr is the local address of the connections going through the plugin.
+type pluginAddr struct {
+	network string
+	target  string
+}
+
+func (a pluginAddr) Network() string {
+	return a.network
+}
+
+func (a pluginAddr) String() string {
+	return a.target
+}
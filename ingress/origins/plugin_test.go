@@ -0,0 +1,196 @@
+package origins
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+const (
+	fallbackPort = 1
+	refusedPort  = 2
+)
+
+// echoPlugin serves the origin dialer plugin protocol on a unix socket, echoing the data of every origin connection.
+// It declines to dial fallbackPort and fails to dial refusedPort.
+func echoPlugin(t *testing.T) string {
+	socket := filepath.Join(t.TempDir(), "plugin.sock")
+	listener, err := net.Listen("unix", socket)
+	require.NoError(t, err)
+
+	server := grpc.NewServer()
+	server.RegisterService(&grpc.ServiceDesc{
+		ServiceName: PluginServiceName,
+		HandlerType: (*any)(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName:    "Dial",
+			ServerStreams: true,
+			ClientStreams: true,
+			Handler: func(_ any, stream grpc.ServerStream) error {
+				var dial PluginFrame
+				if err := stream.RecvMsg(&dial); err != nil {
+					return err
+				}
+				switch netip.MustParseAddrPort(dial.Dial.Address).Port() {
+				case fallbackPort:
+					return stream.SendMsg(&PluginFrame{Result: &PluginDialResult{Fallback: true}})
+				case refusedPort:
+					return stream.SendMsg(&PluginFrame{Result: &PluginDialResult{Error: "connection refused"}})
+				}
+				if err := stream.SendMsg(&PluginFrame{Result: &PluginDialResult{}}); err != nil {
+					return err
+				}
+				for {
+					var frame PluginFrame
+					if err := stream.RecvMsg(&frame); err != nil {
+						return nil
+					}
+					if err := stream.SendMsg(&frame); err != nil {
+						return err
+					}
+				}
+			},
+		}},
+	}, nil)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	t.Cleanup(server.Stop)
+	return "unix://" + socket
+}
+
+func newTestPluginDialer(t *testing.T, target string) *PluginDialer {
+	plugin, err := NewPluginDialer(target)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = plugin.Close() })
+	return plugin
+}
+
+func TestPluginDialerTCP(t *testing.T) {
+	plugin := newTestPluginDialer(t, echoPlugin(t))
+
+	conn, err := plugin.DialTCP(context.Background(), netip.MustParseAddrPort("10.0.0.1:8080"))
+	require.NoError(t, err)
+	defer conn.Close()
+	assert.Equal(t, "10.0.0.1:8080", conn.RemoteAddr().String())
+
+	_, err = conn.Write([]byte("hello origin"))
+	require.NoError(t, err)
+	buf := make([]byte, 5)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "hello", string(buf[:n]))
+	// The rest of the frame is read next
+	n, err = conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, " orig", string(buf[:n]))
+
+	require.NoError(t, conn.Close())
+	_, err = conn.Write([]byte("closed"))
+	assert.ErrorIs(t, err, net.ErrClosed)
+}
+
+func TestPluginDialerUDP(t *testing.T) {
+	plugin := newTestPluginDialer(t, echoPlugin(t))
+
+	conn, err := plugin.DialUDP(netip.MustParseAddrPort("10.0.0.1:53"))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	for _, datagram := range []string{"first", "second"} {
+		_, err = conn.Write([]byte(datagram))
+		require.NoError(t, err)
+	}
+	// Datagrams keep their boundaries, and the rest of a datagram larger than the buffer is discarded
+	buf := make([]byte, 3)
+	n, err := conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "fir", string(buf[:n]))
+	buf = make([]byte, 16)
+	n, err = conn.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, "second", string(buf[:n]))
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(50*time.Millisecond)))
+	_, err = conn.Read(buf)
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+}
+
+type recordingDialer struct {
+	dialed []netip.AddrPort
+}
+
+func (d *recordingDialer) DialTCP(_ context.Context, addr netip.AddrPort) (net.Conn, error) {
+	d.dialed = append(d.dialed, addr)
+	client, server := net.Pipe()
+	_ = server.Close()
+	return client, nil
+}
+
+func (d *recordingDialer) DialUDP(addr netip.AddrPort) (net.Conn, error) {
+	return d.DialTCP(context.Background(), addr)
+}
+
+func TestPluginDialerFallback(t *testing.T) {
+	log := zerolog.Nop()
+	for name, test := range map[string]struct {
+		target   string
+		addr     netip.AddrPort
+		fallback bool
+	}{
+		"declined": {
+			target:   echoPlugin(t),
+			addr:     netip.AddrPortFrom(netip.MustParseAddr("10.0.0.1"), fallbackPort),
+			fallback: true,
+		},
+		"unreachable": {
+			target:   "unix://" + filepath.Join(t.TempDir(), "missing.sock"),
+			addr:     netip.MustParseAddrPort("10.0.0.1:8080"),
+			fallback: true,
+		},
+		"refused": {
+			target: echoPlugin(t),
+			addr:   netip.AddrPortFrom(netip.MustParseAddr("10.0.0.1"), refusedPort),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			defaultDialer := &recordingDialer{}
+			service := ingress.NewOriginDialer(ingress.OriginConfig{
+				DefaultDialer: defaultDialer,
+				Plugin:        newTestPluginDialer(t, test.target),
+			}, &log)
+
+			conn, err := service.DialTCP(context.Background(), test.addr)
+			if test.fallback {
+				require.NoError(t, err)
+				_ = conn.Close()
+				assert.Equal(t, []netip.AddrPort{test.addr}, defaultDialer.dialed)
+			} else {
+				assert.Error(t, err)
+				assert.False(t, errors.Is(err, ingress.ErrOriginDialFallback))
+				assert.Empty(t, defaultDialer.dialed)
+			}
+		})
+	}
+}
+
+func TestValidatePluginTarget(t *testing.T) {
+	for _, target := range []string{"unix:///run/cloudflared/plugin.sock", "127.0.0.1:9000", "[::1]:9000", "localhost:9000"} {
+		assert.NoError(t, validatePluginTarget(target), target)
+	}
+	for _, target := range []string{"10.0.0.1:9000", "plugin.example.com:9000", "/run/cloudflared/plugin.sock"} {
+		assert.Error(t, validatePluginTarget(target), target)
+	}
+}
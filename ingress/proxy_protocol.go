@@ -0,0 +1,65 @@
+package ingress
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+)
+
+// proxyProtocolSignature starts every PROXY protocol v2 header.
+var proxyProtocolSignature = []byte{0x0D, 0x0A, 0x0D, 0x0A, 0x00, 0x0D, 0x0A, 0x51, 0x55, 0x49, 0x54, 0x0A}
+
+const (
+	proxyProtocolVersion = 0x20
+	proxyProtocolLocal   = 0x00
+	proxyProtocolProxy   = 0x01
+
+	proxyProtocolUnspec  = 0x00
+	proxyProtocolTCPIPv4 = 0x11
+	proxyProtocolTCPIPv6 = 0x21
+)
+
+// writeProxyProtocolHeader writes a PROXY protocol v2 header telling the origin that the connection comes from
+// client. The source port is 0 because the edge only tells cloudflared the IP of the client. When the client or
+// the address of the origin is unknown, the header uses the LOCAL command so the origin uses the addresses of the
+// connection itself.
+func writeProxyProtocolHeader(w io.Writer, client netip.Addr, origin net.Addr) error {
+	header := make([]byte, 0, len(proxyProtocolSignature)+4+36)
+	header = append(header, proxyProtocolSignature...)
+
+	dst, ok := tcpAddrPort(origin)
+	if !client.IsValid() || !ok {
+		header = append(header, proxyProtocolVersion|proxyProtocolLocal, proxyProtocolUnspec, 0, 0)
+		_, err := w.Write(header)
+		return err
+	}
+
+	src := client.Unmap()
+	dstIP := dst.Addr().Unmap()
+	var addresses []byte
+	if src.Is4() && dstIP.Is4() {
+		header = append(header, proxyProtocolVersion|proxyProtocolProxy, proxyProtocolTCPIPv4, 0, 12)
+		src4, dst4 := src.As4(), dstIP.As4()
+		addresses = append(src4[:], dst4[:]...)
+	} else {
+		// Both addresses must have the same family, IPv4 addresses are mapped to IPv6 when the other one is IPv6
+		header = append(header, proxyProtocolVersion|proxyProtocolProxy, proxyProtocolTCPIPv6, 0, 36)
+		src16, dst16 := src.As16(), dstIP.As16()
+		addresses = append(src16[:], dst16[:]...)
+	}
+	header = append(header, addresses...)
+	header = binary.BigEndian.AppendUint16(header, 0)
+	header = binary.BigEndian.AppendUint16(header, dst.Port())
+	_, err := w.Write(header)
+	return err
+}
+
+func tcpAddrPort(addr net.Addr) (netip.AddrPort, bool) {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return netip.AddrPort{}, false
+	}
+	addrPort := tcpAddr.AddrPort()
+	return addrPort, addrPort.IsValid()
+}
@@ -0,0 +1,106 @@
+package ingress
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteProxyProtocolHeader(t *testing.T) {
+	tests := []struct {
+		name     string
+		client   netip.Addr
+		origin   net.Addr
+		expected []byte
+	}{
+		{
+			name:   "IPv4",
+			client: netip.MustParseAddr("203.0.113.7"),
+			origin: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 5432},
+			expected: append(append([]byte{}, proxyProtocolSignature...),
+				0x21, 0x11, 0, 12,
+				203, 0, 113, 7,
+				10, 0, 0, 1,
+				0, 0,
+				0x15, 0x38,
+			),
+		},
+		{
+			name:   "IPv6 client to IPv4 origin",
+			client: netip.MustParseAddr("2001:db8::1"),
+			origin: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80},
+			expected: append(append([]byte{}, proxyProtocolSignature...),
+				0x21, 0x21, 0, 36,
+				0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+				0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 10, 0, 0, 1,
+				0, 0,
+				0, 80,
+			),
+		},
+		{
+			name:     "unknown client",
+			origin:   &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 80},
+			expected: append(append([]byte{}, proxyProtocolSignature...), 0x20, 0x00, 0, 0),
+		},
+		{
+			name:     "unknown origin",
+			client:   netip.MustParseAddr("203.0.113.7"),
+			origin:   &net.UnixAddr{Name: "/tmp/origin.sock", Net: "unix"},
+			expected: append(append([]byte{}, proxyProtocolSignature...), 0x20, 0x00, 0, 0),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, writeProxyProtocolHeader(&buf, test.client, test.origin))
+			require.Equal(t, test.expected, buf.Bytes())
+		})
+	}
+}
+
+func TestTCPOverWSServiceSendsProxyProtocolHeader(t *testing.T) {
+	originListener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer originListener.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := originListener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		header := make([]byte, len(proxyProtocolSignature)+4+12)
+		if _, err := io.ReadFull(conn, header); err == nil {
+			received <- header
+		}
+	}()
+
+	log := zerolog.Nop()
+	service := newTCPOverWSService(&url.URL{Scheme: "tcp", Host: originListener.Addr().String()})
+	require.NoError(t, service.start(&log, nil, OriginRequestConfig{ProxyProtocol: true}))
+
+	ctx := ContextWithClientIP(context.Background(), netip.MustParseAddr("203.0.113.7"))
+	originConn, err := service.EstablishConnection(ctx, "", &log)
+	require.NoError(t, err)
+	defer originConn.Close()
+
+	var header []byte
+	select {
+	case header = <-received:
+	case <-time.After(time.Second):
+		t.Fatal("origin didn't receive the PROXY protocol header")
+	}
+	require.Equal(t, proxyProtocolSignature, header[:len(proxyProtocolSignature)])
+	// Source address
+	require.Equal(t, []byte{203, 0, 113, 7}, header[16:20])
+}
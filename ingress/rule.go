@@ -28,6 +28,12 @@ type Rule struct {
 	// Handlers is a list of functions that acts as a middleware during ProxyHTTP
 	Handlers []middleware.Handler
 
+	// CORS is handled by cloudflared for this rule when set.
+	CORS *CORSPolicy `json:"-"`
+
+	// Transform changes the requests to HTTP origins and their responses when set.
+	Transform *Transform `json:"-"`
+
 	// Configure the request cloudflared sends to this specific origin.
 	Config OriginRequestConfig `json:"originRequest"`
 }
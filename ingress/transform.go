@@ -0,0 +1,337 @@
+package ingress
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+const (
+	defaultTransformTimeout = 100 * time.Millisecond
+
+	transformRequestFunc  = "on_request"
+	transformResponseFunc = "on_response"
+)
+
+// transformLibs are the Lua libraries scripts can use, the others reach files, processes or the environment.
+var transformLibs = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.StringLibName, lua.OpenString},
+	{lua.MathLibName, lua.OpenMath},
+}
+
+// Transform runs the Lua script of a rule over the requests to its origin and their responses, e.g. to mint auth
+// headers or rewrite URLs without changing the origin. The script is as trusted as the rest of the configuration:
+// it's sandboxed from files and processes, and each call is bounded in time but not in memory.
+//
+// on_request(req) gets a table with the method, host, path, query, headers and body of the request, and
+// on_response(resp) one with the status, headers and body of the response. Headers map canonical names, e.g.
+// X-Api-Key, to a string, or to a list of strings when there are several values. The changes the functions make to
+// their table are applied to the request or response.
+type Transform struct {
+	proto       *lua.FunctionProto
+	maxBodySize int64
+	timeout     time.Duration
+	// states are Lua states that ran the script, ready to call its functions. A state runs one call at a time.
+	states sync.Pool
+}
+
+// NewTransform compiles the script of cfg, or returns nil when cfg is nil.
+func NewTransform(cfg *config.TransformConfig) (*Transform, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.Lua == "" {
+		return nil, fmt.Errorf("transform requires the path of a lua script")
+	}
+	script, err := os.Open(cfg.Lua)
+	if err != nil {
+		return nil, err
+	}
+	defer script.Close()
+	chunk, err := parse.Parse(script, cfg.Lua)
+	if err != nil {
+		return nil, err
+	}
+	proto, err := lua.Compile(chunk, cfg.Lua)
+	if err != nil {
+		return nil, err
+	}
+	t := &Transform{
+		proto:       proto,
+		maxBodySize: cfg.MaxBodySize,
+		timeout:     defaultTransformTimeout,
+	}
+	if cfg.Timeout != nil {
+		t.timeout = cfg.Timeout.Duration
+	}
+	if t.timeout <= 0 {
+		return nil, fmt.Errorf("transform timeout must be positive")
+	}
+	// Runs the script once so that errors of its top level show up with the configuration
+	state, err := t.newState(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	t.states.Put(state)
+	return t, nil
+}
+
+func (t *Transform) newState(ctx context.Context) (*lua.LState, error) {
+	state := lua.NewState(lua.Options{SkipOpenLibs: true})
+	for _, lib := range transformLibs {
+		state.Push(state.NewFunction(lib.open))
+		state.Push(lua.LString(lib.name))
+		state.Call(1, 0)
+	}
+	// The base library also loads files and writes to stdout
+	for _, name := range []string{"dofile", "loadfile", "print"} {
+		state.SetGlobal(name, lua.LNil)
+	}
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	state.SetContext(ctx)
+	defer state.RemoveContext()
+	state.Push(state.NewFunctionFromProto(t.proto))
+	if err := state.PCall(0, 0, nil); err != nil {
+		state.Close()
+		return nil, err
+	}
+	return state, nil
+}
+
+// call calls the function name of the script, if the script defines it, with the table build returns. apply then
+// applies the changes the function made to the table.
+func (t *Transform) call(
+	ctx context.Context,
+	name string,
+	build func(state *lua.LState) (*lua.LTable, error),
+	apply func(table *lua.LTable) error,
+) error {
+	state, ok := t.states.Get().(*lua.LState)
+	if !ok {
+		var err error
+		if state, err = t.newState(ctx); err != nil {
+			return err
+		}
+	}
+	fn := state.GetGlobal(name)
+	if fn == lua.LNil {
+		t.states.Put(state)
+		return nil
+	}
+	table, err := build(state)
+	if err != nil {
+		t.states.Put(state)
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, t.timeout)
+	defer cancel()
+	state.SetContext(ctx)
+	err = state.CallByParam(lua.P{Fn: fn, Protect: true}, table)
+	state.RemoveContext()
+	if err != nil {
+		// The state may be left in the middle of the call
+		state.Close()
+		return err
+	}
+	t.states.Put(state)
+	return apply(table)
+}
+
+// TransformRequest runs on_request over req, and applies its changes to req.
+func (t *Transform) TransformRequest(req *http.Request) error {
+	if t == nil {
+		return nil
+	}
+	var body string
+	var withBody bool
+	build := func(state *lua.LState) (*lua.LTable, error) {
+		table := state.NewTable()
+		table.RawSetString("method", lua.LString(req.Method))
+		table.RawSetString("host", lua.LString(req.Host))
+		table.RawSetString("path", lua.LString(req.URL.Path))
+		table.RawSetString("query", lua.LString(req.URL.RawQuery))
+		table.RawSetString("headers", headersTable(state, req.Header))
+		var err error
+		if body, withBody, err = t.readBody(&req.Body); err != nil {
+			return nil, err
+		}
+		if withBody {
+			table.RawSetString("body", lua.LString(body))
+		}
+		return table, nil
+	}
+	apply := func(table *lua.LTable) error {
+		method, err := stringField(table, "method")
+		if err != nil {
+			return err
+		}
+		host, err := stringField(table, "host")
+		if err != nil {
+			return err
+		}
+		path, err := stringField(table, "path")
+		if err != nil {
+			return err
+		}
+		query, err := stringField(table, "query")
+		if err != nil {
+			return err
+		}
+		header, err := tableHeaders(table.RawGetString("headers"))
+		if err != nil {
+			return err
+		}
+		req.Method, req.Host, req.URL.RawQuery, req.Header = method, host, query, header
+		if path != req.URL.Path {
+			req.URL.Path = path
+			req.URL.RawPath = ""
+		}
+		// Setting a body replaces the body of the request, even if it wasn't given to the script
+		if newBody, ok := table.RawGetString("body").(lua.LString); ok && (!withBody || string(newBody) != body) {
+			req.Body = io.NopCloser(bytes.NewReader([]byte(newBody)))
+			req.ContentLength = int64(len(newBody))
+		}
+		return nil
+	}
+	return t.call(req.Context(), transformRequestFunc, build, apply)
+}
+
+// TransformResponse runs on_response over resp, the response to req, and applies its changes to resp. The bodies
+// of upgraded connections and compressed bodies aren't given to the script.
+func (t *Transform) TransformResponse(req *http.Request, resp *http.Response) error {
+	if t == nil {
+		return nil
+	}
+	upgraded := resp.StatusCode == http.StatusSwitchingProtocols
+	var body string
+	var withBody bool
+	build := func(state *lua.LState) (*lua.LTable, error) {
+		table := state.NewTable()
+		table.RawSetString("status", lua.LNumber(resp.StatusCode))
+		table.RawSetString("headers", headersTable(state, resp.Header))
+		if !upgraded && resp.Header.Get("Content-Encoding") == "" {
+			var err error
+			if body, withBody, err = t.readBody(&resp.Body); err != nil {
+				return nil, err
+			}
+		}
+		if withBody {
+			table.RawSetString("body", lua.LString(body))
+		}
+		return table, nil
+	}
+	apply := func(table *lua.LTable) error {
+		status, ok := table.RawGetString("status").(lua.LNumber)
+		if !ok || status < 100 || status > 599 {
+			return fmt.Errorf("%s set an invalid status %s", transformResponseFunc, table.RawGetString("status"))
+		}
+		if upgraded && int(status) != resp.StatusCode {
+			return fmt.Errorf("%s can't change the status of an upgraded connection", transformResponseFunc)
+		}
+		header, err := tableHeaders(table.RawGetString("headers"))
+		if err != nil {
+			return err
+		}
+		resp.StatusCode, resp.Header = int(status), header
+		if newBody, ok := table.RawGetString("body").(lua.LString); ok && !upgraded && (!withBody || string(newBody) != body) {
+			_ = resp.Body.Close()
+			resp.Body = io.NopCloser(bytes.NewReader([]byte(newBody)))
+			resp.ContentLength = int64(len(newBody))
+			resp.Header.Set("Content-Length", strconv.Itoa(len(newBody)))
+			resp.Header.Del("Content-Encoding")
+		}
+		return nil
+	}
+	return t.call(req.Context(), transformResponseFunc, build, apply)
+}
+
+// readBody reads the body if it is at most maxBodySize bytes. A larger body is put back together to be streamed.
+// Returns whether the body was read.
+func (t *Transform) readBody(body *io.ReadCloser) (string, bool, error) {
+	if t.maxBodySize <= 0 || *body == nil || *body == http.NoBody {
+		return "", false, nil
+	}
+	original := *body
+	content, err := io.ReadAll(io.LimitReader(original, t.maxBodySize+1))
+	if err != nil {
+		return "", false, err
+	}
+	if int64(len(content)) > t.maxBodySize {
+		*body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(content), original), original}
+		return "", false, nil
+	}
+	_ = original.Close()
+	*body = io.NopCloser(bytes.NewReader(content))
+	return string(content), true, nil
+}
+
+func headersTable(state *lua.LState, header http.Header) *lua.LTable {
+	table := state.NewTable()
+	for name, values := range header {
+		if len(values) == 1 {
+			table.RawSetString(name, lua.LString(values[0]))
+			continue
+		}
+		list := state.NewTable()
+		for _, value := range values {
+			list.Append(lua.LString(value))
+		}
+		table.RawSetString(name, list)
+	}
+	return table
+}
+
+func tableHeaders(value lua.LValue) (http.Header, error) {
+	table, ok := value.(*lua.LTable)
+	if !ok {
+		return nil, fmt.Errorf("headers must be a table, got %s", value.Type())
+	}
+	header := http.Header{}
+	var err error
+	table.ForEach(func(key, value lua.LValue) {
+		name, ok := key.(lua.LString)
+		if !ok {
+			err = fmt.Errorf("header names must be strings, got %s", key.Type())
+			return
+		}
+		switch value := value.(type) {
+		case lua.LString, lua.LNumber:
+			header.Add(string(name), value.String())
+		case *lua.LTable:
+			value.ForEach(func(_, v lua.LValue) {
+				header.Add(string(name), v.String())
+			})
+		default:
+			err = fmt.Errorf("header %s must be a string or a list of strings, got %s", name, value.Type())
+		}
+	})
+	return header, err
+}
+
+func stringField(table *lua.LTable, name string) (string, error) {
+	value, ok := table.RawGetString(name).(lua.LString)
+	if !ok {
+		return "", fmt.Errorf("%s must be a string, got %s", name, table.RawGetString(name).Type())
+	}
+	return string(value), nil
+}
@@ -0,0 +1,161 @@
+package ingress
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+)
+
+func newTestTransform(t *testing.T, script string, maxBodySize int64) *Transform {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "transform.lua")
+	require.NoError(t, os.WriteFile(path, []byte(script), 0o600))
+	transform, err := NewTransform(&config.TransformConfig{
+		Lua:         path,
+		MaxBodySize: maxBodySize,
+		Timeout:     &config.CustomDuration{Duration: 50 * time.Millisecond},
+	})
+	require.NoError(t, err)
+	return transform
+}
+
+func TestTransformRequest(t *testing.T) {
+	transform := newTestTransform(t, `
+local prefix = "/v2"
+
+function on_request(req)
+  req.headers["x-api-key"] = "minted-" .. req.host
+  req.headers["Cookie"] = nil
+  req.path = prefix .. req.path
+  req.query = req.query .. "&via=cloudflared"
+  req.body = string.upper(req.body)
+end
+`, 64)
+
+	req := httptest.NewRequest(http.MethodPost, "https://app.example.com/orders?id=1", strings.NewReader("hello"))
+	req.Header.Set("Cookie", "session=abc")
+	req.Header.Add("Accept", "text/html")
+	req.Header.Add("Accept", "application/json")
+	require.NoError(t, transform.TransformRequest(req))
+
+	assert.Equal(t, "minted-app.example.com", req.Header.Get("X-Api-Key"))
+	assert.Empty(t, req.Header.Values("Cookie"))
+	assert.Equal(t, []string{"text/html", "application/json"}, req.Header.Values("Accept"))
+	assert.Equal(t, "/v2/orders", req.URL.Path)
+	assert.Equal(t, "id=1&via=cloudflared", req.URL.RawQuery)
+	assert.EqualValues(t, 5, req.ContentLength)
+	body, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "HELLO", string(body))
+}
+
+func TestTransformLargeBody(t *testing.T) {
+	transform := newTestTransform(t, `
+function on_request(req)
+  req.headers["X-Body-Given"] = tostring(req.body ~= nil)
+end
+`, 4)
+
+	// Bodies larger than the limit are streamed to the origin untouched
+	req := httptest.NewRequest(http.MethodPost, "https://app.example.com", strings.NewReader("larger than 4 bytes"))
+	require.NoError(t, transform.TransformRequest(req))
+	assert.Equal(t, "false", req.Header.Get("X-Body-Given"))
+	body, err := io.ReadAll(req.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "larger than 4 bytes", string(body))
+}
+
+func TestTransformResponse(t *testing.T) {
+	transform := newTestTransform(t, `
+function on_response(resp)
+  if resp.status == 404 then
+    resp.status = 200
+    resp.body = "{}"
+    resp.headers["Content-Type"] = "application/json"
+  end
+  resp.headers["Server"] = nil
+end
+`, 64)
+
+	req := httptest.NewRequest(http.MethodGet, "https://app.example.com", nil)
+	resp := &http.Response{
+		StatusCode: http.StatusNotFound,
+		Header:     http.Header{"Server": []string{"legacy/1.0"}, "Content-Length": []string{"9"}},
+		Body:       io.NopCloser(strings.NewReader("not found")),
+	}
+	require.NoError(t, transform.TransformResponse(req, resp))
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	assert.Equal(t, "2", resp.Header.Get("Content-Length"))
+	assert.Empty(t, resp.Header.Get("Server"))
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "{}", string(body))
+
+	// Requests are left as they are when the script has no on_request
+	req = httptest.NewRequest(http.MethodGet, "https://app.example.com/path", nil)
+	require.NoError(t, transform.TransformRequest(req))
+	assert.Equal(t, "/path", req.URL.Path)
+
+	// A nil transform changes nothing
+	var disabled *Transform
+	require.NoError(t, disabled.TransformRequest(req))
+	require.NoError(t, disabled.TransformResponse(req, resp))
+}
+
+func TestTransformErrors(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "https://app.example.com", nil)
+
+	looping := newTestTransform(t, `function on_request(req) while true do end end`, 0)
+	require.Error(t, looping.TransformRequest(req))
+	// The state interrupted in the middle of the call is replaced
+	require.Error(t, looping.TransformRequest(req))
+
+	// Scripts can't reach files or processes
+	sandboxed := newTestTransform(t, `function on_request(req) os.execute("true") end`, 0)
+	require.Error(t, sandboxed.TransformRequest(req))
+	sandboxed = newTestTransform(t, `function on_request(req) dofile("/etc/passwd") end`, 0)
+	require.Error(t, sandboxed.TransformRequest(req))
+
+	invalidHeader := newTestTransform(t, `function on_request(req) req.headers["X-Flag"] = true end`, 0)
+	require.Error(t, invalidHeader.TransformRequest(req))
+
+	invalidStatus := newTestTransform(t, `function on_response(resp) resp.status = 42 end`, 0)
+	resp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Body: http.NoBody}
+	require.Error(t, invalidStatus.TransformResponse(req, resp))
+}
+
+func TestNewTransformInvalid(t *testing.T) {
+	transform, err := NewTransform(nil)
+	require.NoError(t, err)
+	require.Nil(t, transform)
+
+	path := filepath.Join(t.TempDir(), "transform.lua")
+	invalid := map[string]*config.TransformConfig{
+		"no script":      {},
+		"missing script": {Lua: path},
+	}
+	for name, cfg := range invalid {
+		_, err := NewTransform(cfg)
+		assert.Error(t, err, name)
+	}
+
+	for name, script := range map[string]string{
+		"syntax error":    "function on_request(req",
+		"top level error": `error("boom")`,
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(script), 0o600))
+		_, err := NewTransform(&config.TransformConfig{Lua: path})
+		assert.Error(t, err, name)
+	}
+}
@@ -0,0 +1,104 @@
+package mockedge
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/tunnelrpc"
+)
+
+func (e *Edge) serveHTTP2(ctx context.Context) error {
+	for {
+		conn, err := e.tcpListener.Accept()
+		if err != nil {
+			return err
+		}
+		go e.handleHTTP2(ctx, tls.Server(conn, e.serverTLS))
+	}
+}
+
+// handleHTTP2 opens the control stream on an HTTP/2 connection. cloudflared is the HTTP/2 server, so the edge
+// opens the control stream as a request and sends every proxied request the same way.
+func (e *Edge) handleHTTP2(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	transport := &http2.Transport{}
+	clientConn, err := transport.NewClientConn(conn)
+	if err != nil {
+		e.log.Debug().Err(err).Msg("Mock edge failed to establish HTTP/2 connection")
+		return
+	}
+	tunnelConn := &tunnelConn{
+		protocol: connection.HTTP2,
+		roundTrip: func(req *http.Request) (*http.Response, error) {
+			return http2RoundTrip(clientConn, req)
+		},
+		close: func() {
+			_ = conn.Close()
+		},
+	}
+	if !e.addConn(tunnelConn) {
+		return
+	}
+	defer e.removeConn(tunnelConn)
+
+	reqBodyReader, reqBodyWriter := io.Pipe()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost:8080/", reqBodyReader)
+	if err != nil {
+		return
+	}
+	req.Header.Set(connection.InternalUpgradeHeader, connection.ControlStreamUpgrade)
+	resp, err := clientConn.RoundTrip(req)
+	if err != nil {
+		e.log.Debug().Err(err).Msg("Mock edge failed to open HTTP/2 control stream")
+		return
+	}
+	controlStream := &http2ControlStream{
+		Reader: resp.Body,
+		Writer: reqBodyWriter,
+		body:   resp.Body,
+		pipe:   reqBodyWriter,
+	}
+	server := tunnelrpc.NewRegistrationServer(&registrationServer{edge: e, conn: tunnelConn})
+	if err := server.Serve(ctx, controlStream); err != nil {
+		e.log.Debug().Err(err).Msg("Mock edge HTTP/2 control stream terminated")
+	}
+}
+
+// http2RoundTrip proxies req over the HTTP/2 connection and restores the response headers cloudflared
+// serializes into a single header.
+func http2RoundTrip(clientConn *http2.ClientConn, req *http.Request) (*http.Response, error) {
+	resp, err := clientConn.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	userHeaders, err := connection.DeserializeHeaders(resp.Header.Get(connection.CanonicalResponseUserHeaders))
+	if err != nil {
+		_ = resp.Body.Close()
+		return nil, err
+	}
+	header := make(http.Header, len(userHeaders))
+	for _, h := range userHeaders {
+		header.Add(h.Name, h.Value)
+	}
+	resp.Header = header
+	return resp, nil
+}
+
+// http2ControlStream reads the response body and writes the request body of the control stream request.
+type http2ControlStream struct {
+	io.Reader
+	io.Writer
+	body io.Closer
+	pipe *io.PipeWriter
+}
+
+func (s *http2ControlStream) Close() error {
+	_ = s.pipe.Close()
+	return s.body.Close()
+}
@@ -0,0 +1,307 @@
+// Package mockedge is a minimal Cloudflare edge for tests. It accepts QUIC and HTTP/2 tunnel connections from
+// cloudflared, serves the registration RPCs over their control streams and proxies HTTP requests to cloudflared
+// through the registered connections, which is enough to run the whole supervisor against it.
+package mockedge
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/google/uuid"
+	"github.com/quic-go/quic-go"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
+)
+
+const (
+	defaultLocation = "MOCK"
+	// listenAttempts is how many UDP ports are tried before giving up on finding one with the same TCP port free.
+	listenAttempts = 10
+)
+
+var errNoRegisteredConnection = errors.New("mock edge has no registered connection")
+
+// Registration is a connection registration received by the mock edge.
+type Registration struct {
+	ConnIndex uint8
+	TunnelID  uuid.UUID
+	Protocol  connection.Protocol
+	Options   *pogs.ConnectionOptions
+	// Err is the error the registration was rejected with, if any.
+	Err error
+}
+
+// Config configures the behaviour of the mock edge.
+type Config struct {
+	// Location returned to cloudflared when a connection is registered. Defaults to MOCK.
+	Location string
+	// RegisterConnection decides the outcome of every registration; returning an error rejects it. Return an
+	// error with the connection.DuplicateConnectionError message to reject it as a duplicate connection.
+	// Every registration is accepted when nil.
+	RegisterConnection func(Registration) error
+	// Log defaults to a no-op logger.
+	Log *zerolog.Logger
+}
+
+// Edge listens on the same local port for QUIC over UDP and for HTTP/2 over TCP, as the real edge does, so it
+// can be given to the supervisor as a static edge address.
+type Edge struct {
+	cfg Config
+	log *zerolog.Logger
+
+	rootCAs      *x509.CertPool
+	quicListener *quic.Listener
+	tcpListener  net.Listener
+	serverTLS    *tls.Config
+
+	mu            sync.Mutex
+	conns         map[*tunnelConn]struct{}
+	registrations []Registration
+	localConfig   []byte
+	// changedC is closed and replaced every time the state above changes.
+	changedC chan struct{}
+	closed   bool
+}
+
+// New creates a mock edge listening on a random local port. Call Serve to start accepting connections.
+func New(cfg Config) (*Edge, error) {
+	if cfg.Location == "" {
+		cfg.Location = defaultLocation
+	}
+	log := cfg.Log
+	if log == nil {
+		nop := zerolog.Nop()
+		log = &nop
+	}
+	serverTLS, rootCAs, err := generateTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	edge := &Edge{
+		cfg:       cfg,
+		log:       log,
+		rootCAs:   rootCAs,
+		serverTLS: serverTLS,
+		conns:     make(map[*tunnelConn]struct{}),
+		changedC:  make(chan struct{}),
+	}
+	if err := edge.listen(); err != nil {
+		return nil, err
+	}
+	return edge, nil
+}
+
+func (e *Edge) listen() error {
+	var lastErr error
+	for i := 0; i < listenAttempts; i++ {
+		udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			return err
+		}
+		port := udpConn.LocalAddr().(*net.UDPAddr).Port
+		tcpListener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+		if err != nil {
+			_ = udpConn.Close()
+			lastErr = err
+			continue
+		}
+		quicListener, err := quic.Listen(udpConn, e.quicTLSConfig(), quicConfig)
+		if err != nil {
+			_ = udpConn.Close()
+			_ = tcpListener.Close()
+			return err
+		}
+		e.quicListener = quicListener
+		e.tcpListener = tcpListener
+		return nil
+	}
+	return fmt.Errorf("unable to listen on the same UDP and TCP port: %w", lastErr)
+}
+
+// Addr is the address to connect to the mock edge with either protocol.
+func (e *Edge) Addr() string {
+	return e.tcpListener.Addr().String()
+}
+
+// ClientTLSConfigs returns the TLS configurations cloudflared needs to trust the mock edge, by protocol.
+func (e *Edge) ClientTLSConfigs() map[connection.Protocol]*tls.Config {
+	configs := make(map[connection.Protocol]*tls.Config, len(connection.ProtocolList))
+	for _, protocol := range connection.ProtocolList {
+		settings := protocol.TLSSettings()
+		configs[protocol] = &tls.Config{
+			RootCAs:    e.rootCAs,
+			ServerName: settings.ServerName,
+			NextProtos: settings.NextProtos,
+			MinVersion: tls.VersionTLS12,
+		}
+	}
+	return configs
+}
+
+// Serve accepts tunnel connections until ctx is done or the edge is closed.
+func (e *Edge) Serve(ctx context.Context) error {
+	errC := make(chan error, 2)
+	go func() {
+		errC <- e.serveQUIC(ctx)
+	}()
+	go func() {
+		errC <- e.serveHTTP2(ctx)
+	}()
+	select {
+	case <-ctx.Done():
+		_ = e.Close()
+		return ctx.Err()
+	case err := <-errC:
+		_ = e.Close()
+		return err
+	}
+}
+
+// Close stops listening and closes every tunnel connection.
+func (e *Edge) Close() error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	e.mu.Unlock()
+
+	e.DropConnections()
+	return errors.Join(e.quicListener.Close(), e.tcpListener.Close())
+}
+
+// DropConnections closes every tunnel connection, forcing cloudflared to reconnect all of them at once.
+func (e *Edge) DropConnections() {
+	e.mu.Lock()
+	conns := make([]*tunnelConn, 0, len(e.conns))
+	for conn := range e.conns {
+		conns = append(conns, conn)
+	}
+	e.mu.Unlock()
+
+	for _, conn := range conns {
+		conn.close()
+	}
+}
+
+// Registrations returns every registration received so far, including rejected ones.
+func (e *Edge) Registrations() []Registration {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]Registration(nil), e.registrations...)
+}
+
+// LocalConfiguration returns the last configuration pushed by cloudflared.
+func (e *Edge) LocalConfiguration() []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.localConfig
+}
+
+// RegisteredConnections returns the protocol of every registered connection by connection index.
+func (e *Edge) RegisteredConnections() map[uint8]connection.Protocol {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.registeredConnectionsLocked()
+}
+
+func (e *Edge) registeredConnectionsLocked() map[uint8]connection.Protocol {
+	registered := make(map[uint8]connection.Protocol)
+	for conn := range e.conns {
+		if conn.registered {
+			registered[conn.connIndex] = conn.protocol
+		}
+	}
+	return registered
+}
+
+// WaitForConnections blocks until n connections are registered, or ctx is done.
+func (e *Edge) WaitForConnections(ctx context.Context, n int) error {
+	return e.waitFor(ctx, func() bool {
+		return len(e.registeredConnectionsLocked()) >= n
+	})
+}
+
+// WaitForRegistrations blocks until n registrations were received in total, or ctx is done.
+func (e *Edge) WaitForRegistrations(ctx context.Context, n int) error {
+	return e.waitFor(ctx, func() bool {
+		return len(e.registrations) >= n
+	})
+}
+
+func (e *Edge) waitFor(ctx context.Context, condition func() bool) error {
+	for {
+		e.mu.Lock()
+		done := condition()
+		changedC := e.changedC
+		e.mu.Unlock()
+		if done {
+			return nil
+		}
+		select {
+		case <-changedC:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// RoundTrip proxies req to cloudflared through one of the registered connections, as the edge does with
+// eyeball requests.
+func (e *Edge) RoundTrip(req *http.Request) (*http.Response, error) {
+	e.mu.Lock()
+	var conn *tunnelConn
+	for c := range e.conns {
+		if c.registered {
+			conn = c
+			break
+		}
+	}
+	e.mu.Unlock()
+	if conn == nil {
+		return nil, errNoRegisteredConnection
+	}
+	return conn.roundTrip(req)
+}
+
+func (e *Edge) addConn(conn *tunnelConn) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.closed {
+		return false
+	}
+	e.conns[conn] = struct{}{}
+	return true
+}
+
+func (e *Edge) removeConn(conn *tunnelConn) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	delete(e.conns, conn)
+	e.notifyLocked()
+}
+
+func (e *Edge) notifyLocked() {
+	close(e.changedC)
+	e.changedC = make(chan struct{})
+}
+
+// tunnelConn is a tunnel connection opened by cloudflared, with either protocol.
+type tunnelConn struct {
+	protocol   connection.Protocol
+	connIndex  uint8
+	registered bool
+	roundTrip  func(*http.Request) (*http.Response, error)
+	close      func()
+}
@@ -0,0 +1,155 @@
+package mockedge
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/tunnelrpc"
+	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
+	rpcquic "github.com/cloudflare/cloudflared/tunnelrpc/quic"
+)
+
+const httpStatusKey = "HttpStatus"
+
+var quicConfig = &quic.Config{
+	KeepAlivePeriod: 5 * time.Second,
+	EnableDatagrams: true,
+}
+
+func (e *Edge) quicTLSConfig() *tls.Config {
+	config := e.serverTLS.Clone()
+	config.NextProtos = connection.QUIC.TLSSettings().NextProtos
+	return config
+}
+
+func (e *Edge) serveQUIC(ctx context.Context) error {
+	for {
+		conn, err := e.quicListener.Accept(ctx)
+		if err != nil {
+			return err
+		}
+		go e.handleQUIC(ctx, conn)
+	}
+}
+
+// handleQUIC serves the control stream cloudflared opens first on every QUIC connection.
+func (e *Edge) handleQUIC(ctx context.Context, conn quic.Connection) {
+	defer func() {
+		_ = conn.CloseWithError(0, "")
+	}()
+	tunnelConn := &tunnelConn{
+		protocol: connection.QUIC,
+		roundTrip: func(req *http.Request) (*http.Response, error) {
+			return quicRoundTrip(conn, req)
+		},
+		close: func() {
+			_ = conn.CloseWithError(0, "dropped by mock edge")
+		},
+	}
+	if !e.addConn(tunnelConn) {
+		return
+	}
+	defer e.removeConn(tunnelConn)
+
+	controlStream, err := conn.AcceptStream(ctx)
+	if err != nil {
+		e.log.Debug().Err(err).Msg("Mock edge failed to accept QUIC control stream")
+		return
+	}
+	server := tunnelrpc.NewRegistrationServer(&registrationServer{edge: e, conn: tunnelConn})
+	if err := server.Serve(ctx, controlStream); err != nil {
+		e.log.Debug().Err(err).Msg("Mock edge QUIC control stream terminated")
+	}
+}
+
+// quicRoundTrip proxies req over a new QUIC stream, using the same connect request protocol as the edge.
+func quicRoundTrip(conn quic.Connection, req *http.Request) (*http.Response, error) {
+	stream, err := conn.OpenStreamSync(req.Context())
+	if err != nil {
+		return nil, err
+	}
+	requestStream := rpcquic.RequestClientStream{ReadWriteCloser: stream}
+
+	metadata := []pogs.Metadata{
+		{Key: connection.HTTPMethodKey, Val: req.Method},
+		{Key: connection.HTTPHostKey, Val: req.Host},
+	}
+	if req.ContentLength > 0 {
+		req.Header.Set("Content-Length", strconv.FormatInt(req.ContentLength, 10))
+	}
+	for name, values := range req.Header {
+		for _, value := range values {
+			metadata = append(metadata, pogs.Metadata{Key: fmt.Sprintf("%s:%s", connection.HTTPHeaderKey, name), Val: value})
+		}
+	}
+	if err := requestStream.WriteConnectRequestData(req.URL.String(), pogs.ConnectionTypeHTTP, metadata...); err != nil {
+		stream.CancelRead(0)
+		return nil, err
+	}
+	if req.Body != nil {
+		if _, err := io.Copy(stream, req.Body); err != nil {
+			stream.CancelRead(0)
+			return nil, err
+		}
+	}
+	// Close the write side, the response is read from the read side.
+	_ = stream.Close()
+
+	connectResponse, err := requestStream.ReadConnectResponseData()
+	if err != nil {
+		stream.CancelRead(0)
+		return nil, err
+	}
+	if connectResponse.Error != "" {
+		stream.CancelRead(0)
+		return nil, errors.New(connectResponse.Error)
+	}
+
+	resp := &http.Response{
+		Status:     http.StatusText(http.StatusOK),
+		StatusCode: http.StatusOK,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+		Body:       &quicResponseBody{stream: stream},
+		Request:    req,
+	}
+	headerPrefix := connection.HTTPHeaderKey + ":"
+	for _, m := range connectResponse.Metadata {
+		switch {
+		case m.Key == httpStatusKey:
+			if resp.StatusCode, err = strconv.Atoi(m.Val); err != nil {
+				stream.CancelRead(0)
+				return nil, fmt.Errorf("invalid status %q: %w", m.Val, err)
+			}
+			resp.Status = http.StatusText(resp.StatusCode)
+		case strings.HasPrefix(m.Key, headerPrefix):
+			resp.Header.Add(strings.TrimPrefix(m.Key, headerPrefix), m.Val)
+		}
+	}
+	return resp, nil
+}
+
+type quicResponseBody struct {
+	stream quic.Stream
+}
+
+func (b *quicResponseBody) Read(p []byte) (int, error) {
+	return b.stream.Read(p)
+}
+
+func (b *quicResponseBody) Close() error {
+	b.stream.CancelRead(0)
+	return nil
+}
@@ -0,0 +1,65 @@
+package mockedge
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+
+	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
+)
+
+// registrationServer serves the registration RPCs of a single tunnel connection.
+type registrationServer struct {
+	edge *Edge
+	conn *tunnelConn
+}
+
+func (s *registrationServer) RegisterConnection(
+	ctx context.Context,
+	auth pogs.TunnelAuth,
+	tunnelID uuid.UUID,
+	connIndex byte,
+	options *pogs.ConnectionOptions,
+) (*pogs.ConnectionDetails, error) {
+	registration := Registration{
+		ConnIndex: connIndex,
+		TunnelID:  tunnelID,
+		Protocol:  s.conn.protocol,
+		Options:   options,
+	}
+	if s.edge.cfg.RegisterConnection != nil {
+		registration.Err = s.edge.cfg.RegisterConnection(registration)
+	}
+
+	s.edge.mu.Lock()
+	defer s.edge.mu.Unlock()
+	s.edge.registrations = append(s.edge.registrations, registration)
+	if registration.Err == nil {
+		s.conn.connIndex = connIndex
+		s.conn.registered = true
+	}
+	s.edge.notifyLocked()
+
+	if registration.Err != nil {
+		return nil, registration.Err
+	}
+	return &pogs.ConnectionDetails{
+		UUID:     uuid.New(),
+		Location: s.edge.cfg.Location,
+	}, nil
+}
+
+func (s *registrationServer) UnregisterConnection(ctx context.Context) {
+	s.edge.mu.Lock()
+	defer s.edge.mu.Unlock()
+	s.conn.registered = false
+	s.edge.notifyLocked()
+}
+
+func (s *registrationServer) UpdateLocalConfiguration(ctx context.Context, config []byte) error {
+	s.edge.mu.Lock()
+	defer s.edge.mu.Unlock()
+	s.edge.localConfig = config
+	s.edge.notifyLocked()
+	return nil
+}
@@ -0,0 +1,70 @@
+// Package lockdown implements the read-only lockdown mode of cloudflared, for appliances that ship it in a verified
+// configuration: nothing may change the running configuration or the binary, neither remote configurations, fleet
+// configuration refreshes and updates, nor the commands of stdin control and the admin endpoints of the metrics
+// server. Rejected attempts are logged and recorded in the audit log.
+package lockdown
+
+import (
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/audit"
+)
+
+// ErrLockdown is the error the attempts to change cloudflared in lockdown mode are rejected with.
+var ErrLockdown = errors.New("rejected: cloudflared runs in read-only lockdown mode")
+
+// Lockdown rejects the attempts to change a running cloudflared. A nil Lockdown rejects nothing, so that callers
+// don't need to check whether lockdown mode is enabled.
+type Lockdown struct {
+	auditLog *audit.Log
+	log      *zerolog.Logger
+}
+
+// New returns the Lockdown of a cloudflared, nil if lockdown mode isn't enabled.
+func New(enabled bool, auditLog *audit.Log, log *zerolog.Logger) *Lockdown {
+	if !enabled {
+		return nil
+	}
+	log.Info().Msg("Running in read-only lockdown mode: remote configurations, fleet configuration refreshes, " +
+		"updates, stdin control and the admin endpoints of the metrics server are disabled")
+	return &Lockdown{
+		auditLog: auditLog,
+		log:      log,
+	}
+}
+
+// Enabled returns whether lockdown mode is enabled.
+func (l *Lockdown) Enabled() bool {
+	return l != nil
+}
+
+// Check returns ErrLockdown in lockdown mode, after logging and auditing the attempt of actor to take action.
+func (l *Lockdown) Check(action, actor string, details map[string]string) error {
+	if l == nil {
+		return nil
+	}
+	event := l.log.Warn().Str("action", action).Str("actor", actor)
+	for key, value := range details {
+		event = event.Str(key, value)
+	}
+	event.Msg("Rejected an attempt to change cloudflared in lockdown mode")
+	l.auditLog.Record(action, actor, ErrLockdown, details)
+	return ErrLockdown
+}
+
+// Handler rejects the requests to next with 403 Forbidden in lockdown mode, as local attempts to take action.
+func (l *Lockdown) Handler(action string, next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := l.Check(action, audit.ActorLocal, map[string]string{"remoteAddr": r.RemoteAddr}); err != nil {
+			http.Error(w, "ERR: "+err.Error(), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
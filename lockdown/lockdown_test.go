@@ -0,0 +1,59 @@
+package lockdown
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/audit"
+)
+
+func TestLockdownRejects(t *testing.T) {
+	log := zerolog.Nop()
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.Open(auditPath, &log)
+	require.NoError(t, err)
+	defer auditLog.Close()
+
+	lock := New(true, auditLog, &log)
+	assert.True(t, lock.Enabled())
+	assert.ErrorIs(t, lock.Check(audit.ActionConfigApply, audit.ActorRemote, map[string]string{"version": "3"}), ErrLockdown)
+
+	handled := false
+	handler := lock.Handler(audit.ActionPrepareReconnect, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		handled = true
+	}))
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/prepare-reconnect", nil))
+	assert.False(t, handled)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+	assert.Contains(t, recorder.Body.String(), ErrLockdown.Error())
+
+	content, err := os.ReadFile(auditPath)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"action":"config_apply","actor":"remote","outcome":"failure"`)
+	assert.Contains(t, lines[1], `"action":"prepare_reconnect","actor":"local","outcome":"failure"`)
+}
+
+func TestLockdownDisabled(t *testing.T) {
+	log := zerolog.Nop()
+	lock := New(false, nil, &log)
+	assert.Nil(t, lock)
+	assert.False(t, lock.Enabled())
+	assert.NoError(t, lock.Check(audit.ActionReconnect, audit.ActorLocal, nil))
+
+	handled := false
+	lock.Handler(audit.ActionPrepareReconnect, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		handled = true
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/prepare-reconnect", nil))
+	assert.True(t, handled)
+}
@@ -6,6 +6,9 @@ import (
 
 var defaultConfig = createDefaultConfig()
 
+// defaultTransportLogFilename is distinct from the application log's, so both can share a directory.
+const defaultTransportLogFilename = "cloudflared-transport.log"
+
 // Logging configuration
 type Config struct {
 	ConsoleConfig *ConsoleConfig // If nil, the logger will not log into the console
@@ -13,6 +16,8 @@ type Config struct {
 	RollingConfig *RollingConfig // If nil, the logger will not use a rolling log
 
 	MinLevel string // debug | info | error | fatal
+
+	disableManagement bool // If true, the logger will not stream its events to the management logger
 }
 
 type ConsoleConfig struct {
@@ -94,6 +99,51 @@ func CreateConfig(
 		FileConfig:    file,
 		RollingConfig: rolling,
 
+		disableManagement: true,
+
+		MinLevel: minLevel,
+	}
+}
+
+// RollingLimits bounds the files of a rolling log. Zero values use lumberjack's defaults: 100 megabytes per file,
+// and old files are kept forever.
+type RollingLimits struct {
+	MaxSize    int // megabytes
+	MaxBackups int // files
+	MaxAge     int // days
+}
+
+// CreateTransportConfig creates the configuration of a transport logger that logs apart from the application, to a
+// file or to a directory of rolling files, and never to the console nor to the management logger.
+func CreateTransportConfig(
+	minLevel string,
+	rollingLogPath, nonRollingLogFilePath string,
+	limits RollingLimits,
+) *Config {
+	var file *FileConfig
+	var rolling *RollingConfig
+	if nonRollingLogFilePath != "" {
+		file = createFileConfig(nonRollingLogFilePath)
+	} else if rollingLogPath != "" {
+		rolling = &RollingConfig{
+			Dirname:    rollingLogPath,
+			Filename:   defaultTransportLogFilename,
+			maxSize:    limits.MaxSize,
+			maxBackups: limits.MaxBackups,
+			maxAge:     limits.MaxAge,
+		}
+	}
+
+	if minLevel == "" {
+		minLevel = defaultConfig.MinLevel
+	}
+
+	return &Config{
+		FileConfig:    file,
+		RollingConfig: rolling,
+
+		disableManagement: true,
+
 		MinLevel: minLevel,
 	}
 }
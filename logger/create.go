@@ -17,6 +17,7 @@ import (
 
 	cfdflags "github.com/cloudflare/cloudflared/cmd/cloudflared/flags"
 	"github.com/cloudflare/cloudflared/management"
+	"github.com/cloudflare/cloudflared/redact"
 )
 
 const (
@@ -61,11 +62,12 @@ type resilientMultiWriter struct {
 }
 
 func (t resilientMultiWriter) Write(p []byte) (n int, err error) {
+	redacted := redact.Bytes(p)
 	for _, w := range t.writers {
-		_, _ = w.Write(p)
+		_, _ = w.Write(redacted)
 	}
 	if t.managementWriter != nil {
-		_, _ = t.managementWriter.Write(p)
+		_, _ = t.managementWriter.Write(redacted)
 	}
 	return len(p), nil
 }
@@ -73,13 +75,15 @@ func (t resilientMultiWriter) Write(p []byte) (n int, err error) {
 func (t resilientMultiWriter) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
 	// Only write the event to normal writers if it exceeds the level, but always write to the
 	// management logger and let it decided with the provided level of the log event.
+	// Secrets are scrubbed from every event before it is written anywhere.
+	redacted := redact.Bytes(p)
 	if t.level <= level {
 		for _, w := range t.writers {
-			_, _ = w.Write(p)
+			_, _ = w.Write(redacted)
 		}
 	}
 	if t.managementWriter != nil {
-		_, _ = t.managementWriter.WriteLevel(level, p)
+		_, _ = t.managementWriter.WriteLevel(level, redacted)
 	}
 	return len(p), nil
 }
@@ -111,7 +115,10 @@ func newZerolog(loggerConfig *Config) *zerolog.Logger {
 		writers = append(writers, rollingLogger)
 	}
 
-	managementWriter := ManagementLogger
+	var managementWriter zerolog.LevelWriter
+	if !loggerConfig.disableManagement {
+		managementWriter = ManagementLogger
+	}
 
 	level, levelErr := zerolog.ParseLevel(loggerConfig.MinLevel)
 	if levelErr != nil {
@@ -128,8 +135,32 @@ func newZerolog(loggerConfig *Config) *zerolog.Logger {
 	return &log
 }
 
+// CreateTransportLoggerFromContext creates the logger of transport events, e.g. QUIC frames and control stream
+// messages. They go along with the application logs, including the management log stream, unless a transport log
+// file or directory is configured, then they are only written there.
 func CreateTransportLoggerFromContext(c *cli.Context, disableTerminal bool) *zerolog.Logger {
-	return createFromContext(c, cfdflags.TransportLogLevel, cfdflags.LogDirectory, disableTerminal)
+	logFile := c.String(cfdflags.TransportLogFile)
+	logDirectory := c.String(cfdflags.TransportLogDirectory)
+	if logFile == "" && logDirectory == "" {
+		return createFromContext(c, cfdflags.TransportLogLevel, cfdflags.LogDirectory, disableTerminal)
+	}
+
+	loggerConfig := CreateTransportConfig(
+		c.String(cfdflags.TransportLogLevel),
+		logDirectory,
+		logFile,
+		RollingLimits{
+			MaxSize:    c.Int(cfdflags.TransportLogMaxSize),
+			MaxBackups: c.Int(cfdflags.TransportLogMaxBackups),
+			MaxAge:     c.Int(cfdflags.TransportLogMaxAge),
+		},
+	)
+
+	log := newZerolog(loggerConfig)
+	if incompatibleFlagsSet := logFile != "" && logDirectory != ""; incompatibleFlagsSet {
+		log.Error().Msgf("Your config includes values for both %s (%s) and %s (%s), but they are incompatible. %s takes precedence.", cfdflags.TransportLogFile, logFile, cfdflags.TransportLogDirectory, logDirectory, cfdflags.TransportLogFile)
+	}
+	return log
 }
 
 func CreateLoggerFromContext(c *cli.Context, disableTerminal bool) *zerolog.Logger {
@@ -182,6 +213,7 @@ func Create(loggerConfig *Config) *zerolog.Logger {
 			nil,
 			nil,
 			defaultConfig.MinLevel,
+			false,
 		}
 	}
 	return newZerolog(loggerConfig)
@@ -206,11 +238,24 @@ type fileInitializer struct {
 }
 
 var (
-	singleFileInit   fileInitializer
-	rotatingFileInit fileInitializer
+	fileInitsLock sync.Mutex
+	// fileInits lets loggers that log to the same file share its writer
+	fileInits = map[string]*fileInitializer{}
 )
 
+func getFileInitializer(path string) *fileInitializer {
+	fileInitsLock.Lock()
+	defer fileInitsLock.Unlock()
+	init, ok := fileInits[path]
+	if !ok {
+		init = &fileInitializer{}
+		fileInits[path] = init
+	}
+	return init
+}
+
 func createFileWriter(config FileConfig) (io.Writer, error) {
+	singleFileInit := getFileInitializer(config.Fullpath())
 	singleFileInit.once.Do(func() {
 		var logFile io.Writer
 		fullpath := config.Fullpath()
@@ -255,6 +300,7 @@ func createDirFile(config FileConfig) (io.Writer, error) {
 }
 
 func createRollingLogger(config RollingConfig) (io.Writer, error) {
+	rotatingFileInit := getFileInitializer(filepath.Join(config.Dirname, config.Filename))
 	rotatingFileInit.once.Do(func() {
 		if err := os.MkdirAll(config.Dirname, dirPermMode); err != nil {
 			rotatingFileInit.creationError = err
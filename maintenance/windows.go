@@ -0,0 +1,121 @@
+// Package maintenance restricts the disruptive actions of cloudflared, such as restarting after an autoupdate or
+// reconnecting every connection, to the maintenance windows configured by the operator.
+package maintenance
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+const (
+	// DefaultOverride is how long an emergency override lasts when no duration is given.
+	DefaultOverride = time.Hour
+	// checkInterval is how often Wait checks the windows, matching their minute granularity
+	checkInterval = time.Minute
+)
+
+var errNoWindows = errors.New("no maintenance windows are configured")
+
+// Windows tells whether disruptive actions are allowed. They are while one of the windows is open, in the local
+// time of the connector, or during an emergency override. A nil Windows always allows them.
+type Windows struct {
+	windows []connection.ScheduleWindow
+	now     func() time.Time
+
+	mu            sync.Mutex
+	overrideUntil time.Time
+	// overriddenC is closed and replaced on every override, to wake up everyone waiting for a window
+	overriddenC chan struct{}
+}
+
+// Override describes an emergency override of the maintenance windows.
+type Override struct {
+	Until time.Time `json:"until"`
+}
+
+// New returns the Windows allowing disruptive actions during windows, or nil if there are none.
+func New(windows []connection.ScheduleWindow) *Windows {
+	if len(windows) == 0 {
+		return nil
+	}
+	return &Windows{
+		windows:     windows,
+		now:         time.Now,
+		overriddenC: make(chan struct{}),
+	}
+}
+
+// Open tells whether disruptive actions are allowed now.
+func (w *Windows) Open() bool {
+	if w == nil {
+		return true
+	}
+	now := w.now()
+	w.mu.Lock()
+	overridden := now.Before(w.overrideUntil)
+	w.mu.Unlock()
+	if overridden {
+		return true
+	}
+	for _, window := range w.windows {
+		if window.Active(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// Override allows disruptive actions outside of the windows for duration, or DefaultOverride if it isn't positive.
+// Overriding again restarts the override.
+func (w *Windows) Override(duration time.Duration) (*Override, error) {
+	if w == nil {
+		return nil, errNoWindows
+	}
+	if duration <= 0 {
+		duration = DefaultOverride
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.overrideUntil = w.now().Add(duration)
+	close(w.overriddenC)
+	w.overriddenC = make(chan struct{})
+	return &Override{Until: w.overrideUntil}, nil
+}
+
+// OverrideJSON is Override, with the outcome encoded as JSON.
+func (w *Windows) OverrideJSON(duration time.Duration) ([]byte, error) {
+	override, err := w.Override(duration)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(override)
+}
+
+// Overridden returns a channel that is closed on the next override. Returns nil for a nil Windows.
+func (w *Windows) Overridden() <-chan struct{} {
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.overriddenC
+}
+
+// Wait blocks until disruptive actions are allowed, or ctx is done.
+func (w *Windows) Wait(ctx context.Context) error {
+	for !w.Open() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-w.Overridden():
+		case <-time.After(checkInterval):
+		}
+	}
+	return nil
+}
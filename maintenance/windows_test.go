@@ -0,0 +1,92 @@
+package maintenance
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+func newTestWindows(t *testing.T, now *time.Time) *Windows {
+	t.Helper()
+	// Every day from 02:00 to 04:00
+	window, err := connection.NewScheduleWindow("0 2 * * *", 2*time.Hour)
+	require.NoError(t, err)
+	w := New([]connection.ScheduleWindow{window})
+	w.now = func() time.Time { return *now }
+	return w
+}
+
+func TestWindowsOpen(t *testing.T) {
+	now := time.Date(2024, 3, 4, 1, 59, 0, 0, time.Local)
+	w := newTestWindows(t, &now)
+	assert.False(t, w.Open())
+
+	now = time.Date(2024, 3, 4, 2, 0, 0, 0, time.Local)
+	assert.True(t, w.Open())
+	now = time.Date(2024, 3, 4, 3, 59, 59, 0, time.Local)
+	assert.True(t, w.Open())
+	now = time.Date(2024, 3, 4, 4, 0, 0, 0, time.Local)
+	assert.False(t, w.Open())
+}
+
+func TestWindowsOverride(t *testing.T) {
+	now := time.Date(2024, 3, 4, 12, 0, 0, 0, time.Local)
+	w := newTestWindows(t, &now)
+	overridden := w.Overridden()
+
+	override, err := w.Override(0)
+	require.NoError(t, err)
+	assert.Equal(t, now.Add(DefaultOverride), override.Until)
+	assert.True(t, w.Open())
+	select {
+	case <-overridden:
+	default:
+		assert.Fail(t, "override was not signaled")
+	}
+	assert.NotEqual(t, overridden, w.Overridden())
+
+	now = now.Add(DefaultOverride)
+	assert.False(t, w.Open())
+
+	json, err := w.OverrideJSON(time.Minute)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"until":"`+now.Add(time.Minute).Format(time.RFC3339Nano)+`"}`, string(json))
+}
+
+func TestWindowsWait(t *testing.T) {
+	now := time.Date(2024, 3, 4, 12, 0, 0, 0, time.Local)
+	w := newTestWindows(t, &now)
+
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, w.Wait(ctx), context.DeadlineExceeded)
+
+	waitErrC := make(chan error)
+	go func() {
+		waitErrC <- w.Wait(t.Context())
+	}()
+	_, err := w.Override(time.Minute)
+	require.NoError(t, err)
+	select {
+	case err := <-waitErrC:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "Wait did not return after the override")
+	}
+}
+
+func TestNilWindows(t *testing.T) {
+	assert.Nil(t, New(nil))
+
+	var w *Windows
+	assert.True(t, w.Open())
+	assert.NoError(t, w.Wait(t.Context()))
+	assert.Nil(t, w.Overridden())
+	_, err := w.Override(time.Minute)
+	assert.ErrorIs(t, err, errNoWindows)
+}
@@ -55,6 +55,8 @@ type StreamingFilters struct {
 	Events   []LogEventType `json:"events,omitempty"`
 	Level    *LogLevel      `json:"level,omitempty"`
 	Sampling float64        `json:"sampling,omitempty"`
+	// Fields of the log events to send, all of them when empty
+	Fields []string `json:"fields,omitempty"`
 }
 
 // EventStopStreaming signifies that the client wishes to halt receiving log events.
@@ -80,6 +82,8 @@ const (
 	HTTP
 	TCP
 	UDP
+	// Access events summarize every HTTP request, they are only sent to sessions requesting them explicitly.
+	Access
 )
 
 func ParseLogEventType(s string) (LogEventType, bool) {
@@ -92,6 +96,8 @@ func ParseLogEventType(s string) (LogEventType, bool) {
 		return TCP, true
 	case "udp":
 		return UDP, true
+	case "access":
+		return Access, true
 	}
 	return -1, false
 }
@@ -106,6 +112,8 @@ func (l LogEventType) String() string {
 		return "tcp"
 	case UDP:
 		return "udp"
+	case Access:
+		return "access"
 	default:
 		return ""
 	}
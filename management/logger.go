@@ -1,6 +1,7 @@
 package management
 
 import (
+	"fmt"
 	"os"
 	"sync"
 	"time"
@@ -11,11 +12,23 @@ import (
 
 var json = jsoniter.ConfigFastest
 
+const (
+	// Bounds how many log events are buffered for an interrupted session, the oldest are dropped first.
+	offlineBufferSize = 1000
+	// How long the log events are buffered for the actor of an interrupted session to start streaming again.
+	offlineBufferTTL = 10 * time.Minute
+)
+
 // Logger manages the number of management streaming log sessions
 type Logger struct {
 	sessions []*session
 	mu       sync.RWMutex
 
+	// Log events buffered while the session of an actor is interrupted, e.g. by an outage of the connections to the
+	// edge, to replay them when the actor starts streaming again instead of leaving a gap in the logs they see.
+	offline   *offlineBuffer
+	offlineMu sync.Mutex
+
 	// Unique logger that isn't a io.Writer of the list of zerolog writers. This helps prevent management log
 	// statements from creating infinite recursion to export messages to a session and allows basic debugging and
 	// error statements to be issued in the management code itself.
@@ -37,10 +50,14 @@ type LoggerListener interface {
 	ActiveSession(actor) *session
 	// ActiveSession returns the count of active sessions.
 	ActiveSessions() int
-	// Listen appends the session to the list of sessions that receive log events.
-	Listen(*session)
+	// Listen appends the session to the list of sessions that receive log events and returns the log events buffered
+	// since the previous session of the same actor was interrupted.
+	Listen(*session) []*Log
 	// Remove a session from the available sessions that were receiving log events.
 	Remove(*session)
+	// Interrupt removes a session that stopped receiving log events without the actor asking for it and buffers the
+	// log events until the actor starts streaming again.
+	Interrupt(*session)
 }
 
 func (l *Logger) ActiveSession(actor actor) *session {
@@ -66,11 +83,12 @@ func (l *Logger) ActiveSessions() int {
 	return count
 }
 
-func (l *Logger) Listen(session *session) {
+func (l *Logger) Listen(session *session) []*Log {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 	session.active.Store(true)
 	l.sessions = append(l.sessions, session)
+	return l.resume(session)
 }
 
 func (l *Logger) Remove(session *session) {
@@ -91,14 +109,74 @@ func (l *Logger) Remove(session *session) {
 	l.sessions = l.sessions[:len(l.sessions)-1]
 }
 
+func (l *Logger) Interrupt(session *session) {
+	l.Remove(session)
+	l.offlineMu.Lock()
+	defer l.offlineMu.Unlock()
+	l.offline = &offlineBuffer{
+		actor: session.actor,
+		since: time.Now(),
+	}
+}
+
+// buffering returns if the log events are buffered for an interrupted session, forgetting the session once the
+// actor didn't start streaming again in time.
+func (l *Logger) buffering() bool {
+	l.offlineMu.Lock()
+	defer l.offlineMu.Unlock()
+	return l.bufferingLocked()
+}
+
+func (l *Logger) bufferingLocked() bool {
+	if l.offline != nil && time.Since(l.offline.since) > offlineBufferTTL {
+		l.offline = nil
+	}
+	return l.offline != nil
+}
+
+func (l *Logger) buffer(event *Log) {
+	l.offlineMu.Lock()
+	defer l.offlineMu.Unlock()
+	if l.offline != nil {
+		l.offline.insert(event)
+	}
+}
+
+// resume returns the log events buffered for the actor of the session that match its filters, and stops buffering.
+func (l *Logger) resume(session *session) []*Log {
+	l.offlineMu.Lock()
+	defer l.offlineMu.Unlock()
+	if !l.bufferingLocked() || l.offline.actor.ID != session.actor.ID {
+		return nil
+	}
+	offline := l.offline
+	l.offline = nil
+	var events []*Log
+	if offline.dropped > 0 {
+		events = append(events, &Log{
+			Time:    time.Now().UTC().Format(zerolog.TimeFieldFormat),
+			Level:   Warn,
+			Event:   Cloudflared,
+			Message: fmt.Sprintf("%d log events were dropped while the log stream was interrupted", offline.dropped),
+		})
+	}
+	for _, event := range offline.events {
+		if event, ok := session.filter(event); ok {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
 // Write will write the log event to all sessions that have available capacity. For those that are full, the message
 // will be dropped.
 // This function is the interface that zerolog expects to call when a log event is to be written out.
 func (l *Logger) Write(p []byte) (int, error) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
-	// return early if no active sessions
-	if len(l.sessions) == 0 {
+	buffering := l.buffering()
+	// return early if no active sessions and no interrupted session
+	if len(l.sessions) == 0 && !buffering {
 		return len(p), nil
 	}
 	event, err := parseZerologEvent(p)
@@ -110,6 +188,9 @@ func (l *Logger) Write(p []byte) (int, error) {
 	for _, session := range l.sessions {
 		session.Insert(event)
 	}
+	if buffering {
+		l.buffer(event)
+	}
 	return len(p), nil
 }
 
@@ -117,6 +198,22 @@ func (l *Logger) WriteLevel(level zerolog.Level, p []byte) (n int, err error) {
 	return l.Write(p)
 }
 
+// offlineBuffer holds the latest log events since the session of actor was interrupted.
+type offlineBuffer struct {
+	actor   actor
+	since   time.Time
+	events  []*Log
+	dropped int
+}
+
+func (b *offlineBuffer) insert(event *Log) {
+	if len(b.events) == offlineBufferSize {
+		b.events = b.events[1:]
+		b.dropped++
+	}
+	b.events = append(b.events, event)
+}
+
 func parseZerologEvent(p []byte) (*Log, error) {
 	var fields map[string]interface{}
 	iter := json.BorrowIterator(p)
@@ -3,6 +3,7 @@ package management
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
@@ -92,6 +93,63 @@ func TestLoggerWrite_MultipleSessions(t *testing.T) {
 	}
 }
 
+// Validate the events logged while a session is interrupted are replayed to the next session of the same actor
+func TestLoggerInterrupt_Replay(t *testing.T) {
+	logger := NewLogger()
+	zlog := zerolog.New(logger).With().Timestamp().Logger().Level(zerolog.InfoLevel)
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	interrupted := newSession(logWindow, actor{ID: actorID}, cancel)
+	logger.Listen(interrupted)
+	logger.Interrupt(interrupted)
+	assert.Equal(t, 0, logger.ActiveSessions())
+	zlog.Info().Int(EventTypeKey, int(HTTP)).Msg("while interrupted")
+	zlog.Info().Int(EventTypeKey, int(TCP)).Msg("filtered out")
+
+	// Another actor doesn't receive the buffered events
+	other := newSession(logWindow, actor{ID: "other"}, cancel)
+	assert.Empty(t, logger.Listen(other))
+	logger.Remove(other)
+
+	session := newSession(logWindow, actor{ID: actorID}, cancel)
+	session.Filters(&StreamingFilters{Events: []LogEventType{HTTP}})
+	buffered := logger.Listen(session)
+	defer logger.Remove(session)
+	require.Len(t, buffered, 1)
+	assert.Equal(t, "while interrupted", buffered[0].Message)
+
+	// The events are only replayed once
+	logger.Remove(session)
+	assert.Empty(t, logger.Listen(session))
+}
+
+// Validate the buffer of an interrupted session is bounded and forgotten after a while
+func TestLoggerInterrupt_Bounded(t *testing.T) {
+	logger := NewLogger()
+	zlog := zerolog.New(logger).With().Timestamp().Logger().Level(zerolog.InfoLevel)
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	interrupted := newSession(logWindow, actor{ID: actorID}, cancel)
+	logger.Interrupt(interrupted)
+	for i := 0; i < offlineBufferSize+2; i++ {
+		zlog.Info().Int("i", i).Msg("hello")
+	}
+	session := newSession(logWindow, actor{ID: actorID}, cancel)
+	buffered := logger.Listen(session)
+	logger.Remove(session)
+	require.Len(t, buffered, offlineBufferSize+1)
+	assert.Equal(t, Warn, buffered[0].Level)
+	assert.Equal(t, "2 log events were dropped while the log stream was interrupted", buffered[0].Message)
+	assert.Equal(t, float64(2), buffered[1].Fields["i"])
+
+	logger.Interrupt(session)
+	logger.offline.since = time.Now().Add(-offlineBufferTTL - time.Second)
+	zlog.Info().Msg("hello")
+	assert.Empty(t, logger.Listen(session))
+}
+
 type mockWriter struct {
 	event *Log
 	err   error
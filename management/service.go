@@ -2,11 +2,13 @@ package management
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"net/http/pprof"
 	"os"
+	"strconv"
 	"sync"
 	"time"
 
@@ -16,6 +18,9 @@ import (
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog"
 	"nhooyr.io/websocket"
+
+	"github.com/cloudflare/cloudflared/audit"
+	"github.com/cloudflare/cloudflared/har"
 )
 
 const (
@@ -29,6 +34,12 @@ const (
 	// There is a limited idle time while not actively serving a session for a request before dropping the connection.
 	StatusIdleLimitExceeded websocket.StatusCode = 4003
 	reasonIdleLimitExceeded                      = "session was idle for too long"
+
+	// Bounds of the traffic captures, which are streamed to the client as the requests are proxied
+	defaultCaptureDuration = 10 * time.Second
+	maxCaptureDuration     = 5 * time.Minute
+	defaultCaptureSize     = 10 * 1024 * 1024
+	maxCaptureSize         = 100 * 1024 * 1024
 )
 
 var (
@@ -52,7 +63,9 @@ type ManagementService struct {
 	label     string
 
 	// Additional Handlers
-	metricsHandler http.Handler
+	metricsHandler     http.Handler
+	connectionFeatures ConnectionFeaturesGetter
+	harRecorder        *har.Recorder
 
 	log    *zerolog.Logger
 	router chi.Router
@@ -62,6 +75,7 @@ type ManagementService struct {
 	// to validate this before setting streaming to true.
 	streamingMut sync.Mutex
 	logger       LoggerListener
+	auditLog     *audit.Log
 }
 
 func New(managementHostname string,
@@ -71,15 +85,21 @@ func New(managementHostname string,
 	label string,
 	log *zerolog.Logger,
 	logger LoggerListener,
+	auditLog *audit.Log,
+	connectionFeatures ConnectionFeaturesGetter,
+	harRecorder *har.Recorder,
 ) *ManagementService {
 	s := &ManagementService{
-		Hostname:       managementHostname,
-		log:            log,
-		logger:         logger,
-		serviceIP:      serviceIP,
-		clientID:       clientID,
-		label:          label,
-		metricsHandler: promhttp.Handler(),
+		Hostname:           managementHostname,
+		log:                log,
+		logger:             logger,
+		auditLog:           auditLog,
+		serviceIP:          serviceIP,
+		clientID:           clientID,
+		label:              label,
+		metricsHandler:     promhttp.Handler(),
+		connectionFeatures: connectionFeatures,
+		harRecorder:        harRecorder,
 	}
 	r := chi.NewRouter()
 	r.Use(ValidateAccessTokenQueryMiddleware)
@@ -89,6 +109,7 @@ func New(managementHostname string,
 	r.With(corsHandler).Head("/ping", ping)
 	r.Get("/logs", s.logs)
 	r.With(corsHandler).Get("/host_details", s.getHostDetails)
+	r.With(corsHandler).Get("/features", s.getFeatures)
 
 	// Diagnostic management services
 	if enableDiagServices {
@@ -96,6 +117,11 @@ func New(managementHostname string,
 		r.With(corsHandler).Get("/metrics", s.metricsHandler.ServeHTTP)
 		// Supports only heap and goroutine
 		r.With(corsHandler).Get("/debug/pprof/{profile:heap|goroutine}", pprof.Index)
+		// Captures of the proxied requests, as HAR files
+		if harRecorder != nil {
+			r.With(corsHandler).Get("/capture", s.capture)
+			r.With(corsHandler).Get("/failed_requests", s.failedRequests)
+		}
 	}
 
 	s.router = r
@@ -132,6 +158,83 @@ func (m *ManagementService) getHostDetails(w http.ResponseWriter, r *http.Reques
 	json.NewEncoder(w).Encode(getHostDetailsResponse)
 }
 
+// ConnectionFeatures are the features a connection of the tunnel registered with
+type ConnectionFeatures struct {
+	Index           uint8    `json:"index"`
+	Features        []string `json:"features"`
+	DatagramVersion string   `json:"datagram_version"`
+	PostQuantum     string   `json:"post_quantum"`
+}
+
+// ConnectionFeaturesGetter provides the features of the connections currently registered
+type ConnectionFeaturesGetter interface {
+	GetConnectionFeatures() []ConnectionFeatures
+}
+
+// The response provided by the /features endpoint
+type getFeaturesResponse struct {
+	Connections []ConnectionFeatures `json:"connections"`
+}
+
+func (m *ManagementService) getFeatures(w http.ResponseWriter, r *http.Request) {
+	response := getFeaturesResponse{
+		Connections: []ConnectionFeatures{},
+	}
+	if m.connectionFeatures != nil {
+		response.Connections = append(response.Connections, m.connectionFeatures.GetConnectionFeatures()...)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(200)
+	json.NewEncoder(w).Encode(response)
+}
+
+// capture streams a HAR file of the requests proxied to the hostname query parameter, or to any hostname, for the
+// duration parameter or until they reach max_size bytes. Captures are HAR only, packet captures (PCAP) of the
+// private network traffic are out of scope.
+func (m *ManagementService) capture(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	duration := defaultCaptureDuration
+	if param := query.Get("duration"); param != "" {
+		var err error
+		if duration, err = time.ParseDuration(param); err != nil || duration <= 0 || duration > maxCaptureDuration {
+			http.Error(w, fmt.Sprintf("duration must be a positive duration of at most %s", maxCaptureDuration), http.StatusBadRequest)
+			return
+		}
+	}
+	maxSize := defaultCaptureSize
+	if param := query.Get("max_size"); param != "" {
+		var err error
+		if maxSize, err = strconv.Atoi(param); err != nil || maxSize <= 0 || maxSize > maxCaptureSize {
+			http.Error(w, fmt.Sprintf("max_size must be a positive number of bytes of at most %d", maxCaptureSize), http.StatusBadRequest)
+			return
+		}
+	}
+	hostname := query.Get("hostname")
+	m.log.Info().Str("hostname", hostname).Dur("duration", duration).Msg("Capturing the proxied requests")
+
+	writeHARHeaders(w, "capture.har")
+	if err := m.harRecorder.Capture(r.Context(), w, hostname, duration, maxSize); err != nil {
+		m.log.Err(err).Msg("Failed to stream the capture of the proxied requests")
+	}
+}
+
+// failedRequests answers with a HAR file of the last failed requests.
+func (m *ManagementService) failedRequests(w http.ResponseWriter, r *http.Request) {
+	writeHAR(w, "failed-requests.har", m.harRecorder.Failures())
+}
+
+func writeHAR(w http.ResponseWriter, filename string, file har.File) {
+	writeHARHeaders(w, filename)
+	json.NewEncoder(w).Encode(file)
+}
+
+func writeHARHeaders(w http.ResponseWriter, filename string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(200)
+}
+
 func (m *ManagementService) getLabel() string {
 	if m.label != "" {
 		return fmt.Sprintf("custom:%s", m.label)
@@ -183,7 +286,22 @@ func (m *ManagementService) readEvents(c *websocket.Conn, ctx context.Context, e
 }
 
 // streamLogs will begin the process of reading from the Session listener and write the log events to the client.
-func (m *ManagementService) streamLogs(c *websocket.Conn, ctx context.Context, session *session) {
+// The log events buffered while the previous session of the actor was interrupted are sent first.
+func (m *ManagementService) streamLogs(c *websocket.Conn, ctx context.Context, session *session, buffered []*Log) {
+	if len(buffered) > 0 {
+		err := WriteEvent(c, ctx, &EventLog{
+			ServerEvent: ServerEvent{Type: Logs},
+			Logs:        buffered,
+		})
+		if err != nil {
+			if !IsClosed(err, m.log) {
+				m.log.Err(err).Send()
+				m.log.Err(c.Close(websocket.StatusInternalError, err.Error())).Send()
+			}
+			session.Stop()
+			return
+		}
+	}
 	for session.Active() {
 		select {
 		case <-ctx.Done():
@@ -244,6 +362,16 @@ func (m *ManagementService) parseFilters(c *websocket.Conn, event *ClientEvent,
 	return true
 }
 
+// auditLogStream records the start of a log stream, whose level filter changes the logs cloudflared emits to the
+// session.
+func (m *ManagementService) auditLogStream(actor actor, filters *StreamingFilters, err error) {
+	details := map[string]string{}
+	if filters != nil && filters.Level != nil {
+		details["level"] = filters.Level.String()
+	}
+	m.auditLog.Record(audit.ActionLogStream, actor.ID, err, details)
+}
+
 // Management Streaming Logs accept handler
 func (m *ManagementService) logs(w http.ResponseWriter, r *http.Request) {
 	c, err := websocket.Accept(w, r, &websocket.AcceptOptions{
@@ -282,11 +410,18 @@ func (m *ManagementService) logs(w http.ResponseWriter, r *http.Request) {
 
 	session := newSession(logWindow, claims.Actor, cancel)
 	defer m.logger.Remove(session)
+	// Whether the actor asked to stream logs and didn't ask to stop
+	streaming := false
 
 	for {
 		select {
 		case <-ctx.Done():
 			m.log.Debug().Msgf("management logs: context cancelled")
+			// The request was cancelled while streaming, rather than the session being preempted by another session
+			// of the actor, so keep the logs until the actor reconnects.
+			if streaming && r.Context().Err() != nil {
+				m.logger.Interrupt(session)
+			}
 			c.Close(websocket.StatusNormalClosure, "context closed")
 			return
 		case event := <-events:
@@ -302,19 +437,23 @@ func (m *ManagementService) logs(w http.ResponseWriter, r *http.Request) {
 				}
 				// Make sure the session can start
 				if !m.canStartStream(session) {
+					m.auditLogStream(claims.Actor, startEvent.Filters, errors.New(reasonSessionLimitExceeded))
 					m.log.Err(c.Close(StatusSessionLimitExceeded, reasonSessionLimitExceeded)).Send()
 					return
 				}
+				m.auditLogStream(claims.Actor, startEvent.Filters, nil)
 				session.Filters(startEvent.Filters)
-				m.logger.Listen(session)
+				buffered := m.logger.Listen(session)
+				streaming = true
 				m.log.Debug().Msgf("Streaming logs")
-				go m.streamLogs(c, ctx, session)
+				go m.streamLogs(c, ctx, session, buffered)
 				continue
 			case StopStreaming:
 				idle.Reset(idleTimeout)
 				// Stop the current session for the current actor who requested it
 				session.Stop()
 				m.logger.Remove(session)
+				streaming = false
 			case UnknownClientEventType:
 				fallthrough
 			default:
@@ -15,6 +15,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"nhooyr.io/websocket"
 
+	"github.com/cloudflare/cloudflared/har"
 	"github.com/cloudflare/cloudflared/internal/test"
 )
 
@@ -24,7 +25,7 @@ var (
 )
 
 func TestDisableDiagnosticRoutes(t *testing.T) {
-	mgmt := New("management.argotunnel.com", false, "1.1.1.1:80", uuid.Nil, "", &noopLogger, nil)
+	mgmt := New("management.argotunnel.com", false, "1.1.1.1:80", uuid.Nil, "", &noopLogger, nil, nil, nil, nil)
 	for _, path := range []string{"/metrics", "/debug/pprof/goroutine", "/debug/pprof/heap"} {
 		t.Run(strings.Replace(path, "/", "_", -1), func(t *testing.T) {
 			req := httptest.NewRequest("GET", managementHostname+path+"?access_token="+validToken, nil)
@@ -36,6 +37,79 @@ func TestDisableDiagnosticRoutes(t *testing.T) {
 	}
 }
 
+func TestHARRoutes(t *testing.T) {
+	recorder := har.NewRecorder("test", har.DefaultMaxFailures)
+	mgmt := New("management.argotunnel.com", true, "1.1.1.1:80", uuid.Nil, "", &noopLogger, nil, nil, nil, recorder)
+
+	for _, query := range []string{"duration=1h", "duration=-1s", "max_size=0", "max_size=abc"} {
+		req := httptest.NewRequest("GET", managementHostname+"/capture?access_token="+validToken+"&"+query, nil)
+		resp := httptest.NewRecorder()
+		mgmt.ServeHTTP(resp, req)
+		require.Equal(t, http.StatusBadRequest, resp.Code, query)
+	}
+
+	req := httptest.NewRequest("GET", managementHostname+"/capture?access_token="+validToken+"&duration=10ms&hostname=app.example.com", nil)
+	resp := httptest.NewRecorder()
+	mgmt.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.JSONEq(t, `{"log":{"version":"1.2","creator":{"name":"cloudflared","version":"test"},"entries":[]}}`, resp.Body.String())
+
+	recorder.Record(har.Exchange{Request: httptest.NewRequest("GET", "https://app.example.com/orders", nil), Status: http.StatusBadGateway})
+	failedReq := httptest.NewRequest("GET", managementHostname+"/failed_requests?access_token="+validToken, nil)
+	resp = httptest.NewRecorder()
+	mgmt.ServeHTTP(resp, failedReq)
+	require.Equal(t, http.StatusOK, resp.Code)
+	require.Contains(t, resp.Body.String(), `"url":"https://app.example.com/orders"`)
+
+	// Captures are diagnostic services
+	mgmt = New("management.argotunnel.com", false, "1.1.1.1:80", uuid.Nil, "", &noopLogger, nil, nil, nil, recorder)
+	resp = httptest.NewRecorder()
+	mgmt.ServeHTTP(resp, req)
+	require.Equal(t, http.StatusNotFound, resp.Code)
+	resp = httptest.NewRecorder()
+	mgmt.ServeHTTP(resp, failedReq)
+	require.Equal(t, http.StatusNotFound, resp.Code)
+}
+
+type staticConnectionFeatures []ConnectionFeatures
+
+func (f staticConnectionFeatures) GetConnectionFeatures() []ConnectionFeatures {
+	return f
+}
+
+func TestFeaturesRoute(t *testing.T) {
+	connections := staticConnectionFeatures{{
+		Index:           1,
+		Features:        []string{"support_datagram_v2", "management_logs"},
+		DatagramVersion: "support_datagram_v2",
+		PostQuantum:     "disabled",
+	}}
+	for _, test := range []struct {
+		name     string
+		features ConnectionFeaturesGetter
+		expected string
+	}{
+		{
+			name:     "no_connections",
+			expected: `{"connections":[]}`,
+		},
+		{
+			name:     "connections",
+			features: connections,
+			expected: `{"connections":[{"index":1,"features":["support_datagram_v2","management_logs"],"datagram_version":"support_datagram_v2","post_quantum":"disabled"}]}`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			mgmt := New("management.argotunnel.com", false, "1.1.1.1:80", uuid.Nil, "", &noopLogger, nil, nil, test.features, nil)
+			req := httptest.NewRequest("GET", managementHostname+"/features?access_token="+validToken, nil)
+			recorder := httptest.NewRecorder()
+			mgmt.ServeHTTP(recorder, req)
+			require.Equal(t, http.StatusOK, recorder.Code)
+			require.JSONEq(t, test.expected, recorder.Body.String())
+		})
+	}
+}
+
 func TestReadEventsLoop(t *testing.T) {
 	sentEvent := EventStartStreaming{
 		ClientEvent: ClientEvent{Type: StartStreaming},
@@ -68,25 +68,48 @@ func (s *session) Filters(filters *StreamingFilters) {
 // Insert attempts to insert the log to the session. If the log event matches the provided session filters, it
 // will be applied to the listener.
 func (s *session) Insert(log *Log) {
+	log, ok := s.filter(log)
+	if !ok {
+		return
+	}
+	select {
+	case s.listener <- log:
+	default:
+		// buffer is full, discard
+	}
+}
+
+// filter returns the log with only the requested fields, or false if the log event doesn't match the session filters.
+func (s *session) filter(log *Log) (*Log, bool) {
 	// Level filters are optional
 	if s.filters.Level != nil {
 		if *s.filters.Level > log.Level {
-			return
+			return nil, false
 		}
 	}
-	// Event filters are optional
+	// Event filters are optional, except for access events which are only sent when requested
 	if len(s.filters.Events) != 0 && !contains(s.filters.Events, log.Event) {
-		return
+		return nil, false
+	}
+	if log.Event == Access && !contains(s.filters.Events, Access) {
+		return nil, false
 	}
 	// Sampling is also optional
 	if s.sampler != nil && !s.sampler.Sample() {
-		return
+		return nil, false
 	}
-	select {
-	case s.listener <- log:
-	default:
-		// buffer is full, discard
+	// The log is shared with the other sessions, so it's copied to keep only the requested fields
+	if len(s.filters.Fields) != 0 {
+		selected := *log
+		selected.Fields = make(map[string]interface{}, len(s.filters.Fields))
+		for _, field := range s.filters.Fields {
+			if value, ok := log.Fields[field]; ok {
+				selected.Fields[field] = value
+			}
+		}
+		log = &selected
 	}
+	return log, true
 }
 
 // Active returns if the session is active
@@ -117,6 +117,39 @@ func TestSession_Insert(t *testing.T) {
 	}
 }
 
+// Validate that access events are only sent to sessions requesting them
+func TestSession_InsertAccess(t *testing.T) {
+	_, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	log := Log{
+		Time:    time.Now().UTC().Format(time.RFC3339),
+		Event:   Access,
+		Level:   Debug,
+		Message: "GET example.com/ 200",
+		Fields:  map[string]interface{}{"status": float64(200), "duration": float64(12), "path": "/"},
+	}
+
+	session := newSession(4, actor{}, cancel)
+	session.Filters(nil)
+	session.Insert(&log)
+	select {
+	case <-session.listener:
+		require.Fail(t, "access events are only sent when requested")
+	default:
+	}
+
+	session.Filters(&StreamingFilters{Events: []LogEventType{Access}, Fields: []string{"status", "duration"}})
+	session.Insert(&log)
+	select {
+	case event := <-session.listener:
+		require.Equal(t, map[string]interface{}{"status": float64(200), "duration": float64(12)}, event.Fields)
+		// The fields are selected on a copy of the log
+		require.Len(t, log.Fields, 3)
+	default:
+		require.Fail(t, "expected the access event")
+	}
+}
+
 // Validate that the session has a max amount of events to hold
 func TestSession_InsertOverflow(t *testing.T) {
 	_, cancel := context.WithCancel(context.Background())
@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const bearerPrefix = "Bearer "
+
+// AuthConfig defines the optional access controls applied to every endpoint served by the
+// metrics listener. The zero value keeps the listener unauthenticated.
+type AuthConfig struct {
+	// BearerToken, when set, must be presented by clients in the Authorization header.
+	BearerToken string
+	// AllowedCIDRs restricts the remote addresses that may reach the listener.
+	AllowedCIDRs []netip.Prefix
+	// TLSConfig, when set, makes the listener serve HTTPS. Setting ClientCAs and ClientAuth
+	// on it enables mTLS.
+	TLSConfig *tls.Config
+}
+
+// Enabled returns true if any form of access control was configured.
+func (c AuthConfig) Enabled() bool {
+	return c.BearerToken != "" || len(c.AllowedCIDRs) > 0 || c.TLSConfig != nil
+}
+
+// Authenticates returns true if clients must prove who they are, either with the bearer token or with a
+// client certificate. An address allowlist alone doesn't count, the listener may be reachable from a shared network.
+func (c AuthConfig) Authenticates() bool {
+	return c.BearerToken != "" || (c.TLSConfig != nil && c.TLSConfig.ClientAuth == tls.RequireAndVerifyClientCert)
+}
+
+// ParseAllowedCIDRs parses a list of CIDRs or single IP addresses into prefixes.
+func ParseAllowedCIDRs(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, value := range values {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			addr, err := netip.ParseAddr(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid metrics allowed address %q: %w", value, err)
+			}
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid metrics allowed CIDR %q: %w", value, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// newAuthHandler wraps next with the checks described by config. Requests from addresses outside the
+// allowlist are rejected with 403 and requests without the expected bearer token with 401.
+func newAuthHandler(next http.Handler, config AuthConfig) http.Handler {
+	if config.BearerToken == "" && len(config.AllowedCIDRs) == 0 {
+		return next
+	}
+	expectedAuth := []byte(bearerPrefix + config.BearerToken)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(config.AllowedCIDRs) > 0 && !remoteAddrAllowed(r.RemoteAddr, config.AllowedCIDRs) {
+			http.Error(w, "Forbidden", http.StatusForbidden)
+			return
+		}
+		if config.BearerToken != "" {
+			if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expectedAuth) != 1 {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="cloudflared"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireAuthentication rejects every request to next with 403 unless config authenticates clients. It guards
+// the endpoints that change the state of the tunnel, so they are never reachable on an open metrics listener.
+func requireAuthentication(next http.Handler, config AuthConfig) http.Handler {
+	if config.Authenticates() {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, fmt.Sprintf("ERR: %s requires the metrics server to authenticate clients with a token or client certificates", r.URL.Path), http.StatusForbidden)
+	})
+}
+
+func remoteAddrAllowed(remoteAddr string, allowed []netip.Prefix) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range allowed {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
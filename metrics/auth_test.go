@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAllowedCIDRs(t *testing.T) {
+	prefixes, err := ParseAllowedCIDRs([]string{"10.0.0.0/8", "192.168.1.7", "2001:db8::1/32", " "})
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("192.168.1.7/32"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}, prefixes)
+
+	_, err = ParseAllowedCIDRs([]string{"not-an-ip"})
+	assert.Error(t, err)
+}
+
+func TestAuthHandler(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	handler := newAuthHandler(next, AuthConfig{
+		BearerToken:  "secret",
+		AllowedCIDRs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")},
+	})
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		authHeader string
+		expected   int
+	}{
+		{"allowed with token", "10.1.2.3:5000", "Bearer secret", http.StatusOK},
+		{"allowed ipv4-mapped with token", "[::ffff:10.1.2.3]:5000", "Bearer secret", http.StatusOK},
+		{"allowed without token", "10.1.2.3:5000", "", http.StatusUnauthorized},
+		{"allowed with wrong token", "10.1.2.3:5000", "Bearer wrong", http.StatusUnauthorized},
+		{"outside allowlist", "172.16.0.1:5000", "Bearer secret", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			req.RemoteAddr = test.remoteAddr
+			if test.authHeader != "" {
+				req.Header.Set("Authorization", test.authHeader)
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, req)
+			assert.Equal(t, test.expected, rec.Code)
+		})
+	}
+}
+
+func TestAuthHandlerDisabled(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	assert.False(t, AuthConfig{}.Enabled())
+	handler := newAuthHandler(next, AuthConfig{})
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestRequireAuthentication(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name     string
+		config   AuthConfig
+		expected int
+	}{
+		{name: "no auth", config: AuthConfig{}, expected: http.StatusForbidden},
+		{name: "allowlist only", config: AuthConfig{AllowedCIDRs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}, expected: http.StatusForbidden},
+		{name: "tls without client certificates", config: AuthConfig{TLSConfig: &tls.Config{}}, expected: http.StatusForbidden},
+		{name: "bearer token", config: AuthConfig{BearerToken: "secret"}, expected: http.StatusOK},
+		{name: "mtls", config: AuthConfig{TLSConfig: &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert}}, expected: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			requireAuthentication(next, test.config).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/prepare-reconnect", nil))
+			assert.Equal(t, test.expected, rec.Code)
+		})
+	}
+}
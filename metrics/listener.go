@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/facebookgo/grace/gracenet"
+)
+
+const unixPrefix = "unix:"
+
+// ListenerSpec is an additional listener of the metrics server, parsed from `ADDRESS[;OPTION=VALUE...]` where ADDRESS
+// is HOST:PORT, [IPV6]:PORT or unix:PATH, and the options are:
+//   - family=ipv4|ipv6 binds a host name such as localhost to the addresses of one family only
+//   - auth=none serves the listener without access controls
+//   - token-file=PATH requires the bearer token read from PATH
+//   - allowed-cidr=CIDR only allows clients from CIDR, and may be repeated
+//   - tls-cert=PATH, tls-key=PATH and client-ca=PATH serve the listener over TLS, with mTLS if client-ca is set
+//
+// A listener without any of the auth options has the same access controls as the main metrics listener.
+type ListenerSpec struct {
+	Network string
+	Address string
+
+	// Inherit is set when the listener has the access controls of the main metrics listener
+	Inherit      bool
+	TokenFile    string
+	AllowedCIDRs []string
+	TLSCert      string
+	TLSKey       string
+	ClientCA     string
+}
+
+// ParseListenerSpec parses the spec of an additional listener of the metrics server.
+func ParseListenerSpec(spec string) (ListenerSpec, error) {
+	fields := strings.Split(spec, ";")
+	address := strings.TrimSpace(fields[0])
+	listener := ListenerSpec{Network: "tcp", Address: address, Inherit: true}
+	if path, ok := strings.CutPrefix(address, unixPrefix); ok {
+		if path == "" {
+			return ListenerSpec{}, fmt.Errorf("metrics listener %q is missing the path of the unix socket", spec)
+		}
+		listener.Network, listener.Address = "unix", path
+	} else if _, _, err := net.SplitHostPort(address); err != nil {
+		return ListenerSpec{}, fmt.Errorf("invalid address of metrics listener %q: %w", spec, err)
+	}
+
+	authNone := false
+	for _, option := range fields[1:] {
+		key, value, ok := strings.Cut(strings.TrimSpace(option), "=")
+		if !ok || value == "" {
+			return ListenerSpec{}, fmt.Errorf("invalid option %q of metrics listener %q, expected OPTION=VALUE", option, spec)
+		}
+		switch key {
+		case "family":
+			if listener.Network == "unix" {
+				return ListenerSpec{}, fmt.Errorf("metrics listener %q on a unix socket has no address family", spec)
+			}
+			switch value {
+			case "ipv4":
+				listener.Network = "tcp4"
+			case "ipv6":
+				listener.Network = "tcp6"
+			default:
+				return ListenerSpec{}, fmt.Errorf("invalid family %q of metrics listener %q, expected ipv4 or ipv6", value, spec)
+			}
+		case "auth":
+			if value != "none" {
+				return ListenerSpec{}, fmt.Errorf("invalid auth %q of metrics listener %q, expected none", value, spec)
+			}
+			authNone = true
+		case "token-file":
+			listener.TokenFile = value
+		case "allowed-cidr":
+			if listener.Network == "unix" {
+				return ListenerSpec{}, fmt.Errorf("metrics listener %q on a unix socket can't restrict client addresses, restrict the permissions of the socket instead", spec)
+			}
+			listener.AllowedCIDRs = append(listener.AllowedCIDRs, value)
+		case "tls-cert":
+			listener.TLSCert = value
+		case "tls-key":
+			listener.TLSKey = value
+		case "client-ca":
+			listener.ClientCA = value
+		default:
+			return ListenerSpec{}, fmt.Errorf("unknown option %q of metrics listener %q", key, spec)
+		}
+	}
+
+	hasAuth := listener.TokenFile != "" || len(listener.AllowedCIDRs) > 0 || listener.TLSCert != "" || listener.TLSKey != "" || listener.ClientCA != ""
+	if authNone && hasAuth {
+		return ListenerSpec{}, fmt.Errorf("metrics listener %q can't both have auth=none and access controls", spec)
+	}
+	listener.Inherit = !authNone && !hasAuth
+	return listener, nil
+}
+
+// Listen opens the listener of the spec.
+func (s ListenerSpec) Listen(listeners *gracenet.Net) (net.Listener, error) {
+	if s.Network == "unix" {
+		return listenUnix(listeners, s.Address)
+	}
+	listener, err := listeners.Listen(s.Network, s.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to bind to address (%s): %w", s.Address, err)
+	}
+	return listener, nil
+}
+
+// InheritedAuth returns the access controls of the main metrics listener applied to the listener of the spec. Unix
+// sockets don't have client addresses, their access is controlled by the permissions of the socket file instead.
+func (s ListenerSpec) InheritedAuth(main AuthConfig) AuthConfig {
+	if s.Network == "unix" {
+		main.AllowedCIDRs = nil
+	}
+	return main
+}
+
+// listenUnix listens on the unix socket at path, only accessible to its owner. A socket left behind by a process that
+// didn't exit cleanly is replaced, as long as nothing listens on it anymore.
+func listenUnix(listeners *gracenet.Net, path string) (net.Listener, error) {
+	listener, err := listeners.Listen("unix", path)
+	if err != nil {
+		info, statErr := os.Stat(path)
+		if statErr != nil || info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("failed to listen on unix socket (%s): %w", path, err)
+		}
+		if conn, dialErr := net.Dial("unix", path); dialErr == nil {
+			_ = conn.Close()
+			return nil, fmt.Errorf("failed to listen on unix socket (%s): %w", path, err)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale unix socket (%s): %w", path, err)
+		}
+		if listener, err = listeners.Listen("unix", path); err != nil {
+			return nil, fmt.Errorf("failed to listen on unix socket (%s): %w", path, err)
+		}
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		_ = listener.Close()
+		return nil, fmt.Errorf("failed to restrict the permissions of unix socket (%s): %w", path, err)
+	}
+	return listener, nil
+}
@@ -0,0 +1,95 @@
+package metrics
+
+import (
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/facebookgo/grace/gracenet"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseListenerSpec(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected ListenerSpec
+	}{
+		{
+			spec:     "[::1]:20300",
+			expected: ListenerSpec{Network: "tcp", Address: "[::1]:20300", Inherit: true},
+		},
+		{
+			spec:     "localhost:20300;family=ipv6;auth=none",
+			expected: ListenerSpec{Network: "tcp6", Address: "localhost:20300"},
+		},
+		{
+			spec: "0.0.0.0:20300;token-file=/etc/cloudflared/metrics-token;allowed-cidr=10.0.0.0/8;allowed-cidr=192.168.0.0/16",
+			expected: ListenerSpec{
+				Network:      "tcp",
+				Address:      "0.0.0.0:20300",
+				TokenFile:    "/etc/cloudflared/metrics-token",
+				AllowedCIDRs: []string{"10.0.0.0/8", "192.168.0.0/16"},
+			},
+		},
+		{
+			spec:     "unix:/run/cloudflared/metrics.sock;tls-cert=cert.pem;tls-key=key.pem;client-ca=ca.pem",
+			expected: ListenerSpec{Network: "unix", Address: "/run/cloudflared/metrics.sock", TLSCert: "cert.pem", TLSKey: "key.pem", ClientCA: "ca.pem"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.spec, func(t *testing.T) {
+			spec, err := ParseListenerSpec(test.spec)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, spec)
+		})
+	}
+
+	for _, invalid := range []string{
+		"20300",
+		"unix:",
+		"localhost:20300;family=ipv5",
+		"unix:/run/metrics.sock;family=ipv4",
+		"unix:/run/metrics.sock;allowed-cidr=10.0.0.0/8",
+		"localhost:20300;auth=none;token-file=/etc/token",
+		"localhost:20300;token-file",
+		"localhost:20300;unknown=value",
+	} {
+		_, err := ParseListenerSpec(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestListenerSpecInheritedAuth(t *testing.T) {
+	main := AuthConfig{BearerToken: "secret", AllowedCIDRs: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}}
+	tcp, err := ParseListenerSpec("[::1]:20300")
+	require.NoError(t, err)
+	assert.Equal(t, main, tcp.InheritedAuth(main))
+
+	unix, err := ParseListenerSpec("unix:/run/cloudflared/metrics.sock")
+	require.NoError(t, err)
+	assert.Equal(t, AuthConfig{BearerToken: "secret"}, unix.InheritedAuth(main))
+}
+
+func TestListenUnixReplacesStaleSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.sock")
+	// A socket file left behind without anything listening on it
+	stale, err := net.Listen("unix", path)
+	require.NoError(t, err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	require.NoError(t, stale.Close())
+
+	listeners := gracenet.Net{}
+	listener, err := CreateMetricsListener(&listeners, "unix:"+path)
+	require.NoError(t, err)
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	// A socket in use isn't replaced
+	_, err = CreateMetricsListener(&listeners, "unix:"+path)
+	assert.Error(t, err)
+	require.NoError(t, listener.Close())
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/audit"
+)
+
+type maintenanceOverrider interface {
+	OverrideJSON(duration time.Duration) ([]byte, error)
+}
+
+// maintenanceOverrideHandler allows disruptive actions outside of the maintenance windows in an emergency. The
+// optional duration query parameter is how long the override lasts, e.g. 30m, the default override is used without it.
+func maintenanceOverrideHandler(overrider maintenanceOverrider, auditLog *audit.Log, log *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var duration time.Duration
+		if value := r.URL.Query().Get("duration"); value != "" {
+			var err error
+			if duration, err = time.ParseDuration(value); err != nil || duration <= 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = fmt.Fprintf(w, "ERR: invalid duration %q", value)
+				return
+			}
+		}
+		json, err := overrider.OverrideJSON(duration)
+		auditLog.Record(audit.ActionMaintenanceOverride, audit.ActorLocal, err, map[string]string{
+			"remoteAddr": r.RemoteAddr,
+			"duration":   duration.String(),
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusConflict)
+			_, _ = fmt.Fprintf(w, "ERR: %v", err)
+			return
+		}
+		log.Warn().Str("remoteAddr", r.RemoteAddr).Msg("Maintenance windows overridden, disruptive actions are allowed now")
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(json)
+	}
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockMaintenanceOverrider struct {
+	duration time.Duration
+	err      error
+}
+
+func (m *mockMaintenanceOverrider) OverrideJSON(duration time.Duration) ([]byte, error) {
+	m.duration = duration
+	if m.err != nil {
+		return nil, m.err
+	}
+	return []byte(`{"until":"2024-03-04T12:00:00Z"}`), nil
+}
+
+func TestMaintenanceOverrideHandler(t *testing.T) {
+	log := zerolog.Nop()
+	tests := []struct {
+		name             string
+		method           string
+		target           string
+		err              error
+		expectedCode     int
+		expectedDuration time.Duration
+	}{
+		{"default duration", http.MethodPost, "/maintenance-override", nil, http.StatusOK, 0},
+		{"duration", http.MethodPost, "/maintenance-override?duration=30m", nil, http.StatusOK, 30 * time.Minute},
+		{"invalid duration", http.MethodPost, "/maintenance-override?duration=later", nil, http.StatusBadRequest, 0},
+		{"negative duration", http.MethodPost, "/maintenance-override?duration=-1m", nil, http.StatusBadRequest, 0},
+		{"no windows", http.MethodPost, "/maintenance-override", errors.New("no maintenance windows are configured"), http.StatusConflict, 0},
+		{"get", http.MethodGet, "/maintenance-override", nil, http.StatusMethodNotAllowed, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			overrider := &mockMaintenanceOverrider{err: test.err}
+			rec := httptest.NewRecorder()
+			maintenanceOverrideHandler(overrider, nil, &log).ServeHTTP(rec, httptest.NewRequest(test.method, test.target, nil))
+			assert.Equal(t, test.expectedCode, rec.Code)
+			assert.Equal(t, test.expectedDuration, overrider.duration)
+			if test.expectedCode == http.StatusOK {
+				assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+				assert.JSONEq(t, `{"until":"2024-03-04T12:00:00Z"}`, rec.Body.String())
+			}
+		})
+	}
+}
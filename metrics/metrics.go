@@ -2,11 +2,13 @@ package metrics
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"net"
 	"net/http"
 	_ "net/http/pprof"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
 
@@ -16,7 +18,9 @@ import (
 	"github.com/rs/zerolog"
 	"golang.org/x/net/trace"
 
+	"github.com/cloudflare/cloudflared/audit"
 	"github.com/cloudflare/cloudflared/diagnostic"
+	"github.com/cloudflare/cloudflared/lockdown"
 )
 
 const (
@@ -57,6 +61,14 @@ type Config struct {
 	DiagnosticHandler   *diagnostic.Handler
 	QuickTunnelHostname string
 	Orchestrator        orchestrator
+	ReconnectPreparer   reconnectPreparer
+	Maintenance         maintenanceOverrider
+	Auth                AuthConfig
+	AuditLog            *audit.Log
+	// Lockdown rejects the requests to the admin endpoints, nil when lockdown mode is disabled
+	Lockdown *lockdown.Lockdown
+	// TunnelLabels are added to every metric served when set
+	TunnelLabels *TunnelLabels
 
 	ShutdownTimeout time.Duration
 }
@@ -71,7 +83,7 @@ func newMetricsHandler(
 ) *http.ServeMux {
 	router := http.NewServeMux()
 	router.Handle("/debug/", http.DefaultServeMux)
-	router.Handle("/metrics", promhttp.Handler())
+	router.Handle("/metrics", newPrometheusHandler(config.TunnelLabels))
 	router.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
 		_, _ = fmt.Fprintf(w, "OK\n")
 	})
@@ -94,18 +106,41 @@ func newMetricsHandler(
 		})
 	}
 
+	if config.ReconnectPreparer != nil {
+		// Preparing to reconnect changes the state of the tunnel, so it's only served to authenticated clients
+		router.Handle("/prepare-reconnect", requireAuthentication(config.Lockdown.Handler(audit.ActionPrepareReconnect, prepareReconnectHandler(config.ReconnectPreparer, config.AuditLog, log)), config.Auth))
+	}
+
+	if config.Maintenance != nil {
+		// Overriding the maintenance windows allows disruptive actions, so it's only served to authenticated clients
+		router.Handle("/maintenance-override", requireAuthentication(config.Lockdown.Handler(audit.ActionMaintenanceOverride, maintenanceOverrideHandler(config.Maintenance, config.AuditLog, log)), config.Auth))
+	}
+
 	config.DiagnosticHandler.InstallEndpoints(router)
 
 	return router
 }
 
+func newPrometheusHandler(tunnelLabels *TunnelLabels) http.Handler {
+	if tunnelLabels == nil {
+		return promhttp.Handler()
+	}
+	gatherer := newLabelingGatherer(prometheus.DefaultGatherer, tunnelLabels.labels())
+	return promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer, promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}),
+	)
+}
+
 // CreateMetricsListener will create a new [net.Listener] by using an
 // known set of ports when the default address is passed with the fallback
 // of choosing a random port when none is available.
 //
 // In case the provided address is not the default one then it will be used
-// as is.
+// as is, a unix:PATH address listening on the unix socket at PATH.
 func CreateMetricsListener(listeners *gracenet.Net, laddr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(laddr, unixPrefix); ok {
+		return listenUnix(listeners, path)
+	}
 	if laddr == GetMetricsDefaultAddress(Runtime) {
 		// On the presence of the default address select
 		// a port from the known set of addresses iteratively.
@@ -142,11 +177,14 @@ func ServeMetrics(
 	log *zerolog.Logger,
 ) (err error) {
 	var wg sync.WaitGroup
-	// Metrics port is privileged, so no need for further access control
+	// Metrics port is privileged and optionally guarded by config.Auth, so no need for further access control
 	trace.AuthRequest = func(*http.Request) (bool, bool) { return true, true }
 	// TODO: parameterize ReadTimeout and WriteTimeout. The maximum time we can
 	// profile CPU usage depends on WriteTimeout
-	h := newMetricsHandler(config, log)
+	h := newAuthHandler(newMetricsHandler(config, log), config.Auth)
+	if config.Auth.TLSConfig != nil {
+		l = tls.NewListener(l, config.Auth.TLSConfig)
+	}
 	server := &http.Server{
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
@@ -159,6 +197,9 @@ func ServeMetrics(
 		err = server.Serve(l)
 	}()
 	log.Info().Msgf("Starting metrics server on %s", fmt.Sprintf("%v/metrics", l.Addr()))
+	if config.Auth.Enabled() {
+		log.Info().Msg("Metrics server access control is enabled")
+	}
 	// server.Serve will hang if server.Shutdown is called before the server is
 	// fully started up. So add artificial delay.
 	time.Sleep(startupTime)
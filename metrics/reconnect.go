@@ -0,0 +1,50 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/audit"
+)
+
+type reconnectPreparer interface {
+	PrepareReconnectJSON(ctx context.Context, window time.Duration) ([]byte, error)
+}
+
+// prepareReconnectHandler prepares the tunnel to reconnect quickly after a planned maintenance window. The
+// optional window query parameter is a duration, e.g. 15m, the tunnel's default window is used without it.
+func prepareReconnectHandler(preparer reconnectPreparer, auditLog *audit.Log, log *zerolog.Logger) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.Header().Set("Allow", http.MethodPost)
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		var window time.Duration
+		if value := r.URL.Query().Get("window"); value != "" {
+			var err error
+			if window, err = time.ParseDuration(value); err != nil || window <= 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = fmt.Fprintf(w, "ERR: invalid window %q", value)
+				return
+			}
+		}
+		json, err := preparer.PrepareReconnectJSON(r.Context(), window)
+		auditLog.Record(audit.ActionPrepareReconnect, audit.ActorLocal, err, map[string]string{
+			"remoteAddr": r.RemoteAddr,
+			"window":     window.String(),
+		})
+		if err != nil {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = fmt.Fprintf(w, "ERR: %v", err)
+			log.Err(err).Msg("Failed to prepare to reconnect")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(json)
+	}
+}
@@ -0,0 +1,58 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockReconnectPreparer struct {
+	window time.Duration
+	err    error
+}
+
+func (m *mockReconnectPreparer) PrepareReconnectJSON(_ context.Context, window time.Duration) ([]byte, error) {
+	m.window = window
+	if m.err != nil {
+		return nil, m.err
+	}
+	return []byte(`{"probes":[]}`), nil
+}
+
+func TestPrepareReconnectHandler(t *testing.T) {
+	log := zerolog.Nop()
+	tests := []struct {
+		name           string
+		method         string
+		target         string
+		err            error
+		expectedCode   int
+		expectedWindow time.Duration
+	}{
+		{"default window", http.MethodPost, "/prepare-reconnect", nil, http.StatusOK, 0},
+		{"window", http.MethodPost, "/prepare-reconnect?window=15m", nil, http.StatusOK, 15 * time.Minute},
+		{"invalid window", http.MethodPost, "/prepare-reconnect?window=soon", nil, http.StatusBadRequest, 0},
+		{"negative window", http.MethodPost, "/prepare-reconnect?window=-1m", nil, http.StatusBadRequest, 0},
+		{"not started", http.MethodPost, "/prepare-reconnect", errors.New("tunnel has not started"), http.StatusServiceUnavailable, 0},
+		{"get", http.MethodGet, "/prepare-reconnect", nil, http.StatusMethodNotAllowed, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			preparer := &mockReconnectPreparer{err: test.err}
+			rec := httptest.NewRecorder()
+			prepareReconnectHandler(preparer, nil, &log).ServeHTTP(rec, httptest.NewRequest(test.method, test.target, nil))
+			assert.Equal(t, test.expectedCode, rec.Code)
+			assert.Equal(t, test.expectedWindow, preparer.window)
+			if test.expectedCode == http.StatusOK {
+				assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+				assert.JSONEq(t, `{"probes":[]}`, rec.Body.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package metrics
+
+import (
+	"sort"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	TunnelIDLabel    = "tunnel_id"
+	TunnelNameLabel  = "tunnel_name"
+	ConnectorIDLabel = "connector_id"
+	// StableConnectorIDLabel identifies the connector across restarts, unlike ConnectorIDLabel
+	StableConnectorIDLabel = "stable_connector_id"
+)
+
+// TunnelLabels identify the tunnel and connector a cloudflared process runs, so that the metrics of several
+// tunnels on the same host, or of a fleet of connectors, can be told apart and aggregated.
+type TunnelLabels struct {
+	TunnelID    string
+	TunnelName  string
+	ConnectorID string
+	// StableConnectorID is left out of the labels if empty
+	StableConnectorID string
+}
+
+func (l TunnelLabels) labels() prometheus.Labels {
+	labels := prometheus.Labels{
+		TunnelIDLabel:    l.TunnelID,
+		TunnelNameLabel:  l.TunnelName,
+		ConnectorIDLabel: l.ConnectorID,
+	}
+	if l.StableConnectorID != "" {
+		labels[StableConnectorIDLabel] = l.StableConnectorID
+	}
+	return labels
+}
+
+// RegisterTunnelInfo exports the tunnel labels in the cloudflared_tunnel_info metric, which always has the
+// value 1, so that dashboards can join them to the other metrics of the process.
+func RegisterTunnelInfo(labels TunnelLabels) {
+	tunnelInfo := prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace:   "cloudflared",
+			Name:        "tunnel_info",
+			Help:        "Tunnel and connector this cloudflared runs",
+			ConstLabels: labels.labels(),
+		},
+	)
+	prometheus.MustRegister(tunnelInfo)
+	tunnelInfo.Set(1)
+}
+
+// labelingGatherer adds constant labels to every metric gathered. A metric that already has one of the labels,
+// like cloudflared_tunnel_info, keeps its own value.
+type labelingGatherer struct {
+	gatherer prometheus.Gatherer
+	labels   []*dto.LabelPair
+}
+
+func newLabelingGatherer(gatherer prometheus.Gatherer, labels prometheus.Labels) *labelingGatherer {
+	pairs := make([]*dto.LabelPair, 0, len(labels))
+	for name, value := range labels {
+		pairs = append(pairs, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	}
+	return &labelingGatherer{
+		gatherer: gatherer,
+		labels:   pairs,
+	}
+}
+
+func (g *labelingGatherer) Gather() ([]*dto.MetricFamily, error) {
+	families, err := g.gatherer.Gather()
+	for _, family := range families {
+		for _, metric := range family.Metric {
+			metric.Label = g.addLabels(metric.Label)
+		}
+	}
+	return families, err
+}
+
+func (g *labelingGatherer) addLabels(pairs []*dto.LabelPair) []*dto.LabelPair {
+	existing := make(map[string]struct{}, len(pairs))
+	for _, pair := range pairs {
+		existing[pair.GetName()] = struct{}{}
+	}
+	for _, label := range g.labels {
+		if _, ok := existing[label.GetName()]; !ok {
+			pairs = append(pairs, label)
+		}
+	}
+	// The exposition format expects labels sorted by name
+	sort.Slice(pairs, func(i, j int) bool {
+		return pairs[i].GetName() < pairs[j].GetName()
+	})
+	return pairs
+}
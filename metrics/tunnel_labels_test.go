@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLabelingGatherer(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	requests := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "requests",
+		Help: "Requests",
+	}, []string{"zone"})
+	registry.MustRegister(requests)
+	requests.WithLabelValues("example.com").Inc()
+
+	info := prometheus.NewGauge(prometheus.GaugeOpts{
+		Name:        "info",
+		Help:        "Info",
+		ConstLabels: prometheus.Labels{TunnelNameLabel: "other"},
+	})
+	registry.MustRegister(info)
+
+	labels := TunnelLabels{
+		TunnelID:    "4c3a4c38-a8e1-4e6b-9f5c-4e2f8c0a2b5d",
+		TunnelName:  "web",
+		ConnectorID: "0f9f8b1e-8b0b-4d6a-9b3d-5f0c3b8a2e1c",
+	}
+	families, err := newLabelingGatherer(registry, labels.labels()).Gather()
+	require.NoError(t, err)
+	require.Len(t, families, 2)
+
+	gathered := make(map[string]map[string]string)
+	for _, family := range families {
+		require.Len(t, family.Metric, 1)
+		pairs := family.Metric[0].Label
+		metricLabels := make(map[string]string, len(pairs))
+		for i, pair := range pairs {
+			if i > 0 {
+				require.Less(t, pairs[i-1].GetName(), pair.GetName())
+			}
+			metricLabels[pair.GetName()] = pair.GetValue()
+		}
+		gathered[family.GetName()] = metricLabels
+	}
+
+	require.Equal(t, map[string]string{
+		ConnectorIDLabel: labels.ConnectorID,
+		TunnelIDLabel:    labels.TunnelID,
+		TunnelNameLabel:  labels.TunnelName,
+		"zone":           "example.com",
+	}, gathered["requests"])
+	// Labels of the metric take precedence
+	require.Equal(t, "other", gathered["info"][TunnelNameLabel])
+	require.Equal(t, labels.TunnelID, gathered["info"][TunnelIDLabel])
+}
+
+func TestTunnelLabelsStableConnectorID(t *testing.T) {
+	labels := TunnelLabels{TunnelID: "4c3a4c38-a8e1-4e6b-9f5c-4e2f8c0a2b5d"}
+	require.NotContains(t, labels.labels(), StableConnectorIDLabel)
+
+	labels.StableConnectorID = "7d3c1a52-2f4b-4b8e-8d6e-1c9a0b7e5f3d"
+	require.Equal(t, labels.StableConnectorID, labels.labels()[StableConnectorIDLabel])
+}
@@ -3,8 +3,12 @@ package orchestration
 import (
 	"encoding/json"
 
+	"github.com/cloudflare/cloudflared/audit"
 	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/har"
 	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/lockdown"
+	"github.com/cloudflare/cloudflared/usage"
 )
 
 type newRemoteConfig struct {
@@ -22,6 +26,14 @@ type Config struct {
 	Ingress             *ingress.Ingress
 	WarpRouting         ingress.WarpRoutingConfig
 	OriginDialerService *ingress.OriginDialerService
+	// AuditLog records the remote configurations applied, nil when auditing is disabled
+	AuditLog *audit.Log
+	// Lockdown rejects the remote configurations, nil when lockdown mode is disabled
+	Lockdown *lockdown.Lockdown
+	// Usage accounts the bytes proxied per hostname and private network, nil when accounting is disabled
+	Usage *usage.Accounting
+	// HAR records the proxied requests for management, nil when the diagnostic services are disabled
+	HAR *har.Recorder
 
 	// Extra settings used to configure this instance but that are not eligible for remotely management
 	// ie. (--protocol, --loglevel, ...)
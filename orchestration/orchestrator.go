@@ -11,6 +11,7 @@ import (
 	pkgerrors "github.com/pkg/errors"
 	"github.com/rs/zerolog"
 
+	"github.com/cloudflare/cloudflared/audit"
 	"github.com/cloudflare/cloudflared/cmd/cloudflared/flags"
 	"github.com/cloudflare/cloudflared/config"
 	"github.com/cloudflare/cloudflared/connection"
@@ -41,6 +42,8 @@ type Orchestrator struct {
 	// Origin dialer service to manage egress socket dialing.
 	originDialerService *ingress.OriginDialerService
 	log                 *zerolog.Logger
+	// Called with the version of each remote configuration once it is applied
+	configAppliedHooks []func(version int32)
 
 	// orchestrator must not handle any more updates after shutdownC is closed
 	shutdownC <-chan struct{}
@@ -74,6 +77,14 @@ func NewOrchestrator(ctx context.Context,
 	return o, nil
 }
 
+// OnConfigApplied registers a hook that is called with the version of each remote configuration once it is
+// applied. Hooks are called with the update lock held, so they must not block.
+func (o *Orchestrator) OnConfigApplied(hook func(version int32)) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.configAppliedHooks = append(o.configAppliedHooks, hook)
+}
+
 // UpdateConfig creates a new proxy with the new ingress rules
 func (o *Orchestrator) UpdateConfig(version int32, config []byte) *pogs.UpdateConfigurationResponse {
 	o.lock.Lock()
@@ -88,12 +99,21 @@ func (o *Orchestrator) UpdateConfig(version int32, config []byte) *pogs.UpdateCo
 			LastAppliedVersion: o.currentVersion,
 		}
 	}
+	if err := o.config.Lockdown.Check(audit.ActionConfigApply, audit.ActorRemote, map[string]string{
+		"version": strconv.Itoa(int(version)),
+	}); err != nil {
+		return &pogs.UpdateConfigurationResponse{
+			LastAppliedVersion: o.currentVersion,
+			Err:                err,
+		}
+	}
 	var newConf newRemoteConfig
 	if err := json.Unmarshal(config, &newConf); err != nil {
 		o.log.Err(err).
 			Int32("version", version).
 			Str("config", string(config)).
 			Msgf("Failed to deserialize new configuration")
+		o.auditConfigApply(version, err)
 		return &pogs.UpdateConfigurationResponse{
 			LastAppliedVersion: o.currentVersion,
 			Err:                err,
@@ -105,6 +125,7 @@ func (o *Orchestrator) UpdateConfig(version int32, config []byte) *pogs.UpdateCo
 			Int32("version", version).
 			Str("config", string(config)).
 			Msgf("Failed to update ingress")
+		o.auditConfigApply(version, err)
 		return &pogs.UpdateConfigurationResponse{
 			LastAppliedVersion: o.currentVersion,
 			Err:                err,
@@ -117,11 +138,21 @@ func (o *Orchestrator) UpdateConfig(version int32, config []byte) *pogs.UpdateCo
 		Str("config", string(config)).
 		Msg("Updated to new configuration")
 	configVersion.Set(float64(version))
+	o.auditConfigApply(version, nil)
+	for _, hook := range o.configAppliedHooks {
+		hook(version)
+	}
 	return &pogs.UpdateConfigurationResponse{
 		LastAppliedVersion: o.currentVersion,
 	}
 }
 
+func (o *Orchestrator) auditConfigApply(version int32, err error) {
+	o.config.AuditLog.Record(audit.ActionConfigApply, audit.ActorRemote, err, map[string]string{
+		"version": strconv.Itoa(int(version)),
+	})
+}
+
 // overrideRemoteWarpRoutingWithLocalValues overrides the ingress.WarpRoutingConfig that comes from the remote with
 // the local values if there is any.
 func (o *Orchestrator) overrideRemoteWarpRoutingWithLocalValues(remoteWarpRouting *ingress.WarpRoutingConfig) error {
@@ -187,7 +218,7 @@ func (o *Orchestrator) updateIngress(ingressRules ingress.Ingress, warpRouting i
 	o.originDialerService.UpdateDefaultDialer(ingress.NewDialer(warpRouting))
 
 	// Create and replace the origin proxy with a new instance
-	proxy := proxy.NewOriginProxy(ingressRules, o.originDialerService, o.tags, o.flowLimiter, o.log)
+	proxy := proxy.NewOriginProxy(ingressRules, o.originDialerService, o.tags, o.flowLimiter, o.config.Usage, o.config.HAR, o.log)
 	o.proxy.Store(proxy)
 	o.config.Ingress = &ingressRules
 	o.config.WarpRouting = warpRouting
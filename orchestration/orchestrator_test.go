@@ -8,6 +8,9 @@ import (
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -19,11 +22,13 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 
+	"github.com/cloudflare/cloudflared/audit"
 	"github.com/cloudflare/cloudflared/cmd/cloudflared/flags"
 
 	"github.com/cloudflare/cloudflared/config"
 	"github.com/cloudflare/cloudflared/connection"
 	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/lockdown"
 	"github.com/cloudflare/cloudflared/management"
 	"github.com/cloudflare/cloudflared/tracing"
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
@@ -63,7 +68,7 @@ func TestUpdateConfiguration(t *testing.T) {
 		Ingress:             &ingress.Ingress{},
 		OriginDialerService: originDialer,
 	}
-	orchestrator, err := NewOrchestrator(t.Context(), initConfig, testTags, []ingress.Rule{ingress.NewManagementRule(management.New("management.argotunnel.com", false, "1.1.1.1:80", uuid.Nil, "", &testLogger, nil))}, &testLogger)
+	orchestrator, err := NewOrchestrator(t.Context(), initConfig, testTags, []ingress.Rule{ingress.NewManagementRule(management.New("management.argotunnel.com", false, "1.1.1.1:80", uuid.Nil, "", &testLogger, nil, nil, nil, nil))}, &testLogger)
 	require.NoError(t, err)
 	initOriginProxy, err := orchestrator.GetOriginProxy()
 	require.NoError(t, err)
@@ -245,6 +250,91 @@ func TestUpdateConfiguration_WithoutIngressRule(t *testing.T) {
 	require.Len(t, orchestrator.config.Ingress.Rules, 1)
 }
 
+// Validates that config applied hooks are only called for versions that are actually applied.
+func TestUpdateConfiguration_ConfigAppliedHook(t *testing.T) {
+	originDialer := ingress.NewOriginDialer(ingress.OriginConfig{
+		DefaultDialer:   testDefaultDialer,
+		TCPWriteTimeout: 1 * time.Second,
+	}, &testLogger)
+	initConfig := &Config{
+		Ingress:             &ingress.Ingress{},
+		OriginDialerService: originDialer,
+	}
+	orchestrator, err := NewOrchestrator(t.Context(), initConfig, testTags, []ingress.Rule{}, &testLogger)
+	require.NoError(t, err)
+	var applied []int32
+	orchestrator.OnConfigApplied(func(version int32) {
+		applied = append(applied, version)
+	})
+
+	configJSON := []byte(`{"ingress": [{"service": "http_status:404"}], "warp-routing": {}}`)
+	updateWithValidation(t, orchestrator, 1, configJSON)
+	// Stale version is ignored
+	orchestrator.UpdateConfig(1, configJSON)
+	// Invalid configuration is rejected
+	resp := orchestrator.UpdateConfig(2, []byte(`{"ingress":`))
+	require.Error(t, resp.Err)
+	updateWithValidation(t, orchestrator, 3, configJSON)
+
+	require.Equal(t, []int32{1, 3}, applied)
+}
+
+// Validates that applied and rejected configurations are recorded in the audit log.
+func TestUpdateConfiguration_AuditLog(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	auditLog, err := audit.Open(auditPath, &testLogger)
+	require.NoError(t, err)
+	defer auditLog.Close()
+
+	originDialer := ingress.NewOriginDialer(ingress.OriginConfig{
+		DefaultDialer:   testDefaultDialer,
+		TCPWriteTimeout: 1 * time.Second,
+	}, &testLogger)
+	initConfig := &Config{
+		Ingress:             &ingress.Ingress{},
+		OriginDialerService: originDialer,
+		AuditLog:            auditLog,
+	}
+	orchestrator, err := NewOrchestrator(t.Context(), initConfig, testTags, []ingress.Rule{}, &testLogger)
+	require.NoError(t, err)
+
+	updateWithValidation(t, orchestrator, 1, []byte(`{"ingress": [{"service": "http_status:404"}], "warp-routing": {}}`))
+	resp := orchestrator.UpdateConfig(2, []byte(`{"ingress":`))
+	require.Error(t, resp.Err)
+
+	content, err := os.ReadFile(auditPath)
+	require.NoError(t, err)
+	entries, err := audit.Verify(strings.NewReader(string(content)))
+	require.NoError(t, err)
+	require.Equal(t, 2, entries)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	require.Contains(t, lines[0], `"action":"config_apply","actor":"remote","outcome":"success"`)
+	require.Contains(t, lines[0], `"version":"1"`)
+	require.Contains(t, lines[1], `"outcome":"failure"`)
+	require.Contains(t, lines[1], `"version":"2"`)
+}
+
+// Validates that remote configurations are rejected in lockdown mode.
+func TestUpdateConfiguration_Lockdown(t *testing.T) {
+	originDialer := ingress.NewOriginDialer(ingress.OriginConfig{
+		DefaultDialer:   testDefaultDialer,
+		TCPWriteTimeout: 1 * time.Second,
+	}, &testLogger)
+	initConfig := &Config{
+		Ingress:             &ingress.Ingress{},
+		OriginDialerService: originDialer,
+		Lockdown:            lockdown.New(true, nil, &testLogger),
+	}
+	orchestrator, err := NewOrchestrator(t.Context(), initConfig, testTags, []ingress.Rule{}, &testLogger)
+	require.NoError(t, err)
+	initRules := orchestrator.config.Ingress.Rules
+
+	resp := orchestrator.UpdateConfig(1, []byte(`{"ingress": [{"service": "http_status:404"}], "warp-routing": {}}`))
+	require.ErrorIs(t, resp.Err, lockdown.ErrLockdown)
+	require.Equal(t, int32(-1), resp.LastAppliedVersion)
+	require.Equal(t, initRules, orchestrator.config.Ingress.Rules)
+}
+
 // TestConcurrentUpdateAndRead makes sure orchestrator can receive updates and return origin proxy concurrently
 func TestConcurrentUpdateAndRead(t *testing.T) {
 	const (
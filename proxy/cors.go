@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/ingress"
+)
+
+// corsResponseWriter sets the CORS headers of the rule on the response to req, whatever the origin sent.
+type corsResponseWriter struct {
+	connection.ResponseWriter
+	policy *ingress.CORSPolicy
+	req    *http.Request
+}
+
+func (w *corsResponseWriter) WriteRespHeaders(status int, header http.Header) error {
+	if header == nil {
+		header = http.Header{}
+	}
+	w.policy.SetResponseHeaders(w.req, header)
+	return w.ResponseWriter.WriteRespHeaders(status, header)
+}
+
+// WriteHeader covers the local services, e.g. Hello World, which write their responses as a http.Handler.
+func (w *corsResponseWriter) WriteHeader(status int) {
+	w.policy.SetResponseHeaders(w.req, w.ResponseWriter.Header())
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *corsResponseWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
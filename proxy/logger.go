@@ -3,6 +3,7 @@ package proxy
 import (
 	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/rs/zerolog"
 
@@ -13,6 +14,7 @@ import (
 
 const (
 	logFieldCFRay         = "cfRay"
+	logFieldRequestID     = "requestID"
 	logFieldLBProbe       = "lbProbe"
 	logFieldRule          = "ingressRule"
 	logFieldOriginService = "originService"
@@ -24,12 +26,13 @@ var (
 	LogFieldFlowID = "flowID"
 )
 
-// newHTTPLogger creates a child zerolog.Logger from the provided with added context from the HTTP request, ingress
-// services, and connection index.
-func newHTTPLogger(logger *zerolog.Logger, connIndex uint8, req *http.Request, rule int, serviceName string) zerolog.Logger {
+// newHTTPLogger creates a child zerolog.Logger from the provided with added context from the HTTP request, its ID,
+// ingress services, and connection index.
+func newHTTPLogger(logger *zerolog.Logger, connIndex uint8, req *http.Request, requestID string, rule int, serviceName string) zerolog.Logger {
 	ctx := logger.With().
 		Int(management.EventTypeKey, int(management.HTTP)).
-		Uint8(logFieldConnIndex, connIndex)
+		Uint8(logFieldConnIndex, connIndex).
+		Str(logFieldRequestID, requestID)
 	cfRay := connection.FindCfRayHeader(req)
 	lbProbe := connection.IsLBProbeRequest(req)
 	if cfRay != "" {
@@ -56,6 +59,26 @@ func newTCPLogger(logger *zerolog.Logger, req *connection.TCPRequest) zerolog.Lo
 		Logger()
 }
 
+// logAccess logs the access event summarizing an HTTP request, which management sessions only get when they
+// request them.
+func logAccess(logger *zerolog.Logger, connIndex uint8, req *http.Request, requestID string, rule int, status int, bytes int64, duration time.Duration) {
+	event := logger.Debug().
+		Int(management.EventTypeKey, int(management.Access)).
+		Uint8(logFieldConnIndex, connIndex).
+		Str(logFieldRequestID, requestID).
+		Interface(logFieldRule, rule).
+		Str("method", req.Method).
+		Str("host", req.Host).
+		Str("path", req.URL.Path).
+		Int("status", status).
+		Int64("bytes", bytes).
+		Dur("duration", duration)
+	if cfRay := connection.FindCfRayHeader(req); cfRay != "" {
+		event.Str(logFieldCFRay, cfRay)
+	}
+	event.Msgf("%s %s%s %d", req.Method, req.Host, req.URL.Path, status)
+}
+
 // logHTTPRequest logs a Debug message with the corresponding HTTP request details from the eyeball.
 func logHTTPRequest(logger *zerolog.Logger, r *http.Request) {
 	logger.Debug().
@@ -79,3 +102,53 @@ func logRequestError(logger *zerolog.Logger, err error) {
 	requestErrors.Inc()
 	logger.Error().Err(err).Send()
 }
+
+// accessRecorder records the status, headers and size of the response for the access event and HAR entry of the
+// request.
+type accessRecorder struct {
+	connection.ResponseWriter
+	status      int
+	header      http.Header
+	headersTime time.Time
+	bytes       int64
+}
+
+func (w *accessRecorder) WriteRespHeaders(status int, header http.Header) error {
+	w.recordHeaders(status, header)
+	return w.ResponseWriter.WriteRespHeaders(status, header)
+}
+
+func (w *accessRecorder) WriteHeader(status int) {
+	w.recordHeaders(status, w.ResponseWriter.Header())
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *accessRecorder) recordHeaders(status int, header http.Header) {
+	w.status = status
+	w.header = header
+	w.headersTime = time.Now()
+}
+
+func (w *accessRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.recordHeaders(http.StatusOK, w.ResponseWriter.Header())
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *accessRecorder) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// statusOrBadGateway returns the status of the response, or 502 when no response was written because the request
+// failed, as the connection answers those with a 502.
+func (w *accessRecorder) statusOrBadGateway() int {
+	if w.status == 0 {
+		return http.StatusBadGateway
+	}
+	return w.status
+}
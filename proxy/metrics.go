@@ -1,9 +1,12 @@
 package proxy
 
 import (
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/ingress"
 )
 
 // Metrics uses connection.MetricsNamespace(aka cloudflared) as namespace and connection.TunnelSubsystem
@@ -68,6 +71,48 @@ var (
 			Buckets:   []float64{1, 10, 25, 50, 100, 500, 1000, 5000},
 		},
 	)
+	originTimeouts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "origin_timeouts",
+			Help:      "Count of HTTP requests to origins that timed out, by the phase that timed out: connect, tls_handshake or response_header",
+		},
+		[]string{"phase"},
+	)
+	// Buckets in seconds shared by the histograms splitting the duration of HTTP requests between the origin and
+	// cloudflared
+	requestDurationBuckets = []float64{0.001, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+	originTTFB             = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "origin_ttfb_seconds",
+			Help:      "Time from sending HTTP requests to the origin until it responded with headers, by ingress rule hostname",
+			Buckets:   requestDurationBuckets,
+		},
+		[]string{"hostname"},
+	)
+	proxyProcessing = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "processing_seconds",
+			Help:      "Time cloudflared spent on HTTP requests until it sent the response headers to the edge, excluding the origin time to first byte, by ingress rule hostname",
+			Buckets:   requestDurationBuckets,
+		},
+		[]string{"hostname"},
+	)
+	requestDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: "proxy",
+			Name:      "request_duration_seconds",
+			Help:      "Total duration of HTTP requests proxied to origins, including streaming the response body, by ingress rule hostname",
+			Buckets:   requestDurationBuckets,
+		},
+		[]string{"hostname"},
+	)
 	connectStreamErrors = prometheus.NewCounter(
 		prometheus.CounterOpts{
 			Namespace: connection.MetricsNamespace,
@@ -88,6 +133,10 @@ func init() {
 		totalTCPSessions,
 		connectLatency,
 		connectStreamErrors,
+		originTimeouts,
+		originTTFB,
+		proxyProcessing,
+		requestDuration,
 	)
 }
 
@@ -110,3 +159,35 @@ func decrementTCPConcurrentRequests() {
 	decrementConcurrentRequests()
 	activeTCPSessions.Dec()
 }
+
+// requestTimer splits the duration of a request proxied to an HTTP origin between the origin and cloudflared, to
+// tell whether a slow request is slowed down by the origin or the tunnel.
+type requestTimer struct {
+	hostname   string
+	start      time.Time
+	originTTFB time.Duration
+}
+
+func newRequestTimer(start time.Time, rule *ingress.Rule) *requestTimer {
+	hostname := rule.Hostname
+	if hostname == "" {
+		hostname = "*"
+	}
+	return &requestTimer{
+		hostname: hostname,
+		start:    start,
+	}
+}
+
+func (t *requestTimer) originResponded(roundTripStart time.Time) {
+	t.originTTFB = time.Since(roundTripStart)
+	originTTFB.WithLabelValues(t.hostname).Observe(t.originTTFB.Seconds())
+}
+
+func (t *requestTimer) headersWritten() {
+	proxyProcessing.WithLabelValues(t.hostname).Observe((time.Since(t.start) - t.originTTFB).Seconds())
+}
+
+func (t *requestTimer) done() {
+	requestDuration.WithLabelValues(t.hostname).Observe(time.Since(t.start).Seconds())
+}
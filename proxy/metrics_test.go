@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/config"
+	cfdflow "github.com/cloudflare/cloudflared/flow"
+	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/tracing"
+)
+
+func histogramFor(t *testing.T, histogram *prometheus.HistogramVec, hostname string) *dto.Histogram {
+	var metric dto.Metric
+	require.NoError(t, histogram.WithLabelValues(hostname).(prometheus.Metric).Write(&metric))
+	return metric.GetHistogram()
+}
+
+func TestRequestTimings(t *testing.T) {
+	const originDelay = 50 * time.Millisecond
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(originDelay)
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		time.Sleep(originDelay)
+		_, _ = w.Write([]byte("body"))
+	}))
+	defer origin.Close()
+
+	ingressRule, err := ingress.ParseIngress(&config.Configuration{
+		TunnelID: t.Name(),
+		Ingress: []config.UnvalidatedIngressRule{
+			{
+				Hostname: "timings.example.com",
+				Service:  origin.URL,
+			},
+			{
+				Service: "http_status:404",
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	log := zerolog.Nop()
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	require.NoError(t, ingressRule.StartOrigins(&log, ctx.Done()))
+	originDialer := ingress.NewOriginDialer(ingress.OriginConfig{
+		DefaultDialer:   testDefaultDialer,
+		TCPWriteTimeout: 1 * time.Second,
+	}, &log)
+	proxy := NewOriginProxy(ingressRule, originDialer, testTags, cfdflow.NewLimiter(0), nil, nil, &log)
+
+	req, err := http.NewRequest(http.MethodGet, "http://timings.example.com", nil)
+	require.NoError(t, err)
+	responseWriter := newMockHTTPRespWriter()
+	require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, &log), false))
+	require.Equal(t, "body", responseWriter.Body.String())
+
+	ttfb := histogramFor(t, originTTFB, "timings.example.com")
+	require.Equal(t, uint64(1), ttfb.GetSampleCount())
+	require.GreaterOrEqual(t, ttfb.GetSampleSum(), originDelay.Seconds())
+
+	processing := histogramFor(t, proxyProcessing, "timings.example.com")
+	require.Equal(t, uint64(1), processing.GetSampleCount())
+	require.Less(t, processing.GetSampleSum(), originDelay.Seconds())
+
+	duration := histogramFor(t, requestDuration, "timings.example.com")
+	require.Equal(t, uint64(1), duration.GetSampleCount())
+	require.GreaterOrEqual(t, duration.GetSampleSum(), 2*originDelay.Seconds())
+}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"net/netip"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/pkg/errors"
 	"github.com/rs/zerolog"
 	"go.opentelemetry.io/otel/attribute"
@@ -20,16 +23,20 @@ import (
 	"github.com/cloudflare/cloudflared/carrier"
 	"github.com/cloudflare/cloudflared/cfio"
 	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/har"
 	"github.com/cloudflare/cloudflared/ingress"
 	"github.com/cloudflare/cloudflared/stream"
 	"github.com/cloudflare/cloudflared/tracing"
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
+	"github.com/cloudflare/cloudflared/usage"
 )
 
 const (
 	// TagHeaderNamePrefix indicates a Cloudflared Warp Tag prefix that gets appended for warp traffic stream headers.
 	TagHeaderNamePrefix = "Cf-Warp-Tag-"
 	trailerHeaderName   = "Trailer"
+	// connectingIPHeader is set by the edge to the IP of the client
+	connectingIPHeader = "Cf-Connecting-Ip"
 )
 
 // Proxy represents a means to Proxy between cloudflared and the origin services.
@@ -38,7 +45,11 @@ type Proxy struct {
 	originDialer ingress.OriginTCPDialer
 	tags         []pogs.Tag
 	flowLimiter  cfdflow.Limiter
-	log          *zerolog.Logger
+	// usage accounts the bytes proxied per hostname and private network, nil when accounting is disabled
+	usage *usage.Accounting
+	// har records the HTTP requests for management, nil when it is disabled
+	har *har.Recorder
+	log *zerolog.Logger
 }
 
 // NewOriginProxy returns a new instance of the Proxy struct.
@@ -47,6 +58,8 @@ func NewOriginProxy(
 	originDialer ingress.OriginDialer,
 	tags []pogs.Tag,
 	flowLimiter cfdflow.Limiter,
+	usageAccounting *usage.Accounting,
+	harRecorder *har.Recorder,
 	log *zerolog.Logger,
 ) *Proxy {
 	proxy := &Proxy{
@@ -54,6 +67,8 @@ func NewOriginProxy(
 		originDialer: originDialer,
 		tags:         tags,
 		flowLimiter:  flowLimiter,
+		usage:        usageAccounting,
+		har:          harRecorder,
 		log:          log,
 	}
 
@@ -68,7 +83,7 @@ func (p *Proxy) applyIngressMiddleware(rule *ingress.Rule, r *http.Request, w co
 		}
 
 		if result.ShouldFilterRequest {
-			_ = w.WriteRespHeaders(result.StatusCode, nil)
+			_ = w.WriteRespHeaders(result.StatusCode, result.Headers)
 			return fmt.Errorf("request filtered by middleware handler (%s) due to: %s", handler.Name(), result.Reason), true
 		}
 	}
@@ -81,20 +96,49 @@ func (p *Proxy) ProxyHTTP(
 	w connection.ResponseWriter,
 	tr *tracing.TracedHTTPRequest,
 	isWebsocket bool,
-) error {
+) (err error) {
+	start := time.Now()
 	incrementRequests()
 	defer decrementConcurrentRequests()
 
 	req := tr.Request
 	p.appendTagHeaders(req)
+	requestID := newRequestID(req)
 
 	_, ruleSpan := tr.Tracer().Start(req.Context(), "ingress_match",
-		trace.WithAttributes(attribute.String("req-host", req.Host)))
+		trace.WithAttributes(attribute.String("req-host", req.Host), attribute.String("request-id", requestID)))
 	rule, ruleNum := p.ingressRules.FindMatchingRule(req.Host, req.URL.Path)
 	ruleSpan.SetAttributes(attribute.Int("rule-num", ruleNum))
 	ruleSpan.End()
-	logger := newHTTPLogger(p.log, tr.ConnIndex, req, ruleNum, rule.Service.String())
+	p.appendRuleTagHeaders(req, rule.Config.TagHeaders)
+	if header := rule.Config.RequestIDHeader; header != "" {
+		req.Header.Set(header, requestID)
+	}
+	logger := newHTTPLogger(p.log, tr.ConnIndex, req, requestID, ruleNum, rule.Service.String())
 	logHTTPRequest(&logger, req)
+	access := &accessRecorder{ResponseWriter: w}
+	w = access
+	defer func() {
+		end := time.Now()
+		logAccess(p.log, tr.ConnIndex, req, requestID, ruleNum, access.statusOrBadGateway(), access.bytes, end.Sub(start))
+		p.har.Record(har.Exchange{
+			Request:     req,
+			Start:       start,
+			HeadersTime: access.headersTime,
+			End:         end,
+			Status:      access.statusOrBadGateway(),
+			Header:      access.header,
+			Bytes:       access.bytes,
+			Error:       err,
+		})
+	}()
+	if rule.CORS != nil {
+		// Preflight requests don't carry credentials, so they are answered before any middleware, e.g. Access
+		if headers, ok := rule.CORS.Preflight(req); ok {
+			return w.WriteRespHeaders(http.StatusNoContent, headers)
+		}
+		w = &corsResponseWriter{ResponseWriter: w, policy: rule.CORS, req: req}
+	}
 	if err, applied := p.applyIngressMiddleware(rule, req, w); err != nil {
 		if applied {
 			logRequestError(&logger, err)
@@ -103,6 +147,7 @@ func (p *Proxy) ProxyHTTP(
 		return err
 	}
 
+	traffic := p.usage.Hostname(rule.Hostname)
 	switch originProxy := rule.Service.(type) {
 	case ingress.HTTPOriginProxy:
 		if err := p.proxyHTTPRequest(
@@ -110,7 +155,10 @@ func (p *Proxy) ProxyHTTP(
 			tr,
 			originProxy,
 			isWebsocket,
-			rule.Config.DisableChunkedEncoding,
+			rule.Config,
+			rule.Transform,
+			newRequestTimer(start, rule),
+			traffic,
 			&logger,
 		); err != nil {
 			logRequestError(&logger, err)
@@ -126,9 +174,13 @@ func (p *Proxy) ProxyHTTP(
 		if !ok {
 			return fmt.Errorf("response writer is not a flusher")
 		}
-		rws := connection.NewHTTPResponseReadWriterAcker(w, flusher, req)
+		rws := countStream(connection.NewHTTPResponseReadWriterAcker(w, flusher, req), traffic)
 		logger := logger.With().Str(logFieldDestAddr, dest).Logger()
-		if err := p.proxyStream(tr.ToTracedContext(), rws, dest, originProxy, &logger); err != nil {
+		tracedCtx := tr.ToTracedContext()
+		if clientIP, err := netip.ParseAddr(req.Header.Get(connectingIPHeader)); err == nil {
+			tracedCtx.Context = ingress.ContextWithClientIP(tracedCtx.Context, clientIP)
+		}
+		if err := p.proxyStream(tracedCtx, rws, dest, originProxy, &logger); err != nil {
 			logRequestError(&logger, err)
 			return err
 		}
@@ -172,6 +224,7 @@ func (p *Proxy) ProxyTCP(
 		return err
 	}
 
+	conn = countStream(conn, p.usage.Network(dest.Addr()))
 	if err := p.proxyTCPStream(tracedCtx, conn, dest, p.originDialer, &logger); err != nil {
 		logRequestError(&logger, err)
 		return err
@@ -188,9 +241,16 @@ func (p *Proxy) proxyHTTPRequest(
 	tr *tracing.TracedHTTPRequest,
 	httpService ingress.HTTPOriginProxy,
 	isWebsocket bool,
-	disableChunkedEncoding bool,
+	cfg ingress.OriginRequestConfig,
+	transform *ingress.Transform,
+	timer *requestTimer,
+	traffic *usage.Traffic,
 	logger *zerolog.Logger,
 ) error {
+	// The body is read to send it to the origin, or piped to it once a websocket is upgraded
+	if traffic != nil && tr.Request.Body != nil && tr.Request.Body != http.NoBody {
+		tr.Request.Body = &countingReadCloser{ReadCloser: tr.Request.Body, count: traffic.AddToOrigin}
+	}
 	roundTripReq := tr.Request
 	if isWebsocket {
 		roundTripReq = tr.Clone(tr.Request.Context())
@@ -201,7 +261,7 @@ func (p *Proxy) proxyHTTPRequest(
 		roundTripReq.Body = nil
 	} else {
 		// Support for WSGI Servers by switching transfer encoding from chunked to gzip/deflate
-		if disableChunkedEncoding {
+		if cfg.DisableChunkedEncoding {
 			roundTripReq.TransferEncoding = []string{"gzip", "deflate"}
 			cLength, err := strconv.Atoi(tr.Request.Header.Get("Content-Length"))
 			if err == nil {
@@ -210,6 +270,20 @@ func (p *Proxy) proxyHTTPRequest(
 		}
 		// Request origin to keep connection alive to improve performance
 		roundTripReq.Header.Set("Connection", "keep-alive")
+
+		if cfg.RequestBodyBufferDir != "" && roundTripReq.Body != nil && roundTripReq.Body != http.NoBody {
+			bodyBuffer, err := newRequestBodyBuffer(roundTripReq.Body, cfg.RequestBodyBufferDir, int64(cfg.RequestBodyBufferMaxSize))
+			if err != nil {
+				logger.Warn().Err(err).Msg("Proxying the request body to the origin without buffering it")
+			} else {
+				defer bodyBuffer.Close()
+				roundTripReq.Body = bodyBuffer
+			}
+		}
+	}
+
+	if err := transform.TransformRequest(roundTripReq); err != nil {
+		return errors.Wrap(err, "Error transforming the request")
 	}
 
 	// Set the User-Agent as an empty string if not provided to avoid inserting golang default UA
@@ -218,16 +292,26 @@ func (p *Proxy) proxyHTTPRequest(
 	}
 
 	_, ttfbSpan := tr.Tracer().Start(tr.Context(), "ttfb_origin")
+	roundTripStart := time.Now()
 	resp, err := httpService.RoundTrip(roundTripReq)
 	if err != nil {
 		tracing.EndWithErrorStatus(ttfbSpan, err)
 		if err := roundTripReq.Context().Err(); err != nil {
 			return errors.Wrap(err, "Incoming request ended abruptly")
 		}
+		if phase, ok := originTimeoutPhase(err); ok {
+			originTimeouts.WithLabelValues(phase).Inc()
+			return errors.Wrapf(err, "Unable to reach the origin service, it timed out in the %s phase", phase)
+		}
 		return errors.Wrap(err, "Unable to reach the origin service. The service may be down or it may not be responding to traffic from cloudflared")
 	}
 
 	tracing.EndWithStatusCode(ttfbSpan, resp.StatusCode)
+	timer.originResponded(roundTripStart)
+	if err := transform.TransformResponse(roundTripReq, resp); err != nil {
+		_ = resp.Body.Close()
+		return errors.Wrap(err, "Error transforming the response")
+	}
 	defer resp.Body.Close()
 
 	headers := make(http.Header, len(resp.Header))
@@ -243,6 +327,7 @@ func (p *Proxy) proxyHTTPRequest(
 	if err != nil {
 		return errors.Wrap(err, "Error writing response header")
 	}
+	timer.headersWritten()
 
 	if resp.StatusCode == http.StatusSwitchingProtocols {
 		rwc, ok := resp.Body.(io.ReadWriteCloser)
@@ -255,17 +340,27 @@ func (p *Proxy) proxyHTTPRequest(
 			writer: w,
 			reader: tr.Request.Body,
 		}
+		if traffic != nil {
+			eyeballStream.writer = &countingWriter{Writer: w, count: traffic.AddFromOrigin}
+		}
 
 		stream.Pipe(eyeballStream, rwc, logger)
 		return nil
 	}
 
-	if _, err = cfio.Copy(w, resp.Body); err != nil {
+	var body io.Reader = resp.Body
+	if traffic != nil {
+		body = &countingReader{Reader: resp.Body, count: traffic.AddFromOrigin}
+	}
+	// Response bodies can be large, yield to interactive streams, e.g. RDP, sharing the tunnel
+	if _, err = cfio.Copy(stream.Bulk(w), body); err != nil {
 		return err
 	}
 
 	// copy trailers
 	copyTrailers(w, resp)
+	// The duration of upgraded connections is how long they are used, so only other requests are timed
+	timer.done()
 
 	logOriginHTTPResponse(logger, resp)
 	return nil
@@ -378,6 +473,52 @@ func (p *Proxy) appendTagHeaders(r *http.Request) {
 	}
 }
 
+// appendRuleTagHeaders sets each header configured in the rule's tagHeaders to the value of the tag it names, so that
+// the origin can tell which connector served the request. Headers naming a tag this connector doesn't have are skipped.
+func (p *Proxy) appendRuleTagHeaders(r *http.Request, tagHeaders map[string]string) {
+	for header, tagName := range tagHeaders {
+		for _, tag := range p.tags {
+			if tag.Name == tagName {
+				r.Header.Set(header, tag.Value)
+				break
+			}
+		}
+	}
+}
+
+// newRequestID identifies a request across the edge, cloudflared and origin logs. It's the request's cf-ray, or a
+// random ID when the request has none, e.g. when it comes from a load balancer probe.
+func newRequestID(r *http.Request) string {
+	if cfRay := connection.FindCfRayHeader(r); cfRay != "" {
+		return cfRay
+	}
+	return uuid.New().String()
+}
+
+// Phases of a request to an origin that can time out, as labelled in the origin_timeouts metric
+const (
+	originTimeoutConnect        = "connect"
+	originTimeoutTLSHandshake   = "tls_handshake"
+	originTimeoutResponseHeader = "response_header"
+)
+
+// originTimeoutPhase tells which phase of a request to an origin timed out, each being bounded by the connectTimeout,
+// tlsTimeout and responseHeaderTimeout of the ingress rule. http.Transport doesn't export its timeout errors, so
+// they are recognised by their message.
+func originTimeoutPhase(err error) (string, bool) {
+	var opErr *net.OpError
+	switch {
+	case errors.As(err, &opErr) && opErr.Op == "dial" && opErr.Timeout():
+		return originTimeoutConnect, true
+	case strings.Contains(err.Error(), "TLS handshake timeout"):
+		return originTimeoutTLSHandshake, true
+	case strings.Contains(err.Error(), "timeout awaiting response headers"):
+		return originTimeoutResponseHeader, true
+	default:
+		return "", false
+	}
+}
+
 func copyTrailers(w connection.ResponseWriter, response *http.Response) {
 	for trailerHeader, trailerValues := range response.Trailer {
 		for _, trailerValue := range trailerValues {
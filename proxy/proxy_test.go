@@ -4,18 +4,23 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/gobwas/ws/wsutil"
+	"github.com/google/uuid"
 	gorillaWS "github.com/gorilla/websocket"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
@@ -31,10 +36,13 @@ import (
 	"github.com/cloudflare/cloudflared/cfio"
 	"github.com/cloudflare/cloudflared/config"
 	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/har"
 	"github.com/cloudflare/cloudflared/hello"
 	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/management"
 	"github.com/cloudflare/cloudflared/tracing"
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
+	"github.com/cloudflare/cloudflared/usage"
 )
 
 var (
@@ -167,7 +175,7 @@ func TestProxySingleOrigin(t *testing.T) {
 		TCPWriteTimeout: 1 * time.Second,
 	}, &log)
 
-	proxy := NewOriginProxy(ingressRule, originDialer, testTags, cfdflow.NewLimiter(0), &log)
+	proxy := NewOriginProxy(ingressRule, originDialer, testTags, cfdflow.NewLimiter(0), nil, nil, &log)
 	t.Run("testProxyHTTP", testProxyHTTP(proxy))
 	t.Run("testProxyWebsocket", testProxyWebsocket(proxy))
 	t.Run("testProxySSE", testProxySSE(proxy))
@@ -355,6 +363,342 @@ func TestProxyMultipleOrigins(t *testing.T) {
 	runIngressTestScenarios(t, unvalidatedIngress, tests)
 }
 
+func TestProxyTagHeaders(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-Connector-Site") + "," + r.Header.Get("X-Connector-Name")))
+	}))
+	defer origin.Close()
+
+	ingressRule, err := ingress.ParseIngress(&config.Configuration{
+		TunnelID: t.Name(),
+		Ingress: []config.UnvalidatedIngressRule{
+			{
+				Hostname: "override.example.com",
+				Service:  origin.URL,
+				OriginRequest: config.OriginRequestConfig{
+					TagHeaders: map[string]string{"X-Connector-Name": "Name", "X-Connector-Site": "unknown"},
+				},
+			},
+			{
+				Hostname: "*",
+				Service:  origin.URL,
+			},
+		},
+		OriginRequest: config.OriginRequestConfig{
+			TagHeaders: map[string]string{"X-Connector-Site": "Name"},
+		},
+	})
+	require.NoError(t, err)
+
+	log := zerolog.Nop()
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	require.NoError(t, ingressRule.StartOrigins(&log, ctx.Done()))
+	originDialer := ingress.NewOriginDialer(ingress.OriginConfig{
+		DefaultDialer:   testDefaultDialer,
+		TCPWriteTimeout: 1 * time.Second,
+	}, &log)
+	proxy := NewOriginProxy(ingressRule, originDialer, testTags, cfdflow.NewLimiter(0), nil, nil, &log)
+
+	tests := []struct {
+		url          string
+		expectedBody string
+	}{
+		// The tunnel wide tagHeaders apply to every rule
+		{url: "http://default.example.com", expectedBody: "value,"},
+		// A rule's tagHeaders replace the tunnel wide ones, and headers naming an unknown tag are skipped
+		{url: "http://override.example.com", expectedBody: ",value"},
+	}
+	for _, test := range tests {
+		responseWriter := newMockHTTPRespWriter()
+		req, err := http.NewRequest(http.MethodGet, test.url, nil)
+		require.NoError(t, err)
+
+		err = proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, &log), false)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, responseWriter.Code)
+		assert.Equal(t, test.expectedBody, responseWriter.Body.String())
+	}
+}
+
+func TestProxyRequestIDHeader(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("X-Request-ID")))
+	}))
+	defer origin.Close()
+
+	requestIDHeader := "X-Request-ID"
+	ingressRule, err := ingress.ParseIngress(&config.Configuration{
+		TunnelID: t.Name(),
+		Ingress: []config.UnvalidatedIngressRule{
+			{
+				Hostname: "*",
+				Service:  origin.URL,
+			},
+		},
+		OriginRequest: config.OriginRequestConfig{
+			RequestIDHeader: &requestIDHeader,
+		},
+	})
+	require.NoError(t, err)
+
+	var logs bytes.Buffer
+	log := zerolog.New(&logs)
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	require.NoError(t, ingressRule.StartOrigins(&log, ctx.Done()))
+	originDialer := ingress.NewOriginDialer(ingress.OriginConfig{
+		DefaultDialer:   testDefaultDialer,
+		TCPWriteTimeout: 1 * time.Second,
+	}, &log)
+	proxy := NewOriginProxy(ingressRule, originDialer, testTags, cfdflow.NewLimiter(0), nil, nil, &log)
+
+	proxyRequest := func(cfRay string) string {
+		responseWriter := newMockHTTPRespWriter()
+		req, err := http.NewRequest(http.MethodGet, "http://example.com", nil)
+		require.NoError(t, err)
+		if cfRay != "" {
+			req.Header.Set("Cf-Ray", cfRay)
+		}
+		require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, &log), false))
+		require.Equal(t, http.StatusOK, responseWriter.Code)
+		return responseWriter.Body.String()
+	}
+
+	// The cf-ray of the request is adopted as its ID
+	assert.Equal(t, "8a1b2c3d4e5f6789-LHR", proxyRequest("8a1b2c3d4e5f6789-LHR"))
+
+	// Requests without one get a generated ID, which is logged with the request
+	logs.Reset()
+	requestID := proxyRequest("")
+	_, err = uuid.Parse(requestID)
+	require.NoError(t, err)
+	assert.Contains(t, logs.String(), `"requestID":"`+requestID+`"`)
+}
+
+func TestProxyAccessEvent(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("created"))
+	}))
+	defer origin.Close()
+
+	ingressRule, err := ingress.ParseIngress(&config.Configuration{
+		TunnelID: t.Name(),
+		Ingress:  []config.UnvalidatedIngressRule{{Hostname: "*", Service: origin.URL}},
+	})
+	require.NoError(t, err)
+
+	var logs bytes.Buffer
+	log := zerolog.New(&logs)
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	require.NoError(t, ingressRule.StartOrigins(&log, ctx.Done()))
+	originDialer := ingress.NewOriginDialer(ingress.OriginConfig{
+		DefaultDialer:   testDefaultDialer,
+		TCPWriteTimeout: 1 * time.Second,
+	}, &log)
+	proxy := NewOriginProxy(ingressRule, originDialer, testTags, cfdflow.NewLimiter(0), nil, nil, &log)
+
+	req, err := http.NewRequest(http.MethodPost, "http://example.com/orders", nil)
+	require.NoError(t, err)
+	require.NoError(t, proxy.ProxyHTTP(newMockHTTPRespWriter(), tracing.NewTracedHTTPRequest(req, 0, &log), false))
+
+	var accessEvents []map[string]interface{}
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var event map[string]interface{}
+		require.NoError(t, json.Unmarshal([]byte(line), &event))
+		if event[management.EventTypeKey] == float64(management.Access) {
+			accessEvents = append(accessEvents, event)
+		}
+	}
+	require.Len(t, accessEvents, 1)
+	assert.Equal(t, "POST", accessEvents[0]["method"])
+	assert.Equal(t, "example.com", accessEvents[0]["host"])
+	assert.Equal(t, "/orders", accessEvents[0]["path"])
+	assert.Equal(t, float64(http.StatusCreated), accessEvents[0]["status"])
+	assert.Equal(t, float64(len("created")), accessEvents[0]["bytes"])
+}
+
+func TestProxyHARCapture(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	ingressRule, err := ingress.ParseIngress(&config.Configuration{
+		TunnelID: t.Name(),
+		Ingress:  []config.UnvalidatedIngressRule{{Hostname: "*", Service: origin.URL}},
+	})
+	require.NoError(t, err)
+	log := zerolog.Nop()
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	require.NoError(t, ingressRule.StartOrigins(&log, ctx.Done()))
+	originDialer := ingress.NewOriginDialer(ingress.OriginConfig{
+		DefaultDialer:   testDefaultDialer,
+		TCPWriteTimeout: 1 * time.Second,
+	}, &log)
+	recorder := har.NewRecorder("test", har.DefaultMaxFailures)
+	proxy := NewOriginProxy(ingressRule, originDialer, testTags, cfdflow.NewLimiter(0), nil, recorder, &log)
+
+	var captured bytes.Buffer
+	done := make(chan error)
+	go func() {
+		done <- recorder.Capture(t.Context(), &captured, "example.com", 500*time.Millisecond, 1024*1024)
+	}()
+	// Gives the capture time to start
+	time.Sleep(50 * time.Millisecond)
+
+	req, err := http.NewRequest(http.MethodGet, "http://example.com/status?verbose=1", nil)
+	require.NoError(t, err)
+	req.Header.Set("Authorization", "Bearer secret")
+	require.NoError(t, proxy.ProxyHTTP(newMockHTTPRespWriter(), tracing.NewTracedHTTPRequest(req, 0, &log), false))
+
+	require.NoError(t, <-done)
+	var file har.File
+	require.NoError(t, json.Unmarshal(captured.Bytes(), &file))
+	require.Len(t, file.Log.Entries, 1)
+	entry := file.Log.Entries[0]
+	assert.Equal(t, "https://example.com/status?verbose=1", entry.Request.URL)
+	assert.Contains(t, entry.Request.Headers, har.NameValue{Name: "Authorization", Value: "[REDACTED]"})
+	assert.Equal(t, http.StatusOK, entry.Response.Status)
+	assert.Equal(t, har.Content{Size: 2, MimeType: "text/plain"}, entry.Response.Content)
+}
+
+func TestProxyCORS(t *testing.T) {
+	var originRequests atomic.Int32
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		originRequests.Add(1)
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	ingressRule, err := ingress.ParseIngress(&config.Configuration{
+		TunnelID: t.Name(),
+		Ingress: []config.UnvalidatedIngressRule{
+			{
+				Hostname: "*",
+				Service:  origin.URL,
+				OriginRequest: config.OriginRequestConfig{
+					CORS: &config.CORSConfig{
+						AllowedOrigins:   []string{"https://app.example.com"},
+						AllowedMethods:   []string{"get", "put"},
+						AllowedHeaders:   []string{"Content-Type"},
+						AllowCredentials: true,
+						MaxAge:           &config.CustomDuration{Duration: time.Hour},
+					},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	log := zerolog.Nop()
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	require.NoError(t, ingressRule.StartOrigins(&log, ctx.Done()))
+	originDialer := ingress.NewOriginDialer(ingress.OriginConfig{
+		DefaultDialer:   testDefaultDialer,
+		TCPWriteTimeout: 1 * time.Second,
+	}, &log)
+	proxy := NewOriginProxy(ingressRule, originDialer, testTags, cfdflow.NewLimiter(0), nil, nil, &log)
+
+	proxyRequest := func(method, origin string) *mockHTTPRespWriter {
+		responseWriter := newMockHTTPRespWriter()
+		req, err := http.NewRequest(method, "http://api.example.com", nil)
+		require.NoError(t, err)
+		req.Header.Set("Origin", origin)
+		if method == http.MethodOptions {
+			req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+		}
+		require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, &log), false))
+		return responseWriter
+	}
+
+	// Preflight requests are answered without involving the origin
+	preflight := proxyRequest(http.MethodOptions, "https://app.example.com")
+	assert.Equal(t, http.StatusNoContent, preflight.Code)
+	assert.Equal(t, "https://app.example.com", preflight.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "GET, PUT", preflight.Header().Get("Access-Control-Allow-Methods"))
+	assert.Equal(t, "Content-Type", preflight.Header().Get("Access-Control-Allow-Headers"))
+	assert.Equal(t, "true", preflight.Header().Get("Access-Control-Allow-Credentials"))
+	assert.Equal(t, "3600", preflight.Header().Get("Access-Control-Max-Age"))
+	assert.Equal(t, int32(0), originRequests.Load())
+
+	preflight = proxyRequest(http.MethodOptions, "https://evil.example.com")
+	assert.Equal(t, http.StatusNoContent, preflight.Code)
+	assert.Empty(t, preflight.Header().Get("Access-Control-Allow-Origin"))
+
+	// The CORS headers of the origin are replaced by those of the rule
+	resp := proxyRequest(http.MethodGet, "https://app.example.com")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "https://app.example.com", resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, "ok", resp.Body.String())
+
+	resp = proxyRequest(http.MethodGet, "https://evil.example.com")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Empty(t, resp.Header().Get("Access-Control-Allow-Origin"))
+	assert.Equal(t, int32(2), originRequests.Load())
+}
+
+func TestProxyTransform(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v2/orders", r.URL.Path)
+		assert.Equal(t, "minted", r.Header.Get("X-Api-Key"))
+		w.Header().Set("Server", "legacy/1.0")
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer origin.Close()
+
+	script := filepath.Join(t.TempDir(), "transform.lua")
+	require.NoError(t, os.WriteFile(script, []byte(`
+function on_request(req)
+  req.path = "/v2" .. req.path
+  req.headers["X-Api-Key"] = "minted"
+end
+
+function on_response(resp)
+  resp.headers["Server"] = nil
+  resp.body = resp.body .. "!"
+end
+`), 0o600))
+	ingressRule, err := ingress.ParseIngress(&config.Configuration{
+		TunnelID: t.Name(),
+		Ingress: []config.UnvalidatedIngressRule{
+			{
+				Hostname: "*",
+				Service:  origin.URL,
+				OriginRequest: config.OriginRequestConfig{
+					Transform: &config.TransformConfig{Lua: script, MaxBodySize: 1024},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	log := zerolog.Nop()
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	require.NoError(t, ingressRule.StartOrigins(&log, ctx.Done()))
+	originDialer := ingress.NewOriginDialer(ingress.OriginConfig{
+		DefaultDialer:   testDefaultDialer,
+		TCPWriteTimeout: 1 * time.Second,
+	}, &log)
+	proxy := NewOriginProxy(ingressRule, originDialer, testTags, cfdflow.NewLimiter(0), nil, nil, &log)
+
+	responseWriter := newMockHTTPRespWriter()
+	req, err := http.NewRequest(http.MethodGet, "http://api.example.com/orders", nil)
+	require.NoError(t, err)
+	require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, &log), false))
+	assert.Equal(t, http.StatusOK, responseWriter.Code)
+	assert.Empty(t, responseWriter.Header().Get("Server"))
+	assert.Equal(t, "3", responseWriter.Header().Get("Content-Length"))
+	assert.Equal(t, "ok!", responseWriter.Body.String())
+}
+
 type MultipleIngressTest struct {
 	url            string
 	expectedStatus int
@@ -378,7 +722,7 @@ func runIngressTestScenarios(t *testing.T, unvalidatedIngress []config.Unvalidat
 		TCPWriteTimeout: 1 * time.Second,
 	}, &log)
 
-	proxy := NewOriginProxy(ingressRule, originDialer, testTags, cfdflow.NewLimiter(0), &log)
+	proxy := NewOriginProxy(ingressRule, originDialer, testTags, cfdflow.NewLimiter(0), nil, nil, &log)
 
 	for _, test := range tests {
 		responseWriter := newMockHTTPRespWriter()
@@ -431,7 +775,7 @@ func TestProxyError(t *testing.T) {
 		TCPWriteTimeout: 1 * time.Second,
 	}, &log)
 
-	proxy := NewOriginProxy(ing, originDialer, testTags, cfdflow.NewLimiter(0), &log)
+	proxy := NewOriginProxy(ing, originDialer, testTags, cfdflow.NewLimiter(0), nil, nil, &log)
 
 	responseWriter := newMockHTTPRespWriter()
 	req, err := http.NewRequest(http.MethodGet, "http://127.0.0.1", nil)
@@ -440,6 +784,66 @@ func TestProxyError(t *testing.T) {
 	require.Error(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, &log), false))
 }
 
+func TestOriginTimeoutPhase(t *testing.T) {
+	slowOrigin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slowOrigin.Close()
+	// Accepts connections but never completes a TLS handshake
+	silentOrigin, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer silentOrigin.Close()
+	go func() {
+		for {
+			conn, err := silentOrigin.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	tests := []struct {
+		name      string
+		url       string
+		transport *http.Transport
+		phase     string
+	}{
+		{
+			name:      "connect",
+			url:       slowOrigin.URL,
+			transport: &http.Transport{DialContext: (&net.Dialer{Timeout: time.Nanosecond}).DialContext},
+			phase:     originTimeoutConnect,
+		},
+		{
+			name:      "tls handshake",
+			url:       "https://" + silentOrigin.Addr().String(),
+			transport: &http.Transport{TLSHandshakeTimeout: 50 * time.Millisecond},
+			phase:     originTimeoutTLSHandshake,
+		},
+		{
+			name:      "response header",
+			url:       slowOrigin.URL,
+			transport: &http.Transport{ResponseHeaderTimeout: 50 * time.Millisecond},
+			phase:     originTimeoutResponseHeader,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodGet, test.url, nil)
+			require.NoError(t, err)
+			_, err = test.transport.RoundTrip(req)
+			require.Error(t, err)
+			phase, ok := originTimeoutPhase(err)
+			require.True(t, ok, err.Error())
+			assert.Equal(t, test.phase, phase)
+		})
+	}
+
+	_, ok := originTimeoutPhase(fmt.Errorf("connection refused"))
+	assert.False(t, ok)
+}
+
 type replayer struct {
 	sync.RWMutex
 	rw *bytes.Buffer
@@ -699,7 +1103,7 @@ func TestConnections(t *testing.T) {
 			flowLimiter.EXPECT().Acquire("tcp").AnyTimes().Return(test.args.flowLimiterResponse)
 			flowLimiter.EXPECT().Release().AnyTimes()
 
-			proxy := NewOriginProxy(ingressRule, originDialer, testTags, flowLimiter, &log)
+			proxy := NewOriginProxy(ingressRule, originDialer, testTags, flowLimiter, nil, nil, &log)
 
 			dest := ln.Addr().String()
 			req, err := http.NewRequest(
@@ -1014,3 +1418,53 @@ func runEchoWSService(t *testing.T, l net.Listener) {
 		}
 	}()
 }
+
+func TestProxyUsage(t *testing.T) {
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(append([]byte("echo "), body...))
+	}))
+	defer origin.Close()
+
+	ingressRule, err := ingress.ParseIngress(&config.Configuration{
+		TunnelID: t.Name(),
+		Ingress: []config.UnvalidatedIngressRule{
+			{
+				Hostname: "api.example.com",
+				Service:  origin.URL,
+			},
+			{
+				Service: "http_status:404",
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	log := zerolog.Nop()
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	require.NoError(t, ingressRule.StartOrigins(&log, ctx.Done()))
+	originDialer := ingress.NewOriginDialer(ingress.OriginConfig{
+		DefaultDialer:   testDefaultDialer,
+		TCPWriteTimeout: 1 * time.Second,
+	}, &log)
+	accounting, err := usage.New("", nil)
+	require.NoError(t, err)
+	proxy := NewOriginProxy(ingressRule, originDialer, testTags, cfdflow.NewLimiter(0), accounting, nil, &log)
+
+	for i := 0; i < 2; i++ {
+		responseWriter := newMockHTTPRespWriter()
+		req, err := http.NewRequest(http.MethodPost, "http://api.example.com", strings.NewReader("hello"))
+		require.NoError(t, err)
+		require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, &log), false))
+		require.Equal(t, "echo hello", responseWriter.Body.String())
+	}
+	responseWriter := newMockHTTPRespWriter()
+	req, err := http.NewRequest(http.MethodGet, "http://unknown.example.com", nil)
+	require.NoError(t, err)
+	require.NoError(t, proxy.ProxyHTTP(responseWriter, tracing.NewTracedHTTPRequest(req, 0, &log), false))
+
+	report := accounting.Report()
+	assert.Equal(t, usage.Totals{ToOrigin: 10, FromOrigin: 20}, report.Hostnames["api.example.com"])
+	assert.NotContains(t, report.Hostnames, "unknown.example.com")
+}
@@ -0,0 +1,154 @@
+package proxy
+
+import (
+	"io"
+	"os"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+const requestBodyBufferReadSize = 32 * 1024
+
+var errRequestBodyBufferClosed = errors.New("request body buffer closed")
+
+// requestBodyBuffer reads a request body into a temporary file as fast as the edge delivers it, while the origin
+// reads the body back from the file at its own pace. At most maxSize bytes are held in the file: once it is full, the
+// body is only read from the edge as the origin catches up, and the file is truncated each time the origin has read
+// everything in it.
+type requestBodyBuffer struct {
+	body    io.ReadCloser
+	file    *os.File
+	maxSize int64
+
+	lock sync.Mutex
+	cond *sync.Cond
+	// written and read are the offsets in the file up to which the body has been written and read
+	written int64
+	read    int64
+	// bodyErr is why reading the body ended, io.EOF once it has been buffered entirely
+	bodyErr error
+	closed  bool
+
+	closeOnce sync.Once
+}
+
+func newRequestBodyBuffer(body io.ReadCloser, dir string, maxSize int64) (*requestBodyBuffer, error) {
+	file, err := os.CreateTemp(dir, "cloudflared-request-body-")
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create the request body buffer")
+	}
+	b := &requestBodyBuffer{
+		body:    body,
+		file:    file,
+		maxSize: maxSize,
+	}
+	b.cond = sync.NewCond(&b.lock)
+	go b.fill()
+	return b, nil
+}
+
+func (b *requestBodyBuffer) fill() {
+	buf := make([]byte, requestBodyBufferReadSize)
+	for {
+		n, err := b.body.Read(buf)
+		if n > 0 {
+			if writeErr := b.write(buf[:n]); writeErr != nil {
+				b.end(writeErr)
+				return
+			}
+		}
+		if err != nil {
+			b.end(err)
+			return
+		}
+	}
+}
+
+func (b *requestBodyBuffer) write(p []byte) error {
+	b.lock.Lock()
+	for !b.closed && b.written > 0 && b.written+int64(len(p)) > b.maxSize && b.read < b.written {
+		b.cond.Wait()
+	}
+	if b.closed {
+		b.lock.Unlock()
+		return errRequestBodyBufferClosed
+	}
+	if b.written > 0 && b.read == b.written {
+		// Everything buffered so far has been read, start over at the beginning of the file
+		if err := b.file.Truncate(0); err != nil {
+			b.lock.Unlock()
+			return err
+		}
+		b.written, b.read = 0, 0
+	}
+	offset := b.written
+	b.lock.Unlock()
+
+	// The origin only reads below offset, so the file can be written without holding the lock
+	n, err := b.file.WriteAt(p, offset)
+
+	b.lock.Lock()
+	b.written += int64(n)
+	b.cond.Broadcast()
+	b.lock.Unlock()
+	return err
+}
+
+func (b *requestBodyBuffer) end(err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.bodyErr = err
+	b.cond.Broadcast()
+}
+
+func (b *requestBodyBuffer) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	b.lock.Lock()
+	for !b.closed && b.read == b.written && b.bodyErr == nil {
+		b.cond.Wait()
+	}
+	if b.closed {
+		b.lock.Unlock()
+		return 0, errRequestBodyBufferClosed
+	}
+	if b.read == b.written {
+		err := b.bodyErr
+		b.lock.Unlock()
+		return 0, err
+	}
+	offset := b.read
+	if available := b.written - b.read; int64(len(p)) > available {
+		p = p[:available]
+	}
+	b.lock.Unlock()
+
+	n, err := b.file.ReadAt(p, offset)
+	if err == io.EOF && n == len(p) {
+		err = nil
+	}
+
+	b.lock.Lock()
+	b.read += int64(n)
+	b.cond.Broadcast()
+	b.lock.Unlock()
+	return n, err
+}
+
+// Close stops buffering the body and removes the temporary file.
+func (b *requestBodyBuffer) Close() error {
+	var err error
+	b.closeOnce.Do(func() {
+		b.lock.Lock()
+		b.closed = true
+		b.cond.Broadcast()
+		b.lock.Unlock()
+
+		err = b.body.Close()
+		_ = b.file.Close()
+		_ = os.Remove(b.file.Name())
+	})
+	return err
+}
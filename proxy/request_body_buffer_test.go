@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestBodyBuffer(t *testing.T) {
+	body := make([]byte, 200*1024)
+	_, err := rand.Read(body)
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	b, err := newRequestBodyBuffer(io.NopCloser(bytes.NewReader(body)), dir, 1<<20)
+	require.NoError(t, err)
+
+	// The body is read from the edge before the origin reads anything
+	require.Eventually(t, func() bool {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		return b.bodyErr == io.EOF
+	}, time.Second, 10*time.Millisecond)
+
+	buffered, err := io.ReadAll(b)
+	require.NoError(t, err)
+	require.Equal(t, body, buffered)
+
+	require.NoError(t, b.Close())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
+
+func TestRequestBodyBufferMaxSize(t *testing.T) {
+	const maxSize = 2 * requestBodyBufferReadSize
+	body := make([]byte, 10*maxSize+123)
+	_, err := rand.Read(body)
+	require.NoError(t, err)
+
+	b, err := newRequestBodyBuffer(io.NopCloser(bytes.NewReader(body)), t.TempDir(), maxSize)
+	require.NoError(t, err)
+	defer b.Close()
+
+	// Buffering stops once the file is full
+	require.Eventually(t, func() bool {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		return b.written == maxSize
+	}, time.Second, 10*time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	b.lock.Lock()
+	require.Equal(t, int64(maxSize), b.written)
+	b.lock.Unlock()
+
+	buffered, err := io.ReadAll(b)
+	require.NoError(t, err)
+	require.Equal(t, body, buffered)
+
+	info, err := b.file.Stat()
+	require.NoError(t, err)
+	require.LessOrEqual(t, info.Size(), int64(maxSize))
+}
+
+func TestRequestBodyBufferClose(t *testing.T) {
+	reader, writer := io.Pipe()
+	b, err := newRequestBodyBuffer(reader, t.TempDir(), 1<<20)
+	require.NoError(t, err)
+
+	_, err = writer.Write([]byte("partial"))
+	require.NoError(t, err)
+	buf := make([]byte, 16)
+	n, err := b.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, "partial", string(buf[:n]))
+
+	// Closing unblocks a read waiting for more of the body
+	readErr := make(chan error)
+	go func() {
+		_, err := b.Read(buf)
+		readErr <- err
+	}()
+	require.NoError(t, b.Close())
+	require.ErrorIs(t, <-readErr, errRequestBodyBufferClosed)
+
+	_, err = os.Stat(b.file.Name())
+	require.True(t, os.IsNotExist(err))
+}
+
+func TestRequestBodyBufferBodyError(t *testing.T) {
+	reader, writer := io.Pipe()
+	b, err := newRequestBodyBuffer(reader, t.TempDir(), 1<<20)
+	require.NoError(t, err)
+	defer b.Close()
+
+	_, err = writer.Write([]byte("partial"))
+	require.NoError(t, err)
+	_ = writer.CloseWithError(io.ErrUnexpectedEOF)
+
+	buffered, err := io.ReadAll(b)
+	require.ErrorIs(t, err, io.ErrUnexpectedEOF)
+	require.Equal(t, "partial", string(buffered))
+}
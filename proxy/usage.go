@@ -0,0 +1,71 @@
+package proxy
+
+import (
+	"io"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/usage"
+)
+
+// countingReader counts the bytes read from the wrapped reader.
+type countingReader struct {
+	io.Reader
+	count func(int)
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.count(n)
+	return n, err
+}
+
+// countingReadCloser counts the bytes read from the wrapped ReadCloser.
+type countingReadCloser struct {
+	io.ReadCloser
+	count func(int)
+}
+
+func (r *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.count(n)
+	return n, err
+}
+
+// countingWriter counts the bytes written to the wrapped writer.
+type countingWriter struct {
+	io.Writer
+	count func(int)
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.Writer.Write(p)
+	w.count(n)
+	return n, err
+}
+
+// countingReadWriteAcker counts the bytes read from the eyeball as sent to the origin, and the bytes written to the
+// eyeball as received from the origin.
+type countingReadWriteAcker struct {
+	connection.ReadWriteAcker
+	traffic *usage.Traffic
+}
+
+func (rwa *countingReadWriteAcker) Read(p []byte) (int, error) {
+	n, err := rwa.ReadWriteAcker.Read(p)
+	rwa.traffic.AddToOrigin(n)
+	return n, err
+}
+
+func (rwa *countingReadWriteAcker) Write(p []byte) (int, error) {
+	n, err := rwa.ReadWriteAcker.Write(p)
+	rwa.traffic.AddFromOrigin(n)
+	return n, err
+}
+
+// countStream makes rwa count the bytes proxied through it, if usage is accounted.
+func countStream(rwa connection.ReadWriteAcker, traffic *usage.Traffic) connection.ReadWriteAcker {
+	if traffic == nil {
+		return rwa
+	}
+	return &countingReadWriteAcker{ReadWriteAcker: rwa, traffic: traffic}
+}
@@ -45,13 +45,18 @@ func SuffixType(b []byte, datagramType DatagramV2Type) ([]byte, error) {
 	return b, nil
 }
 
-// Maximum application payload to send to / receive from QUIC datagram frame
+// Maximum application payload to send to / receive from QUIC datagram frame, lower while the MTU of the
+// connection can't carry it
 func (dm *DatagramMuxerV2) mtu() int {
+	if limit := dm.mtuTracker.MaxDatagramPayload() - sessionIDLen - typeIDLen; limit > 0 && limit < maxDatagramPayloadSize {
+		return limit
+	}
 	return maxDatagramPayloadSize
 }
 
 type DatagramMuxerV2 struct {
 	session          quic.Connection
+	mtuTracker       *MTUTracker
 	logger           *zerolog.Logger
 	sessionDemuxChan chan<- *packet.Session
 	packetDemuxChan  chan Packet
@@ -65,6 +70,7 @@ func NewDatagramMuxerV2(
 	logger := log.With().Uint8("datagramVersion", 2).Logger()
 	return &DatagramMuxerV2{
 		session:          quicSession,
+		mtuTracker:       MTUTrackerFromContext(quicSession.Context()),
 		logger:           &logger,
 		sessionDemuxChan: sessionDemuxChan,
 		packetDemuxChan:  make(chan Packet, packetChanCapacity),
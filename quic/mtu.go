@@ -0,0 +1,57 @@
+package quic
+
+import (
+	"context"
+	"sync/atomic"
+
+	"github.com/quic-go/quic-go/logging"
+)
+
+// datagramFrameOverhead is the most a short header packet and a DATAGRAM frame add to the payload of a datagram:
+// header byte, connection ID, packet number, AEAD tag, frame type and frame length.
+const datagramFrameOverhead = 1 + 20 + 4 + 16 + 1 + 2
+
+type mtuTrackerKey struct{}
+
+// MTUTracker follows the MTU quic-go discovers on a connection, so that datagram muxers know how large a payload
+// they can send before quic-go rejects it. A nil MTUTracker knows no MTU.
+type MTUTracker struct {
+	mtu atomic.Int64
+}
+
+// NewMTUTracker starts tracking with the initial packet size of the connection.
+func NewMTUTracker(initialPacketSize uint16) *MTUTracker {
+	t := &MTUTracker{}
+	t.mtu.Store(int64(initialPacketSize))
+	return t
+}
+
+// ContextWithMTUTracker returns a context that carries t. Dialing with it lets the tracer of the connection
+// update t, and the datagram muxers find it from the context of the connection.
+func ContextWithMTUTracker(ctx context.Context, t *MTUTracker) context.Context {
+	return context.WithValue(ctx, mtuTrackerKey{}, t)
+}
+
+// MTUTrackerFromContext returns the tracker ctx carries, or nil.
+func MTUTrackerFromContext(ctx context.Context) *MTUTracker {
+	if ctx == nil {
+		return nil
+	}
+	t, _ := ctx.Value(mtuTrackerKey{}).(*MTUTracker)
+	return t
+}
+
+func (t *MTUTracker) update(mtu logging.ByteCount) {
+	if t == nil {
+		return
+	}
+	t.mtu.Store(int64(mtu))
+}
+
+// MaxDatagramPayload returns the largest payload a datagram frame can currently carry, or 0 if it is unknown.
+func (t *MTUTracker) MaxDatagramPayload() int {
+	if t == nil {
+		return 0
+	}
+	return max(int(t.mtu.Load())-datagramFrameOverhead, 0)
+}
@@ -0,0 +1,40 @@
+package quic
+
+import (
+	"context"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMTUTracker(t *testing.T) {
+	var noTracker *MTUTracker
+	assert.Equal(t, 0, noTracker.MaxDatagramPayload())
+	assert.Nil(t, MTUTrackerFromContext(context.Background()))
+
+	tracker := NewMTUTracker(1232)
+	ctx := ContextWithMTUTracker(context.Background(), tracker)
+	require.Same(t, tracker, MTUTrackerFromContext(ctx))
+	assert.Equal(t, 1232-datagramFrameOverhead, tracker.MaxDatagramPayload())
+
+	// The tracer of the connection updates the tracker as quic-go discovers the MTU
+	log := zerolog.Nop()
+	connTracer := newConnTracer(newClientCollector("0", &log), tracker)
+	connTracer.UpdatedMTU(1452, true)
+	assert.Equal(t, 1452-datagramFrameOverhead, tracker.MaxDatagramPayload())
+}
+
+func TestDatagramMuxerV2MTU(t *testing.T) {
+	muxer := &DatagramMuxerV2{}
+	assert.Equal(t, maxDatagramPayloadSize, muxer.mtu())
+
+	// A small MTU lowers the payload below the maximum
+	muxer.mtuTracker = NewMTUTracker(1232)
+	assert.Equal(t, 1232-datagramFrameOverhead-sessionIDLen-typeIDLen, muxer.mtu())
+
+	// A large one doesn't raise it above the maximum
+	muxer.mtuTracker.update(9000)
+	assert.Equal(t, maxDatagramPayloadSize, muxer.mtu())
+}
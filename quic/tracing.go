@@ -22,18 +22,20 @@ func NewClientTracer(logger *zerolog.Logger, index uint8) func(context.Context,
 	return t.TracerForConnection
 }
 
-func (t *tracer) TracerForConnection(_ctx context.Context, _p logging.Perspective, _odcid logging.ConnectionID) *logging.ConnectionTracer {
-	return newConnTracer(newClientCollector(t.index, t.logger))
+func (t *tracer) TracerForConnection(ctx context.Context, _p logging.Perspective, _odcid logging.ConnectionID) *logging.ConnectionTracer {
+	return newConnTracer(newClientCollector(t.index, t.logger), MTUTrackerFromContext(ctx))
 }
 
 // connTracer collects connection level metrics
 type connTracer struct {
 	metricsCollector *clientCollector
+	mtuTracker       *MTUTracker
 }
 
-func newConnTracer(metricsCollector *clientCollector) *logging.ConnectionTracer {
+func newConnTracer(metricsCollector *clientCollector, mtuTracker *MTUTracker) *logging.ConnectionTracer {
 	tracer := connTracer{
 		metricsCollector: metricsCollector,
+		mtuTracker:       mtuTracker,
 	}
 	return &logging.ConnectionTracer{
 		StartedConnection:           tracer.StartedConnection,
@@ -99,6 +101,7 @@ func (ct *connTracer) ReceivedShortHeaderPacket(hdr *logging.ShortHeader, size l
 
 func (ct *connTracer) UpdatedMTU(mtu logging.ByteCount, done bool) {
 	ct.metricsCollector.updateMTU(mtu)
+	ct.mtuTracker.update(mtu)
 }
 
 func (ct *connTracer) UpdatedCongestionState(state logging.CongestionState) {
@@ -3,6 +3,7 @@ package v3
 import (
 	"errors"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 
@@ -42,15 +43,20 @@ type sessionManager struct {
 	mutex        sync.RWMutex
 	originDialer ingress.OriginUDPDialer
 	limiter      cfdflow.Limiter
-	metrics      Metrics
-	log          *zerolog.Logger
+	// How long sessions wait to be resumed on another connection after their connection closed.
+	resumeGrace time.Duration
+	metrics     Metrics
+	log         *zerolog.Logger
 }
 
-func NewSessionManager(metrics Metrics, log *zerolog.Logger, originDialer ingress.OriginUDPDialer, limiter cfdflow.Limiter) SessionManager {
+// NewSessionManager creates a session manager. When resumeGrace is positive, a session outlives its connection for
+// that long so that the flow can be registered again on another connection and resume with the same origin socket.
+func NewSessionManager(metrics Metrics, log *zerolog.Logger, originDialer ingress.OriginUDPDialer, limiter cfdflow.Limiter, resumeGrace time.Duration) SessionManager {
 	return &sessionManager{
 		sessions:     make(map[RequestID]Session),
 		originDialer: originDialer,
 		limiter:      limiter,
+		resumeGrace:  resumeGrace,
 		metrics:      metrics,
 		log:          log,
 	}
@@ -81,6 +87,7 @@ func (s *sessionManager) RegisterSession(request *UDPSessionRegistrationDatagram
 	session := NewSession(
 		request.RequestID,
 		request.IdleDurationHint,
+		s.resumeGrace,
 		origin,
 		origin.RemoteAddr(),
 		origin.LocalAddr(),
@@ -27,6 +27,8 @@ const (
 	DroppedReadFailed
 	// Origin payloads that are too large to proxy.
 	DroppedReadTooLarge
+	// Origin payloads read while the flow waits to be resumed on another connection.
+	DroppedReadDetached
 )
 
 var droppedReason = map[DroppedReason]string{
@@ -36,6 +38,7 @@ var droppedReason = map[DroppedReason]string{
 	DroppedWriteFlowUnknown:      "write_flow_unknown",
 	DroppedReadFailed:            "read_failed",
 	DroppedReadTooLarge:          "read_too_large",
+	DroppedReadDetached:          "read_detached",
 }
 
 func (dr DroppedReason) String() string {
@@ -11,6 +11,7 @@ import (
 
 	"github.com/cloudflare/cloudflared/ingress"
 	"github.com/cloudflare/cloudflared/packet"
+	"github.com/cloudflare/cloudflared/quic"
 )
 
 const (
@@ -41,6 +42,9 @@ type DatagramConn interface {
 // DatagramUDPWriter provides the Muxer interface to create proper UDP Datagrams when sending over a connection.
 type DatagramUDPWriter interface {
 	SendUDPSessionDatagram(datagram []byte) error
+	// MaxUDPSessionPayload is the largest origin payload SendUDPSessionDatagram can currently send, which shrinks
+	// with the MTU of the connection.
+	MaxUDPSessionPayload() int
 	SendUDPSessionResponse(id RequestID, resp SessionRegistrationResp) error
 }
 
@@ -63,6 +67,7 @@ type QuicConnection interface {
 
 type datagramConn struct {
 	conn             QuicConnection
+	mtuTracker       *quic.MTUTracker
 	index            uint8
 	sessionManager   SessionManager
 	icmpRouter       ingress.ICMPRouter
@@ -80,6 +85,7 @@ func NewDatagramConn(conn QuicConnection, sessionManager SessionManager, icmpRou
 	log := logger.With().Uint8("datagramVersion", 3).Logger()
 	return &datagramConn{
 		conn:             conn,
+		mtuTracker:       quic.MTUTrackerFromContext(conn.Context()),
 		index:            index,
 		sessionManager:   sessionManager,
 		icmpRouter:       icmpRouter,
@@ -109,6 +115,13 @@ func (c *datagramConn) SendUDPSessionDatagram(datagram []byte) error {
 	return c.conn.SendDatagram(datagram)
 }
 
+func (c *datagramConn) MaxUDPSessionPayload() int {
+	if limit := c.mtuTracker.MaxDatagramPayload() - DatagramPayloadHeaderLen; limit > 0 && limit < maxDatagramPayloadLen {
+		return limit
+	}
+	return maxDatagramPayloadLen
+}
+
 func (c *datagramConn) SendUDPSessionResponse(id RequestID, resp SessionRegistrationResp) error {
 	datagram := UDPSessionRegistrationResponseDatagram{
 		RequestID:    id,
@@ -319,8 +332,9 @@ func (c *datagramConn) handleSessionAlreadyRegistered(requestID RequestID, logge
 		return
 	}
 	// The session is already running in another routine so we want to restart the idle timeout since no proxied
-	// packets have come down yet.
-	session.ResetIdleTimer()
+	// packets have come down yet. The registration could also come from a connection that reconnected with the same
+	// index, in which case the session resumes on it.
+	session.Migrate(c, c.conn.Context(), c.logger)
 	c.metrics.RetryFlowResponse(c.index)
 	logger.Debug().Msgf("flow registration response retry")
 }
@@ -24,6 +24,7 @@ import (
 	cfdflow "github.com/cloudflare/cloudflared/flow"
 	"github.com/cloudflare/cloudflared/ingress"
 	"github.com/cloudflare/cloudflared/packet"
+	cfdquic "github.com/cloudflare/cloudflared/quic"
 	v3 "github.com/cloudflare/cloudflared/quic/v3"
 )
 
@@ -34,6 +35,7 @@ type noopEyeball struct {
 func (noopEyeball) Serve(ctx context.Context) error              { return nil }
 func (n noopEyeball) ID() uint8                                  { return n.connID }
 func (noopEyeball) SendUDPSessionDatagram(datagram []byte) error { return nil }
+func (noopEyeball) MaxUDPSessionPayload() int                    { return 1280 }
 func (noopEyeball) SendUDPSessionResponse(id v3.RequestID, resp v3.SessionRegistrationResp) error {
 	return nil
 }
@@ -72,6 +74,10 @@ func (m *mockEyeball) SendUDPSessionDatagram(datagram []byte) error {
 	return nil
 }
 
+func (m *mockEyeball) MaxUDPSessionPayload() int {
+	return 1280
+}
+
 func (m *mockEyeball) SendUDPSessionResponse(id v3.RequestID, resp v3.SessionRegistrationResp) error {
 	m.recvResp <- struct {
 		id   v3.RequestID
@@ -93,7 +99,7 @@ func TestDatagramConn_New(t *testing.T) {
 		DefaultDialer:   testDefaultDialer,
 		TCPWriteTimeout: 0,
 	}, &log)
-	conn := v3.NewDatagramConn(newMockQuicConn(t.Context()), v3.NewSessionManager(&noopMetrics{}, &log, originDialerService, cfdflow.NewLimiter(0)), &noopICMPRouter{}, 0, &noopMetrics{}, &log)
+	conn := v3.NewDatagramConn(newMockQuicConn(t.Context()), v3.NewSessionManager(&noopMetrics{}, &log, originDialerService, cfdflow.NewLimiter(0), 0), &noopICMPRouter{}, 0, &noopMetrics{}, &log)
 	if conn == nil {
 		t.Fatal("expected valid connection")
 	}
@@ -108,7 +114,7 @@ func TestDatagramConn_SendUDPSessionDatagram(t *testing.T) {
 	connCtx, connCancel := context.WithCancelCause(t.Context())
 	defer connCancel(context.Canceled)
 	quic := newMockQuicConn(connCtx)
-	conn := v3.NewDatagramConn(quic, v3.NewSessionManager(&noopMetrics{}, &log, originDialerService, cfdflow.NewLimiter(0)), &noopICMPRouter{}, 0, &noopMetrics{}, &log)
+	conn := v3.NewDatagramConn(quic, v3.NewSessionManager(&noopMetrics{}, &log, originDialerService, cfdflow.NewLimiter(0), 0), &noopICMPRouter{}, 0, &noopMetrics{}, &log)
 
 	payload := []byte{0xef, 0xef}
 	err := conn.SendUDPSessionDatagram(payload)
@@ -129,7 +135,7 @@ func TestDatagramConn_SendUDPSessionResponse(t *testing.T) {
 	connCtx, connCancel := context.WithCancelCause(t.Context())
 	defer connCancel(context.Canceled)
 	quic := newMockQuicConn(connCtx)
-	conn := v3.NewDatagramConn(quic, v3.NewSessionManager(&noopMetrics{}, &log, originDialerService, cfdflow.NewLimiter(0)), &noopICMPRouter{}, 0, &noopMetrics{}, &log)
+	conn := v3.NewDatagramConn(quic, v3.NewSessionManager(&noopMetrics{}, &log, originDialerService, cfdflow.NewLimiter(0), 0), &noopICMPRouter{}, 0, &noopMetrics{}, &log)
 
 	err := conn.SendUDPSessionResponse(testRequestID, v3.ResponseDestinationUnreachable)
 	require.NoError(t, err)
@@ -157,7 +163,7 @@ func TestDatagramConnServe_ApplicationClosed(t *testing.T) {
 	connCtx, connCancel := context.WithCancelCause(t.Context())
 	defer connCancel(context.Canceled)
 	quic := newMockQuicConn(connCtx)
-	conn := v3.NewDatagramConn(quic, v3.NewSessionManager(&noopMetrics{}, &log, originDialerService, cfdflow.NewLimiter(0)), &noopICMPRouter{}, 0, &noopMetrics{}, &log)
+	conn := v3.NewDatagramConn(quic, v3.NewSessionManager(&noopMetrics{}, &log, originDialerService, cfdflow.NewLimiter(0), 0), &noopICMPRouter{}, 0, &noopMetrics{}, &log)
 
 	ctx, cancel := context.WithTimeout(t.Context(), 1*time.Second)
 	defer cancel()
@@ -179,7 +185,7 @@ func TestDatagramConnServe_ConnectionClosed(t *testing.T) {
 	ctx, cancel := context.WithTimeout(t.Context(), 1*time.Second)
 	defer cancel()
 	quic.ctx = ctx
-	conn := v3.NewDatagramConn(quic, v3.NewSessionManager(&noopMetrics{}, &log, originDialerService, cfdflow.NewLimiter(0)), &noopICMPRouter{}, 0, &noopMetrics{}, &log)
+	conn := v3.NewDatagramConn(quic, v3.NewSessionManager(&noopMetrics{}, &log, originDialerService, cfdflow.NewLimiter(0), 0), &noopICMPRouter{}, 0, &noopMetrics{}, &log)
 
 	err := conn.Serve(t.Context())
 	if !errors.Is(err, context.DeadlineExceeded) {
@@ -194,7 +200,7 @@ func TestDatagramConnServe_ReceiveDatagramError(t *testing.T) {
 		TCPWriteTimeout: 0,
 	}, &log)
 	quic := &mockQuicConnReadError{err: net.ErrClosed}
-	conn := v3.NewDatagramConn(quic, v3.NewSessionManager(&noopMetrics{}, &log, originDialerService, cfdflow.NewLimiter(0)), &noopICMPRouter{}, 0, &noopMetrics{}, &log)
+	conn := v3.NewDatagramConn(quic, v3.NewSessionManager(&noopMetrics{}, &log, originDialerService, cfdflow.NewLimiter(0), 0), &noopICMPRouter{}, 0, &noopMetrics{}, &log)
 
 	err := conn.Serve(t.Context())
 	if !errors.Is(err, net.ErrClosed) {
@@ -979,3 +985,16 @@ func (m *mockSession) Write(payload []byte) {
 func (m *mockSession) Close() error {
 	return nil
 }
+
+func TestDatagramConn_MaxUDPSessionPayload(t *testing.T) {
+	log := zerolog.Nop()
+	conn := v3.NewDatagramConn(newMockQuicConn(t.Context()), nil, &noopICMPRouter{}, 0, &noopMetrics{}, &log)
+	require.Equal(t, 1280, conn.MaxUDPSessionPayload())
+
+	// The payload follows the MTU of the connection while it can't carry the maximum
+	tracker := cfdquic.NewMTUTracker(1232)
+	conn = v3.NewDatagramConn(newMockQuicConn(cfdquic.ContextWithMTUTracker(t.Context(), tracker)), nil, &noopICMPRouter{}, 0, &noopMetrics{}, &log)
+	smallPayload := conn.MaxUDPSessionPayload()
+	require.Equal(t, tracker.MaxDatagramPayload()-v3.DatagramPayloadHeaderLen, smallPayload)
+	require.Less(t, smallPayload, 1280)
+}
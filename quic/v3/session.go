@@ -84,8 +84,14 @@ type session struct {
 	// side may still be trying to write to this session.
 	closeWrite  chan struct{}
 	contextChan chan context.Context
-	metrics     Metrics
-	log         *zerolog.Logger
+	// How long the session waits to be migrated to another connection after its connection closed, 0 closes it
+	// with the connection.
+	resumeGrace time.Duration
+	// Set while the connection of the session is closed and the session waits to be resumed.
+	detached atomic.Bool
+	metrics  Metrics
+	// The logger is swapped for the one of the connection the session migrates to.
+	log atomic.Pointer[zerolog.Logger]
 
 	// A special close function that we wrap with sync.Once to make sure it is only called once
 	closeFn func() error
@@ -94,6 +100,7 @@ type session struct {
 func NewSession(
 	id RequestID,
 	closeAfterIdle time.Duration,
+	resumeGrace time.Duration,
 	origin io.ReadWriteCloser,
 	originAddr net.Addr,
 	localAddr net.Addr,
@@ -111,6 +118,7 @@ func NewSession(
 	session := &session{
 		id:             id,
 		closeAfterIdle: closeAfterIdle,
+		resumeGrace:    resumeGrace,
 		origin:         origin,
 		originAddr:     originAddr,
 		localAddr:      localAddr,
@@ -124,7 +132,6 @@ func NewSession(
 		// contextChan is an unbounded channel to help enforce one active migration of a session at a time.
 		contextChan: make(chan context.Context),
 		metrics:     metrics,
-		log:         &logger,
 		closeFn: sync.OnceValue(func() error {
 			// We don't want to block on sending to the close channel if it is already full
 			select {
@@ -138,6 +145,7 @@ func NewSession(
 		}),
 	}
 	session.eyeball.Store(&eyeball)
+	session.log.Store(&logger)
 	return session
 }
 
@@ -153,6 +161,10 @@ func (s *session) LocalAddr() net.Addr {
 	return s.localAddr
 }
 
+func (s *session) logger() *zerolog.Logger {
+	return s.log.Load()
+}
+
 func (s *session) ConnectionID() uint8 {
 	eyeball := *(s.eyeball.Load())
 	return eyeball.ID()
@@ -160,17 +172,24 @@ func (s *session) ConnectionID() uint8 {
 
 func (s *session) Migrate(eyeball DatagramConn, ctx context.Context, logger *zerolog.Logger) {
 	current := *(s.eyeball.Load())
-	// Only migrate if the connection ids are different.
-	if current.ID() != eyeball.ID() {
+	// Only migrate if the connections are different. A connection that reconnected keeps its index, so it is compared
+	// by identity.
+	if current != eyeball {
 		s.eyeball.Store(&eyeball)
-		s.contextChan <- ctx
+		// Origin payloads can be sent to the new connection right away, before the serve loop switches contexts.
+		s.detached.Store(false)
 		log := logger.With().Str(logFlowID, s.id.String()).Logger()
-		s.log = &log
+		s.log.Store(&log)
+		select {
+		case s.contextChan <- ctx:
+		case <-s.closeWrite:
+			// The session closed before it could be migrated, there is no serve loop left to switch contexts.
+			return
+		}
+		s.metrics.MigrateFlow(eyeball.ID())
 	}
 	// The session is already running so we want to restart the idle timeout since no proxied packets have come down yet.
 	s.markActive()
-	connectionIndex := eyeball.ID()
-	s.metrics.MigrateFlow(connectionIndex)
 }
 
 func (s *session) Serve(ctx context.Context) error {
@@ -193,28 +212,42 @@ func (s *session) readLoop() {
 		n, err := s.origin.Read(readBuffer[DatagramPayloadHeaderLen:])
 		if err != nil {
 			if isConnectionClosed(err) {
-				s.log.Debug().Msgf("flow (read) connection closed: %v", err)
+				s.logger().Debug().Msgf("flow (read) connection closed: %v", err)
 			}
 			s.closeSession(err)
 			return
 		}
 		if n < 0 {
 			s.metrics.DroppedUDPDatagram(s.ConnectionID(), DroppedReadFailed)
-			s.log.Warn().Int(logPacketSizeKey, n).Msg("flow (origin) packet read was negative and was dropped")
-			continue
-		}
-		if n > maxDatagramPayloadLen {
-			s.metrics.DroppedUDPDatagram(s.ConnectionID(), DroppedReadTooLarge)
-			s.log.Error().Int(logPacketSizeKey, n).Msg("flow (origin) packet read was too large and was dropped")
+			s.logger().Warn().Int(logPacketSizeKey, n).Msg("flow (origin) packet read was negative and was dropped")
 			continue
 		}
 		// We need to synchronize on the eyeball in-case that the connection was migrated. This should be rarely a point
 		// of lock contention, as a migration can only happen during startup of a session before traffic flow.
 		eyeball := *(s.eyeball.Load())
+		// The connection can carry less than maxDatagramPayloadLen while its MTU is small, sending a larger payload
+		// would fail and close the session.
+		if n > eyeball.MaxUDPSessionPayload() {
+			s.metrics.DroppedUDPDatagram(s.ConnectionID(), DroppedReadTooLarge)
+			s.logger().Error().Int(logPacketSizeKey, n).Msg("flow (origin) packet read was too large and was dropped")
+			continue
+		}
+		if s.detached.Load() {
+			// There is no connection to send the payload to until the session is resumed.
+			s.metrics.DroppedUDPDatagram(eyeball.ID(), DroppedReadDetached)
+			continue
+		}
 		// Sending a packet to the session does block on the [quic.Connection], however, this is okay because it
 		// will cause back-pressure to the kernel buffer if the writes are not fast enough to the edge.
 		err = eyeball.SendUDPSessionDatagram(readBuffer[:DatagramPayloadHeaderLen+n])
 		if err != nil {
+			if s.resumeGrace > 0 {
+				// The connection is likely closing, the serve loop decides if the session waits to be resumed
+				// once it observes the closure.
+				s.metrics.DroppedUDPDatagram(eyeball.ID(), DroppedWriteFailed)
+				s.logger().Debug().Err(err).Msg("flow (origin) packet could not be sent to the connection and was dropped")
+				continue
+			}
 			s.closeSession(err)
 			return
 		}
@@ -228,7 +261,7 @@ func (s *session) Write(payload []byte) {
 	case s.writeChan <- payload:
 	default:
 		s.metrics.DroppedUDPDatagram(s.ConnectionID(), DroppedWriteFull)
-		s.log.Error().Msg("failed to write flow payload to origin: dropped")
+		s.logger().Error().Msg("failed to write flow payload to origin: dropped")
 	}
 }
 
@@ -246,13 +279,13 @@ func (s *session) writeLoop() {
 				// Check if this is a write deadline exceeded to the connection
 				if errors.Is(err, os.ErrDeadlineExceeded) {
 					s.metrics.DroppedUDPDatagram(s.ConnectionID(), DroppedWriteDeadlineExceeded)
-					s.log.Warn().Err(err).Msg("flow (write) deadline exceeded: dropping packet")
+					s.logger().Warn().Err(err).Msg("flow (write) deadline exceeded: dropping packet")
 					continue
 				}
 				if isConnectionClosed(err) {
-					s.log.Debug().Msgf("flow (write) connection closed: %v", err)
+					s.logger().Debug().Msgf("flow (write) connection closed: %v", err)
 				}
-				s.log.Err(err).Msg("failed to write flow payload to origin")
+				s.logger().Err(err).Msg("failed to write flow payload to origin")
 				s.closeSession(err)
 				// If we fail to write to the origin socket, we need to end the writer and close the session
 				return
@@ -260,7 +293,7 @@ func (s *session) writeLoop() {
 			// Write must return a non-nil error if it returns n < len(p). https://pkg.go.dev/io#Writer
 			if n < len(payload) {
 				s.metrics.DroppedUDPDatagram(s.ConnectionID(), DroppedWriteFailed)
-				s.log.Err(io.ErrShortWrite).Msg("failed to write the full flow payload to origin")
+				s.logger().Err(io.ErrShortWrite).Msg("failed to write the full flow payload to origin")
 				continue
 			}
 			// Mark the session as active since we successfully proxied a packet to the origin.
@@ -281,7 +314,7 @@ func (s *session) closeSession(err error) {
 	default:
 		// In the case that the errChan is already full, we will skip over it and return as to not block
 		// the caller because we should start cleaning up the session.
-		s.log.Warn().Msg("error channel was full")
+		s.logger().Warn().Msg("error channel was full")
 	}
 }
 
@@ -320,15 +353,49 @@ func (s *session) waitForCloseCondition(ctx context.Context, closeAfterIdle time
 	checkIdleTimer := time.NewTimer(closeAfterIdle)
 	defer checkIdleTimer.Stop()
 
+	// Once the connection of the session closed, the session waits to be resumed on another connection until the
+	// resume grace timer fires.
+	var (
+		connDone    = connCtx.Done()
+		detachedErr error
+		resumeGrace *time.Timer
+		resumeC     <-chan time.Time
+	)
+	defer func() {
+		if resumeGrace != nil {
+			resumeGrace.Stop()
+		}
+	}()
 	for {
 		select {
-		case <-connCtx.Done():
-			return connCtx.Err()
+		case <-connDone:
+			if s.resumeGrace <= 0 {
+				return connCtx.Err()
+			}
+			detachedErr = connCtx.Err()
+			connDone = nil
+			s.detached.Store(true)
+			if resumeGrace == nil {
+				resumeGrace = time.NewTimer(s.resumeGrace)
+			} else {
+				resumeGrace.Reset(s.resumeGrace)
+			}
+			resumeC = resumeGrace.C
+			s.logger().Debug().Msgf("flow connection closed: waiting %s for the flow to be resumed", s.resumeGrace)
+		case <-resumeC:
+			return detachedErr
 		case newContext := <-s.contextChan:
 			// During migration of a session, we need to make sure that the context of the new connection is used instead
 			// of the old connection context. This will ensure that when the old connection goes away, this session will
 			// still be active on the existing connection.
 			connCtx = newContext
+			connDone = connCtx.Done()
+			if resumeC != nil {
+				resumeGrace.Stop()
+				resumeC = nil
+				s.detached.Store(false)
+				s.logger().Debug().Msg("flow resumed")
+			}
 			continue
 		case reason := <-s.errChan:
 			// Any error returned here is from the read or write loops indicating that it can no longer process datagrams
@@ -25,7 +25,7 @@ var (
 
 func TestSessionNew(t *testing.T) {
 	log := zerolog.Nop()
-	session := v3.NewSession(testRequestID, 5*time.Second, nil, testOriginAddr, testLocalAddr, &noopEyeball{}, &noopMetrics{}, &log)
+	session := v3.NewSession(testRequestID, 5*time.Second, 0, nil, testOriginAddr, testLocalAddr, &noopEyeball{}, &noopMetrics{}, &log)
 	if testRequestID != session.ID() {
 		t.Fatalf("session id doesn't match: %s != %s", testRequestID, session.ID())
 	}
@@ -48,7 +48,7 @@ func testSessionWrite(t *testing.T, payloads [][]byte) {
 	}()
 
 	// Create a session
-	session := v3.NewSession(testRequestID, 5*time.Second, origin, testOriginAddr, testLocalAddr, &noopEyeball{}, &noopMetrics{}, &log)
+	session := v3.NewSession(testRequestID, 5*time.Second, 0, origin, testOriginAddr, testLocalAddr, &noopEyeball{}, &noopMetrics{}, &log)
 	defer session.Close()
 	// Start the Serve to begin the writeLoop
 	ctx, cancel := context.WithCancelCause(t.Context())
@@ -91,7 +91,7 @@ func testSessionRead(t *testing.T, payloads [][]byte) {
 	defer origin.Close()
 	defer server.Close()
 	eyeball := newMockEyeball()
-	session := v3.NewSession(testRequestID, 3*time.Second, origin, testOriginAddr, testLocalAddr, &eyeball, &noopMetrics{}, &log)
+	session := v3.NewSession(testRequestID, 3*time.Second, 0, origin, testOriginAddr, testLocalAddr, &eyeball, &noopMetrics{}, &log)
 	defer session.Close()
 
 	ctx, cancel := context.WithCancelCause(t.Context())
@@ -144,7 +144,7 @@ func TestSessionRead_OriginTooLarge(t *testing.T) {
 	origin, server := net.Pipe()
 	defer origin.Close()
 	defer server.Close()
-	session := v3.NewSession(testRequestID, 2*time.Second, origin, testOriginAddr, testLocalAddr, &eyeball, &noopMetrics{}, &log)
+	session := v3.NewSession(testRequestID, 2*time.Second, 0, origin, testOriginAddr, testLocalAddr, &eyeball, &noopMetrics{}, &log)
 	defer session.Close()
 
 	done := make(chan error)
@@ -175,7 +175,7 @@ func TestSessionServe_Migrate(t *testing.T) {
 	log := zerolog.Nop()
 	eyeball := newMockEyeball()
 	pipe1, pipe2 := net.Pipe()
-	session := v3.NewSession(testRequestID, 2*time.Second, pipe2, testOriginAddr, testLocalAddr, &eyeball, &noopMetrics{}, &log)
+	session := v3.NewSession(testRequestID, 2*time.Second, 0, pipe2, testOriginAddr, testLocalAddr, &eyeball, &noopMetrics{}, &log)
 	defer session.Close()
 
 	done := make(chan error)
@@ -232,7 +232,7 @@ func TestSessionServe_Migrate_CloseContext2(t *testing.T) {
 	log := zerolog.Nop()
 	eyeball := newMockEyeball()
 	pipe1, pipe2 := net.Pipe()
-	session := v3.NewSession(testRequestID, 2*time.Second, pipe2, testOriginAddr, testLocalAddr, &eyeball, &noopMetrics{}, &log)
+	session := v3.NewSession(testRequestID, 2*time.Second, 0, pipe2, testOriginAddr, testLocalAddr, &eyeball, &noopMetrics{}, &log)
 	defer session.Close()
 
 	done := make(chan error)
@@ -287,13 +287,81 @@ func TestSessionServe_Migrate_CloseContext2(t *testing.T) {
 	}
 }
 
+func TestSessionServe_ResumeAfterConnectionClosed(t *testing.T) {
+	defer leaktest.Check(t)()
+	log := zerolog.Nop()
+	eyeball := newMockEyeball()
+	pipe1, pipe2 := net.Pipe()
+	session := v3.NewSession(testRequestID, 5*time.Second, 5*time.Second, pipe2, testOriginAddr, testLocalAddr, &eyeball, &noopMetrics{}, &log)
+	defer session.Close()
+
+	done := make(chan error)
+	eyeball1Ctx, cancel := context.WithCancelCause(t.Context())
+	go func() {
+		done <- session.Serve(eyeball1Ctx)
+	}()
+
+	// Close the connection; the session should wait to be resumed
+	cancel(errors.New("connection closed"))
+	select {
+	case err := <-done:
+		t.Fatalf("expected session to wait to be resumed: %+v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// The connection reconnects with the same index and resumes the session
+	eyeball2 := newMockEyeball()
+	session.Migrate(&eyeball2, t.Context(), &log)
+
+	// Origin sends data
+	payload := []byte{0xde}
+	_, _ = pipe1.Write(payload)
+
+	// Expect write to eyeball2
+	data := <-eyeball2.recvData
+	if len(data) <= 17 || !slices.Equal(payload, data[17:]) {
+		t.Fatalf("expected data to write to eyeball2 after resumption: %+v", data)
+	}
+
+	_ = session.Close()
+	err := <-done
+	if !errors.Is(err, v3.SessionCloseErr) {
+		t.Fatalf("session Serve should be closed: %+v", err)
+	}
+}
+
+func TestSessionServe_ResumeGraceExpires(t *testing.T) {
+	defer leaktest.Check(t)()
+	log := zerolog.Nop()
+	eyeball := newMockEyeball()
+	_, pipe2 := net.Pipe()
+	session := v3.NewSession(testRequestID, 5*time.Second, 50*time.Millisecond, pipe2, testOriginAddr, testLocalAddr, &eyeball, &noopMetrics{}, &log)
+	defer session.Close()
+
+	done := make(chan error)
+	eyeball1Ctx, cancel := context.WithCancel(t.Context())
+	go func() {
+		done <- session.Serve(eyeball1Ctx)
+	}()
+
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("session Serve should be done: %+v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("expected session to close once the resume grace expired")
+	}
+}
+
 func TestSessionClose_Multiple(t *testing.T) {
 	defer leaktest.Check(t)()
 	log := zerolog.Nop()
 	origin, server := net.Pipe()
 	defer origin.Close()
 	defer server.Close()
-	session := v3.NewSession(testRequestID, 5*time.Second, origin, testOriginAddr, testLocalAddr, &noopEyeball{}, &noopMetrics{}, &log)
+	session := v3.NewSession(testRequestID, 5*time.Second, 0, origin, testOriginAddr, testLocalAddr, &noopEyeball{}, &noopMetrics{}, &log)
 	err := session.Close()
 	if err != nil {
 		t.Fatal(err)
@@ -321,7 +389,7 @@ func TestSessionServe_IdleTimeout(t *testing.T) {
 	defer origin.Close()
 	defer server.Close()
 	closeAfterIdle := 2 * time.Second
-	session := v3.NewSession(testRequestID, closeAfterIdle, origin, testOriginAddr, testLocalAddr, &noopEyeball{}, &noopMetrics{}, &log)
+	session := v3.NewSession(testRequestID, closeAfterIdle, 0, origin, testOriginAddr, testLocalAddr, &noopEyeball{}, &noopMetrics{}, &log)
 	err := session.Serve(t.Context())
 
 	// Session should idle timeout if no reads or writes occur
@@ -349,7 +417,7 @@ func TestSessionServe_ParentContextCanceled(t *testing.T) {
 	defer server.Close()
 	closeAfterIdle := 10 * time.Second
 
-	session := v3.NewSession(testRequestID, closeAfterIdle, origin, testOriginAddr, testLocalAddr, &noopEyeball{}, &noopMetrics{}, &log)
+	session := v3.NewSession(testRequestID, closeAfterIdle, 0, origin, testOriginAddr, testLocalAddr, &noopEyeball{}, &noopMetrics{}, &log)
 	ctx, cancel := context.WithTimeout(t.Context(), 2*time.Second)
 	defer cancel()
 	err := session.Serve(ctx)
@@ -373,7 +441,7 @@ func TestSessionServe_ReadErrors(t *testing.T) {
 	defer leaktest.Check(t)()
 	log := zerolog.Nop()
 	origin := newTestErrOrigin(net.ErrClosed, nil)
-	session := v3.NewSession(testRequestID, 30*time.Second, &origin, testOriginAddr, testLocalAddr, &noopEyeball{}, &noopMetrics{}, &log)
+	session := v3.NewSession(testRequestID, 30*time.Second, 0, &origin, testOriginAddr, testLocalAddr, &noopEyeball{}, &noopMetrics{}, &log)
 	err := session.Serve(t.Context())
 	if !errors.Is(err, net.ErrClosed) {
 		t.Fatal(err)
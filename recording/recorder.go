@@ -0,0 +1,329 @@
+// Package recording records the sessions proxied to TCP origins over WebSockets, e.g. SSH through the bastion
+// service, to a local directory and optionally uploads them to an S3-compatible bucket, for compliance in admin
+// access use cases.
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// FormatRaw records the bytes sent to and received from the origin in two files, whatever the protocol.
+	FormatRaw = "raw"
+	// FormatAsciicast records the session as an asciicast v2 file that can be replayed with asciinema. It is meant
+	// for plaintext terminal protocols, the bytes of encrypted ones like SSH aren't readable.
+	FormatAsciicast = "asciicast"
+
+	toOrigin   = "to_origin"
+	fromOrigin = "from_origin"
+
+	// asciicast needs a terminal size, the one of the client isn't known
+	asciicastWidth  = 80
+	asciicastHeight = 24
+)
+
+var (
+	recordingsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cloudflared",
+		Subsystem: "recording",
+		Name:      "sessions_total",
+		Help:      "Count of sessions recorded, by result: complete, truncated when a limit was reached, or error",
+	}, []string{"result"})
+	uploadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cloudflared",
+		Subsystem: "recording",
+		Name:      "uploads_total",
+		Help:      "Count of recordings uploaded to S3, by result",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(recordingsTotal, uploadsTotal)
+}
+
+// Config configures how sessions are recorded.
+type Config struct {
+	// Dir is where recordings are written, and kept unless they are uploaded
+	Dir string
+	// Format is FormatRaw or FormatAsciicast
+	Format string
+	// MaxSize stops recording a session once that many bytes were recorded, unlimited when 0
+	MaxSize int64
+	// MaxDuration stops recording a session after that long, unlimited when 0
+	MaxDuration time.Duration
+	// S3 is where recordings are uploaded once complete, they are only kept in Dir when nil
+	S3 *S3Config
+}
+
+// Recorder records sessions. A nil Recorder records nothing.
+type Recorder struct {
+	config Config
+	log    *zerolog.Logger
+	now    func() time.Time
+	// uploads are the uploads in progress
+	uploads sync.WaitGroup
+}
+
+// New validates config and creates its directory.
+func New(config Config, log *zerolog.Logger) (*Recorder, error) {
+	if config.Dir == "" {
+		return nil, errors.New("a directory to record sessions to is required")
+	}
+	switch config.Format {
+	case "":
+		config.Format = FormatRaw
+	case FormatRaw, FormatAsciicast:
+	default:
+		return nil, fmt.Errorf("unknown recording format %q, expected %s or %s", config.Format, FormatRaw, FormatAsciicast)
+	}
+	if config.MaxSize < 0 || config.MaxDuration < 0 {
+		return nil, errors.New("recording limits can't be negative")
+	}
+	if config.S3 != nil {
+		if err := config.S3.validate(); err != nil {
+			return nil, err
+		}
+	}
+	if err := os.MkdirAll(config.Dir, 0o700); err != nil {
+		return nil, errors.Wrapf(err, "failed to create the recording directory %s", config.Dir)
+	}
+	return &Recorder{
+		config: config,
+		log:    log,
+		now:    time.Now,
+	}, nil
+}
+
+// Record returns conn, recording what is written to and read from it until it is closed. dest is the origin conn is
+// connected to and clientIP who the session is for, if known.
+func (r *Recorder) Record(conn net.Conn, dest, clientIP string) net.Conn {
+	if r == nil {
+		return conn
+	}
+	s, err := r.newSession(dest, clientIP)
+	if err != nil {
+		recordingsTotal.WithLabelValues("error").Inc()
+		r.log.Err(err).Str("dest", dest).Msg("Failed to start recording session, proxying it unrecorded")
+		return conn
+	}
+	return &recordingConn{Conn: conn, session: s}
+}
+
+// Wait waits for the uploads in progress.
+func (r *Recorder) Wait() {
+	if r == nil {
+		return
+	}
+	r.uploads.Wait()
+}
+
+type recordingConn struct {
+	net.Conn
+	session *session
+}
+
+func (c *recordingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.session.record(fromOrigin, p[:n])
+	return n, err
+}
+
+func (c *recordingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.session.record(toOrigin, p[:n])
+	return n, err
+}
+
+func (c *recordingConn) Close() error {
+	err := c.Conn.Close()
+	c.session.close()
+	return err
+}
+
+// session is the recording of a connection
+type session struct {
+	recorder *Recorder
+	name     string
+	dest     string
+	start    time.Time
+
+	lock      sync.Mutex
+	files     map[string]*os.File
+	size      int64
+	stopped   bool
+	truncated bool
+	err       error
+	closeOnce sync.Once
+}
+
+func (r *Recorder) newSession(dest, clientIP string) (*session, error) {
+	start := r.now()
+	s := &session{
+		recorder: r,
+		name:     fmt.Sprintf("%s-%s", start.UTC().Format("20060102T150405Z"), uuid.New()),
+		dest:     dest,
+		start:    start,
+		files:    make(map[string]*os.File, 2),
+	}
+	switch r.config.Format {
+	case FormatAsciicast:
+		f, err := s.create(s.name + ".cast")
+		if err != nil {
+			return nil, err
+		}
+		s.files[toOrigin], s.files[fromOrigin] = f, f
+		title := dest
+		if clientIP != "" {
+			title = fmt.Sprintf("%s to %s", clientIP, dest)
+		}
+		header, _ := json.Marshal(map[string]interface{}{
+			"version":   2,
+			"width":     asciicastWidth,
+			"height":    asciicastHeight,
+			"timestamp": start.Unix(),
+			"title":     title,
+		})
+		if _, err := f.Write(append(header, '\n')); err != nil {
+			s.closeFiles()
+			return nil, errors.Wrap(err, "failed to write the recording")
+		}
+	default:
+		for _, direction := range []string{toOrigin, fromOrigin} {
+			f, err := s.create(fmt.Sprintf("%s.%s.raw", s.name, direction))
+			if err != nil {
+				s.closeFiles()
+				return nil, err
+			}
+			s.files[direction] = f
+		}
+	}
+	r.log.Info().Str("dest", dest).Str("clientIP", clientIP).Str("recording", s.name).Msg("Recording session")
+	return s, nil
+}
+
+func (s *session) create(name string) (*os.File, error) {
+	f, err := os.OpenFile(filepath.Join(s.recorder.config.Dir, name), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create the recording")
+	}
+	return f, nil
+}
+
+// record writes data sent in direction, until a limit is reached.
+func (s *session) record(direction string, data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.stopped {
+		return
+	}
+	config := s.recorder.config
+	elapsed := s.recorder.now().Sub(s.start)
+	if config.MaxDuration > 0 && elapsed >= config.MaxDuration {
+		s.stop(fmt.Sprintf("the maximum duration of %s was reached", config.MaxDuration))
+		return
+	}
+	if config.MaxSize > 0 && s.size+int64(len(data)) > config.MaxSize {
+		data = data[:config.MaxSize-s.size]
+		defer s.stop(fmt.Sprintf("the maximum size of %d bytes was reached", config.MaxSize))
+	}
+	s.size += int64(len(data))
+	var err error
+	if config.Format == FormatAsciicast {
+		code := "o"
+		if direction == toOrigin {
+			code = "i"
+		}
+		var event []byte
+		event, err = json.Marshal([]interface{}{elapsed.Seconds(), code, string(data)})
+		if err == nil {
+			_, err = s.files[direction].Write(append(event, '\n'))
+		}
+	} else {
+		_, err = s.files[direction].Write(data)
+	}
+	if err != nil {
+		s.err = err
+		s.stopped = true
+		s.recorder.log.Err(err).Str("recording", s.name).Msg("Failed to write the recording, the rest of the session is not recorded")
+	}
+}
+
+// stop stops recording because a limit was reached, the session goes on unrecorded.
+func (s *session) stop(reason string) {
+	s.stopped = true
+	s.truncated = true
+	s.recorder.log.Warn().Str("recording", s.name).Msgf("Stopped recording the session, %s", reason)
+}
+
+// close closes the recording and uploads it if the recorder uploads to S3.
+func (s *session) close() {
+	s.closeOnce.Do(func() {
+		s.lock.Lock()
+		s.stopped = true
+		paths := s.closeFiles()
+		result := "complete"
+		if s.err != nil {
+			result = "error"
+		} else if s.truncated {
+			result = "truncated"
+		}
+		s.lock.Unlock()
+		recordingsTotal.WithLabelValues(result).Inc()
+
+		if s.recorder.config.S3 == nil {
+			return
+		}
+		s.recorder.uploads.Add(1)
+		go func() {
+			defer s.recorder.uploads.Done()
+			s.recorder.upload(paths)
+		}()
+	})
+}
+
+// closeFiles closes the files of the session and returns their paths.
+func (s *session) closeFiles() []string {
+	closed := make(map[*os.File]bool, len(s.files))
+	paths := make([]string, 0, len(s.files))
+	for _, direction := range []string{toOrigin, fromOrigin} {
+		f := s.files[direction]
+		if f == nil || closed[f] {
+			continue
+		}
+		closed[f] = true
+		if err := f.Close(); err != nil && s.err == nil {
+			s.err = err
+		}
+		paths = append(paths, f.Name())
+	}
+	return paths
+}
+
+// upload uploads the recordings at paths, and removes the ones uploaded.
+func (r *Recorder) upload(paths []string) {
+	for _, path := range paths {
+		if err := r.config.S3.upload(path); err != nil {
+			uploadsTotal.WithLabelValues("error").Inc()
+			r.log.Err(err).Str("recording", path).Msg("Failed to upload the recording, it is kept locally")
+			continue
+		}
+		uploadsTotal.WithLabelValues("success").Inc()
+		if err := os.Remove(path); err != nil {
+			r.log.Err(err).Str("recording", path).Msg("Failed to remove the uploaded recording")
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+var testLogger = zerolog.Nop()
+
+func TestNewValidatesConfig(t *testing.T) {
+	dir := t.TempDir()
+	invalid := []Config{
+		{},
+		{Dir: dir, Format: "mp4"},
+		{Dir: dir, MaxSize: -1},
+		{Dir: dir, S3: &S3Config{Bucket: "recordings"}},
+		{Dir: dir, S3: &S3Config{Endpoint: "s3.example.com", Bucket: "recordings", AccessKeyID: "id", SecretAccessKey: "secret"}},
+	}
+	for _, config := range invalid {
+		_, err := New(config, &testLogger)
+		require.Error(t, err, "%+v", config)
+	}
+
+	recorder, err := New(Config{Dir: filepath.Join(dir, "sessions")}, &testLogger)
+	require.NoError(t, err)
+	require.Equal(t, FormatRaw, recorder.config.Format)
+	info, err := os.Stat(filepath.Join(dir, "sessions"))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o700), info.Mode().Perm())
+}
+
+func TestNilRecorder(t *testing.T) {
+	conn, _ := net.Pipe()
+	var recorder *Recorder
+	require.Equal(t, conn, recorder.Record(conn, "localhost:22", ""))
+	recorder.Wait()
+}
+
+// echo returns a connection to an origin that echoes what it is sent, and the recording of it.
+func echo(t *testing.T, recorder *Recorder) net.Conn {
+	client, origin := net.Pipe()
+	go func() {
+		_, _ = io.Copy(origin, origin)
+		origin.Close()
+	}()
+	return recorder.Record(client, "localhost:22", "192.0.2.1")
+}
+
+func exchange(t *testing.T, conn net.Conn, messages ...string) {
+	for _, message := range messages {
+		_, err := conn.Write([]byte(message))
+		require.NoError(t, err)
+		buf := make([]byte, len(message))
+		_, err = io.ReadFull(conn, buf)
+		require.NoError(t, err)
+		require.Equal(t, message, string(buf))
+	}
+}
+
+func recordings(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	names := make([]string, len(entries))
+	for i, entry := range entries {
+		names[i] = entry.Name()
+	}
+	return names
+}
+
+func TestRecordRaw(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := New(Config{Dir: dir}, &testLogger)
+	require.NoError(t, err)
+
+	conn := echo(t, recorder)
+	exchange(t, conn, "ls\n", "exit\n")
+	require.NoError(t, conn.Close())
+	// Closing again doesn't record anything else
+	conn.Close()
+
+	names := recordings(t, dir)
+	require.Len(t, names, 2)
+	for _, name := range names {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		require.Equal(t, "ls\nexit\n", string(content), name)
+	}
+}
+
+func TestRecordAsciicast(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := New(Config{Dir: dir, Format: FormatAsciicast}, &testLogger)
+	require.NoError(t, err)
+
+	conn := echo(t, recorder)
+	exchange(t, conn, "whoami\n")
+	require.NoError(t, conn.Close())
+
+	names := recordings(t, dir)
+	require.Len(t, names, 1)
+	require.True(t, strings.HasSuffix(names[0], ".cast"))
+	f, err := os.Open(filepath.Join(dir, names[0]))
+	require.NoError(t, err)
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+
+	require.True(t, scanner.Scan())
+	var header map[string]interface{}
+	require.NoError(t, json.Unmarshal(scanner.Bytes(), &header))
+	require.Equal(t, float64(2), header["version"])
+	require.Equal(t, "192.0.2.1 to localhost:22", header["title"])
+
+	var codes []string
+	for scanner.Scan() {
+		var event []interface{}
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		require.Len(t, event, 3)
+		require.Equal(t, "whoami\n", event[2])
+		codes = append(codes, event[1].(string))
+	}
+	require.Equal(t, []string{"i", "o"}, codes)
+}
+
+func TestRecordLimits(t *testing.T) {
+	dir := t.TempDir()
+	recorder, err := New(Config{Dir: dir, MaxSize: 4}, &testLogger)
+	require.NoError(t, err)
+
+	conn := echo(t, recorder)
+	// The session goes on once the limit is reached, unrecorded
+	exchange(t, conn, "abc", "def")
+	require.NoError(t, conn.Close())
+	var recorded string
+	for _, name := range recordings(t, dir) {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		recorded += string(content)
+	}
+	require.Len(t, recorded, 4)
+
+	dir = t.TempDir()
+	recorder, err = New(Config{Dir: dir, MaxDuration: time.Minute}, &testLogger)
+	require.NoError(t, err)
+	now := time.Now()
+	recorder.now = func() time.Time { return now }
+	conn = echo(t, recorder)
+	exchange(t, conn, "abc")
+	now = now.Add(time.Minute)
+	exchange(t, conn, "def")
+	require.NoError(t, conn.Close())
+	for _, name := range recordings(t, dir) {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		require.NoError(t, err)
+		require.Equal(t, "abc", string(content))
+	}
+}
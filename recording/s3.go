@@ -0,0 +1,176 @@
+package recording
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	accessKeyIDEnv     = "AWS_ACCESS_KEY_ID"
+	secretAccessKeyEnv = "AWS_SECRET_ACCESS_KEY"
+	sessionTokenEnv    = "AWS_SESSION_TOKEN"
+
+	s3Service       = "s3"
+	s3UploadTimeout = 5 * time.Minute
+	amzDateFormat   = "20060102T150405Z"
+	amzDayFormat    = "20060102"
+)
+
+// S3Config is an S3-compatible bucket recordings are uploaded to, with path-style requests so that it works with
+// any endpoint.
+type S3Config struct {
+	// Endpoint is the URL of the S3 API, e.g. https://s3.us-east-1.amazonaws.com
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to the names of the recordings in the bucket
+	Prefix string
+	// AccessKeyID and SecretAccessKey default to the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables
+	AccessKeyID     string
+	SecretAccessKey string
+	// SessionToken is required with temporary credentials. It defaults to the AWS_SESSION_TOKEN environment variable
+	// when the access key ID is taken from the environment.
+	SessionToken string
+
+	client *http.Client
+	now    func() time.Time
+}
+
+func (c *S3Config) validate() error {
+	if c.Endpoint == "" || c.Bucket == "" {
+		return errors.New("an endpoint and a bucket are required to upload recordings to S3")
+	}
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil || endpoint.Host == "" || (endpoint.Scheme != "https" && endpoint.Scheme != "http") {
+		return fmt.Errorf("invalid S3 endpoint %q, expected an http or https URL", c.Endpoint)
+	}
+	if c.Region == "" {
+		c.Region = "us-east-1"
+	}
+	if c.AccessKeyID == "" {
+		c.AccessKeyID = os.Getenv(accessKeyIDEnv)
+		if c.SessionToken == "" {
+			c.SessionToken = os.Getenv(sessionTokenEnv)
+		}
+	}
+	if c.SecretAccessKey == "" {
+		c.SecretAccessKey = os.Getenv(secretAccessKeyEnv)
+	}
+	if c.AccessKeyID == "" || c.SecretAccessKey == "" {
+		return fmt.Errorf("S3 credentials are required to upload recordings, set them in the configuration or in %s and %s", accessKeyIDEnv, secretAccessKeyEnv)
+	}
+	if c.client == nil {
+		c.client = &http.Client{Timeout: s3UploadTimeout}
+	}
+	if c.now == nil {
+		c.now = time.Now
+	}
+	return nil
+}
+
+// upload puts the file at filePath in the bucket, named after its base name.
+func (c *S3Config) upload(filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, f)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	endpoint, err := url.Parse(c.Endpoint)
+	if err != nil {
+		return err
+	}
+	endpoint.Path = path.Join("/", endpoint.Path, c.Bucket, c.Prefix, filepath.Base(filePath))
+	req, err := http.NewRequest(http.MethodPut, endpoint.String(), f)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	c.sign(req, hex.EncodeToString(hash.Sum(nil)))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("S3 responded with %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// sign signs req with AWS Signature Version 4, payloadHash being the hex encoded SHA-256 of its body.
+func (c *S3Config) sign(req *http.Request, payloadHash string) {
+	now := c.now().UTC()
+	amzDate := now.Format(amzDateFormat)
+	day := now.Format(amzDayFormat)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	// The host header is sent from req.URL.Host, and the headers are signed in alphabetical order
+	signedHeaders := []string{"host", "x-amz-content-sha256", "x-amz-date"}
+	canonicalHeaders := []string{
+		"host:" + req.URL.Host,
+		"x-amz-content-sha256:" + payloadHash,
+		"x-amz-date:" + amzDate,
+	}
+	if c.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", c.SessionToken)
+		signedHeaders = append(signedHeaders, "x-amz-security-token")
+		canonicalHeaders = append(canonicalHeaders, "x-amz-security-token:"+c.SessionToken)
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.RawQuery,
+		strings.Join(canonicalHeaders, "\n"),
+		"",
+		strings.Join(signedHeaders, ";"),
+		payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{day, c.Region, s3Service, "aws4_request"}, "/")
+	canonicalHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(canonicalHash[:]),
+	}, "\n")
+	signature := hex.EncodeToString(hmacSHA256(signingKey(c.SecretAccessKey, day, c.Region, s3Service), stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		c.AccessKeyID, scope, strings.Join(signedHeaders, ";"), signature))
+}
+
+func signingKey(secret, day, region, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secret), day)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
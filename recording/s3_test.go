@@ -0,0 +1,125 @@
+package recording
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// From the AWS documentation on deriving the signing key of Signature Version 4
+func TestSigningKey(t *testing.T) {
+	key := signingKey("wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "20120215", "us-east-1", "iam")
+	require.Equal(t, "f4780e2d9f65fa895f9c67b32ce1baf0b0d8a43505a000a1a9e090d414db404d", hex.EncodeToString(key))
+}
+
+func TestS3CredentialsFromEnv(t *testing.T) {
+	t.Setenv(accessKeyIDEnv, "env-id")
+	t.Setenv(secretAccessKeyEnv, "env-secret")
+	t.Setenv(sessionTokenEnv, "env-token")
+	config := &S3Config{Endpoint: "https://s3.example.com", Bucket: "recordings"}
+	require.NoError(t, config.validate())
+	require.Equal(t, "env-id", config.AccessKeyID)
+	require.Equal(t, "env-secret", config.SecretAccessKey)
+	require.Equal(t, "env-token", config.SessionToken)
+	require.Equal(t, "us-east-1", config.Region)
+
+	// The session token of the environment doesn't go with the credentials of the configuration
+	config = &S3Config{Endpoint: "https://s3.example.com", Bucket: "recordings", AccessKeyID: "id", SecretAccessKey: "secret"}
+	require.NoError(t, config.validate())
+	require.Empty(t, config.SessionToken)
+
+	t.Setenv(secretAccessKeyEnv, "")
+	require.Error(t, (&S3Config{Endpoint: "https://s3.example.com", Bucket: "recordings"}).validate())
+}
+
+func TestSignWithSessionToken(t *testing.T) {
+	config := &S3Config{
+		Endpoint:        "https://s3.example.com",
+		Bucket:          "recordings",
+		AccessKeyID:     "id",
+		SecretAccessKey: "secret",
+		SessionToken:    "token",
+	}
+	require.NoError(t, config.validate())
+	config.now = func() time.Time { return time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC) }
+	req, err := http.NewRequest(http.MethodPut, "https://s3.example.com/recordings/session.cast", nil)
+	require.NoError(t, err)
+	config.sign(req, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")
+
+	require.Equal(t, "token", req.Header.Get("X-Amz-Security-Token"))
+	require.Empty(t, req.Header.Get("Host"))
+	require.Contains(t, req.Header.Get("Authorization"),
+		"Credential=id/20261016/us-east-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token, Signature=")
+}
+
+func TestUploadToS3(t *testing.T) {
+	var (
+		lock     sync.Mutex
+		uploaded = make(map[string]string)
+		fail     bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		defer lock.Unlock()
+		if fail {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		require.Equal(t, http.MethodPut, r.Method)
+		require.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=id/"))
+		require.NotEmpty(t, r.Header.Get("X-Amz-Content-Sha256"))
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		uploaded[r.URL.Path] = string(body)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	recorder, err := New(Config{
+		Dir: dir,
+		S3: &S3Config{
+			Endpoint:        server.URL,
+			Bucket:          "recordings",
+			Prefix:          "ssh",
+			AccessKeyID:     "id",
+			SecretAccessKey: "secret",
+		},
+	}, &testLogger)
+	require.NoError(t, err)
+
+	conn := echo(t, recorder)
+	exchange(t, conn, "uptime\n")
+	require.NoError(t, conn.Close())
+	recorder.Wait()
+
+	lock.Lock()
+	require.Len(t, uploaded, 2)
+	for path, body := range uploaded {
+		require.True(t, strings.HasPrefix(path, "/recordings/ssh/"), path)
+		require.Equal(t, "uptime\n", body)
+	}
+	fail = true
+	lock.Unlock()
+	// Uploaded recordings are removed
+	require.Empty(t, recordings(t, dir))
+
+	conn = echo(t, recorder)
+	exchange(t, conn, "uptime\n")
+	require.NoError(t, conn.Close())
+	recorder.Wait()
+	// Recordings that fail to upload are kept
+	names := recordings(t, dir)
+	require.Len(t, names, 2)
+	content, err := os.ReadFile(filepath.Join(dir, names[0]))
+	require.NoError(t, err)
+	require.Equal(t, "uptime\n", string(content))
+}
@@ -0,0 +1,142 @@
+// Package redact scrubs secrets, e.g. tokens, credentials and cookies, from text that ends up in logs, error
+// messages and diagnostic reports.
+package redact
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Placeholder replaces every secret.
+const Placeholder = "[REDACTED]"
+
+// minSecretLength keeps short values, which would match ordinary text, from being registered as secrets.
+const minSecretLength = 8
+
+type rule struct {
+	pattern *regexp.Regexp
+	// replacement may refer to the submatches of pattern that aren't secret, e.g. a header name
+	replacement string
+}
+
+var rules = []rule{
+	// Userinfo of URLs, e.g. proxy URLs in error messages
+	{regexp.MustCompile(`(?i)\b([a-z][a-z0-9+.-]*://)[^/\s:@"']+(:[^/\s@"']*)?@`), "${1}" + Placeholder + "@"},
+	// Cookies are redacted as a whole before anything else, they can hold several values
+	{regexp.MustCompile(`(?i)((?:set-)?cookie"?\s*[:=]\s*\[?\s*"?)[^"\]\r\n]+`), "${1}" + Placeholder},
+	// Authorization headers keep their scheme
+	{regexp.MustCompile(`(?i)((?:proxy-)?authorization"?\s*[:=]\s*\[?\s*"?(?:(?:bearer|basic|token)\s+)?)[^\s"',;\]]+`), "${1}" + Placeholder},
+	// Values of keys that name a secret, in headers, query strings, JSON or key=value pairs
+	{regexp.MustCompile(`(?i)(\b(?:[a-z]+[-_]?)?(?:token|secret|password|passwd|api[-_]?key)"?\s*[:=]\s*"?)[^\s"',;&\]]+`), "${1}" + Placeholder},
+	// Tunnel tokens and JWTs, base64 encoded JSON
+	{regexp.MustCompile(`\beyJ[A-Za-z0-9_-]{10,}(?:\.[A-Za-z0-9_-]+)*=*`), Placeholder},
+}
+
+// anyRule matches wherever one of the rules does. Most text has no secret, it is scanned once by anyRule instead
+// of once per rule.
+var anyRule = combineRules(rules)
+
+func combineRules(rules []rule) *regexp.Regexp {
+	patterns := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		patterns = append(patterns, "(?:"+rule.pattern.String()+")")
+	}
+	return regexp.MustCompile(strings.Join(patterns, "|"))
+}
+
+var (
+	secretsLock sync.RWMutex
+	secrets     []string
+)
+
+// AddSecret makes every later redaction scrub value, wherever it appears. It is meant for secrets that were
+// configured or loaded, e.g. a tunnel token, which the patterns may not recognize. Short values are ignored.
+func AddSecret(value string) {
+	value = strings.TrimSpace(value)
+	if len(value) < minSecretLength {
+		return
+	}
+	secretsLock.Lock()
+	defer secretsLock.Unlock()
+	for _, secret := range secrets {
+		if secret == value {
+			return
+		}
+	}
+	secrets = append(secrets, value)
+	// Longer secrets first, so that a secret containing another one is redacted as a whole
+	sort.Slice(secrets, func(i, j int) bool {
+		return len(secrets[i]) > len(secrets[j])
+	})
+}
+
+// String returns s with its secrets replaced by Placeholder.
+func String(s string) string {
+	secretsLock.RLock()
+	for _, secret := range secrets {
+		s = strings.ReplaceAll(s, secret, Placeholder)
+	}
+	secretsLock.RUnlock()
+	if !anyRule.MatchString(s) {
+		return s
+	}
+	for _, rule := range rules {
+		s = rule.pattern.ReplaceAllString(s, rule.replacement)
+	}
+	return s
+}
+
+// Bytes returns b with its secrets replaced by Placeholder. b is returned as is if it has no secrets.
+func Bytes(b []byte) []byte {
+	secretsLock.RLock()
+	for _, secret := range secrets {
+		if bytes.Contains(b, []byte(secret)) {
+			b = bytes.ReplaceAll(b, []byte(secret), []byte(Placeholder))
+		}
+	}
+	secretsLock.RUnlock()
+	if !anyRule.Match(b) {
+		return b
+	}
+	for _, rule := range rules {
+		if rule.pattern.Match(b) {
+			b = rule.pattern.ReplaceAll(b, []byte(rule.replacement))
+		}
+	}
+	return b
+}
+
+// Error returns the message of err with its secrets replaced by Placeholder, or an empty string if err is nil.
+func Error(err error) string {
+	if err == nil {
+		return ""
+	}
+	return String(err.Error())
+}
+
+// Copy copies src to dst line by line, redacting each line, so that secrets aren't split between two writes.
+func Copy(dst io.Writer, src io.Reader) (int64, error) {
+	reader := bufio.NewReader(src)
+	var written int64
+	for {
+		line, readErr := reader.ReadBytes('\n')
+		if len(line) > 0 {
+			n, err := dst.Write(Bytes(line))
+			written += int64(n)
+			if err != nil {
+				return written, err
+			}
+		}
+		if readErr == io.EOF {
+			return written, nil
+		}
+		if readErr != nil {
+			return written, readErr
+		}
+	}
+}
@@ -33,8 +33,13 @@ type BackoffHandler struct {
 
 	retries       uint
 	resetDeadline time.Time
+	// retryAfter, if set, is how long the next backoff waits instead of the exponential backoff period
+	retryAfter time.Duration
 
 	Clock Clock
+	// Rand, if set, is the source of the backoff jitter instead of the global one, so that a sequence of backoffs
+	// can be reproduced from a seed. It must not be shared with other goroutines.
+	Rand *rand.Rand
 }
 
 func NewBackoff(maxRetries uint, baseTime time.Duration, retryForever bool) BackoffHandler {
@@ -54,6 +59,9 @@ func (b BackoffHandler) GetMaxBackoffDuration(ctx context.Context) (time.Duratio
 		return time.Duration(0), false
 	default:
 	}
+	if b.retryAfter > 0 {
+		return b.retryAfter, true
+	}
 	if !b.resetDeadline.IsZero() && b.Clock.Now().After(b.resetDeadline) {
 		// b.retries would be set to 0 at this point
 		return time.Second, true
@@ -68,6 +76,11 @@ func (b BackoffHandler) GetMaxBackoffDuration(ctx context.Context) (time.Duratio
 // BackoffTimer returns a channel that sends the current time when the exponential backoff timeout expires.
 // Returns nil if the maximum number of retries have been used.
 func (b *BackoffHandler) BackoffTimer() <-chan time.Time {
+	if b.retryAfter > 0 {
+		timeToWait := b.retryAfter
+		b.retryAfter = 0
+		return b.Clock.After(timeToWait)
+	}
 	if !b.resetDeadline.IsZero() && b.Clock.Now().After(b.resetDeadline) {
 		b.retries = 0
 		b.resetDeadline = time.Time{}
@@ -80,7 +93,7 @@ func (b *BackoffHandler) BackoffTimer() <-chan time.Time {
 		b.retries++
 	}
 	maxTimeToWait := b.GetBaseTime() * (1 << b.retries)
-	timeToWait := time.Duration(rand.Int63n(maxTimeToWait.Nanoseconds())) // #nosec G404
+	timeToWait := b.jitter(maxTimeToWait)
 	return b.Clock.After(timeToWait)
 }
 
@@ -99,16 +112,30 @@ func (b *BackoffHandler) Backoff(ctx context.Context) bool {
 	}
 }
 
+// RetryAfter makes the next backoff wait exactly d, e.g. because the server asked to retry after d, instead of a
+// random exponential backoff period. It doesn't count as a retry.
+func (b *BackoffHandler) RetryAfter(d time.Duration) {
+	b.retryAfter = d
+}
+
 // Sets a grace period within which the backoff timer is maintained. After the grace
 // period expires, the number of retries & backoff duration is reset.
 func (b *BackoffHandler) SetGracePeriod() time.Duration {
 	maxTimeToWait := b.GetBaseTime() * 2 << (b.retries + 1)
-	timeToWait := time.Duration(rand.Int63n(maxTimeToWait.Nanoseconds())) // #nosec G404
+	timeToWait := b.jitter(maxTimeToWait)
 	b.resetDeadline = b.Clock.Now().Add(timeToWait)
 
 	return timeToWait
 }
 
+// jitter returns a random duration in [0, max).
+func (b *BackoffHandler) jitter(max time.Duration) time.Duration {
+	if b.Rand != nil {
+		return time.Duration(b.Rand.Int63n(max.Nanoseconds()))
+	}
+	return time.Duration(rand.Int63n(max.Nanoseconds())) // #nosec G404
+}
+
 func (b BackoffHandler) GetBaseTime() time.Duration {
 	if b.baseTime == 0 {
 		return DefaultBaseTime
@@ -121,6 +148,14 @@ func (b *BackoffHandler) Retries() int {
 	return int(b.retries) // #nosec G115
 }
 
+// RetriesLeft returns how many retries are left before reaching the maximum number of retries.
+func (b *BackoffHandler) RetriesLeft() int {
+	if b.retries >= b.maxRetries {
+		return 0
+	}
+	return int(b.maxRetries - b.retries) // #nosec G115
+}
+
 func (b *BackoffHandler) ReachedMaxRetries() bool {
 	return b.retries == b.maxRetries
 }
@@ -2,6 +2,8 @@ package retry
 
 import (
 	"context"
+	"math/rand"
+	"slices"
 	"testing"
 	"time"
 )
@@ -140,3 +142,78 @@ func TestBackoffRetryForever(t *testing.T) {
 		t.Fatalf("backoff returned %v instead of 8 seconds on fifth retry", duration)
 	}
 }
+
+func TestBackoffRetryAfter(t *testing.T) {
+	ctx := context.Background()
+	var waited []time.Duration
+	after := func(d time.Duration) <-chan time.Time {
+		waited = append(waited, d)
+		return immediateTimeAfter(d)
+	}
+	backoff := BackoffHandler{maxRetries: 1, Clock: Clock{time.Now, after}}
+	backoff.RetryAfter(30 * time.Second)
+	if duration, ok := backoff.GetMaxBackoffDuration(ctx); !ok || duration != 30*time.Second {
+		t.Fatalf("expected max backoff of 30s, got %v", duration)
+	}
+	if !backoff.Backoff(ctx) {
+		t.Fatalf("backoff failed after retry after")
+	}
+	if waited[0] != 30*time.Second {
+		t.Fatalf("expected to wait 30s, waited %v", waited[0])
+	}
+	// The retry after doesn't count as a retry, and only applies once
+	if backoff.Retries() != 0 {
+		t.Fatalf("expected no retries, got %d", backoff.Retries())
+	}
+	if !backoff.Backoff(ctx) {
+		t.Fatalf("backoff failed after retry after")
+	}
+	if backoff.Retries() != 1 {
+		t.Fatalf("expected 1 retry, got %d", backoff.Retries())
+	}
+}
+
+func TestBackoffRetriesLeft(t *testing.T) {
+	backoff := BackoffHandler{maxRetries: 2, Clock: Clock{time.Now, immediateTimeAfter}}
+	if left := backoff.RetriesLeft(); left != 2 {
+		t.Fatalf("expected 2 retries left, got %d", left)
+	}
+	backoff.BackoffTimer()
+	if left := backoff.RetriesLeft(); left != 1 {
+		t.Fatalf("expected 1 retry left, got %d", left)
+	}
+	backoff.BackoffTimer()
+	backoff.BackoffTimer()
+	if left := backoff.RetriesLeft(); left != 0 {
+		t.Fatalf("expected no retries left, got %d", left)
+	}
+	backoff.ResetNow()
+	if left := backoff.RetriesLeft(); left != 2 {
+		t.Fatalf("expected 2 retries left after reset, got %d", left)
+	}
+}
+
+func TestBackoffSeededJitter(t *testing.T) {
+	timesToWait := func(seed int64) []time.Duration {
+		var waits []time.Duration
+		after := func(d time.Duration) <-chan time.Time {
+			waits = append(waits, d)
+			return immediateTimeAfter(d)
+		}
+		backoff := BackoffHandler{maxRetries: 5, Clock: Clock{time.Now, after}, Rand: rand.New(rand.NewSource(seed))}
+		for backoff.Backoff(context.Background()) {
+		}
+		waits = append(waits, backoff.SetGracePeriod())
+		return waits
+	}
+	first := timesToWait(42)
+	if len(first) != 6 {
+		t.Fatalf("expected 6 backoffs, got %d", len(first))
+	}
+	if second := timesToWait(42); !slices.Equal(first, second) {
+		t.Fatalf("expected the same backoffs for the same seed, got %v and %v", first, second)
+	}
+	if other := timesToWait(43); slices.Equal(first, other) {
+		t.Fatalf("expected different backoffs for another seed, got %v", other)
+	}
+}
@@ -0,0 +1,251 @@
+package socks
+
+import (
+	"crypto/subtle"
+	"io"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/cloudflare/cloudflared/ipaccess"
+)
+
+// Reasons a SOCKS connection is rejected
+const (
+	rejectClient      = "client"
+	rejectAuth        = "auth"
+	rejectDestination = "destination"
+	rejectLimit       = "limit"
+)
+
+var (
+	connectionsActive = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: "cloudflared",
+		Subsystem: "socks",
+		Name:      "connections_active",
+		Help:      "Number of connections currently proxied by SOCKS proxy services",
+	})
+	connectionsRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cloudflared",
+		Subsystem: "socks",
+		Name:      "connections_rejected_total",
+		Help:      "Count of SOCKS connections rejected, by reason: client, auth, destination or limit",
+	}, []string{"reason"})
+	bytesProxied = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cloudflared",
+		Subsystem: "socks",
+		Name:      "bytes_total",
+		Help:      "Bytes proxied by SOCKS proxy services, by direction",
+	}, []string{"direction"})
+)
+
+func init() {
+	prometheus.MustRegister(connectionsActive, connectionsRejected, bytesProxied)
+}
+
+// User authenticates to a SOCKS proxy with a username and password.
+type User struct {
+	Name     string
+	Password string
+	// AccessPolicy restricts the destinations of the user on top of the access policy of the proxy, the user can
+	// reach every destination of the proxy when nil.
+	AccessPolicy *ipaccess.Policy
+}
+
+// PolicyConfig is the configuration of a Policy.
+type PolicyConfig struct {
+	// Users are allowed to authenticate, clients can't connect anonymously when there are any
+	Users []User
+	// ClientNetworks are the networks the clients must connect from, any client is allowed when empty
+	ClientNetworks []netip.Prefix
+	// MaxConnections caps the concurrent connections through the proxy, unlimited when 0
+	MaxConnections int
+	// MaxConnectionsPerUser caps the concurrent connections of each user, unlimited when 0
+	MaxConnectionsPerUser int
+	// BandwidthLimit caps the bytes per second of each connection in each direction, unlimited when 0
+	BandwidthLimit int64
+}
+
+// Policy restricts who can use a SOCKS proxy and how much. A nil Policy allows anonymous clients from anywhere,
+// with no limits.
+type Policy struct {
+	config PolicyConfig
+	users  map[string]User
+
+	lock            sync.Mutex
+	connections     int
+	userConnections map[string]int
+}
+
+// NewPolicy creates a Policy from config.
+func NewPolicy(config PolicyConfig) *Policy {
+	users := make(map[string]User, len(config.Users))
+	for _, user := range config.Users {
+		users[user.Name] = user
+	}
+	return &Policy{
+		config:          config,
+		users:           users,
+		userConnections: make(map[string]int),
+	}
+}
+
+// allowsClient tells whether a client connecting from ip can use the proxy. Clients whose IP is unknown are only
+// allowed when the policy doesn't restrict clients.
+func (p *Policy) allowsClient(ip netip.Addr) bool {
+	if p == nil || len(p.config.ClientNetworks) == 0 {
+		return true
+	}
+	ip = ip.Unmap()
+	for _, network := range p.config.ClientNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// session is a connection of a client to the proxy, the user it authenticated as if the policy has users
+type session struct {
+	policy *Policy
+	user   *User
+}
+
+func (p *Policy) newSession() *session {
+	return &session{policy: p}
+}
+
+// authHandler returns the AuthHandler the client authenticates with, username/password only when the policy has
+// users.
+func (s *session) authHandler() AuthHandler {
+	if s == nil || s.policy == nil || len(s.policy.users) == 0 {
+		return NewAuthHandler()
+	}
+	return &rejectingAuthHandler{
+		AuthHandler: &StandardAuthHandler{
+			authenticators: map[uint8]Authenticator{
+				UserPassAuth: NewUserPassAuthAuthenticator(s.authenticate),
+			},
+		},
+	}
+}
+
+func (s *session) authenticate(name, password string) bool {
+	user, ok := s.policy.users[name]
+	// Compare the password even if the user doesn't exist, so that the response time doesn't tell
+	valid := subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) == 1
+	if !ok || !valid {
+		return false
+	}
+	s.user = &user
+	return true
+}
+
+// rejectingAuthHandler counts the clients that fail to authenticate, including the anonymous ones.
+type rejectingAuthHandler struct {
+	AuthHandler
+}
+
+func (h *rejectingAuthHandler) Handle(reader io.Reader, writer io.Writer) error {
+	err := h.AuthHandler.Handle(reader, writer)
+	if err != nil {
+		connectionsRejected.WithLabelValues(rejectAuth).Inc()
+	}
+	return err
+}
+
+// restrictsDestinations tells whether the destinations of the session are restricted by the user's access policy.
+func (s *session) restrictsDestinations() bool {
+	return s != nil && s.user != nil && s.user.AccessPolicy != nil
+}
+
+// allowsDestination tells whether the user of the session can connect to ip and port.
+func (s *session) allowsDestination(ip net.IP, port int) (bool, *ipaccess.Rule) {
+	if !s.restrictsDestinations() {
+		return true, nil
+	}
+	return s.user.AccessPolicy.Allowed(ip, port)
+}
+
+// acquire counts a connection of the session, returns false if the proxy or the user has too many already.
+func (s *session) acquire() bool {
+	if s == nil || s.policy == nil {
+		connectionsActive.Inc()
+		return true
+	}
+	p := s.policy
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.config.MaxConnections > 0 && p.connections >= p.config.MaxConnections {
+		connectionsRejected.WithLabelValues(rejectLimit).Inc()
+		return false
+	}
+	if s.user != nil && p.config.MaxConnectionsPerUser > 0 && p.userConnections[s.user.Name] >= p.config.MaxConnectionsPerUser {
+		connectionsRejected.WithLabelValues(rejectLimit).Inc()
+		return false
+	}
+	p.connections++
+	if s.user != nil {
+		p.userConnections[s.user.Name]++
+	}
+	connectionsActive.Inc()
+	return true
+}
+
+func (s *session) release() {
+	connectionsActive.Dec()
+	if s == nil || s.policy == nil {
+		return
+	}
+	p := s.policy
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.connections--
+	if s.user != nil {
+		if p.userConnections[s.user.Name]--; p.userConnections[s.user.Name] <= 0 {
+			delete(p.userConnections, s.user.Name)
+		}
+	}
+}
+
+// limitReader counts the bytes read from r in the given direction, and caps how fast they are read to the
+// bandwidth limit of the policy.
+func (s *session) limitReader(r io.Reader, direction string) io.Reader {
+	limited := &limitedReader{
+		reader: r,
+		start:  time.Now(),
+		count:  bytesProxied.WithLabelValues(direction),
+	}
+	if s != nil && s.policy != nil {
+		limited.bytesPerSecond = s.policy.config.BandwidthLimit
+	}
+	return limited
+}
+
+type limitedReader struct {
+	reader         io.Reader
+	bytesPerSecond int64
+	start          time.Time
+	read           int64
+	count          prometheus.Counter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	// Read at most a second worth of bytes at a time, so that the pace is smooth
+	if r.bytesPerSecond > 0 && int64(len(p)) > r.bytesPerSecond {
+		p = p[:r.bytesPerSecond]
+	}
+	n, err := r.reader.Read(p)
+	r.count.Add(float64(n))
+	if r.bytesPerSecond > 0 && n > 0 {
+		r.read += int64(n)
+		due := time.Duration(float64(r.read) / float64(r.bytesPerSecond) * float64(time.Second))
+		if wait := due - time.Since(r.start); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+	return n, err
+}
@@ -0,0 +1,206 @@
+package socks
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/proxy"
+
+	"github.com/cloudflare/cloudflared/ipaccess"
+)
+
+// serveSocks serves every connection accepted on a local listener with StreamNetHandler, as if it came from clientIP.
+func serveSocks(t *testing.T, policy *Policy, clientIP netip.Addr) string {
+	t.Helper()
+	log := zerolog.Nop()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				StreamNetHandler(conn, nil, policy, clientIP, &log)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// echoServer echoes back the first 4 bytes of every connection accepted on a local listener, then closes it.
+func echoServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				_, _ = io.CopyN(conn, conn, 4)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func dialSocks(t *testing.T, socksAddr string, auth *proxy.Auth, dest string) (net.Conn, error) {
+	t.Helper()
+	dialer, err := proxy.SOCKS5("tcp", socksAddr, auth, proxy.Direct)
+	require.NoError(t, err)
+	return dialer.Dial("tcp", dest)
+}
+
+func assertEcho(t *testing.T, conn net.Conn) {
+	t.Helper()
+	_, err := conn.Write([]byte("ping"))
+	require.NoError(t, err)
+	reply := make([]byte, 4)
+	_, err = io.ReadFull(conn, reply)
+	require.NoError(t, err)
+	assert.Equal(t, "ping", string(reply))
+}
+
+func TestPolicyAuthentication(t *testing.T) {
+	dest := echoServer(t)
+	policy := NewPolicy(PolicyConfig{
+		Users: []User{{Name: "alice", Password: "alice-password"}},
+	})
+	socksAddr := serveSocks(t, policy, netip.Addr{})
+
+	conn, err := dialSocks(t, socksAddr, &proxy.Auth{User: "alice", Password: "alice-password"}, dest)
+	require.NoError(t, err)
+	assertEcho(t, conn)
+	_ = conn.Close()
+
+	_, err = dialSocks(t, socksAddr, &proxy.Auth{User: "alice", Password: "wrong"}, dest)
+	assert.Error(t, err)
+	_, err = dialSocks(t, socksAddr, &proxy.Auth{User: "mallory", Password: "alice-password"}, dest)
+	assert.Error(t, err)
+	// Anonymous clients are rejected once there are users
+	_, err = dialSocks(t, socksAddr, nil, dest)
+	assert.Error(t, err)
+}
+
+func TestPolicyClientNetworks(t *testing.T) {
+	dest := echoServer(t)
+	policy := NewPolicy(PolicyConfig{
+		ClientNetworks: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+	})
+
+	conn, err := dialSocks(t, serveSocks(t, policy, netip.MustParseAddr("192.0.2.10")), nil, dest)
+	require.NoError(t, err)
+	assertEcho(t, conn)
+	_ = conn.Close()
+
+	_, err = dialSocks(t, serveSocks(t, policy, netip.MustParseAddr("198.51.100.10")), nil, dest)
+	assert.Error(t, err)
+	// Clients whose IP is unknown can't be checked
+	_, err = dialSocks(t, serveSocks(t, policy, netip.Addr{}), nil, dest)
+	assert.Error(t, err)
+}
+
+func TestPolicyUserDestinations(t *testing.T) {
+	dest := echoServer(t)
+	_, port, err := net.SplitHostPort(dest)
+	require.NoError(t, err)
+	loopback := "127.0.0.0/8"
+	allowed, err := ipaccess.NewRuleByCIDR(&loopback, nil, true)
+	require.NoError(t, err)
+	loopbackPolicy, err := ipaccess.NewPolicy(false, []ipaccess.Rule{allowed})
+	require.NoError(t, err)
+	other := "192.0.2.0/24"
+	allowed, err = ipaccess.NewRuleByCIDR(&other, nil, true)
+	require.NoError(t, err)
+	otherPolicy, err := ipaccess.NewPolicy(false, []ipaccess.Rule{allowed})
+	require.NoError(t, err)
+
+	policy := NewPolicy(PolicyConfig{
+		Users: []User{
+			{Name: "alice", Password: "alice-password", AccessPolicy: loopbackPolicy},
+			{Name: "bob", Password: "bob-password", AccessPolicy: otherPolicy},
+		},
+	})
+	socksAddr := serveSocks(t, policy, netip.Addr{})
+
+	conn, err := dialSocks(t, socksAddr, &proxy.Auth{User: "alice", Password: "alice-password"}, net.JoinHostPort("127.0.0.1", port))
+	require.NoError(t, err)
+	assertEcho(t, conn)
+	_ = conn.Close()
+
+	_, err = dialSocks(t, socksAddr, &proxy.Auth{User: "bob", Password: "bob-password"}, net.JoinHostPort("127.0.0.1", port))
+	assert.Error(t, err)
+}
+
+func TestPolicyConnectionLimits(t *testing.T) {
+	dest := echoServer(t)
+	policy := NewPolicy(PolicyConfig{
+		Users: []User{
+			{Name: "alice", Password: "alice-password"},
+			{Name: "bob", Password: "bob-password"},
+		},
+		MaxConnections:        2,
+		MaxConnectionsPerUser: 1,
+	})
+	socksAddr := serveSocks(t, policy, netip.Addr{})
+	alice := &proxy.Auth{User: "alice", Password: "alice-password"}
+	bob := &proxy.Auth{User: "bob", Password: "bob-password"}
+
+	first, err := dialSocks(t, socksAddr, alice, dest)
+	require.NoError(t, err)
+	assertEcho(t, first)
+	// alice has as many connections as she can
+	_, err = dialSocks(t, socksAddr, alice, dest)
+	assert.Error(t, err)
+
+	second, err := dialSocks(t, socksAddr, bob, dest)
+	require.NoError(t, err)
+	assertEcho(t, second)
+
+	// Once alice closes her connection, she can connect again
+	_ = first.Close()
+	require.Eventually(t, func() bool {
+		conn, err := dialSocks(t, socksAddr, alice, dest)
+		if err != nil {
+			return false
+		}
+		_ = conn.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	_ = second.Close()
+}
+
+func TestLimitedReader(t *testing.T) {
+	policy := NewPolicy(PolicyConfig{BandwidthLimit: 1000})
+	reader := policy.newSession().limitReader(strings.NewReader(strings.Repeat("a", 1500)), "to_origin")
+
+	start := time.Now()
+	var out bytes.Buffer
+	_, err := io.Copy(&out, reader)
+	require.NoError(t, err)
+	assert.Equal(t, 1500, out.Len())
+	// 1500 bytes at 1000 bytes per second take at least 1.5 seconds
+	assert.GreaterOrEqual(t, time.Since(start), 1400*time.Millisecond)
+
+	var unlimited *session
+	reader = unlimited.limitReader(strings.NewReader("abc"), "to_origin")
+	content, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, "abc", string(content))
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/netip"
 	"strings"
 
 	"github.com/rs/zerolog"
@@ -20,6 +21,8 @@ type RequestHandler interface {
 type StandardRequestHandler struct {
 	dialer       Dialer
 	accessPolicy *ipaccess.Policy
+	// session enforces the Policy of the proxy, if any, on the connection of the client
+	session *session
 }
 
 // NewRequestHandler creates a standard SOCKS5 request handler
@@ -50,7 +53,7 @@ func (h *StandardRequestHandler) Handle(req *Request, conn io.ReadWriter) error
 
 // handleConnect is used to handle a connect command
 func (h *StandardRequestHandler) handleConnect(conn io.ReadWriter, req *Request) error {
-	if h.accessPolicy != nil {
+	if h.accessPolicy != nil || h.session.restrictsDestinations() {
 		if req.DestAddr.IP == nil {
 			addr, err := net.ResolveIPAddr("ip", req.DestAddr.FQDN)
 			if err != nil {
@@ -60,7 +63,10 @@ func (h *StandardRequestHandler) handleConnect(conn io.ReadWriter, req *Request)
 
 			req.DestAddr.IP = addr.IP
 		}
+	}
+	if h.accessPolicy != nil {
 		if allowed, rule := h.accessPolicy.Allowed(req.DestAddr.IP, req.DestAddr.Port); !allowed {
+			connectionsRejected.WithLabelValues(rejectDestination).Inc()
 			_ = sendReply(conn, ruleFailure, req.DestAddr)
 			if rule != nil {
 				return fmt.Errorf("Connect to %v denied due to iprule: %s", req.DestAddr, rule.String())
@@ -68,6 +74,19 @@ func (h *StandardRequestHandler) handleConnect(conn io.ReadWriter, req *Request)
 			return fmt.Errorf("Connect to %v denied", req.DestAddr)
 		}
 	}
+	if allowed, rule := h.session.allowsDestination(req.DestAddr.IP, req.DestAddr.Port); !allowed {
+		connectionsRejected.WithLabelValues(rejectDestination).Inc()
+		_ = sendReply(conn, ruleFailure, req.DestAddr)
+		if rule != nil {
+			return fmt.Errorf("Connect to %v denied to user %s due to iprule: %s", req.DestAddr, h.session.user.Name, rule.String())
+		}
+		return fmt.Errorf("Connect to %v denied to user %s", req.DestAddr, h.session.user.Name)
+	}
+	if !h.session.acquire() {
+		_ = sendReply(conn, ruleFailure, req.DestAddr)
+		return fmt.Errorf("Connect to %v denied, too many connections", req.DestAddr)
+	}
+	defer h.session.release()
 
 	target, localAddr, err := h.dialer.Dial(req.DestAddr.Address())
 	if err != nil {
@@ -94,12 +113,12 @@ func (h *StandardRequestHandler) handleConnect(conn io.ReadWriter, req *Request)
 	proxyDone := make(chan error, 2)
 
 	go func() {
-		_, e := io.Copy(target, req.bufConn)
+		_, e := io.Copy(target, h.session.limitReader(req.bufConn, "to_origin"))
 		proxyDone <- e
 	}()
 
 	go func() {
-		_, e := io.Copy(conn, target)
+		_, e := io.Copy(conn, h.session.limitReader(target, "from_origin"))
 		proxyDone <- e
 	}()
 
@@ -141,10 +160,24 @@ func StreamHandler(tunnelConn io.ReadWriter, originConn net.Conn, log *zerolog.L
 	}
 }
 
-func StreamNetHandler(tunnelConn io.ReadWriter, accessPolicy *ipaccess.Policy, log *zerolog.Logger) {
-	dialer := NewNetDialer()
-	requestHandler := NewRequestHandler(dialer, accessPolicy)
-	socksServer := NewConnectionHandler(requestHandler)
+// StreamNetHandler serves a SOCKS connection of a client connecting from clientIP, which is invalid if unknown, and
+// dials the destinations it requests if both accessPolicy and policy allow it.
+func StreamNetHandler(tunnelConn io.ReadWriter, accessPolicy *ipaccess.Policy, policy *Policy, clientIP netip.Addr, log *zerolog.Logger) {
+	if !policy.allowsClient(clientIP) {
+		connectionsRejected.WithLabelValues(rejectClient).Inc()
+		log.Debug().Msgf("Socks client %s denied", clientIP)
+		return
+	}
+	session := policy.newSession()
+	requestHandler := &StandardRequestHandler{
+		dialer:       NewNetDialer(),
+		accessPolicy: accessPolicy,
+		session:      session,
+	}
+	socksServer := &StandardConnectionHandler{
+		requestHandler: requestHandler,
+		authHandler:    session.authHandler(),
+	}
 
 	if err := socksServer.Serve(tunnelConn); err != nil {
 		log.Debug().Err(err).Msg("Socks stream handler error")
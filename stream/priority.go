@@ -0,0 +1,116 @@
+package stream
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+const (
+	// interactiveBufferSize caps how much data an interactive stream reads before forwarding it, so keystrokes and
+	// screen updates aren't coalesced into large writes.
+	interactiveBufferSize = 4 * 1024
+	// maxBulkYield bounds how long a bulk write waits for interactive writes, so bulk streams are never starved.
+	maxBulkYield = 10 * time.Millisecond
+)
+
+var defaultScheduler = newScheduler()
+
+// scheduler tracks the interactive writes to the tunnel in progress, so bulk streams can yield to them.
+type scheduler struct {
+	lock   sync.Mutex
+	active int
+	// idle is closed once active drops back to zero
+	idle chan struct{}
+}
+
+func newScheduler() *scheduler {
+	return &scheduler{}
+}
+
+func (s *scheduler) begin() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.active == 0 {
+		s.idle = make(chan struct{})
+	}
+	s.active++
+}
+
+func (s *scheduler) end() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.active--
+	if s.active == 0 {
+		close(s.idle)
+	}
+}
+
+// yield waits until no interactive write is in progress, or until maxWait elapses.
+func (s *scheduler) yield(maxWait time.Duration) {
+	s.lock.Lock()
+	if s.active == 0 {
+		s.lock.Unlock()
+		return
+	}
+	idle := s.idle
+	s.lock.Unlock()
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	select {
+	case <-idle:
+	case <-timer.C:
+	}
+}
+
+// interactiveWriter marks the writes to the tunnel as interactive.
+type interactiveWriter struct {
+	io.ReadWriter
+	scheduler *scheduler
+}
+
+func (w *interactiveWriter) Write(p []byte) (int, error) {
+	w.scheduler.begin()
+	defer w.scheduler.end()
+	return w.ReadWriter.Write(p)
+}
+
+// bulkWriter yields to interactive writes before writing to the tunnel.
+type bulkWriter struct {
+	io.Writer
+	scheduler *scheduler
+}
+
+func (w *bulkWriter) Write(p []byte) (int, error) {
+	w.scheduler.yield(maxBulkYield)
+	return w.Writer.Write(p)
+}
+
+// smallReads limits each read to interactiveBufferSize. It also hides any io.WriterTo/io.ReaderFrom of the
+// underlying connections, so copies go through the small buffer.
+type smallReads struct {
+	io.ReadWriter
+}
+
+func (r *smallReads) Read(p []byte) (int, error) {
+	if len(p) > interactiveBufferSize {
+		p = p[:interactiveBufferSize]
+	}
+	return r.ReadWriter.Read(p)
+}
+
+// Bulk wraps a writer to the tunnel carrying bulk data, such as HTTP response bodies. Its writes are held back,
+// briefly, while interactive streams are writing to the tunnel.
+func Bulk(w io.Writer) io.Writer {
+	return &bulkWriter{Writer: w, scheduler: defaultScheduler}
+}
+
+// PipeInteractive is Pipe for interactive protocols, such as RDP and VNC. Data is forwarded in small writes, and
+// writes to the tunnel take priority over Bulk writes.
+func PipeInteractive(tunnelConn, originConn io.ReadWriter, log *zerolog.Logger) {
+	tunnelConn = &interactiveWriter{ReadWriter: tunnelConn, scheduler: defaultScheduler}
+	Pipe(&smallReads{tunnelConn}, &smallReads{originConn}, log)
+}
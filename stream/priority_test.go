@@ -0,0 +1,60 @@
+package stream
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedulerYield(t *testing.T) {
+	s := newScheduler()
+
+	start := time.Now()
+	s.yield(time.Second)
+	require.Less(t, time.Since(start), 100*time.Millisecond, "yield should return immediately without interactive writes")
+
+	s.begin()
+	done := make(chan struct{})
+	go func() {
+		s.yield(time.Minute)
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("yield returned while an interactive write is in progress")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.end()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("yield didn't return once interactive writes finished")
+	}
+
+	s.begin()
+	defer s.end()
+	start = time.Now()
+	s.yield(20 * time.Millisecond)
+	require.GreaterOrEqual(t, time.Since(start), 20*time.Millisecond)
+}
+
+func TestSmallReads(t *testing.T) {
+	data := bytes.Repeat([]byte{'a'}, 3*interactiveBufferSize)
+	r := &smallReads{bytes.NewBuffer(data)}
+
+	buf := make([]byte, len(data))
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	require.Equal(t, interactiveBufferSize, n)
+}
+
+func TestBulkWriterWrites(t *testing.T) {
+	var buf bytes.Buffer
+	w := Bulk(&buf)
+	n, err := w.Write([]byte("response"))
+	require.NoError(t, err)
+	require.Equal(t, len("response"), n)
+	require.Equal(t, "response", buf.String())
+}
@@ -0,0 +1,95 @@
+package supervisor
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+)
+
+// maxConnectionAttempts bounds how many connection attempts are kept, the oldest are dropped first.
+const maxConnectionAttempts = 50
+
+// ConnectionAttempt describes a failed attempt to connect to the edge and register the tunnel.
+type ConnectionAttempt struct {
+	Time      time.Time `json:"time"`
+	ConnIndex uint8     `json:"conn_index"`
+	EdgeIP    string    `json:"edge_ip"`
+	Protocol  string    `json:"protocol"`
+	// Proxy is true if the connection was dialed through the edge proxy
+	Proxy bool   `json:"proxy"`
+	Error string `json:"error"`
+}
+
+// connectionAttempts keeps the most recent failed connection attempts, to summarize them when no connection
+// registers in time. A nil connectionAttempts records nothing.
+type connectionAttempts struct {
+	mu       sync.Mutex
+	attempts []ConnectionAttempt
+}
+
+func newConnectionAttempts() *connectionAttempts {
+	return &connectionAttempts{}
+}
+
+func (a *connectionAttempts) record(connIndex uint8, addr *allregions.EdgeAddr, protocol connection.Protocol, proxy bool, err error) {
+	if a == nil || err == nil {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if len(a.attempts) == maxConnectionAttempts {
+		a.attempts = a.attempts[1:]
+	}
+	a.attempts = append(a.attempts, ConnectionAttempt{
+		Time:      time.Now(),
+		ConnIndex: connIndex,
+		EdgeIP:    addr.UDP.IP.String(),
+		Protocol:  protocol.String(),
+		Proxy:     proxy,
+		Error:     err.Error(),
+	})
+}
+
+func (a *connectionAttempts) list() []ConnectionAttempt {
+	if a == nil {
+		return nil
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return append([]ConnectionAttempt(nil), a.attempts...)
+}
+
+// ReadyTimeoutError is returned when no connection registered within the ready timeout. It carries the failed
+// connection attempts, so that scripts and CI pipelines can tell why the tunnel never became ready.
+type ReadyTimeoutError struct {
+	Timeout  time.Duration
+	Attempts []ConnectionAttempt
+}
+
+func (e *ReadyTimeoutError) Error() string {
+	if len(e.Attempts) == 0 {
+		return fmt.Sprintf("no connection registered within %s", e.Timeout)
+	}
+	last := e.Attempts[len(e.Attempts)-1]
+	return fmt.Sprintf("no connection registered within %s after %d failed attempts, last error from %s over %s: %s",
+		e.Timeout, len(e.Attempts), last.EdgeIP, last.Protocol, last.Error)
+}
+
+// logAttempts logs every attempt on its own line.
+func (e *ReadyTimeoutError) logAttempts(log *zerolog.Logger) {
+	for _, attempt := range e.Attempts {
+		log.Error().
+			Time("time", attempt.Time).
+			Uint8(connection.LogFieldConnIndex, attempt.ConnIndex).
+			Str(connection.LogFieldIPAddress, attempt.EdgeIP).
+			Str("protocol", attempt.Protocol).
+			Bool("proxy", attempt.Proxy).
+			Str("error", attempt.Error).
+			Msg("Connection attempt failed")
+	}
+}
@@ -0,0 +1,146 @@
+package supervisor
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/edgediscovery"
+	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+)
+
+const (
+	auditResultRegistered = "registered"
+	auditResultFailed     = "failed"
+	auditResultCanceled   = "canceled"
+)
+
+// ConnectionAuditRecord is a line of the connection audit file, describing an attempt to connect to the edge.
+type ConnectionAuditRecord struct {
+	Time      time.Time `json:"time"`
+	ConnIndex uint8     `json:"conn_index"`
+	EdgeIP    string    `json:"edge_ip"`
+	Protocol  string    `json:"protocol"`
+	// Proxy is true if the connection was dialed through the edge proxy
+	Proxy bool `json:"proxy"`
+	// Duration is how long the attempt took, including how long the connection served if it registered
+	Duration float64 `json:"duration_seconds"`
+	// Result is registered if the connection registered, whatever ended it afterwards, failed or canceled otherwise
+	Result     string `json:"result"`
+	ErrorClass string `json:"error_class,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// connectionAudit appends a record of every attempt to connect to the edge to a JSON lines file rotated once it
+// reaches maxSize megabytes, whatever the log level, to analyze the connectivity of the tunnel after the fact.
+// A nil connectionAudit records nothing.
+type connectionAudit struct {
+	mu     sync.Mutex
+	writer *lumberjack.Logger
+}
+
+func newConnectionAudit(path string, maxSize, maxBackups int) *connectionAudit {
+	if path == "" {
+		return nil
+	}
+	return &connectionAudit{
+		writer: &lumberjack.Logger{
+			Filename:   path,
+			MaxSize:    maxSize,
+			MaxBackups: maxBackups,
+		},
+	}
+}
+
+// record appends the attempt of connIndex that started at start and ended with err.
+func (a *connectionAudit) record(
+	start time.Time,
+	connIndex uint8,
+	addr *allregions.EdgeAddr,
+	protocol connection.Protocol,
+	proxy bool,
+	registered bool,
+	err error,
+) error {
+	if a == nil {
+		return nil
+	}
+	record := ConnectionAuditRecord{
+		Time:      start.UTC(),
+		ConnIndex: connIndex,
+		EdgeIP:    addr.UDP.IP.String(),
+		Protocol:  protocol.String(),
+		Proxy:     proxy,
+		Duration:  time.Since(start).Seconds(),
+		Result:    auditResult(registered, err),
+	}
+	if err != nil {
+		record.ErrorClass = auditErrorClass(err)
+		record.Error = err.Error()
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	_, err = a.writer.Write(append(line, '\n'))
+	return err
+}
+
+func auditResult(registered bool, err error) string {
+	switch {
+	case registered:
+		return auditResultRegistered
+	case errors.Is(err, context.Canceled):
+		return auditResultCanceled
+	default:
+		return auditResultFailed
+	}
+}
+
+// auditErrorClass returns the kind of error that ended a connection attempt, to group attempts without parsing the
+// error messages.
+func auditErrorClass(err error) string {
+	var (
+		dialErr        edgediscovery.DialError
+		quicDialErr    *connection.EdgeQuicDialError
+		dupConnErr     connection.DupConnRegisterTunnelError
+		registerErr    connection.ServerRegisterTunnelError
+		edgeBackoffErr edgeBackoffError
+		idleTimeoutErr *quic.IdleTimeoutError
+		appErr         *quic.ApplicationError
+		controlErr     *connection.ControlStreamError
+		unrecoverable  unrecoverableError
+	)
+	switch {
+	case errors.Is(err, context.Canceled):
+		return "canceled"
+	case errors.As(err, &dialErr):
+		return "dial"
+	case errors.As(err, &quicDialErr):
+		return "quic_dial"
+	case errors.As(err, &dupConnErr):
+		return "duplicate_connection"
+	case errors.As(err, &registerErr):
+		return "registration"
+	case errors.As(err, &edgeBackoffErr):
+		return "edge_backoff"
+	case errors.As(err, &idleTimeoutErr):
+		return "idle_timeout"
+	case errors.As(err, &appErr):
+		return "quic_application"
+	case errors.As(err, &controlErr):
+		return "control_stream"
+	case errors.As(err, &unrecoverable):
+		return "unrecoverable"
+	default:
+		return "other"
+	}
+}
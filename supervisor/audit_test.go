@@ -0,0 +1,66 @@
+package supervisor
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+)
+
+func TestConnectionAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit := newConnectionAudit(path, 1, 1)
+	require.NotNil(t, audit)
+	addr := &allregions.EdgeAddr{UDP: &net.UDPAddr{IP: net.ParseIP("198.41.200.1"), Port: 7844}}
+	start := time.Now().Add(-time.Second)
+
+	require.NoError(t, audit.record(start, 1, addr, connection.HTTP2, true, false, edgeBackoffError{err: errors.New("too many connections")}))
+	require.NoError(t, audit.record(start, 2, addr, connection.QUIC, false, true, nil))
+	require.NoError(t, audit.record(start, 3, addr, connection.QUIC, false, false, fmt.Errorf("serving: %w", context.Canceled)))
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var records []ConnectionAuditRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record ConnectionAuditRecord
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+	require.Len(t, records, 3)
+
+	assert.Equal(t, uint8(1), records[0].ConnIndex)
+	assert.Equal(t, "198.41.200.1", records[0].EdgeIP)
+	assert.Equal(t, "http2", records[0].Protocol)
+	assert.True(t, records[0].Proxy)
+	assert.GreaterOrEqual(t, records[0].Duration, 1.0)
+	assert.Equal(t, auditResultFailed, records[0].Result)
+	assert.Equal(t, "edge_backoff", records[0].ErrorClass)
+	assert.NotEmpty(t, records[0].Error)
+
+	assert.Equal(t, auditResultRegistered, records[1].Result)
+	assert.Empty(t, records[1].ErrorClass)
+	assert.Empty(t, records[1].Error)
+
+	assert.Equal(t, auditResultCanceled, records[2].Result)
+	assert.Equal(t, "canceled", records[2].ErrorClass)
+}
+
+func TestConnectionAuditDisabled(t *testing.T) {
+	audit := newConnectionAudit("", 1, 1)
+	assert.Nil(t, audit)
+	assert.NoError(t, audit.record(time.Now(), 0, nil, connection.QUIC, false, false, errors.New("failed")))
+}
@@ -0,0 +1,170 @@
+package supervisor
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+const (
+	// MinClockSkewThreshold is the smallest skew that can be told apart from the one second resolution of Date headers
+	// and the round trip of the request.
+	MinClockSkewThreshold = 2 * time.Second
+	// clockSkewTimeout bounds a request measuring the skew
+	clockSkewTimeout = 10 * time.Second
+	// clockSkewRecheckInterval is how often the skew is measured again while delaying the registration
+	clockSkewRecheckInterval = 5 * time.Second
+)
+
+var clockSkewSeconds = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Namespace: connection.MetricsNamespace,
+		Subsystem: connection.TunnelSubsystem,
+		Name:      "clock_skew_seconds",
+		Help:      "How far the local clock was ahead of the Cloudflare edge (negative if behind) when last measured",
+	},
+)
+
+func init() {
+	prometheus.MustRegister(clockSkewSeconds)
+}
+
+var (
+	// errClockSkewUnknown is returned when the edge answered without a Date header to measure the skew with
+	errClockSkewUnknown = errors.New("the response has no Date header")
+	// errClockSkewed is returned when the certificate of the edge isn't valid at the local time, which is then too far
+	// off to measure the skew over TLS
+	errClockSkewed = errors.New("the local clock is likely skewed")
+)
+
+// clockSkewCheck compares the local clock with the Date headers of the Cloudflare edge at startup and every interval,
+// as TLS certificates and tokens fail to validate with confusing errors when the clock of a device drifts. A skew
+// beyond threshold is logged as an error. At startup the registration waits up to wait for the clock to be synced,
+// e.g. by NTP on a device that just booted. A nil clockSkewCheck checks nothing.
+type clockSkewCheck struct {
+	url       string
+	interval  time.Duration
+	threshold time.Duration
+	wait      time.Duration
+	client    *http.Client
+	now       func() time.Time
+	after     func(time.Duration) <-chan time.Time
+	log       *zerolog.Logger
+}
+
+func newClockSkewCheck(url string, interval, threshold, wait time.Duration, log *zerolog.Logger) *clockSkewCheck {
+	if url == "" || (interval <= 0 && wait <= 0) {
+		return nil
+	}
+	return &clockSkewCheck{
+		url:       url,
+		interval:  interval,
+		threshold: max(threshold, MinClockSkewThreshold),
+		wait:      wait,
+		client:    &http.Client{Timeout: clockSkewTimeout},
+		now:       time.Now,
+		after:     time.After,
+		log:       log,
+	}
+}
+
+// measure returns how far the local clock is ahead of the edge. The Date header is truncated to the second and
+// compared with the middle of the request.
+func (c *clockSkewCheck) measure(ctx context.Context) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, c.url, nil)
+	if err != nil {
+		return 0, err
+	}
+	sent := c.now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		var certErr x509.CertificateInvalidError
+		if errors.As(err, &certErr) && certErr.Reason == x509.Expired {
+			return 0, fmt.Errorf("%w: the certificate of %s isn't valid at the local time %s: %v",
+				errClockSkewed, c.url, sent.UTC().Format(time.RFC3339), err)
+		}
+		return 0, err
+	}
+	_ = resp.Body.Close()
+	received := c.now()
+	date, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return 0, errClockSkewUnknown
+	}
+	local := sent.Add(received.Sub(sent) / 2)
+	return local.Sub(date.Add(500 * time.Millisecond)).Round(time.Second), nil
+}
+
+// check measures the skew once, reporting it in the metric and logging it if it's beyond the threshold. It returns
+// false if the skew is beyond the threshold.
+func (c *clockSkewCheck) check(ctx context.Context) bool {
+	skew, err := c.measure(ctx)
+	if errors.Is(err, errClockSkewed) {
+		c.log.Error().Err(err).Msg("Clock skew detected, TLS and token validation will fail. Sync the clock with NTP")
+		return false
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			c.log.Warn().Err(err).Str("url", c.url).Msg("Failed to compare the local clock with the Cloudflare edge")
+		}
+		return true
+	}
+	clockSkewSeconds.Set(skew.Seconds())
+	if skew.Abs() <= c.threshold {
+		c.log.Debug().Dur("skew", skew).Msg("The local clock is in sync with the Cloudflare edge")
+		return true
+	}
+	direction := "ahead of"
+	if skew < 0 {
+		direction = "behind"
+	}
+	c.log.Error().Dur("skew", skew).Msgf("Clock skew of %s detected: the local clock is %s the Cloudflare edge. "+
+		"TLS and token validation may fail, sync the clock with NTP", skew.Abs(), direction)
+	return false
+}
+
+// waitForSync checks the clock before the first registration, waiting up to wait for it to be synced.
+func (c *clockSkewCheck) waitForSync(ctx context.Context) {
+	if c == nil || c.check(ctx) || c.wait <= 0 {
+		return
+	}
+	c.log.Info().Msgf("Delaying the registration up to %s until the local clock is synced", c.wait)
+	deadline := c.after(c.wait)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-deadline:
+			c.log.Warn().Msg("The local clock is still skewed, registering anyway")
+			return
+		case <-c.after(clockSkewRecheckInterval):
+			if c.check(ctx) {
+				return
+			}
+		}
+	}
+}
+
+func (c *clockSkewCheck) run(ctx context.Context) {
+	if c == nil || c.interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.check(ctx)
+		}
+	}
+}
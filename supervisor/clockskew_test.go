@@ -0,0 +1,94 @@
+package supervisor
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// edgeClock serves the Date header of a clock offset from the local one.
+func edgeClock(t *testing.T, offset *time.Duration) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Date", time.Now().Add(*offset).UTC().Format(http.TimeFormat))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestClockSkewCheckMeasures(t *testing.T) {
+	offset := -time.Minute
+	server := edgeClock(t, &offset)
+	log := zerolog.Nop()
+	check := newClockSkewCheck(server.URL, time.Hour, 30*time.Second, 0, &log)
+
+	// The local clock is ahead of the edge
+	skew, err := check.measure(context.Background())
+	require.NoError(t, err)
+	assert.InDelta(t, time.Minute.Seconds(), skew.Seconds(), 1)
+	assert.False(t, check.check(context.Background()))
+	assert.InDelta(t, 60, quicGaugeValue(t, clockSkewSeconds), 1)
+
+	offset = 10 * time.Second
+	assert.True(t, check.check(context.Background()))
+	assert.InDelta(t, -10, quicGaugeValue(t, clockSkewSeconds), 1)
+
+	assert.Nil(t, newClockSkewCheck(server.URL, 0, 30*time.Second, 0, &log))
+	assert.Nil(t, newClockSkewCheck("", time.Hour, 30*time.Second, 0, &log))
+}
+
+func TestClockSkewCheckCertificateExpired(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	log := zerolog.Nop()
+	check := newClockSkewCheck(server.URL, time.Hour, 30*time.Second, 0, &log)
+	client := server.Client()
+	client.Transport.(*http.Transport).TLSClientConfig.Time = func() time.Time {
+		return time.Now().AddDate(100, 0, 0)
+	}
+	check.client = client
+
+	_, err := check.measure(context.Background())
+	assert.ErrorIs(t, err, errClockSkewed)
+	assert.False(t, check.check(context.Background()))
+}
+
+func TestClockSkewCheckWaitsForSync(t *testing.T) {
+	offset := time.Hour
+	server := edgeClock(t, &offset)
+	log := zerolog.Nop()
+	check := newClockSkewCheck(server.URL, 0, 30*time.Second, time.Minute, &log)
+	rechecks := 0
+	deadline := make(chan time.Time)
+	check.after = func(d time.Duration) <-chan time.Time {
+		if d == time.Minute {
+			return deadline
+		}
+		rechecks++
+		// The clock is synced after two rechecks
+		if rechecks == 2 {
+			offset = 0
+		}
+		c := make(chan time.Time, 1)
+		c <- time.Time{}
+		return c
+	}
+	check.waitForSync(context.Background())
+	assert.Equal(t, 2, rechecks)
+
+	// The registration isn't delayed further than wait
+	offset = time.Hour
+	close(deadline)
+	check.after = func(d time.Duration) <-chan time.Time {
+		if d == time.Minute {
+			return deadline
+		}
+		return make(chan time.Time)
+	}
+	check.waitForSync(context.Background())
+}
@@ -0,0 +1,98 @@
+package supervisor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+const (
+	// errorBudgetMinAttempts is how many attempts with a protocol the window must hold before its success rate is
+	// trusted, so that the first failures of a starting tunnel don't make it fall back at once
+	errorBudgetMinAttempts = 5
+	// errorBudgetMaxAttempts bounds how many attempts are kept, the oldest are dropped first
+	errorBudgetMaxAttempts = 1000
+)
+
+// protocolErrorBudget decides when connections fall back to another protocol from the success rate of the attempts of
+// all the connections to register with each protocol over a sliding window, rather than from the retries of each
+// connection. A connection failing against one flaky edge IP then keeps its protocol as long as the others succeed
+// with it. A nil protocolErrorBudget leaves the connections falling back once they reached their max retries.
+type protocolErrorBudget struct {
+	window time.Duration
+	// minSuccessRate is the success rate under which the budget of a protocol is exhausted
+	minSuccessRate float64
+	now            func() time.Time
+
+	mu       sync.Mutex
+	attempts []budgetAttempt
+}
+
+type budgetAttempt struct {
+	time     time.Time
+	protocol connection.Protocol
+	success  bool
+}
+
+func newProtocolErrorBudget(minSuccessRate float64, window time.Duration) *protocolErrorBudget {
+	if minSuccessRate <= 0 || window <= 0 {
+		return nil
+	}
+	return &protocolErrorBudget{
+		window:         window,
+		minSuccessRate: minSuccessRate,
+		now:            time.Now,
+	}
+}
+
+// record records whether a connection registered with protocol.
+func (b *protocolErrorBudget) record(protocol connection.Protocol, success bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	b.expireLocked(now)
+	if len(b.attempts) == errorBudgetMaxAttempts {
+		b.attempts = b.attempts[1:]
+	}
+	b.attempts = append(b.attempts, budgetAttempt{time: now, protocol: protocol, success: success})
+}
+
+// successRate returns the success rate of the attempts with protocol in the window, and false if there were too few of
+// them to tell.
+func (b *protocolErrorBudget) successRate(protocol connection.Protocol) (float64, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expireLocked(b.now())
+	attempts, successes := 0, 0
+	for _, attempt := range b.attempts {
+		if attempt.protocol != protocol {
+			continue
+		}
+		attempts++
+		if attempt.success {
+			successes++
+		}
+	}
+	if attempts < errorBudgetMinAttempts {
+		return 0, false
+	}
+	return float64(successes) / float64(attempts), true
+}
+
+// exhausted returns whether the success rate of protocol dropped below the minimum, and the rate.
+func (b *protocolErrorBudget) exhausted(protocol connection.Protocol) (bool, float64) {
+	rate, ok := b.successRate(protocol)
+	return ok && rate < b.minSuccessRate, rate
+}
+
+func (b *protocolErrorBudget) expireLocked(now time.Time) {
+	expired := 0
+	for expired < len(b.attempts) && now.Sub(b.attempts[expired].time) > b.window {
+		expired++
+	}
+	b.attempts = b.attempts[expired:]
+}
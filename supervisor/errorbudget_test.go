@@ -0,0 +1,61 @@
+package supervisor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+func TestProtocolErrorBudget(t *testing.T) {
+	budget := newProtocolErrorBudget(0.5, time.Minute)
+	require.NotNil(t, budget)
+	now := time.Unix(0, 0)
+	budget.now = func() time.Time { return now }
+
+	// Too few attempts to tell
+	for i := 0; i < errorBudgetMinAttempts-1; i++ {
+		budget.record(connection.QUIC, false)
+	}
+	exhausted, _ := budget.exhausted(connection.QUIC)
+	assert.False(t, exhausted)
+
+	budget.record(connection.QUIC, false)
+	exhausted, rate := budget.exhausted(connection.QUIC)
+	assert.True(t, exhausted)
+	assert.Equal(t, 0.0, rate)
+
+	// Attempts with other protocols don't count
+	for i := 0; i < errorBudgetMinAttempts; i++ {
+		budget.record(connection.HTTP2, true)
+	}
+	exhausted, _ = budget.exhausted(connection.QUIC)
+	assert.True(t, exhausted)
+
+	// Other connections succeeding with QUIC make up for the failures
+	for i := 0; i < errorBudgetMinAttempts; i++ {
+		budget.record(connection.QUIC, true)
+	}
+	exhausted, rate = budget.exhausted(connection.QUIC)
+	assert.False(t, exhausted)
+	assert.Equal(t, 0.5, rate)
+
+	// Attempts out of the window are forgotten
+	now = now.Add(2 * time.Minute)
+	for i := 0; i < errorBudgetMinAttempts; i++ {
+		budget.record(connection.QUIC, i == 0)
+	}
+	exhausted, rate = budget.exhausted(connection.QUIC)
+	assert.True(t, exhausted)
+	assert.Equal(t, 0.2, rate)
+}
+
+func TestProtocolErrorBudgetDisabled(t *testing.T) {
+	assert.Nil(t, newProtocolErrorBudget(0, time.Minute))
+	assert.Nil(t, newProtocolErrorBudget(0.5, 0))
+	var budget *protocolErrorBudget
+	budget.record(connection.QUIC, false)
+}
@@ -0,0 +1,47 @@
+package supervisor
+
+import (
+	"sync"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/client"
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+// negotiatedFeatures remembers the options each connection last registered with, to report the features negotiated
+// differently when it reconnects. Otherwise a feature downgrade, e.g. from datagram v3 to v2, silently changes how
+// UDP and ICMP are proxied.
+type negotiatedFeatures struct {
+	mu      sync.Mutex
+	options map[uint8]client.ConnectionOptionsInfo
+}
+
+func newNegotiatedFeatures() *negotiatedFeatures {
+	return &negotiatedFeatures{options: make(map[uint8]client.ConnectionOptionsInfo)}
+}
+
+// registered records the options connIndex registered with, logging and counting the features that changed since it
+// last registered.
+func (n *negotiatedFeatures) registered(connIndex uint8, options *client.ConnectionOptionsSnapshot, log *zerolog.Logger) {
+	if options == nil {
+		return
+	}
+	current := options.Info()
+	n.mu.Lock()
+	previous, reconnected := n.options[connIndex]
+	n.options[connIndex] = current
+	n.mu.Unlock()
+	if !reconnected {
+		return
+	}
+	changes := current.FeatureChanges(previous)
+	if len(changes) == 0 {
+		return
+	}
+	for _, change := range changes {
+		featureChanges.WithLabelValues(change.Feature).Inc()
+	}
+	log.Warn().Uint8(connection.LogFieldConnIndex, connIndex).Interface("featureChanges", changes).
+		Msg("Connection reconnected with different features, which can change how traffic is proxied")
+}
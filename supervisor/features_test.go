@@ -0,0 +1,48 @@
+package supervisor
+
+import (
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/client"
+	"github.com/cloudflare/cloudflared/features"
+)
+
+type changingFeatureSelector struct {
+	snapshot features.FeatureSnapshot
+}
+
+func (s *changingFeatureSelector) Snapshot() features.FeatureSnapshot {
+	return s.snapshot
+}
+
+func TestNegotiatedFeaturesOnReconnect(t *testing.T) {
+	log := zerolog.Nop()
+	selector := &changingFeatureSelector{features.FeatureSnapshot{
+		DatagramVersion: features.DatagramV3,
+		FeaturesList:    []string{features.FeatureDatagramV3_2},
+	}}
+	config, err := client.NewConfig("1234", "linux_amd64", selector)
+	require.NoError(t, err)
+	negotiated := newNegotiatedFeatures()
+	datagramChanges := counterValue(t, featureChanges.WithLabelValues("datagramVersion"))
+
+	negotiated.registered(0, config.ConnectionOptionsSnapshot(nil, 0), &log)
+	negotiated.registered(0, config.ConnectionOptionsSnapshot(nil, 0), &log)
+	assert.Equal(t, datagramChanges, counterValue(t, featureChanges.WithLabelValues("datagramVersion")))
+
+	// The connection reconnects with datagram v2
+	selector.snapshot = features.FeatureSnapshot{
+		DatagramVersion: features.DatagramV2,
+		FeaturesList:    []string{features.FeatureDatagramV2},
+	}
+	negotiated.registered(0, config.ConnectionOptionsSnapshot(nil, 0), &log)
+	assert.Equal(t, datagramChanges+1, counterValue(t, featureChanges.WithLabelValues("datagramVersion")))
+
+	// Another connection registering for the first time is no change
+	negotiated.registered(1, config.ConnectionOptionsSnapshot(nil, 0), &log)
+	assert.Equal(t, datagramChanges+1, counterValue(t, featureChanges.WithLabelValues("datagramVersion")))
+}
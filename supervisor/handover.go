@@ -0,0 +1,121 @@
+package supervisor
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+// fallbackHandover coordinates moving connections to the fallback protocol. Once a connection falls back and
+// connects with the fallback protocol, the connections still serving the previous protocol are drained: they
+// unregister so the edge stops sending them new streams, wait a bounded time for their in-flight requests and
+// only then close to reconnect with the fallback protocol. Without it, each of them would break its streams when
+// it eventually fails on its own.
+type fallbackHandover struct {
+	mu    sync.Mutex
+	conns map[uint8]*connDrain
+}
+
+func newFallbackHandover() *fallbackHandover {
+	return &fallbackHandover{conns: map[uint8]*connDrain{}}
+}
+
+// register tracks a connection serving protocol until the returned connDrain is released.
+func (h *fallbackHandover) register(connIndex uint8, protocol connection.Protocol) *connDrain {
+	drain := &connDrain{
+		connIndex: connIndex,
+		protocol:  protocol,
+		drainC:    make(chan struct{}),
+		doneC:     make(chan struct{}),
+	}
+	if h == nil {
+		return drain
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.conns[connIndex] = drain
+	drain.release = func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.conns[connIndex] == drain {
+			delete(h.conns, connIndex)
+		}
+	}
+	return drain
+}
+
+// fallbackConnected drains every other connection that doesn't serve the fallback protocol and returns how many.
+func (h *fallbackHandover) fallbackConnected(connIndex uint8, fallback connection.Protocol) int {
+	if h == nil {
+		return 0
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	drained := 0
+	for index, drain := range h.conns {
+		if index == connIndex || drain.protocol == fallback {
+			continue
+		}
+		if drain.drain(fallback) {
+			drained++
+		}
+	}
+	return drained
+}
+
+// connDrain is the handle of a connection that can be drained for a handover.
+type connDrain struct {
+	connIndex uint8
+	protocol  connection.Protocol
+	release   func()
+
+	once   sync.Once
+	target connection.Protocol
+	drainC chan struct{}
+	doneC  chan struct{}
+}
+
+// drain asks the connection to hand over to the target protocol. It returns false if it was already asked to.
+func (d *connDrain) drain(target connection.Protocol) bool {
+	drained := false
+	d.once.Do(func() {
+		d.target = target
+		close(d.drainC)
+		drained = true
+	})
+	return drained
+}
+
+// shutdownC returns a channel closed when either the tunnel shuts down gracefully or the connection is drained,
+// since both unregister the connection and let its in-flight requests finish.
+func (d *connDrain) shutdownC(gracefulShutdownC <-chan struct{}) <-chan struct{} {
+	shutdownC := make(chan struct{})
+	go func() {
+		select {
+		case <-gracefulShutdownC:
+			close(shutdownC)
+		case <-d.drainC:
+			close(shutdownC)
+		case <-d.doneC:
+		}
+	}()
+	return shutdownC
+}
+
+// done releases the connection once it stopped serving.
+func (d *connDrain) done() {
+	close(d.doneC)
+	if d.release != nil {
+		d.release()
+	}
+}
+
+// handoverError ends a connection that was drained to reconnect with another protocol.
+type handoverError struct {
+	protocol connection.Protocol
+}
+
+func (e handoverError) Error() string {
+	return fmt.Sprintf("connection handed over to %s", e.protocol)
+}
@@ -0,0 +1,109 @@
+package supervisor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/tunnelstate"
+)
+
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestFallbackHandoverDrainsPreviousProtocol(t *testing.T) {
+	handover := newFallbackHandover()
+	quic0 := handover.register(0, connection.QUIC)
+	quic1 := handover.register(1, connection.QUIC)
+	http2 := handover.register(2, connection.HTTP2)
+	released := handover.register(3, connection.QUIC)
+	released.done()
+
+	assert.Equal(t, 2, handover.fallbackConnected(2, connection.HTTP2))
+	for _, drain := range []*connDrain{quic0, quic1} {
+		assert.True(t, isClosed(drain.drainC))
+		assert.Equal(t, connection.HTTP2, drain.target)
+	}
+	assert.False(t, isClosed(http2.drainC))
+	assert.False(t, isClosed(released.drainC))
+
+	// Connections already draining are not drained again.
+	assert.Equal(t, 0, handover.fallbackConnected(2, connection.HTTP2))
+}
+
+func TestConnDrainShutdownC(t *testing.T) {
+	handover := newFallbackHandover()
+
+	drained := handover.register(0, connection.QUIC)
+	shutdownC := drained.shutdownC(make(chan struct{}))
+	drained.drain(connection.HTTP2)
+	require.Eventually(t, func() bool { return isClosed(shutdownC) }, time.Second, time.Millisecond)
+
+	gracefulShutdownC := make(chan struct{})
+	shutdown := handover.register(1, connection.QUIC)
+	shutdownC = shutdown.shutdownC(gracefulShutdownC)
+	close(gracefulShutdownC)
+	require.Eventually(t, func() bool { return isClosed(shutdownC) }, time.Second, time.Millisecond)
+}
+
+type unregisteredControlStream struct {
+	connection.ControlStreamHandler
+	unregisteredC chan struct{}
+}
+
+func (s unregisteredControlStream) Unregistered() <-chan struct{} {
+	return s.unregisteredC
+}
+
+func TestWaitForHandover(t *testing.T) {
+	log := zerolog.Nop()
+	e := &EdgeTunnelServer{config: &TunnelConfig{GracePeriod: 5 * time.Second}}
+	connLog := NewConnAwareLogger(&log, tunnelstate.NewConnTracker(&log), connection.NewObserver(&log, &log))
+	controlStream := unregisteredControlStream{unregisteredC: make(chan struct{})}
+	resources := connection.NewConnResources(0)
+	streamDone := resources.StreamStarted()
+	drain := newFallbackHandover().register(0, connection.QUIC)
+
+	errC := make(chan error, 1)
+	go func() {
+		errC <- e.waitForHandover(t.Context(), connLog, drain, controlStream, resources)
+	}()
+
+	drain.drain(connection.HTTP2)
+	close(controlStream.unregisteredC)
+	select {
+	case err := <-errC:
+		require.FailNow(t, "handover did not wait for the stream in flight", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	streamDone()
+	select {
+	case err := <-errC:
+		assert.Equal(t, handoverError{protocol: connection.HTTP2}, err)
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "handover did not complete")
+	}
+}
+
+func TestWaitForHandoverWithoutDrain(t *testing.T) {
+	log := zerolog.Nop()
+	e := &EdgeTunnelServer{config: &TunnelConfig{GracePeriod: 5 * time.Second}}
+	connLog := NewConnAwareLogger(&log, tunnelstate.NewConnTracker(&log), connection.NewObserver(&log, &log))
+	drain := newFallbackHandover().register(0, connection.QUIC)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	assert.NoError(t, e.waitForHandover(ctx, connLog, drain, unregisteredControlStream{}, nil))
+}
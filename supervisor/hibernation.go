@@ -0,0 +1,324 @@
+package supervisor
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/packet"
+	v3 "github.com/cloudflare/cloudflared/quic/v3"
+	"github.com/cloudflare/cloudflared/tracing"
+)
+
+// hibernation tracks the requests proxied by a tunnel, so that a tunnel idle for long enough, with no request,
+// stream or UDP session in flight, can drop to a single connection with a low keepalive, and wake up to all its HA
+// connections as soon as a request arrives. A nil hibernation never hibernates.
+type hibernation struct {
+	idleAfter time.Duration
+	keepAlive time.Duration
+	now       func() time.Time
+
+	// lastActivity is when the last request was proxied or ended, in unix nanoseconds
+	lastActivity atomic.Int64
+	// inFlight is the number of requests, streams and UDP sessions being proxied, the tunnel isn't idle while any is
+	inFlight    atomic.Int64
+	hibernating atomic.Bool
+	wakeC       chan struct{}
+}
+
+func newHibernation(idleAfter, keepAlive time.Duration) *hibernation {
+	if idleAfter <= 0 {
+		return nil
+	}
+	h := &hibernation{
+		idleAfter: idleAfter,
+		keepAlive: keepAlive,
+		now:       time.Now,
+		wakeC:     make(chan struct{}, 1),
+	}
+	h.lastActivity.Store(h.now().UnixNano())
+	return h
+}
+
+// recordActivity notes that a request is proxied, and wakes the tunnel up if it is hibernating.
+func (h *hibernation) recordActivity() {
+	h.lastActivity.Store(h.now().UnixNano())
+	if h.hibernating.Load() {
+		select {
+		case h.wakeC <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// startActivity notes that a request, stream or UDP session starts being proxied. The returned function notes that
+// it ended, it must be called once.
+func (h *hibernation) startActivity() func() {
+	h.inFlight.Add(1)
+	h.recordActivity()
+	return func() {
+		h.recordActivity()
+		h.inFlight.Add(-1)
+	}
+}
+
+// idleTimer returns a channel that fires when the tunnel may have been idle for long enough to hibernate.
+// Returns nil if hibernation is disabled or the tunnel is hibernating already.
+func (h *hibernation) idleTimer(clock func(time.Duration) <-chan time.Time) <-chan time.Time {
+	if h == nil || h.hibernating.Load() {
+		return nil
+	}
+	return clock(h.idleAfter - h.idleFor())
+}
+
+// idleFor returns how long the tunnel has been idle, zero while anything is in flight.
+func (h *hibernation) idleFor() time.Duration {
+	if h.inFlight.Load() > 0 {
+		return 0
+	}
+	return h.now().Sub(time.Unix(0, h.lastActivity.Load()))
+}
+
+// shouldHibernate tells whether the tunnel has been idle for long enough with nothing in flight, and starts
+// hibernating if so.
+func (h *hibernation) shouldHibernate() bool {
+	if h.idleFor() < h.idleAfter {
+		return false
+	}
+	// Drop a wake up left over from the previous hibernation
+	select {
+	case <-h.wakeC:
+	default:
+	}
+	h.hibernating.Store(true)
+	hibernating.Set(1)
+	return true
+}
+
+// woken returns a channel that receives once a request arrives while the tunnel is hibernating.
+func (h *hibernation) woken() <-chan struct{} {
+	if h == nil {
+		return nil
+	}
+	return h.wakeC
+}
+
+func (h *hibernation) wake() {
+	h.hibernating.Store(false)
+	hibernating.Set(0)
+	hibernationWakes.Inc()
+}
+
+// quicKeepAlive returns the keepalive period of QUIC connections dialed now, and the idle timeout that goes with it.
+func (h *hibernation) quicKeepAlive(keepAlive, idleTimeout time.Duration) (time.Duration, time.Duration) {
+	if h == nil || !h.hibernating.Load() || h.keepAlive <= keepAlive {
+		return keepAlive, idleTimeout
+	}
+	// The connection is closed if no keepalive is acknowledged within the idle timeout
+	return h.keepAlive, max(idleTimeout, 3*h.keepAlive)
+}
+
+// orchestrator wraps orchestrator to record the requests and streams proxied by connections while they are in flight.
+func (h *hibernation) orchestrator(orchestrator connection.Orchestrator) connection.Orchestrator {
+	if h == nil {
+		return orchestrator
+	}
+	return &activityOrchestrator{Orchestrator: orchestrator, hibernation: h}
+}
+
+type activityOrchestrator struct {
+	connection.Orchestrator
+	hibernation *hibernation
+}
+
+func (o *activityOrchestrator) GetOriginProxy() (connection.OriginProxy, error) {
+	originProxy, err := o.Orchestrator.GetOriginProxy()
+	if err != nil {
+		return nil, err
+	}
+	return &activityOriginProxy{OriginProxy: originProxy, hibernation: o.hibernation}, nil
+}
+
+type activityOriginProxy struct {
+	connection.OriginProxy
+	hibernation *hibernation
+}
+
+func (p *activityOriginProxy) ProxyHTTP(w connection.ResponseWriter, tr *tracing.TracedHTTPRequest, isWebsocket bool) error {
+	defer p.hibernation.startActivity()()
+	return p.OriginProxy.ProxyHTTP(w, tr, isWebsocket)
+}
+
+func (p *activityOriginProxy) ProxyTCP(ctx context.Context, rwa connection.ReadWriteAcker, req *connection.TCPRequest) error {
+	defer p.hibernation.startActivity()()
+	return p.OriginProxy.ProxyTCP(ctx, rwa, req)
+}
+
+// udpDialer wraps dialer to record the UDP sessions proxied by connections, of both datagram versions, until their
+// origin socket is closed.
+func (h *hibernation) udpDialer(dialer ingress.OriginUDPDialer) ingress.OriginUDPDialer {
+	if h == nil {
+		return dialer
+	}
+	return &activityUDPDialer{OriginUDPDialer: dialer, hibernation: h}
+}
+
+type activityUDPDialer struct {
+	ingress.OriginUDPDialer
+	hibernation *hibernation
+}
+
+func (d *activityUDPDialer) DialUDP(addr netip.AddrPort) (net.Conn, error) {
+	end := d.hibernation.startActivity()
+	conn, err := d.OriginUDPDialer.DialUDP(addr)
+	if err != nil {
+		end()
+		return nil, err
+	}
+	return &activityConn{Conn: conn, end: end}, nil
+}
+
+// activityConn is the origin socket of a UDP session, in flight until it is closed.
+type activityConn struct {
+	net.Conn
+	end       func()
+	closeOnce sync.Once
+}
+
+func (c *activityConn) Close() error {
+	c.closeOnce.Do(c.end)
+	return c.Conn.Close()
+}
+
+// sessionManager wraps sessionManager to record the UDP sessions proxied by datagram v3 connections, and each of
+// their datagrams coming from the edge.
+func (h *hibernation) sessionManager(sessionManager v3.SessionManager) v3.SessionManager {
+	if h == nil {
+		return sessionManager
+	}
+	return &activitySessionManager{SessionManager: sessionManager, hibernation: h}
+}
+
+type activitySessionManager struct {
+	v3.SessionManager
+	hibernation *hibernation
+}
+
+func (m *activitySessionManager) RegisterSession(request *v3.UDPSessionRegistrationDatagram, conn v3.DatagramConn) (v3.Session, error) {
+	m.hibernation.recordActivity()
+	return m.SessionManager.RegisterSession(request, conn)
+}
+
+func (m *activitySessionManager) GetSession(requestID v3.RequestID) (v3.Session, error) {
+	m.hibernation.recordActivity()
+	return m.SessionManager.GetSession(requestID)
+}
+
+// icmpRouter wraps icmpRouter to record the ICMP requests proxied by connections. Returns nil if icmpRouter is nil,
+// as connections don't proxy ICMP without a router.
+func (h *hibernation) icmpRouter(icmpRouter ingress.ICMPRouter) ingress.ICMPRouter {
+	if h == nil || icmpRouter == nil {
+		return icmpRouter
+	}
+	return &activityICMPRouter{ICMPRouter: icmpRouter, hibernation: h}
+}
+
+type activityICMPRouter struct {
+	ingress.ICMPRouter
+	hibernation *hibernation
+}
+
+func (r *activityICMPRouter) Request(ctx context.Context, pk *packet.ICMP, responder ingress.ICMPResponder) error {
+	r.hibernation.recordActivity()
+	return r.ICMPRouter.Request(ctx, pk, responder)
+}
+
+// hibernate reconnects the first connection with the hibernation keepalive. The other connections keep serving
+// until it is connected again, then hibernateOthers stops them, so that the tunnel is never left without connections.
+func (s *Supervisor) hibernate(tunnelsWaiting []int) {
+	s.log.Logger().Info().Msgf("No requests for %s, hibernating with a single connection", s.hibernation.idleAfter)
+	hibernations.Inc()
+	s.hibernationPending = true
+	// A connection waiting to reconnect dials with the hibernation keepalive once its backoff expires
+	for _, index := range tunnelsWaiting {
+		if index == 0 {
+			return
+		}
+	}
+	s.tunnelsRestarting[0] = true
+	s.stopTunnel(0)
+}
+
+// hibernationConnected returns a channel that is closed once the first connection is connected again with the
+// hibernation keepalive, nil if the tunnel isn't starting to hibernate.
+func (s *Supervisor) hibernationConnected() <-chan struct{} {
+	if !s.hibernationPending {
+		return nil
+	}
+	return s.hibernationConnectedC
+}
+
+// hibernateOthers stops all the connections but the first one. Returns the connections that were waiting to
+// reconnect, which are dropped from tunnelsWaiting as they stay stopped.
+func (s *Supervisor) hibernateOthers(tunnelsWaiting []int) []int {
+	s.hibernationPending = false
+	s.hibernationConnectedC = nil
+	waiting := make(map[int]bool, len(tunnelsWaiting))
+	for _, index := range tunnelsWaiting {
+		waiting[index] = true
+	}
+	var stillWaiting []int
+	if waiting[0] {
+		stillWaiting = append(stillWaiting, 0)
+	}
+	for i := 1; i < s.config.HAConnections; i++ {
+		// Connections waiting to reconnect have exited already
+		s.tunnelsHibernated[i] = waiting[i]
+		if !waiting[i] {
+			s.stopTunnel(i)
+		}
+	}
+	return stillWaiting
+}
+
+// wakeUp restarts the connections stopped by hibernate. Returns how many connections were started, the others are
+// restarted once they exit.
+func (s *Supervisor) wakeUp(ctx context.Context) int {
+	s.log.Logger().Info().Msg("Request received, waking up all connections")
+	s.hibernation.wake()
+	// The other connections weren't stopped yet if the first one didn't reconnect
+	s.hibernationPending = false
+	s.hibernationConnectedC = nil
+	started := 0
+	for index, exited := range s.tunnelsHibernated {
+		if !exited {
+			s.tunnelsRestarting[index] = true
+		} else {
+			go s.startTunnel(s.tunnelContext(ctx, index), index, s.newConnectedTunnelSignal(index))
+			started++
+		}
+		delete(s.tunnelsHibernated, index)
+	}
+	return started
+}
+
+// tunnelContext derives the context of the tunnel at index from ctx, so that hibernate can stop that tunnel.
+func (s *Supervisor) tunnelContext(ctx context.Context, index int) context.Context {
+	if cancel, ok := s.tunnelCancels[index]; ok {
+		cancel()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	s.tunnelCancels[index] = cancel
+	return ctx
+}
+
+func (s *Supervisor) stopTunnel(index int) {
+	if cancel, ok := s.tunnelCancels[index]; ok {
+		cancel()
+	}
+}
@@ -0,0 +1,224 @@
+package supervisor
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/packet"
+	v3 "github.com/cloudflare/cloudflared/quic/v3"
+	"github.com/cloudflare/cloudflared/tracing"
+)
+
+func TestSupervisorHibernation(t *testing.T) {
+	sim := newSimulation(t, 3, func(config *TunnelConfig) {
+		config.HibernateAfter = time.Minute
+		config.HibernateKeepAlive = 15 * time.Second
+	})
+	connected := sim.connectAll(t)
+	h := sim.supervisor.hibernation
+	wakes := counterValue(t, hibernationWakes)
+
+	// A request postpones hibernation
+	sim.clock.waitForTimers(t, 1)
+	sim.clock.Advance(30 * time.Second)
+	h.recordActivity()
+	sim.clock.Advance(30 * time.Second)
+	sim.clock.waitForTimers(t, 1)
+	sim.server.assertNoCall(t)
+
+	// Idle for long enough, only the first connection reconnects, with the hibernation keepalive
+	sim.clock.Advance(30 * time.Second)
+	first := sim.server.nextCall(t)
+	assert.Equal(t, uint8(0), first.connIndex)
+	// The other connections keep serving until the first one is connected again
+	sim.server.assertNoCall(t)
+	assert.NoError(t, connected[1].ctx.Err())
+	assert.NoError(t, connected[2].ctx.Err())
+	first.connect()
+	require.Eventually(t, func() bool {
+		return connected[1].ctx.Err() != nil && connected[2].ctx.Err() != nil
+	}, simulationTimeout, time.Millisecond)
+	sim.server.assertNoCall(t)
+	keepAlive, idleTimeout := h.quicKeepAlive(time.Second, 5*time.Second)
+	assert.Equal(t, 15*time.Second, keepAlive)
+	assert.Equal(t, 45*time.Second, idleTimeout)
+
+	// A request wakes the other connections up
+	h.recordActivity()
+	calls := sim.server.nextCalls(t, 2)
+	assert.Equal(t, uint8(1), calls[0].connIndex)
+	assert.Equal(t, uint8(2), calls[1].connIndex)
+	assert.Equal(t, wakes+1, counterValue(t, hibernationWakes))
+	keepAlive, _ = h.quicKeepAlive(time.Second, 5*time.Second)
+	assert.Equal(t, time.Second, keepAlive)
+}
+
+func TestHibernationRecordsProxiedRequests(t *testing.T) {
+	h := newHibernation(time.Minute, 15*time.Second)
+	now := time.Unix(0, 0)
+	h.now = func() time.Time { return now }
+	h.lastActivity.Store(now.UnixNano())
+	originProxy, err := h.orchestrator(&mockOrchestrator{originProxy: &mockOriginProxy{}}).GetOriginProxy()
+	require.NoError(t, err)
+
+	now = now.Add(time.Hour)
+	require.True(t, h.shouldHibernate())
+	require.NoError(t, originProxy.ProxyTCP(context.Background(), nil, &connection.TCPRequest{}))
+	assert.Equal(t, time.Duration(0), h.idleFor())
+	select {
+	case <-h.woken():
+	default:
+		assert.Fail(t, "a request didn't wake the tunnel up")
+	}
+}
+
+func TestHibernationWaitsForInFlightStreams(t *testing.T) {
+	h := newHibernation(time.Minute, 15*time.Second)
+	var now atomic.Int64
+	h.now = func() time.Time { return time.Unix(0, now.Load()) }
+	h.lastActivity.Store(0)
+	release := make(chan struct{})
+	originProxy, err := h.orchestrator(&mockOrchestrator{originProxy: &mockOriginProxy{release: release}}).GetOriginProxy()
+	require.NoError(t, err)
+
+	proxied := make(chan error)
+	go func() {
+		proxied <- originProxy.ProxyTCP(context.Background(), nil, &connection.TCPRequest{})
+	}()
+	require.Eventually(t, func() bool { return h.inFlight.Load() == 1 }, time.Second, time.Millisecond)
+
+	// A long-running stream keeps the tunnel awake, however long it lasts
+	now.Add(int64(time.Hour))
+	assert.Equal(t, time.Duration(0), h.idleFor())
+	assert.False(t, h.shouldHibernate())
+
+	// The tunnel is idle from the end of the stream
+	close(release)
+	require.NoError(t, <-proxied)
+	now.Add(int64(30 * time.Second))
+	assert.Equal(t, 30*time.Second, h.idleFor())
+	assert.False(t, h.shouldHibernate())
+	now.Add(int64(30 * time.Second))
+	assert.True(t, h.shouldHibernate())
+}
+
+func TestHibernationWaitsForUDPSessions(t *testing.T) {
+	h := newHibernation(time.Minute, 15*time.Second)
+	now := time.Unix(0, 0)
+	h.now = func() time.Time { return now }
+	h.lastActivity.Store(now.UnixNano())
+	dialer := h.udpDialer(&mockUDPDialer{})
+
+	conn, err := dialer.DialUDP(netip.MustParseAddrPort("10.0.0.1:53"))
+	require.NoError(t, err)
+	now = now.Add(time.Hour)
+	assert.False(t, h.shouldHibernate())
+
+	require.NoError(t, conn.Close())
+	require.NoError(t, conn.Close())
+	assert.EqualValues(t, 0, h.inFlight.Load())
+	now = now.Add(time.Minute)
+	assert.True(t, h.shouldHibernate())
+}
+
+func TestHibernationRecordsDatagrams(t *testing.T) {
+	h := newHibernation(time.Minute, 15*time.Second)
+	now := time.Unix(0, 0)
+	h.now = func() time.Time { return now }
+	h.lastActivity.Store(now.UnixNano())
+	sessionManager := h.sessionManager(&mockSessionManager{})
+	icmpRouter := h.icmpRouter(&mockICMPRouter{})
+
+	now = now.Add(time.Hour)
+	_, _ = sessionManager.GetSession(v3.RequestID{})
+	assert.Equal(t, time.Duration(0), h.idleFor())
+
+	now = now.Add(time.Hour)
+	require.NoError(t, icmpRouter.Request(context.Background(), nil, nil))
+	assert.Equal(t, time.Duration(0), h.idleFor())
+
+	// Connections don't proxy ICMP without a router
+	assert.Nil(t, h.icmpRouter(nil))
+}
+
+func TestHibernationDisabled(t *testing.T) {
+	h := newHibernation(0, 15*time.Second)
+	require.Nil(t, h)
+	orchestrator := &mockOrchestrator{}
+	assert.Same(t, orchestrator, h.orchestrator(orchestrator))
+	assert.Nil(t, h.idleTimer(time.After))
+	keepAlive, idleTimeout := h.quicKeepAlive(time.Second, 5*time.Second)
+	assert.Equal(t, time.Second, keepAlive)
+	assert.Equal(t, 5*time.Second, idleTimeout)
+}
+
+type mockOrchestrator struct {
+	connection.Orchestrator
+	originProxy connection.OriginProxy
+}
+
+func (o *mockOrchestrator) GetOriginProxy() (connection.OriginProxy, error) {
+	return o.originProxy, nil
+}
+
+// mockOriginProxy proxies requests until release is closed, at once if it is nil.
+type mockOriginProxy struct {
+	release chan struct{}
+}
+
+func (p *mockOriginProxy) ProxyHTTP(connection.ResponseWriter, *tracing.TracedHTTPRequest, bool) error {
+	if p.release != nil {
+		<-p.release
+	}
+	return nil
+}
+
+func (p *mockOriginProxy) ProxyTCP(context.Context, connection.ReadWriteAcker, *connection.TCPRequest) error {
+	if p.release != nil {
+		<-p.release
+	}
+	return nil
+}
+
+type mockUDPDialer struct {
+	ingress.OriginUDPDialer
+}
+
+func (*mockUDPDialer) DialUDP(netip.AddrPort) (net.Conn, error) {
+	conn, _ := net.Pipe()
+	return conn, nil
+}
+
+type mockSessionManager struct {
+	v3.SessionManager
+}
+
+func (*mockSessionManager) GetSession(v3.RequestID) (v3.Session, error) {
+	return nil, v3.ErrSessionNotFound
+}
+
+type mockICMPRouter struct {
+	ingress.ICMPRouter
+}
+
+func (*mockICMPRouter) Request(context.Context, *packet.ICMP, ingress.ICMPResponder) error {
+	return nil
+}
+
+func counterValue(t *testing.T, counter prometheus.Counter) float64 {
+	t.Helper()
+	var m dto.Metric
+	require.NoError(t, counter.Write(&m))
+	return m.GetCounter().GetValue()
+}
@@ -1,9 +1,14 @@
 package supervisor
 
 import (
+	"strconv"
+	"sync"
+	"time"
+
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/cloudflare/cloudflared/connection"
+	v3 "github.com/cloudflare/cloudflared/quic/v3"
 )
 
 // Metrics uses connection.MetricsNamespace(aka cloudflared) as namespace and connection.TunnelSubsystem
@@ -18,10 +23,182 @@ var (
 			Help:      "Number of active ha connections",
 		},
 	)
+	backoffDuration = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "backoff_duration_seconds",
+			Help:      "Maximum time the connection waits before retrying to connect, 0 once connected",
+		},
+		[]string{"conn_index"},
+	)
+	backoffRetriesRemaining = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "backoff_retries_remaining",
+			Help:      "Number of retries the connection has left before falling back to another protocol or giving up",
+		},
+		[]string{"conn_index"},
+	)
+	protocolFallbackActive = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "protocol_fallback",
+			Help:      "Whether the connection serves the fallback protocol (1) or the selected one (0)",
+		},
+		[]string{"conn_index"},
+	)
+	hibernating = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "hibernating",
+			Help:      "Whether the tunnel is idle and hibernating with a single connection (1) or not (0)",
+		},
+	)
+	hibernations = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "hibernations_total",
+			Help:      "Number of times the tunnel was idle long enough to hibernate",
+		},
+	)
+	hibernationWakes = prometheus.NewCounter(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "hibernation_wakes_total",
+			Help:      "Number of times a request woke the tunnel up from hibernation",
+		},
+	)
+	overloadActive = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "overloaded",
+			Help:      "Whether cloudflared is over its CPU or memory limit and sheds new streams and UDP flows (1) or not (0)",
+		},
+	)
+	overloadShed = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "overload_shed_total",
+			Help:      "Number of new streams and UDP flows refused while cloudflared was overloaded",
+		},
+		[]string{"type"},
+	)
+	watchdogStalls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "watchdog_stalls_total",
+			Help:      "Number of times the watchdog found a registered connection stalled",
+		},
+		[]string{"subsystem"},
+	)
+	watchdogRestarts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "watchdog_restarts_total",
+			Help:      "Number of stalled connections reconnected by the watchdog",
+		},
+		[]string{"subsystem"},
+	)
+	subsystemUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "subsystem_up",
+			Help:      "Whether the DNS refresh loop and the ICMP server are running (1) or waiting to restart (0), by subsystem",
+		},
+		[]string{"subsystem"},
+	)
+	subsystemRestarts = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "subsystem_restarts_total",
+			Help:      "Number of restarts of the DNS refresh loop and the ICMP server after they stopped unexpectedly, by subsystem",
+		},
+		[]string{"subsystem"},
+	)
+	featureChanges = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "feature_changes_total",
+			Help:      "Number of times a connection reconnected with a feature negotiated differently than before, by feature",
+		},
+		[]string{"feature"},
+	)
 )
 
 func init() {
 	prometheus.MustRegister(
 		haConnections,
+		backoffDuration,
+		backoffRetriesRemaining,
+		protocolFallbackActive,
+		hibernating,
+		hibernations,
+		hibernationWakes,
+		overloadActive,
+		overloadShed,
+		watchdogStalls,
+		watchdogRestarts,
+		subsystemUp,
+		subsystemRestarts,
+		featureChanges,
 	)
 }
+
+// observeProtocolFallback records the retry state of a connection about to connect.
+func observeProtocolFallback(connIndex uint8, pf *protocolFallback) {
+	index := strconv.Itoa(int(connIndex))
+	backoffRetriesRemaining.WithLabelValues(index).Set(float64(pf.RetriesLeft()))
+	fallback := 0.0
+	if pf.inFallback {
+		fallback = 1
+	}
+	protocolFallbackActive.WithLabelValues(index).Set(fallback)
+}
+
+// observeBackoff records the backoff a connection waits for before retrying.
+func observeBackoff(connIndex uint8, pf *protocolFallback, duration time.Duration) {
+	backoffDuration.WithLabelValues(strconv.Itoa(int(connIndex))).Set(duration.Seconds())
+	backoffRetriesRemaining.WithLabelValues(strconv.Itoa(int(connIndex))).Set(float64(pf.RetriesLeft()))
+}
+
+// observeConnected records that a connection isn't backing off anymore.
+func observeConnected(connIndex uint8) {
+	backoffDuration.WithLabelValues(strconv.Itoa(int(connIndex))).Set(0)
+}
+
+// datagramMetricsInternal 保证数据报度量在每个注册器上只注册一次，使同一进程中可以创建多个使用同一注册器的 Supervisor
+var datagramMetricsInternal struct {
+	sync.Mutex
+	metrics map[prometheus.Registerer]v3.Metrics
+}
+
+// newDatagramMetrics 返回注册在 registerer 上的数据报度量，registerer 为 nil 时使用默认注册器
+func newDatagramMetrics(registerer prometheus.Registerer) v3.Metrics {
+	if registerer == nil {
+		registerer = prometheus.DefaultRegisterer
+	}
+	datagramMetricsInternal.Lock()
+	defer datagramMetricsInternal.Unlock()
+	if metrics, ok := datagramMetricsInternal.metrics[registerer]; ok {
+		return metrics
+	}
+	if datagramMetricsInternal.metrics == nil {
+		datagramMetricsInternal.metrics = make(map[prometheus.Registerer]v3.Metrics)
+	}
+	metrics := v3.NewMetrics(registerer)
+	datagramMetricsInternal.metrics[registerer] = metrics
+	return metrics
+}
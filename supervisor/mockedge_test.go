@@ -0,0 +1,191 @@
+package supervisor
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/client"
+	"github.com/cloudflare/cloudflared/config"
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/edgediscovery"
+	"github.com/cloudflare/cloudflared/features"
+	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/ingress/origins"
+	"github.com/cloudflare/cloudflared/internal/mockedge"
+	"github.com/cloudflare/cloudflared/orchestration"
+	"github.com/cloudflare/cloudflared/signal"
+	"github.com/cloudflare/cloudflared/tunnelrpc"
+)
+
+const mockEdgeTestTimeout = 30 * time.Second
+
+type staticFeatureSelector struct{}
+
+func (staticFeatureSelector) Snapshot() features.FeatureSnapshot {
+	return features.FeatureSnapshot{
+		PostQuantum:     features.PostQuantumPrefer,
+		DatagramVersion: features.DatagramV2,
+	}
+}
+
+// runSupervisorAgainstMockEdge runs a supervisor with haConnections connections to edge using protocol, until the
+// returned shutdown function is called. The supervisor gives at most one connection to every edge address.
+func runSupervisorAgainstMockEdge(
+	t *testing.T,
+	edge *mockedge.Edge,
+	protocol connection.Protocol,
+	haConnections int,
+) (tunnelID uuid.UUID, shutdown func() error) {
+	log := zerolog.Nop()
+	origin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Origin", "mock")
+		_, _ = w.Write([]byte("hello from " + r.URL.Path))
+	}))
+	t.Cleanup(origin.Close)
+
+	ingressRules, err := ingress.ParseIngress(&config.Configuration{
+		Ingress: []config.UnvalidatedIngressRule{{Service: origin.URL}},
+	})
+	require.NoError(t, err)
+	warpRoutingConfig := ingress.NewWarpRoutingConfig(&config.WarpRoutingConfig{})
+	originDialer := ingress.NewOriginDialer(ingress.OriginConfig{
+		DefaultDialer: ingress.NewDialer(warpRoutingConfig),
+	}, &log)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	orchestrator, err := orchestration.NewOrchestrator(ctx, &orchestration.Config{
+		Ingress:             &ingressRules,
+		WarpRouting:         warpRoutingConfig,
+		OriginDialerService: originDialer,
+	}, nil, []ingress.Rule{}, &log)
+	require.NoError(t, err)
+
+	fetcher := dynamicMockFetcher{
+		protocolPercents: edgediscovery.ProtocolPercents{edgediscovery.ProtocolPercent{Protocol: protocol.String(), Percentage: 100}},
+	}
+	protocolSelector, err := connection.NewProtocolSelector(protocol.String(), "", false, false, fetcher.fetch(), time.Hour, &log)
+	require.NoError(t, err)
+	clientConfig, err := client.NewConfig("mockedge", "test", staticFeatureSelector{})
+	require.NoError(t, err)
+
+	tunnelID = uuid.New()
+	tunnelConfig := &TunnelConfig{
+		ClientConfig:  clientConfig,
+		EdgeAddrs:     []string{edge.Addr()},
+		HAConnections: haConnections,
+		Log:           &log,
+		LogTransport:  &log,
+		Observer:      connection.NewObserver(&log, &log),
+		Retries:       5,
+		NamedTunnel: &connection.TunnelProperties{Credentials: connection.Credentials{
+			AccountTag:   "account",
+			TunnelSecret: []byte("secret"),
+			TunnelID:     tunnelID,
+		}},
+		ProtocolSelector:     protocolSelector,
+		EdgeTLSConfigs:       edge.ClientTLSConfigs(),
+		MaxEdgeAddrRetries:   8,
+		RPCTimeout:           5 * time.Second,
+		RegistrationTimeouts: tunnelrpc.NewRegistrationTimeouts(5 * time.Second),
+		GracePeriod:          time.Second,
+		OriginDNSService: origins.NewStaticDNSResolverService(
+			[]netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:53")},
+			origins.NewDNSDialer(),
+			&log,
+			origins.NewMetrics(prometheus.NewRegistry()),
+		),
+		OriginDialerService:                 originDialer,
+		QUICConnectionLevelFlowControlLimit: 30 * (1 << 20),
+		QUICStreamLevelFlowControlLimit:     6 * (1 << 20),
+		CloseConnOnce:                       &sync.Once{},
+	}
+
+	gracefulShutdownC := make(chan struct{})
+	daemonErrC := make(chan error, 1)
+	go func() {
+		daemonErrC <- StartTunnelDaemon(ctx, tunnelConfig, orchestrator, signal.New(make(chan struct{})), make(chan ReconnectSignal), gracefulShutdownC)
+	}()
+
+	shutdown = func() error {
+		defer cancel()
+		close(gracefulShutdownC)
+		select {
+		case err := <-daemonErrC:
+			return err
+		case <-time.After(mockEdgeTestTimeout):
+			return context.DeadlineExceeded
+		}
+	}
+	return tunnelID, shutdown
+}
+
+func startMockEdge(t *testing.T, cfg mockedge.Config) *mockedge.Edge {
+	edge, err := mockedge.New(cfg)
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	go func() {
+		_ = edge.Serve(ctx)
+	}()
+	return edge
+}
+
+func TestSupervisorAgainstMockEdge(t *testing.T) {
+	for _, protocol := range []connection.Protocol{connection.QUIC, connection.HTTP2} {
+		t.Run(protocol.String(), func(t *testing.T) {
+			edge := startMockEdge(t, mockedge.Config{})
+			tunnelID, shutdown := runSupervisorAgainstMockEdge(t, edge, protocol, 1)
+
+			ctx, cancel := context.WithTimeout(t.Context(), mockEdgeTestTimeout)
+			defer cancel()
+			require.NoError(t, edge.WaitForConnections(ctx, 1))
+			assert.Equal(t, map[uint8]connection.Protocol{0: protocol}, edge.RegisteredConnections())
+			for _, registration := range edge.Registrations() {
+				assert.Equal(t, tunnelID, registration.TunnelID)
+				assert.NoError(t, registration.Err)
+			}
+
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://tunnel.example.com/path", nil)
+			require.NoError(t, err)
+			resp, err := edge.RoundTrip(req)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+			body, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			assert.Equal(t, http.StatusOK, resp.StatusCode)
+			assert.Equal(t, "mock", resp.Header.Get("X-Origin"))
+			assert.Equal(t, "hello from /path", string(body))
+
+			require.NoError(t, shutdown())
+			assert.Empty(t, edge.RegisteredConnections())
+		})
+	}
+}
+
+func TestSupervisorReconnectsToMockEdge(t *testing.T) {
+	edge := startMockEdge(t, mockedge.Config{})
+	_, shutdown := runSupervisorAgainstMockEdge(t, edge, connection.QUIC, 1)
+	defer func() {
+		assert.NoError(t, shutdown())
+	}()
+
+	ctx, cancel := context.WithTimeout(t.Context(), mockEdgeTestTimeout)
+	defer cancel()
+	require.NoError(t, edge.WaitForConnections(ctx, 1))
+
+	edge.DropConnections()
+	require.NoError(t, edge.WaitForRegistrations(ctx, 2))
+	require.NoError(t, edge.WaitForConnections(ctx, 1))
+}
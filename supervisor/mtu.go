@@ -0,0 +1,79 @@
+package supervisor
+
+import (
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/edgediscovery"
+)
+
+var quicProbedDatagramSize = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Namespace: connection.MetricsNamespace,
+		Subsystem: connection.TunnelSubsystem,
+		Name:      "quic_probed_datagram_size",
+		Help:      "Largest UDP payload the path to the edge carries, as probed before connecting with QUIC",
+	},
+	[]string{"conn_index"},
+)
+
+func init() {
+	prometheus.MustRegister(quicProbedDatagramSize)
+}
+
+// mtuProbes probes the path MTU to edge IPs before connecting to them with QUIC, and remembers the largest
+// datagram each one can be sent, to size the packets of QUIC connections to it. A nil mtuProbes never probes.
+type mtuProbes struct {
+	probe func(edgeAddr *net.UDPAddr, localIP net.IP) (int, error)
+
+	mu    sync.Mutex
+	sizes map[netip.Addr]int
+}
+
+func newMTUProbes(enabled bool) *mtuProbes {
+	if !enabled {
+		return nil
+	}
+	return &mtuProbes{
+		probe: edgediscovery.ProbeDatagramSize,
+		sizes: make(map[netip.Addr]int),
+	}
+}
+
+// probeAddr probes the path to edgeAddr and returns the largest datagram it carries, or false if it couldn't be
+// probed.
+func (p *mtuProbes) probeAddr(connIndex uint8, edgeAddr *net.UDPAddr, localIP net.IP, log *zerolog.Logger) (int, bool) {
+	if p == nil {
+		return 0, false
+	}
+	size, err := p.probe(edgeAddr, localIP)
+	if err != nil {
+		log.Debug().Err(err).Msg("Failed to probe the MTU to the edge")
+		return 0, false
+	}
+	log.Debug().Int("datagramSize", size).Msg("Probed the MTU to the edge")
+	quicProbedDatagramSize.WithLabelValues(strconv.Itoa(int(connIndex))).Set(float64(size))
+
+	addr, _ := netip.AddrFromSlice(edgeAddr.IP)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.sizes[addr.Unmap()] = size
+	return size, true
+}
+
+// size returns the largest datagram the path to addr carried when it was last probed, or false if it wasn't.
+func (p *mtuProbes) size(addr netip.Addr) (int, bool) {
+	if p == nil {
+		return 0, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	size, ok := p.sizes[addr.Unmap()]
+	return size, ok
+}
@@ -0,0 +1,43 @@
+package supervisor
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMTUProbes(t *testing.T) {
+	log := zerolog.Nop()
+	probes := newMTUProbes(true)
+	probes.probe = func(edgeAddr *net.UDPAddr, _ net.IP) (int, error) {
+		if edgeAddr.IP.Equal(net.IPv4(198, 41, 200, 13)) {
+			return 0, errors.New("unsupported")
+		}
+		return 1180, nil
+	}
+
+	size, ok := probes.probeAddr(0, &net.UDPAddr{IP: net.IPv4(198, 41, 192, 7), Port: 7844}, nil, &log)
+	assert.True(t, ok)
+	assert.Equal(t, 1180, size)
+	size, ok = probes.size(netip.MustParseAddr("198.41.192.7"))
+	assert.True(t, ok)
+	assert.Equal(t, 1180, size)
+
+	_, ok = probes.probeAddr(1, &net.UDPAddr{IP: net.IPv4(198, 41, 200, 13), Port: 7844}, nil, &log)
+	assert.False(t, ok)
+	_, ok = probes.size(netip.MustParseAddr("198.41.200.13"))
+	assert.False(t, ok)
+}
+
+func TestMTUProbesDisabled(t *testing.T) {
+	log := zerolog.Nop()
+	probes := newMTUProbes(false)
+	_, ok := probes.probeAddr(0, &net.UDPAddr{IP: net.IPv4(198, 41, 192, 7), Port: 7844}, nil, &log)
+	assert.False(t, ok)
+	_, ok = probes.size(netip.MustParseAddr("198.41.192.7"))
+	assert.False(t, ok)
+}
@@ -0,0 +1,222 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"runtime/metrics"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/connection"
+	cfdflow "github.com/cloudflare/cloudflared/flow"
+	"github.com/cloudflare/cloudflared/management"
+	"github.com/cloudflare/cloudflared/tracing"
+)
+
+// overloadSampleInterval is how often the load of cloudflared is sampled.
+const overloadSampleInterval = time.Second
+
+// errOverloaded refuses a new stream or UDP flow. It wraps cfdflow.ErrTooManyActiveFlows, so that the edge is told
+// that the flow was rate limited.
+var errOverloaded = fmt.Errorf("cloudflared is overloaded: %w", cfdflow.ErrTooManyActiveFlows)
+
+// The runtime metrics the load is sampled from
+const (
+	cpuTotalMetric       = "/cpu/classes/total:cpu-seconds"
+	cpuIdleMetric        = "/cpu/classes/idle:cpu-seconds"
+	memoryTotalMetric    = "/memory/classes/total:bytes"
+	memoryReleasedMetric = "/memory/classes/heap/released:bytes"
+)
+
+// overload sheds the new streams and UDP flows of a saturated cloudflared, so that the sessions in flight keep being
+// served instead of everything degrading together. While the CPU used by the Go code of the process, or its memory,
+// is over its limit, new streams and flows are only admitted at the rate of a token bucket. The others are refused at
+// once: HTTP requests with a 503, TCP streams and UDP flows as rate limited. A nil overload admits everything.
+type overload struct {
+	// maxCPU is the fraction of GOMAXPROCS the process may use, unlimited when 0
+	maxCPU float64
+	// maxMemory is the memory the process may hold, in bytes, unlimited when 0
+	maxMemory uint64
+	// rate is the number of new streams and flows admitted per second while overloaded, burst the most at once
+	rate   float64
+	burst  float64
+	now    func() time.Time
+	sample func() loadSample
+
+	overloaded atomic.Bool
+	lastSample loadSample
+
+	tokensLock sync.Mutex
+	tokens     float64
+	refilled   time.Time
+}
+
+// loadSample is the load of the process since it started.
+type loadSample struct {
+	// cpuBusy and cpuTotal are the CPU seconds the process used and had, as defined by GOMAXPROCS
+	cpuBusy  float64
+	cpuTotal float64
+	memory   uint64
+}
+
+func newOverload(maxCPU float64, maxMemory uint64, rate float64) *overload {
+	if maxCPU <= 0 && maxMemory == 0 {
+		return nil
+	}
+	o := &overload{
+		maxCPU:    maxCPU,
+		maxMemory: maxMemory,
+		rate:      rate,
+		burst:     rate,
+		now:       time.Now,
+		sample:    sampleLoad,
+	}
+	// A rate below 1 still admits a stream once its token is full
+	if rate > 0 && rate < 1 {
+		o.burst = 1
+	}
+	o.lastSample = o.sample()
+	return o
+}
+
+// sampleLoad reads the load of the process from the runtime.
+func sampleLoad() loadSample {
+	samples := []metrics.Sample{
+		{Name: cpuTotalMetric},
+		{Name: cpuIdleMetric},
+		{Name: memoryTotalMetric},
+		{Name: memoryReleasedMetric},
+	}
+	metrics.Read(samples)
+	return loadSample{
+		cpuBusy:  samples[0].Value.Float64() - samples[1].Value.Float64(),
+		cpuTotal: samples[0].Value.Float64(),
+		memory:   samples[2].Value.Uint64() - samples[3].Value.Uint64(),
+	}
+}
+
+// run samples the load every overloadSampleInterval until ctx is done.
+func (o *overload) run(ctx context.Context, log *zerolog.Logger) {
+	if o == nil {
+		return
+	}
+	ticker := time.NewTicker(overloadSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			o.check(log)
+		}
+	}
+}
+
+// check samples the load, and starts or stops shedding when it crosses the limits.
+func (o *overload) check(log *zerolog.Logger) {
+	sample := o.sample()
+	var cpu float64
+	if total := sample.cpuTotal - o.lastSample.cpuTotal; total > 0 {
+		cpu = (sample.cpuBusy - o.lastSample.cpuBusy) / total
+	}
+	o.lastSample = sample
+	overloaded := (o.maxCPU > 0 && cpu >= o.maxCPU) || (o.maxMemory > 0 && sample.memory >= o.maxMemory)
+	if overloaded == o.overloaded.Load() {
+		return
+	}
+	if overloaded {
+		o.tokensLock.Lock()
+		o.tokens = o.burst
+		o.refilled = o.now()
+		o.tokensLock.Unlock()
+		log.Warn().Float64("cpu", cpu).Uint64("memoryBytes", sample.memory).
+			Msgf("cloudflared is overloaded, admitting at most %g new streams and UDP flows per second", o.rate)
+		overloadActive.Set(1)
+	} else {
+		log.Info().Float64("cpu", cpu).Uint64("memoryBytes", sample.memory).Msg("cloudflared is no longer overloaded")
+		overloadActive.Set(0)
+	}
+	o.overloaded.Store(overloaded)
+}
+
+// admit returns whether a new stream or flow of kind is admitted, taking a token while overloaded.
+func (o *overload) admit(kind string) bool {
+	if o == nil || !o.overloaded.Load() {
+		return true
+	}
+	o.tokensLock.Lock()
+	defer o.tokensLock.Unlock()
+	now := o.now()
+	o.tokens = min(o.burst, o.tokens+now.Sub(o.refilled).Seconds()*o.rate)
+	o.refilled = now
+	if o.tokens >= 1 {
+		o.tokens--
+		return true
+	}
+	overloadShed.WithLabelValues(kind).Inc()
+	return false
+}
+
+// orchestrator wraps orchestrator to shed the requests and streams proxied by connections.
+func (o *overload) orchestrator(orchestrator connection.Orchestrator) connection.Orchestrator {
+	if o == nil {
+		return orchestrator
+	}
+	return &sheddingOrchestrator{Orchestrator: orchestrator, overload: o}
+}
+
+type sheddingOrchestrator struct {
+	connection.Orchestrator
+	overload *overload
+}
+
+func (s *sheddingOrchestrator) GetOriginProxy() (connection.OriginProxy, error) {
+	originProxy, err := s.Orchestrator.GetOriginProxy()
+	if err != nil {
+		return nil, err
+	}
+	return &sheddingOriginProxy{OriginProxy: originProxy, overload: s.overload}, nil
+}
+
+type sheddingOriginProxy struct {
+	connection.OriginProxy
+	overload *overload
+}
+
+func (p *sheddingOriginProxy) ProxyHTTP(w connection.ResponseWriter, tr *tracing.TracedHTTPRequest, isWebsocket bool) error {
+	if !p.overload.admit(management.HTTP.String()) {
+		return w.WriteRespHeaders(http.StatusServiceUnavailable, http.Header{"Retry-After": []string{"1"}})
+	}
+	return p.OriginProxy.ProxyHTTP(w, tr, isWebsocket)
+}
+
+func (p *sheddingOriginProxy) ProxyTCP(ctx context.Context, rwa connection.ReadWriteAcker, req *connection.TCPRequest) error {
+	if !p.overload.admit(management.TCP.String()) {
+		return errOverloaded
+	}
+	return p.OriginProxy.ProxyTCP(ctx, rwa, req)
+}
+
+// flowLimiter wraps limiter to shed the UDP flows of both datagram versions.
+func (o *overload) flowLimiter(limiter cfdflow.Limiter) cfdflow.Limiter {
+	if o == nil {
+		return limiter
+	}
+	return &sheddingLimiter{Limiter: limiter, overload: o}
+}
+
+type sheddingLimiter struct {
+	cfdflow.Limiter
+	overload *overload
+}
+
+func (l *sheddingLimiter) Acquire(flowType string) error {
+	if !l.overload.admit(flowType) {
+		return errOverloaded
+	}
+	return l.Limiter.Acquire(flowType)
+}
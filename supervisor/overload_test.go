@@ -0,0 +1,105 @@
+package supervisor
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+	cfdflow "github.com/cloudflare/cloudflared/flow"
+)
+
+func newTestOverload(t *testing.T, rate float64) (*overload, *loadSample, *time.Time) {
+	t.Helper()
+	load := &loadSample{}
+	now := time.Unix(0, 0)
+	o := newOverload(0.8, 1<<30, rate)
+	require.NotNil(t, o)
+	o.sample = func() loadSample { return *load }
+	o.now = func() time.Time { return now }
+	o.lastSample = *load
+	return o, load, &now
+}
+
+func TestOverloadSheds(t *testing.T) {
+	o, load, now := newTestOverload(t, 2)
+	log := zerolog.Nop()
+	originProxy, err := o.orchestrator(&mockOrchestrator{originProxy: &mockOriginProxy{}}).GetOriginProxy()
+	require.NoError(t, err)
+	shed := counterValue(t, overloadShed.WithLabelValues("http"))
+
+	// Under the limits, everything is admitted
+	load.cpuBusy, load.cpuTotal, load.memory = 5, 10, 1<<20
+	o.check(&log)
+	for i := 0; i < 10; i++ {
+		w := &mockResponseWriter{}
+		require.NoError(t, originProxy.ProxyHTTP(w, nil, false))
+		assert.Zero(t, w.status)
+	}
+
+	// Using 90% of the CPU, new streams are admitted at the rate of the bucket
+	load.cpuBusy, load.cpuTotal = 14, 15
+	o.check(&log)
+	require.True(t, o.overloaded.Load())
+	require.NoError(t, originProxy.ProxyTCP(context.Background(), nil, &connection.TCPRequest{}))
+	require.NoError(t, originProxy.ProxyHTTP(&mockResponseWriter{}, nil, false))
+	w := &mockResponseWriter{}
+	require.NoError(t, originProxy.ProxyHTTP(w, nil, false))
+	assert.Equal(t, http.StatusServiceUnavailable, w.status)
+	assert.Equal(t, "1", w.header.Get("Retry-After"))
+	assert.Equal(t, shed+1, counterValue(t, overloadShed.WithLabelValues("http")))
+	err = originProxy.ProxyTCP(context.Background(), nil, &connection.TCPRequest{})
+	assert.ErrorIs(t, err, cfdflow.ErrTooManyActiveFlows)
+
+	// Tokens are refilled over time
+	*now = now.Add(500 * time.Millisecond)
+	require.NoError(t, originProxy.ProxyTCP(context.Background(), nil, &connection.TCPRequest{}))
+	assert.Error(t, originProxy.ProxyTCP(context.Background(), nil, &connection.TCPRequest{}))
+
+	// The CPU is measured since the last sample, and memory over its limit overloads too
+	load.cpuBusy, load.cpuTotal = 15, 20
+	o.check(&log)
+	assert.False(t, o.overloaded.Load())
+	load.memory = 2 << 30
+	o.check(&log)
+	assert.True(t, o.overloaded.Load())
+}
+
+func TestOverloadShedsUDPFlows(t *testing.T) {
+	o, load, _ := newTestOverload(t, 0)
+	log := zerolog.Nop()
+	limiter := o.flowLimiter(cfdflow.NewLimiter(0))
+
+	require.NoError(t, limiter.Acquire("udp"))
+	load.memory = 1 << 30
+	o.check(&log)
+	// With no rate, all new flows are refused while overloaded
+	assert.ErrorIs(t, limiter.Acquire("udp"), cfdflow.ErrTooManyActiveFlows)
+}
+
+func TestOverloadDisabled(t *testing.T) {
+	o := newOverload(0, 0, 10)
+	require.Nil(t, o)
+	orchestrator := &mockOrchestrator{}
+	assert.Same(t, orchestrator, o.orchestrator(orchestrator))
+	limiter := cfdflow.NewLimiter(0)
+	assert.Same(t, limiter, o.flowLimiter(limiter))
+	assert.True(t, o.admit("http"))
+	o.run(context.Background(), nil)
+}
+
+type mockResponseWriter struct {
+	connection.ResponseWriter
+	status int
+	header http.Header
+}
+
+func (w *mockResponseWriter) WriteRespHeaders(status int, header http.Header) error {
+	w.status, w.header = status, header
+	return nil
+}
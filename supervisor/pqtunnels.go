@@ -3,6 +3,7 @@ package supervisor
 import (
 	"crypto/tls"
 	"fmt"
+	"slices"
 
 	"github.com/cloudflare/cloudflared/features"
 )
@@ -21,6 +22,9 @@ var (
 	nonFipsPostQuantumPreferPKex []tls.CurveID = []tls.CurveID{X25519MLKEM768PQKex}
 	fipsPostQuantumStrictPKex    []tls.CurveID = []tls.CurveID{P256Kyber768Draft00PQKex}
 	fipsPostQuantumPreferPKex    []tls.CurveID = []tls.CurveID{P256Kyber768Draft00PQKex, tls.CurveP256}
+	nonFipsClassicalKex          []tls.CurveID = []tls.CurveID{tls.X25519, tls.CurveP256}
+	fipsClassicalKex             []tls.CurveID = []tls.CurveID{tls.CurveP256}
+	postQuantumKex               []tls.CurveID = []tls.CurveID{X25519Kyber768Draft00PQKex, P256Kyber768Draft00PQKex, X25519MLKEM768PQKex}
 )
 
 func removeDuplicates(curves []tls.CurveID) []tls.CurveID {
@@ -54,6 +58,18 @@ func curvePreference(pqMode features.PostQuantumMode, fipsEnabled bool, currentC
 		curves := append(nonFipsPostQuantumPreferPKex, currentCurve...)
 		curves = removeDuplicates(curves)
 		return curves, nil
+	case features.PostQuantumDisabled:
+		if fipsEnabled {
+			return fipsClassicalKex, nil
+		}
+		// The default curves of Go include post-quantum ones, so they are listed explicitly when none are set
+		curves := slices.DeleteFunc(slices.Clone(currentCurve), func(curve tls.CurveID) bool {
+			return slices.Contains(postQuantumKex, curve)
+		})
+		if len(curves) == 0 {
+			return nonFipsClassicalKex, nil
+		}
+		return curves, nil
 	default:
 		return nil, fmt.Errorf("Unexpected post quantum mode")
 	}
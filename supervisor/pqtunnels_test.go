@@ -75,6 +75,26 @@ func TestCurvePreferences(t *testing.T) {
 			currentCurves:  []tls.CurveID{tls.CurveP256},
 			expectedCurves: []tls.CurveID{X25519MLKEM768PQKex},
 		},
+		{
+			name:           "Non FIPS with Disabled PQ",
+			pqMode:         features.PostQuantumDisabled,
+			fipsEnabled:    false,
+			currentCurves:  []tls.CurveID{X25519MLKEM768PQKex, tls.CurveP256},
+			expectedCurves: []tls.CurveID{tls.CurveP256},
+		},
+		{
+			name:           "Non FIPS with Disabled PQ - no curves set",
+			pqMode:         features.PostQuantumDisabled,
+			fipsEnabled:    false,
+			expectedCurves: []tls.CurveID{tls.X25519, tls.CurveP256},
+		},
+		{
+			name:           "FIPS with Disabled PQ",
+			pqMode:         features.PostQuantumDisabled,
+			fipsEnabled:    true,
+			currentCurves:  []tls.CurveID{X25519MLKEM768PQKex},
+			expectedCurves: []tls.CurveID{tls.CurveP256},
+		},
 	}
 
 	for _, tcase := range tests {
@@ -110,7 +130,7 @@ func runClientServerHandshake(t *testing.T, curves []tls.CurveID) []tls.CurveID
 }
 
 func TestSupportedCurvesNegotiation(t *testing.T) {
-	for _, tcase := range []features.PostQuantumMode{features.PostQuantumPrefer} {
+	for _, tcase := range []features.PostQuantumMode{features.PostQuantumPrefer, features.PostQuantumDisabled} {
 		curves, err := curvePreference(tcase, fips.IsFipsEnabled(), make([]tls.CurveID, 0))
 		require.NoError(t, err)
 		advertisedCurves := runClientServerHandshake(t, curves)
@@ -0,0 +1,231 @@
+package supervisor
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+	"github.com/cloudflare/cloudflared/management"
+)
+
+const (
+	// defaultPrepareReconnectWindow is how long the tunnel stays prepared to reconnect when no window is given.
+	defaultPrepareReconnectWindow = 10 * time.Minute
+	// preparedRetryDuration is how long connections wait before reconnecting while prepared.
+	preparedRetryDuration = time.Second
+	// reconnectProbes is how many freshly resolved edge addresses are probed when preparing to reconnect.
+	reconnectProbes = 4
+)
+
+var errTunnelNotStarted = errors.New("tunnel has not started")
+
+// ReconnectPreparer gets a running tunnel ready for a planned maintenance window, e.g. a network change, so
+// that its connections come back within seconds once the window is over instead of after long backoffs. It
+// resolves fresh edge addresses and checks that they are reachable, then for the duration of the window
+// connections that drop retry every second, without falling back to another protocol.
+// A nil ReconnectPreparer is never prepared.
+type ReconnectPreparer struct {
+	now func() time.Time
+
+	mu    sync.Mutex
+	until time.Time
+	probe func(context.Context) ([]ReconnectProbe, error)
+	// preparedC wakes up the supervisor to shorten the backoff it is waiting on. It is unbuffered so that the
+	// supervisor sees the window start before any connection that drops afterwards.
+	preparedC chan struct{}
+	// supervisedC is closed once the supervisor stops receiving from preparedC, nil while it isn't receiving
+	supervisedC chan struct{}
+}
+
+// PreparedReconnect describes how the tunnel was prepared to reconnect.
+type PreparedReconnect struct {
+	Until  time.Time        `json:"until"`
+	Probes []ReconnectProbe `json:"probes"`
+}
+
+// ReconnectProbe is the outcome of dialing a freshly resolved edge address.
+type ReconnectProbe struct {
+	EdgeIP    string `json:"edge_ip"`
+	Protocol  string `json:"protocol"`
+	Reachable bool   `json:"reachable"`
+	LatencyMS int64  `json:"latency_ms,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+func NewReconnectPreparer() *ReconnectPreparer {
+	return &ReconnectPreparer{
+		now:       time.Now,
+		preparedC: make(chan struct{}),
+	}
+}
+
+// PrepareReconnect probes freshly resolved edge addresses and keeps the tunnel prepared to reconnect for window,
+// or defaultPrepareReconnectWindow if it isn't positive. Preparing again restarts the window.
+func (p *ReconnectPreparer) PrepareReconnect(ctx context.Context, window time.Duration) (*PreparedReconnect, error) {
+	if p == nil {
+		return nil, errTunnelNotStarted
+	}
+	p.mu.Lock()
+	probe := p.probe
+	p.mu.Unlock()
+	if probe == nil {
+		return nil, errTunnelNotStarted
+	}
+	probes, err := probe(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &PreparedReconnect{
+		Until:  p.prepare(window),
+		Probes: probes,
+	}, nil
+}
+
+// PrepareReconnectJSON is PrepareReconnect, with the outcome encoded as JSON.
+func (p *ReconnectPreparer) PrepareReconnectJSON(ctx context.Context, window time.Duration) ([]byte, error) {
+	prepared, err := p.PrepareReconnect(ctx, window)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(prepared)
+}
+
+// attach lets the preparer probe the edge with the tunnel's settings.
+func (p *ReconnectPreparer) attach(probe func(context.Context) ([]ReconnectProbe, error)) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.probe = probe
+}
+
+// prepare starts the window and returns when it ends.
+func (p *ReconnectPreparer) prepare(window time.Duration) time.Time {
+	if window <= 0 {
+		window = defaultPrepareReconnectWindow
+	}
+	p.mu.Lock()
+	p.until = p.now().Add(window)
+	until := p.until
+	supervisedC := p.supervisedC
+	p.mu.Unlock()
+	if supervisedC != nil {
+		select {
+		case p.preparedC <- struct{}{}:
+		case <-supervisedC:
+		}
+	}
+	return until
+}
+
+// supervise tells the preparer that the supervisor receives from prepared, until the returned function is called.
+func (p *ReconnectPreparer) supervise() func() {
+	if p == nil {
+		return func() {}
+	}
+	supervisedC := make(chan struct{})
+	p.mu.Lock()
+	p.supervisedC = supervisedC
+	p.mu.Unlock()
+	return func() {
+		p.mu.Lock()
+		p.supervisedC = nil
+		p.mu.Unlock()
+		close(supervisedC)
+	}
+}
+
+// preparing returns true during the window.
+func (p *ReconnectPreparer) preparing() bool {
+	if p == nil {
+		return false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.now().Before(p.until)
+}
+
+// prepared is signaled every time the window starts, prepare only returns once the signal is received.
+func (p *ReconnectPreparer) prepared() <-chan struct{} {
+	if p == nil {
+		return nil
+	}
+	return p.preparedC
+}
+
+// probeFreshEdge resolves the edge again and dials a few of its addresses with the current protocol. Addresses
+// that can't be reached are scored down, so connections that reconnect later prefer the others. If any of them
+// is reachable, the tunnel's edge addresses are replaced by the fresh ones before the window starts.
+func (e *EdgeTunnelServer) probeFreshEdge(ctx context.Context) ([]ReconnectProbe, error) {
+	edge, err := resolveEdge(e.config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve edge addresses")
+	}
+	addrs, err := edge.GetRaceAddrs(0, reconnectProbes)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve edge addresses")
+	}
+	protocol := e.config.ProtocolSelector.Current()
+	dial := e.dialer(0, protocol)
+	if dial == nil {
+		return nil, errors.Errorf("can't probe the edge with %s", protocol)
+	}
+
+	probes := make([]ReconnectProbe, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probes[i] = probeEdgeAddr(ctx, addr, protocol, dial)
+			if !probes[i].Reachable && ctx.Err() == nil {
+				e.edgeAddrs.HandshakeFailed(addr)
+			}
+		}()
+	}
+	wg.Wait()
+
+	reachable := 0
+	for _, probe := range probes {
+		if probe.Reachable {
+			reachable++
+		}
+	}
+	log := e.config.Log.With().Int(management.EventTypeKey, int(management.Cloudflared)).Logger()
+	if reachable == 0 {
+		log.Warn().Msgf("Prepared to reconnect, but none of the %d probed edge addresses are reachable with %s", len(probes), protocol)
+		return probes, nil
+	}
+	// The probed addresses were only assigned to be dialed, connections pick among the fresh addresses
+	edge.KeepRaceWinner(nil, addrs)
+	e.edgeAddrs.Refresh(edge)
+	log.Info().Msgf("Prepared to reconnect, %d of %d probed edge addresses are reachable with %s", reachable, len(probes), protocol)
+	return probes, nil
+}
+
+func probeEdgeAddr(
+	ctx context.Context,
+	addr *allregions.EdgeAddr,
+	protocol connection.Protocol,
+	dial func(context.Context, *allregions.EdgeAddr) (racedConn, error),
+) ReconnectProbe {
+	dialCtx, cancel := context.WithTimeout(ctx, dialTimeout)
+	defer cancel()
+	probe := ReconnectProbe{EdgeIP: addr.UDP.IP.String(), Protocol: protocol.String()}
+	start := time.Now()
+	conn, err := dial(dialCtx, addr)
+	if err != nil {
+		probe.Error = err.Error()
+		return probe
+	}
+	probe.Reachable = true
+	probe.LatencyMS = time.Since(start).Milliseconds()
+	conn.close()
+	return probe
+}
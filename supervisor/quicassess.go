@@ -0,0 +1,285 @@
+package supervisor
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/logging"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/edgediscovery"
+	quicpogs "github.com/cloudflare/cloudflared/quic"
+)
+
+const (
+	// MinQUICAssessmentInterval is the shortest interval between two QUIC assessments.
+	MinQUICAssessmentInterval = time.Minute
+	// quicAssessmentSample is how long the QUIC connection of an assessment is kept open to measure packet loss
+	quicAssessmentSample = 10 * time.Second
+	// quicAssessmentKeepAlive is how often the QUIC connection of an assessment sends packets while it's open
+	quicAssessmentKeepAlive = 500 * time.Millisecond
+	// quicAssessmentRounds is how many of the latest assessments the recommendation is made from
+	quicAssessmentRounds = 6
+	// quicAssessmentMinRounds is how many assessments are needed before recommending anything
+	quicAssessmentMinRounds = 3
+	// quicAssessmentMinHandshakeRate is the share of QUIC handshakes that must succeed to recommend QUIC
+	quicAssessmentMinHandshakeRate = 0.9
+	// quicAssessmentMaxLoss is the share of QUIC packets that may be lost to recommend QUIC
+	quicAssessmentMaxLoss = 0.05
+)
+
+var (
+	quicAssessmentHandshakes = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "quic_assessment_handshakes_total",
+			Help:      "QUIC handshakes with the edge made to assess QUIC while the connections use HTTP/2, by result",
+		},
+		[]string{"result"},
+	)
+	quicAssessmentLoss = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "quic_assessment_packet_loss_ratio",
+			Help:      "Share of the packets lost by the last QUIC connection made to assess QUIC",
+		},
+	)
+	quicAssessmentDatagramSize = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "quic_assessment_datagram_size",
+			Help:      "Largest UDP payload the path to the edge carried when QUIC was last assessed",
+		},
+	)
+	quicAssessmentRecommended = prometheus.NewGauge(
+		prometheus.GaugeOpts{
+			Namespace: connection.MetricsNamespace,
+			Subsystem: connection.TunnelSubsystem,
+			Name:      "quic_assessment_recommended",
+			Help:      "Whether the QUIC assessments recommend switching to QUIC: 1 if they do, 0 if they don't, -1 until there are enough of them",
+		},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(quicAssessmentHandshakes, quicAssessmentLoss, quicAssessmentDatagramSize, quicAssessmentRecommended)
+}
+
+// quicAssessmentResult is what an assessment measured.
+type quicAssessmentResult struct {
+	handshake bool
+	// datagramSize is the largest UDP payload the path carried, 0 if it couldn't be probed
+	datagramSize int
+	lossRatio    float64
+	err          error
+}
+
+// quicAssessment measures in the background whether QUIC would work while the connections use HTTP/2, and recommends
+// whether to switch to it in logs and metrics, for operators who want to know before switching. Every interval it
+// probes the MTU to an edge IP and keeps a QUIC connection to it open for a while to measure the handshake and the
+// packet loss, without registering it. A nil quicAssessment assesses nothing.
+type quicAssessment struct {
+	interval time.Duration
+	// current returns the protocol the connections use
+	current func() connection.Protocol
+	probe   func(ctx context.Context) quicAssessmentResult
+	log     *zerolog.Logger
+
+	results []quicAssessmentResult
+	// recommended is the last recommendation logged, nil until there are enough assessments
+	recommended *bool
+}
+
+func newQUICAssessment(
+	interval time.Duration,
+	selector connection.ProtocolSelector,
+	edgeIPs *edgediscovery.Edge,
+	tlsConfig *tls.Config,
+	localIP net.IP,
+	log *zerolog.Logger,
+) *quicAssessment {
+	if interval <= 0 || tlsConfig == nil {
+		return nil
+	}
+	quicAssessmentRecommended.Set(-1)
+	return &quicAssessment{
+		interval: interval,
+		current:  selector.Current,
+		probe: func(ctx context.Context) quicAssessmentResult {
+			addr, err := edgeIPs.GetAddrForRPC()
+			if err != nil {
+				return quicAssessmentResult{err: err}
+			}
+			return probeQUIC(ctx, addr.UDP, tlsConfig.Clone(), localIP, quicAssessmentSample)
+		},
+		log: log,
+	}
+}
+
+func (a *quicAssessment) run(ctx context.Context) {
+	if a == nil {
+		return
+	}
+	ticker := time.NewTicker(a.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			a.assess(ctx)
+		}
+	}
+}
+
+// assess measures QUIC once if the connections use HTTP/2, and logs the recommendation when it changes.
+func (a *quicAssessment) assess(ctx context.Context) {
+	if a.current() != connection.HTTP2 {
+		return
+	}
+	result := a.probe(ctx)
+	if ctx.Err() != nil {
+		return
+	}
+	if result.handshake {
+		quicAssessmentHandshakes.WithLabelValues("success").Inc()
+		quicAssessmentLoss.Set(result.lossRatio)
+	} else {
+		quicAssessmentHandshakes.WithLabelValues("failure").Inc()
+	}
+	if result.datagramSize > 0 {
+		quicAssessmentDatagramSize.Set(float64(result.datagramSize))
+	}
+	a.log.Debug().Err(result.err).
+		Bool("handshake", result.handshake).
+		Float64("lossRatio", result.lossRatio).
+		Int("datagramSize", result.datagramSize).
+		Msg("Assessed QUIC")
+
+	if len(a.results) == quicAssessmentRounds {
+		a.results = a.results[1:]
+	}
+	a.results = append(a.results, result)
+
+	recommended, reason, ok := a.recommend()
+	if !ok {
+		return
+	}
+	if recommended {
+		quicAssessmentRecommended.Set(1)
+	} else {
+		quicAssessmentRecommended.Set(0)
+	}
+	if a.recommended != nil && *a.recommended == recommended {
+		return
+	}
+	a.recommended = &recommended
+	if recommended {
+		a.log.Info().Msgf("QUIC would work on this network: %s. Consider switching to --protocol quic", reason)
+	} else {
+		a.log.Info().Msgf("QUIC isn't recommended on this network: %s. Keep using HTTP/2", reason)
+	}
+}
+
+// recommend returns whether the latest assessments recommend QUIC and why, or false if there are too few of them.
+func (a *quicAssessment) recommend() (bool, string, bool) {
+	if len(a.results) < quicAssessmentMinRounds {
+		return false, "", false
+	}
+	if size := a.results[len(a.results)-1].datagramSize; size > 0 && size < edgediscovery.MinQUICDatagramSize {
+		return false, fmt.Sprintf("the path to the edge only carries %d byte datagrams, QUIC needs %d", size, edgediscovery.MinQUICDatagramSize), true
+	}
+	handshakes := 0
+	var loss float64
+	for _, result := range a.results {
+		if result.handshake {
+			handshakes++
+			loss += result.lossRatio
+		}
+	}
+	handshakeRate := float64(handshakes) / float64(len(a.results))
+	if handshakeRate < quicAssessmentMinHandshakeRate {
+		return false, fmt.Sprintf("only %.0f%% of the QUIC handshakes with the edge succeeded", handshakeRate*100), true
+	}
+	loss /= float64(handshakes)
+	if loss > quicAssessmentMaxLoss {
+		return false, fmt.Sprintf("%.1f%% of the QUIC packets were lost", loss*100), true
+	}
+	return true, fmt.Sprintf("%.0f%% of the QUIC handshakes with the edge succeeded and %.1f%% of the packets were lost", handshakeRate*100, loss*100), true
+}
+
+// probeQUIC probes the MTU to edgeAddr and keeps a QUIC connection to it open for sample to measure packet loss.
+func probeQUIC(ctx context.Context, edgeAddr *net.UDPAddr, tlsConfig *tls.Config, localIP net.IP, sample time.Duration) quicAssessmentResult {
+	var result quicAssessmentResult
+	if size, err := edgediscovery.ProbeDatagramSize(edgeAddr, localIP); err == nil {
+		result.datagramSize = size
+	}
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer udpConn.Close()
+
+	var packets packetLossCounter
+	quicConfig := &quic.Config{
+		HandshakeIdleTimeout: quicpogs.HandshakeIdleTimeout,
+		MaxIdleTimeout:       quicpogs.MaxIdleTimeout,
+		KeepAlivePeriod:      quicAssessmentKeepAlive,
+		EnableDatagrams:      true,
+		Tracer:               packets.tracer,
+	}
+	conn, err := quic.Dial(ctx, udpConn, edgeAddr, tlsConfig, quicConfig)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	result.handshake = true
+	select {
+	case <-ctx.Done():
+	case <-conn.Context().Done():
+	case <-time.After(sample):
+	}
+	_ = conn.CloseWithError(0, "")
+	result.lossRatio = packets.ratio()
+	return result
+}
+
+// packetLossCounter counts the packets a QUIC connection sent and lost.
+type packetLossCounter struct {
+	sent atomic.Int64
+	lost atomic.Int64
+}
+
+func (c *packetLossCounter) tracer(context.Context, logging.Perspective, logging.ConnectionID) *logging.ConnectionTracer {
+	return &logging.ConnectionTracer{
+		SentLongHeaderPacket: func(*logging.ExtendedHeader, logging.ByteCount, logging.ECN, *logging.AckFrame, []logging.Frame) {
+			c.sent.Add(1)
+		},
+		SentShortHeaderPacket: func(*logging.ShortHeader, logging.ByteCount, logging.ECN, *logging.AckFrame, []logging.Frame) {
+			c.sent.Add(1)
+		},
+		LostPacket: func(logging.EncryptionLevel, logging.PacketNumber, logging.PacketLossReason) {
+			c.lost.Add(1)
+		},
+	}
+}
+
+func (c *packetLossCounter) ratio() float64 {
+	sent := c.sent.Load()
+	if sent == 0 {
+		return 0
+	}
+	return float64(c.lost.Load()) / float64(sent)
+}
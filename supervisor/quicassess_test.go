@@ -0,0 +1,110 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/quic-go/quic-go/logging"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+func newTestQUICAssessment(protocol *connection.Protocol, results *[]quicAssessmentResult) *quicAssessment {
+	log := zerolog.Nop()
+	return &quicAssessment{
+		interval: time.Minute,
+		current:  func() connection.Protocol { return *protocol },
+		probe: func(context.Context) quicAssessmentResult {
+			result := (*results)[0]
+			*results = (*results)[1:]
+			return result
+		},
+		log: &log,
+	}
+}
+
+func quicGaugeValue(t *testing.T, gauge prometheus.Gauge) float64 {
+	t.Helper()
+	var m dto.Metric
+	require.NoError(t, gauge.Write(&m))
+	return m.GetGauge().GetValue()
+}
+
+func TestQUICAssessmentRecommend(t *testing.T) {
+	protocol := connection.HTTP2
+	good := quicAssessmentResult{handshake: true, lossRatio: 0.01, datagramSize: 1400}
+	results := []quicAssessmentResult{good, good, good, {err: errors.New("handshake timeout")}}
+	assessment := newTestQUICAssessment(&protocol, &results)
+	ctx := context.Background()
+
+	// Too few assessments to recommend anything
+	assessment.assess(ctx)
+	assessment.assess(ctx)
+	_, _, ok := assessment.recommend()
+	assert.False(t, ok)
+
+	assessment.assess(ctx)
+	recommended, _, ok := assessment.recommend()
+	assert.True(t, ok)
+	assert.True(t, recommended)
+	assert.Equal(t, 1.0, quicGaugeValue(t, quicAssessmentRecommended))
+	assert.Equal(t, 1400.0, quicGaugeValue(t, quicAssessmentDatagramSize))
+
+	// One failed handshake out of 4 is too many
+	assessment.assess(ctx)
+	recommended, reason, ok := assessment.recommend()
+	assert.True(t, ok)
+	assert.False(t, recommended)
+	assert.Contains(t, reason, "75%")
+	assert.Equal(t, 0.0, quicGaugeValue(t, quicAssessmentRecommended))
+
+	// Nothing is assessed once the connections use QUIC
+	protocol = connection.QUIC
+	assessment.assess(ctx)
+	assert.Len(t, assessment.results, 4)
+}
+
+func TestQUICAssessmentRecommendLossAndMTU(t *testing.T) {
+	protocol := connection.HTTP2
+	lossy := quicAssessmentResult{handshake: true, lossRatio: 0.2}
+	results := []quicAssessmentResult{lossy, lossy, lossy, {handshake: true, datagramSize: 1100}}
+	assessment := newTestQUICAssessment(&protocol, &results)
+	ctx := context.Background()
+
+	for i := 0; i < quicAssessmentMinRounds; i++ {
+		assessment.assess(ctx)
+	}
+	recommended, reason, _ := assessment.recommend()
+	assert.False(t, recommended)
+	assert.Contains(t, reason, "lost")
+
+	assessment.assess(ctx)
+	recommended, reason, _ = assessment.recommend()
+	assert.False(t, recommended)
+	assert.Contains(t, reason, "1100 byte datagrams")
+}
+
+func TestQUICAssessmentDisabled(t *testing.T) {
+	log := zerolog.Nop()
+	assessment := newQUICAssessment(0, nil, nil, nil, nil, &log)
+	assert.Nil(t, assessment)
+	assessment.run(context.Background())
+}
+
+func TestPacketLossCounter(t *testing.T) {
+	var packets packetLossCounter
+	assert.Equal(t, 0.0, packets.ratio())
+	tracer := packets.tracer(context.Background(), 0, logging.ConnectionID{})
+	for i := 0; i < 10; i++ {
+		tracer.SentShortHeaderPacket(nil, 0, 0, nil, nil)
+	}
+	tracer.LostPacket(0, 0, 0)
+	assert.Equal(t, 0.1, packets.ratio())
+}
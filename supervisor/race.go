@@ -0,0 +1,203 @@
+package supervisor
+
+import (
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+
+	"github.com/quic-go/quic-go"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+	"github.com/cloudflare/cloudflared/management"
+)
+
+// connectionRace dials several edge addresses in parallel for the first connection when the tunnel starts, and
+// keeps whichever connects first. On networks where some paths to the edge are blocked, the tunnel becomes ready
+// as soon as any path works instead of after timing out on the blocked ones one at a time, which matters most for
+// short-lived tunnels, e.g. in CI. Only dialing races: the winner registers the tunnel once, as usual.
+// A nil connectionRace never races.
+type connectionRace struct {
+	candidates int
+	started    atomic.Bool
+
+	mu      sync.Mutex
+	results map[uint8]racedConn
+}
+
+// racedConn is the outcome of a race, left for serveConnection to take instead of dialing again.
+type racedConn struct {
+	quicConn quic.Connection
+	tlsConn  net.Conn
+	// err is set if no candidate connected
+	err error
+}
+
+func newConnectionRace(candidates int) *connectionRace {
+	if candidates < 2 {
+		return nil
+	}
+	return &connectionRace{
+		candidates: candidates,
+		results:    map[uint8]racedConn{},
+	}
+}
+
+// start returns true the first time the first connection connects, which is the only time it races.
+func (r *connectionRace) start(connIndex uint8) bool {
+	return r != nil && connIndex == 0 && r.started.CompareAndSwap(false, true)
+}
+
+func (r *connectionRace) store(connIndex uint8, result racedConn) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results[connIndex] = result
+}
+
+// take returns the outcome of the connection's race, if it raced and the outcome wasn't taken yet.
+func (r *connectionRace) take(connIndex uint8) (racedConn, bool) {
+	if r == nil {
+		return racedConn{}, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	result, ok := r.results[connIndex]
+	delete(r.results, connIndex)
+	return result, ok
+}
+
+// discard closes the connection that won the connection's race if it wasn't taken, e.g. because serving
+// returned before dialing.
+func (r *connectionRace) discard(connIndex uint8) {
+	result, ok := r.take(connIndex)
+	if !ok {
+		return
+	}
+	result.close()
+}
+
+func (rc racedConn) close() {
+	if rc.quicConn != nil {
+		_ = rc.quicConn.CloseWithError(0, "connection not used")
+	}
+	if rc.tlsConn != nil {
+		_ = rc.tlsConn.Close()
+	}
+}
+
+// raceFirstConn gives the connection the edge address that connects first with protocol among the race
+// candidates, and leaves its connection for serveConnection to take. If none of them connect, the connection
+// keeps the first candidate and serveConnection gets its dial error.
+func (e *EdgeTunnelServer) raceFirstConn(ctx context.Context, connIndex uint8, protocol connection.Protocol) (*allregions.EdgeAddr, error) {
+	addrs, err := e.edgeAddrs.GetRaceAddrs(int(connIndex), e.race.candidates)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) < 2 {
+		return addrs[0], nil
+	}
+
+	dial := e.dialer(connIndex, protocol)
+	if dial == nil {
+		e.edgeAddrs.KeepRaceWinner(addrs[0], addrs)
+		return addrs[0], nil
+	}
+
+	e.config.Log.Info().
+		Int(management.EventTypeKey, int(management.Cloudflared)).
+		Uint8(connection.LogFieldConnIndex, connIndex).
+		Msgf("Racing %d edge addresses to connect with %s", len(addrs), protocol)
+	winner, conn, errs := raceDial(ctx, addrs, dial, racedConn.close)
+	for addr := range errs {
+		// Without a winner the first candidate's error goes through serveConnection, which scores it
+		if ctx.Err() == nil && (winner != nil || addr != addrs[0]) {
+			e.edgeAddrs.HandshakeFailed(addr)
+		}
+	}
+	if winner == nil {
+		winner, conn = addrs[0], racedConn{err: errs[addrs[0]]}
+	}
+	e.edgeAddrs.KeepRaceWinner(winner, addrs)
+	e.race.store(connIndex, conn)
+	return winner, nil
+}
+
+// dialer returns a function that dials an edge address with protocol, or nil if protocol can't be dialed on its own.
+func (e *EdgeTunnelServer) dialer(connIndex uint8, protocol connection.Protocol) func(context.Context, *allregions.EdgeAddr) (racedConn, error) {
+	switch protocol {
+	case connection.QUIC:
+		pqMode := e.config.connectionOptions("", 0).FeatureSnapshot.PostQuantum
+		return func(ctx context.Context, addr *allregions.EdgeAddr) (racedConn, error) {
+			conn, err := e.dialQUIC(ctx, addr.UDP.AddrPort(), e.raceLogger(connIndex, addr), pqMode, connIndex)
+			return racedConn{quicConn: conn}, err
+		}
+	case connection.HTTP2:
+		return func(ctx context.Context, addr *allregions.EdgeAddr) (racedConn, error) {
+			conn, err := e.dialHTTP2(ctx, e.raceLogger(connIndex, addr), addr)
+			return racedConn{tlsConn: conn}, err
+		}
+	default:
+		return nil
+	}
+}
+
+func (e *EdgeTunnelServer) raceLogger(connIndex uint8, addr *allregions.EdgeAddr) *ConnAwareLogger {
+	logger := e.config.Log.With().
+		Int(management.EventTypeKey, int(management.Cloudflared)).
+		IPAddr(connection.LogFieldIPAddress, addr.UDP.IP).
+		Uint8(connection.LogFieldConnIndex, connIndex).
+		Logger()
+	return e.connAwareLogger.ReplaceLogger(&logger)
+}
+
+// raceDial dials all addrs in parallel and returns the first to connect. The other dials are canceled, and the
+// connections of those that connect anyway are closed. errs has the errors of the addrs that failed to connect
+// before the race was decided. If none connect, winner is nil.
+func raceDial[T any](
+	ctx context.Context,
+	addrs []*allregions.EdgeAddr,
+	dial func(context.Context, *allregions.EdgeAddr) (T, error),
+	closeConn func(T),
+) (winner *allregions.EdgeAddr, conn T, errs map[*allregions.EdgeAddr]error) {
+	type result struct {
+		addr *allregions.EdgeAddr
+		conn T
+		err  error
+	}
+	results := make(chan result, len(addrs))
+	// Each dial has its own context, the winner's connection may outlive the dial context
+	cancels := make(map[*allregions.EdgeAddr]context.CancelFunc, len(addrs))
+	for _, addr := range addrs {
+		dialCtx, cancel := context.WithCancel(ctx)
+		cancels[addr] = cancel
+		go func() {
+			conn, err := dial(dialCtx, addr)
+			results <- result{addr: addr, conn: conn, err: err}
+		}()
+	}
+
+	errs = make(map[*allregions.EdgeAddr]error)
+	for pending := len(addrs); pending > 0; pending-- {
+		r := <-results
+		if r.err != nil {
+			errs[r.addr] = r.err
+			cancels[r.addr]()
+			continue
+		}
+		for addr, cancel := range cancels {
+			if addr != r.addr {
+				cancel()
+			}
+		}
+		go func(pending int) {
+			for ; pending > 0; pending-- {
+				if r := <-results; r.err == nil {
+					closeConn(r.conn)
+				}
+			}
+		}(pending - 1)
+		return r.addr, r.conn, errs
+	}
+	return nil, conn, errs
+}
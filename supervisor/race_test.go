@@ -0,0 +1,114 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+)
+
+func raceTestAddrs(n int) []*allregions.EdgeAddr {
+	addrs := make([]*allregions.EdgeAddr, n)
+	for i := range addrs {
+		ip := net.IPv4(198, 41, 200, byte(i))
+		addrs[i] = &allregions.EdgeAddr{
+			TCP:       &net.TCPAddr{IP: ip, Port: 7844},
+			UDP:       &net.UDPAddr{IP: ip, Port: 7844},
+			IPVersion: allregions.V4,
+		}
+	}
+	return addrs
+}
+
+func TestRaceDialKeepsFirstToConnect(t *testing.T) {
+	addrs := raceTestAddrs(3)
+	errBlocked := errors.New("blocked")
+	closed := make(chan int, len(addrs))
+	dial := func(ctx context.Context, addr *allregions.EdgeAddr) (int, error) {
+		switch addr {
+		case addrs[0]:
+			// Blocked path, only gives up when canceled
+			<-ctx.Done()
+			return 0, ctx.Err()
+		case addrs[1]:
+			return 0, errBlocked
+		default:
+			return 2, nil
+		}
+	}
+
+	winner, conn, errs := raceDial(context.Background(), addrs, dial, func(conn int) { closed <- conn })
+	assert.Equal(t, addrs[2], winner)
+	assert.Equal(t, 2, conn)
+	// The blocked path was still dialing, the failed one may not have failed yet when the race was decided
+	assert.NotContains(t, errs, addrs[0])
+	if err, ok := errs[addrs[1]]; ok {
+		assert.Equal(t, errBlocked, err)
+	}
+	assert.Empty(t, closed)
+}
+
+func TestRaceDialClosesLosers(t *testing.T) {
+	addrs := raceTestAddrs(2)
+	release := make(chan struct{})
+	closed := make(chan int, len(addrs))
+	dial := func(ctx context.Context, addr *allregions.EdgeAddr) (int, error) {
+		if addr == addrs[0] {
+			return 0, nil
+		}
+		// Connects after losing, ignoring that it was canceled
+		<-release
+		assert.Error(t, ctx.Err())
+		return 1, nil
+	}
+
+	winner, conn, errs := raceDial(context.Background(), addrs, dial, func(conn int) { closed <- conn })
+	assert.Equal(t, addrs[0], winner)
+	assert.Equal(t, 0, conn)
+	assert.Empty(t, errs)
+
+	close(release)
+	select {
+	case conn := <-closed:
+		assert.Equal(t, 1, conn)
+	case <-time.After(time.Second):
+		require.Fail(t, "losing connection wasn't closed")
+	}
+}
+
+func TestRaceDialNoneConnect(t *testing.T) {
+	addrs := raceTestAddrs(2)
+	dial := func(ctx context.Context, addr *allregions.EdgeAddr) (int, error) {
+		return 0, errors.New(addr.UDP.IP.String())
+	}
+
+	winner, _, errs := raceDial(context.Background(), addrs, dial, func(int) {})
+	assert.Nil(t, winner)
+	require.Len(t, errs, 2)
+	for _, addr := range addrs {
+		assert.EqualError(t, errs[addr], addr.UDP.IP.String())
+	}
+}
+
+func TestConnectionRaceStartsOnce(t *testing.T) {
+	assert.Nil(t, newConnectionRace(1))
+	assert.False(t, newConnectionRace(0).start(0))
+
+	race := newConnectionRace(2)
+	assert.False(t, race.start(1))
+	assert.True(t, race.start(0))
+	assert.False(t, race.start(0))
+
+	race.store(0, racedConn{err: errors.New("no candidate connected")})
+	result, ok := race.take(0)
+	assert.True(t, ok)
+	assert.Error(t, result.err)
+	_, ok = race.take(0)
+	assert.False(t, ok)
+}
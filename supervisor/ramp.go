@@ -0,0 +1,87 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/cloudflare/cloudflared/retry"
+	"github.com/cloudflare/cloudflared/signal"
+)
+
+// RampStrategy is how the HA connections after the first one are started once it registered.
+type RampStrategy string
+
+const (
+	// RampFixed starts the connections one at a time, an interval apart.
+	RampFixed RampStrategy = "fixed"
+	// RampExponential starts the connections in batches doubling in size, an interval apart, so that many HA
+	// connections come up quickly after a cautious start.
+	RampExponential RampStrategy = "exponential"
+	// RampSequential starts each connection once the previous one registered, waiting at most an interval for it.
+	RampSequential RampStrategy = "sequential"
+)
+
+// ParseRampStrategy returns the RampStrategy named s.
+func ParseRampStrategy(s string) (RampStrategy, error) {
+	switch strategy := RampStrategy(s); strategy {
+	case RampFixed, RampExponential, RampSequential:
+		return strategy, nil
+	default:
+		return "", fmt.Errorf("unknown registration ramp %q, expected %s, %s or %s", s, RampFixed, RampExponential, RampSequential)
+	}
+}
+
+// RegistrationRamp configures how the HA connections after the first one are started. The zero value starts them
+// one at a time, registrationInterval apart.
+type RegistrationRamp struct {
+	Strategy RampStrategy
+	// Interval is the delay between two connections, or two batches of connections with RampExponential. With
+	// RampSequential, it is the longest a connection waits for the previous one to register.
+	Interval time.Duration
+}
+
+// withDefaults returns r with its zero fields set to their defaults.
+func (r RegistrationRamp) withDefaults() RegistrationRamp {
+	if r.Strategy == "" {
+		r.Strategy = RampFixed
+	}
+	if r.Interval <= 0 {
+		r.Interval = registrationInterval
+	}
+	return r
+}
+
+// startHAConnections starts the HA connections after the first one, following the registration ramp.
+func (s *Supervisor) startHAConnections(ctx context.Context) {
+	ramp := s.config.RegistrationRamp.withDefaults()
+	batch := 1
+	for i := 1; i < s.config.HAConnections; {
+		var connected *signal.Signal
+		for started := 0; started < batch && i < s.config.HAConnections; started++ {
+			s.tunnelsProtocolFallback[i] = &protocolFallback{
+				s.newBackoff(retry.DefaultBaseTime),
+				s.haConnectionProtocol(i),
+				false,
+				false,
+				false,
+			}
+			connected = s.newConnectedTunnelSignal(i)
+			go s.startTunnel(s.tunnelContext(ctx, i), i, connected)
+			i++
+		}
+		// Waiting between registrations avoids a burst of connections to the edge
+		wait := s.clock.After(ramp.Interval)
+		if ramp.Strategy == RampSequential {
+			select {
+			case <-connected.Wait():
+			case <-wait:
+			}
+		} else {
+			<-wait
+		}
+		if ramp.Strategy == RampExponential {
+			batch *= 2
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package supervisor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRampStrategy(t *testing.T) {
+	for _, s := range []RampStrategy{RampFixed, RampExponential, RampSequential} {
+		strategy, err := ParseRampStrategy(string(s))
+		require.NoError(t, err)
+		assert.Equal(t, s, strategy)
+	}
+	_, err := ParseRampStrategy("linear")
+	assert.Error(t, err)
+}
+
+func TestSupervisorExponentialRamp(t *testing.T) {
+	const interval = 2 * time.Second
+	sim := newSimulation(t, 7, func(config *TunnelConfig) {
+		config.RegistrationRamp = RegistrationRamp{Strategy: RampExponential, Interval: interval}
+	})
+	sim.server.nextCall(t).connect()
+
+	// Batches of 1, 2 and then the 3 remaining connections, an interval apart
+	for _, batch := range []int{1, 2, 3} {
+		calls := sim.server.nextCalls(t, batch)
+		for _, call := range calls {
+			call.connect()
+		}
+		sim.server.assertNoCall(t)
+		sim.clock.waitForTimers(t, 1)
+		sim.clock.Advance(interval)
+	}
+	sim.server.assertNoCall(t)
+}
+
+func TestSupervisorSequentialRamp(t *testing.T) {
+	const interval = 10 * time.Second
+	sim := newSimulation(t, 4, func(config *TunnelConfig) {
+		config.RegistrationRamp = RegistrationRamp{Strategy: RampSequential, Interval: interval}
+	})
+	sim.server.nextCall(t).connect()
+
+	// The next connection starts as soon as the previous one registered
+	second := sim.server.nextCall(t)
+	assert.Equal(t, uint8(1), second.connIndex)
+	sim.server.assertNoCall(t)
+	second.connect()
+	third := sim.server.nextCall(t)
+	assert.Equal(t, uint8(2), third.connIndex)
+
+	// ... or after the interval if it doesn't
+	sim.server.assertNoCall(t)
+	sim.clock.waitForTimers(t, 2)
+	sim.clock.Advance(interval)
+	assert.Equal(t, uint8(3), sim.server.nextCall(t).connIndex)
+}
@@ -0,0 +1,155 @@
+package supervisor
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/retry"
+)
+
+// States of a subsystem reported by SubsystemStatuses.
+const (
+	SubsystemRunning    = "running"
+	SubsystemRestarting = "restarting"
+)
+
+// The subsystems the supervisor keeps running besides the connections, as labelled in their metrics
+const (
+	subsystemDNSRefresh = "dns_refresh"
+	subsystemICMP       = "icmp"
+)
+
+const (
+	// subsystemRestartMaxRetries caps the backoff between restarts of a subsystem that keeps failing at about a minute
+	subsystemRestartMaxRetries = 6
+	subsystemRestartBaseTime   = time.Second
+)
+
+var errSubsystemStopped = errors.New("stopped")
+
+// activeSubsystems are the subsystems of the running supervisors, reported by SubsystemStatuses.
+var activeSubsystems struct {
+	sync.Mutex
+	subsystems map[*subsystem]struct{}
+}
+
+// SubsystemStatus reports the state of a subsystem the supervisor keeps running, e.g. the ICMP server.
+type SubsystemStatus struct {
+	Name string `json:"name"`
+	// State is SubsystemRunning, or SubsystemRestarting while waiting to restart after the subsystem stopped
+	State    string    `json:"state"`
+	Since    time.Time `json:"since"`
+	Restarts int       `json:"restarts"`
+	// Error is why the subsystem last stopped
+	Error string `json:"error,omitempty"`
+}
+
+// subsystem keeps a long-lived goroutine of the supervisor running, restarting it with backoff whenever it returns or
+// panics before the supervisor stops, instead of leaving it dead for the lifetime of the process.
+type subsystem struct {
+	log     *zerolog.Logger
+	backoff retry.BackoffHandler
+
+	lock   sync.Mutex
+	status SubsystemStatus
+}
+
+func newSubsystem(name string, log *zerolog.Logger) *subsystem {
+	return &subsystem{
+		log:     log,
+		backoff: retry.NewBackoff(subsystemRestartMaxRetries, subsystemRestartBaseTime, true),
+		status:  SubsystemStatus{Name: name},
+	}
+}
+
+// supervise runs the subsystem until ctx is done, reporting it in SubsystemStatuses and the subsystem_up metric
+// meanwhile. run is expected to return once ctx is done.
+func (s *subsystem) supervise(ctx context.Context, run func(context.Context) error) {
+	activeSubsystems.Lock()
+	if activeSubsystems.subsystems == nil {
+		activeSubsystems.subsystems = make(map[*subsystem]struct{})
+	}
+	activeSubsystems.subsystems[s] = struct{}{}
+	activeSubsystems.Unlock()
+	defer func() {
+		activeSubsystems.Lock()
+		delete(activeSubsystems.subsystems, s)
+		activeSubsystems.Unlock()
+		subsystemUp.DeleteLabelValues(s.status.Name)
+	}()
+
+	for {
+		s.setState(SubsystemRunning, nil)
+		// A subsystem that ran for long enough restarts with the shortest backoff again
+		s.backoff.SetGracePeriod()
+		err := s.run(ctx, run)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			err = errSubsystemStopped
+		}
+		s.setState(SubsystemRestarting, err)
+		s.log.Err(err).Str("subsystem", s.status.Name).Msg("Subsystem stopped unexpectedly, restarting it")
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.backoff.BackoffTimer():
+		}
+		subsystemRestarts.WithLabelValues(s.status.Name).Inc()
+		s.lock.Lock()
+		s.status.Restarts++
+		s.lock.Unlock()
+	}
+}
+
+// run runs the subsystem once, turning a panic into an error so that it doesn't crash cloudflared.
+func (s *subsystem) run(ctx context.Context, run func(context.Context) error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return run(ctx)
+}
+
+func (s *subsystem) setState(state string, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.status.State = state
+	s.status.Since = time.Now()
+	if err != nil {
+		s.status.Error = err.Error()
+	}
+	up := 0.0
+	if state == SubsystemRunning {
+		up = 1
+	}
+	subsystemUp.WithLabelValues(s.status.Name).Set(up)
+}
+
+func (s *subsystem) Status() SubsystemStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.status
+}
+
+// SubsystemStatuses returns the state of the subsystems the running supervisors keep running, sorted by name.
+func SubsystemStatuses() []SubsystemStatus {
+	activeSubsystems.Lock()
+	defer activeSubsystems.Unlock()
+	statuses := make([]SubsystemStatus, 0, len(activeSubsystems.subsystems))
+	for s := range activeSubsystems.subsystems {
+		statuses = append(statuses, s.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
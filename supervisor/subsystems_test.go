@@ -0,0 +1,73 @@
+package supervisor
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/retry"
+)
+
+func TestSubsystemRestartsAfterFailure(t *testing.T) {
+	log := zerolog.Nop()
+	s := newSubsystem(subsystemICMP, &log)
+	s.backoff = retry.NewBackoff(subsystemRestartMaxRetries, time.Millisecond, true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	runs := make(chan int, 3)
+	stopped := make(chan struct{})
+	calls := 0
+	go func() {
+		defer close(stopped)
+		s.supervise(ctx, func(ctx context.Context) error {
+			calls++
+			runs <- calls
+			switch calls {
+			case 1:
+				return errors.New("socket closed")
+			case 2:
+				panic("crashed")
+			}
+			<-ctx.Done()
+			return ctx.Err()
+		})
+	}()
+
+	for i := 1; i <= 3; i++ {
+		select {
+		case run := <-runs:
+			assert.Equal(t, i, run)
+		case <-time.After(5 * time.Second):
+			require.FailNow(t, "subsystem was not restarted")
+		}
+	}
+	var status SubsystemStatus
+	require.Eventually(t, func() bool {
+		var ok bool
+		status, ok = subsystemStatus(subsystemICMP)
+		return ok && status.State == SubsystemRunning
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(t, 2, status.Restarts)
+	assert.Equal(t, "panic: crashed", status.Error)
+	assert.Equal(t, 1.0, gaugeValue(t, subsystemUp, subsystemICMP))
+
+	cancel()
+	<-stopped
+	_, ok := subsystemStatus(subsystemICMP)
+	assert.False(t, ok)
+}
+
+func subsystemStatus(name string) (SubsystemStatus, bool) {
+	for _, status := range SubsystemStatuses() {
+		if status.Name == name {
+			return status, true
+		}
+	}
+	return SubsystemStatus{}, false
+}
@@ -5,11 +5,10 @@ package supervisor
 import (
 	"context"
 	"errors"
-	"net"
+	"math/rand"
 	"strings"
 	"time"
 
-	"github.com/prometheus/client_golang/prometheus"
 	"github.com/quic-go/quic-go"
 	"github.com/rs/zerolog"
 
@@ -27,9 +26,16 @@ const (
 	// 设置为 10 秒，给予足够的时间让临时网络问题得以恢复
 	tunnelRetryDuration = time.Second * 10
 
-	// registrationInterval 定义了在注册新隧道之间的时间间隔
+	// registrationInterval 定义了在注册新隧道之间的默认时间间隔
 	// 通过错开注册时间，避免所有隧道同时连接造成的突发负载
 	registrationInterval = time.Second
+
+	// protocolScheduleInterval 定义了检查协议计划表窗口是否打开或关闭的间隔，与计划表的分钟粒度一致
+	protocolScheduleInterval = time.Minute
+
+	// protocolHintTTL 定义了连接注册时使用的协议作为其他连接重连协议的有效时间
+	// 每次注册都会刷新，过期后恢复协议选择器自己选择的协议
+	protocolHintTTL = 10 * time.Minute
 )
 
 // Supervisor 管理非声明式隧道。它负责与 Cloudflare 边缘节点建立连接，
@@ -80,6 +86,39 @@ type Supervisor struct {
 
 	// gracefulShutdownC 优雅关闭信号通道，当收到信号时开始关闭流程
 	gracefulShutdownC <-chan struct{}
+
+	// clock 用于退避计时和错开注册，测试中可以替换为模拟时钟
+	clock retry.Clock
+
+	// seeds 配置了随机种子时，为每个退避计时器生成各自的种子，使重连的时间序列可以复现，为 nil 时使用全局随机源
+	seeds *rand.Rand
+
+	// attempts 最近失败的连接尝试，启动超时时汇总报告
+	attempts *connectionAttempts
+
+	// preparer 计划维护前准备重连，准备期间缩短重连的退避时间，为 nil 时不会准备
+	preparer *ReconnectPreparer
+
+	// hibernation 隧道空闲时只保留一个低保活频率的连接，收到请求后恢复所有连接，为 nil 时不休眠
+	hibernation *hibernation
+	// overload CPU或内存超过限制时按令牌桶速率接受新流和UDP会话，为 nil 时不限制
+	overload *overload
+	// watchdog 检查已注册连接的控制流心跳，为 nil 时不检查
+	watchdog *watchdog
+	// quicAssessment 使用HTTP2时在后台评估QUIC是否可用并给出建议，为 nil 时不评估
+	quicAssessment *quicAssessment
+	// clockSkew 启动时和定期比较本地时钟与边缘的时间，为 nil 时不检查
+	clockSkew *clockSkewCheck
+	// tunnelCancels 每个隧道连接的取消函数，休眠时用于停止单个连接
+	tunnelCancels map[int]context.CancelFunc
+	// tunnelsHibernated 休眠时停止的隧道索引，value 表示该隧道是否已经退出
+	tunnelsHibernated map[int]bool
+	// tunnelsRestarting 退出后需要立即重启的隧道索引（休眠时重连的第一个连接，或唤醒时尚未退出的连接）
+	tunnelsRestarting map[int]bool
+	// hibernationPending 开始休眠后，第一个连接以休眠的保活频率重新连接之前，其余连接继续服务
+	hibernationPending bool
+	// hibernationConnectedC 开始休眠后第一个连接重新连接成功时关闭
+	hibernationConnectedC chan struct{}
 }
 
 // errEarlyShutdown 当在初始化阶段就收到关闭信号时返回的错误
@@ -103,22 +142,55 @@ type tunnelError struct {
 //   - *Supervisor: 初始化完成的 Supervisor 实例
 //   - error: 初始化过程中的错误，如边缘节点解析失败等
 func NewSupervisor(config *TunnelConfig, orchestrator *orchestration.Orchestrator, reconnectCh chan ReconnectSignal, gracefulShutdownC <-chan struct{}) (*Supervisor, error) {
-	// 判断是否使用静态边缘地址（用户手动指定）还是动态解析
-	isStaticEdge := len(config.EdgeAddrs) > 0
+	// 先添加日志钩子，之后派生的日志记录器都会调用它们
+	config.Log = withHooks(config.Log, config.LogHooks)
+	config.LogTransport = withHooks(config.LogTransport, config.TransportLogHooks)
 
-	var err error
-	var edgeIPs *edgediscovery.Edge
-	if isStaticEdge {
-		// 使用静态配置的边缘地址
-		edgeIPs, err = edgediscovery.StaticEdge(config.Log, config.EdgeAddrs)
-	} else {
-		// 根据区域和 IP 版本动态解析边缘节点地址
-		edgeIPs, err = edgediscovery.ResolveEdge(config.Log, config.Region, config.EdgeIPVersion)
-	}
+	edgeIPs, err := resolveEdge(config)
 	if err != nil {
 		return nil, err
 	}
 
+	edgeIPs.SetRotationPolicy(config.EdgeRotation)
+
+	// 重启后优先使用各连接上次注册所在的边缘IP，减少重复注册错误
+	if config.EdgeAddrStateFile != "" {
+		sticky, err := edgediscovery.LoadStickyAddrs(config.EdgeAddrStateFile, config.EdgeAddrStateTTL)
+		if err != nil {
+			config.Log.Warn().Err(err).Msg("Ignoring previously registered edge addresses")
+		}
+		edgeIPs.UseStickyAddrs(sticky)
+	}
+
+	// 根据各边缘IP的历史表现为其打分，优先选择表现好的IP，配置了文件时评分会跨重启保留
+	scorecard, err := edgediscovery.NewScorecard(config.EdgeScorecardFile)
+	if err != nil {
+		config.Log.Warn().Err(err).Msg("Ignoring previous edge address scores")
+	}
+	edgeIPs.UseScorecard(scorecard)
+
+	// 避免两个连接注册到同一个边缘IP，配置了锁文件目录时也避免与本机其他进程的连接重复
+	registrationGuard, err := edgediscovery.NewRegistrationGuard(config.EdgeRegistrationLockDir)
+	if err != nil {
+		config.Log.Warn().Err(err).Msg("Only avoiding duplicate edge registrations within this process")
+	}
+	edgeIPs.UseRegistrationGuard(registrationGuard)
+
+	// 配置了随机种子时，边缘地址的选择和退避时间都由种子决定，便于复现不稳定的重连行为
+	var seeds *rand.Rand
+	if config.RandomSeed != 0 {
+		seeds = rand.New(rand.NewSource(config.RandomSeed))      // #nosec G404
+		edgeIPs.SetRand(rand.New(rand.NewSource(seeds.Int63()))) // #nosec G404
+	}
+
+	// 注册外部的隧道状态监听器
+	for _, listener := range config.Listeners {
+		config.Observer.RegisterListener(listener)
+	}
+	if len(config.Listeners) > 0 {
+		orchestrator.OnConfigApplied(config.Observer.SendConfigApplied)
+	}
+
 	// 创建连接状态跟踪器，用于监控所有隧道连接的状态
 	tracker := tunnelstate.NewConnTracker(config.Log)
 
@@ -131,11 +203,24 @@ func NewSupervisor(config *TunnelConfig, orchestrator *orchestration.Orchestrato
 	// 获取边缘绑定地址，用于指定本地出站网络接口
 	edgeBindAddr := config.EdgeBindAddr
 
-	// 创建数据报度量收集器，用于监控 QUIC 数据报的性能指标
-	datagramMetrics := v3.NewMetrics(prometheus.DefaultRegisterer)
+	// 创建数据报度量收集器，用于监控 QUIC 数据报的性能指标，嵌入方可以注册到自己的注册器上，与其他隧道的指标隔离
+	datagramMetrics := newDatagramMetrics(config.DatagramMetricsRegisterer)
+
+	// 配置了休眠时记录代理的请求，没有进行中的请求且空闲一段时间后进入休眠
+	hibernation := newHibernation(config.HibernateAfter, config.HibernateKeepAlive)
+
+	// 配置了CPU或内存限制时，过载期间拒绝超出令牌桶速率的新流和UDP会话，保证已有会话正常服务
+	overload := newOverload(config.OverloadCPU, config.OverloadMemory, config.OverloadStreamRate)
+
+	// 配置了看门狗时，报告停止应答控制流心跳的连接，配置了重启时重连它们
+	watchdog := newWatchdog(config.WatchdogTimeout, config.WatchdogRestart, config.ControlStreamHeartbeat, config.HAConnections, config.Log)
 
-	// 创建会话管理器，负责管理 QUIC 会话和流量控制
-	sessionManager := v3.NewSessionManager(datagramMetrics, config.Log, config.OriginDialerService, orchestrator.GetFlowLimiter())
+	// 创建会话管理器，负责管理 QUIC 会话和流量控制，连接断开后会话在宽限期内可在新连接上恢复，配置了流量统计时统计 UDP 会话的流量，
+	// 会话打开期间不休眠
+	sessionManager := v3.NewSessionManager(datagramMetrics, config.Log, hibernation.udpDialer(config.Usage.UDPDialer(config.OriginDialerService)), overload.flowLimiter(orchestrator.GetFlowLimiter()), config.UDPSessionResumeGrace)
+
+	// 记录失败的连接尝试，启动超时时汇总报告
+	attempts := newConnectionAttempts()
 
 	// 创建边缘隧道服务器，这是实际建立和维护隧道连接的核心组件
 	edgeTunnelServer := EdgeTunnelServer{
@@ -150,8 +235,24 @@ func NewSupervisor(config *TunnelConfig, orchestrator *orchestration.Orchestrato
 		reconnectCh:       reconnectCh,
 		gracefulShutdownC: gracefulShutdownC,
 		connAwareLogger:   log,
+		// 在重连之间共享，用于识别反复超时的控制流
+		controlPlaneHealth: connection.NewControlPlaneHealth(config.ControlStreamFallbackThreshold),
+		handover:           newFallbackHandover(),
+		race:               newConnectionRace(config.FirstConnectionRace),
+		attempts:           attempts,
+		audit:              newConnectionAudit(config.ConnectionAuditFile, config.ConnectionAuditMaxSize, config.ConnectionAuditMaxBackups),
+		preparer:           config.ReconnectPreparer,
+		mtuProbes:          newMTUProbes(config.QUICMTUProbe),
+		hibernation:        hibernation,
+		overload:           overload,
+		watchdog:           watchdog,
+		errorBudget:        newProtocolErrorBudget(config.ProtocolFallbackSuccessRate, config.ProtocolFallbackWindow),
+		features:           newNegotiatedFeatures(),
 	}
 
+	// 计划维护前可以通过 preparer 重新解析并探测边缘地址
+	config.ReconnectPreparer.attach(edgeTunnelServer.probeFreshEdge)
+
 	// 组装并返回完整的 Supervisor 实例
 	return &Supervisor{
 		config:                  config,
@@ -165,9 +266,56 @@ func NewSupervisor(config *TunnelConfig, orchestrator *orchestration.Orchestrato
 		logTransport:            config.LogTransport,
 		reconnectCh:             reconnectCh,
 		gracefulShutdownC:       gracefulShutdownC,
+		clock:                   retry.Clock{Now: time.Now, After: time.After},
+		seeds:                   seeds,
+		attempts:                attempts,
+		preparer:                config.ReconnectPreparer,
+		hibernation:             hibernation,
+		overload:                overload,
+		watchdog:                watchdog,
+		quicAssessment:          newQUICAssessment(config.QUICAssessmentInterval, config.ProtocolSelector, edgeIPs, config.EdgeTLSConfigs[connection.QUIC], config.EdgeBindAddr, config.Log),
+		clockSkew:               newClockSkewCheck(config.ClockSkewCheckURL, config.ClockSkewCheckInterval, config.ClockSkewThreshold, config.ClockSkewWait, config.Log),
+		tunnelCancels:           map[int]context.CancelFunc{},
+		tunnelsHibernated:       map[int]bool{},
+		tunnelsRestarting:       map[int]bool{},
 	}, nil
 }
 
+// reconnectAll 向每个 HA 连接发送重连信号，连接重连时会重新选择协议
+func (s *Supervisor) reconnectAll(ctx context.Context) {
+	if s.reconnectCh == nil {
+		return
+	}
+	for i := 0; i < s.config.HAConnections; i++ {
+		select {
+		case s.reconnectCh <- ReconnectSignal{}:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// withHooks 返回添加了钩子的日志记录器，没有钩子时原样返回
+func withHooks(log *zerolog.Logger, hooks []zerolog.Hook) *zerolog.Logger {
+	if log == nil || len(hooks) == 0 {
+		return log
+	}
+	hooked := *log
+	for _, hook := range hooks {
+		hooked = hooked.Hook(hook)
+	}
+	return &hooked
+}
+
+// resolveEdge 查找可分配给连接的边缘地址
+// 配置了静态边缘地址（用户手动指定）时直接使用，否则根据区域和 IP 版本动态解析
+func resolveEdge(config *TunnelConfig) (*edgediscovery.Edge, error) {
+	if len(config.EdgeAddrs) > 0 {
+		return edgediscovery.StaticEdge(config.Log, config.EdgeAddrs)
+	}
+	return edgediscovery.ResolveEdge(config.Log, config.Region, config.EdgeIPVersion, config.EdgeHosts)
+}
+
 // Run 启动 Supervisor 的主事件循环，管理所有隧道连接的生命周期
 //
 // 此方法负责：
@@ -187,24 +335,42 @@ func (s *Supervisor) Run(
 ) error {
 	// 如果配置了 ICMP 路由器服务器，在后台启动它
 	// ICMP 用于网络诊断（如 ping、traceroute）
+	// 意外退出时按退避时间重启，运行状态通过指标和诊断的隧道状态报告
 	if s.config.ICMPRouterServer != nil {
-		go func() {
-			if err := s.config.ICMPRouterServer.Serve(ctx); err != nil {
-				if errors.Is(err, net.ErrClosed) {
-					s.log.Logger().Info().Err(err).Msg("icmp router terminated")
-				} else {
-					s.log.Logger().Err(err).Msg("icmp router terminated")
-				}
-			}
-		}()
+		go newSubsystem(subsystemICMP, s.log.Logger()).supervise(ctx, s.config.ICMPRouterServer.Serve)
 	}
 
 	// 启动 DNS 解析器的刷新循环
 	// 定期刷新源站 DNS 记录，确保连接到正确的后端服务器
-	go s.config.OriginDNSService.StartRefreshLoop(ctx)
+	// 只配置了一个静态解析器地址时刷新循环会立即返回，不需要重启
+	if s.config.OriginDNSService.RefreshesInBackground() {
+		go newSubsystem(subsystemDNSRefresh, s.log.Logger()).supervise(ctx, func(ctx context.Context) error {
+			s.config.OriginDNSService.StartRefreshLoop(ctx)
+			return nil
+		})
+	} else {
+		go s.config.OriginDNSService.StartRefreshLoop(ctx)
+	}
+
+	// 定期采样CPU和内存负载，过载时限制接受新流的速率
+	go s.overload.run(ctx, s.log.Logger())
+
+	// 定期检查已注册连接的心跳
+	go s.watchdog.run(ctx)
+
+	// 使用HTTP2时定期评估QUIC是否可用
+	go s.quicAssessment.run(ctx)
+
+	// 注册前检查本地时钟，时钟偏差过大时按配置等待时钟同步，之后定期检查
+	s.clockSkew.waitForSync(ctx)
+	go s.clockSkew.run(ctx)
+
+	// 启动超时后需要停止仍在重试的第一个隧道
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
 	// 初始化阶段：建立第一个隧道连接，然后启动其余的 HA 连接
-	if err := s.initialize(ctx, connectedSignal); err != nil {
+	if err := s.initialize(ctx, cancel, connectedSignal); err != nil {
 		if err == errEarlyShutdown {
 			// 在初始化阶段就收到了关闭信号，正常退出
 			return nil
@@ -220,11 +386,30 @@ func (s *Supervisor) Run(
 	tunnelsActive := s.config.HAConnections
 
 	// 创建退避计时器，用于控制重试间隔，避免频繁重连
-	backoff := retry.NewBackoff(s.config.Retries, tunnelRetryDuration, true)
+	backoff := s.newBackoff(tunnelRetryDuration)
 	var backoffTimer <-chan time.Time
 
 	// shuttingDown 标记是否正在关闭，用于在关闭时停止新的重连
 	shuttingDown := false
+	// 关闭信号通道被关闭后会一直可读，收到信号后置为 nil 以免主循环空转
+	gracefulShutdownC := s.gracefulShutdownC
+	// 主循环接收准备重连的信号，退出后准备重连不再等待主循环
+	defer s.preparer.supervise()()
+
+	// 配置了协议计划表时定期检查窗口，窗口打开或关闭时让连接以计划的协议重连，配置了维护窗口时推迟到维护窗口内
+	var scheduleTimer <-chan time.Time
+	scheduler, scheduled := s.config.ProtocolSelector.(connection.ProtocolScheduler)
+	var scheduledProtocol connection.Protocol
+	var inSchedule bool
+	// scheduleReconnect 标记计划表变化后的重连因不在维护窗口内而被推迟
+	var scheduleReconnect bool
+	if scheduled {
+		scheduledProtocol, inSchedule = scheduler.Scheduled()
+		scheduleTimer = s.clock.After(protocolScheduleInterval)
+	}
+
+	// 配置了休眠时，隧道空闲足够长的时间后休眠
+	hibernateTimer := s.hibernation.idleTimer(s.clock.After)
 
 	// 主事件循环：监听各种事件并做出响应
 	for {
@@ -244,13 +429,29 @@ func (s *Supervisor) Run(
 			tunnelsActive--
 			s.log.ConnAwareLogger().Err(tunnelError.err).Int(connection.LogFieldConnIndex, tunnelError.index).Msg("Connection terminated")
 
+			// 休眠时停止的隧道保持停止，直到被唤醒
+			if _, ok := s.tunnelsHibernated[tunnelError.index]; ok {
+				s.tunnelsHibernated[tunnelError.index] = true
+				continue
+			}
+			// 休眠或唤醒时需要重启的隧道立即重连
+			if s.tunnelsRestarting[tunnelError.index] {
+				delete(s.tunnelsRestarting, tunnelError.index)
+				if !shuttingDown {
+					go s.startTunnel(s.tunnelContext(ctx, tunnelError.index), tunnelError.index, s.newConnectedTunnelSignal(tunnelError.index))
+					tunnelsActive++
+					continue
+				}
+			}
+
 			// 如果隧道出错且不在关闭状态，则尝试重连
 			if tunnelError.err != nil && !shuttingDown {
 				switch tunnelError.err.(type) {
-				case ReconnectSignal:
+				case ReconnectSignal, handoverError, edgeBackoffError:
 					// 对于收到重连信号的隧道，立即重连（不等待退避时间）
-					// 这通常发生在边缘节点要求客户端重新连接的情况
-					go s.startTunnel(ctx, tunnelError.index, s.newConnectedTunnelSignal(tunnelError.index))
+					// 这通常发生在边缘节点要求客户端重新连接的情况，或者连接已排空并交接到降级协议
+					// 边缘要求退避时连接已等待过，同样立即重连
+					go s.startTunnel(s.tunnelContext(ctx, tunnelError.index), tunnelError.index, s.newConnectedTunnelSignal(tunnelError.index))
 					tunnelsActive++
 					continue
 				}
@@ -266,7 +467,10 @@ func (s *Supervisor) Run(
 				s.waitForNextTunnel(tunnelError.index)
 
 				// 如果退避计时器还未启动，则启动它
-				if backoffTimer == nil {
+				// 准备维护期间不增加退避时间，维护结束后隧道可以在几秒内重连
+				if backoffTimer == nil && s.preparer.preparing() {
+					backoffTimer = s.clock.After(preparedRetryDuration)
+				} else if backoffTimer == nil {
 					backoffTimer = backoff.BackoffTimer()
 				}
 			} else if tunnelsActive == 0 {
@@ -278,13 +482,81 @@ func (s *Supervisor) Run(
 		// 退避计时器到期，重新启动等待中的隧道
 		case <-backoffTimer:
 			backoffTimer = nil
+			// 正在关闭时不再重启等待中的隧道
+			if shuttingDown {
+				tunnelsWaiting = nil
+				continue
+			}
 			// 为所有等待的隧道重新建立连接
 			for _, index := range tunnelsWaiting {
-				go s.startTunnel(ctx, index, s.newConnectedTunnelSignal(index))
+				go s.startTunnel(s.tunnelContext(ctx, index), index, s.newConnectedTunnelSignal(index))
 			}
 			tunnelsActive += len(tunnelsWaiting)
 			tunnelsWaiting = nil
 
+		// 检查协议计划表的窗口是否打开或关闭
+		case <-scheduleTimer:
+			scheduleTimer = s.clock.After(protocolScheduleInterval)
+			protocol, active := scheduler.Scheduled()
+			if active != inSchedule || protocol != scheduledProtocol {
+				scheduledProtocol, inSchedule = protocol, active
+				scheduleReconnect = true
+				if !s.config.Maintenance.Open() {
+					s.log.Logger().Info().Msgf("Protocol schedule changed, reconnecting with %s during the next maintenance window", scheduler.Current())
+				}
+			}
+			if scheduleReconnect && !shuttingDown && s.config.Maintenance.Open() {
+				scheduleReconnect = false
+				s.log.Logger().Info().Msgf("Protocol schedule changed, reconnecting with %s", scheduler.Current())
+				go s.reconnectAll(ctx)
+			}
+
+		// 维护窗口被紧急覆盖，立即执行推迟的重连
+		case <-s.config.Maintenance.Overridden():
+			if scheduleReconnect && !shuttingDown {
+				scheduleReconnect = false
+				s.log.Logger().Info().Msgf("Maintenance windows overridden, reconnecting with %s", scheduler.Current())
+				go s.reconnectAll(ctx)
+			}
+
+		// 隧道可能已经空闲了足够长的时间，只保留第一个连接
+		case <-hibernateTimer:
+			hibernateTimer = nil
+			if shuttingDown {
+				continue
+			}
+			if !s.hibernation.shouldHibernate() {
+				hibernateTimer = s.hibernation.idleTimer(s.clock.After)
+				continue
+			}
+			s.hibernate(tunnelsWaiting)
+
+		// 第一个连接以休眠的保活频率重新连接成功，停止其余连接
+		case <-s.hibernationConnected():
+			tunnelsWaiting = s.hibernateOthers(tunnelsWaiting)
+
+		// 休眠时收到请求，立即恢复所有连接
+		case <-s.hibernation.woken():
+			if shuttingDown {
+				continue
+			}
+			tunnelsActive += s.wakeUp(ctx)
+			hibernateTimer = s.hibernation.idleTimer(s.clock.After)
+
+		// 看门狗发现连接停止应答心跳，立即重连该连接
+		case index := <-s.watchdog.stalled():
+			if _, ok := s.tunnelsHibernated[int(index)]; ok || shuttingDown {
+				continue
+			}
+			s.tunnelsRestarting[int(index)] = true
+			s.stopTunnel(int(index))
+
+		// 开始准备维护，缩短正在等待的退避
+		case <-s.preparer.prepared():
+			if backoffTimer != nil {
+				backoffTimer = s.clock.After(preparedRetryDuration)
+			}
+
 		// 有隧道成功连接
 		case <-s.nextConnectedSignal:
 			// 检查是否还有其他隧道正在连接
@@ -295,8 +567,14 @@ func (s *Supervisor) Run(
 			}
 
 		// 收到优雅关闭信号
-		case <-s.gracefulShutdownC:
+		case <-gracefulShutdownC:
+			gracefulShutdownC = nil
 			shuttingDown = true
+			// 所有隧道都在等待退避时不会再有隧道退出，直接结束
+			if tunnelsActive == 0 {
+				s.log.ConnAwareLogger().Msg("no more connections active and exiting")
+				return nil
+			}
 		}
 	}
 }
@@ -310,12 +588,14 @@ func (s *Supervisor) Run(
 //
 // 参数:
 //   - ctx: 上下文
+//   - cancel: 取消 ctx，启动超时时用于停止第一个隧道
 //   - connectedSignal: 当第一个隧道成功连接时发出的信号
 //
 // 返回:
 //   - error: 如果初始化成功返回 nil，否则返回初始化错误
 func (s *Supervisor) initialize(
 	ctx context.Context,
+	cancel context.CancelFunc,
 	connectedSignal *signal.Signal,
 ) error {
 	// 获取可用的边缘地址数量
@@ -329,13 +609,24 @@ func (s *Supervisor) initialize(
 
 	// 为第一个隧道（索引 0）初始化协议降级配置
 	s.tunnelsProtocolFallback[0] = &protocolFallback{
-		retry.NewBackoff(s.config.Retries, retry.DefaultBaseTime, true), // 退避计时器
-		s.config.ProtocolSelector.Current(),                             // 当前选择的协议
-		false,                                                           // 是否已降级
+		s.newBackoff(retry.DefaultBaseTime), // 退避计时器
+		s.config.ProtocolSelector.Current(), // 当前选择的协议
+		false,                               // 是否已降级
+		false,                               // 是否由协议计划表指定
+		false,                               // 是否由其他连接的协议提示指定
+	}
+	if _, ok := s.config.ProtocolSelector.(connection.HybridProtocolSelector); ok && s.config.HAConnections < 2 {
+		s.log.Logger().Warn().Msgf("The hybrid protocol needs at least 2 HA connections to serve both QUIC and HTTP2, only QUIC is served with %d", s.config.HAConnections)
 	}
 
 	// 启动第一个隧道连接（在后台运行）
-	go s.startFirstTunnel(ctx, connectedSignal)
+	go s.startFirstTunnel(s.tunnelContext(ctx, 0), connectedSignal)
+
+	// 配置了启动超时时，超时内没有连接注册成功则放弃
+	var readyTimeoutC <-chan time.Time
+	if s.config.ReadyTimeout > 0 {
+		readyTimeoutC = s.clock.After(s.config.ReadyTimeout)
+	}
 
 	// 等待第一个隧道的响应，然后再尝试启动其他 HA 边缘隧道
 	// 这确保了至少有一个可工作的连接，然后再建立其余连接
@@ -350,28 +641,45 @@ func (s *Supervisor) initialize(
 	case <-s.gracefulShutdownC:
 		// 在初始化期间收到关闭信号
 		return errEarlyShutdown
+	case <-readyTimeoutC:
+		// 停止重试第一个隧道，汇总所有失败的连接尝试
+		cancel()
+		<-s.tunnelErrors
+		err := &ReadyTimeoutError{Timeout: s.config.ReadyTimeout, Attempts: s.attempts.list()}
+		err.logAttempts(s.log.Logger())
+		return err
 	case <-connectedSignal.Wait():
 		// 第一个隧道成功连接，继续后续流程
 	}
 
-	// 至少有一个成功的连接，启动其余的隧道
-	for i := 1; i < s.config.HAConnections; i++ {
-		// 为每个隧道设置协议降级配置
-		s.tunnelsProtocolFallback[i] = &protocolFallback{
-			retry.NewBackoff(s.config.Retries, retry.DefaultBaseTime, true),
-			// 使用第一个隧道成功连接的协议
-			// 这样可以避免重复尝试已知失败的协议
-			s.tunnelsProtocolFallback[0].protocol,
-			false,
-		}
-		// 启动隧道连接
-		go s.startTunnel(ctx, i, s.newConnectedTunnelSignal(i))
-		// 在启动隧道之间等待一小段时间，避免同时建立大量连接
-		time.Sleep(registrationInterval)
-	}
+	// 至少有一个成功的连接，按配置的注册节奏启动其余的隧道，避免同时建立大量连接
+	s.startHAConnections(ctx)
 	return nil
 }
 
+// haConnectionProtocol 返回第一个隧道连接成功后，其余HA连接一开始使用的协议
+// 通常使用第一个隧道成功连接的协议，这样可以避免重复尝试已知失败的协议
+// 混合模式下每个连接使用自己的协议，除非第一个隧道已经降级，说明QUIC在这里无法使用
+// index: 连接索引
+func (s *Supervisor) haConnectionProtocol(index int) connection.Protocol {
+	first := s.tunnelsProtocolFallback[0]
+	if hybrid, ok := s.config.ProtocolSelector.(connection.HybridProtocolSelector); ok && !first.inFallback {
+		return hybrid.ForConnection(uint8(index)).Current() // nolint: gosec
+	}
+	return first.protocol
+}
+
+// newBackoff 创建一个使用 Supervisor 时钟的无限重试退避计时器
+// 只在主循环中调用，这样配置了随机种子时各计时器得到的种子是确定的
+func (s *Supervisor) newBackoff(baseTime time.Duration) retry.BackoffHandler {
+	backoff := retry.NewBackoff(s.config.Retries, baseTime, true)
+	backoff.Clock = s.clock
+	if s.seeds != nil {
+		backoff.Rand = rand.New(rand.NewSource(s.seeds.Int63())) // #nosec G404
+	}
+	return backoff
+}
+
 // startFirstTunnel 启动第一个隧道连接
 //
 // 这是一个特殊的函数，专门用于启动第一个隧道。与 startTunnel 不同，
@@ -431,6 +739,7 @@ func (s *Supervisor) startFirstTunnel(
 				return
 			}
 		case connection.DupConnRegisterTunnelError,
+			edgeBackoffError,
 			*quic.IdleTimeoutError,
 			*quic.ApplicationError,
 			edgediscovery.DialError,
@@ -484,6 +793,10 @@ func (s *Supervisor) newConnectedTunnelSignal(index int) *signal.Signal {
 
 	// 将这个通道记录到正在连接的隧道映射中
 	s.tunnelsConnecting[index] = sig
+	// 开始休眠后，第一个连接成功时才停止其余连接
+	if index == 0 && s.hibernationPending {
+		s.hibernationConnectedC = sig
+	}
 
 	// 更新下一个预期连接的隧道信息
 	s.nextConnectedSignal = sig
@@ -0,0 +1,652 @@
+package supervisor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/edgediscovery"
+	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+	"github.com/cloudflare/cloudflared/ingress/origins"
+	"github.com/cloudflare/cloudflared/maintenance"
+	"github.com/cloudflare/cloudflared/retry"
+	"github.com/cloudflare/cloudflared/signal"
+	"github.com/cloudflare/cloudflared/tunnelstate"
+)
+
+const simulationTimeout = 5 * time.Second
+
+// fakeClock is a retry.Clock whose time only moves when Advance is called.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeTimer
+}
+
+type fakeTimer struct {
+	deadline time.Time
+	c        chan time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Unix(0, 0)}
+}
+
+func (c *fakeClock) clock() retry.Clock {
+	return retry.Clock{Now: c.Now, After: c.After}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	timer := fakeTimer{deadline: c.now.Add(d), c: make(chan time.Time, 1)}
+	if d <= 0 {
+		timer.c <- c.now
+		return timer.c
+	}
+	c.timers = append(c.timers, timer)
+	return timer.c
+}
+
+// Advance moves the clock forward by d and fires every timer that expired.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	pending := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.deadline.After(c.now) {
+			pending = append(pending, timer)
+			continue
+		}
+		timer.c <- c.now
+	}
+	c.timers = pending
+}
+
+// waitForTimers waits until n timers are pending.
+func (c *fakeClock) waitForTimers(t *testing.T, n int) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		return len(c.timers) == n
+	}, simulationTimeout, time.Millisecond, "expected %d pending timers", n)
+}
+
+// serveCall is a call to stubTunnelServer.Serve, which blocks until the test sends its result.
+type serveCall struct {
+	ctx       context.Context
+	connIndex uint8
+	connected *signal.Signal
+	result    chan error
+}
+
+// connect signals that the connection registered.
+func (c serveCall) connect() {
+	c.connected.Notify()
+}
+
+// exit makes Serve return err.
+func (c serveCall) exit(err error) {
+	c.result <- err
+}
+
+type stubTunnelServer struct {
+	calls chan serveCall
+}
+
+func (s *stubTunnelServer) Serve(ctx context.Context, connIndex uint8, _ *protocolFallback, connectedSignal *signal.Signal) error {
+	call := serveCall{
+		ctx:       ctx,
+		connIndex: connIndex,
+		connected: connectedSignal,
+		result:    make(chan error, 1),
+	}
+	select {
+	case s.calls <- call:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-call.result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// nextCall returns the next connection the supervisor started.
+func (s *stubTunnelServer) nextCall(t *testing.T) serveCall {
+	t.Helper()
+	select {
+	case call := <-s.calls:
+		return call
+	case <-time.After(simulationTimeout):
+		require.FailNow(t, "supervisor did not start a connection")
+		return serveCall{}
+	}
+}
+
+// nextCalls returns the next n connections the supervisor started, ordered by connection index.
+func (s *stubTunnelServer) nextCalls(t *testing.T, n int) []serveCall {
+	t.Helper()
+	calls := make([]serveCall, 0, n)
+	for i := 0; i < n; i++ {
+		calls = append(calls, s.nextCall(t))
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		return calls[i].connIndex < calls[j].connIndex
+	})
+	return calls
+}
+
+// assertNoCall asserts the supervisor does not start a connection without the clock moving.
+func (s *stubTunnelServer) assertNoCall(t *testing.T) {
+	t.Helper()
+	select {
+	case call := <-s.calls:
+		assert.Failf(t, "unexpected connection", "connection %d was started", call.connIndex)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+type simulation struct {
+	supervisor        *Supervisor
+	clock             *fakeClock
+	server            *stubTunnelServer
+	gracefulShutdownC chan struct{}
+	cancel            context.CancelFunc
+	runErrC           chan error
+}
+
+// newSimulation runs a supervisor with haConnections connections served by a stub tunnel server, whose time is
+// controlled by a fake clock. configure can change the tunnel config before the supervisor runs.
+func newSimulation(t *testing.T, haConnections int, configure ...func(*TunnelConfig)) *simulation {
+	log := zerolog.Nop()
+	edgeAddrs := make([]string, 0, haConnections)
+	for i := 0; i < haConnections; i++ {
+		edgeAddrs = append(edgeAddrs, fmt.Sprintf("127.0.0.%d:7844", i+1))
+	}
+	edgeIPs, err := edgediscovery.StaticEdge(&log, edgeAddrs)
+	require.NoError(t, err)
+	fetcher := dynamicMockFetcher{
+		protocolPercents: edgediscovery.ProtocolPercents{edgediscovery.ProtocolPercent{Protocol: "quic", Percentage: 100}},
+	}
+	protocolSelector, err := connection.NewProtocolSelector("quic", "", false, false, fetcher.fetch(), time.Hour, &log)
+	require.NoError(t, err)
+
+	config := &TunnelConfig{
+		EdgeAddrs:        edgeAddrs,
+		HAConnections:    haConnections,
+		Log:              &log,
+		LogTransport:     &log,
+		Retries:          5,
+		ProtocolSelector: protocolSelector,
+		OriginDNSService: origins.NewStaticDNSResolverService(
+			[]netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:53")},
+			origins.NewDNSDialer(),
+			&log,
+			origins.NewMetrics(prometheus.NewRegistry()),
+		),
+	}
+	for _, c := range configure {
+		c(config)
+	}
+	clock := newFakeClock()
+	if config.ReconnectPreparer != nil {
+		config.ReconnectPreparer.now = clock.Now
+	}
+	server := &stubTunnelServer{calls: make(chan serveCall)}
+	gracefulShutdownC := make(chan struct{})
+	s := &Supervisor{
+		config:                  config,
+		edgeIPs:                 edgeIPs,
+		edgeTunnelServer:        server,
+		reconnectCh:             make(chan ReconnectSignal, haConnections),
+		tunnelErrors:            make(chan tunnelError),
+		tunnelsConnecting:       map[int]chan struct{}{},
+		tunnelsProtocolFallback: map[int]*protocolFallback{},
+		log:                     NewConnAwareLogger(&log, tunnelstate.NewConnTracker(&log), connection.NewObserver(&log, &log)),
+		logTransport:            &log,
+		gracefulShutdownC:       gracefulShutdownC,
+		clock:                   clock.clock(),
+		attempts:                newConnectionAttempts(),
+		preparer:                config.ReconnectPreparer,
+		hibernation:             newHibernation(config.HibernateAfter, config.HibernateKeepAlive),
+		tunnelCancels:           map[int]context.CancelFunc{},
+		tunnelsHibernated:       map[int]bool{},
+		tunnelsRestarting:       map[int]bool{},
+	}
+	if config.RandomSeed != 0 {
+		s.seeds = rand.New(rand.NewSource(config.RandomSeed))
+	}
+	if s.hibernation != nil {
+		s.hibernation.now = clock.Now
+		s.hibernation.lastActivity.Store(clock.Now().UnixNano())
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	sim := &simulation{
+		supervisor:        s,
+		clock:             clock,
+		server:            server,
+		gracefulShutdownC: gracefulShutdownC,
+		cancel:            cancel,
+		runErrC:           make(chan error, 1),
+	}
+	go func() {
+		sim.runErrC <- s.Run(ctx, signal.New(make(chan struct{})))
+	}()
+	return sim
+}
+
+// connectAll registers every HA connection, stepping over the delay between registrations.
+func (sim *simulation) connectAll(t *testing.T) []serveCall {
+	t.Helper()
+	haConnections := sim.supervisor.config.HAConnections
+	calls := make([]serveCall, 0, haConnections)
+	first := sim.server.nextCall(t)
+	first.connect()
+	calls = append(calls, first)
+	for i := 1; i < haConnections; i++ {
+		call := sim.server.nextCall(t)
+		call.connect()
+		calls = append(calls, call)
+		sim.clock.waitForTimers(t, 1)
+		sim.clock.Advance(registrationInterval)
+	}
+	return calls
+}
+
+// advanceBackoff fires the pending supervisor backoff timer, whatever its random duration.
+func (sim *simulation) advanceBackoff(t *testing.T, retries uint) {
+	t.Helper()
+	sim.clock.waitForTimers(t, 1)
+	sim.clock.Advance(tunnelRetryDuration * (1 << retries))
+}
+
+func (sim *simulation) waitForExit(t *testing.T) error {
+	t.Helper()
+	select {
+	case err := <-sim.runErrC:
+		return err
+	case <-time.After(simulationTimeout):
+		require.FailNow(t, "supervisor did not exit")
+		return nil
+	}
+}
+
+func TestSupervisorFirstConnectionFails(t *testing.T) {
+	sim := newSimulation(t, 2)
+
+	errFatal := errors.New("fatal")
+	sim.server.nextCall(t).exit(errFatal)
+
+	assert.Equal(t, errFatal, sim.waitForExit(t))
+	sim.server.assertNoCall(t)
+}
+
+func TestSupervisorReadyTimeout(t *testing.T) {
+	sim := newSimulation(t, 2, func(config *TunnelConfig) {
+		config.ReadyTimeout = time.Minute
+	})
+	addr := &allregions.EdgeAddr{UDP: &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 7844}}
+	sim.supervisor.attempts.record(0, addr, connection.QUIC, false, errors.New("handshake timeout"))
+
+	// The first connection is still trying when the timeout expires
+	first := sim.server.nextCall(t)
+	sim.clock.waitForTimers(t, 1)
+	sim.clock.Advance(time.Minute)
+
+	var readyTimeoutErr *ReadyTimeoutError
+	require.ErrorAs(t, sim.waitForExit(t), &readyTimeoutErr)
+	assert.Equal(t, time.Minute, readyTimeoutErr.Timeout)
+	require.Len(t, readyTimeoutErr.Attempts, 1)
+	assert.Equal(t, "127.0.0.1", readyTimeoutErr.Attempts[0].EdgeIP)
+	assert.Equal(t, "quic", readyTimeoutErr.Attempts[0].Protocol)
+	assert.Equal(t, "handshake timeout", readyTimeoutErr.Attempts[0].Error)
+	assert.Equal(t, uint8(0), first.connIndex)
+	sim.server.assertNoCall(t)
+}
+
+func TestSupervisorAllConnectionsFail(t *testing.T) {
+	sim := newSimulation(t, 2)
+	calls := sim.connectAll(t)
+
+	for _, call := range calls {
+		call.exit(errors.New("connection lost"))
+	}
+	sim.clock.waitForTimers(t, 1)
+	sim.server.assertNoCall(t)
+
+	sim.advanceBackoff(t, 1)
+	restarted := sim.server.nextCalls(t, 2)
+	assert.Equal(t, uint8(0), restarted[0].connIndex)
+	assert.Equal(t, uint8(1), restarted[1].connIndex)
+
+	sim.cancel()
+	assert.NoError(t, sim.waitForExit(t))
+}
+
+func TestSupervisorConnectionFlaps(t *testing.T) {
+	sim := newSimulation(t, 2)
+	calls := sim.connectAll(t)
+
+	flapping := calls[1]
+	for retries := uint(1); retries <= 3; retries++ {
+		flapping.exit(errors.New("connection lost"))
+		sim.clock.waitForTimers(t, 1)
+		sim.server.assertNoCall(t)
+
+		sim.advanceBackoff(t, retries)
+		flapping = sim.server.nextCall(t)
+		require.Equal(t, uint8(1), flapping.connIndex)
+		flapping.connect()
+	}
+
+	// The stable connection was never restarted.
+	sim.server.assertNoCall(t)
+	sim.cancel()
+	assert.NoError(t, sim.waitForExit(t))
+}
+
+func TestSupervisorReconnectSignalSkipsBackoff(t *testing.T) {
+	sim := newSimulation(t, 2)
+	calls := sim.connectAll(t)
+
+	calls[1].exit(ReconnectSignal{})
+	assert.Equal(t, uint8(1), sim.server.nextCall(t).connIndex)
+
+	sim.cancel()
+	assert.NoError(t, sim.waitForExit(t))
+}
+
+func TestSupervisorEdgeBackoffSkipsBackoff(t *testing.T) {
+	sim := newSimulation(t, 2)
+
+	// The first connection keeps retrying while the edge rate limits it
+	sim.server.nextCall(t).exit(edgeBackoffError{errors.New("rate limited")})
+	first := sim.server.nextCall(t)
+	assert.Equal(t, uint8(0), first.connIndex)
+	first.connect()
+	sim.clock.waitForTimers(t, 1)
+	sim.clock.Advance(registrationInterval)
+	calls := sim.server.nextCalls(t, 1)
+	calls[0].connect()
+
+	// The connection already waited as long as the edge asked, so it restarts right away
+	calls[0].exit(edgeBackoffError{errors.New("rate limited")})
+	assert.Equal(t, uint8(1), sim.server.nextCall(t).connIndex)
+
+	sim.cancel()
+	assert.NoError(t, sim.waitForExit(t))
+}
+
+func TestSupervisorPreparedReconnect(t *testing.T) {
+	sim := newSimulation(t, 2, func(config *TunnelConfig) {
+		config.ReconnectPreparer = NewReconnectPreparer()
+		// The usual backoff after the window is random and could be as short as a prepared one
+		config.RandomSeed = 1
+	})
+	calls := sim.connectAll(t)
+	preparer := sim.supervisor.preparer
+
+	// While prepared, dropped connections retry quickly
+	until := preparer.prepare(time.Minute)
+	assert.Equal(t, sim.clock.Now().Add(time.Minute), until)
+	calls[1].exit(errors.New("network maintenance"))
+	sim.clock.waitForTimers(t, 1)
+	sim.clock.Advance(preparedRetryDuration)
+	restarted := sim.server.nextCall(t)
+	assert.Equal(t, uint8(1), restarted.connIndex)
+	restarted.connect()
+
+	// Once the window is over, they back off as usual
+	sim.clock.Advance(time.Minute)
+	restarted.exit(errors.New("connection lost"))
+	sim.clock.waitForTimers(t, 1)
+	sim.clock.Advance(preparedRetryDuration)
+	sim.server.assertNoCall(t)
+	sim.advanceBackoff(t, 1)
+	assert.Equal(t, uint8(1), sim.server.nextCall(t).connIndex)
+
+	sim.cancel()
+	assert.NoError(t, sim.waitForExit(t))
+}
+
+func TestSupervisorPrepareShortensBackoff(t *testing.T) {
+	sim := newSimulation(t, 2, func(config *TunnelConfig) {
+		config.ReconnectPreparer = NewReconnectPreparer()
+	})
+	calls := sim.connectAll(t)
+
+	calls[1].exit(errors.New("connection lost"))
+	sim.clock.waitForTimers(t, 1)
+	sim.supervisor.preparer.prepare(time.Minute)
+
+	// The backoff the connection was waiting on is replaced by a short one
+	sim.clock.waitForTimers(t, 2)
+	sim.clock.Advance(preparedRetryDuration)
+	assert.Equal(t, uint8(1), sim.server.nextCall(t).connIndex)
+
+	sim.cancel()
+	assert.NoError(t, sim.waitForExit(t))
+}
+
+func TestReconnectPreparerPrepare(t *testing.T) {
+	clock := newFakeClock()
+	preparer := NewReconnectPreparer()
+	preparer.now = clock.Now
+	probes := []ReconnectProbe{{EdgeIP: "198.41.200.1", Protocol: "quic", Reachable: true, LatencyMS: 12}}
+	preparer.attach(func(context.Context) ([]ReconnectProbe, error) {
+		return probes, nil
+	})
+
+	prepared, err := preparer.PrepareReconnect(t.Context(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, clock.Now().Add(defaultPrepareReconnectWindow), prepared.Until)
+	assert.Equal(t, probes, prepared.Probes)
+	assert.True(t, preparer.preparing())
+
+	clock.Advance(defaultPrepareReconnectWindow)
+	assert.False(t, preparer.preparing())
+
+	// Probing failures, e.g. when the edge can't be resolved, don't prepare the tunnel
+	preparer.attach(func(context.Context) ([]ReconnectProbe, error) {
+		return nil, errors.New("failed to resolve edge addresses")
+	})
+	_, err = preparer.PrepareReconnect(t.Context(), time.Minute)
+	assert.Error(t, err)
+	assert.False(t, preparer.preparing())
+}
+
+func TestReconnectPreparerNotStarted(t *testing.T) {
+	var nilPreparer *ReconnectPreparer
+	assert.False(t, nilPreparer.preparing())
+	_, err := nilPreparer.PrepareReconnect(t.Context(), time.Minute)
+	assert.ErrorIs(t, err, errTunnelNotStarted)
+
+	preparer := NewReconnectPreparer()
+	_, err = preparer.PrepareReconnectJSON(t.Context(), time.Minute)
+	assert.ErrorIs(t, err, errTunnelNotStarted)
+	assert.False(t, preparer.preparing())
+}
+
+func TestSupervisorScheduleWaitsForMaintenanceWindow(t *testing.T) {
+	// A single window, opening in 12 hours
+	opens := time.Now().Add(12 * time.Hour)
+	window, err := connection.NewScheduleWindow(fmt.Sprintf("%d %d * * *", opens.Minute(), opens.Hour()), time.Minute)
+	require.NoError(t, err)
+	windows := maintenance.New([]connection.ScheduleWindow{window})
+	scheduler := &fakeProtocolScheduler{}
+	sim := newSimulation(t, 1, func(config *TunnelConfig) {
+		scheduler.ProtocolSelector = config.ProtocolSelector
+		config.ProtocolSelector = scheduler
+		config.Maintenance = windows
+	})
+	sim.server.nextCall(t).connect()
+
+	// The schedule forces http2, but the connections can't reconnect outside of the maintenance window
+	sim.clock.waitForTimers(t, 1)
+	http2 := connection.HTTP2
+	scheduler.scheduled = &http2
+	sim.clock.Advance(protocolScheduleInterval)
+	sim.clock.waitForTimers(t, 1)
+	sim.clock.Advance(protocolScheduleInterval)
+	sim.clock.waitForTimers(t, 1)
+	assert.Empty(t, sim.supervisor.reconnectCh)
+
+	// Until the windows are overridden
+	_, err = windows.Override(time.Minute)
+	require.NoError(t, err)
+	select {
+	case <-sim.supervisor.reconnectCh:
+	case <-time.After(simulationTimeout):
+		require.FailNow(t, "connection did not reconnect")
+	}
+
+	sim.cancel()
+	assert.NoError(t, sim.waitForExit(t))
+}
+
+func TestSupervisorShutdownDuringBackoff(t *testing.T) {
+	sim := newSimulation(t, 2)
+	calls := sim.connectAll(t)
+
+	calls[1].exit(errors.New("connection lost"))
+	sim.clock.waitForTimers(t, 1)
+
+	close(sim.gracefulShutdownC)
+	sim.server.assertNoCall(t)
+	sim.advanceBackoff(t, 1)
+	sim.server.assertNoCall(t)
+
+	calls[0].exit(nil)
+	assert.NoError(t, sim.waitForExit(t))
+}
+
+func TestSupervisorShutdownWithAllConnectionsInBackoff(t *testing.T) {
+	sim := newSimulation(t, 1)
+	calls := sim.connectAll(t)
+
+	calls[0].exit(errors.New("connection lost"))
+	sim.clock.waitForTimers(t, 1)
+
+	close(sim.gracefulShutdownC)
+	assert.NoError(t, sim.waitForExit(t))
+	sim.server.assertNoCall(t)
+}
+
+func TestHAConnectionProtocol(t *testing.T) {
+	log := zerolog.Nop()
+	fetcher := dynamicMockFetcher{}
+	hybrid, err := connection.NewProtocolSelector(connection.HybridFlag, "", false, false, fetcher.fetch(), time.Hour, &log)
+	require.NoError(t, err)
+	quicOnly, err := connection.NewProtocolSelector("quic", "", false, false, fetcher.fetch(), time.Hour, &log)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name      string
+		selector  connection.ProtocolSelector
+		first     *protocolFallback
+		protocol1 connection.Protocol
+		protocol2 connection.Protocol
+	}{
+		{
+			name:      "connections follow the first one",
+			selector:  quicOnly,
+			first:     &protocolFallback{protocol: connection.QUIC},
+			protocol1: connection.QUIC,
+			protocol2: connection.QUIC,
+		},
+		{
+			name:      "hybrid connections alternate protocols",
+			selector:  hybrid,
+			first:     &protocolFallback{protocol: connection.QUIC},
+			protocol1: connection.HTTP2,
+			protocol2: connection.QUIC,
+		},
+		{
+			name:      "hybrid connections follow the first one in fallback",
+			selector:  hybrid,
+			first:     &protocolFallback{protocol: connection.HTTP2, inFallback: true},
+			protocol1: connection.HTTP2,
+			protocol2: connection.HTTP2,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := &Supervisor{
+				config:                  &TunnelConfig{ProtocolSelector: test.selector},
+				tunnelsProtocolFallback: map[int]*protocolFallback{0: test.first},
+			}
+			assert.Equal(t, test.protocol1, s.haConnectionProtocol(1))
+			assert.Equal(t, test.protocol2, s.haConnectionProtocol(2))
+		})
+	}
+}
+
+func TestWithHooks(t *testing.T) {
+	var output bytes.Buffer
+	log := zerolog.New(&output)
+	require.Same(t, &log, withHooks(&log, nil))
+
+	var errorsLogged int
+	hooked := withHooks(&log, []zerolog.Hook{
+		zerolog.HookFunc(func(e *zerolog.Event, _ zerolog.Level, _ string) {
+			e.Str("correlationID", "abc")
+		}),
+		zerolog.HookFunc(func(_ *zerolog.Event, level zerolog.Level, _ string) {
+			if level == zerolog.ErrorLevel {
+				errorsLogged++
+			}
+		}),
+	})
+	// Loggers derived from the hooked logger keep its hooks
+	derived := hooked.With().Uint8(connection.LogFieldConnIndex, 1).Logger()
+	derived.Error().Msg("Connection failed")
+	require.Contains(t, output.String(), `"correlationID":"abc"`)
+	require.Equal(t, 1, errorsLogged)
+
+	// The original logger is left unchanged
+	output.Reset()
+	log.Error().Msg("Connection failed")
+	require.NotContains(t, output.String(), "correlationID")
+	require.Equal(t, 1, errorsLogged)
+}
+
+func TestNewDatagramMetricsPerRegisterer(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	metrics := newDatagramMetrics(registry)
+	// Supervisors sharing a registry share its metrics instead of registering them twice
+	assert.Same(t, metrics, newDatagramMetrics(registry))
+	assert.NotSame(t, metrics, newDatagramMetrics(prometheus.NewRegistry()))
+	assert.Same(t, newDatagramMetrics(nil), newDatagramMetrics(prometheus.DefaultRegisterer))
+
+	metrics.IncrementFlows(0)
+	families, err := registry.Gather()
+	require.NoError(t, err)
+	assert.NotEmpty(t, families)
+}
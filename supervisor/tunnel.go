@@ -9,32 +9,41 @@ import (
 	"net"
 	"net/netip"
 	"runtime/debug"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/getsentry/sentry-go"
 	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/quic-go/quic-go"
 	"github.com/rs/zerolog"
+	"golang.org/x/net/proxy"
 	"golang.org/x/sync/errgroup"
 
 	"github.com/cloudflare/cloudflared/client"
 	"github.com/cloudflare/cloudflared/connection"
 	"github.com/cloudflare/cloudflared/edgediscovery"
 	"github.com/cloudflare/cloudflared/edgediscovery/allregions"
+	"github.com/cloudflare/cloudflared/edgediscovery/pac"
+	"github.com/cloudflare/cloudflared/errorreport"
+	"github.com/cloudflare/cloudflared/faultinject"
 	"github.com/cloudflare/cloudflared/features"
 	"github.com/cloudflare/cloudflared/fips"
 	"github.com/cloudflare/cloudflared/ingress"
 	"github.com/cloudflare/cloudflared/ingress/origins"
+	"github.com/cloudflare/cloudflared/maintenance"
 	"github.com/cloudflare/cloudflared/management"
 	"github.com/cloudflare/cloudflared/orchestration"
 	quicpogs "github.com/cloudflare/cloudflared/quic"
 	v3 "github.com/cloudflare/cloudflared/quic/v3"
 	"github.com/cloudflare/cloudflared/retry"
 	"github.com/cloudflare/cloudflared/signal"
+	"github.com/cloudflare/cloudflared/tunnelrpc"
 	"github.com/cloudflare/cloudflared/tunnelrpc/pogs"
 	"github.com/cloudflare/cloudflared/tunnelstate"
+	"github.com/cloudflare/cloudflared/usage"
 )
 
 const (
@@ -51,12 +60,19 @@ type TunnelConfig struct {
 	CloseConnOnce *sync.Once     // 确保连接信号只关闭一次的同步原语
 
 	// 边缘网络配置
-	EdgeAddrs     []string                   // 边缘节点地址列表
-	Region        string                     // 指定的区域
-	EdgeIPVersion allregions.ConfigIPVersion // IP版本配置（IPv4/IPv6）
-	EdgeBindAddr  net.IP                     // 本地绑定的IP地址
-	EdgeProxyURL  string                     // SOCKS5 代理 URL（可选），格式: socks5://[user:pass@]host:port，失败时自动降级到直连
-	HAConnections int                        // 高可用连接数量
+	EdgeAddrs               []string                      // 边缘节点地址列表
+	Region                  string                        // 指定的区域
+	EdgeIPVersion           allregions.ConfigIPVersion    // IP版本配置（IPv4/IPv6）
+	EdgeRotation            allregions.RotationPolicy     // 连接失败轮换IP时优先选择的区域（均衡/同区域/另一区域）
+	EdgeHosts               *allregions.EdgeHosts         // 边缘 SRV 和主机名查询的静态应答（可选），用于没有公共 DNS 的测试环境
+	EdgeBindAddr            net.IP                        // 本地绑定的IP地址
+	EdgeProxyURL            string                        // 代理 URL（可选），格式: socks5://[user:pass@]host:port、http(s)://[user:pass@]host:port 或 ssh://user@host:port
+	EdgeProxyAuth           *proxy.Auth                   // 代理认证信息（可选），优先于代理 URL 中的用户信息
+	EdgeProxyTLS            edgediscovery.ProxyTLSConfigs // HTTPS 代理的 TLS 配置（可选），按代理地址区分，例如出示给代理的客户端证书
+	EdgeProxySSH            *edgediscovery.SSHJumpHost    // ssh:// 代理的 SSH 跳板机（可选），提供私钥认证和主机密钥校验
+	EdgeProxyPAC            *pac.Selector                 // 按 PAC 文件为每个边缘地址选择代理（可选），与 EdgeProxyURL 互斥
+	EdgeProxyDirectFallback bool                          // 代理失败时是否降级到直连
+	HAConnections           int                           // 高可用连接数量
 
 	// 运行状态配置
 	IsAutoupdated   bool       // 是否启用自动更新
@@ -67,10 +83,15 @@ type TunnelConfig struct {
 	// 日志配置
 	Log          *zerolog.Logger // 通用日志记录器
 	LogTransport *zerolog.Logger // 传输层日志记录器
+	// 嵌入方注册的日志钩子，Supervisor 创建时分别添加到通用和传输层日志记录器，
+	// 可借此为日志添加关联ID、发送到自定义目标或统计错误数
+	LogHooks          []zerolog.Hook
+	TransportLogHooks []zerolog.Hook
 
 	// 监控和版本
-	Observer        *connection.Observer // 连接观察者，用于监控连接状态
-	ReportedVersion string               // 上报的版本号
+	Observer        *connection.Observer  // 连接观察者，用于监控连接状态
+	Listeners       []connection.Listener // 外部注册的隧道状态监听器，嵌入方和扩展可借此导出连接、配置和协议变化
+	ReportedVersion string                // 上报的版本号
 
 	// 重试配置
 	Retries            uint  // 最大重试次数
@@ -88,15 +109,91 @@ type TunnelConfig struct {
 	ICMPRouterServer    ingress.ICMPRouterServer     // ICMP路由服务器
 	OriginDNSService    *origins.DNSResolverService  // 源站DNS解析服务
 	OriginDialerService *ingress.OriginDialerService // 源站拨号服务
+	Usage               *usage.Accounting            // 按主机名和私有网络统计代理的流量，为nil时不统计
+	// DatagramMetricsRegisterer 注册数据报指标的注册器，为 nil 时使用 prometheus.DefaultRegisterer，使用同一注册器的 Supervisor 共享数据报指标
+	DatagramMetricsRegisterer prometheus.Registerer
 
 	// 超时配置
-	RPCTimeout         time.Duration // RPC调用超时时间
-	WriteStreamTimeout time.Duration // 写流超时时间
+	RPCTimeout           time.Duration                  // RPC调用超时时间
+	RegistrationTimeouts tunnelrpc.RegistrationTimeouts // 注册、注销、推送本地配置等控制流RPC各自的超时时间及重试次数
+	WriteStreamTimeout   time.Duration                  // 写流超时时间
+
+	// 控制流配置
+	// ControlStreamFallbackThreshold QUIC控制流注册连续超时多少次后改为通过HTTP/2控制连接注册，0表示禁用
+	ControlStreamFallbackThreshold uint
+	// ControlStreamHeartbeat 控制流心跳配置，连续多次心跳失败时主动判定连接已断开并触发重连
+	ControlStreamHeartbeat connection.ControlStreamHeartbeat
+
+	// 故障注入配置，仅用于集成测试和预发环境，nil表示不注入任何故障
+	FaultInjector *faultinject.Injector
+	// ConnectionLeakCheck 连接关闭后等待其goroutine、流和会话释放的时间，超时仍未释放时记录警告，0表示禁用
+	ConnectionLeakCheck time.Duration
+	// UDPSessionResumeGrace 连接断开后UDP会话等待在新连接上恢复的时间，仅适用于datagram v3会话，0表示会话随连接一起关闭
+	UDPSessionResumeGrace time.Duration
+	// EdgeAddrStateFile 记录每个连接注册所在边缘IP的状态文件，重启后优先使用这些IP，为空表示禁用
+	EdgeAddrStateFile string
+	// EdgeAddrStateTTL 状态文件中记录的边缘IP的有效期，超过后不再优先使用
+	EdgeAddrStateTTL time.Duration
+	// EdgeScorecardFile 保存各边缘IP评分（握手成功率、注册耗时、连接存活时间）的文件，为空表示评分只保存在内存中
+	EdgeScorecardFile string
+	// EdgeRegistrationLockDir 与本机其他cloudflared进程共享锁文件的目录，避免它们的连接注册到同一个边缘IP，为空表示只避免本进程内的重复
+	EdgeRegistrationLockDir string
+	// RandomSeed 非0时用作边缘地址选择和重连退避抖动的随机种子，用于复现不稳定的重连行为，0表示使用全局随机源
+	RandomSeed int64
+	// HibernateAfter 隧道没有请求超过该时间后休眠，只保留一个连接，收到请求后立即恢复所有连接，0表示不休眠
+	HibernateAfter time.Duration
+	// HibernateKeepAlive 休眠时保留的QUIC连接的保活周期
+	HibernateKeepAlive time.Duration
+	// OverloadCPU 进程使用的CPU超过GOMAXPROCS的该比例时视为过载，0表示不限制
+	OverloadCPU float64
+	// OverloadMemory 进程占用的内存超过该字节数时视为过载，0表示不限制
+	OverloadMemory uint64
+	// OverloadStreamRate 过载时每秒接受的新流和UDP会话数量，其余的立即拒绝
+	OverloadStreamRate float64
+	// RegistrationRamp 第一个连接注册成功后启动其余HA连接的节奏：固定间隔、指数增长的批次，或等待上一个连接注册成功
+	RegistrationRamp RegistrationRamp
+	// ProtocolFallbackSuccessRate 所有连接以某协议注册的成功率低于该值时才降级协议，0表示连接达到最大重试次数即降级
+	ProtocolFallbackSuccessRate float64
+	// ProtocolFallbackWindow 计算协议注册成功率的滑动窗口
+	ProtocolFallbackWindow time.Duration
+	// WatchdogTimeout 已注册的连接超过该时间没有应答控制流心跳时视为停滞，0表示禁用看门狗
+	WatchdogTimeout time.Duration
+	// WatchdogRestart 看门狗发现连接停滞时重连该连接，否则只记录日志
+	WatchdogRestart bool
+	// Maintenance 限制计划表变化导致的重连只在维护窗口内进行，为 nil 时随时可以重连
+	Maintenance *maintenance.Windows
+	// FirstConnectionRace 首个连接启动时并行拨号的边缘IP数量，保留最先建立的连接，小于2表示禁用
+	FirstConnectionRace int
+	// ReadyTimeout 启动后等待首个连接注册成功的时间，超时后停止重试并返回所有连接尝试的汇总，0表示一直重试
+	ReadyTimeout time.Duration
+	// ConnectionAuditFile 记录每次连接尝试的 JSON lines 文件，与日志级别无关，为空时不记录
+	ConnectionAuditFile string
+	// ConnectionAuditMaxSize 连接尝试记录文件轮转前的大小（MB）
+	ConnectionAuditMaxSize int
+	// ConnectionAuditMaxBackups 保留的轮转后的连接尝试记录文件数量
+	ConnectionAuditMaxBackups int
+	// ReconnectPreparer 计划维护前准备重连，为 nil 时不支持准备重连
+	ReconnectPreparer *ReconnectPreparer
+	// ClockSkewCheckURL 比较本地时钟时读取其 Date 响应头的边缘 URL，为空时不检查
+	ClockSkewCheckURL string
+	// ClockSkewCheckInterval 定期检查时钟偏差的间隔，0表示只在启动时检查
+	ClockSkewCheckInterval time.Duration
+	// ClockSkewThreshold 超过该值的时钟偏差记录为错误
+	ClockSkewThreshold time.Duration
+	// ClockSkewWait 启动时时钟偏差过大时最多推迟注册的时间，0表示不推迟
+	ClockSkewWait time.Duration
 
 	// QUIC 特定配置
-	DisableQUICPathMTUDiscovery         bool   // 是否禁用QUIC路径MTU发现
-	QUICConnectionLevelFlowControlLimit uint64 // QUIC连接级流控限制
-	QUICStreamLevelFlowControlLimit     uint64 // QUIC流级流控限制
+	DisableQUICPathMTUDiscovery         bool          // 是否禁用QUIC路径MTU发现
+	QUICConnectionLevelFlowControlLimit uint64        // QUIC连接级流控限制
+	QUICStreamLevelFlowControlLimit     uint64        // QUIC流级流控限制
+	QUICDSCP                            uint8         // QUIC UDP数据包的DSCP标记，0表示不标记
+	QUICMTUProbe                        bool          // 使用QUIC前是否探测到边缘的MTU
+	QUICAssessmentInterval              time.Duration // 使用HTTP2时在后台评估QUIC是否可用的间隔，0表示不评估
+
+	// HTTP2 特定配置
+	EdgeTCPOptions   edgediscovery.TCPOptions    // 直连边缘的TCP套接字选项（DSCP、Fast Open、keepalive等）
+	HTTP2Compression connection.HTTP2Compression // HTTP2流的压缩算法和质量，质量为0表示不压缩
 }
 
 // connectionOptions 根据源站本地地址和之前的尝试次数创建连接选项快照
@@ -110,6 +207,11 @@ func (c *TunnelConfig) connectionOptions(originLocalAddr string, previousAttempt
 	return c.ClientConfig.ConnectionOptionsSnapshot(originIP, previousAttempts)
 }
 
+// edgeProxied 返回到边缘的 HTTP2 连接是否配置了代理，PAC 文件也可能为某些地址选择直连
+func (c *TunnelConfig) edgeProxied() bool {
+	return c.EdgeProxyURL != "" || c.EdgeProxyPAC != nil
+}
+
 // StartTunnelDaemon 启动隧道守护进程
 // 这是启动整个隧道服务的入口函数，它会创建一个Supervisor并运行它
 // ctx: 上下文，用于控制整个守护进程的生命周期
@@ -233,7 +335,19 @@ type EdgeTunnelServer struct {
 	gracefulShutdownC <-chan struct{}             // 优雅关闭信号通道
 	tracker           *tunnelstate.ConnTracker    // 连接状态追踪器
 
-	connAwareLogger *ConnAwareLogger // 连接感知日志记录器
+	connAwareLogger    *ConnAwareLogger               // 连接感知日志记录器
+	controlPlaneHealth *connection.ControlPlaneHealth // 控制流健康状态，决定何时使用备用控制通道
+	handover           *fallbackHandover              // 协议降级时排空仍使用旧协议的连接
+	race               *connectionRace                // 首个连接启动时并行拨号多个边缘IP，为nil时不竞速
+	attempts           *connectionAttempts            // 最近失败的连接尝试，启动超时时汇总报告
+	audit              *connectionAudit               // 将每次连接尝试记录到文件，为nil时不记录
+	preparer           *ReconnectPreparer             // 计划维护前准备重连，准备期间缩短重连的退避时间
+	mtuProbes          *mtuProbes                     // 使用QUIC前探测到边缘的MTU，为nil时不探测
+	hibernation        *hibernation                   // 记录代理的请求，休眠时拨号的QUIC连接使用更低的保活频率，为nil时不休眠
+	overload           *overload                      // 过载时限制接受新流和UDP会话的速率，为nil时不限制
+	watchdog           *watchdog                      // 检查已注册连接的控制流心跳，为nil时不检查
+	errorBudget        *protocolErrorBudget           // 所有连接各协议的注册成功率，决定何时降级协议，为nil时达到最大重试次数即降级
+	features           *negotiatedFeatures            // 各连接上次注册时协商的特性，重连后特性不同时记录差异
 }
 
 // TunnelServer 隧道服务器接口，定义了服务隧道连接的基本方法
@@ -264,14 +378,41 @@ func (e *EdgeTunnelServer) Serve(ctx context.Context, connIndex uint8, protocolF
 	go func() {
 		// 当连接成功时，通知外部
 		if connectedFuse.Await() {
+			observeConnected(connIndex)
 			connectedSignal.Notify()
 		}
 	}()
 	// 确保如果在连接前返回，上面的goroutine会终止
 	defer connectedFuse.Fuse(false)
 
-	// 获取与连接索引关联的边缘IP地址
-	addr, err := e.edgeAddrs.GetAddr(int(connIndex))
+	// 协议计划表的窗口打开或关闭后，以计划的协议重连
+	if scheduler, ok := e.config.ProtocolSelector.(connection.ProtocolScheduler); ok {
+		previous := protocolFallback.protocol
+		if protocolFallback.applySchedule(scheduler) {
+			e.config.Log.Info().Uint8(connection.LogFieldConnIndex, connIndex).Msgf("Protocol schedule switched the connection to %s", protocolFallback.protocol)
+			e.config.Observer.SendProtocolChange(connIndex, previous, protocolFallback.protocol)
+		}
+	}
+
+	// 其他连接最近以另一个协议注册成功时，以该协议重连，使所有连接收敛到实际可用的协议
+	if hinter, ok := e.config.ProtocolSelector.(connection.ProtocolHinter); ok {
+		previous := protocolFallback.protocol
+		if protocolFallback.applyHint(hinter) {
+			e.config.Log.Info().Uint8(connection.LogFieldConnIndex, connIndex).Msgf("Other connections registered with %s, switching the connection to it", protocolFallback.protocol)
+			e.config.Observer.SendProtocolChange(connIndex, previous, protocolFallback.protocol)
+		}
+	}
+
+	// 获取与连接索引关联的边缘IP地址，首个连接启动时并行拨号多个边缘IP，使用最先建立连接的IP
+	var addr *allregions.EdgeAddr
+	var err error
+	if e.race.start(connIndex) {
+		addr, err = e.raceFirstConn(ctx, connIndex, protocolFallback.protocol)
+		// 确保未被使用的竞速胜出连接被关闭
+		defer e.race.discard(connIndex)
+	} else {
+		addr, err = e.edgeAddrs.GetAddr(int(connIndex))
+	}
 	switch err.(type) {
 	case nil: // 没有错误
 	case edgediscovery.ErrNoAddressesLeft:
@@ -289,9 +430,33 @@ func (e *EdgeTunnelServer) Serve(ctx context.Context, connIndex uint8, protocolF
 		Logger()
 	connLog := e.connAwareLogger.ReplaceLogger(&logger)
 
+	// 记录连接开始时的协议，返回时如果协议发生变化则通知监听器
+	protocol := protocolFallback.protocol
+	defer func() {
+		if protocolFallback.protocol != protocol {
+			e.config.Observer.SendProtocolChange(connIndex, protocol, protocolFallback.protocol)
+		}
+	}()
+
+	// 使用QUIC前探测到边缘的MTU，路径承载不了QUIC数据报时改用降级协议
+	if protocolFallback.protocol == connection.QUIC {
+		size, probed := e.mtuProbes.probeAddr(connIndex, addr.UDP, e.edgeBindAddr, connLog.Logger())
+		fallback, hasFallback := e.protocolSelector(connIndex).Fallback()
+		if probed && size < edgediscovery.MinQUICDatagramSize && hasFallback {
+			connLog.Logger().Warn().Msgf("The path to the edge only carries %d byte datagrams, QUIC needs %d. Switching to fallback protocol %s",
+				size, edgediscovery.MinQUICDatagramSize, fallback)
+			protocolFallback.fallback(fallback)
+		}
+	}
+
+	// 记录连接的重试和降级状态，用于在连接卡在最大退避时告警
+	observeProtocolFallback(connIndex, protocolFallback)
+
 	// 每个连接保持自己的协议副本，因为单个连接可能会在特定的边缘节点
 	// 不支持新协议时降级到另一个协议
 	// 每个连接也可以有自己的IP版本，因为单个连接可能会降级到另一个IP版本
+	attemptStart := time.Now()
+	attemptProtocol := protocolFallback.protocol
 	err, shouldFallbackProtocol := e.serveTunnel(
 		ctx,
 		connLog,
@@ -299,9 +464,34 @@ func (e *EdgeTunnelServer) Serve(ctx context.Context, connIndex uint8, protocolF
 		connIndex,
 		connectedFuse,
 		protocolFallback,
-		protocolFallback.protocol,
+		attemptProtocol,
 	)
 
+	// 记录失败的连接尝试，启动超时时汇总报告
+	e.attempts.record(connIndex, addr, protocol, protocol == connection.HTTP2 && e.config.edgeProxied(), err)
+	// 将这次连接尝试记录到文件，用于事后分析连接情况
+	proxied := attemptProtocol == connection.HTTP2 && e.config.edgeProxied()
+	if auditErr := e.audit.record(attemptStart, connIndex, addr, attemptProtocol, proxied, connectedFuse.Value(), err); auditErr != nil {
+		connLog.Logger().Debug().Err(auditErr).Msg("Failed to record the connection attempt")
+	}
+	// 未能注册的尝试计入协议的错误预算，边缘要求的退避和维护准备期间的失败不是协议的问题
+	var edgeBackoff edgeBackoffError
+	if err != nil && !connectedFuse.Value() && ctx.Err() == nil && !e.preparer.preparing() && !errors.As(err, &edgeBackoff) {
+		e.errorBudget.record(protocol, false)
+	}
+
+	// 混合模式下最后一个QUIC连接断开时，UDP和ICMP流量在QUIC连接恢复前无法服务
+	if e.hybrid() && protocol == connection.QUIC && ctx.Err() == nil && e.tracker.CountActiveConnsWithProtocol(connection.QUIC) == 0 {
+		connLog.Logger().Warn().Msg("No QUIC connection to the edge is left, private network UDP and ICMP traffic can't be served until one reconnects. HTTP traffic is still served over HTTP2 connections.")
+	}
+
+	// 连接已被排空以交接到降级协议，直接以该协议重连，无需退避
+	var handover handoverError
+	if errors.As(err, &handover) {
+		protocolFallback.fallback(handover.protocol)
+		return err
+	}
+
 	// 检查连接错误是否来自主机的IP问题或建立到边缘的连接问题
 	// 如果是，则轮换IP地址
 	shouldRotateEdgeIP, cErr := e.edgeAddrHandler.ShouldGetNewAddress(connIndex, err)
@@ -319,6 +509,12 @@ func (e *EdgeTunnelServer) Serve(ctx context.Context, connIndex uint8, protocolF
 		}
 	}
 
+	// 准备维护期间很快重试且不降级协议，维护结束后连接可以在几秒内恢复
+	if e.preparer.preparing() {
+		protocolFallback.RetryAfter(preparedRetryDuration)
+		shouldFallbackProtocol = false
+	}
+
 	// 设置连接正在重连，并记录下一次重试的退避时间
 	duration, ok := protocolFallback.GetMaxBackoffDuration(ctx)
 	if !ok {
@@ -326,6 +522,8 @@ func (e *EdgeTunnelServer) Serve(ctx context.Context, connIndex uint8, protocolF
 	}
 	e.config.Observer.SendReconnect(connIndex)
 	connLog.Logger().Info().Msgf("Retrying connection in up to %s", duration)
+	backoffTimer := protocolFallback.BackoffTimer()
+	observeBackoff(connIndex, protocolFallback, duration)
 
 	select {
 	case <-ctx.Done():
@@ -334,7 +532,7 @@ func (e *EdgeTunnelServer) Serve(ctx context.Context, connIndex uint8, protocolF
 	case <-e.gracefulShutdownC:
 		// 收到优雅关闭信号
 		return nil
-	case <-protocolFallback.BackoffTimer():
+	case <-backoffTimer:
 		// 退避定时器到期，决定是否需要降级协议
 		// 如果不需要降级协议，直接返回。否则，为下一次方法调用设置新协议
 		if !shouldFallbackProtocol {
@@ -342,7 +540,8 @@ func (e *EdgeTunnelServer) Serve(ctx context.Context, connIndex uint8, protocolF
 		}
 
 		// 如果单个连接已经使用当前协议连接成功，我们知道不需要降级到不同的协议
-		if e.tracker.HasConnectedWith(e.config.ProtocolSelector.Current()) {
+		selector := e.protocolSelector(connIndex)
+		if e.tracker.HasConnectedWith(selector.Current()) {
 			return err
 		}
 
@@ -350,7 +549,8 @@ func (e *EdgeTunnelServer) Serve(ctx context.Context, connIndex uint8, protocolF
 		if !selectNextProtocol(
 			connLog.Logger(),
 			protocolFallback,
-			e.config.ProtocolSelector,
+			selector,
+			e.errorBudget,
 			err,
 		) {
 			return err
@@ -360,12 +560,29 @@ func (e *EdgeTunnelServer) Serve(ctx context.Context, connIndex uint8, protocolF
 	return err
 }
 
+// hybrid 返回是否同时使用所有协议，每个协议服务一部分HA连接
+func (e *EdgeTunnelServer) hybrid() bool {
+	_, ok := e.config.ProtocolSelector.(connection.HybridProtocolSelector)
+	return ok
+}
+
+// protocolSelector 返回连接使用的协议选择器，混合模式下每个连接有自己的协议，否则所有连接共用同一个选择器
+// connIndex: 连接索引
+func (e *EdgeTunnelServer) protocolSelector(connIndex uint8) connection.ProtocolSelector {
+	if hybrid, ok := e.config.ProtocolSelector.(connection.HybridProtocolSelector); ok {
+		return hybrid.ForConnection(connIndex)
+	}
+	return e.config.ProtocolSelector
+}
+
 // protocolFallback 是对backoffHandler的包装，当退避达到最大重试次数时会尝试降级选项
 // 它管理协议选择和退避策略
 type protocolFallback struct {
 	retry.BackoffHandler                     // 退避处理器
 	protocol             connection.Protocol // 当前使用的协议
 	inFallback           bool                // 是否处于降级状态
+	scheduled            bool                // 协议是否由协议计划表指定
+	hinted               bool                // 协议是否由其他连接的协议提示指定
 }
 
 // reset 重置协议降级状态
@@ -383,25 +600,83 @@ func (pf *protocolFallback) fallback(fallback connection.Protocol) {
 	pf.inFallback = true
 }
 
+// applySchedule 使用协议计划表指定的协议，窗口关闭后恢复选择器的当前协议
+// 不在窗口内时保留降级等状态，只在窗口打开或关闭时改变协议
+// scheduler: 协议计划表
+// 返回: 协议是否改变
+func (pf *protocolFallback) applySchedule(scheduler connection.ProtocolScheduler) bool {
+	protocol, scheduled := scheduler.Scheduled()
+	if !scheduled {
+		if !pf.scheduled {
+			return false
+		}
+		protocol = scheduler.Current()
+	}
+	pf.scheduled = scheduled
+	if pf.protocol == protocol {
+		return false
+	}
+	pf.reset()
+	pf.protocol = protocol
+	return true
+}
+
+// applyHint 使用其他连接最近注册成功的协议，提示过期后恢复选择器的当前协议
+// 协议计划表指定的协议优先，已降级的连接也不使用提示，因为该连接自己已经确认了原协议无法使用
+// hinter: 接受连接协议提示的协议选择器
+// 返回: 协议是否改变
+func (pf *protocolFallback) applyHint(hinter connection.ProtocolHinter) bool {
+	if pf.scheduled || pf.inFallback {
+		return false
+	}
+	_, hinted := hinter.Hinted()
+	if !hinted && !pf.hinted {
+		return false
+	}
+	pf.hinted = hinted
+	protocol := hinter.Current()
+	if pf.protocol == protocol {
+		return false
+	}
+	pf.protocol = protocol
+	return true
+}
+
 // selectNextProtocol 为下一次重试迭代选择连接协议
 // 根据错误原因和重试次数决定是否需要切换协议或降级
 // connLog: 日志记录器
 // protocolBackoff: 协议降级处理器
 // selector: 协议选择器
+// budget: 协议的错误预算，为nil时达到最大重试次数即降级
 // cause: 导致重试的错误原因
 // 返回: true表示能够选择协议并继续重试，false表示已无选项应停止重试
 func selectNextProtocol(
 	connLog *zerolog.Logger,
 	protocolBackoff *protocolFallback,
 	selector connection.ProtocolSelector,
+	budget *protocolErrorBudget,
 	cause error,
 ) bool {
 	// 检查QUIC是否损坏（无法正常工作）
 	isQuicBroken := isQuicBroken(cause)
-	_, hasFallback := selector.Fallback()
+	fallback, hasFallback := selector.Fallback()
+
+	// 有可降级的协议时，只有所有连接使用当前协议的成功率低于错误预算才降级
+	// 其他连接使用该协议仍然成功时，问题多半出在这个连接的边缘IP上，重置重试次数继续使用该协议
+	shouldFallback := protocolBackoff.ReachedMaxRetries()
+	if budget != nil && hasFallback && protocolBackoff.protocol != fallback {
+		exhausted, rate := budget.exhausted(protocolBackoff.protocol)
+		if exhausted {
+			connLog.Warn().Msgf("Only %.0f%% of the recent attempts to connect with %s succeeded", rate*100, protocolBackoff.protocol)
+		} else if shouldFallback {
+			connLog.Info().Msgf("Other connections still succeed with %s, retrying it", protocolBackoff.protocol)
+			protocolBackoff.ResetNow()
+		}
+		shouldFallback = exhausted
+	}
 
-	// 如果达到最大重试次数，或者有降级选项且QUIC损坏，则尝试降级
-	if protocolBackoff.ReachedMaxRetries() || (hasFallback && isQuicBroken) {
+	// 如果应当降级，或者有降级选项且QUIC损坏，则尝试降级
+	if shouldFallback || (hasFallback && isQuicBroken) {
 		if isQuicBroken {
 			// 记录QUIC连接问题的警告信息
 			connLog.Warn().Msg("If this log occurs persistently, and cloudflared is unable to connect to " +
@@ -412,8 +687,6 @@ func selectNextProtocol(
 				"unless your cloudflared can connect with Cloudflare Network with `quic`.")
 		}
 
-		// 获取降级协议
-		fallback, hasFallback := selector.Fallback()
 		if !hasFallback {
 			// 没有降级选项，停止重试
 			return false
@@ -456,6 +729,27 @@ func isQuicBroken(cause error) bool {
 	return false
 }
 
+// isEdgeFailure 检查连接错误是否说明该边缘IP表现不佳，用于为边缘IP打分
+// 关闭、重连信号、协议交接、重复注册和本地配置错误都与边缘IP本身无关
+// ctx: 连接的上下文
+// err: 连接返回的错误
+// 返回: true表示应降低该边缘IP的评分
+func isEdgeFailure(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	switch err := err.(type) {
+	case ReconnectSignal, handoverError, connection.DupConnRegisterTunnelError, unrecoverableError:
+		return false
+	case connection.ServerRegisterTunnelError:
+		// 边缘限流注册不代表该IP有问题
+		if err.RateLimited {
+			return false
+		}
+	}
+	return !errors.Is(err, context.Canceled)
+}
+
 // serveTunnel 运行单个隧道连接，在优雅关闭时返回nil
 // 发生错误时返回一个标志，指示错误是否可以重试
 // ctx: 上下文
@@ -512,10 +806,25 @@ func (e *EdgeTunnelServer) serveTunnel(
 			// 服务器端注册隧道错误
 			connLog.ConnAwareLogger().Err(err).Msg("Register tunnel error from server side")
 			// 不要将服务器返回的注册错误发送到Sentry，它们已在服务器端记录
+			if err.RateLimited || (err.RetryAfter > 0 && !err.Permanent) {
+				// 边缘指定了重试等待时间时严格按其等待，而不是使用指数退避
+				// 限流不是协议的问题，不降级协议
+				if err.RetryAfter > 0 {
+					connLog.Logger().Info().Msgf("Edge asked to retry registering in %s", err.RetryAfter)
+					backoff.RetryAfter(err.RetryAfter)
+				}
+				return edgeBackoffError{err.Cause}, false
+			}
 			return err.Cause, !err.Permanent
 		case *connection.EdgeQuicDialError:
 			// 边缘QUIC拨号错误，不可恢复
 			return err, false
+		case handoverError:
+			// 连接已排空，交接到降级协议
+			connLog.Logger().Info().
+				Uint8(connection.LogFieldConnIndex, connIndex).
+				Msgf("Connection drained, reconnecting with %s", err.protocol)
+			return err, true
 		case ReconnectSignal:
 			// 收到重连信号
 			connLog.Logger().Info().
@@ -559,10 +868,65 @@ func (e *EdgeTunnelServer) serveConnection(
 	backoff *protocolFallback,
 	protocol connection.Protocol,
 ) (err error, recoverable bool) {
+	// 注入的故障：模拟边缘返回重复连接注册错误
+	if e.config.FaultInjector.ForceDupConn(connIndex) {
+		connLog.Logger().Warn().Uint8(connection.LogFieldConnIndex, connIndex).Msg("Injecting duplicate connection error")
+		return connection.DupConnRegisterTunnelError{}, false
+	}
+
+	// 统计该连接持有的goroutine、流和会话，连接关闭后检查是否有泄漏
+	resources := connection.NewConnResources(connIndex)
+	defer e.checkConnResources(connLog, resources)
+
+	// 登记该连接，其他连接降级成功后可将其排空并交接到降级协议
+	drain := e.handover.register(connIndex, protocol)
+	defer drain.done()
+
+	// 记录该边缘IP的握手结果、注册耗时和连接存活时间，选择边缘IP时会降低长期表现不佳的IP的优先级
+	connectStart := time.Now()
+	var registeredAt atomic.Int64
+	// 连接结束后其他连接可以使用该边缘IP，看门狗也不再检查该连接
+	defer func() {
+		if registeredAt.Load() != 0 {
+			e.edgeAddrs.Unregistered(int(connIndex))
+			e.watchdog.unwatchConnection(connIndex)
+		}
+	}()
+	defer func() {
+		if !isEdgeFailure(ctx, err) {
+			return
+		}
+		if registered := registeredAt.Load(); registered != 0 {
+			e.edgeAddrs.ConnectionFailed(addr, time.Since(time.Unix(0, registered)))
+		} else {
+			e.edgeAddrs.HandshakeFailed(addr)
+		}
+	}()
+
+	// 本次连接注册时使用的连接选项，注册成功后与该连接上次注册的特性比较
+	var connOptions *client.ConnectionOptionsSnapshot
+
 	// 创建连接熔断器，结合布尔熔断器和协议降级处理器
 	connectedFuse := &connectedFuse{
 		fuse:    fuse,
 		backoff: backoff,
+		fallbackConnected: func() {
+			if drained := e.handover.fallbackConnected(connIndex, protocol); drained > 0 {
+				connLog.Logger().Info().Msgf("Connected with fallback protocol %s, draining %d connections to hand them over", protocol, drained)
+			}
+		},
+		registered: func() {
+			now := time.Now()
+			registeredAt.Store(now.UnixNano())
+			e.edgeAddrs.Registered(int(connIndex), addr, now.Sub(connectStart))
+			e.watchdog.watchConnection(connIndex)
+			e.errorBudget.record(protocol, true)
+			e.features.registered(connIndex, connOptions, connLog.Logger())
+			// 协议计划表指定的协议不代表其他连接可用的协议
+			if hinter, ok := e.config.ProtocolSelector.(connection.ProtocolHinter); ok && !backoff.scheduled {
+				hinter.Hint(protocol, protocolHintTTL)
+			}
+		},
 	}
 	// 创建控制流，用于管理隧道的控制消息
 	controlStream := connection.NewControlStream(
@@ -572,10 +936,14 @@ func (e *EdgeTunnelServer) serveConnection(
 		connIndex,
 		addr.UDP.IP,
 		nil,
-		e.config.RPCTimeout,
-		e.gracefulShutdownC,
+		e.config.RegistrationTimeouts,
+		// 优雅关闭和排空都会注销连接并等待进行中的请求完成
+		drain.shutdownC(e.gracefulShutdownC),
 		e.config.GracePeriod,
 		protocol,
+		e.secondaryControlPlane(connLog, addr, protocol, connIndex, resources),
+		e.controlPlaneHealth,
+		e.watchdog.heartbeat(e.config.ControlStreamHeartbeat),
 	)
 
 	// 根据协议类型选择不同的连接方式
@@ -583,7 +951,7 @@ func (e *EdgeTunnelServer) serveConnection(
 	case connection.QUIC:
 		// 使用QUIC协议
 		// nolint: gosec
-		connOptions := e.config.connectionOptions(addr.UDP.String(), uint8(backoff.Retries()))
+		connOptions = e.config.connectionOptions(addr.UDP.String(), uint8(backoff.Retries()))
 		// nolint: zerologlint
 		connOptions.LogFields(connLog.Logger().Debug().Uint8(connection.LogFieldConnIndex, connIndex)).Msgf("Tunnel connection options")
 		return e.serveQUIC(ctx,
@@ -591,19 +959,32 @@ func (e *EdgeTunnelServer) serveConnection(
 			connLog,
 			connOptions,
 			controlStream,
-			connIndex)
+			connIndex,
+			resources,
+			drain)
 
 	case connection.HTTP2:
 		// 使用HTTP2协议
 		// 首先建立到边缘的TLS连接，支持通过 SOCKS5 代理（失败时自动降级到直连）
-		edgeConn, err := edgediscovery.DialEdgeWithProxy(ctx, dialTimeout, e.config.EdgeTLSConfigs[protocol], addr.TCP, e.edgeBindAddr, e.config.EdgeProxyURL)
+		// 首个连接启动时竞速拨号胜出的连接直接使用，无需重新拨号
+		var edgeConn net.Conn
+		var err error
+		if raced, ok := e.race.take(connIndex); ok {
+			edgeConn, err = raced.tlsConn, raced.err
+		} else {
+			edgeConn, err = e.dialHTTP2(ctx, connLog, addr)
+		}
 		if err != nil {
 			connLog.ConnAwareLogger().Err(err).Msg("Unable to establish connection with Cloudflare edge")
 			return err, true
 		}
 
 		// nolint: gosec
-		connOptions := e.config.connectionOptions(edgeConn.LocalAddr().String(), uint8(backoff.Retries()))
+		connOptions = e.config.connectionOptions(edgeConn.LocalAddr().String(), uint8(backoff.Retries()))
+		if compression := e.config.HTTP2Compression; compression.Enabled() {
+			// 通过连接选项与边缘协商流压缩
+			connOptions = connOptions.WithCompression(compression.Quality, compression.Algorithm.Feature())
+		}
 		// nolint: zerologlint
 		connOptions.LogFields(connLog.Logger().Debug().Uint8(connection.LogFieldConnIndex, connIndex)).Msgf("Tunnel connection options")
 		if err := e.serveHTTP2(
@@ -613,6 +994,8 @@ func (e *EdgeTunnelServer) serveConnection(
 			connOptions,
 			controlStream,
 			connIndex,
+			resources,
+			drain,
 		); err != nil {
 			return err, false
 		}
@@ -624,6 +1007,93 @@ func (e *EdgeTunnelServer) serveConnection(
 	return
 }
 
+// dialHTTP2 建立HTTP2连接使用的到边缘的TLS连接，支持通过代理（失败时自动降级到直连）
+// ctx: 上下文
+// connLog: 连接感知日志记录器
+// addr: 边缘地址
+func (e *EdgeTunnelServer) dialHTTP2(ctx context.Context, connLog *ConnAwareLogger, addr *allregions.EdgeAddr) (net.Conn, error) {
+	return e.dialEdgeTLS(ctx, connLog, e.config.EdgeTLSConfigs[connection.HTTP2], addr)
+}
+
+// dialEdgeTLS 建立到边缘的TLS连接，经由 EdgeProxyURL 或 PAC 文件为该地址选择的代理
+// 配置了 PAC 文件时按 PAC 文件返回的顺序依次尝试各个代理，全部失败且允许降级时直连
+// ctx: 上下文
+// connLog: 连接感知日志记录器
+// tlsConfig: 与边缘握手的TLS配置
+// addr: 边缘地址
+func (e *EdgeTunnelServer) dialEdgeTLS(ctx context.Context, connLog *ConnAwareLogger, tlsConfig *tls.Config, addr *allregions.EdgeAddr) (net.Conn, error) {
+	if e.config.EdgeProxyPAC == nil {
+		return edgediscovery.DialEdgeWithProxy(ctx, dialTimeout, tlsConfig, addr.TCP, e.edgeBindAddr, e.config.EdgeProxyURL, e.config.EdgeProxyAuth, e.config.EdgeProxyTLS, e.config.EdgeProxySSH, e.edgeProxyFault(connLog), e.config.EdgeProxyDirectFallback, e.config.EdgeTCPOptions)
+	}
+
+	proxies, err := e.config.EdgeProxyPAC.ProxiesFor(ctx, addr.TCP)
+	if err != nil {
+		if !e.config.EdgeProxyDirectFallback {
+			return nil, errors.Wrap(err, "failed to select the edge proxy with the PAC file")
+		}
+		connLog.Logger().Warn().Err(err).Msg("Failed to select the edge proxy with the PAC file, connecting directly")
+		proxies = []string{""}
+	}
+	for _, proxyURL := range proxies {
+		var edgeConn net.Conn
+		// 每个代理失败后尝试下一个，由 PAC 文件决定是否直连
+		edgeConn, err = edgediscovery.DialEdgeWithProxy(ctx, dialTimeout, tlsConfig, addr.TCP, e.edgeBindAddr, proxyURL, e.config.EdgeProxyAuth, e.config.EdgeProxyTLS, e.config.EdgeProxySSH, e.edgeProxyFault(connLog), false, e.config.EdgeTCPOptions)
+		if err == nil {
+			return edgeConn, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		if proxyURL != "" {
+			connLog.Logger().Debug().Err(err).Str("proxy", proxyURL).Msg("Edge proxy selected by the PAC file failed")
+		}
+	}
+	if e.config.EdgeProxyDirectFallback && !slices.Contains(proxies, "") {
+		return edgediscovery.DialEdgeWithProxy(ctx, dialTimeout, tlsConfig, addr.TCP, e.edgeBindAddr, "", nil, nil, nil, nil, true, e.config.EdgeTCPOptions)
+	}
+	return nil, err
+}
+
+// secondaryControlPlane 返回当主控制流降级时用于注册的备用控制通道
+// 目前仅QUIC连接支持通过HTTP/2控制连接注册，未启用或不适用时返回nil
+// connLog: 连接感知日志记录器
+// addr: 边缘地址
+// protocol: 主连接使用的协议
+// connIndex: 连接索引
+// resources: 连接持有的资源统计
+// 通过备用控制通道注册后边缘会将流量路由到该HTTP/2连接，因此该连接同样代理到源站的请求
+func (e *EdgeTunnelServer) secondaryControlPlane(
+	connLog *ConnAwareLogger,
+	addr *allregions.EdgeAddr,
+	protocol connection.Protocol,
+	connIndex uint8,
+	resources *connection.ConnResources,
+) connection.ControlPlane {
+	if protocol != connection.QUIC || !e.controlPlaneHealth.Enabled() {
+		return nil
+	}
+	tlsConfig := e.config.EdgeTLSConfigs[connection.HTTP2]
+	if tlsConfig == nil {
+		return nil
+	}
+	return connection.NewHTTP2ControlPlane(func(ctx context.Context) (net.Conn, error) {
+		return e.dialEdgeTLS(ctx, connLog, tlsConfig, addr)
+	}, connection.NewHTTP2DataPlane(e.hibernation.orchestrator(e.overload.orchestrator(e.orchestrator)), e.config.Observer, connIndex, resources, e.config.Log), connLog.Logger())
+}
+
+// edgeProxyFault 返回每次代理拨号前调用的故障注入函数
+// 注入代理拨号失败时代理拨号返回注入的错误，与真实的代理拨号失败一样降级为直连
+// connLog: 连接感知日志记录器
+func (e *EdgeTunnelServer) edgeProxyFault(connLog *ConnAwareLogger) func() error {
+	return func() error {
+		err := e.config.FaultInjector.ProxyDialError()
+		if err != nil {
+			connLog.Logger().Warn().Err(err).Msg("Injecting edge proxy dial failure")
+		}
+		return err
+	}
+}
+
 // unrecoverableError 表示不可恢复的错误
 // 这种错误类型表明连接无法通过重试来恢复
 type unrecoverableError struct {
@@ -635,6 +1105,43 @@ func (r unrecoverableError) Error() string {
 	return r.err.Error()
 }
 
+// edgeBackoffError 表示边缘要求退避后再注册，例如限流或连接过多
+// 连接在返回该错误前已按边缘指定的时间（未指定时按指数退避）等待，supervisor应立即重连
+type edgeBackoffError struct {
+	err error // 底层错误
+}
+
+// Error 实现error接口
+func (e edgeBackoffError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap 返回底层错误
+func (e edgeBackoffError) Unwrap() error {
+	return e.err
+}
+
+// checkConnResources 在后台等待已关闭连接的资源释放，超过ConnectionLeakCheck仍未释放时记录警告
+// connLog: 连接感知日志记录器
+// resources: 连接持有的资源
+func (e *EdgeTunnelServer) checkConnResources(connLog *ConnAwareLogger, resources *connection.ConnResources) {
+	if e.config.ConnectionLeakCheck <= 0 {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), e.config.ConnectionLeakCheck)
+		defer cancel()
+		if residue := resources.WaitForRelease(ctx); !residue.IsZero() {
+			connLog.Logger().Warn().
+				Uint8(connection.LogFieldConnIndex, residue.ConnIndex).
+				Int64("goroutines", residue.Goroutines).
+				Int64("streams", residue.Streams).
+				Int64("sessions", residue.Sessions).
+				Msgf("Closed tunnel connection still holds resources after %s, they may be leaking", e.config.ConnectionLeakCheck)
+		}
+	}()
+}
+
 // serveHTTP2 使用HTTP2协议为连接提供服务
 // ctx: 上下文
 // connLog: 连接感知日志记录器
@@ -642,6 +1149,8 @@ func (r unrecoverableError) Error() string {
 // connOptions: 连接选项快照
 // controlStreamHandler: 控制流处理器
 // connIndex: 连接索引
+// resources: 连接持有的资源统计
+// drain: 连接的排空句柄
 // 返回: 如果发生错误则返回错误信息
 func (e *EdgeTunnelServer) serveHTTP2(
 	ctx context.Context,
@@ -650,6 +1159,8 @@ func (e *EdgeTunnelServer) serveHTTP2(
 	connOptions *client.ConnectionOptionsSnapshot,
 	controlStreamHandler connection.ControlStreamHandler,
 	connIndex uint8,
+	resources *connection.ConnResources,
+	drain *connDrain,
 ) error {
 	// 检查后量子加密模式
 	pqMode := connOptions.FeatureSnapshot.PostQuantum
@@ -662,11 +1173,13 @@ func (e *EdgeTunnelServer) serveHTTP2(
 	// 创建HTTP2连接
 	h2conn := connection.NewHTTP2Connection(
 		tlsServerConn,
-		e.orchestrator,
+		e.hibernation.orchestrator(e.overload.orchestrator(e.orchestrator)),
 		connOptions,
+		e.config.HTTP2Compression,
 		e.config.Observer,
 		connIndex,
 		controlStreamHandler,
+		resources,
 		e.config.Log,
 	)
 
@@ -688,6 +1201,11 @@ func (e *EdgeTunnelServer) serveHTTP2(
 		return err
 	})
 
+	errGroup.Go(func() error {
+		// 连接被排空时等待进行中的请求完成后结束连接
+		return e.waitForHandover(serveCtx, connLog, drain, controlStreamHandler, resources)
+	})
+
 	// 等待所有goroutine完成
 	return errGroup.Wait()
 }
@@ -699,6 +1217,8 @@ func (e *EdgeTunnelServer) serveHTTP2(
 // connOptions: 连接选项快照
 // controlStreamHandler: 控制流处理器
 // connIndex: 连接索引
+// resources: 连接持有的资源统计
+// drain: 连接的排空句柄
 // 返回: err为错误信息，recoverable表示错误是否可恢复
 func (e *EdgeTunnelServer) serveQUIC(
 	ctx context.Context,
@@ -707,55 +1227,16 @@ func (e *EdgeTunnelServer) serveQUIC(
 	connOptions *client.ConnectionOptionsSnapshot,
 	controlStreamHandler connection.ControlStreamHandler,
 	connIndex uint8,
+	resources *connection.ConnResources,
+	drain *connDrain,
 ) (err error, recoverable bool) {
-	// 获取QUIC协议的TLS配置
-	tlsConfig := e.config.EdgeTLSConfigs[connection.QUIC]
-
-	// 根据后量子加密模式和FIPS模式确定曲线偏好
-	pqMode := connOptions.FeatureSnapshot.PostQuantum
-	curvePref, err := curvePreference(pqMode, fips.IsFipsEnabled(), tlsConfig.CurvePreferences)
-	if err != nil {
-		connLogger.ConnAwareLogger().Err(err).Msgf("failed to get curve preferences")
-		return err, true
-	}
-
-	connLogger.Logger().Info().Msgf("Tunnel connection curve preferences: %v", curvePref)
-
-	tlsConfig.CurvePreferences = curvePref
-
-	// quic-go 0.44将初始包大小默认增加到1280，这会导致通过WARP运行隧道的问题
-	// 因为WARP的MTU是1280
-	var initialPacketSize uint16 = 1252
-	if edgeAddr.Addr().Is4() {
-		// IPv4地址使用更小的包大小
-		initialPacketSize = 1232
-	}
-
-	// 创建QUIC配置
-	quicConfig := &quic.Config{
-		HandshakeIdleTimeout:       quicpogs.HandshakeIdleTimeout,                            // 握手空闲超时
-		MaxIdleTimeout:             quicpogs.MaxIdleTimeout,                                  // 最大空闲超时
-		KeepAlivePeriod:            quicpogs.MaxIdlePingPeriod,                               // 保活周期
-		MaxIncomingStreams:         quicpogs.MaxIncomingStreams,                              // 最大入站流数量
-		MaxIncomingUniStreams:      quicpogs.MaxIncomingStreams,                              // 最大入站单向流数量
-		EnableDatagrams:            true,                                                     // 启用数据报
-		Tracer:                     quicpogs.NewClientTracer(connLogger.Logger(), connIndex), // 跟踪器
-		DisablePathMTUDiscovery:    e.config.DisableQUICPathMTUDiscovery,                     // 是否禁用路径MTU发现
-		MaxConnectionReceiveWindow: e.config.QUICConnectionLevelFlowControlLimit,             // 连接级接收窗口
-		MaxStreamReceiveWindow:     e.config.QUICStreamLevelFlowControlLimit,                 // 流级接收窗口
-		InitialPacketSize:          initialPacketSize,                                        // 初始包大小
+	// 首个连接启动时竞速拨号胜出的连接直接使用，无需重新拨号
+	var conn quic.Connection
+	if raced, ok := e.race.take(connIndex); ok {
+		conn, err = raced.quicConn, raced.err
+	} else {
+		conn, err = e.dialQUIC(ctx, edgeAddr, connLogger, connOptions.FeatureSnapshot.PostQuantum, connIndex)
 	}
-
-	// 拨号建立到边缘的QUIC连接
-	conn, err := connection.DialQuic(
-		ctx,
-		quicConfig,
-		tlsConfig,
-		edgeAddr,
-		e.edgeBindAddr,
-		connIndex,
-		connLogger.Logger(),
-	)
 	if err != nil {
 		connLogger.ConnAwareLogger().Err(err).Msgf("Failed to dial a quic connection")
 
@@ -764,6 +1245,9 @@ func (e *EdgeTunnelServer) serveQUIC(
 		return err, true
 	}
 
+	// 按流量类别统计连接承载的字节、流和数据报
+	traffic := e.config.Observer.ConnTraffic(connIndex)
+
 	// 根据数据报版本创建相应的会话管理器
 	var datagramSessionManager connection.DatagramSessionHandler
 	if connOptions.FeatureSnapshot.DatagramVersion == features.DatagramV3 {
@@ -771,10 +1255,12 @@ func (e *EdgeTunnelServer) serveQUIC(
 		datagramSessionManager = connection.NewDatagramV3Connection(
 			ctx,
 			conn,
-			e.sessionManager,
-			e.config.ICMPRouterServer,
+			e.hibernation.sessionManager(e.sessionManager),
+			e.hibernation.icmpRouter(e.config.Usage.ICMPRouter(e.config.ICMPRouterServer)),
 			connIndex,
 			e.datagramMetrics,
+			traffic,
+			resources,
 			connLogger.Logger(),
 		)
 	} else {
@@ -782,12 +1268,14 @@ func (e *EdgeTunnelServer) serveQUIC(
 		datagramSessionManager = connection.NewDatagramV2Connection(
 			ctx,
 			conn,
-			e.config.OriginDialerService,
-			e.config.ICMPRouterServer,
+			e.hibernation.udpDialer(e.config.Usage.UDPDialer(e.config.OriginDialerService)),
+			e.hibernation.icmpRouter(e.config.Usage.ICMPRouter(e.config.ICMPRouterServer)),
 			connIndex,
 			e.config.RPCTimeout,
 			e.config.WriteStreamTimeout,
-			e.orchestrator.GetFlowLimiter(),
+			e.overload.flowLimiter(e.orchestrator.GetFlowLimiter()),
+			traffic,
+			resources,
 			connLogger.Logger(),
 		)
 	}
@@ -797,13 +1285,15 @@ func (e *EdgeTunnelServer) serveQUIC(
 		ctx,
 		conn,
 		connIndex,
-		e.orchestrator,
+		e.hibernation.orchestrator(e.overload.orchestrator(e.orchestrator)),
 		datagramSessionManager,
 		controlStreamHandler,
 		connOptions,
 		e.config.RPCTimeout,
 		e.config.WriteStreamTimeout,
 		e.config.GracePeriod,
+		traffic,
+		resources,
 		connLogger.Logger(),
 	)
 
@@ -829,10 +1319,129 @@ func (e *EdgeTunnelServer) serveQUIC(
 		return err
 	})
 
+	errGroup.Go(func() error {
+		// 连接被排空时等待进行中的请求完成后结束连接
+		return e.waitForHandover(serveCtx, connLogger, drain, controlStreamHandler, resources)
+	})
+
 	// 等待所有goroutine完成
 	return errGroup.Wait(), false
 }
 
+// dialQUIC 拨号建立到边缘的QUIC连接
+// ctx: 上下文
+// edgeAddr: 边缘地址（IP:端口）
+// connLogger: 连接感知日志记录器
+// pqMode: 后量子加密模式
+// connIndex: 连接索引
+func (e *EdgeTunnelServer) dialQUIC(
+	ctx context.Context,
+	edgeAddr netip.AddrPort,
+	connLogger *ConnAwareLogger,
+	pqMode features.PostQuantumMode,
+	connIndex uint8,
+) (quic.Connection, error) {
+	// 复制QUIC协议的TLS配置，竞速拨号时多个连接会同时设置曲线偏好
+	tlsConfig := e.config.EdgeTLSConfigs[connection.QUIC].Clone()
+
+	// 根据后量子加密模式和FIPS模式确定曲线偏好
+	curvePref, err := curvePreference(pqMode, fips.IsFipsEnabled(), tlsConfig.CurvePreferences)
+	if err != nil {
+		connLogger.ConnAwareLogger().Err(err).Msgf("failed to get curve preferences")
+		return nil, err
+	}
+
+	connLogger.Logger().Info().Msgf("Tunnel connection curve preferences: %v", curvePref)
+
+	tlsConfig.CurvePreferences = curvePref
+
+	// quic-go 0.44将初始包大小默认增加到1280，这会导致通过WARP运行隧道的问题
+	// 因为WARP的MTU是1280
+	var initialPacketSize uint16 = 1252
+	if edgeAddr.Addr().Is4() {
+		// IPv4地址使用更小的包大小
+		initialPacketSize = 1232
+	}
+	// 探测到的MTU更小时使用更小的包，并且不再探测更大的包
+	disablePathMTUDiscovery := e.config.DisableQUICPathMTUDiscovery
+	if size, ok := e.mtuProbes.size(edgeAddr.Addr()); ok && size < int(initialPacketSize) && size >= edgediscovery.MinQUICDatagramSize {
+		initialPacketSize = uint16(size)
+		disablePathMTUDiscovery = true
+	}
+
+	// 休眠时只保留一个连接，降低其保活频率
+	keepAlivePeriod, maxIdleTimeout := e.hibernation.quicKeepAlive(quicpogs.MaxIdlePingPeriod, quicpogs.MaxIdleTimeout)
+
+	// 创建QUIC配置
+	quicConfig := &quic.Config{
+		HandshakeIdleTimeout:       quicpogs.HandshakeIdleTimeout,                            // 握手空闲超时
+		MaxIdleTimeout:             maxIdleTimeout,                                           // 最大空闲超时
+		KeepAlivePeriod:            keepAlivePeriod,                                          // 保活周期
+		MaxIncomingStreams:         quicpogs.MaxIncomingStreams,                              // 最大入站流数量
+		MaxIncomingUniStreams:      quicpogs.MaxIncomingStreams,                              // 最大入站单向流数量
+		EnableDatagrams:            true,                                                     // 启用数据报
+		Tracer:                     quicpogs.NewClientTracer(connLogger.Logger(), connIndex), // 跟踪器
+		DisablePathMTUDiscovery:    disablePathMTUDiscovery,                                  // 是否禁用路径MTU发现
+		MaxConnectionReceiveWindow: e.config.QUICConnectionLevelFlowControlLimit,             // 连接级接收窗口
+		MaxStreamReceiveWindow:     e.config.QUICStreamLevelFlowControlLimit,                 // 流级接收窗口
+		InitialPacketSize:          initialPacketSize,                                        // 初始包大小
+	}
+
+	// 跟踪连接发现的MTU，数据报复用器据此限制数据报负载大小，避免超出MTU的数据报发送失败
+	ctx = quicpogs.ContextWithMTUTracker(ctx, quicpogs.NewMTUTracker(initialPacketSize))
+
+	return connection.DialQuic(
+		ctx,
+		quicConfig,
+		tlsConfig,
+		edgeAddr,
+		e.edgeBindAddr,
+		connIndex,
+		e.config.QUICDSCP,
+		e.config.FaultInjector,
+		connLogger.Logger(),
+	)
+}
+
+// waitForHandover 等待连接被排空。排空时控制流会注销连接，边缘不再向其发送新的流，
+// 注销完成后在宽限期内等待进行中的流结束，然后返回handoverError结束连接
+// ctx: 连接服务上下文
+// connLog: 连接感知日志记录器
+// drain: 连接的排空句柄
+// controlStream: 连接的控制流
+// resources: 连接持有的资源统计
+func (e *EdgeTunnelServer) waitForHandover(
+	ctx context.Context,
+	connLog *ConnAwareLogger,
+	drain *connDrain,
+	controlStream connection.ControlStreamHandler,
+	resources *connection.ConnResources,
+) error {
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-e.gracefulShutdownC:
+		// 优雅关闭时连接自行结束
+		return nil
+	case <-drain.drainC:
+	}
+	connLog.Logger().Info().
+		Uint8(connection.LogFieldConnIndex, drain.connIndex).
+		Msgf("Draining connection to hand it over to %s", drain.target)
+	drainCtx, cancel := context.WithTimeout(ctx, e.config.GracePeriod)
+	defer cancel()
+	select {
+	case <-drainCtx.Done():
+	case <-controlStream.Unregistered():
+	}
+	if !resources.WaitForStreams(drainCtx) && ctx.Err() == nil {
+		connLog.Logger().Warn().
+			Uint8(connection.LogFieldConnIndex, drain.connIndex).
+			Msgf("Connection still has requests in flight after %s, closing it", e.config.GracePeriod)
+	}
+	return handoverError{protocol: drain.target}
+}
+
 // reportErrorToSentry 是一个辅助函数，用于处理和验证错误是否应该报告到Sentry
 // 只有在特定条件下（FIPS启用、后量子严格模式、加密错误）才会报告
 // err: 要检查的错误
@@ -848,7 +1457,7 @@ func (e *EdgeTunnelServer) reportErrorToSentry(err error, pqMode features.PostQu
 			pqMode == features.PostQuantumStrict {
 			// 仅在使用FIPS、后量子严格模式且错误是由EdgeQuicDialError报告的加密错误时
 			// 才报告到Sentry
-			sentry.CaptureException(err)
+			errorreport.Capture(err)
 		}
 	}
 }
@@ -876,14 +1485,22 @@ func listenReconnect(ctx context.Context, reconnectCh <-chan ReconnectSignal, gr
 // connectedFuse 连接熔断器，结合布尔熔断器和协议降级处理器
 // 用于跟踪连接状态并在连接成功时重置退避策略
 type connectedFuse struct {
-	fuse    *booleanFuse      // 布尔熔断器，跟踪连接是否成功
-	backoff *protocolFallback // 协议降级处理器
+	fuse              *booleanFuse      // 布尔熔断器，跟踪连接是否成功
+	backoff           *protocolFallback // 协议降级处理器
+	fallbackConnected func()            // 以降级协议连接成功时调用，用于交接其他连接
+	registered        func()            // 连接注册成功时调用，用于记住注册所在的边缘IP
 }
 
 // Connected 标记连接已成功建立
-// 触发熔断器并重置退避策略
+// 触发熔断器并记住注册所在的边缘IP，然后重置退避策略，如果是以降级协议连接成功则交接其他仍使用旧协议的连接
 func (cf *connectedFuse) Connected() {
 	cf.fuse.Fuse(true)
+	if cf.registered != nil {
+		cf.registered()
+	}
+	if cf.backoff.inFallback && cf.fallbackConnected != nil {
+		cf.fallbackConnected()
+	}
 	cf.backoff.reset()
 }
 
@@ -4,6 +4,8 @@ import (
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
 	"github.com/quic-go/quic-go"
 	"github.com/rs/zerolog"
 	"github.com/stretchr/testify/assert"
@@ -57,19 +59,21 @@ func TestWaitForBackoffFallback(t *testing.T) {
 		backoff,
 		initProtocol,
 		false,
+		false,
+		false,
 	}
 
 	// Retry #0 and #1. At retry #2, we switch protocol, so the fallback loop has one more retry than this
 	for i := 0; i < int(maxRetries-1); i++ {
 		protoFallback.BackoffTimer() // simulate retry
-		ok := selectNextProtocol(&log, protoFallback, protocolSelector, nil)
+		ok := selectNextProtocol(&log, protoFallback, protocolSelector, nil, nil)
 		assert.True(t, ok)
 		assert.Equal(t, initProtocol, protoFallback.protocol)
 	}
 
 	// Retry fallback protocol
 	protoFallback.BackoffTimer() // simulate retry
-	ok := selectNextProtocol(&log, protoFallback, protocolSelector, nil)
+	ok := selectNextProtocol(&log, protoFallback, protocolSelector, nil, nil)
 	assert.True(t, ok)
 	fallback, ok := protocolSelector.Fallback()
 	assert.True(t, ok)
@@ -84,18 +88,18 @@ func TestWaitForBackoffFallback(t *testing.T) {
 		protoFallback.BackoffTimer()
 	}
 	// No protocol to fallback, return error
-	ok = selectNextProtocol(&log, protoFallback, protocolSelector, nil)
+	ok = selectNextProtocol(&log, protoFallback, protocolSelector, nil, nil)
 	assert.False(t, ok)
 
 	protoFallback.reset()
 	protoFallback.BackoffTimer() // simulate retry
-	ok = selectNextProtocol(&log, protoFallback, protocolSelector, nil)
+	ok = selectNextProtocol(&log, protoFallback, protocolSelector, nil, nil)
 	assert.True(t, ok)
 	assert.Equal(t, initProtocol, protoFallback.protocol)
 
 	protoFallback.reset()
 	protoFallback.BackoffTimer() // simulate retry
-	ok = selectNextProtocol(&log, protoFallback, protocolSelector, &quic.IdleTimeoutError{})
+	ok = selectNextProtocol(&log, protoFallback, protocolSelector, nil, &quic.IdleTimeoutError{})
 	// Check that we get a true after the first try itself when this flag is true. This allows us to immediately
 	// switch protocols when there is a fallback.
 	assert.True(t, ok)
@@ -112,15 +116,171 @@ func TestWaitForBackoffFallback(t *testing.T) {
 		&log,
 	)
 	assert.NoError(t, err)
-	protoFallback = &protocolFallback{backoff, protocolSelector.Current(), false}
+	protoFallback = &protocolFallback{backoff, protocolSelector.Current(), false, false, false}
 	for i := 0; i < int(maxRetries-1); i++ {
 		protoFallback.BackoffTimer() // simulate retry
-		ok := selectNextProtocol(&log, protoFallback, protocolSelector, &quic.IdleTimeoutError{})
+		ok := selectNextProtocol(&log, protoFallback, protocolSelector, nil, &quic.IdleTimeoutError{})
 		assert.True(t, ok)
 		assert.Equal(t, connection.QUIC, protoFallback.protocol)
 	}
 	// And finally it fails as it should, with no fallback.
 	protoFallback.BackoffTimer()
-	ok = selectNextProtocol(&log, protoFallback, protocolSelector, &quic.IdleTimeoutError{})
+	ok = selectNextProtocol(&log, protoFallback, protocolSelector, nil, &quic.IdleTimeoutError{})
 	assert.False(t, ok)
 }
+
+func TestSelectNextProtocolErrorBudget(t *testing.T) {
+	log := zerolog.Nop()
+	mockFetcher := dynamicMockFetcher{
+		protocolPercents: edgediscovery.ProtocolPercents{edgediscovery.ProtocolPercent{Protocol: "quic", Percentage: 100}},
+	}
+	protocolSelector, err := connection.NewProtocolSelector("auto", "", false, false, mockFetcher.fetch(), time.Hour, &log)
+	assert.NoError(t, err)
+	budget := newProtocolErrorBudget(0.5, time.Minute)
+	maxRetries := uint(3)
+	backoff := retry.NewBackoff(maxRetries, 40*time.Millisecond, false)
+	backoff.Clock.After = immediateTimeAfter
+	protoFallback := &protocolFallback{backoff, connection.QUIC, false, false, false}
+
+	// The other connections register with QUIC, so the connection keeps retrying it past its max retries
+	for i := 0; i < errorBudgetMinAttempts; i++ {
+		budget.record(connection.QUIC, true)
+	}
+	for i := 0; i < int(maxRetries); i++ {
+		protoFallback.BackoffTimer()
+		budget.record(connection.QUIC, false)
+	}
+	assert.True(t, selectNextProtocol(&log, protoFallback, protocolSelector, budget, nil))
+	assert.Equal(t, connection.QUIC, protoFallback.protocol)
+	assert.Equal(t, int(maxRetries), protoFallback.RetriesLeft())
+
+	// Once most attempts with QUIC fail, the connection falls back even before its max retries
+	for i := 0; i < errorBudgetMinAttempts; i++ {
+		budget.record(connection.QUIC, false)
+	}
+	protoFallback.BackoffTimer()
+	assert.True(t, selectNextProtocol(&log, protoFallback, protocolSelector, budget, nil))
+	assert.Equal(t, connection.HTTP2, protoFallback.protocol)
+	assert.True(t, protoFallback.inFallback)
+
+	// Without a protocol to fall back to, the connection stops retrying once it reached its max retries
+	for i := 0; i < int(maxRetries); i++ {
+		protoFallback.BackoffTimer()
+	}
+	assert.False(t, selectNextProtocol(&log, protoFallback, protocolSelector, budget, nil))
+}
+
+type fakeProtocolScheduler struct {
+	connection.ProtocolSelector
+	scheduled *connection.Protocol
+}
+
+func (s *fakeProtocolScheduler) Scheduled() (connection.Protocol, bool) {
+	if s.scheduled == nil {
+		return 0, false
+	}
+	return *s.scheduled, true
+}
+
+func (s *fakeProtocolScheduler) Current() connection.Protocol {
+	if s.scheduled != nil {
+		return *s.scheduled
+	}
+	return s.ProtocolSelector.Current()
+}
+
+func TestProtocolFallbackApplySchedule(t *testing.T) {
+	log := zerolog.Nop()
+	mockFetcher := dynamicMockFetcher{
+		protocolPercents: edgediscovery.ProtocolPercents{edgediscovery.ProtocolPercent{Protocol: "quic", Percentage: 100}},
+	}
+	selector, err := connection.NewProtocolSelector("auto", "", false, false, mockFetcher.fetch(), time.Hour, &log)
+	assert.NoError(t, err)
+	scheduler := &fakeProtocolScheduler{ProtocolSelector: selector}
+	backoff := retry.NewBackoff(3, time.Millisecond, false)
+	protoFallback := &protocolFallback{backoff, connection.QUIC, false, false, false}
+
+	// A fallback outside of the schedule is kept
+	protoFallback.fallback(connection.HTTP2)
+	assert.False(t, protoFallback.applySchedule(scheduler))
+	assert.Equal(t, connection.HTTP2, protoFallback.protocol)
+
+	// The window forces QUIC, then the selector picks the protocol again once it closes
+	quicProtocol := connection.QUIC
+	scheduler.scheduled = &quicProtocol
+	assert.True(t, protoFallback.applySchedule(scheduler))
+	assert.Equal(t, connection.QUIC, protoFallback.protocol)
+	assert.False(t, protoFallback.inFallback)
+	assert.False(t, protoFallback.applySchedule(scheduler))
+
+	http2Protocol := connection.HTTP2
+	scheduler.scheduled = &http2Protocol
+	assert.True(t, protoFallback.applySchedule(scheduler))
+	assert.Equal(t, connection.HTTP2, protoFallback.protocol)
+
+	scheduler.scheduled = nil
+	assert.True(t, protoFallback.applySchedule(scheduler))
+	assert.Equal(t, connection.QUIC, protoFallback.protocol)
+	assert.False(t, protoFallback.applySchedule(scheduler))
+}
+
+func TestProtocolFallbackApplyHint(t *testing.T) {
+	log := zerolog.Nop()
+	mockFetcher := dynamicMockFetcher{
+		protocolPercents: edgediscovery.ProtocolPercents{edgediscovery.ProtocolPercent{Protocol: "quic", Percentage: 100}},
+	}
+	selector, err := connection.NewProtocolSelector("auto", "", false, false, mockFetcher.fetch(), time.Hour, &log)
+	assert.NoError(t, err)
+	hinter, ok := selector.(connection.ProtocolHinter)
+	assert.True(t, ok)
+	backoff := retry.NewBackoff(3, time.Millisecond, false)
+	protoFallback := &protocolFallback{backoff, connection.QUIC, false, false, false}
+	assert.False(t, protoFallback.applyHint(hinter))
+
+	// Another connection registered with HTTP2
+	hinter.Hint(connection.HTTP2, time.Hour)
+	assert.True(t, protoFallback.applyHint(hinter))
+	assert.Equal(t, connection.HTTP2, protoFallback.protocol)
+	assert.False(t, protoFallback.applyHint(hinter))
+
+	// The selector picks the protocol again once the hint expired
+	hinter.Hint(connection.HTTP2, 0)
+	assert.True(t, protoFallback.applyHint(hinter))
+	assert.Equal(t, connection.QUIC, protoFallback.protocol)
+	assert.False(t, protoFallback.applyHint(hinter))
+
+	// A connection in fallback keeps its own protocol
+	protoFallback.fallback(connection.HTTP2)
+	hinter.Hint(connection.QUIC, time.Hour)
+	assert.False(t, protoFallback.applyHint(hinter))
+	assert.Equal(t, connection.HTTP2, protoFallback.protocol)
+}
+
+func gaugeValue(t *testing.T, gauge *prometheus.GaugeVec, connIndex string) float64 {
+	var metric dto.Metric
+	assert.NoError(t, gauge.WithLabelValues(connIndex).Write(&metric))
+	return metric.GetGauge().GetValue()
+}
+
+func TestObserveBackoff(t *testing.T) {
+	backoff := retry.NewBackoff(3, time.Second, false)
+	backoff.Clock.After = immediateTimeAfter
+	protoFallback := &protocolFallback{backoff, connection.QUIC, false, false, false}
+
+	observeProtocolFallback(200, protoFallback)
+	assert.Equal(t, 3.0, gaugeValue(t, backoffRetriesRemaining, "200"))
+	assert.Equal(t, 0.0, gaugeValue(t, protocolFallbackActive, "200"))
+
+	protoFallback.BackoffTimer()
+	observeBackoff(200, protoFallback, 4*time.Second)
+	assert.Equal(t, 4.0, gaugeValue(t, backoffDuration, "200"))
+	assert.Equal(t, 2.0, gaugeValue(t, backoffRetriesRemaining, "200"))
+
+	protoFallback.fallback(connection.HTTP2)
+	observeProtocolFallback(200, protoFallback)
+	assert.Equal(t, 3.0, gaugeValue(t, backoffRetriesRemaining, "200"))
+	assert.Equal(t, 1.0, gaugeValue(t, protocolFallbackActive, "200"))
+
+	observeConnected(200)
+	assert.Equal(t, 0.0, gaugeValue(t, backoffDuration, "200"))
+}
@@ -0,0 +1,138 @@
+package supervisor
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+// watchdogConnection labels the connections in the metrics of the watchdog
+const watchdogConnection = "connection"
+
+// watchdog watches the serve loops of the registered connections, so that one that silently stopped doesn't leave the
+// tunnel looking connected while it serves nothing: they must keep answering control stream heartbeats. A connection
+// that stalls is logged and counted, and reconnected if restart is set. A nil watchdog watches nothing.
+type watchdog struct {
+	// timeout is how long a registered connection may go without an answered heartbeat
+	timeout time.Duration
+	restart bool
+	now     func() time.Time
+	log     *zerolog.Logger
+
+	lock sync.Mutex
+	// connections holds the last heartbeat of each registered connection
+	connections map[uint8]time.Time
+	// stalledC receives the connections to reconnect
+	stalledC chan uint8
+}
+
+func newWatchdog(timeout time.Duration, restart bool, heartbeat connection.ControlStreamHeartbeat, haConnections int, log *zerolog.Logger) *watchdog {
+	if timeout <= 0 {
+		return nil
+	}
+	// An idle connection makes no progress without heartbeats
+	if heartbeat.Interval <= 0 {
+		log.Warn().Msg("The watchdog is disabled without control stream heartbeats")
+		return nil
+	}
+	return &watchdog{
+		timeout:     timeout,
+		restart:     restart,
+		now:         time.Now,
+		log:         log,
+		connections: make(map[uint8]time.Time),
+		stalledC:    make(chan uint8, haConnections),
+	}
+}
+
+// heartbeat returns heartbeat reporting the answered heartbeats of connections to the watchdog.
+func (w *watchdog) heartbeat(heartbeat connection.ControlStreamHeartbeat) connection.ControlStreamHeartbeat {
+	if w == nil {
+		return heartbeat
+	}
+	heartbeat.OnHeartbeat = w.beat
+	return heartbeat
+}
+
+// watchConnection starts watching connIndex once it registered.
+func (w *watchdog) watchConnection(connIndex uint8) {
+	if w == nil {
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.connections[connIndex] = w.now()
+}
+
+// unwatchConnection stops watching connIndex once it stopped serving.
+func (w *watchdog) unwatchConnection(connIndex uint8) {
+	if w == nil {
+		return
+	}
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	delete(w.connections, connIndex)
+}
+
+func (w *watchdog) beat(connIndex uint8) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if _, ok := w.connections[connIndex]; ok {
+		w.connections[connIndex] = w.now()
+	}
+}
+
+// stalled returns the channel receiving the connections to reconnect, nil if the watchdog doesn't restart them.
+func (w *watchdog) stalled() <-chan uint8 {
+	if w == nil || !w.restart {
+		return nil
+	}
+	return w.stalledC
+}
+
+// run checks the connections until ctx is done.
+func (w *watchdog) run(ctx context.Context) {
+	if w == nil {
+		return
+	}
+	ticker := time.NewTicker(w.timeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.check()
+		}
+	}
+}
+
+// check reports the connections without a heartbeat for longer than the timeout. They are no longer watched until
+// they register again.
+func (w *watchdog) check() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	now := w.now()
+	for connIndex, beat := range w.connections {
+		if now.Sub(beat) <= w.timeout {
+			continue
+		}
+		delete(w.connections, connIndex)
+		watchdogStalls.WithLabelValues(watchdogConnection).Inc()
+		w.log.Error().Uint8(connection.LogFieldConnIndex, connIndex).
+			Msgf("Connection answered no control stream heartbeat for %s", now.Sub(beat).Truncate(time.Second))
+		if !w.restart {
+			continue
+		}
+		select {
+		case w.stalledC <- connIndex:
+			watchdogRestarts.WithLabelValues(watchdogConnection).Inc()
+			w.log.Info().Uint8(connection.LogFieldConnIndex, connIndex).Msg("Reconnecting the stalled connection")
+		default:
+		}
+	}
+}
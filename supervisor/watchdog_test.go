@@ -0,0 +1,63 @@
+package supervisor
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+func TestWatchdogConnections(t *testing.T) {
+	log := zerolog.Nop()
+	w := newWatchdog(time.Minute, true, connection.ControlStreamHeartbeat{Interval: 10 * time.Second}, 4, &log)
+	require.NotNil(t, w)
+	now := time.Unix(0, 0)
+	w.now = func() time.Time { return now }
+	heartbeat := w.heartbeat(connection.ControlStreamHeartbeat{Interval: 10 * time.Second})
+	require.NotNil(t, heartbeat.OnHeartbeat)
+	stalls := counterValue(t, watchdogStalls.WithLabelValues(watchdogConnection))
+
+	w.watchConnection(0)
+	w.watchConnection(1)
+	// Heartbeats of connections that aren't registered are ignored
+	heartbeat.OnHeartbeat(2)
+
+	now = now.Add(50 * time.Second)
+	heartbeat.OnHeartbeat(0)
+	now = now.Add(50 * time.Second)
+	w.check()
+	select {
+	case index := <-w.stalled():
+		assert.Equal(t, uint8(1), index)
+	default:
+		t.Fatal("the stalled connection wasn't reconnected")
+	}
+	assert.Equal(t, stalls+1, counterValue(t, watchdogStalls.WithLabelValues(watchdogConnection)))
+
+	// The stalled connection is only reported once, and a stopped connection isn't reported
+	w.unwatchConnection(0)
+	now = now.Add(time.Hour)
+	w.check()
+	assert.Empty(t, w.stalled())
+	assert.Equal(t, stalls+1, counterValue(t, watchdogStalls.WithLabelValues(watchdogConnection)))
+}
+
+func TestWatchdogDisabled(t *testing.T) {
+	log := zerolog.Nop()
+	w := newWatchdog(0, true, connection.ControlStreamHeartbeat{Interval: time.Second}, 4, &log)
+	require.Nil(t, w)
+	heartbeat := connection.ControlStreamHeartbeat{Interval: time.Second}
+	assert.Nil(t, w.heartbeat(heartbeat).OnHeartbeat)
+	w.watchConnection(0)
+	w.unwatchConnection(0)
+	assert.Nil(t, w.stalled())
+	w.run(context.Background())
+
+	// An idle connection makes no progress without heartbeats
+	assert.Nil(t, newWatchdog(time.Minute, true, connection.ControlStreamHeartbeat{}, 4, &log))
+}
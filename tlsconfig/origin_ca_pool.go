@@ -0,0 +1,297 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+)
+
+// OriginCAPoolRescanInterval is how often a caPool directory is re-scanned for rotated CA certificates.
+const OriginCAPoolRescanInterval = 30 * time.Second
+
+var (
+	originCAPoolReloads = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cloudflared",
+		Subsystem: "origin",
+		Name:      "ca_pool_reloads",
+		Help:      "Count of reloads of caPool directories whose certificates changed, by path and result",
+	}, []string{"path", "result"})
+	originCAPoolCertificates = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "cloudflared",
+		Subsystem: "origin",
+		Name:      "ca_pool_certificates",
+		Help:      "Number of CA certificates loaded from each caPool directory",
+	}, []string{"path"})
+)
+
+func init() {
+	prometheus.MustRegister(originCAPoolReloads, originCAPoolCertificates)
+}
+
+// activeOriginCAPools are the pools of the ingress rules in use, reported by OriginCAPoolStatuses.
+var activeOriginCAPools struct {
+	sync.Mutex
+	pools map[*OriginCAPool]struct{}
+}
+
+// OriginCAPoolStatus reports the state of a caPool directory.
+type OriginCAPoolStatus struct {
+	Path         string    `json:"path"`
+	Certificates int       `json:"certificates"`
+	LastReload   time.Time `json:"lastReload"`
+	// Error is why the last reload failed, the certificates from the reload before it are still trusted
+	Error string `json:"error,omitempty"`
+}
+
+// OriginCAPool is the pool of CAs trusted for the certificate of origins whose caPool is a directory. It holds the
+// system and Cloudflare CAs and every certificate of the PEM files in the directory, which is re-scanned so that
+// origins rotating their internal CAs don't need cloudflared to restart.
+type OriginCAPool struct {
+	dir string
+	log *zerolog.Logger
+
+	lock        sync.RWMutex
+	pool        *x509.CertPool
+	fingerprint string
+	status      OriginCAPoolStatus
+}
+
+// IsOriginCAPoolDir tells whether the caPool path is a directory of CA certificates rather than a file.
+func IsOriginCAPoolDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// NewOriginCAPool loads the CA certificates of dir, failing if they can't be.
+func NewOriginCAPool(dir string, log *zerolog.Logger) (*OriginCAPool, error) {
+	p := &OriginCAPool{
+		dir:    dir,
+		log:    log,
+		status: OriginCAPoolStatus{Path: dir},
+	}
+	if err := p.Reload(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Pool returns the certificates most recently loaded.
+func (p *OriginCAPool) Pool() *x509.CertPool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.pool
+}
+
+// Status returns the state of the pool.
+func (p *OriginCAPool) Status() OriginCAPoolStatus {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.status
+}
+
+// Reload re-scans the directory and replaces the pool if any file changed. The previous pool is kept if the new
+// certificates can't be loaded.
+func (p *OriginCAPool) Reload() error {
+	files, fingerprint, err := p.scan()
+	if err == nil && p.unchanged(fingerprint) {
+		return nil
+	}
+
+	var (
+		pool  *x509.CertPool
+		count int
+	)
+	if err == nil {
+		pool, count, err = p.load(files)
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.status.LastReload = time.Now()
+	if err != nil {
+		p.status.Error = err.Error()
+		originCAPoolReloads.WithLabelValues(p.dir, "error").Inc()
+		return err
+	}
+	p.pool = pool
+	p.fingerprint = fingerprint
+	p.status.Certificates = count
+	p.status.Error = ""
+	originCAPoolReloads.WithLabelValues(p.dir, "success").Inc()
+	originCAPoolCertificates.WithLabelValues(p.dir).Set(float64(count))
+	return nil
+}
+
+// Run re-scans the directory every interval until shutdownC is closed, reporting the pool in
+// OriginCAPoolStatuses meanwhile.
+func (p *OriginCAPool) Run(interval time.Duration, shutdownC <-chan struct{}) {
+	activeOriginCAPools.Lock()
+	if activeOriginCAPools.pools == nil {
+		activeOriginCAPools.pools = make(map[*OriginCAPool]struct{})
+	}
+	activeOriginCAPools.pools[p] = struct{}{}
+	activeOriginCAPools.Unlock()
+	defer func() {
+		activeOriginCAPools.Lock()
+		delete(activeOriginCAPools.pools, p)
+		activeOriginCAPools.Unlock()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-shutdownC:
+			return
+		case <-ticker.C:
+			if err := p.Reload(); err != nil {
+				p.log.Err(err).Str("caPool", p.dir).Msg("Failed to reload the origin CA pool, keeping the previous certificates")
+			}
+		}
+	}
+}
+
+// VerifyConnection returns a tls.Config VerifyConnection callback verifying the origin certificate against the pool
+// as it is at the time of the handshake. It is meant to be used with InsecureSkipVerify, as the RootCAs of a
+// tls.Config can't change once it is in use. serverName is verified when no SNI was sent, e.g. for IP origins, and
+// the connection is rejected when neither names the origin, any certificate signed by the pool would be accepted otherwise.
+func (p *OriginCAPool) VerifyConnection(serverName string) func(tls.ConnectionState) error {
+	return func(cs tls.ConnectionState) error {
+		if len(cs.PeerCertificates) == 0 {
+			return errors.New("origin presented no certificate")
+		}
+		opts := x509.VerifyOptions{
+			DNSName:       cs.ServerName,
+			Roots:         p.Pool(),
+			Intermediates: x509.NewCertPool(),
+		}
+		if opts.DNSName == "" {
+			opts.DNSName = serverName
+		}
+		if opts.DNSName == "" {
+			return errors.New("unable to verify the origin certificate without a server name, set originServerName")
+		}
+		for _, cert := range cs.PeerCertificates[1:] {
+			opts.Intermediates.AddCert(cert)
+		}
+		_, err := cs.PeerCertificates[0].Verify(opts)
+		return err
+	}
+}
+
+// unchanged tells whether the files are the ones of the pool in use, clearing the error of a failed reload since
+// then, e.g. when a bad file was removed.
+func (p *OriginCAPool) unchanged(fingerprint string) bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.pool == nil || fingerprint != p.fingerprint {
+		return false
+	}
+	p.status.Error = ""
+	return true
+}
+
+// scan lists the files of the directory along with a fingerprint of their names, sizes and modification times.
+// Hidden entries are skipped, such as the ..data directory Kubernetes swaps to update a mounted secret.
+func (p *OriginCAPool) scan() ([]string, string, error) {
+	entries, err := os.ReadDir(p.dir)
+	if err != nil {
+		return nil, "", errors.Wrapf(err, "unable to read the caPool directory %s", p.dir)
+	}
+	var (
+		files       []string
+		fingerprint strings.Builder
+	)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(p.dir, entry.Name())
+		// Stat follows symlinks, which rotated files often are
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, "", errors.Wrapf(err, "unable to read %s", path)
+		}
+		if info.IsDir() {
+			continue
+		}
+		files = append(files, path)
+		_, _ = fmt.Fprintf(&fingerprint, "%s:%d:%d;", entry.Name(), info.Size(), info.ModTime().UnixNano())
+	}
+	sort.Strings(files)
+	return files, fingerprint.String(), nil
+}
+
+func (p *OriginCAPool) load(files []string) (*x509.CertPool, int, error) {
+	pool, err := loadGlobalCertPool(p.log)
+	if err != nil {
+		return nil, 0, err
+	}
+	count := 0
+	for _, path := range files {
+		certs, err := readPEMCertificates(path)
+		if err != nil {
+			return nil, 0, err
+		}
+		for _, cert := range certs {
+			pool.AddCert(cert)
+		}
+		count += len(certs)
+	}
+	if count == 0 {
+		return nil, 0, fmt.Errorf("no CA certificates found in the caPool directory %s", p.dir)
+	}
+	return pool, count, nil
+}
+
+func readPEMCertificates(path string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read %s", path)
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid certificate in %s", path)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no PEM certificate found in %s", path)
+	}
+	return certs, nil
+}
+
+// OriginCAPoolStatuses reports the state of the caPool directories of the ingress rules in use.
+func OriginCAPoolStatuses() []OriginCAPoolStatus {
+	activeOriginCAPools.Lock()
+	defer activeOriginCAPools.Unlock()
+	statuses := make([]OriginCAPoolStatus, 0, len(activeOriginCAPools.pools))
+	for pool := range activeOriginCAPools.pools {
+		statuses = append(statuses, pool.Status())
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Path < statuses[j].Path
+	})
+	return statuses
+}
@@ -0,0 +1,168 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOriginCAPoolReload(t *testing.T) {
+	log := zerolog.Nop()
+	dir := t.TempDir()
+	oldCA := newTestCA(t, "old CA")
+	newCA := newTestCA(t, "new CA")
+	oldCA.write(t, filepath.Join(dir, "ca.pem"))
+
+	pool, err := NewOriginCAPool(dir, &log)
+	require.NoError(t, err)
+	require.Equal(t, 1, pool.Status().Certificates)
+	verify := pool.VerifyConnection("origin.internal")
+
+	require.NoError(t, verify(oldCA.connectionState(t, "origin.internal")))
+	require.Error(t, verify(oldCA.connectionState(t, "other.internal")))
+	require.Error(t, verify(newCA.connectionState(t, "origin.internal")))
+
+	// The origin rotates to a new CA, the old one is still trusted until it is removed
+	newCA.write(t, filepath.Join(dir, "ca-next.pem"))
+	require.NoError(t, pool.Reload())
+	require.Equal(t, 2, pool.Status().Certificates)
+	require.NoError(t, verify(oldCA.connectionState(t, "origin.internal")))
+	require.NoError(t, verify(newCA.connectionState(t, "origin.internal")))
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "ca.pem")))
+	require.NoError(t, pool.Reload())
+	require.Equal(t, 1, pool.Status().Certificates)
+	require.Error(t, verify(oldCA.connectionState(t, "origin.internal")))
+	require.NoError(t, verify(newCA.connectionState(t, "origin.internal")))
+
+	// A bad file is reported and the certificates loaded before are kept
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "broken.pem"), []byte("not a certificate"), 0o600))
+	require.Error(t, pool.Reload())
+	status := pool.Status()
+	require.NotEmpty(t, status.Error)
+	require.Equal(t, 1, status.Certificates)
+	require.NoError(t, verify(newCA.connectionState(t, "origin.internal")))
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "broken.pem")))
+	require.NoError(t, pool.Reload())
+	require.Empty(t, pool.Status().Error)
+}
+
+func TestOriginCAPoolRequiresServerName(t *testing.T) {
+	log := zerolog.Nop()
+	dir := t.TempDir()
+	ca := newTestCA(t, "CA")
+	ca.write(t, filepath.Join(dir, "ca.pem"))
+
+	pool, err := NewOriginCAPool(dir, &log)
+	require.NoError(t, err)
+	verify := pool.VerifyConnection("")
+
+	require.Error(t, verify(ca.connectionState(t, "origin.internal")))
+	state := ca.connectionState(t, "origin.internal")
+	state.ServerName = "origin.internal"
+	require.NoError(t, verify(state))
+}
+
+func TestOriginCAPoolSkipsHiddenEntries(t *testing.T) {
+	log := zerolog.Nop()
+	dir := t.TempDir()
+	ca := newTestCA(t, "CA")
+	ca.write(t, filepath.Join(dir, "ca.pem"))
+	require.NoError(t, os.Mkdir(filepath.Join(dir, "..data"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".hidden"), []byte("not a certificate"), 0o600))
+
+	pool, err := NewOriginCAPool(dir, &log)
+	require.NoError(t, err)
+	require.Equal(t, 1, pool.Status().Certificates)
+}
+
+func TestNewOriginCAPoolEmptyDir(t *testing.T) {
+	log := zerolog.Nop()
+	_, err := NewOriginCAPool(t.TempDir(), &log)
+	require.Error(t, err)
+}
+
+func TestOriginCAPoolStatuses(t *testing.T) {
+	log := zerolog.Nop()
+	dir := t.TempDir()
+	newTestCA(t, "CA").write(t, filepath.Join(dir, "ca.pem"))
+	pool, err := NewOriginCAPool(dir, &log)
+	require.NoError(t, err)
+
+	shutdownC := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		pool.Run(time.Hour, shutdownC)
+	}()
+	require.Eventually(t, func() bool {
+		return len(OriginCAPoolStatuses()) == 1
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, dir, OriginCAPoolStatuses()[0].Path)
+
+	close(shutdownC)
+	<-done
+	require.Empty(t, OriginCAPoolStatuses())
+}
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) write(t *testing.T, path string) {
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.cert.Raw})
+	require.NoError(t, os.WriteFile(path, data, 0o600))
+}
+
+// connectionState returns the state of a handshake with an origin whose certificate for serverName is signed by ca.
+func (ca *testCA) connectionState(t *testing.T, serverName string) tls.ConnectionState {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: serverName},
+		DNSNames:     []string{serverName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return tls.ConnectionState{PeerCertificates: []*x509.Certificate{cert}}
+}
@@ -0,0 +1,72 @@
+package tunneldns
+
+import (
+	"bufio"
+	"net/netip"
+	"os"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/pkg/errors"
+)
+
+// Blocklist is a set of domains, with their subdomains, answered with NXDOMAIN instead of being forwarded.
+type Blocklist struct {
+	domains map[string]struct{}
+}
+
+// LoadBlocklist reads the domains of a blocklist file, either one per line or in the hosts file format
+// (0.0.0.0 ads.example.com). Anything after # is a comment.
+func LoadBlocklist(path string) (*Blocklist, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open the blocklist")
+	}
+	defer file.Close()
+
+	blocklist := &Blocklist{domains: map[string]struct{}{}}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		// The first field of a hosts file line is the address the domains resolve to
+		if _, err := netip.ParseAddr(fields[0]); err == nil {
+			fields = fields[1:]
+		}
+		for _, domain := range fields {
+			if _, ok := dns.IsDomainName(domain); !ok {
+				return nil, errors.Errorf("invalid domain %q on line %d of the blocklist %s", domain, line, path)
+			}
+			blocklist.domains[dns.CanonicalName(domain)] = struct{}{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.Wrap(err, "failed to read the blocklist")
+	}
+	return blocklist, nil
+}
+
+// Len is the number of domains of the blocklist.
+func (b *Blocklist) Len() int {
+	if b == nil {
+		return 0
+	}
+	return len(b.domains)
+}
+
+// Blocks returns whether name is a domain of the blocklist or one of their subdomains.
+func (b *Blocklist) Blocks(name string) bool {
+	if b == nil {
+		return false
+	}
+	name = dns.CanonicalName(name)
+	for offset, end := 0, false; !end; offset, end = dns.NextLabel(name, offset) {
+		if _, ok := b.domains[name[offset:]]; ok {
+			return true
+		}
+	}
+	return false
+}
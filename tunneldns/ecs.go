@@ -0,0 +1,89 @@
+package tunneldns
+
+import (
+	"fmt"
+	"net/netip"
+
+	"github.com/miekg/dns"
+)
+
+const (
+	// ECSPassthrough forwards the EDNS client subnet of the queries as it is
+	ECSPassthrough = "passthrough"
+	// ECSStrip removes the EDNS client subnet from the queries, so that the upstreams don't learn the client networks
+	ECSStrip = "strip"
+)
+
+// ECSPolicy controls the EDNS client subnet option (RFC 7871) of the queries forwarded to the upstreams.
+type ECSPolicy struct {
+	strip bool
+	// subnet replaces the client subnet of the queries if set
+	subnet *dns.EDNS0_SUBNET
+}
+
+// ParseECSPolicy parses ECSPassthrough (the default if empty), ECSStrip, or a subnet such as 192.0.2.0/24 sent as the
+// client subnet of every query instead of the one of the client, if any.
+func ParseECSPolicy(policy string) (ECSPolicy, error) {
+	switch policy {
+	case "", ECSPassthrough:
+		return ECSPolicy{}, nil
+	case ECSStrip:
+		return ECSPolicy{strip: true}, nil
+	}
+	prefix, err := netip.ParsePrefix(policy)
+	if err != nil {
+		return ECSPolicy{}, fmt.Errorf("invalid EDNS client subnet policy %q, expected %s, %s or a subnet", policy, ECSPassthrough, ECSStrip)
+	}
+	prefix = prefix.Masked()
+	subnet := &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: uint8(prefix.Bits()),
+		Address:       prefix.Addr().AsSlice(),
+	}
+	if prefix.Addr().Is6() {
+		subnet.Family = 2
+	}
+	return ECSPolicy{strip: true, subnet: subnet}, nil
+}
+
+// apply returns the query to forward to the upstreams, a copy of query if the policy changes it.
+func (p ECSPolicy) apply(query *dns.Msg) *dns.Msg {
+	if !p.strip {
+		return query
+	}
+	query = query.Copy()
+	opt := query.IsEdns0()
+	if opt != nil {
+		options := opt.Option[:0]
+		for _, option := range opt.Option {
+			if option.Option() != dns.EDNS0SUBNET {
+				options = append(options, option)
+			}
+		}
+		opt.Option = options
+	}
+	if p.subnet != nil {
+		if opt == nil {
+			opt = query.SetEdns0(dns.DefaultMsgSize, false).IsEdns0()
+		}
+		subnet := *p.subnet
+		opt.Option = append(opt.Option, &subnet)
+	}
+	return query
+}
+
+// restore removes from reply the OPT record answering the one added to a query that had none, since a client not
+// using EDNS doesn't expect one.
+func (p ECSPolicy) restore(query, reply *dns.Msg) {
+	if p.subnet == nil || query.IsEdns0() != nil {
+		return
+	}
+	extra := reply.Extra[:0]
+	for _, rr := range reply.Extra {
+		if rr.Header().Rrtype != dns.TypeOPT {
+			extra = append(extra, rr)
+		}
+	}
+	reply.Extra = extra
+}
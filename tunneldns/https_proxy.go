@@ -2,6 +2,7 @@ package tunneldns
 
 import (
 	"context"
+	"time"
 
 	"github.com/coredns/coredns/plugin"
 	"github.com/miekg/dns"
@@ -15,25 +16,50 @@ type Upstream interface {
 
 // ProxyPlugin is a simplified DNS proxy using a generic upstream interface
 type ProxyPlugin struct {
-	Upstreams []Upstream
+	upstreams *upstreamSelector
+	ecs       ECSPolicy
+	blocklist *Blocklist
 	Next      plugin.Handler
 }
 
+// NewProxyPlugin creates a proxy trying the upstreams in the order of policy. The queries of the domains of blocklist,
+// which may be nil, are answered with NXDOMAIN.
+func NewProxyPlugin(upstreams []Upstream, policy UpstreamPolicy, ecs ECSPolicy, blocklist *Blocklist) *ProxyPlugin {
+	return &ProxyPlugin{
+		upstreams: newUpstreamSelector(policy, upstreams),
+		ecs:       ecs,
+		blocklist: blocklist,
+	}
+}
+
 // ServeDNS implements interface for CoreDNS plugin
-func (p ProxyPlugin) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+func (p *ProxyPlugin) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) (int, error) {
+	if len(r.Question) > 0 && p.blocklist.Blocks(r.Question[0].Name) {
+		blockedQueries.Inc()
+		reply := new(dns.Msg)
+		reply.SetRcode(r, dns.RcodeNameError)
+		w.WriteMsg(reply)
+		return 0, nil
+	}
+
 	var reply *dns.Msg
 	var backendErr error
 
-	for _, upstream := range p.Upstreams {
-		reply, backendErr = upstream.Exchange(ctx, r)
+	query := p.ecs.apply(r)
+	for _, upstream := range p.upstreams.order() {
+		start := time.Now()
+		reply, backendErr = upstream.upstream.Exchange(ctx, query)
 		if backendErr == nil {
+			upstream.succeeded(time.Since(start))
+			p.ecs.restore(r, reply)
 			w.WriteMsg(reply)
 			return 0, nil
 		}
+		upstream.failed(time.Now())
 	}
 
 	return dns.RcodeServerFailure, errors.Wrap(backendErr, "failed to contact any of the upstreams")
 }
 
 // Name implements interface for CoreDNS plugin
-func (p ProxyPlugin) Name() string { return "proxy" }
+func (p *ProxyPlugin) Name() string { return "proxy" }
@@ -0,0 +1,164 @@
+package tunneldns
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeUpstream struct {
+	name    string
+	fail    bool
+	queries []*dns.Msg
+}
+
+func (u *fakeUpstream) String() string { return u.name }
+
+func (u *fakeUpstream) Exchange(_ context.Context, query *dns.Msg) (*dns.Msg, error) {
+	u.queries = append(u.queries, query)
+	if u.fail {
+		return nil, errors.New("upstream unreachable")
+	}
+	reply := new(dns.Msg)
+	reply.SetReply(query)
+	return reply, nil
+}
+
+type recordingWriter struct {
+	dns.ResponseWriter
+	reply *dns.Msg
+}
+
+func (w *recordingWriter) WriteMsg(reply *dns.Msg) error {
+	w.reply = reply
+	return nil
+}
+
+func names(upstreams []*upstreamHealth) []string {
+	var result []string
+	for _, upstream := range upstreams {
+		result = append(result, upstream.name)
+	}
+	return result
+}
+
+func TestUpstreamSelectorOrder(t *testing.T) {
+	upstreams := []Upstream{&fakeUpstream{name: "test-a"}, &fakeUpstream{name: "test-b"}, &fakeUpstream{name: "test-c"}}
+
+	fallback := newUpstreamSelector(PolicyFallback, upstreams)
+	assert.Equal(t, []string{"test-a", "test-b", "test-c"}, names(fallback.order()))
+
+	random := newUpstreamSelector(PolicyRandom, upstreams)
+	random.shuffle = func(n int, swap func(i, j int)) { swap(0, n-1) }
+	assert.Equal(t, []string{"test-c", "test-b", "test-a"}, names(random.order()))
+
+	fastest := newUpstreamSelector(PolicyFastest, upstreams)
+	fastest.upstreams[0].succeeded(30 * time.Millisecond)
+	fastest.upstreams[1].succeeded(10 * time.Millisecond)
+	// test-c has no latency yet, so it's tried first
+	assert.Equal(t, []string{"test-c", "test-b", "test-a"}, names(fastest.order()))
+
+	// An unhealthy upstream is tried last until it's given another chance
+	for i := 0; i < maxConsecutiveFailures; i++ {
+		fastest.upstreams[2].failed(time.Now())
+	}
+	assert.Equal(t, []string{"test-b", "test-a", "test-c"}, names(fastest.order()))
+	fastest.upstreams[2].failed(time.Now().Add(-unhealthyRetryInterval))
+	assert.Equal(t, []string{"test-c", "test-b", "test-a"}, names(fastest.order()))
+}
+
+func TestProxyPluginFailsOver(t *testing.T) {
+	failing := &fakeUpstream{name: "test-failing", fail: true}
+	working := &fakeUpstream{name: "test-working"}
+	proxy := NewProxyPlugin([]Upstream{failing, working}, PolicyFallback, ECSPolicy{}, nil)
+
+	query := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	writer := &recordingWriter{}
+	_, err := proxy.ServeDNS(context.Background(), writer, query)
+	require.NoError(t, err)
+	require.NotNil(t, writer.reply)
+	assert.Equal(t, dns.RcodeSuccess, writer.reply.Rcode)
+	assert.Len(t, failing.queries, 1)
+	assert.Len(t, working.queries, 1)
+
+	failing.queries, working.fail = nil, true
+	_, err = proxy.ServeDNS(context.Background(), &recordingWriter{}, query)
+	assert.Error(t, err)
+}
+
+func TestECSPolicy(t *testing.T) {
+	query := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	query.SetEdns0(dns.DefaultMsgSize, false)
+	query.IsEdns0().Option = append(query.IsEdns0().Option, &dns.EDNS0_SUBNET{
+		Code:          dns.EDNS0SUBNET,
+		Family:        1,
+		SourceNetmask: 24,
+		Address:       net.ParseIP("198.51.100.0").To4(),
+	})
+
+	passthrough, err := ParseECSPolicy("")
+	require.NoError(t, err)
+	assert.Same(t, query, passthrough.apply(query))
+
+	strip, err := ParseECSPolicy(ECSStrip)
+	require.NoError(t, err)
+	assert.Empty(t, strip.apply(query).IsEdns0().Option)
+	// The query of the client is left as it is
+	assert.Len(t, query.IsEdns0().Option, 1)
+
+	fixed, err := ParseECSPolicy("2001:db8:1234::/48")
+	require.NoError(t, err)
+	plainQuery := new(dns.Msg).SetQuestion("example.com.", dns.TypeA)
+	forwarded := fixed.apply(plainQuery)
+	require.NotNil(t, forwarded.IsEdns0())
+	subnet := forwarded.IsEdns0().Option[0].(*dns.EDNS0_SUBNET)
+	assert.Equal(t, uint16(2), subnet.Family)
+	assert.Equal(t, uint8(48), subnet.SourceNetmask)
+	assert.Equal(t, "2001:db8:1234::", subnet.Address.String())
+
+	reply := new(dns.Msg).SetReply(forwarded)
+	reply.SetEdns0(dns.DefaultMsgSize, false)
+	fixed.restore(plainQuery, reply)
+	assert.Nil(t, reply.IsEdns0())
+
+	_, err = ParseECSPolicy("198.51.100.0")
+	assert.Error(t, err)
+}
+
+func TestBlocklist(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "blocklist")
+	require.NoError(t, os.WriteFile(path, []byte(`# Ads
+0.0.0.0 ads.example.com tracker.example.com
+Telemetry.Example.NET # comment
+
+`), 0600))
+	blocklist, err := LoadBlocklist(path)
+	require.NoError(t, err)
+	assert.Equal(t, 3, blocklist.Len())
+
+	for _, name := range []string{"ads.example.com.", "cdn.ads.example.com.", "TRACKER.example.com.", "telemetry.example.net."} {
+		assert.True(t, blocklist.Blocks(name), name)
+	}
+	for _, name := range []string{"example.com.", "myads.example.com.", "example.net."} {
+		assert.False(t, blocklist.Blocks(name), name)
+	}
+
+	proxy := NewProxyPlugin([]Upstream{&fakeUpstream{name: "test-blocklist"}}, PolicyFallback, ECSPolicy{}, blocklist)
+	writer := &recordingWriter{}
+	_, err = proxy.ServeDNS(context.Background(), writer, new(dns.Msg).SetQuestion("ads.example.com.", dns.TypeA))
+	require.NoError(t, err)
+	require.NotNil(t, writer.reply)
+	assert.Equal(t, dns.RcodeNameError, writer.reply.Rcode)
+
+	require.NoError(t, os.WriteFile(path, []byte("not..a.domain\n"), 0600))
+	_, err = LoadBlocklist(path)
+	assert.Error(t, err)
+}
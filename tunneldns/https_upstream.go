@@ -38,6 +38,11 @@ func NewUpstreamHTTPS(endpoint string, bootstraps []string, maxConnections int,
 	return &UpstreamHTTPS{client: configureClient(u.Hostname(), maxConnections), endpoint: u, bootstraps: bootstraps, log: log}, nil
 }
 
+// String is the endpoint of the upstream, which identifies it in the metrics
+func (u *UpstreamHTTPS) String() string {
+	return u.endpoint.String()
+}
+
 // Exchange provides an implementation for the Upstream interface
 func (u *UpstreamHTTPS) Exchange(ctx context.Context, query *dns.Msg) (*dns.Msg, error) {
 	queryBuf, err := query.Pack()
@@ -10,6 +10,7 @@ import (
 	"github.com/coredns/coredns/plugin/pkg/rcode"
 	"github.com/coredns/coredns/request"
 	"github.com/miekg/dns"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 const (
@@ -42,3 +43,35 @@ func (p MetricsPlugin) ServeDNS(ctx context.Context, w dns.ResponseWriter, r *dn
 
 // Name implements the CoreDNS plugin interface
 func (p MetricsPlugin) Name() string { return "metrics" }
+
+var (
+	upstreamRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "cloudflared",
+		Subsystem: "proxy_dns",
+		Name:      "upstream_requests_total",
+		Help:      "Queries forwarded to each DNS over HTTPS upstream, by result: success or failure",
+	}, []string{"upstream", "result"})
+	upstreamLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "cloudflared",
+		Subsystem: "proxy_dns",
+		Name:      "upstream_latency_seconds",
+		Help:      "Time each DNS over HTTPS upstream took to answer the queries it succeeded",
+		Buckets:   []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5},
+	}, []string{"upstream"})
+	upstreamHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "cloudflared",
+		Subsystem: "proxy_dns",
+		Name:      "upstream_healthy",
+		Help:      "Whether each DNS over HTTPS upstream is healthy (1), or failed the latest queries (0)",
+	}, []string{"upstream"})
+	blockedQueries = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: "cloudflared",
+		Subsystem: "proxy_dns",
+		Name:      "blocked_queries_total",
+		Help:      "Queries answered with NXDOMAIN because their domain is in the blocklist",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(upstreamRequests, upstreamLatency, upstreamHealthy, blockedQueries)
+}
@@ -18,6 +18,16 @@ const (
 	MaxUpstreamConnsDefault = 5
 )
 
+// ResolverOptions are the optional behaviors of the DNS over HTTPS proxy server
+type ResolverOptions struct {
+	// UpstreamPolicy is the order the upstreams are tried in, see ParseUpstreamPolicy
+	UpstreamPolicy string
+	// ECS controls the EDNS client subnet of the queries, see ParseECSPolicy
+	ECS string
+	// BlocklistFile is the file of the domains answered with NXDOMAIN, if any
+	BlocklistFile string
+}
+
 // Listener is an adapter between CoreDNS server and Warp runnable
 type Listener struct {
 	server *dnsserver.Server
@@ -78,7 +88,24 @@ func (l *Listener) Stop() error {
 }
 
 // CreateListener configures the server and bound sockets
-func CreateListener(address string, port uint16, upstreams []string, bootstraps []string, maxUpstreamConnections int, log *zerolog.Logger) (*Listener, error) {
+func CreateListener(address string, port uint16, upstreams []string, bootstraps []string, maxUpstreamConnections int, options ResolverOptions, log *zerolog.Logger) (*Listener, error) {
+	policy, err := ParseUpstreamPolicy(options.UpstreamPolicy)
+	if err != nil {
+		return nil, err
+	}
+	ecs, err := ParseECSPolicy(options.ECS)
+	if err != nil {
+		return nil, err
+	}
+	var blocklist *Blocklist
+	if options.BlocklistFile != "" {
+		blocklist, err = LoadBlocklist(options.BlocklistFile)
+		if err != nil {
+			return nil, err
+		}
+		log.Info().Msgf("Blocking %d domains of %s", blocklist.Len(), options.BlocklistFile)
+	}
+
 	// Build the list of upstreams
 	upstreamList := make([]Upstream, 0)
 	for _, url := range upstreams {
@@ -92,9 +119,7 @@ func CreateListener(address string, port uint16, upstreams []string, bootstraps
 
 	// Create a local cache with HTTPS proxy plugin
 	chain := cache.New()
-	chain.Next = ProxyPlugin{
-		Upstreams: upstreamList,
-	}
+	chain.Next = NewProxyPlugin(upstreamList, policy, ecs, blocklist)
 
 	// Format an endpoint
 	endpoint := "dns://" + net.JoinHostPort(address, strconv.FormatUint(uint64(port), 10))
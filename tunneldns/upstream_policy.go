@@ -0,0 +1,144 @@
+package tunneldns
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+// UpstreamPolicy is the order the upstreams are tried in for every query, the next one being tried when one fails.
+type UpstreamPolicy string
+
+const (
+	// PolicyFallback tries the upstreams in the order they are configured
+	PolicyFallback UpstreamPolicy = "fallback"
+	// PolicyRandom tries the upstreams in a random order, spreading the queries over them
+	PolicyRandom UpstreamPolicy = "random"
+	// PolicyFastest tries the upstreams that answered the fastest recently first
+	PolicyFastest UpstreamPolicy = "fastest"
+
+	// An upstream failing this many queries in a row is unhealthy, and tried after the healthy ones
+	maxConsecutiveFailures = 3
+	// An unhealthy upstream is given another chance after this long
+	unhealthyRetryInterval = 30 * time.Second
+	// Weight of the latest query in the average latency of an upstream
+	latencyWeight = 0.3
+)
+
+// ParseUpstreamPolicy parses the name of an UpstreamPolicy, empty being PolicyFallback.
+func ParseUpstreamPolicy(name string) (UpstreamPolicy, error) {
+	switch policy := UpstreamPolicy(name); policy {
+	case "":
+		return PolicyFallback, nil
+	case PolicyFallback, PolicyRandom, PolicyFastest:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown upstream policy %q, expected %s, %s or %s", name, PolicyFallback, PolicyRandom, PolicyFastest)
+	}
+}
+
+// upstreamHealth tracks the recent queries of an upstream.
+type upstreamHealth struct {
+	upstream Upstream
+	name     string
+
+	lock                sync.Mutex
+	latency             time.Duration
+	consecutiveFailures int
+	lastFailure         time.Time
+}
+
+func (h *upstreamHealth) healthy(now time.Time) bool {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.consecutiveFailures < maxConsecutiveFailures || now.Sub(h.lastFailure) >= unhealthyRetryInterval
+}
+
+func (h *upstreamHealth) averageLatency() time.Duration {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.latency
+}
+
+func (h *upstreamHealth) succeeded(latency time.Duration) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.latency == 0 {
+		h.latency = latency
+	} else {
+		h.latency = time.Duration(latencyWeight*float64(latency) + (1-latencyWeight)*float64(h.latency))
+	}
+	h.consecutiveFailures = 0
+	upstreamRequests.WithLabelValues(h.name, "success").Inc()
+	upstreamLatency.WithLabelValues(h.name).Observe(latency.Seconds())
+	upstreamHealthy.WithLabelValues(h.name).Set(1)
+}
+
+func (h *upstreamHealth) failed(now time.Time) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.consecutiveFailures++
+	h.lastFailure = now
+	upstreamRequests.WithLabelValues(h.name, "failure").Inc()
+	if h.consecutiveFailures >= maxConsecutiveFailures {
+		upstreamHealthy.WithLabelValues(h.name).Set(0)
+	}
+}
+
+// upstreamSelector orders the upstreams to try for a query according to a policy.
+type upstreamSelector struct {
+	policy    UpstreamPolicy
+	upstreams []*upstreamHealth
+	shuffle   func(n int, swap func(i, j int))
+}
+
+func newUpstreamSelector(policy UpstreamPolicy, upstreams []Upstream) *upstreamSelector {
+	health := make([]*upstreamHealth, len(upstreams))
+	for i, upstream := range upstreams {
+		name := fmt.Sprintf("upstream%d", i)
+		if stringer, ok := upstream.(fmt.Stringer); ok {
+			name = stringer.String()
+		}
+		health[i] = &upstreamHealth{upstream: upstream, name: name}
+		upstreamHealthy.WithLabelValues(name).Set(1)
+	}
+	return &upstreamSelector{
+		policy:    policy,
+		upstreams: health,
+		shuffle:   rand.Shuffle,
+	}
+}
+
+// order returns the upstreams in the order to try them in: the healthy ones according to the policy, then the
+// unhealthy ones as a last resort.
+func (s *upstreamSelector) order() []*upstreamHealth {
+	now := time.Now()
+	healthy := make([]*upstreamHealth, 0, len(s.upstreams))
+	var unhealthy []*upstreamHealth
+	for _, upstream := range s.upstreams {
+		if upstream.healthy(now) {
+			healthy = append(healthy, upstream)
+		} else {
+			unhealthy = append(unhealthy, upstream)
+		}
+	}
+
+	switch s.policy {
+	case PolicyRandom:
+		s.shuffle(len(healthy), func(i, j int) {
+			healthy[i], healthy[j] = healthy[j], healthy[i]
+		})
+	case PolicyFastest:
+		// Upstreams without any latency yet are tried first, so that they get one
+		latencies := make(map[*upstreamHealth]time.Duration, len(healthy))
+		for _, upstream := range healthy {
+			latencies[upstream] = upstream.averageLatency()
+		}
+		sort.SliceStable(healthy, func(i, j int) bool {
+			return latencies[healthy[i]] < latencies[healthy[j]]
+		})
+	}
+	return append(healthy, unhealthy...)
+}
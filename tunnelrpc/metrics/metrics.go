@@ -38,6 +38,7 @@ const (
 	OperationRegisterConnection       = "register_connection"
 	OperationUnregisterConnection     = "unregister_connection"
 	OperationUpdateLocalConfiguration = "update_local_configuration"
+	OperationHeartbeat                = "heartbeat"
 )
 
 type rpcMetrics struct {
@@ -47,6 +48,7 @@ type rpcMetrics struct {
 
 	ClientOperations        *prometheus.CounterVec
 	ClientFailures          *prometheus.CounterVec
+	ClientRetries           *prometheus.CounterVec
 	ClientOperationsLatency *prometheus.HistogramVec
 }
 
@@ -99,15 +101,25 @@ var CapnpMetrics *rpcMetrics = &rpcMetrics{
 		},
 		[]string{"handler", "method"},
 	),
+	ClientRetries: prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Subsystem: rpcSubsystem,
+			Name:      "client_retries",
+			Help:      "Number of retries of idempotent rpc methods by handler requested",
+		},
+		[]string{"handler", "method"},
+	),
 	ClientOperationsLatency: prometheus.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Namespace: metricsNamespace,
 			Subsystem: rpcSubsystem,
 			Name:      "client_latency_secs",
 			Help:      "Latency of rpc methods by handler requested",
-			// Bucket starts at 50ms, each bucket grows by a factor of 3, up to 5 buckets and is expressed as seconds:
-			// 50ms, 150ms, 450ms, 1350ms, 4050ms
-			Buckets: prometheus.ExponentialBuckets(0.05, 3, 5),
+			// Bucket starts at 50ms, each bucket grows by a factor of 3, up to 6 buckets and is expressed as seconds:
+			// 50ms, 150ms, 450ms, 1350ms, 4050ms, 12150ms
+			// The last bucket covers operations configured with a longer timeout than the default.
+			Buckets: prometheus.ExponentialBuckets(0.05, 3, 6),
 		},
 		[]string{"handler", "method"},
 	),
@@ -139,5 +151,6 @@ func init() {
 	prometheus.MustRegister(CapnpMetrics.serverOperationsLatency)
 	prometheus.MustRegister(CapnpMetrics.ClientOperations)
 	prometheus.MustRegister(CapnpMetrics.ClientFailures)
+	prometheus.MustRegister(CapnpMetrics.ClientRetries)
 	prometheus.MustRegister(CapnpMetrics.ClientOperationsLatency)
 }
@@ -227,6 +227,20 @@ func (c RegistrationServer_PogsClient) UnregisterConnection(ctx context.Context)
 	return nil
 }
 
+// Ping issues a lightweight call over the control stream to verify the edge is still responsive. The edge
+// may not implement the method being called; an unimplemented exception still proves the peer is alive.
+func (c RegistrationServer_PogsClient) Ping(ctx context.Context) error {
+	client := proto.TunnelServer{Client: c.Client}
+	promise := client.GetServerInfo(ctx, func(p proto.TunnelServer_getServerInfo_Params) error {
+		return nil
+	})
+	_, err := promise.Struct()
+	if err != nil && !capnp.IsUnimplemented(err) {
+		return wrapRPCError(err)
+	}
+	return nil
+}
+
 type ClientInfo struct {
 	ClientID []byte `capnp:"clientId"` // must be a slice for capnp compatibility
 	Features []string
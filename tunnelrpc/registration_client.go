@@ -2,6 +2,7 @@ package tunnelrpc
 
 import (
 	"context"
+	"errors"
 	"io"
 	"net"
 	"time"
@@ -24,23 +25,52 @@ type RegistrationClient interface {
 	) (*pogs.ConnectionDetails, error)
 	SendLocalConfiguration(ctx context.Context, config []byte) error
 	GracefulShutdown(ctx context.Context, gracePeriod time.Duration) error
+	// Heartbeat verifies the edge is still responding on the control stream and returns the round trip time.
+	Heartbeat(ctx context.Context) (time.Duration, error)
 	Close()
 }
 
+// DefaultUnregisterTimeout bounds the unregistration RPC when no timeout is set for it. It is short on purpose: an
+// edge that doesn't answer within it won't answer in time for a process that is shutting down, and a failed
+// unregistration only leaves the edge to notice the connection closing.
+const DefaultUnregisterTimeout = 5 * time.Second
+
+// RegistrationTimeouts holds the timeout of each RegistrationClient operation, since they have very different
+// tolerances: registration is on the critical path of a connection while pushing the local configuration is not.
+type RegistrationTimeouts struct {
+	RegisterConnection       time.Duration
+	UpdateLocalConfiguration time.Duration
+	Heartbeat                time.Duration
+	// UnregisterConnection bounds the unregistration RPC. When zero, DefaultUnregisterTimeout is used instead.
+	UnregisterConnection time.Duration
+	// IdempotentRetries is how many more times an idempotent operation is attempted after it times out.
+	IdempotentRetries uint
+}
+
+// NewRegistrationTimeouts returns RegistrationTimeouts that use the same timeout for every operation and do not
+// retry.
+func NewRegistrationTimeouts(timeout time.Duration) RegistrationTimeouts {
+	return RegistrationTimeouts{
+		RegisterConnection:       timeout,
+		UpdateLocalConfiguration: timeout,
+		Heartbeat:                timeout,
+	}
+}
+
 type registrationClient struct {
-	client         pogs.RegistrationServer_PogsClient
-	transport      rpc.Transport
-	requestTimeout time.Duration
+	client    pogs.RegistrationServer_PogsClient
+	transport rpc.Transport
+	timeouts  RegistrationTimeouts
 }
 
-func NewRegistrationClient(ctx context.Context, stream io.ReadWriteCloser, requestTimeout time.Duration) RegistrationClient {
+func NewRegistrationClient(ctx context.Context, stream io.ReadWriteCloser, timeouts RegistrationTimeouts) RegistrationClient {
 	transport := SafeTransport(stream)
 	conn := NewClientConn(transport)
 	client := pogs.NewRegistrationServer_PogsClient(conn.Bootstrap(ctx), conn)
 	return &registrationClient{
-		client:         client,
-		transport:      transport,
-		requestTimeout: requestTimeout,
+		client:    client,
+		transport: transport,
+		timeouts:  timeouts,
 	}
 }
 
@@ -52,7 +82,7 @@ func (r *registrationClient) RegisterConnection(
 	connIndex uint8,
 	edgeAddress net.IP,
 ) (*pogs.ConnectionDetails, error) {
-	ctx, cancel := context.WithTimeout(ctx, r.requestTimeout)
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.RegisterConnection)
 	defer cancel()
 	defer metrics.CapnpMetrics.ClientOperations.WithLabelValues(metrics.Registration, metrics.OperationRegisterConnection).Inc()
 	timer := metrics.NewClientOperationLatencyObserver(metrics.Registration, metrics.OperationRegisterConnection)
@@ -66,7 +96,14 @@ func (r *registrationClient) RegisterConnection(
 }
 
 func (r *registrationClient) SendLocalConfiguration(ctx context.Context, config []byte) error {
-	ctx, cancel := context.WithTimeout(ctx, r.requestTimeout)
+	// Pushing the same configuration more than once is harmless, so it is retried on timeouts.
+	return r.retryIdempotent(ctx, metrics.OperationUpdateLocalConfiguration, func() error {
+		return r.sendLocalConfiguration(ctx, config)
+	})
+}
+
+func (r *registrationClient) sendLocalConfiguration(ctx context.Context, config []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.UpdateLocalConfiguration)
 	defer cancel()
 	defer metrics.CapnpMetrics.ClientOperations.WithLabelValues(metrics.Registration, metrics.OperationUpdateLocalConfiguration).Inc()
 	timer := metrics.NewClientOperationLatencyObserver(metrics.Registration, metrics.OperationUpdateLocalConfiguration)
@@ -79,8 +116,34 @@ func (r *registrationClient) SendLocalConfiguration(ctx context.Context, config
 	return err
 }
 
+// retryIdempotent calls op until it succeeds, fails with something other than a timeout or runs out of retries.
+func (r *registrationClient) retryIdempotent(ctx context.Context, method string, op func() error) error {
+	err := op()
+	for attempt := uint(0); attempt < r.timeouts.IdempotentRetries; attempt++ {
+		if err == nil || ctx.Err() != nil || !errors.Is(err, context.DeadlineExceeded) {
+			return err
+		}
+		metrics.CapnpMetrics.ClientRetries.WithLabelValues(metrics.Registration, method).Inc()
+		err = op()
+	}
+	return err
+}
+
+// UnregisterTimeout returns how long to wait for the edge to unregister a connection, never longer than a positive
+// gracePeriod.
+func (t RegistrationTimeouts) UnregisterTimeout(gracePeriod time.Duration) time.Duration {
+	timeout := DefaultUnregisterTimeout
+	if t.UnregisterConnection > 0 {
+		timeout = t.UnregisterConnection
+	}
+	if gracePeriod > 0 {
+		timeout = min(timeout, gracePeriod)
+	}
+	return timeout
+}
+
 func (r *registrationClient) GracefulShutdown(ctx context.Context, gracePeriod time.Duration) error {
-	ctx, cancel := context.WithTimeout(ctx, gracePeriod)
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.UnregisterTimeout(gracePeriod))
 	defer cancel()
 	defer metrics.CapnpMetrics.ClientOperations.WithLabelValues(metrics.Registration, metrics.OperationUnregisterConnection).Inc()
 	timer := metrics.NewClientOperationLatencyObserver(metrics.Registration, metrics.OperationUnregisterConnection)
@@ -93,6 +156,21 @@ func (r *registrationClient) GracefulShutdown(ctx context.Context, gracePeriod t
 	return nil
 }
 
+func (r *registrationClient) Heartbeat(ctx context.Context) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Heartbeat)
+	defer cancel()
+	defer metrics.CapnpMetrics.ClientOperations.WithLabelValues(metrics.Registration, metrics.OperationHeartbeat).Inc()
+	timer := metrics.NewClientOperationLatencyObserver(metrics.Registration, metrics.OperationHeartbeat)
+	defer timer.ObserveDuration()
+
+	start := time.Now()
+	if err := r.client.Ping(ctx); err != nil {
+		metrics.CapnpMetrics.ClientFailures.WithLabelValues(metrics.Registration, metrics.OperationHeartbeat).Inc()
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
 func (r *registrationClient) Close() {
 	// Closing the client will also close the connection
 	_ = r.client.Close()
@@ -0,0 +1,88 @@
+package tunnelrpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewRegistrationTimeouts(t *testing.T) {
+	timeouts := NewRegistrationTimeouts(3 * time.Second)
+	assert.Equal(t, 3*time.Second, timeouts.RegisterConnection)
+	assert.Equal(t, 3*time.Second, timeouts.UpdateLocalConfiguration)
+	assert.Equal(t, 3*time.Second, timeouts.Heartbeat)
+	assert.Zero(t, timeouts.UnregisterConnection)
+	assert.Zero(t, timeouts.IdempotentRetries)
+}
+
+func TestUnregisterTimeout(t *testing.T) {
+	timeouts := NewRegistrationTimeouts(3 * time.Second)
+	assert.Equal(t, DefaultUnregisterTimeout, timeouts.UnregisterTimeout(30*time.Second))
+	assert.Equal(t, DefaultUnregisterTimeout, timeouts.UnregisterTimeout(0))
+	// Unregistration never outlasts the grace period
+	assert.Equal(t, time.Second, timeouts.UnregisterTimeout(time.Second))
+	timeouts.UnregisterConnection = 10 * time.Second
+	assert.Equal(t, 10*time.Second, timeouts.UnregisterTimeout(30*time.Second))
+	assert.Equal(t, 10*time.Second, timeouts.UnregisterTimeout(0))
+}
+
+func TestRetryIdempotent(t *testing.T) {
+	errOther := errors.New("other")
+	tests := []struct {
+		name             string
+		retries          uint
+		errs             []error
+		expectedAttempts int
+		expectedErr      error
+	}{
+		{
+			name:             "success",
+			retries:          2,
+			errs:             []error{nil},
+			expectedAttempts: 1,
+		},
+		{
+			name:             "timeout then success",
+			retries:          2,
+			errs:             []error{context.DeadlineExceeded, nil},
+			expectedAttempts: 2,
+		},
+		{
+			name:             "retries exhausted",
+			retries:          2,
+			errs:             []error{context.DeadlineExceeded, context.DeadlineExceeded, context.DeadlineExceeded},
+			expectedAttempts: 3,
+			expectedErr:      context.DeadlineExceeded,
+		},
+		{
+			name:             "no retries",
+			retries:          0,
+			errs:             []error{context.DeadlineExceeded},
+			expectedAttempts: 1,
+			expectedErr:      context.DeadlineExceeded,
+		},
+		{
+			name:             "other errors are not retried",
+			retries:          2,
+			errs:             []error{errOther},
+			expectedAttempts: 1,
+			expectedErr:      errOther,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := &registrationClient{timeouts: RegistrationTimeouts{IdempotentRetries: test.retries}}
+			attempts := 0
+			err := client.retryIdempotent(t.Context(), "test", func() error {
+				err := test.errs[attempts]
+				attempts++
+				return err
+			})
+			assert.Equal(t, test.expectedAttempts, attempts)
+			assert.ErrorIs(t, err, test.expectedErr)
+		})
+	}
+}
@@ -2,24 +2,39 @@ package tunnelstate
 
 import (
 	"net"
+	"slices"
 	"sync"
+	"time"
 
 	"github.com/rs/zerolog"
 
+	"github.com/cloudflare/cloudflared/client"
 	"github.com/cloudflare/cloudflared/connection"
+	"github.com/cloudflare/cloudflared/management"
 )
 
+// connectionHistorySize is how many events are kept per connection index, the oldest are dropped first.
+const connectionHistorySize = 32
+
 type ConnTracker struct {
 	mutex sync.RWMutex
 	// int is the connection Index
 	connectionInfo map[uint8]ConnectionInfo
-	log            *zerolog.Logger
+	// history holds the latest events of each connection index, oldest first
+	history map[uint8][]ConnectionEvent
+	// now is the clock the events are timestamped with, replaced in tests
+	now func() time.Time
+	log *zerolog.Logger
 }
 
 type ConnectionInfo struct {
 	IsConnected bool                `json:"isConnected,omitempty"`
 	Protocol    connection.Protocol `json:"protocol,omitempty"`
 	EdgeAddress net.IP              `json:"edgeAddress,omitempty"`
+	// Location is the Cloudflare data center (colo) the connection registered with
+	Location string `json:"location,omitempty"`
+	// ConnectionOptions are the options sent to the edge when the connection registered
+	ConnectionOptions *client.ConnectionOptionsInfo `json:"connectionOptions,omitempty"`
 }
 
 // Convinience struct to extend the connection with its index.
@@ -28,11 +43,29 @@ type IndexedConnectionInfo struct {
 	Index uint8 `json:"index,omitempty"`
 }
 
+// ConnectionEvent is something that happened to a connection, with the edge IP and protocol it had at the time, so
+// that flapping patterns such as a connection always dying on the same colo over QUIC can be told apart.
+type ConnectionEvent struct {
+	Time        time.Time           `json:"time"`
+	Event       string              `json:"event"`
+	Protocol    connection.Protocol `json:"protocol,omitempty"`
+	EdgeAddress net.IP              `json:"edgeAddress,omitempty"`
+	Location    string              `json:"location,omitempty"`
+}
+
+// ConnectionHistory is the latest events of a connection index, oldest first.
+type ConnectionHistory struct {
+	Index  uint8             `json:"index"`
+	Events []ConnectionEvent `json:"events"`
+}
+
 func NewConnTracker(
 	log *zerolog.Logger,
 ) *ConnTracker {
 	return &ConnTracker{
 		connectionInfo: make(map[uint8]ConnectionInfo, 0),
+		history:        make(map[uint8][]ConnectionEvent),
+		now:            time.Now,
 		log:            log,
 	}
 }
@@ -45,20 +78,65 @@ func (ct *ConnTracker) OnTunnelEvent(c connection.Event) {
 			IsConnected: true,
 			Protocol:    c.Protocol,
 			EdgeAddress: c.EdgeAddress,
+			Location:    c.Location,
+		}
+		if c.ConnectionOptions != nil {
+			options := c.ConnectionOptions.Info()
+			ci.ConnectionOptions = &options
 		}
 		ct.connectionInfo[c.Index] = ci
+		ct.recordLocked(c.Index, "connected", ci)
 		ct.mutex.Unlock()
 	case connection.Disconnected, connection.Reconnecting, connection.RegisteringTunnel, connection.Unregistering:
 		ct.mutex.Lock()
 		ci := ct.connectionInfo[c.Index]
 		ci.IsConnected = false
 		ct.connectionInfo[c.Index] = ci
+		ct.recordLocked(c.Index, historyEventNames[c.EventType], ci)
 		ct.mutex.Unlock()
 	default:
 		ct.log.Error().Msgf("Unknown connection event case %v", c)
 	}
 }
 
+// historyEventNames name the events of the connection history.
+var historyEventNames = map[connection.Status]string{
+	connection.Disconnected:      "disconnected",
+	connection.Reconnecting:      "reconnecting",
+	connection.RegisteringTunnel: "registering",
+	connection.Unregistering:     "unregistering",
+}
+
+// recordLocked appends event to the history of the connection index, with the edge IP and protocol it last
+// connected with since the events following a connection don't carry them.
+func (ct *ConnTracker) recordLocked(index uint8, event string, ci ConnectionInfo) {
+	history := append(ct.history[index], ConnectionEvent{
+		Time:        ct.now(),
+		Event:       event,
+		Protocol:    ci.Protocol,
+		EdgeAddress: ci.EdgeAddress,
+		Location:    ci.Location,
+	})
+	if len(history) > connectionHistorySize {
+		history = slices.Clone(history[len(history)-connectionHistorySize:])
+	}
+	ct.history[index] = history
+}
+
+// History returns the latest events of each connection index, ordered by index.
+func (ct *ConnTracker) History() []ConnectionHistory {
+	ct.mutex.RLock()
+	defer ct.mutex.RUnlock()
+	histories := make([]ConnectionHistory, 0, len(ct.history))
+	for index, events := range ct.history {
+		histories = append(histories, ConnectionHistory{Index: index, Events: slices.Clone(events)})
+	}
+	slices.SortFunc(histories, func(a, b ConnectionHistory) int {
+		return int(a.Index) - int(b.Index)
+	})
+	return histories
+}
+
 func (ct *ConnTracker) CountActiveConns() uint {
 	ct.mutex.RLock()
 	defer ct.mutex.RUnlock()
@@ -71,6 +149,19 @@ func (ct *ConnTracker) CountActiveConns() uint {
 	return active
 }
 
+// CountActiveConnsWithProtocol counts the connections to the edge currently connected with said protocol.
+func (ct *ConnTracker) CountActiveConnsWithProtocol(protocol connection.Protocol) uint {
+	ct.mutex.RLock()
+	defer ct.mutex.RUnlock()
+	active := uint(0)
+	for _, ci := range ct.connectionInfo {
+		if ci.IsConnected && ci.Protocol == protocol {
+			active++
+		}
+	}
+	return active
+}
+
 // HasConnectedWith checks if we've ever had a successful connection to the edge
 // with said protocol.
 func (ct *ConnTracker) HasConnectedWith(protocol connection.Protocol) bool {
@@ -101,3 +192,26 @@ func (ct *ConnTracker) GetActiveConnections() []IndexedConnectionInfo {
 
 	return connections
 }
+
+// GetConnectionFeatures returns the features each active connection registered with, ordered by connection index.
+func (ct *ConnTracker) GetConnectionFeatures() []management.ConnectionFeatures {
+	ct.mutex.RLock()
+	defer ct.mutex.RUnlock()
+
+	connections := make([]management.ConnectionFeatures, 0)
+	for index, ci := range ct.connectionInfo {
+		if !ci.IsConnected || ci.ConnectionOptions == nil {
+			continue
+		}
+		connections = append(connections, management.ConnectionFeatures{
+			Index:           index,
+			Features:        ci.ConnectionOptions.Features,
+			DatagramVersion: string(ci.ConnectionOptions.DatagramVersion),
+			PostQuantum:     ci.ConnectionOptions.PostQuantum,
+		})
+	}
+	slices.SortFunc(connections, func(a, b management.ConnectionFeatures) int {
+		return int(a.Index) - int(b.Index)
+	})
+	return connections
+}
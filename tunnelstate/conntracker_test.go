@@ -0,0 +1,53 @@
+package tunnelstate
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+func TestConnTrackerHistory(t *testing.T) {
+	log := zerolog.Nop()
+	tracker := NewConnTracker(&log)
+	now := time.Unix(0, 0)
+	tracker.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	edgeIP := net.IPv4(198, 41, 200, 1)
+
+	tracker.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.Connected, Protocol: connection.QUIC, EdgeAddress: edgeIP, Location: "lhr01"})
+	tracker.OnTunnelEvent(connection.Event{Index: 1, EventType: connection.Disconnected})
+	tracker.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Connected, Protocol: connection.HTTP2, EdgeAddress: edgeIP})
+
+	history := tracker.History()
+	require.Len(t, history, 2)
+	assert.Equal(t, uint8(0), history[0].Index)
+	assert.Equal(t, uint8(1), history[1].Index)
+	// The disconnection is recorded with the edge IP and protocol the connection had
+	assert.Equal(t, []ConnectionEvent{
+		{Time: time.Unix(1, 0), Event: "connected", Protocol: connection.QUIC, EdgeAddress: edgeIP, Location: "lhr01"},
+		{Time: time.Unix(2, 0), Event: "disconnected", Protocol: connection.QUIC, EdgeAddress: edgeIP, Location: "lhr01"},
+	}, history[1].Events)
+}
+
+func TestConnTrackerHistoryIsBounded(t *testing.T) {
+	log := zerolog.Nop()
+	tracker := NewConnTracker(&log)
+	for i := 0; i < connectionHistorySize+5; i++ {
+		tracker.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Connected, Location: "colo"})
+		tracker.OnTunnelEvent(connection.Event{Index: 0, EventType: connection.Reconnecting})
+	}
+
+	history := tracker.History()
+	require.Len(t, history, 1)
+	require.Len(t, history[0].Events, connectionHistorySize)
+	// The oldest events are dropped first
+	assert.Equal(t, "reconnecting", history[0].Events[connectionHistorySize-1].Event)
+}
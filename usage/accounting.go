@@ -0,0 +1,265 @@
+// Package usage accounts the bytes proxied per ingress hostname and per private network, and persists the totals
+// across restarts, so that data usage on metered links can be attributed to the services behind the tunnel.
+package usage
+
+import (
+	"context"
+	"encoding/json"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog"
+
+	"github.com/cloudflare/cloudflared/connection"
+)
+
+// DefaultSaveInterval is how often the totals are saved by default.
+const DefaultSaveInterval = time.Minute
+
+const (
+	kindHostname = "hostname"
+	kindNetwork  = "network"
+	// catchAllHostname names the ingress rules without a hostname
+	catchAllHostname = "*"
+)
+
+var bytesTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Namespace: connection.MetricsNamespace,
+		Subsystem: "usage",
+		Name:      "bytes_total",
+		Help:      "Bytes proxied since usage accounting started, including previous runs, by ingress hostname or private network and direction",
+	},
+	[]string{"kind", "name", "direction"},
+)
+
+func init() {
+	prometheus.MustRegister(bytesTotal)
+}
+
+// Totals are the bytes proxied to and from the origins of a hostname or network.
+type Totals struct {
+	ToOrigin   uint64 `json:"toOrigin"`
+	FromOrigin uint64 `json:"fromOrigin"`
+}
+
+// Report is a snapshot of the totals of every hostname and network that proxied traffic.
+type Report struct {
+	Hostnames map[string]Totals `json:"hostnames,omitempty"`
+	Networks  map[string]Totals `json:"networks,omitempty"`
+}
+
+// Traffic counts the bytes proxied for a hostname or network. A nil Traffic counts nothing.
+type Traffic struct {
+	toOrigin, fromOrigin             atomic.Uint64
+	toOriginMetric, fromOriginMetric prometheus.Counter
+}
+
+// AddToOrigin counts n bytes sent to the origin.
+func (t *Traffic) AddToOrigin(n int) {
+	if t == nil || n <= 0 {
+		return
+	}
+	t.toOrigin.Add(uint64(n))
+	t.toOriginMetric.Add(float64(n))
+}
+
+// AddFromOrigin counts n bytes received from the origin.
+func (t *Traffic) AddFromOrigin(n int) {
+	if t == nil || n <= 0 {
+		return
+	}
+	t.fromOrigin.Add(uint64(n))
+	t.fromOriginMetric.Add(float64(n))
+}
+
+func (t *Traffic) totals() Totals {
+	return Totals{ToOrigin: t.toOrigin.Load(), FromOrigin: t.fromOrigin.Load()}
+}
+
+// Accounting keeps the Traffic of every ingress hostname, and of every configured private network that TCP
+// connections, UDP sessions and ICMP messages to private IPs are attributed to. If it has a path, the totals are saved there periodically and
+// loaded back on start. A nil Accounting counts nothing.
+type Accounting struct {
+	path string
+	// networks are sorted from the most specific, so that an IP is attributed to the smallest network containing it
+	networks []netip.Prefix
+
+	lock      sync.Mutex
+	hostnames map[string]*Traffic
+	traffic   map[netip.Prefix]*Traffic
+}
+
+// New creates an Accounting attributing private network traffic to networks, loading the totals saved at path if
+// it isn't empty. On error the Accounting starts from zero and is returned along with the error, so that callers
+// can still use it.
+func New(path string, networks []netip.Prefix) (*Accounting, error) {
+	a := &Accounting{
+		path:      path,
+		networks:  make([]netip.Prefix, 0, len(networks)),
+		hostnames: make(map[string]*Traffic),
+		traffic:   make(map[netip.Prefix]*Traffic),
+	}
+	for _, network := range networks {
+		a.networks = append(a.networks, network.Masked())
+	}
+	sort.SliceStable(a.networks, func(i, j int) bool {
+		return a.networks[i].Bits() > a.networks[j].Bits()
+	})
+	if path == "" {
+		return a, nil
+	}
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return a, nil
+	}
+	if err != nil {
+		return a, errors.Wrapf(err, "failed to read usage file %s", path)
+	}
+	var saved Report
+	if err := json.Unmarshal(content, &saved); err != nil {
+		return a, errors.Wrapf(err, "failed to parse usage file %s", path)
+	}
+	for hostname, totals := range saved.Hostnames {
+		a.Hostname(hostname).load(totals)
+	}
+	for network, totals := range saved.Networks {
+		// Networks no longer configured keep their totals in the file, but aren't counted anymore
+		prefix, err := netip.ParsePrefix(network)
+		if err != nil {
+			continue
+		}
+		prefix = prefix.Masked()
+		a.traffic[prefix] = newTraffic(kindNetwork, prefix.String())
+		a.traffic[prefix].load(totals)
+	}
+	return a, nil
+}
+
+func newTraffic(kind, name string) *Traffic {
+	return &Traffic{
+		toOriginMetric:   bytesTotal.WithLabelValues(kind, name, "to_origin"),
+		fromOriginMetric: bytesTotal.WithLabelValues(kind, name, "from_origin"),
+	}
+}
+
+func (t *Traffic) load(totals Totals) {
+	t.toOrigin.Add(totals.ToOrigin)
+	t.toOriginMetric.Add(float64(totals.ToOrigin))
+	t.fromOrigin.Add(totals.FromOrigin)
+	t.fromOriginMetric.Add(float64(totals.FromOrigin))
+}
+
+// Hostname returns the Traffic of the ingress rule hostname, an empty hostname is the catch-all rule.
+func (a *Accounting) Hostname(hostname string) *Traffic {
+	if a == nil {
+		return nil
+	}
+	if hostname == "" {
+		hostname = catchAllHostname
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	t, ok := a.hostnames[hostname]
+	if !ok {
+		t = newTraffic(kindHostname, hostname)
+		a.hostnames[hostname] = t
+	}
+	return t
+}
+
+// Network returns the Traffic of the smallest configured network containing addr, or nil if none does.
+func (a *Accounting) Network(addr netip.Addr) *Traffic {
+	if a == nil {
+		return nil
+	}
+	addr = addr.Unmap()
+	for _, network := range a.networks {
+		if !network.Contains(addr) {
+			continue
+		}
+		a.lock.Lock()
+		defer a.lock.Unlock()
+		t, ok := a.traffic[network]
+		if !ok {
+			t = newTraffic(kindNetwork, network.String())
+			a.traffic[network] = t
+		}
+		return t
+	}
+	return nil
+}
+
+// Report returns the current totals.
+func (a *Accounting) Report() *Report {
+	if a == nil {
+		return nil
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	report := &Report{
+		Hostnames: make(map[string]Totals, len(a.hostnames)),
+		Networks:  make(map[string]Totals, len(a.traffic)),
+	}
+	for hostname, t := range a.hostnames {
+		report.Hostnames[hostname] = t.totals()
+	}
+	for network, t := range a.traffic {
+		report.Networks[network.String()] = t.totals()
+	}
+	return report
+}
+
+// Run saves the totals every interval until ctx is done, and a last time then.
+func (a *Accounting) Run(ctx context.Context, interval time.Duration, log *zerolog.Logger) {
+	if a == nil || a.path == "" {
+		return
+	}
+	if interval <= 0 {
+		interval = DefaultSaveInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if err := a.Save(); err != nil {
+				log.Err(err).Msg("Failed to save bandwidth usage")
+			}
+			return
+		case <-ticker.C:
+			if err := a.Save(); err != nil {
+				log.Err(err).Msg("Failed to save bandwidth usage")
+			}
+		}
+	}
+}
+
+// Save writes the totals to a temporary file and renames it over the usage file, so that a crash never leaves a
+// truncated file behind.
+func (a *Accounting) Save() error {
+	content, err := json.Marshal(a.Report())
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(a.path), filepath.Base(a.path)+".tmp")
+	if err != nil {
+		return errors.Wrapf(err, "failed to write usage file %s", a.path)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		_ = tmp.Close()
+		return errors.Wrapf(err, "failed to write usage file %s", a.path)
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrapf(err, "failed to write usage file %s", a.path)
+	}
+	return errors.Wrapf(os.Rename(tmp.Name(), a.path), "failed to write usage file %s", a.path)
+}
@@ -0,0 +1,76 @@
+package usage
+
+import (
+	"context"
+	"net/netip"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAccountingNetworks(t *testing.T) {
+	a, err := New("", []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("10.1.2.3/16"),
+	})
+	require.NoError(t, err)
+
+	// The smallest network containing the IP is used
+	a.Network(netip.MustParseAddr("10.1.0.1")).AddToOrigin(10)
+	a.Network(netip.MustParseAddr("::ffff:10.1.0.2")).AddFromOrigin(20)
+	a.Network(netip.MustParseAddr("10.2.0.1")).AddToOrigin(30)
+	assert.Nil(t, a.Network(netip.MustParseAddr("192.168.0.1")))
+	a.Network(netip.MustParseAddr("192.168.0.1")).AddToOrigin(40)
+
+	assert.Equal(t, map[string]Totals{
+		"10.1.0.0/16": {ToOrigin: 10, FromOrigin: 20},
+		"10.0.0.0/8":  {ToOrigin: 30},
+	}, a.Report().Networks)
+}
+
+func TestAccountingPersisted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "usage.json")
+	networks := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	a, err := New(path, networks)
+	require.NoError(t, err)
+	a.Hostname("app.example.com").AddToOrigin(100)
+	a.Hostname("").AddFromOrigin(200)
+	a.Network(netip.MustParseAddr("10.0.0.1")).AddFromOrigin(300)
+
+	// The totals are saved when the accounting stops
+	ctx, cancel := context.WithCancel(t.Context())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		log := zerolog.Nop()
+		a.Run(ctx, time.Hour, &log)
+	}()
+	cancel()
+	<-done
+
+	restarted, err := New(path, nil)
+	require.NoError(t, err)
+	restarted.Hostname("app.example.com").AddFromOrigin(1)
+	assert.Equal(t, &Report{
+		Hostnames: map[string]Totals{
+			"app.example.com": {ToOrigin: 100, FromOrigin: 1},
+			"*":               {FromOrigin: 200},
+		},
+		Networks: map[string]Totals{
+			"10.0.0.0/8": {FromOrigin: 300},
+		},
+	}, restarted.Report())
+	// Networks that are no longer configured aren't counted anymore
+	assert.Nil(t, restarted.Network(netip.MustParseAddr("10.0.0.1")))
+}
+
+func TestAccountingDisabled(t *testing.T) {
+	var a *Accounting
+	a.Hostname("app.example.com").AddToOrigin(1)
+	a.Network(netip.MustParseAddr("10.0.0.1")).AddFromOrigin(1)
+	assert.Nil(t, a.Report())
+}
@@ -0,0 +1,98 @@
+package usage
+
+import (
+	"context"
+	"net"
+	"net/netip"
+
+	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/packet"
+)
+
+// icmpHeaderLen is the length of the type, code and checksum of ICMP messages, which precede their body
+const icmpHeaderLen = 4
+
+// UDPDialer wraps dialer to count the bytes of the UDP sessions to private networks, in both datagram versions.
+func (a *Accounting) UDPDialer(dialer ingress.OriginUDPDialer) ingress.OriginUDPDialer {
+	if a == nil || len(a.networks) == 0 {
+		return dialer
+	}
+	return &countingUDPDialer{OriginUDPDialer: dialer, accounting: a}
+}
+
+type countingUDPDialer struct {
+	ingress.OriginUDPDialer
+	accounting *Accounting
+}
+
+func (d *countingUDPDialer) DialUDP(addr netip.AddrPort) (net.Conn, error) {
+	conn, err := d.OriginUDPDialer.DialUDP(addr)
+	if err != nil {
+		return nil, err
+	}
+	traffic := d.accounting.Network(addr.Addr())
+	if traffic == nil {
+		return conn, nil
+	}
+	return &countingConn{Conn: conn, traffic: traffic}, nil
+}
+
+// countingConn counts the payloads written to the origin and read from it.
+type countingConn struct {
+	net.Conn
+	traffic *Traffic
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.traffic.AddFromOrigin(n)
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.traffic.AddToOrigin(n)
+	return n, err
+}
+
+// ICMPRouter wraps router to count the ICMP messages to private networks and their replies. Returns nil if router
+// is nil, as connections don't proxy ICMP without a router.
+func (a *Accounting) ICMPRouter(router ingress.ICMPRouter) ingress.ICMPRouter {
+	if a == nil || len(a.networks) == 0 || router == nil {
+		return router
+	}
+	return &countingICMPRouter{ICMPRouter: router, accounting: a}
+}
+
+type countingICMPRouter struct {
+	ingress.ICMPRouter
+	accounting *Accounting
+}
+
+func (r *countingICMPRouter) Request(ctx context.Context, pk *packet.ICMP, responder ingress.ICMPResponder) error {
+	traffic := r.accounting.Network(pk.Dst)
+	if traffic == nil {
+		return r.ICMPRouter.Request(ctx, pk, responder)
+	}
+	traffic.AddToOrigin(icmpLen(pk))
+	return r.ICMPRouter.Request(ctx, pk, &countingICMPResponder{ICMPResponder: responder, traffic: traffic})
+}
+
+// countingICMPResponder counts the replies of the origin.
+type countingICMPResponder struct {
+	ingress.ICMPResponder
+	traffic *Traffic
+}
+
+func (r *countingICMPResponder) ReturnPacket(pk *packet.ICMP) error {
+	r.traffic.AddFromOrigin(icmpLen(pk))
+	return r.ICMPResponder.ReturnPacket(pk)
+}
+
+// icmpLen returns the length of the ICMP message of pk, without the IP header, as UDP sessions count their payloads.
+func icmpLen(pk *packet.ICMP) int {
+	if pk.Message == nil || pk.Body == nil {
+		return icmpHeaderLen
+	}
+	return icmpHeaderLen + pk.Body.Len(pk.Type.Protocol())
+}
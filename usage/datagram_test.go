@@ -0,0 +1,103 @@
+package usage
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+
+	"github.com/cloudflare/cloudflared/ingress"
+	"github.com/cloudflare/cloudflared/packet"
+)
+
+func TestAccountingUDPSessions(t *testing.T) {
+	a, err := New("", []netip.Prefix{netip.MustParsePrefix("10.2.0.0/16")})
+	require.NoError(t, err)
+	dialer := a.UDPDialer(&pipeDialer{})
+
+	conn, err := dialer.DialUDP(netip.MustParseAddrPort("10.2.0.1:53"))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("query"))
+	require.NoError(t, err)
+	_, err = conn.Read(make([]byte, 100))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	// Sessions outside the networks aren't counted
+	conn, err = dialer.DialUDP(netip.MustParseAddrPort("192.168.0.1:53"))
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("query"))
+	require.NoError(t, err)
+	require.NoError(t, conn.Close())
+
+	assert.Equal(t, map[string]Totals{
+		"10.2.0.0/16": {ToOrigin: 5, FromOrigin: 8},
+	}, a.Report().Networks)
+}
+
+func TestAccountingICMP(t *testing.T) {
+	a, err := New("", []netip.Prefix{netip.MustParsePrefix("10.3.0.0/16")})
+	require.NoError(t, err)
+	router := a.ICMPRouter(&echoICMPRouter{})
+	assert.Nil(t, a.ICMPRouter(nil))
+
+	echo := &packet.ICMP{
+		IP: &packet.IP{
+			Src:      netip.MustParseAddr("172.16.0.1"),
+			Dst:      netip.MustParseAddr("10.3.0.1"),
+			Protocol: 1,
+		},
+		Message: &icmp.Message{
+			Type: ipv4.ICMPTypeEcho,
+			Body: &icmp.Echo{ID: 1, Seq: 1, Data: []byte("ping")},
+		},
+	}
+	require.NoError(t, router.Request(context.Background(), echo, &stubICMPResponder{}))
+
+	// The echo header is 4 bytes long, and is followed by the data
+	assert.Equal(t, map[string]Totals{
+		"10.3.0.0/16": {ToOrigin: 12, FromOrigin: 12},
+	}, a.Report().Networks)
+}
+
+// pipeDialer dials an origin that replies "response" to every datagram.
+type pipeDialer struct{}
+
+func (*pipeDialer) DialUDP(netip.AddrPort) (net.Conn, error) {
+	conn, origin := net.Pipe()
+	go func() {
+		defer origin.Close()
+		buf := make([]byte, 100)
+		for {
+			if _, err := origin.Read(buf); err != nil {
+				return
+			}
+			if _, err := origin.Write([]byte("response")); err != nil {
+				return
+			}
+		}
+	}()
+	return conn, nil
+}
+
+// echoICMPRouter returns every request to its responder, as if the origin echoed it.
+type echoICMPRouter struct {
+	ingress.ICMPRouter
+}
+
+func (*echoICMPRouter) Request(_ context.Context, pk *packet.ICMP, responder ingress.ICMPResponder) error {
+	return responder.ReturnPacket(pk)
+}
+
+type stubICMPResponder struct {
+	ingress.ICMPResponder
+}
+
+func (*stubICMPResponder) ReturnPacket(*packet.ICMP) error {
+	return nil
+}
@@ -22,7 +22,7 @@ const (
 )
 
 var (
-	supportedProtocols = []string{"http", "https", "rdp", "ssh", "smb", "tcp"}
+	supportedProtocols = []string{"http", "https", "rdp", "ssh", "smb", "vnc", "tcp", "udp"}
 	validationTimeout  = time.Duration(30 * time.Second)
 )
 